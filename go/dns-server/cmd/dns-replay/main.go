@@ -0,0 +1,170 @@
+// DNS Replay - Replays captured queries against a DNS server and reports
+// latency percentiles and the response code distribution
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bellistech/dns-server/dns"
+)
+
+func main() {
+	server := flag.String("server", "127.0.0.1:5353", "DNS server to send queries to")
+	qps := flag.Float64("qps", 100, "Queries per second to send (0 for as fast as the workers allow)")
+	workers := flag.Int("c", 10, "Concurrent queries, each worker with its own socket")
+	duration := flag.Duration("duration", 0, "Keep replaying the input in a loop for this long (0 to replay it once)")
+	timeout := flag.Duration("timeout", 2*time.Second, "How long to wait for each response")
+	useTCP := flag.Bool("tcp", false, "Send queries over TCP instead of UDP")
+	port := flag.Int("port", 53, "Destination port of the queries to take from a pcap")
+	interval := flag.Duration("report", 10*time.Second, "How often to print progress (0 to disable)")
+	flag.Parse()
+
+	if flag.NArg() != 1 || *workers < 1 || *qps < 0 {
+		fmt.Fprintln(os.Stderr, "Usage: dns-replay [-server <addr>] [-qps <n>] [-c <n>] [-duration <d>] [-tcp] <querylog|capture.pcap>")
+		fmt.Fprintln(os.Stderr, "\nThe input is a dns-server log, a file of \"name [type]\" lines, or a pcap capture.")
+		fmt.Fprintln(os.Stderr, "\nExample:")
+		fmt.Fprintln(os.Stderr, "  dns-replay -server 127.0.0.1:5353 -qps 500 queries.log")
+		fmt.Fprintln(os.Stderr, "  dns-replay -server 192.0.2.53 -qps 2000 -c 50 -duration 1h capture.pcap")
+		os.Exit(1)
+	}
+
+	queries, err := readQueries(flag.Arg(0), *port)
+	if err != nil {
+		log.Fatalf("Failed to read queries: %v", err)
+	}
+	if len(queries) == 0 {
+		log.Fatalf("No queries found in %s", flag.Arg(0))
+	}
+
+	network := "udp"
+	if *useTCP {
+		network = "tcp"
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if *duration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	// Stop early on Ctrl-C, still printing the report
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		cancel()
+	}()
+
+	log.Printf("Replaying %d queries against %s over %s (%d workers, %s)",
+		len(queries), *server, network, *workers, rateString(*qps))
+
+	st := newStats()
+	jobs := make(chan query)
+
+	var wg sync.WaitGroup
+	for i := 0; i < *workers; i++ {
+		conn, err := dns.Dial(ctx, network, *server)
+		if err != nil {
+			log.Fatalf("Failed to connect to %s: %v", *server, err)
+		}
+		conn.Timeout = *timeout
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+			replay(ctx, conn, jobs, st)
+		}()
+	}
+
+	if *interval > 0 {
+		go func() {
+			ticker := time.NewTicker(*interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					log.Print(st.progress())
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	dispatch(ctx, queries, *qps, *duration > 0, jobs)
+	close(jobs)
+	wg.Wait()
+
+	st.report(os.Stdout)
+}
+
+// dispatch hands queries to the workers at the given rate, once through the
+// list or round and round until ctx is done. When all workers are busy it
+// waits for one rather than queueing, so a slow server shows up as a lower
+// achieved rate instead of ever-growing latency
+func dispatch(ctx context.Context, queries []query, qps float64, loop bool, jobs chan<- query) {
+	var gap time.Duration
+	if qps > 0 {
+		gap = time.Duration(float64(time.Second) / qps)
+	}
+
+	start := time.Now()
+	for n := 0; loop || n < len(queries); n++ {
+		if gap > 0 {
+			// Schedule from the start rather than the previous query, so
+			// timer slack doesn't add up
+			if wait := time.Until(start.Add(time.Duration(n) * gap)); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+
+		select {
+		case jobs <- queries[n%len(queries)]:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// replay sends each query it's handed and records the result
+func replay(ctx context.Context, conn *dns.Conn, jobs <-chan query, st *stats) {
+	for q := range jobs {
+		sent := time.Now()
+		msg, err := conn.Exchange(ctx, q.Name, q.Type)
+		rtt := time.Since(sent)
+
+		switch {
+		case err == nil:
+			st.answered(msg.Rcode(), rtt)
+		case ctx.Err() != nil:
+			return // Cut off by the end of the run, not the server's fault
+		default:
+			var netErr net.Error
+			st.failed(errors.As(err, &netErr) && netErr.Timeout())
+		}
+	}
+}
+
+// rateString describes the configured query rate
+func rateString(qps float64) string {
+	if qps == 0 {
+		return "unlimited rate"
+	}
+	return fmt.Sprintf("%g qps", qps)
+}
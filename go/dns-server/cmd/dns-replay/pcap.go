@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/bellistech/dns-server/dns"
+)
+
+// pcap link types we can take packets apart for
+const (
+	linkNull     = 0   // BSD loopback
+	linkEthernet = 1   // Ethernet
+	linkRaw      = 101 // Raw IP
+	linkRawBSD   = 12  // Raw IP on some BSDs
+	linkSLL      = 113 // Linux "any" interface
+	linkSLL2     = 276 // Linux "any" interface, v2
+)
+
+// isPcap reports whether magic starts a classic pcap file, with microsecond
+// or nanosecond timestamps in either byte order
+func isPcap(magic []byte) bool {
+	if len(magic) < 4 {
+		return false
+	}
+	m := binary.LittleEndian.Uint32(magic)
+	switch m {
+	case 0xa1b2c3d4, 0xd4c3b2a1, 0xa1b23c4d, 0x4d3cb2a1:
+		return true
+	}
+	return false
+}
+
+// readPcap returns the DNS queries sent to port in a classic pcap capture.
+// Responses, non-UDP packets, IP fragments and IPv6 packets with extension
+// headers are skipped
+func readPcap(r io.Reader, port int) ([]query, error) {
+	var header [24]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("pcap header: %w", err)
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if m := binary.LittleEndian.Uint32(header[:4]); m == 0xd4c3b2a1 || m == 0x4d3cb2a1 {
+		order = binary.BigEndian
+	}
+	link := order.Uint32(header[20:24]) & 0x0fffffff // Upper bits carry FCS info
+
+	switch link {
+	case linkNull, linkEthernet, linkRaw, linkRawBSD, linkSLL, linkSLL2:
+	default:
+		return nil, fmt.Errorf("pcap link type %d is not supported", link)
+	}
+
+	var queries []query
+	var record [16]byte
+	for {
+		if _, err := io.ReadFull(r, record[:]); err == io.EOF {
+			return queries, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("pcap record: %w", err)
+		}
+
+		length := order.Uint32(record[8:12])
+		if length > 256*1024 {
+			return nil, fmt.Errorf("pcap record of %d bytes, file is corrupt", length)
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("pcap record: %w", err)
+		}
+
+		if payload := udpPayload(link, order, data, port); payload != nil {
+			if q, ok := parseQuery(payload); ok {
+				queries = append(queries, q)
+			}
+		}
+	}
+}
+
+// udpPayload returns the payload of a UDP datagram to port, or nil
+func udpPayload(link uint32, order binary.ByteOrder, data []byte, port int) []byte {
+	var ip []byte
+	switch link {
+	case linkNull:
+		// Address family in the capturing host's byte order
+		if len(data) < 4 {
+			return nil
+		}
+		ip = data[4:]
+	case linkEthernet:
+		if len(data) < 14 {
+			return nil
+		}
+		etherType, rest := binary.BigEndian.Uint16(data[12:14]), data[14:]
+		for (etherType == 0x8100 || etherType == 0x88a8) && len(rest) >= 4 {
+			etherType, rest = binary.BigEndian.Uint16(rest[2:4]), rest[4:] // VLAN tag
+		}
+		if etherType != 0x0800 && etherType != 0x86dd {
+			return nil
+		}
+		ip = rest
+	case linkRaw, linkRawBSD:
+		ip = data
+	case linkSLL:
+		if len(data) < 16 {
+			return nil
+		}
+		ip = data[16:]
+	case linkSLL2:
+		if len(data) < 20 {
+			return nil
+		}
+		ip = data[20:]
+	}
+
+	var udp []byte
+	switch {
+	case len(ip) >= 20 && ip[0]>>4 == 4:
+		headerLen := int(ip[0]&0x0f) * 4
+		fragment := binary.BigEndian.Uint16(ip[6:8]) & 0x3fff // MF flag and offset
+		if ip[9] != 17 || fragment != 0 || len(ip) < headerLen {
+			return nil
+		}
+		udp = ip[headerLen:]
+	case len(ip) >= 40 && ip[0]>>4 == 6:
+		if ip[6] != 17 {
+			return nil
+		}
+		udp = ip[40:]
+	default:
+		return nil
+	}
+
+	if len(udp) < 8 || int(binary.BigEndian.Uint16(udp[2:4])) != port {
+		return nil
+	}
+	return udp[8:]
+}
+
+// parseQuery returns the question of a DNS query
+func parseQuery(payload []byte) (query, bool) {
+	msg, err := dns.NewParser(payload).Parse()
+	if err != nil || msg.Header.Flags&dns.FlagQR != 0 || len(msg.Questions) == 0 {
+		return query{}, false
+	}
+	return query{Name: msg.Questions[0].Name, Type: msg.Questions[0].Type}, true
+}
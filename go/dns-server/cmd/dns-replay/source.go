@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/bellistech/dns-server/dns"
+)
+
+// query is one question to replay
+type query struct {
+	Name string
+	Type uint16
+}
+
+// readQueries loads the queries to replay from a pcap capture or a text log,
+// telling them apart by the pcap magic number
+func readQueries(path string, port int) ([]query, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	magic, err := r.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if isPcap(magic) {
+		return readPcap(r, port)
+	}
+	if bytes.Equal(magic, []byte{0x0a, 0x0d, 0x0d, 0x0a}) {
+		return nil, fmt.Errorf("%s is pcapng; convert it with: editcap -F pcap %s out.pcap", path, path)
+	}
+	return readLog(r)
+}
+
+// logTimestamp matches the date and time the log package prefixes lines with
+var logTimestamp = regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}`)
+
+// queryLogText matches a line of dns-server's text query log, capturing the
+// name and type
+var queryLogText = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\S+ \S+ \S+ \d+b (\S+)/IN/(\S+) `)
+
+// readLog reads one query per line, either as dns-server logs them
+//
+//	2024/01/02 15:04:05 Query from 192.0.2.1:53124: www.example.com A
+//
+// or as its query log does, in JSON or text
+//
+//	{"time":"2024-01-02T15:04:05.123Z","client":"192.0.2.1",...,"qname":"www.example.com.","qtype":"A",...}
+//	2024-01-02T15:04:05.123Z 192.0.2.1 UDP 61b www.example.com./IN/A NOERROR 1 0.084ms
+//
+// or just as "name [type]" (type defaults to A). Blank lines, lines starting
+// with "#" and other server log lines are skipped
+func readLog(r io.Reader) ([]query, error) {
+	var queries []query
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "{") {
+			var entry struct {
+				Name string `json:"qname"`
+				Type string `json:"qtype"`
+			}
+			if err := json.Unmarshal([]byte(line), &entry); err != nil || entry.Name == "" {
+				return nil, fmt.Errorf("line %d: not a query log entry: %q", lineNo, line)
+			}
+			line = entry.Name + " " + entry.Type
+		} else if m := queryLogText.FindStringSubmatch(line); m != nil {
+			line = m[1] + " " + m[2]
+		} else if logTimestamp.MatchString(line) {
+			if !strings.Contains(line, "Query from ") {
+				continue // Some other server log line
+			}
+			// The client address may be IPv6 and contain colons, but the
+			// question never contains ": "
+			line = line[strings.LastIndex(line, ": ")+2:]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("line %d: want \"name [type]\", got %q", lineNo, line)
+		}
+		q := query{Name: fields[0], Type: dns.TypeA}
+		if len(fields) == 2 {
+			t, err := parseType(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			q.Type = t
+		}
+		queries = append(queries, q)
+	}
+	return queries, scanner.Err()
+}
+
+// parseType accepts the type names dns-server knows and TYPEnnn for the rest
+func parseType(s string) (uint16, error) {
+	if t := dns.StringToType(strings.ToUpper(s)); t != 0 {
+		return t, nil
+	}
+	return 0, fmt.Errorf("unknown record type %q", s)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bellistech/dns-server/dns"
+)
+
+// histSubBuckets is how many buckets each power of two of microseconds is
+// split into, which keeps every percentile within about 6% of the truth
+const histSubBuckets = 16
+
+// histogram counts latencies in log-linear microsecond buckets, so a run of
+// millions of queries takes constant memory
+type histogram struct {
+	counts map[int]uint64
+	total  uint64
+	max    time.Duration
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make(map[int]uint64)}
+}
+
+// bucket returns the bucket a latency of us microseconds falls into.
+// Values below histSubBuckets get a bucket each
+func bucket(us uint64) int {
+	if us < histSubBuckets {
+		return int(us)
+	}
+	exp := bits.Len64(us) - 5 // 5 = bits.Len64(histSubBuckets)
+	return (exp+1)*histSubBuckets + int(us>>exp) - histSubBuckets
+}
+
+// bucketUpper returns the largest latency, in microseconds, in bucket b
+func bucketUpper(b int) uint64 {
+	if b < histSubBuckets {
+		return uint64(b)
+	}
+	exp := b/histSubBuckets - 1
+	mantissa := uint64(b%histSubBuckets + histSubBuckets)
+	return (mantissa+1)<<exp - 1
+}
+
+func (h *histogram) add(d time.Duration) {
+	h.counts[bucket(uint64(d.Microseconds()))]++
+	h.total++
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// percentile returns the latency below which p percent of the samples fall
+func (h *histogram) percentile(p float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	buckets := make([]int, 0, len(h.counts))
+	for b := range h.counts {
+		buckets = append(buckets, b)
+	}
+	sort.Ints(buckets)
+
+	rank := uint64(p / 100 * float64(h.total))
+	if rank >= h.total {
+		return h.max
+	}
+	var seen uint64
+	for _, b := range buckets {
+		seen += h.counts[b]
+		if seen > rank {
+			d := time.Duration(bucketUpper(b)) * time.Microsecond
+			return min(d, h.max)
+		}
+	}
+	return h.max
+}
+
+// stats collects the outcome of every replayed query
+type stats struct {
+	mu       sync.Mutex
+	start    time.Time
+	sent     uint64
+	timeouts uint64
+	errors   uint64
+	rcodes   map[uint8]uint64
+	latency  *histogram
+}
+
+func newStats() *stats {
+	return &stats{
+		start:   time.Now(),
+		rcodes:  make(map[uint8]uint64),
+		latency: newHistogram(),
+	}
+}
+
+// answered records a response and how long it took
+func (s *stats) answered(rcode uint8, rtt time.Duration) {
+	s.mu.Lock()
+	s.sent++
+	s.rcodes[rcode]++
+	s.latency.add(rtt)
+	s.mu.Unlock()
+}
+
+// failed records a query that got no usable response
+func (s *stats) failed(timeout bool) {
+	s.mu.Lock()
+	s.sent++
+	if timeout {
+		s.timeouts++
+	} else {
+		s.errors++
+	}
+	s.mu.Unlock()
+}
+
+// progress returns a one-line summary for periodic reporting
+func (s *stats) progress() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elapsed := time.Since(s.start)
+	return fmt.Sprintf("%6s  sent %d (%.0f qps)  p50 %s  p99 %s  timeouts %d  errors %d",
+		elapsed.Round(time.Second), s.sent, float64(s.sent)/elapsed.Seconds(),
+		fmtLatency(s.latency.percentile(50)), fmtLatency(s.latency.percentile(99)),
+		s.timeouts, s.errors)
+}
+
+// report writes the final summary
+func (s *stats) report(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elapsed := time.Since(s.start)
+	answered := s.latency.total
+
+	fmt.Fprintf(w, "\nReplayed %d queries in %s (%.1f qps)\n",
+		s.sent, elapsed.Round(time.Millisecond), float64(s.sent)/elapsed.Seconds())
+	fmt.Fprintf(w, "  answered  %d\n", answered)
+	fmt.Fprintf(w, "  timeouts  %d\n", s.timeouts)
+	fmt.Fprintf(w, "  errors    %d\n", s.errors)
+
+	if answered == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "\nLatency:\n")
+	for _, p := range []float64{50, 90, 99, 99.9} {
+		label := strings.TrimSuffix(fmt.Sprintf("p%g", p), ".0")
+		fmt.Fprintf(w, "  %-6s %s\n", label, fmtLatency(s.latency.percentile(p)))
+	}
+	fmt.Fprintf(w, "  %-6s %s\n", "max", fmtLatency(s.latency.max))
+
+	rcodes := make([]uint8, 0, len(s.rcodes))
+	for rcode := range s.rcodes {
+		rcodes = append(rcodes, rcode)
+	}
+	sort.Slice(rcodes, func(i, j int) bool { return rcodes[i] < rcodes[j] })
+
+	fmt.Fprintf(w, "\nResponse codes:\n")
+	for _, rcode := range rcodes {
+		n := s.rcodes[rcode]
+		fmt.Fprintf(w, "  %-9s %8d  %5.1f%%\n", dns.RcodeToString(rcode), n, float64(n)*100/float64(answered))
+	}
+}
+
+// fmtLatency formats a latency with three significant-ish digits
+func fmtLatency(d time.Duration) string {
+	switch {
+	case d < time.Millisecond:
+		return fmt.Sprintf("%dµs", d.Microseconds())
+	case d < time.Second:
+		return fmt.Sprintf("%.2fms", float64(d.Microseconds())/1000)
+	default:
+		return fmt.Sprintf("%.2fs", d.Seconds())
+	}
+}
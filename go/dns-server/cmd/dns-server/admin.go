@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/bellistech/dns-server/dns"
+)
+
+// recordJSON is the admin API representation of a resource record
+type recordJSON struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	TTL     uint32 `json:"ttl"`
+	Data    string `json:"data"`
+	Comment string `json:"comment,omitempty"`
+	Weight  uint16 `json:"weight,omitempty"` // A and AAAA only
+}
+
+// toRecordJSON returns the admin API representation of a record
+func toRecordJSON(rr dns.ResourceRecord) recordJSON {
+	return recordJSON{
+		Name:    rr.Name,
+		Type:    dns.TypeToString(rr.Type),
+		TTL:     rr.TTL,
+		Data:    rr.RDataString(),
+		Comment: rr.Comment,
+		Weight:  rr.Weight,
+	}
+}
+
+// AdminHandler returns the HTTP handler for the admin API:
+//
+//	GET /zones                 list loaded zones
+//	GET /zones/{zone}          records of a zone, with comments
+//	GET /zones/{zone}/export   the zone in zone file format
+//	POST /zones/{zone}/records add a record (a JSON object like those listed),
+//	                           or replace the one with the same data
+//	DELETE /zones/{zone}/records?name=www&type=A[&data=192.0.2.1]
+//	                           delete records
+//	POST /zones/{zone}/reload  load the zone's file again
+//	GET /stats?n=10            query counters, top names and client prefixes
+//	GET /metrics               metrics in the Prometheus text format
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	mux.HandleFunc("/zones", s.handleZones)
+	mux.HandleFunc("/zones/", s.handleZone)
+	mux.HandleFunc("/stats", s.handleStats)
+	return mux
+}
+
+func (s *Server) handleZones(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.RLock()
+	names := make([]string, 0, len(s.zones))
+	for name := range s.zones {
+		names = append(names, name)
+	}
+	s.mu.RUnlock()
+	sort.Strings(names)
+
+	writeJSON(w, map[string]interface{}{"zones": names})
+}
+
+func (s *Server) handleZone(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/zones/"), "/")
+	name, action, _ := strings.Cut(path, "/")
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	s.mu.RLock()
+	zone := s.zones[name]
+	s.mu.RUnlock()
+
+	if zone == nil {
+		http.Error(w, "zone not found", http.StatusNotFound)
+		return
+	}
+
+	if action == "records" || action == "reload" {
+		if !s.mayChange(r) {
+			http.Error(w, "changes are not allowed from this address", http.StatusForbidden)
+			return
+		}
+		p := s.primaryZone(zone.Name)
+		if p == nil {
+			http.Error(w, "zone "+zone.Name+" isn't loaded from a file, so can't be changed", http.StatusConflict)
+			return
+		}
+		if action == "records" {
+			s.handleRecords(w, r, p)
+		} else {
+			s.handleReload(w, r, p)
+		}
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch action {
+	case "":
+		records := []recordJSON{}
+		for _, rr := range zone.AllRecords() {
+			records = append(records, toRecordJSON(rr))
+		}
+		writeJSON(w, map[string]interface{}{"zone": zone.Name, "records": records})
+
+	case "export":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := zone.Export(w); err != nil {
+			log.Printf("Admin: export of %s failed: %v", zone.Name, err)
+		}
+
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	n := 10
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 1 {
+			http.Error(w, "n must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	names, clients := s.stats.top(n)
+	writeJSON(w, map[string]interface{}{
+		"queries":     atomic.LoadUint64(&s.queries),
+		"answers":     atomic.LoadUint64(&s.answers),
+		"nxdomain":    atomic.LoadUint64(&s.nxdomain),
+		"errors":      atomic.LoadUint64(&s.errors),
+		"dropped":     atomic.LoadUint64(&s.dropped),
+		"truncated":   atomic.LoadUint64(&s.truncated),
+		"forwarded":   atomic.LoadUint64(&s.forwarded),
+		"cache_hits":  atomic.LoadUint64(&s.cacheHits),
+		"refused":     atomic.LoadUint64(&s.refused),
+		"rcodes":      s.metrics.rcodes(),
+		"top_names":   names,
+		"top_clients": clients,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Admin: encoding response failed: %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/bellistech/dns-server/dns"
+)
+
+// catalogVersion is the only catalog zone schema version understood (RFC
+// 9432 section 4.2.1)
+const catalogVersion = "2"
+
+// catalog provisions the member zones of a catalog zone (RFC 9432): each
+// time the catalog is transferred, the zones it lists become secondary zones
+// transferred from the catalog's primaries, and those no longer listed stop
+// being served. A catalog lets a fleet of secondaries pick up new zones from
+// their primary without a change to each one's configuration.
+type catalog struct {
+	server    *Server
+	name      string
+	primaries []string
+
+	// Only touched by the catalog zone's secondary, after its transfers
+	members map[string]*catalogMember // Zone name -> member
+}
+
+// catalogMember is a member zone being served, and the ID the catalog
+// lists it under
+type catalogMember struct {
+	id     string
+	zone   *secondary
+	cancel context.CancelFunc
+	done   chan struct{} // Closed once zone.run has returned
+}
+
+func newCatalog(server *Server, name string, primaries []string) *catalog {
+	return &catalog{
+		server:    server,
+		name:      name,
+		primaries: primaries,
+		members:   make(map[string]*catalogMember),
+	}
+}
+
+// update brings the member zones in line with a new copy of the catalog;
+// they are kept until ctx is done. A catalog that can't be read leaves
+// them as they are.
+func (c *catalog) update(ctx context.Context, zone *dns.Zone) {
+	members, err := catalogMembers(zone)
+	if err != nil {
+		log.Printf("Catalog zone %s: %v; member zones unchanged", c.name, err)
+		return
+	}
+
+	// A member listed under a new ID is started afresh, with its data
+	// dropped and transferred again (RFC 9432 section 5.6)
+	reset := make(map[string]bool)
+	for _, name := range sortedKeys(c.members) {
+		m := c.members[name]
+		id, ok := members[name]
+		if ok && id == m.id {
+			continue
+		}
+		c.stop(name, m)
+		if ok {
+			reset[name] = true
+		} else {
+			log.Printf("Catalog zone %s: member zone %s removed", c.name, name)
+		}
+	}
+
+	for _, name := range sortedKeys(members) {
+		if c.members[name] != nil {
+			continue
+		}
+		// A zone configured here, or by another catalog, stays as it is
+		// (RFC 9432 section 4.1)
+		if c.server.configured(name) {
+			log.Printf("Catalog zone %s: member zone %s ignored, already served otherwise", c.name, name)
+			continue
+		}
+		c.start(ctx, name, members[name])
+		if reset[name] {
+			log.Printf("Catalog zone %s: member zone %s reset under ID %s, transferring it again", c.name, name, members[name])
+		} else {
+			log.Printf("Catalog zone %s: member zone %s added", c.name, name)
+		}
+	}
+}
+
+// start serves a member zone from the catalog's primaries
+func (c *catalog) start(ctx context.Context, name, id string) {
+	z := newSecondary(c.server, name, c.primaries)
+	memberCtx, cancel := context.WithCancel(ctx)
+	m := &catalogMember{id: id, zone: z, cancel: cancel, done: make(chan struct{})}
+	c.members[name] = m
+	c.server.addSecondary(z)
+	go func() {
+		defer close(m.done)
+		z.run(memberCtx)
+	}()
+}
+
+// stop stops serving a member zone. Its secondary is waited for, so a
+// transfer under way can't serve the zone again once removed.
+func (c *catalog) stop(name string, m *catalogMember) {
+	m.cancel()
+	<-m.done
+	c.server.removeSecondary(m.zone)
+	c.server.RemoveZone(name)
+	delete(c.members, name)
+}
+
+// catalogMembers returns the member zones a catalog zone lists, by name,
+// with the unique ID each is listed under: a PTR record to the member's name
+// at <id>.zones.<catalog> (RFC 9432 section 4.1). An ID with more than one
+// PTR record, and a zone listed under more than one ID, are ignored. Other
+// records, such as properties, are left alone.
+func catalogMembers(zone *dns.Zone) (map[string]string, error) {
+	version := zone.Lookup("version."+zone.Name, dns.TypeTXT)
+	if len(version) != 1 || len(version[0].Text) != 1 || version[0].Text[0] != catalogVersion {
+		return nil, fmt.Errorf("version.%s is not a TXT record of %q, the catalog schema version understood", zone.Name, catalogVersion)
+	}
+
+	suffix := ".zones." + strings.ToLower(zone.Name)
+	targets := make(map[string][]string) // ID -> member names
+	for _, rr := range zone.AllRecords() {
+		if rr.Type != dns.TypePTR {
+			continue
+		}
+		id, ok := strings.CutSuffix(strings.ToLower(rr.Name), suffix)
+		if !ok || id == "" || strings.Contains(id, ".") {
+			continue
+		}
+		targets[id] = append(targets[id], strings.ToLower(strings.TrimSuffix(rr.Target, ".")))
+	}
+
+	ids := make(map[string][]string) // Member name -> IDs
+	for _, id := range sortedKeys(targets) {
+		if len(targets[id]) != 1 {
+			log.Printf("Catalog zone %s: %s.zones has %d PTR records, not 1; ignored", zone.Name, id, len(targets[id]))
+			continue
+		}
+		name := targets[id][0]
+		ids[name] = append(ids[name], id)
+	}
+
+	members := make(map[string]string)
+	for _, name := range sortedKeys(ids) {
+		if len(ids[name]) != 1 {
+			log.Printf("Catalog zone %s: member zone %s is listed under %s; ignored", zone.Name, name, strings.Join(ids[name], ", "))
+			continue
+		}
+		members[name] = ids[name][0]
+	}
+	return members, nil
+}
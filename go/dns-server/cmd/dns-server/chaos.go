@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/bellistech/dns-server/dns"
+)
+
+// identity holds the answers to the server identity queries: query name ->
+// TXT string
+type identity map[string]string
+
+func newIdentity(c IdentityConfig) identity {
+	id := make(identity)
+	if c.Version != "" {
+		id["version.bind"] = c.Version
+		id["version.server"] = c.Version
+	}
+	if c.ID != "" {
+		// hostname.bind is BIND's name for id.server
+		id["id.server"] = c.ID
+		id["hostname.bind"] = c.ID
+	}
+	return id
+}
+
+// handleChaos answers a query of class CHAOS: a TXT record for the identity
+// queries configured, and REFUSED for any other name, which we aren't
+// authoritative for
+func (s *Server) handleChaos(query *dns.Message, builder *dns.Builder) []byte {
+	q := query.Questions[0]
+	text, ok := s.identity[strings.ToLower(q.Name)]
+	if !ok {
+		s.logQuery("  -> REFUSED (CHAOS)")
+		return builder.BuildErrorResponse(query, dns.RcodeRefused)
+	}
+
+	atomic.AddUint64(&s.answers, 1)
+	if q.Type != dns.TypeTXT && q.Type != dns.TypeANY {
+		s.logQuery("  -> NODATA")
+		return builder.BuildResponse(query, nil, nil)
+	}
+	rr := dns.NewTXTRecord(q.Name, 0, text)
+	rr.Class = dns.ClassCH
+	s.logQuery("  -> identity %q", text)
+	return builder.BuildResponse(query, []dns.ResourceRecord{rr}, nil)
+}
@@ -0,0 +1,736 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bellistech/dns-server/dns"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the server configuration, read from a YAML file (-config) or
+// built from the command line flags
+type Config struct {
+	Listen         ListenConfig  `yaml:"listen"`
+	Zones          []ZoneConfig  `yaml:"zones"`
+	LocalhostZones bool          `yaml:"localhost_zones"` // Serve the built-in localhost zones
+	DNS64          string        `yaml:"dns64"`           // NAT64 prefix, empty to disable
+	Forward        ForwardConfig `yaml:"forward"`
+	ACL            ACLConfig     `yaml:"acl"`
+	Logging        LoggingConfig `yaml:"logging"`
+	Limits         LimitsConfig  `yaml:"limits"`
+	Admin          AdminConfig   `yaml:"admin"`
+
+	// ZoneCheckInterval is how often the zone files are checked for
+	// changes, which are then loaded without a restart. 0 reloads them only
+	// on SIGHUP.
+	ZoneCheckInterval time.Duration `yaml:"zone_check_interval"`
+
+	// Dnstap sends every query and response to a dnstap collector (nil to
+	// disable)
+	Dnstap *DnstapConfig `yaml:"dnstap"`
+
+	// AnswerOrder is the order of the A and AAAA records of answers:
+	// AnswerOrderRotate, AnswerOrderRandom or AnswerOrderFixed
+	AnswerOrder string `yaml:"answer_order"`
+
+	// Geo answers clients in different regions with the zones' variants
+	// for their region (nil to disable)
+	Geo *GeoConfig `yaml:"geo"`
+
+	// Identity answers the CHAOS class queries tools send a server about
+	// itself
+	Identity IdentityConfig `yaml:"identity"`
+
+	// HealthChecks sets how the addresses of records with a $HEALTHCHECK
+	// are checked
+	HealthChecks HealthCheckConfig `yaml:"health_checks"`
+}
+
+// ListenConfig holds the listen addresses; an empty address disables that
+// listener
+type ListenConfig struct {
+	IPv4 string     `yaml:"ipv4"`
+	IPv6 string     `yaml:"ipv6"`
+	TCP  bool       `yaml:"tcp"` // Also listen on TCP, on the same addresses
+	TLS  *TLSConfig `yaml:"tls"` // DNS over TLS (nil to disable)
+
+	// HTTPS serves DNS over HTTPS (nil to disable); IdleTimeout applies to
+	// HTTP keep-alive connections
+	HTTPS *TLSConfig `yaml:"https"`
+
+	// UDPWorkers is how many UDP sockets share each address with
+	// SO_REUSEPORT, each read by its own goroutine, so the kernel spreads
+	// queries across them. 0 means one per CPU (GOMAXPROCS). Only Linux
+	// spreads them, so elsewhere there is always one.
+	UDPWorkers int `yaml:"udp_workers"`
+}
+
+// TLSConfig sets up DNS over TLS (RFC 7858) or DNS over HTTPS (RFC 8484)
+// listeners, answering the same queries as UDP and TCP for clients that
+// want them private
+type TLSConfig struct {
+	// IPv4 and IPv6 are the listen addresses, usually port 853 for TLS and
+	// 443 for HTTPS; empty disables that listener
+	IPv4 string `yaml:"ipv4"`
+	IPv6 string `yaml:"ipv6"`
+
+	// CertFile and KeyFile are the PEM certificate (with any intermediate
+	// certificates after it) and its private key. They are loaded again
+	// when they change.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+
+	// IdleTimeout closes a connection that sends no query for this long.
+	// 0 means DefaultTLSIdleTimeout.
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+}
+
+// Zone types
+const (
+	ZonePrimary   = "primary"   // Loaded from a zone file
+	ZoneSecondary = "secondary" // Transferred from primary servers
+	ZoneCatalog   = "catalog"   // A secondary zone listing more to serve (RFC 9432)
+)
+
+// ZoneConfig is one zone to serve: a zone file, or a secondary zone copied
+// from its primaries
+type ZoneConfig struct {
+	Type string `yaml:"type"` // ZonePrimary (the default), ZoneSecondary or ZoneCatalog
+	File string `yaml:"file"` // Primary zones only
+
+	// Name and Primaries are for secondary and catalog zones: the zone to
+	// transfer, and the servers (address, port 53 by default) to transfer
+	// it from, tried in order. A catalog zone's members are transferred
+	// from its primaries too.
+	Name      string   `yaml:"name"`
+	Primaries []string `yaml:"primaries"`
+
+	// AllowTransfer lists the networks (CIDR or single address) that may
+	// transfer the zone with AXFR, usually its secondary servers. Empty
+	// allows no one.
+	AllowTransfer []string `yaml:"allow_transfer"`
+
+	// Notify lists the secondaries (address, port 53 by default) sent a
+	// NOTIFY when the zone is loaded or transferred with a new serial
+	Notify []string `yaml:"notify"`
+
+	// DNSSEC signs the zone; primary zones only
+	DNSSEC *DNSSECConfig `yaml:"dnssec"`
+
+	// AllowQuery and DenyQuery restrict who is answered for the zone, as
+	// acl.allow_query and acl.deny_query do for the whole server, which
+	// are checked first
+	AllowQuery []string `yaml:"allow_query"`
+	DenyQuery  []string `yaml:"deny_query"`
+}
+
+// DNSSECConfig signs a zone with DNSSEC
+type DNSSECConfig struct {
+	// KeyDir holds the zone's keys, <zone>.ksk.pem and <zone>.zsk.pem;
+	// keys not found there are generated and saved
+	KeyDir string `yaml:"key_dir"`
+
+	// Presign signs every RRset when the zone is loaded, rather than each
+	// one the first time it is answered
+	Presign bool `yaml:"presign"`
+
+	// SignatureValidity is how long signatures are valid for; they are
+	// made again halfway through. 0 means dns.DefaultSignatureValidity.
+	SignatureValidity time.Duration `yaml:"signature_validity"`
+
+	// NSEC3 proves names don't exist with hashed NSEC3 records instead of
+	// NSEC, so the zone's names can't be listed by walking the chain
+	NSEC3 *NSEC3Config `yaml:"nsec3"`
+}
+
+// NSEC3Config sets how NSEC3 hashes names. RFC 9276 advises no extra
+// iterations and no salt, the defaults.
+type NSEC3Config struct {
+	Iterations uint16 `yaml:"iterations"` // Extra SHA-1 iterations, at most dns.MaxNSEC3Iterations
+	Salt       string `yaml:"salt"`       // In hex; empty or "-" for none
+}
+
+// salt returns the decoded salt
+func (c *NSEC3Config) salt() ([]byte, error) {
+	if c.Salt == "-" {
+		return nil, nil
+	}
+	salt, err := hex.DecodeString(c.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("salt is not hex: %w", err)
+	}
+	if len(salt) > 255 {
+		return nil, fmt.Errorf("salt is longer than 255 bytes")
+	}
+	return salt, nil
+}
+
+// ForwardConfig makes the server a forwarder for names outside its zones:
+// queries for them that ask for recursion go to upstream resolvers, and the
+// answers are cached
+type ForwardConfig struct {
+	// Upstreams are the resolvers (address, port 53 by default) queries are
+	// forwarded to, taking turns; empty disables forwarding
+	Upstreams []string `yaml:"upstreams"`
+
+	// Allow lists the networks (CIDR or single address) whose queries are
+	// forwarded; others are REFUSED as before. Empty allows only loopback
+	// addresses.
+	Allow []string `yaml:"allow"`
+
+	// Timeout is how long each upstream gets to answer before the next is
+	// tried
+	Timeout time.Duration `yaml:"timeout"`
+
+	// CacheSize is how many responses are cached, the least recently used
+	// going first when full. 0 disables the cache.
+	CacheSize int `yaml:"cache_size"`
+
+	// Zones forwards the queries for names in these domains to upstreams
+	// of their own instead, with or without Upstreams for the rest
+	Zones []ForwardZoneConfig `yaml:"zones"`
+
+	// HealthCheck is how often every upstream is sent a query to check it
+	// answers; one that doesn't is tried last until it does again. 0
+	// leaves failed upstreams to be found by the queries forwarded.
+	HealthCheck time.Duration `yaml:"health_check"`
+}
+
+// ForwardZoneConfig forwards the queries for a domain and the names below
+// it to their own upstreams, such as a company's internal resolvers
+type ForwardZoneConfig struct {
+	Name      string   `yaml:"name"`
+	Upstreams []string `yaml:"upstreams"` // Taking turns, as forward.upstreams do
+}
+
+// ACLConfig restricts which clients are answered
+type ACLConfig struct {
+	// AllowQuery lists the networks (CIDR or single address) that may
+	// query; others are REFUSED. Empty allows everyone.
+	AllowQuery []string `yaml:"allow_query"`
+
+	// DenyQuery lists networks that are REFUSED even if AllowQuery
+	// includes them
+	DenyQuery []string `yaml:"deny_query"`
+}
+
+// LoggingConfig controls the server log
+type LoggingConfig struct {
+	File    string `yaml:"file"`    // Appended to; empty logs to stderr
+	Queries bool   `yaml:"queries"` // Log every query and its outcome
+
+	// QueryLog writes a structured line per query to a file of its own
+	// instead of the server log (nil to disable)
+	QueryLog *QueryLogConfig `yaml:"query_log"`
+}
+
+// DnstapConfig configures the dnstap output
+type DnstapConfig struct {
+	Socket   string `yaml:"socket"`   // Unix socket the collector listens on
+	Identity string `yaml:"identity"` // Server name in each message; empty for the host name
+	Version  string `yaml:"version"`  // Server version in each message
+}
+
+// GeoConfig says which region each client is in, by the network its
+// address is in; the longest network containing it wins
+type GeoConfig struct {
+	// Database is a CSV file of "network,region" lines, e.g. the networks
+	// of a GeoIP database with the region of their country
+	Database string `yaml:"database"`
+
+	// Regions lists the networks of each region, on top of the database's
+	Regions map[string][]string `yaml:"regions"`
+}
+
+// IdentityConfig holds the answers to the server identity queries, TXT
+// records of class CHAOS (RFC 4892). Empty ones are refused, so nothing
+// about the server is given away unless configured.
+type IdentityConfig struct {
+	Version string `yaml:"version"` // version.bind and version.server
+	ID      string `yaml:"id"`      // id.server and hostname.bind
+}
+
+// HealthCheckConfig sets how often the health checks of address records
+// run. Addresses that fail are left out of answers until they pass again.
+type HealthCheckConfig struct {
+	// Interval is how often every address is checked; 0 disables the
+	// checks, so every address is answered with
+	Interval time.Duration `yaml:"interval"`
+
+	// Timeout is how long one check may take before the address counts
+	// as down
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// QueryLogConfig configures the structured query log
+type QueryLogConfig struct {
+	File   string `yaml:"file"`
+	Format string `yaml:"format"` // QueryLogJSON (the default) or QueryLogText
+
+	// Sample logs one query in this many, to limit the volume on a busy
+	// server. 0 or 1 logs every query.
+	Sample int `yaml:"sample"`
+
+	// MaxSize is how large the file may grow, in megabytes, before it is
+	// rotated, keeping MaxBackups old files (0 means
+	// DefaultQueryLogBackups). A MaxSize of 0 never rotates.
+	MaxSize    int `yaml:"max_size"`
+	MaxBackups int `yaml:"max_backups"`
+}
+
+// LimitsConfig bounds the resources queries may use
+type LimitsConfig struct {
+	// MaxConcurrentQueries is how many queries are handled at once; more
+	// are dropped until one finishes, so a flood can't exhaust memory.
+	// 0 means no limit.
+	MaxConcurrentQueries int `yaml:"max_concurrent_queries"`
+
+	// QueryWorkers is how many goroutines answer UDP queries. Queries
+	// arriving while all are busy wait in a queue as long; beyond that
+	// they are dropped.
+	QueryWorkers int `yaml:"query_workers"`
+
+	// TCPIdleTimeout closes a TCP connection that sends no query for this
+	// long, or doesn't read its response within it
+	TCPIdleTimeout time.Duration `yaml:"tcp_idle_timeout"`
+
+	// MaxTCPConnections is how many TCP connections are open at once;
+	// more are closed straight away. 0 means no limit.
+	MaxTCPConnections int `yaml:"max_tcp_connections"`
+
+	// EDNSUDPSize is the UDP payload size advertised to EDNS clients, and
+	// the most sent over UDP even to clients advertising more
+	EDNSUDPSize uint16 `yaml:"edns_udp_size"`
+}
+
+// AdminConfig configures the admin HTTP API
+type AdminConfig struct {
+	Listen string `yaml:"listen"` // Empty to disable
+	TopK   int    `yaml:"top_k"`  // Query names and client prefixes tracked for /stats
+
+	// AllowChanges lists the networks whose requests may add and delete
+	// records and reload zones; the rest may only read. Empty allows only
+	// loopback addresses.
+	AllowChanges []string `yaml:"allow_changes"`
+}
+
+// DefaultConfig returns the configuration used for anything a config file
+// or the flags leave out
+func DefaultConfig() *Config {
+	return &Config{
+		Listen:  ListenConfig{IPv4: ":5353", IPv6: "[::]:5353", TCP: true},
+		Logging: LoggingConfig{Queries: true},
+		Forward: ForwardConfig{Timeout: DefaultForwardTimeout, CacheSize: DefaultCacheSize, HealthCheck: DefaultHealthCheck},
+		Limits:  LimitsConfig{QueryWorkers: DefaultQueryWorkers, TCPIdleTimeout: DefaultTCPIdleTimeout, EDNSUDPSize: dns.DefaultEDNSUDPSize},
+		Admin:   AdminConfig{TopK: DefaultTopK},
+
+		AnswerOrder:  AnswerOrderRotate,
+		HealthChecks: HealthCheckConfig{Interval: DefaultHealthInterval, Timeout: DefaultHealthTimeout},
+	}
+}
+
+// LoadConfig reads and validates a YAML config file. Unknown keys are
+// errors, so a misspelled setting isn't silently ignored.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	config := DefaultConfig()
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s:\n%w", path, err)
+	}
+	return config, nil
+}
+
+// Validate checks the configuration and returns every problem found, one
+// per line
+func (c *Config) Validate() error {
+	var errs []error
+	addf := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("  "+format, args...))
+	}
+
+	if c.Listen.IPv4 == "" && c.Listen.IPv6 == "" {
+		addf("listen: at least one of ipv4 and ipv6 is required")
+	}
+	for _, l := range []struct{ key, addr string }{{"ipv4", c.Listen.IPv4}, {"ipv6", c.Listen.IPv6}} {
+		if l.addr == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(l.addr); err != nil {
+			addf("listen.%s: %v", l.key, err)
+		}
+	}
+
+	if c.Listen.UDPWorkers < 0 {
+		addf("listen.udp_workers: must not be negative")
+	}
+
+	for _, l := range []struct {
+		key    string
+		config *TLSConfig
+	}{{"tls", c.Listen.TLS}, {"https", c.Listen.HTTPS}} {
+		t := l.config
+		if t == nil {
+			continue
+		}
+		if t.IPv4 == "" && t.IPv6 == "" {
+			addf("listen.%s: at least one of ipv4 and ipv6 is required", l.key)
+		}
+		for _, a := range []struct{ key, addr string }{{"ipv4", t.IPv4}, {"ipv6", t.IPv6}} {
+			if a.addr == "" {
+				continue
+			}
+			if _, _, err := net.SplitHostPort(a.addr); err != nil {
+				addf("listen.%s.%s: %v", l.key, a.key, err)
+			}
+		}
+		if t.CertFile == "" || t.KeyFile == "" {
+			addf("listen.%s: cert_file and key_file are required", l.key)
+		}
+		if t.IdleTimeout < 0 {
+			addf("listen.%s.idle_timeout: must not be negative", l.key)
+		}
+	}
+
+	if c.ZoneCheckInterval < 0 {
+		addf("zone_check_interval: must not be negative")
+	}
+	if len(c.Zones) == 0 && !c.LocalhostZones && len(c.Forward.Upstreams) == 0 && len(c.Forward.Zones) == 0 {
+		addf("zones: at least one zone file is required, unless forwarding")
+	}
+	for i, zone := range c.Zones {
+		switch zone.Type {
+		case "", ZonePrimary:
+			if zone.File == "" {
+				addf("zones[%d]: file is required", i)
+			}
+			if zone.Name != "" || len(zone.Primaries) > 0 {
+				addf("zones[%d]: name and primaries are only for secondary zones", i)
+			}
+		case ZoneSecondary, ZoneCatalog:
+			if zone.Name == "" {
+				addf("zones[%d]: name is required for a %s zone", i, zone.Type)
+			}
+			if len(zone.Primaries) == 0 {
+				addf("zones[%d]: primaries are required for a %s zone", i, zone.Type)
+			}
+			if zone.File != "" {
+				addf("zones[%d]: a %s zone has no file; its data comes from the primaries", i, zone.Type)
+			}
+			if zone.DNSSEC != nil {
+				addf("zones[%d]: a %s zone can't be signed; dnssec is for primary zones", i, zone.Type)
+			}
+		default:
+			addf("zones[%d].type: %q is not %s, %s or %s", i, zone.Type, ZonePrimary, ZoneSecondary, ZoneCatalog)
+		}
+		if _, err := parseACL(zone.AllowTransfer); err != nil {
+			addf("zones[%d].allow_transfer: %v", i, err)
+		}
+		if _, err := parseACL(zone.AllowQuery); err != nil {
+			addf("zones[%d].allow_query: %v", i, err)
+		}
+		if _, err := parseACL(zone.DenyQuery); err != nil {
+			addf("zones[%d].deny_query: %v", i, err)
+		}
+		for _, target := range zone.Notify {
+			if target == "" {
+				addf("zones[%d].notify: empty address", i)
+			}
+		}
+		if zone.DNSSEC != nil {
+			if zone.DNSSEC.KeyDir == "" {
+				addf("zones[%d].dnssec.key_dir: required to sign the zone", i)
+			}
+			if zone.DNSSEC.SignatureValidity < 0 {
+				addf("zones[%d].dnssec.signature_validity: must not be negative", i)
+			}
+			if nsec3 := zone.DNSSEC.NSEC3; nsec3 != nil {
+				if nsec3.Iterations > dns.MaxNSEC3Iterations {
+					addf("zones[%d].dnssec.nsec3.iterations: at most %d", i, dns.MaxNSEC3Iterations)
+				}
+				if _, err := nsec3.salt(); err != nil {
+					addf("zones[%d].dnssec.nsec3: %v", i, err)
+				}
+			}
+		}
+	}
+
+	switch c.AnswerOrder {
+	case AnswerOrderRotate, AnswerOrderRandom, AnswerOrderFixed:
+	default:
+		addf("answer_order: must be %s, %s or %s", AnswerOrderRotate, AnswerOrderRandom, AnswerOrderFixed)
+	}
+
+	if g := c.Geo; g != nil {
+		if g.Database == "" && len(g.Regions) == 0 {
+			addf("geo: a database or regions are required")
+		}
+		for _, region := range sortedKeys(g.Regions) {
+			if region == "" {
+				addf("geo.regions: empty region name")
+			}
+			if _, err := parseACL(g.Regions[region]); err != nil {
+				addf("geo.regions.%s: %v", region, err)
+			}
+		}
+	}
+
+	// A TXT string is at most 255 bytes
+	if len(c.Identity.Version) > 255 {
+		addf("identity.version: longer than 255 bytes")
+	}
+	if len(c.Identity.ID) > 255 {
+		addf("identity.id: longer than 255 bytes")
+	}
+
+	if c.HealthChecks.Interval < 0 {
+		addf("health_checks.interval: must not be negative")
+	}
+	if c.HealthChecks.Timeout <= 0 {
+		addf("health_checks.timeout: must be positive")
+	}
+
+	if c.DNS64 != "" {
+		if _, err := dns.NewDNS64(c.DNS64); err != nil {
+			addf("dns64: %v", err)
+		}
+	}
+
+	for _, upstream := range c.Forward.Upstreams {
+		if upstream == "" {
+			addf("forward.upstreams: empty address")
+		}
+	}
+	if _, err := parseACL(c.Forward.Allow); err != nil {
+		addf("forward.allow: %v", err)
+	}
+	if c.Forward.Timeout <= 0 {
+		addf("forward.timeout: must be positive")
+	}
+	if c.Forward.CacheSize < 0 {
+		addf("forward.cache_size: must not be negative")
+	}
+	if c.Forward.HealthCheck < 0 {
+		addf("forward.health_check: must not be negative")
+	}
+	forwardZones := make(map[string]bool)
+	for i, zone := range c.Forward.Zones {
+		name := strings.ToLower(strings.TrimSuffix(zone.Name, "."))
+		if name == "" {
+			addf("forward.zones[%d].name: required", i)
+		} else if forwardZones[name] {
+			addf("forward.zones[%d].name: %s is forwarded already", i, name)
+		}
+		forwardZones[name] = true
+		if len(zone.Upstreams) == 0 {
+			addf("forward.zones[%d].upstreams: required", i)
+		}
+		for _, upstream := range zone.Upstreams {
+			if upstream == "" {
+				addf("forward.zones[%d].upstreams: empty address", i)
+			}
+		}
+	}
+
+	if _, err := parseACL(c.ACL.AllowQuery); err != nil {
+		addf("acl.allow_query: %v", err)
+	}
+	if _, err := parseACL(c.ACL.DenyQuery); err != nil {
+		addf("acl.deny_query: %v", err)
+	}
+
+	if ql := c.Logging.QueryLog; ql != nil {
+		if ql.File == "" {
+			addf("logging.query_log.file: required")
+		}
+		if ql.Format != "" && ql.Format != QueryLogJSON && ql.Format != QueryLogText {
+			addf("logging.query_log.format: %q is not %s or %s", ql.Format, QueryLogJSON, QueryLogText)
+		}
+		if ql.Sample < 0 {
+			addf("logging.query_log.sample: must not be negative")
+		}
+		if ql.MaxSize < 0 {
+			addf("logging.query_log.max_size: must not be negative")
+		}
+		if ql.MaxBackups < 0 {
+			addf("logging.query_log.max_backups: must not be negative")
+		}
+	}
+
+	if c.Dnstap != nil && c.Dnstap.Socket == "" {
+		addf("dnstap.socket: required")
+	}
+
+	if c.Limits.MaxConcurrentQueries < 0 {
+		addf("limits.max_concurrent_queries: must not be negative")
+	}
+	if c.Limits.QueryWorkers <= 0 {
+		addf("limits.query_workers: must be positive")
+	}
+	if c.Limits.TCPIdleTimeout <= 0 {
+		addf("limits.tcp_idle_timeout: must be positive")
+	}
+	if c.Limits.MaxTCPConnections < 0 {
+		addf("limits.max_tcp_connections: must not be negative")
+	}
+	if c.Limits.EDNSUDPSize < dns.MinUDPSize {
+		addf("limits.edns_udp_size: must be at least %d", dns.MinUDPSize)
+	}
+
+	if c.Admin.TopK < 1 {
+		addf("admin.top_k: must be at least 1")
+	}
+	if c.Admin.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Admin.Listen); err != nil {
+			addf("admin.listen: %v", err)
+		}
+	}
+	if _, err := parseACL(c.Admin.AllowChanges); err != nil {
+		addf("admin.allow_changes: %v", err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// acl is a list of networks a client must be in to be answered; an empty
+// list allows everyone
+type acl []*net.IPNet
+
+// parseACL parses networks in CIDR notation; a single address stands for
+// itself
+func parseACL(networks []string) (acl, error) {
+	var list acl
+	for _, n := range networks {
+		if !strings.Contains(n, "/") {
+			ip := net.ParseIP(n)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", n)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			list = append(list, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(n)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, network)
+	}
+	return list, nil
+}
+
+// Allows reports whether ip is in one of the networks
+func (a acl) Allows(ip net.IP) bool {
+	return len(a) == 0 || a.Contains(ip)
+}
+
+// Contains reports whether ip is in one of the networks; unlike Allows,
+// false for an empty list
+func (a acl) Contains(ip net.IP) bool {
+	for _, network := range a {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// queryACL decides who may query: clients in deny are refused, then the
+// rest must be in allow (unless it's empty)
+type queryACL struct {
+	allow acl
+	deny  acl
+}
+
+// parseQueryACL parses allow and deny lists as parseACL does
+func parseQueryACL(allow, deny []string) (queryACL, error) {
+	var q queryACL
+	var err error
+	if q.allow, err = parseACL(allow); err != nil {
+		return queryACL{}, err
+	}
+	if q.deny, err = parseACL(deny); err != nil {
+		return queryACL{}, err
+	}
+	return q, nil
+}
+
+// Allows reports whether ip may query
+func (q queryACL) Allows(ip net.IP) bool {
+	return !q.deny.Contains(ip) && q.allow.Allows(ip)
+}
+
+// empty reports whether the ACL allows everyone
+func (q queryACL) empty() bool {
+	return len(q.allow) == 0 && len(q.deny) == 0
+}
+
+// checkConfig validates a config file and parses its zone files without
+// starting the server, so a change can be checked before a restart
+func checkConfig(path string) error {
+	config, err := LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	for _, l := range []struct {
+		key    string
+		config *TLSConfig
+	}{{"tls", config.Listen.TLS}, {"https", config.Listen.HTTPS}} {
+		if l.config == nil {
+			continue
+		}
+		if _, err := loadCertificate(l.config.CertFile, l.config.KeyFile); err != nil {
+			return fmt.Errorf("invalid listen.%s in %s: %w", l.key, path, err)
+		}
+	}
+
+	if config.Geo != nil {
+		if _, err := loadGeoDB(config.Geo); err != nil {
+			return fmt.Errorf("invalid geo in %s: %w", path, err)
+		}
+	}
+
+	var errs []error
+	files := make(map[string]string) // Zone name -> file it came from
+	for _, zone := range config.Zones {
+		if zone.Type == ZoneSecondary || zone.Type == ZoneCatalog {
+			continue
+		}
+		z, err := dns.LoadZoneFile(zone.File)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("  zone %s: %w", zone.File, err))
+			continue
+		}
+		if zone.DNSSEC != nil && z.SOA == nil {
+			errs = append(errs, fmt.Errorf("  zone %s: has no SOA record, which signing needs", zone.File))
+		}
+		if other, ok := files[z.Name]; ok {
+			errs = append(errs, fmt.Errorf("  zone %s: %s is already in %s", zone.File, z.Name, other))
+		}
+		files[z.Name] = zone.File
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid zone files in %s:\n%w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/bellistech/dns-server/dns"
+)
+
+// signZone signs a loaded zone with the keys in config.KeyDir, generating
+// any that are missing, and returns the signer to serve it with
+func (s *Server) signZone(zone *dns.Zone, config *DNSSECConfig) (*dns.Signer, error) {
+	ksk, err := loadKey(config.KeyDir, zone.Name, true)
+	if err != nil {
+		return nil, err
+	}
+	zsk, err := loadKey(config.KeyDir, zone.Name, false)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := dns.NewSigner(zone, ksk, zsk)
+	if err != nil {
+		return nil, err
+	}
+	if config.SignatureValidity > 0 {
+		signer.Validity = config.SignatureValidity
+	}
+	denial := "NSEC"
+	if config.NSEC3 != nil {
+		salt, err := config.NSEC3.salt()
+		if err != nil {
+			return nil, err
+		}
+		if err := signer.UseNSEC3(config.NSEC3.Iterations, salt); err != nil {
+			return nil, fmt.Errorf("zone %s: %w", zone.Name, err)
+		}
+		denial = "NSEC3"
+	}
+	mode := "online"
+	if config.Presign {
+		if err := signer.SignAll(); err != nil {
+			return nil, fmt.Errorf("signing zone %s: %w", zone.Name, err)
+		}
+		mode = "presigned"
+	}
+
+	log.Printf("Zone %s signed with KSK %d and ZSK %d (%s, %s)", zone.Name, ksk.Tag(), zsk.Tag(), mode, denial)
+	return signer, nil
+}
+
+// signer returns the signer of a zone, or nil if it isn't signed
+func (s *Server) signer(zone string) *dns.Signer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.signers[zone]
+}
+
+// signedNegative builds an NXDOMAIN or NODATA response for a DNSSEC client:
+// the zone's SOA record and the NSEC records proving the answer, each with
+// its signature (RFC 4035 section 3.1.3)
+func (s *Server) signedNegative(query *dns.Message, builder *dns.Builder, zone *dns.Zone, signer *dns.Signer, rcode uint8) []byte {
+	name := query.Questions[0].Name
+	signed, err := signer.Sign(zone.Lookup(zone.Name, dns.TypeSOA))
+	if err == nil {
+		// The SOA record and its signature get the negative TTL. The
+		// signature still holds, as validators check it with the original
+		// TTL it was made with (RFC 4035 section 5.3.3).
+		if soa := zone.NegativeSOA(); len(soa) > 0 {
+			for i := range signed {
+				signed[i].TTL = soa[0].TTL
+			}
+		}
+		var denial []dns.ResourceRecord
+		if denial, err = signer.Sign(signer.Denial(name, rcode == dns.RcodeNameError)); err == nil {
+			signed = append(signed, denial...)
+		}
+	}
+	if err != nil {
+		log.Printf("Signing the denial for %s failed: %v", name, err)
+		atomic.AddUint64(&s.errors, 1)
+		return builder.BuildErrorResponse(query, dns.RcodeServerFailure)
+	}
+	response := builder.BuildNegativeResponse(query, rcode, signed)
+	if builder.Truncated {
+		atomic.AddUint64(&s.truncated, 1)
+	}
+	return response
+}
+
+// loadKey reads a zone's key signing key (ksk set) or zone signing key from
+// dir, named <zone>.ksk.pem or <zone>.zsk.pem. A key that isn't there is
+// generated and saved, readable only by us.
+func loadKey(dir, zone string, ksk bool) (*dns.Key, error) {
+	kind := "zsk"
+	if ksk {
+		kind = "ksk"
+	}
+	path := filepath.Join(dir, zone+"."+kind+".pem")
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err := dns.ParseKeyPEM(data, ksk)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", path, err)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	key, err := dns.GenerateKey(ksk)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = key.MarshalPEM(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	log.Printf("Generated %s %d for zone %s in %s", kind, key.Tag(), zone, path)
+	return key, nil
+}
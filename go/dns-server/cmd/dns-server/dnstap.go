@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/bellistech/dns-server/dns"
+)
+
+// dnstapQueueSize is how many frames wait for the collector; when it falls
+// behind more are dropped rather than slowing queries down
+const dnstapQueueSize = 10000
+
+// dnstapRetryInterval is how long to wait before connecting to the
+// collector again after failing to
+const dnstapRetryInterval = 5 * time.Second
+
+// dnstapOutput sends a dnstap query and response frame for every query to
+// a collector listening on a unix socket
+type dnstapOutput struct {
+	socket   string
+	identity string
+	version  string
+	frames   chan []byte
+	dropped  uint64        // Frames dropped with the queue full
+	done     chan struct{} // Closed when run returns
+}
+
+func newDnstapOutput(config *DnstapConfig) *dnstapOutput {
+	return &dnstapOutput{
+		socket:   config.Socket,
+		identity: config.Identity,
+		version:  config.Version,
+		frames:   make(chan []byte, dnstapQueueSize),
+		done:     make(chan struct{}),
+	}
+}
+
+// record queues the frames for a query and its responses (none for a
+// dropped query, several for a zone transfer)
+func (d *dnstapOutput) record(query []byte, responses [][]byte, client net.Addr, t transport, start time.Time) {
+	msg := dns.DnstapMessage{
+		Type:         dns.DnstapAuthQuery,
+		Protocol:     dnstapProtocol(t),
+		QueryTime:    start,
+		QueryMessage: query,
+	}
+	switch addr := client.(type) {
+	case *net.UDPAddr:
+		msg.QueryAddress, msg.QueryPort = addr.IP, uint16(addr.Port)
+	case *net.TCPAddr:
+		msg.QueryAddress, msg.QueryPort = addr.IP, uint16(addr.Port)
+	}
+	d.queue(msg.Marshal(d.identity, d.version))
+
+	msg.Type = dns.DnstapAuthResponse
+	msg.ResponseTime = time.Now()
+	for _, response := range responses {
+		msg.ResponseMessage = response
+		d.queue(msg.Marshal(d.identity, d.version))
+	}
+}
+
+// dnstapProtocol returns the dnstap socket protocol of a transport
+func dnstapProtocol(t transport) uint8 {
+	switch t {
+	case transportUDP:
+		return dns.DnstapUDP
+	case transportTLS:
+		return dns.DnstapDoT
+	case transportHTTPS:
+		return dns.DnstapDoH
+	default:
+		return dns.DnstapTCP
+	}
+}
+
+func (d *dnstapOutput) queue(frame []byte) {
+	select {
+	case d.frames <- frame:
+	default:
+		atomic.AddUint64(&d.dropped, 1)
+	}
+}
+
+// run connects to the collector and writes the queued frames until ctx is
+// done, connecting again whenever the connection fails
+func (d *dnstapOutput) run(ctx context.Context) {
+	defer close(d.done)
+	quiet := false // Failures to connect aren't logged again until connected
+	for {
+		connected, err := d.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			quiet = false
+		}
+		if !quiet {
+			log.Printf("dnstap: %s: %v; retrying every %v", d.socket, err, dnstapRetryInterval)
+			quiet = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(dnstapRetryInterval):
+		}
+	}
+}
+
+// wait waits up to timeout for run to end the session once its context is
+// done
+func (d *dnstapOutput) wait(timeout time.Duration) {
+	select {
+	case <-d.done:
+	case <-time.After(timeout):
+	}
+}
+
+// session runs one Frame Streams session with the collector, ending it
+// cleanly when ctx is done. connected reports whether the session got
+// started.
+func (d *dnstapOutput) session(ctx context.Context) (connected bool, err error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", d.socket)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(dnstapRetryInterval))
+	w, err := dns.NewFrameStreamWriter(conn, dns.DnstapContentType)
+	if err != nil {
+		return false, err
+	}
+	conn.SetDeadline(time.Time{})
+	log.Printf("dnstap: connected to %s", d.socket)
+
+	for {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now().Add(time.Second))
+			return true, w.Close()
+		case frame := <-d.frames:
+			if err := w.WriteFrame(frame); err != nil {
+				return true, err
+			}
+		}
+	}
+}
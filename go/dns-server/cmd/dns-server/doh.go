@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bellistech/dns-server/dns"
+)
+
+// DNS over HTTPS (RFC 8484)
+const (
+	// dohPath is where queries are sent: RFC 8484 leaves it to the server,
+	// and /dns-query is what clients are configured with by default
+	dohPath = "/dns-query"
+
+	// dohMediaType is the content type of queries and responses: a DNS
+	// message in wire format
+	dohMediaType = "application/dns-message"
+
+	// dohMaxMessage is the largest query accepted, the most a DNS message
+	// can be
+	dohMaxMessage = 65535
+)
+
+// newDoHServer returns the HTTP server for DNS over HTTPS. It speaks
+// HTTP/2 to clients offering it, HTTP/1.1 to the others.
+func newDoHServer(s *Server, tlsConfig *tls.Config, idleTimeout time.Duration) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(dohPath, s.handleDoH)
+	return &http.Server{
+		Handler:           mux,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: idleTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+}
+
+// handleDoH answers a query sent with GET, base64url-encoded in the dns
+// parameter, or with POST as the request body (RFC 8484 section 4.1)
+func (s *Server) handleDoH(w http.ResponseWriter, r *http.Request) {
+	var data []byte
+	switch r.Method {
+	case http.MethodGet:
+		param := r.URL.Query().Get("dns")
+		if param == "" {
+			http.Error(w, "missing dns parameter", http.StatusBadRequest)
+			return
+		}
+		// Without padding, though padding is tolerated
+		var err error
+		if data, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(param, "=")); err != nil {
+			http.Error(w, "dns parameter is not base64url", http.StatusBadRequest)
+			return
+		}
+	case http.MethodPost:
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != dohMediaType {
+			http.Error(w, "content type must be "+dohMediaType, http.StatusUnsupportedMediaType)
+			return
+		}
+		var err error
+		if data, err = io.ReadAll(http.MaxBytesReader(w, r.Body, dohMaxMessage)); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "query too large", http.StatusRequestEntityTooLarge)
+			}
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	client, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		http.Error(w, "bad client address", http.StatusInternalServerError)
+		return
+	}
+
+	// Like TCP, wait for a slot rather than drop the query
+	if s.inflight != nil {
+		s.inflight <- struct{}{}
+	}
+	builder := getBuilder()
+	defer putBuilder(builder)
+	responses := s.handleQuery(builder, data, client, client.IP, transportHTTPS)
+	if s.inflight != nil {
+		<-s.inflight
+	}
+	if len(responses) == 0 {
+		http.Error(w, "not a DNS query", http.StatusBadRequest)
+		return
+	}
+
+	response := responses[0]
+	w.Header().Set("Content-Type", dohMediaType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(response)))
+	if age, ok := dohMaxAge(response); ok {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", age))
+	}
+	w.Write(response)
+}
+
+// dohMaxAge returns how long HTTP caches may keep a response: no longer
+// than its records, the SOA record of a negative answer included, may be
+// cached (RFC 8484 section 5.1). ok is false for a response without
+// records.
+func dohMaxAge(response []byte) (age uint32, ok bool) {
+	msg, err := dns.NewParser(response).Parse()
+	if err != nil {
+		return 0, false
+	}
+	for _, rr := range append(msg.Answers, msg.Authority...) {
+		if !ok || rr.TTL < age {
+			age, ok = rr.TTL, true
+		}
+	}
+	return age, ok
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bellistech/dns-server/dns"
+)
+
+const (
+	// DefaultForwardTimeout is how long each upstream gets to answer
+	DefaultForwardTimeout = 2 * time.Second
+
+	// DefaultCacheSize is how many forwarded responses are cached
+	DefaultCacheSize = 10000
+
+	// DefaultHealthCheck is how often each upstream is checked
+	DefaultHealthCheck = 10 * time.Second
+
+	// upstreamBackoff is how long an upstream that failed is tried only
+	// after the others, so queries don't keep waiting on a dead one
+	upstreamBackoff = 30 * time.Second
+)
+
+// defaultForwardAllow is who may have queries forwarded when
+// forward.allow is empty: only this host, so the server isn't an open
+// resolver for anyone to use in amplification attacks
+var defaultForwardAllow = []string{"127.0.0.0/8", "::1"}
+
+// forwarder answers queries for names outside our zones by asking upstream
+// resolvers, and caches their responses
+type forwarder struct {
+	zone        string // Domain forwarded, "" for every name outside our zones
+	upstreams   []string
+	allow       acl
+	timeout     time.Duration // Per upstream
+	healthCheck time.Duration // How often the upstreams are checked (0 for never)
+	cache       *dns.Cache
+	next        atomic.Uint32  // Index of the upstream to try first, round robin
+	failed      []atomic.Int64 // Per upstream: when it last failed, in Unix nanoseconds
+}
+
+// newForwarders creates the forwarder of forward.upstreams (nil without
+// any) and one for each of forward.zones, which share its ACL and cache
+func newForwarders(config *ForwardConfig) (*forwarder, []*forwarder, error) {
+	networks := config.Allow
+	if len(networks) == 0 {
+		networks = defaultForwardAllow
+	}
+	allow, err := parseACL(networks)
+	if err != nil {
+		return nil, nil, err
+	}
+	cache := dns.NewCache(config.CacheSize)
+	newForwarder := func(zone string, upstreams []string) *forwarder {
+		return &forwarder{
+			zone:        zone,
+			upstreams:   upstreams,
+			allow:       allow,
+			timeout:     config.Timeout,
+			healthCheck: config.HealthCheck,
+			cache:       cache,
+			failed:      make([]atomic.Int64, len(upstreams)),
+		}
+	}
+
+	var f *forwarder
+	if len(config.Upstreams) > 0 {
+		f = newForwarder("", config.Upstreams)
+	}
+	var zones []*forwarder
+	for _, zc := range config.Zones {
+		zones = append(zones, newForwarder(strings.ToLower(strings.TrimSuffix(zc.Name, ".")), zc.Upstreams))
+	}
+	return f, zones, nil
+}
+
+// forwards reports whether the query may be forwarded: the client asked for
+// recursion and may have it
+func (f *forwarder) forwards(query *dns.Message, ip net.IP) bool {
+	return query.Header.Flags&dns.FlagRD != 0 && query.Questions[0].Class == dns.ClassIN && f.allow.Allows(ip)
+}
+
+// forwardZone returns the forwarder of the most specific forward zone name
+// is in, or nil
+func (s *Server) forwardZone(name string) *forwarder {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	var found *forwarder
+	for _, f := range s.forwardZones {
+		if (name == f.zone || strings.HasSuffix(name, "."+f.zone)) && (found == nil || len(f.zone) > len(found.zone)) {
+			found = f
+		}
+	}
+	return found
+}
+
+// errUpstreamFailed is an upstream's SERVFAIL or REFUSED, after which the
+// next upstream is tried
+var errUpstreamFailed = errors.New("upstream failed")
+
+// resolve returns the upstream response to a query for name and qtype, from
+// the cache if it is there. The upstreams take turns going first, so the
+// load is spread across them; if one can't be reached, times out or fails
+// the query, the next is tried, and it goes last for upstreamBackoff.
+func (f *forwarder) resolve(name string, qtype uint16) (msg *dns.Message, cached bool, err error) {
+	if rcode, answers, authority, ok := f.cache.Get(name, qtype); ok {
+		return &dns.Message{Header: dns.Header{Flags: dns.FlagQR | uint16(rcode)}, Answers: answers, Authority: authority}, true, nil
+	}
+
+	for _, i := range f.order() {
+		upstream := f.upstreams[i]
+		msg, err = f.exchange(upstream, name, qtype)
+		if err == nil {
+			f.cache.Put(name, qtype, msg)
+			return msg, false, nil
+		}
+		f.failed[i].Store(time.Now().UnixNano())
+		log.Printf("Forwarding %s %s to %s failed: %v", name, dns.TypeToString(qtype), upstream, err)
+	}
+	return nil, false, err
+}
+
+// order returns the indexes of the upstreams in the order to try them:
+// round robin, with those that failed recently moved to the end
+func (f *forwarder) order() []int {
+	first := int(f.next.Add(1) - 1)
+	recent := time.Now().Add(-upstreamBackoff).UnixNano()
+	var healthy, failed []int
+	for n := range f.upstreams {
+		i := (first + n) % len(f.upstreams)
+		if f.failed[i].Load() > recent {
+			failed = append(failed, i)
+		} else {
+			healthy = append(healthy, i)
+		}
+	}
+	return append(healthy, failed...)
+}
+
+// checkHealth asks every upstream each healthCheck interval, until ctx is
+// done, for the SOA record of the domain forwarded (the root's NS records
+// for forward.upstreams). One that fails goes last, as after a failed query,
+// and one that answers again takes its turn at once.
+func (f *forwarder) checkHealth(ctx context.Context) {
+	name, qtype := f.zone, dns.TypeSOA
+	if name == "" {
+		qtype = dns.TypeNS
+	}
+	ticker := time.NewTicker(f.healthCheck)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var wg sync.WaitGroup
+		for i, upstream := range f.upstreams {
+			wg.Add(1)
+			go func(i int, upstream string) {
+				defer wg.Done()
+				_, err := f.exchange(upstream, name, qtype)
+				down := f.failed[i].Load() > time.Now().Add(-upstreamBackoff).UnixNano()
+				switch {
+				case err != nil:
+					f.failed[i].Store(time.Now().UnixNano())
+					if !down {
+						log.Printf("Upstream %s%s failed its health check: %v", upstream, f.describe(), err)
+					}
+				case down:
+					f.failed[i].Store(0)
+					log.Printf("Upstream %s%s answers again", upstream, f.describe())
+				}
+			}(i, upstream)
+		}
+		wg.Wait()
+	}
+}
+
+// describe names the domain forwarded in log lines, if there is one
+func (f *forwarder) describe() string {
+	if f.zone == "" {
+		return ""
+	}
+	return " (" + f.zone + ")"
+}
+
+// exchange asks one upstream, over UDP and then TCP if the answer is
+// truncated
+func (f *forwarder) exchange(upstream, name string, qtype uint16) (*dns.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+	msg, err := dns.Exchange(ctx, upstream, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	switch rcode := msg.Rcode(); rcode {
+	case dns.RcodeNoError, dns.RcodeNameError:
+		return msg, nil
+	default:
+		return nil, fmt.Errorf("%w: rcode %d", errUpstreamFailed, rcode)
+	}
+}
+
+// handleForward answers a query for a name in none of our zones, or in a
+// forward zone, from f's upstream resolvers
+func (s *Server) handleForward(query *dns.Message, builder *dns.Builder, f *forwarder) []byte {
+	q := query.Questions[0]
+	builder.Recursive = true
+
+	msg, cached, err := f.resolve(q.Name, q.Type)
+	if err != nil {
+		atomic.AddUint64(&s.errors, 1)
+		s.logQuery("  -> SERVFAIL (no upstream answered)")
+		return builder.BuildErrorResponse(query, dns.RcodeServerFailure)
+	}
+	atomic.AddUint64(&s.forwarded, 1)
+	if cached {
+		atomic.AddUint64(&s.cacheHits, 1)
+	}
+
+	var response []byte
+	switch {
+	case msg.Rcode() == dns.RcodeNameError:
+		atomic.AddUint64(&s.nxdomain, 1)
+		s.logQuery("  -> NXDOMAIN (forwarded, cached %v)", cached)
+		response = builder.BuildNegativeResponse(query, dns.RcodeNameError, msg.Authority)
+	case len(msg.Answers) == 0:
+		atomic.AddUint64(&s.answers, 1)
+		s.logQuery("  -> NODATA (forwarded, cached %v)", cached)
+		response = builder.BuildNegativeResponse(query, dns.RcodeNoError, msg.Authority)
+	default:
+		atomic.AddUint64(&s.answers, 1)
+		s.logQuery("  -> %d record(s) (forwarded, cached %v)", len(msg.Answers), cached)
+		response = builder.BuildResponse(query, msg.Answers, msg.Authority)
+	}
+	if builder.Truncated {
+		atomic.AddUint64(&s.truncated, 1)
+	}
+	return response
+}
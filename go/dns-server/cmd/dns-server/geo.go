@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/bellistech/dns-server/dns"
+)
+
+// geoDB maps client addresses to the regions zones have variants for
+// ($REGION), by the longest network that contains them
+type geoDB struct {
+	v4, v6   geoTable
+	networks int
+}
+
+// geoTable holds the networks of one address family
+type geoTable struct {
+	lengths  []int                     // Prefix lengths present, longest first
+	networks map[int]map[string]string // Prefix length -> network address -> region
+}
+
+// loadGeoDB builds the database from config's CSV file, then its regions,
+// which take precedence for the same network
+func loadGeoDB(config *GeoConfig) (*geoDB, error) {
+	g := &geoDB{}
+	if config.Database != "" {
+		if err := g.load(config.Database); err != nil {
+			return nil, fmt.Errorf("%s: %w", config.Database, err)
+		}
+	}
+	for _, region := range sortedKeys(config.Regions) {
+		networks, err := parseACL(config.Regions[region])
+		if err != nil {
+			return nil, fmt.Errorf("region %s: %w", region, err)
+		}
+		for _, network := range networks {
+			g.add(network, strings.ToLower(region))
+		}
+	}
+	return g, nil
+}
+
+// load adds the networks of a CSV file of "network,region" lines, such as
+// a GeoIP database's networks with the region each country belongs to.
+// Further columns, a header line and lines starting with # are ignored.
+func (g *geoDB) load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	for first := true; ; first = false {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := r.FieldPos(0)
+		if len(record) < 2 || record[1] == "" {
+			return fmt.Errorf("line %d: want network,region", line)
+		}
+		_, network, err := net.ParseCIDR(record[0])
+		if err != nil {
+			if first {
+				continue // A header
+			}
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		g.add(network, strings.ToLower(record[1]))
+	}
+}
+
+func (g *geoDB) add(network *net.IPNet, region string) {
+	t := &g.v6
+	if network.IP.To4() != nil {
+		t = &g.v4
+		network = &net.IPNet{IP: network.IP.To4(), Mask: network.Mask[len(network.Mask)-net.IPv4len:]}
+	}
+	ones, _ := network.Mask.Size()
+	if t.networks == nil {
+		t.networks = make(map[int]map[string]string)
+	}
+	if t.networks[ones] == nil {
+		t.networks[ones] = make(map[string]string)
+		t.lengths = append(t.lengths, ones)
+		sort.Sort(sort.Reverse(sort.IntSlice(t.lengths)))
+	}
+	if _, ok := t.networks[ones][string(network.IP)]; !ok {
+		g.networks++
+	}
+	t.networks[ones][string(network.IP)] = region
+}
+
+// region returns the region of a client, or "" if no network contains it
+func (g *geoDB) region(ip net.IP) string {
+	return g.regionWithin(ip, 8*net.IPv6len)
+}
+
+// regionWithin returns the region of the longest network of at most
+// maxPrefix bits that contains ip, or "" if none does
+func (g *geoDB) regionWithin(ip net.IP, maxPrefix int) string {
+	t, bits := &g.v6, 8*net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		t, bits, ip = &g.v4, 8*net.IPv4len, ip4
+	}
+	for _, ones := range t.lengths {
+		if ones > maxPrefix {
+			continue
+		}
+		network := ip.Mask(net.CIDRMask(ones, bits))
+		if region, ok := t.networks[ones][string(network)]; ok {
+			return region
+		}
+	}
+	return ""
+}
+
+// longestPrefix returns the length of the longest network of ip's address
+// family: addresses that agree in that many bits are in the same region
+func (g *geoDB) longestPrefix(ip net.IP) int {
+	t := &g.v6
+	if ip.To4() != nil {
+		t = &g.v4
+	}
+	if len(t.lengths) == 0 {
+		return 0
+	}
+	return t.lengths[0]
+}
+
+// clientRegion returns the region of the client a query for zone is from,
+// or "" without GeoDNS or variants in the zone. A client subnet from the
+// query stands for the client, and gets the scope of the answer: as many
+// of its bits as the region depends on.
+func (s *Server) clientRegion(zone *dns.Zone, ip net.IP, subnet *dns.ClientSubnet) string {
+	if s.geo == nil || len(zone.Regions()) == 0 {
+		return ""
+	}
+	if subnet == nil || subnet.SourcePrefix == 0 {
+		// A prefix of 0 asks for the resolver's own address to be used
+		return s.geo.region(ip)
+	}
+	subnet.ScopePrefix = uint8(min(int(subnet.SourcePrefix), s.geo.longestPrefix(subnet.Address)))
+	return s.geo.regionWithin(subnet.Address, int(subnet.SourcePrefix))
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bellistech/dns-server/dns"
+)
+
+const (
+	// DefaultHealthInterval is how often the addresses with a health check
+	// are checked
+	DefaultHealthInterval = 10 * time.Second
+
+	// DefaultHealthTimeout is how long a check may take before the address
+	// counts as down
+	DefaultHealthTimeout = 2 * time.Second
+)
+
+// healthTarget is an address checked the way one or more records of a name
+// say
+type healthTarget struct {
+	name  string // Owner name, lower case: the Host of HTTP checks
+	addr  string
+	check dns.HealthCheck
+}
+
+// healthChecker checks the addresses of the A and AAAA records that have a
+// health check ($HEALTHCHECK in their zone file), and leaves those that
+// fail out of answers. The server becomes a simple failover load balancer:
+// clients are only given addresses that are up.
+type healthChecker struct {
+	server   *Server
+	interval time.Duration
+	timeout  time.Duration
+
+	mu     sync.RWMutex
+	status map[healthTarget]bool // Whether each target passed its last check
+}
+
+func newHealthChecker(s *Server, config *HealthCheckConfig) *healthChecker {
+	return &healthChecker{
+		server:   s,
+		interval: config.Interval,
+		timeout:  config.Timeout,
+		status:   make(map[healthTarget]bool),
+	}
+}
+
+// run checks every target straight away, then each interval until ctx is
+// done. The targets are found again for each round, so zones reloaded,
+// changed or transferred are checked as they are now.
+func (h *healthChecker) run(ctx context.Context) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		h.checkAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkAll checks every target at once, and logs those that go down or
+// come back up
+func (h *healthChecker) checkAll(ctx context.Context) {
+	targets := h.targets()
+	results := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target healthTarget) {
+			defer wg.Done()
+			results[i] = h.probe(ctx, target)
+		}(i, target)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	status := make(map[healthTarget]bool, len(targets))
+	h.mu.Lock()
+	for i, target := range targets {
+		up := results[i] == nil
+		was, checked := h.status[target]
+		switch {
+		case !up && (was || !checked):
+			log.Printf("Health check %s of %s (%s) failed: %v", target.check.String(), target.addr, target.name, results[i])
+		case up && checked && !was:
+			log.Printf("Health check %s of %s (%s) passes again", target.check.String(), target.addr, target.name)
+		}
+		status[target] = up
+	}
+	h.status = status
+	h.mu.Unlock()
+}
+
+// targets returns the addresses to check, from every zone served and its
+// regional variants
+func (h *healthChecker) targets() []healthTarget {
+	s := h.server
+	s.mu.RLock()
+	zones := make([]*dns.Zone, 0, len(s.zones))
+	for _, zone := range s.zones {
+		zones = append(zones, zone)
+	}
+	s.mu.RUnlock()
+
+	seen := make(map[healthTarget]bool)
+	var targets []healthTarget
+	add := func(records []dns.ResourceRecord) {
+		for _, rr := range records {
+			if target, ok := newHealthTarget(rr); ok && !seen[target] {
+				seen[target] = true
+				targets = append(targets, target)
+			}
+		}
+	}
+	for _, zone := range zones {
+		add(zone.AllRecords())
+		for _, region := range zone.Regions() {
+			add(zone.RegionalRecords(region))
+		}
+	}
+	return targets
+}
+
+// newHealthTarget returns what to check for an address record, or false if
+// it has no health check
+func newHealthTarget(rr dns.ResourceRecord) (healthTarget, bool) {
+	if rr.HealthCheck == nil || rr.Type != dns.TypeA && rr.Type != dns.TypeAAAA {
+		return healthTarget{}, false
+	}
+	return healthTarget{
+		name:  strings.ToLower(strings.TrimSuffix(rr.Name, ".")),
+		addr:  rr.Address.String(),
+		check: *rr.HealthCheck,
+	}, true
+}
+
+// probe checks one target: a TCP connection to its port, or an HTTP GET of
+// its path that answers with a 2xx or 3xx status
+func (h *healthChecker) probe(ctx context.Context, target healthTarget) error {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	addr := net.JoinHostPort(target.addr, strconv.Itoa(int(target.check.Port)))
+
+	if target.check.Protocol == dns.HealthCheckTCP {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+target.check.Path, nil)
+	if err != nil {
+		return err
+	}
+	req.Host = target.name
+	client := http.Client{
+		// A redirect is an answer; whatever it points to isn't checked
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		Transport:     &http.Transport{DisableKeepAlives: true},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("HTTP status %s", resp.Status)
+	}
+	return nil
+}
+
+// down reports whether rr's address failed its last health check. Addresses
+// not checked yet count as up.
+func (h *healthChecker) down(rr dns.ResourceRecord) bool {
+	target, ok := newHealthTarget(rr)
+	if !ok {
+		return false
+	}
+	up, checked := h.status[target]
+	return checked && !up
+}
+
+// filter returns records without the addresses that failed their health
+// check, unless every address of their RRset did: answering with all of
+// them then gives clients a chance, where answering with none gives them
+// nothing to try. The zone's RRsets are shared, so records is copied
+// rather than changed.
+func (h *healthChecker) filter(records []dns.ResourceRecord) []dns.ResourceRecord {
+	if h == nil {
+		return records
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var down []bool
+	for i, rr := range records {
+		if h.down(rr) {
+			if down == nil {
+				down = make([]bool, len(records))
+			}
+			down[i] = true
+		}
+	}
+	if down == nil {
+		return records
+	}
+
+	// RRsets with an address up keep only those
+	up := make(map[string]bool)
+	for i, rr := range records {
+		if !down[i] {
+			up[strings.ToLower(rr.Name)+" "+dns.TypeToString(rr.Type)] = true
+		}
+	}
+	filtered := make([]dns.ResourceRecord, 0, len(records))
+	for i, rr := range records {
+		if !down[i] || !up[strings.ToLower(rr.Name)+" "+dns.TypeToString(rr.Type)] {
+			filtered = append(filtered, rr)
+		}
+	}
+	return filtered
+}
@@ -3,111 +3,453 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/bellistech/dns-server/dns"
 )
 
 // Server represents the DNS server
 type Server struct {
-	zones   map[string]*dns.Zone
-	mu      sync.RWMutex
-	builder *dns.Builder
+	zones map[string]*dns.Zone
+	mu    sync.RWMutex
+	dns64 *dns.DNS64 // AAAA synthesis for NAT64 clients (nil if disabled)
 
-	udpConn4 *net.UDPConn
-	udpConn6 *net.UDPConn
+	forwarder    *forwarder   // Upstreams for names outside our zones (nil if disabled)
+	forwardZones []*forwarder // Upstreams for the forward zones' names
+
+	order  answerOrder    // Of the A and AAAA records in answers
+	geo    *geoDB         // Regions of clients, for the zones' variants (nil if disabled)
+	health *healthChecker // Addresses left out of answers while down (nil if disabled)
+
+	queryACL   queryACL               // Clients that may query any zone
+	zoneACLs   map[string]queryACL    // Zone name -> clients that may query it (everyone if absent)
+	transfers  map[string]acl         // Zone name -> clients that may AXFR it (none if absent)
+	notify     map[string][]string    // Zone name -> secondaries to NOTIFY of changes
+	signers    map[string]*dns.Signer // Zone name -> DNSSEC signer (signed zones only)
+	logQueries bool                   // Log every query and its outcome
+	queryLog   *queryLog              // Structured log of answered queries (nil if disabled)
+	dnstap     *dnstapOutput          // dnstap collector of queries and responses (nil if disabled)
+	metrics    *metrics               // Served on the admin API's /metrics (nil without the admin API)
+	udpSize    uint16                 // EDNS UDP payload size we advertise and send at most
+	identity   identity               // Answers to the CHAOS identity queries
+	inflight   chan struct{}          // One slot per query being handled (nil for no limit)
+	workers    *queryWorkers          // Answer the UDP queries
+
+	udpConns []*net.UDPConn
+	tcp      *tcpServer   // TCP listeners and connections (nil if disabled)
+	dot      *tcpServer   // DNS over TLS listeners and connections (nil if disabled)
+	doh      *http.Server // DNS over HTTPS (nil if disabled)
+
+	secondaries []*secondary // Zones kept up to date from their primaries, catalogs' members too (guarded by mu)
+
+	primaries         []*primaryZone // Zones loaded from files, reloaded when they change
+	adminChanges      acl            // Who may change them through the admin API
+	zoneCheckInterval time.Duration  // How often zone files are checked for changes (0 for only on SIGHUP)
+	reloadMu          sync.Mutex     // Held while reloading zones
 
 	// Statistics
-	queries  uint64
-	answers  uint64
-	nxdomain uint64
-	errors   uint64
+	queries   uint64
+	answers   uint64
+	nxdomain  uint64
+	errors    uint64
+	dropped   uint64      // Over limits.max_concurrent_queries or max_tcp_connections
+	truncated uint64      // UDP responses too large for the client, sent with TC
+	forwarded uint64      // Queries answered from upstream resolvers
+	cacheHits uint64      // Forwarded queries answered from the cache
+	refused   uint64      // Queries from clients the query ACLs don't allow
+	stats     *queryStats // Top query names and client prefixes
 }
 
 // NewServer creates a new DNS server
 func NewServer() *Server {
 	return &Server{
-		zones:   make(map[string]*dns.Zone),
-		builder: dns.NewBuilder(),
+		zones:      make(map[string]*dns.Zone),
+		zoneACLs:   make(map[string]queryACL),
+		transfers:  make(map[string]acl),
+		notify:     make(map[string][]string),
+		signers:    make(map[string]*dns.Signer),
+		stats:      newQueryStats(DefaultTopK),
+		logQueries: true,
+		udpSize:    dns.DefaultEDNSUDPSize,
+	}
+}
+
+// Configure applies a validated configuration and loads its zones
+func (s *Server) Configure(config *Config) error {
+	s.stats = newQueryStats(config.Admin.TopK)
+	// The structured query log takes the place of the per-query lines
+	s.logQueries = config.Logging.Queries && config.Logging.QueryLog == nil
+	s.udpSize = config.Limits.EDNSUDPSize
+	s.zoneCheckInterval = config.ZoneCheckInterval
+
+	s.order.mode = config.AnswerOrder
+	s.identity = newIdentity(config.Identity)
+	if config.HealthChecks.Interval > 0 {
+		s.health = newHealthChecker(s, &config.HealthChecks)
+	}
+
+	if config.Geo != nil {
+		geo, err := loadGeoDB(config.Geo)
+		if err != nil {
+			return fmt.Errorf("geo: %w", err)
+		}
+		s.geo = geo
+		log.Printf("GeoDNS enabled with %d network(s)", geo.networks)
+	}
+
+	if config.DNS64 != "" {
+		dns64, err := dns.NewDNS64(config.DNS64)
+		if err != nil {
+			return err
+		}
+		s.dns64 = dns64
+		log.Printf("DNS64 enabled with prefix %s", dns64.Prefix())
+	}
+
+	if len(config.Forward.Upstreams) > 0 || len(config.Forward.Zones) > 0 {
+		forwarder, zones, err := newForwarders(&config.Forward)
+		if err != nil {
+			return err
+		}
+		s.forwarder, s.forwardZones = forwarder, zones
+		if forwarder != nil {
+			log.Printf("Forwarding to %s", strings.Join(forwarder.upstreams, ", "))
+		}
+		for _, f := range zones {
+			log.Printf("Forwarding %s to %s", f.zone, strings.Join(f.upstreams, ", "))
+		}
+	}
+
+	if ql := config.Logging.QueryLog; ql != nil {
+		queryLog, err := newQueryLog(ql)
+		if err != nil {
+			return fmt.Errorf("query log: %w", err)
+		}
+		s.queryLog = queryLog
+		log.Printf("Logging queries to %s as %s, 1 in %d", ql.File, queryLog.format, queryLog.sample)
+	}
+
+	if config.Admin.Listen != "" {
+		s.metrics = newMetrics()
+	}
+	networks := config.Admin.AllowChanges
+	if len(networks) == 0 {
+		networks = defaultAdminAllowChanges
+	}
+	adminChanges, err := parseACL(networks)
+	if err != nil {
+		return fmt.Errorf("admin.allow_changes: %w", err)
+	}
+	s.adminChanges = adminChanges
+
+	if dc := config.Dnstap; dc != nil {
+		s.dnstap = newDnstapOutput(dc)
+		if s.dnstap.identity == "" {
+			s.dnstap.identity, _ = os.Hostname()
+		}
+		log.Printf("Sending dnstap to %s", dc.Socket)
 	}
+
+	queryACL, err := parseQueryACL(config.ACL.AllowQuery, config.ACL.DenyQuery)
+	if err != nil {
+		return err
+	}
+	s.queryACL = queryACL
+
+	if n := config.Limits.MaxConcurrentQueries; n > 0 {
+		s.inflight = make(chan struct{}, n)
+	}
+	s.workers = newQueryWorkers(s, config.Limits.QueryWorkers)
+
+	if config.Listen.TCP {
+		s.tcp = newTCPServer(s, config.Limits.TCPIdleTimeout, config.Limits.MaxTCPConnections, nil)
+	}
+	if config.Listen.TLS != nil {
+		tlsConfig, err := newTLSConfig(config.Listen.TLS, dotALPN)
+		if err != nil {
+			return err
+		}
+		idleTimeout := config.Listen.TLS.IdleTimeout
+		if idleTimeout == 0 {
+			idleTimeout = DefaultTLSIdleTimeout
+		}
+		s.dot = newTCPServer(s, idleTimeout, config.Limits.MaxTCPConnections, tlsConfig)
+	}
+	if config.Listen.HTTPS != nil {
+		tlsConfig, err := newTLSConfig(config.Listen.HTTPS, "h2", "http/1.1")
+		if err != nil {
+			return err
+		}
+		idleTimeout := config.Listen.HTTPS.IdleTimeout
+		if idleTimeout == 0 {
+			idleTimeout = DefaultTLSIdleTimeout
+		}
+		s.doh = newDoHServer(s, tlsConfig, idleTimeout)
+	}
+
+	// Built-in zones first, so a zone file with the same name replaces them
+	if config.LocalhostZones {
+		for _, zone := range dns.LocalhostZones() {
+			s.AddZone(zone)
+			log.Printf("Serving built-in zone: %s", zone.Name)
+		}
+	}
+
+	files := make(map[string]string) // Zone name -> file it came from
+	for _, zc := range config.Zones {
+		var name string
+		if zc.Type == ZoneSecondary || zc.Type == ZoneCatalog {
+			// Served once the first transfer succeeds
+			name = strings.ToLower(strings.TrimSuffix(zc.Name, "."))
+			z := newSecondary(s, name, zc.Primaries)
+			if zc.Type == ZoneCatalog {
+				z.catalog = newCatalog(s, name, zc.Primaries)
+				log.Printf("Catalog zone: %s from %s", name, strings.Join(zc.Primaries, ", "))
+			} else {
+				log.Printf("Secondary zone: %s from %s", name, strings.Join(zc.Primaries, ", "))
+			}
+			s.secondaries = append(s.secondaries, z)
+		} else {
+			zone, err := s.LoadZone(zc.File)
+			if err != nil {
+				return err
+			}
+			name = zone.Name
+			if other, ok := files[name]; ok {
+				return fmt.Errorf("zone %s is in both %s and %s", name, other, zc.File)
+			}
+			files[name] = zc.File
+			s.primaries = append(s.primaries, newPrimaryZone(name, zc))
+			if zc.DNSSEC != nil {
+				signer, err := s.signZone(zone, zc.DNSSEC)
+				if err != nil {
+					return err
+				}
+				s.mu.Lock()
+				s.signers[name] = signer
+				s.mu.Unlock()
+				soa := zone.Lookup(zone.Name, dns.TypeSOA)
+				log.Printf("DS record for the parent zone: %s", signer.KSK().DS(zone.Name, soa[0].TTL))
+			}
+		}
+
+		allowTransfer, err := parseACL(zc.AllowTransfer)
+		if err != nil {
+			return err
+		}
+		if len(allowTransfer) > 0 {
+			s.mu.Lock()
+			s.transfers[name] = allowTransfer
+			s.mu.Unlock()
+			log.Printf("Zone %s may be transferred by %d network(s)", name, len(allowTransfer))
+		}
+		zoneACL, err := parseQueryACL(zc.AllowQuery, zc.DenyQuery)
+		if err != nil {
+			return err
+		}
+		if !zoneACL.empty() {
+			s.mu.Lock()
+			s.zoneACLs[name] = zoneACL
+			s.mu.Unlock()
+			allowed := "everyone"
+			if len(zoneACL.allow) > 0 {
+				allowed = fmt.Sprintf("%d network(s)", len(zoneACL.allow))
+			}
+			log.Printf("Zone %s may be queried by %s, except %d network(s)", name, allowed, len(zoneACL.deny))
+		}
+		if len(zc.Notify) > 0 {
+			s.mu.Lock()
+			s.notify[name] = zc.Notify
+			s.mu.Unlock()
+		}
+	}
+
+	s.mu.RLock()
+	served := len(s.zones)
+	s.mu.RUnlock()
+	log.Printf("Serving %d zone(s), %d secondary zone(s) waiting for their first transfer", served, len(s.secondaries))
+	return nil
 }
 
 // LoadZone loads a zone file
-func (s *Server) LoadZone(filename string) error {
+func (s *Server) LoadZone(filename string) (*dns.Zone, error) {
 	zone, err := dns.LoadZoneFile(filename)
 	if err != nil {
-		return fmt.Errorf("loading %s: %w", filename, err)
+		return nil, fmt.Errorf("loading %s: %w", filename, err)
 	}
 
+	s.AddZone(zone)
+	serial := "no SOA record"
+	if zone.SOA != nil {
+		serial = fmt.Sprintf("serial %d", zone.SOA.Serial)
+	}
+	log.Printf("Loaded zone %s from %s: %d records, %s", zone.Name, filename, len(zone.AllRecords()), serial)
+	if regions := zone.Regions(); len(regions) > 0 && s.geo == nil {
+		log.Printf("Zone %s has variants for %s, which aren't served without geo", zone.Name, strings.Join(regions, ", "))
+	}
+	return zone, nil
+}
+
+// AddZone serves zone, replacing any zone with the same name
+func (s *Server) AddZone(zone *dns.Zone) {
 	s.mu.Lock()
 	s.zones[zone.Name] = zone
 	s.mu.Unlock()
+}
 
-	log.Printf("Loaded zone: %s", zone.Name)
-	return nil
+// RemoveZone stops serving the zone called name
+func (s *Server) RemoveZone(name string) {
+	s.mu.Lock()
+	delete(s.zones, name)
+	s.mu.Unlock()
 }
 
-// Start starts the DNS server
-func (s *Server) Start(ctx context.Context, addr4, addr6 string) error {
+// Start starts the DNS server, with TCP listeners on the same addresses as
+// the UDP ones if listen.TCP is set, DNS over TLS and HTTPS listeners if
+// listen.TLS and listen.HTTPS are, and keeps the secondary zones up to date
+func (s *Server) Start(ctx context.Context, listen ListenConfig) error {
+	addr4, addr6 := listen.IPv4, listen.IPv6
 	var wg sync.WaitGroup
 
-	// Start IPv4 listener
-	if addr4 != "" {
-		udpAddr4, err := net.ResolveUDPAddr("udp4", addr4)
-		if err != nil {
-			return fmt.Errorf("resolve IPv4: %w", err)
+	s.mu.RLock()
+	for _, z := range s.secondaries {
+		go z.run(ctx)
+	}
+	s.mu.RUnlock()
+	if s.zoneCheckInterval > 0 && len(s.primaries) > 0 {
+		go s.watchZones(ctx, s.zoneCheckInterval)
+	}
+	for _, f := range append([]*forwarder{s.forwarder}, s.forwardZones...) {
+		if f != nil && f.healthCheck > 0 {
+			go f.checkHealth(ctx)
 		}
+	}
+	if s.dnstap != nil {
+		go s.dnstap.run(ctx)
+	}
+	if s.health != nil {
+		go s.health.run(ctx)
+	}
 
-		s.udpConn4, err = net.ListenUDP("udp4", udpAddr4)
+	s.workers.start(ctx)
+	workers := udpWorkers(listen.UDPWorkers)
+	serveUDP := func(conns []*net.UDPConn) {
+		s.udpConns = append(s.udpConns, conns...)
+		for _, conn := range conns {
+			wg.Add(1)
+			go func(conn *net.UDPConn) {
+				defer wg.Done()
+				s.serveUDP(ctx, conn)
+			}(conn)
+		}
+	}
+
+	// Start IPv4 listener
+	if addr4 != "" {
+		conns, err := listenUDP("udp4", addr4, workers)
 		if err != nil {
 			return fmt.Errorf("listen IPv4: %w", err)
 		}
 
-		log.Printf("Listening on IPv4 %s", addr4)
+		log.Printf("Listening on IPv4 %s (%d UDP socket(s))", addr4, len(conns))
+		serveUDP(conns)
 
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			s.serveUDP(ctx, s.udpConn4)
-		}()
+		if s.tcp != nil {
+			if err := s.tcp.listen(ctx, &wg, "tcp4", addr4); err != nil {
+				return fmt.Errorf("listen IPv4 TCP: %w", err)
+			}
+			log.Printf("Listening on IPv4 %s (TCP)", addr4)
+		}
 	}
 
 	// Start IPv6 listener
 	if addr6 != "" {
-		udpAddr6, err := net.ResolveUDPAddr("udp6", addr6)
+		conns, err := listenUDP("udp6", addr6, workers)
 		if err != nil {
-			return fmt.Errorf("resolve IPv6: %w", err)
+			return fmt.Errorf("listen IPv6: %w", err)
 		}
 
-		s.udpConn6, err = net.ListenUDP("udp6", udpAddr6)
-		if err != nil {
-			return fmt.Errorf("listen IPv6: %w", err)
+		log.Printf("Listening on IPv6 %s (%d UDP socket(s))", addr6, len(conns))
+		serveUDP(conns)
+
+		if s.tcp != nil {
+			if err := s.tcp.listen(ctx, &wg, "tcp6", addr6); err != nil {
+				return fmt.Errorf("listen IPv6 TCP: %w", err)
+			}
+			log.Printf("Listening on IPv6 %s (TCP)", addr6)
+		}
+	}
+
+	if s.dot != nil {
+		for _, l := range []struct{ network, addr, name string }{
+			{"tcp4", listen.TLS.IPv4, "IPv4"},
+			{"tcp6", listen.TLS.IPv6, "IPv6"},
+		} {
+			if l.addr == "" {
+				continue
+			}
+			if err := s.dot.listen(ctx, &wg, l.network, l.addr); err != nil {
+				return fmt.Errorf("listen %s TLS: %w", l.name, err)
+			}
+			log.Printf("Listening on %s %s (TLS)", l.name, l.addr)
 		}
+	}
 
-		log.Printf("Listening on IPv6 %s", addr6)
+	if s.doh != nil {
+		for _, l := range []struct{ network, addr, name string }{
+			{"tcp4", listen.HTTPS.IPv4, "IPv4"},
+			{"tcp6", listen.HTTPS.IPv6, "IPv6"},
+		} {
+			if l.addr == "" {
+				continue
+			}
+			ln, err := net.Listen(l.network, l.addr)
+			if err != nil {
+				return fmt.Errorf("listen %s HTTPS: %w", l.name, err)
+			}
+			log.Printf("Listening on %s %s (HTTPS, %s)", l.name, l.addr, dohPath)
 
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			s.serveUDP(ctx, s.udpConn6)
-		}()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := s.doh.ServeTLS(ln, "", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					log.Printf("DNS over HTTPS error: %v", err)
+				}
+			}()
+		}
 	}
 
+	// Secondaries may have missed changes while we were down. Secondary
+	// zones notify theirs once transferred.
+	s.mu.RLock()
+	for name := range s.notify {
+		if zone := s.zones[name]; zone != nil {
+			s.notifySecondaries(ctx, zone)
+		}
+	}
+	s.mu.RUnlock()
+
 	wg.Wait()
 	return nil
 }
 
 func (s *Server) serveUDP(ctx context.Context, conn *net.UDPConn) {
-	buffer := make([]byte, 512)
+	// Room for the largest datagram: with EDNS, queries can be bigger
+	// than 512 bytes
+	buffer := make([]byte, 65535)
 
 	for {
 		select {
@@ -127,68 +469,382 @@ func (s *Server) serveUDP(ctx context.Context, conn *net.UDPConn) {
 			}
 		}
 
-		// Copy data for goroutine
-		data := make([]byte, n)
-		copy(data, buffer[:n])
+		// Drop the query if too many are being handled already; the
+		// client will retry
+		if s.inflight != nil {
+			select {
+			case s.inflight <- struct{}{}:
+			default:
+				atomic.AddUint64(&s.dropped, 1)
+				continue
+			}
+		}
+
+		// Copy data for a worker, or drop it if they are all behind
+		if !s.workers.enqueue(conn, clientAddr, buffer[:n]) && s.inflight != nil {
+			<-s.inflight
+		}
+	}
+}
+
+// transport is how a query arrived, which decides how large its response
+// may be and whether it may be a zone transfer
+type transport int
+
+const (
+	transportUDP   transport = iota
+	transportTCP             // A stream of messages
+	transportTLS             // Like TCP, over TLS
+	transportHTTPS           // One response per HTTP request
+)
 
-		// Handle in goroutine for concurrency
-		go s.handleQuery(conn, clientAddr, data)
+func (t transport) String() string {
+	switch t {
+	case transportUDP:
+		return "UDP"
+	case transportTCP:
+		return "TCP"
+	case transportTLS:
+		return "TLS"
+	default:
+		return "HTTPS"
 	}
 }
 
-func (s *Server) handleQuery(conn *net.UDPConn, clientAddr *net.UDPAddr, data []byte) {
+// handleQuery answers one query from client (whose address is ip), over
+// transport t, with builder. It returns the messages to send: usually one,
+// several for a zone transfer, or none. They may be builder's buffer, so
+// are written before it builds anything else.
+func (s *Server) handleQuery(builder *dns.Builder, data []byte, client net.Addr, ip net.IP, t transport) [][]byte {
+	logged := s.queryLog != nil && s.queryLog.sampled()
+	if !logged && s.metrics == nil && s.dnstap == nil {
+		return s.answer(builder, data, client, ip, t)
+	}
+	start := time.Now()
+	responses := s.answer(builder, data, client, ip, t)
+	latency := time.Since(start)
+
+	if logged || s.metrics != nil {
+		if o, ok := summarize(responses); ok {
+			if logged {
+				s.queryLog.record(start, latency, ip, t, o)
+			}
+			if s.metrics != nil {
+				var zone string
+				if z := s.findZone(o.name); z != nil && o.name != "" {
+					zone = z.Name
+				}
+				s.metrics.recordQuery(o, zone, t, latency.Seconds())
+			}
+		}
+	}
+	if s.dnstap != nil {
+		s.dnstap.record(data, responses, client, t, start)
+	}
+	return responses
+}
+
+// answer is handleQuery without the query log, metrics and dnstap
+func (s *Server) answer(builder *dns.Builder, data []byte, client net.Addr, ip net.IP, t transport) [][]byte {
 	atomic.AddUint64(&s.queries, 1)
 
-	// Parse query
+	builder.EDNSUDPSize = s.udpSize
+	builder.UDP = t == transportUDP
+
+	// Parse query. One that can't be is answered with FORMERR from its
+	// header, unless that is a response: answering those could loop.
 	parser := dns.NewParser(data)
 	query, err := parser.Parse()
 	if err != nil {
-		log.Printf("Parse error from %s: %v", clientAddr, err)
 		atomic.AddUint64(&s.errors, 1)
-		return
+		header, headerErr := dns.ParseHeader(data)
+		if headerErr != nil || header.Flags&dns.FlagQR != 0 {
+			log.Printf("Parse error from %s: %v", client, err)
+			return nil
+		}
+		log.Printf("Parse error from %s, answered FORMERR: %v", client, err)
+		return [][]byte{builder.BuildErrorResponse(&dns.Message{Header: *header}, dns.RcodeFormatError)}
+	}
+	if query.Header.Flags&dns.FlagQR != 0 {
+		atomic.AddUint64(&s.errors, 1)
+		log.Printf("Response from %s dropped: only queries are answered", client)
+		return nil
 	}
 
-	if len(query.Questions) == 0 {
-		return
+	// Queries have exactly one question (RFC 9619)
+	if len(query.Questions) != 1 {
+		atomic.AddUint64(&s.errors, 1)
+		s.logQuery("Query from %s with %d questions -> FORMERR", client, len(query.Questions))
+		return [][]byte{builder.BuildErrorResponse(query, dns.RcodeFormatError)}
 	}
 
 	q := query.Questions[0]
-	log.Printf("Query from %s: %s %s", clientAddr, q.Name, dns.TypeToString(q.Type))
+	if query.Opcode() == dns.OpcodeNotify {
+		s.logQuery("NOTIFY from %s: %s", client, q.Name)
+	} else {
+		s.logQuery("Query from %s: %s %s", client, q.Name, dns.TypeToString(q.Type))
+	}
+	s.stats.record(q.Name, ip)
 
-	// Find zone
+	edns, err := query.EDNS()
+	if err != nil {
+		log.Printf("Bad EDNS from %s: %v", client, err)
+		atomic.AddUint64(&s.errors, 1)
+		return [][]byte{builder.BuildErrorResponse(query, dns.RcodeFormatError)}
+	}
+	if edns != nil && edns.Version > 0 {
+		// Only EDNS version 0 exists so far (RFC 6891 section 6.1.3)
+		s.logQuery("  -> BADVERS (EDNS version %d)", edns.Version)
+		return [][]byte{builder.BuildErrorResponse(query, dns.RcodeBadVersion)}
+	}
+
+	// A resolver may say which network it asks for (RFC 7871); that is
+	// where the client is when the answer depends on it, and the option is
+	// echoed with how much of the network the answer holds for
+	var subnet *dns.ClientSubnet
+	if edns != nil {
+		if subnet, err = edns.ClientSubnet(); err != nil {
+			log.Printf("Bad EDNS client subnet from %s: %v", client, err)
+			atomic.AddUint64(&s.errors, 1)
+			return [][]byte{builder.BuildErrorResponse(query, dns.RcodeFormatError)}
+		}
+		builder.ClientSubnet = subnet
+	}
+
+	switch query.Opcode() {
+	case dns.OpcodeQuery:
+	case dns.OpcodeNotify:
+		// From a primary rather than a client, so outside acl.allow_query
+		return [][]byte{s.handleNotify(query, builder, ip)}
+	default:
+		s.logQuery("  -> NOTIMP (opcode %d)", query.Opcode())
+		return [][]byte{builder.BuildErrorResponse(query, dns.RcodeNotImplemented)}
+	}
+
+	if !s.queryACL.Allows(ip) {
+		atomic.AddUint64(&s.refused, 1)
+		s.logQuery("  -> REFUSED (acl)")
+		return [][]byte{builder.BuildErrorResponse(query, dns.RcodeRefused)}
+	}
+
+	// The zones are all class IN; CHAOS holds only the server's identity
+	if q.Class == dns.ClassCH {
+		return [][]byte{s.handleChaos(query, builder)}
+	}
+
+	// Find zone. A forward zone takes the names in it, unless they are in
+	// a zone of ours below it.
 	zone := s.findZone(q.Name)
+	if fz := s.forwardZone(q.Name); fz != nil && q.Type != dns.TypeAXFR && (zone == nil || len(fz.zone) > len(zone.Name)) {
+		if fz.forwards(query, ip) {
+			return [][]byte{s.handleForward(query, builder, fz)}
+		}
+		return [][]byte{builder.BuildErrorResponse(query, dns.RcodeRefused)}
+	}
+	if zone != nil && !s.zoneACL(zone.Name).Allows(ip) {
+		atomic.AddUint64(&s.refused, 1)
+		s.logQuery("  -> REFUSED (acl of zone %s)", zone.Name)
+		return [][]byte{builder.BuildErrorResponse(query, dns.RcodeRefused)}
+	}
+
+	if q.Type == dns.TypeAXFR {
+		return s.handleTransfer(query, builder, ip, t)
+	}
+
 	if zone == nil {
-		// Not authoritative
-		response := s.builder.BuildErrorResponse(query, dns.RcodeRefused)
-		conn.WriteToUDP(response, clientAddr)
-		return
+		// Not authoritative: forwarded if the client asked for recursion
+		// and may have it
+		if s.forwarder != nil && s.forwarder.forwards(query, ip) {
+			return [][]byte{s.handleForward(query, builder, s.forwarder)}
+		}
+		return [][]byte{builder.BuildErrorResponse(query, dns.RcodeRefused)}
+	}
+
+	// DNSSEC records go to clients that set the DO bit (RFC 4035 section
+	// 3.1); the DNSKEY and NSEC records themselves to anyone asking
+	signer := s.signer(zone.Name)
+	dnssecOK := signer != nil && edns != nil && edns.DO
+
+	// Names at and below a delegation are the child zone's, so the client
+	// is referred there; the DS records at the cut are ours to answer
+	if cut, ns := zone.Delegation(q.Name); cut != "" && !(q.Type == dns.TypeDS && strings.EqualFold(strings.TrimSuffix(q.Name, "."), cut)) {
+		return [][]byte{s.handleReferral(query, builder, zone, signer, dnssecOK, cut, ns)}
 	}
 
-	// Lookup records
-	records := zone.Lookup(q.Name, q.Type)
+	// Lookup records, the variants for the client's region if there are
+	// any, and the addresses a CNAME leads to within the zone, without
+	// those failing their health check
+	var records []dns.ResourceRecord
+	region := s.clientRegion(zone, ip, subnet)
+	if q.Type == dns.TypeANY {
+		records = zone.LookupAny(q.Name)
+	} else if region != "" {
+		records = zone.LookupRegion(q.Name, q.Type, region)
+	} else {
+		records = zone.Lookup(q.Name, q.Type)
+	}
+	records = s.health.filter(zone.Chase(records, q.Type, region))
+	if len(records) == 0 && signer != nil {
+		records = signer.Lookup(q.Name, q.Type)
+	}
 
-	if len(records) == 0 && !zone.HasName(q.Name) {
+	// DNS64: answer an AAAA query that would be NODATA with addresses
+	// synthesized from the name's A records
+	synthesized := false
+	if len(records) == 0 && q.Type == dns.TypeAAAA && s.dns64 != nil {
+		var negativeTTL uint32
+		if zone.SOA != nil {
+			negativeTTL = zone.SOA.Minimum
+		}
+		records = s.dns64.Synthesize(s.health.filter(zone.Lookup(q.Name, dns.TypeA)), negativeTTL)
+		synthesized = len(records) > 0
+	}
+	records = s.order.apply(records)
+
+	// Names with nothing but names below them (empty non-terminals) exist
+	// too, and the NSEC3 chain of a signed zone says so
+	if len(records) == 0 && !zone.HasName(q.Name) && (signer == nil || !signer.Exists(q.Name)) {
 		// NXDOMAIN
 		atomic.AddUint64(&s.nxdomain, 1)
-		response := s.builder.BuildErrorResponse(query, dns.RcodeNameError)
-		conn.WriteToUDP(response, clientAddr)
-		log.Printf("  -> NXDOMAIN")
-		return
+		s.logQuery("  -> NXDOMAIN")
+		if dnssecOK {
+			return [][]byte{s.signedNegative(query, builder, zone, signer, dns.RcodeNameError)}
+		}
+		return [][]byte{s.negative(query, builder, zone, dns.RcodeNameError)}
 	}
 
 	// Build response
 	atomic.AddUint64(&s.answers, 1)
 
+	if len(records) == 0 {
+		s.logQuery("  -> NODATA")
+		if dnssecOK {
+			return [][]byte{s.signedNegative(query, builder, zone, signer, dns.RcodeNoError)}
+		}
+		return [][]byte{s.negative(query, builder, zone, dns.RcodeNoError)}
+	}
+
 	// Get NS records for authority section
 	nsRecords := zone.Lookup(zone.Name, dns.TypeNS)
+	answerCount := len(records)
+	if dnssecOK {
+		var err error
+		if records, err = signer.Sign(records); err == nil {
+			nsRecords, err = signer.Sign(nsRecords)
+		}
+		if err != nil {
+			log.Printf("Signing the answer for %s failed: %v", q.Name, err)
+			atomic.AddUint64(&s.errors, 1)
+			return [][]byte{builder.BuildErrorResponse(query, dns.RcodeServerFailure)}
+		}
+	}
 
-	response := s.builder.BuildResponse(query, records, nsRecords)
-	conn.WriteToUDP(response, clientAddr)
+	// Over UDP a response that doesn't fit what the client can receive
+	// comes back with the TC bit set and no records, so the client retries
+	// over TCP
+	response := builder.BuildResponse(query, records, nsRecords)
+	if builder.Truncated {
+		atomic.AddUint64(&s.truncated, 1)
+		s.logQuery("  -> truncated (%d records over %d bytes)", len(records), builder.MaxSize(query))
+		return [][]byte{response}
+	}
 
-	if len(records) > 0 {
-		log.Printf("  -> %d record(s)", len(records))
+	if synthesized {
+		s.logQuery("  -> %d record(s) synthesized by DNS64", answerCount)
 	} else {
-		log.Printf("  -> NODATA")
+		s.logQuery("  -> %d record(s)", answerCount)
+	}
+	return [][]byte{response}
+}
+
+// negative builds an NXDOMAIN or NODATA response with the zone's SOA record
+// in the authority section, at the negative TTL: resolvers cache the answer
+// for as long as that record (RFC 2308 section 5)
+func (s *Server) negative(query *dns.Message, builder *dns.Builder, zone *dns.Zone, rcode uint8) []byte {
+	response := builder.BuildNegativeResponse(query, rcode, zone.NegativeSOA())
+	if builder.Truncated {
+		atomic.AddUint64(&s.truncated, 1)
+	}
+	return response
+}
+
+// handleReferral refers a query for a name in a child zone to the child's
+// name servers, with glue for those inside our zone. The DS records at the
+// cut go along, so resolvers can validate the child; for a DNSSEC client
+// signed, or if there are none, with the NSEC or NSEC3 record proving that
+// the delegation is unsigned (RFC 4035 section 3.1.4).
+func (s *Server) handleReferral(query *dns.Message, builder *dns.Builder, zone *dns.Zone, signer *dns.Signer, dnssecOK bool, cut string, ns []dns.ResourceRecord) []byte {
+	authority := append([]dns.ResourceRecord(nil), ns...)
+	ds := zone.Lookup(cut, dns.TypeDS)
+	if dnssecOK {
+		proof := ds
+		if len(ds) == 0 {
+			proof = signer.Denial(cut, false)
+		}
+		signed, err := signer.Sign(proof)
+		if err != nil {
+			log.Printf("Signing the referral to %s failed: %v", cut, err)
+			atomic.AddUint64(&s.errors, 1)
+			return builder.BuildErrorResponse(query, dns.RcodeServerFailure)
+		}
+		authority = append(authority, signed...)
+	} else {
+		authority = append(authority, ds...)
+	}
+
+	atomic.AddUint64(&s.answers, 1)
+	response := builder.BuildReferral(query, authority, zone.Glue(ns))
+	if builder.Truncated {
+		atomic.AddUint64(&s.truncated, 1)
+		s.logQuery("  -> truncated (referral to %s)", cut)
+		return response
+	}
+	s.logQuery("  -> referral to %s (%d DS)", cut, len(ds))
+	return response
+}
+
+// handleTransfer answers an AXFR query with the whole zone, if the query is
+// for the name of a zone we serve and the client may transfer it. Transfers
+// only run over TCP (RFC 5936 section 4.2), or TLS.
+func (s *Server) handleTransfer(query *dns.Message, builder *dns.Builder, ip net.IP, t transport) [][]byte {
+	if t != transportTCP && t != transportTLS {
+		s.logQuery("  -> NOTIMP (AXFR needs TCP)")
+		return [][]byte{builder.BuildErrorResponse(query, dns.RcodeNotImplemented)}
+	}
+
+	name := joinLabels(splitLabels(strings.ToLower(query.Questions[0].Name)))
+	s.mu.RLock()
+	zone := s.zones[name]
+	allowTransfer := s.transfers[name]
+	s.mu.RUnlock()
+
+	if zone == nil {
+		// Only whole zones are transferred, not a subdomain of one
+		s.logQuery("  -> REFUSED (not a zone)")
+		return [][]byte{builder.BuildErrorResponse(query, dns.RcodeRefused)}
+	}
+	if len(allowTransfer) == 0 || !allowTransfer.Allows(ip) {
+		s.logQuery("  -> REFUSED (zone transfer not allowed)")
+		return [][]byte{builder.BuildErrorResponse(query, dns.RcodeRefused)}
+	}
+
+	messages, err := builder.BuildTransfer(query, zone, dns.TransferMessageSize)
+	s.recordTransfer(zone.Name, "out", err == nil)
+	if err != nil {
+		log.Printf("AXFR of %s failed: %v", zone.Name, err)
+		atomic.AddUint64(&s.errors, 1)
+		return [][]byte{builder.BuildErrorResponse(query, dns.RcodeServerFailure)}
+	}
+
+	atomic.AddUint64(&s.answers, 1)
+	s.logQuery("  -> AXFR of %s in %d message(s)", zone.Name, len(messages))
+	return messages
+}
+
+// logQuery logs a query or its outcome, unless query logging is off
+func (s *Server) logQuery(format string, args ...any) {
+	if s.logQueries {
+		log.Printf(format, args...)
 	}
 }
 
@@ -211,6 +867,13 @@ func (s *Server) findZone(name string) *dns.Zone {
 	return nil
 }
 
+// zoneACL returns the clients that may query a zone
+func (s *Server) zoneACL(zone string) queryACL {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.zoneACLs[zone]
+}
+
 func splitLabels(name string) []string {
 	name = strings.TrimSuffix(name, ".")
 	if name == "" {
@@ -223,43 +886,216 @@ func joinLabels(labels []string) string {
 	return strings.Join(labels, ".")
 }
 
-// Stop stops the server and prints statistics
+// Stop stops the server
 func (s *Server) Stop() {
-	if s.udpConn4 != nil {
-		s.udpConn4.Close()
+	// The process ends once the listeners are closed, so the dnstap
+	// session is ended first
+	if s.dnstap != nil {
+		s.dnstap.wait(time.Second)
+		if n := atomic.LoadUint64(&s.dnstap.dropped); n > 0 {
+			log.Printf("dnstap: %d frames dropped while the collector fell behind or was away", n)
+		}
 	}
-	if s.udpConn6 != nil {
-		s.udpConn6.Close()
+	for _, conn := range s.udpConns {
+		conn.Close()
 	}
+	if s.tcp != nil {
+		s.tcp.close()
+	}
+	if s.dot != nil {
+		s.dot.close()
+	}
+	if s.doh != nil {
+		s.doh.Close()
+	}
+	if s.queryLog != nil {
+		s.queryLog.Close()
+	}
+}
 
-	log.Printf("Statistics: queries=%d, answers=%d, nxdomain=%d, errors=%d",
-		atomic.LoadUint64(&s.queries),
-		atomic.LoadUint64(&s.answers),
-		atomic.LoadUint64(&s.nxdomain),
-		atomic.LoadUint64(&s.errors))
+// listFlag is a flag that may be given more than once, collecting each
+// value
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
 }
 
 func main() {
+	configFile := flag.String("config", "", "YAML config file (see configs/dns-server.yaml); replaces the other flags")
+	check := flag.Bool("check-config", false, "Validate the -config file and its zone files, then exit")
 	addr4 := flag.String("4", ":5353", "IPv4 listen address (empty to disable)")
 	addr6 := flag.String("6", "[::]:5353", "IPv6 listen address (empty to disable)")
-	zoneFile := flag.String("zone", "", "Zone file to load (required)")
+	tcp := flag.Bool("tcp", true, "Also listen on TCP, on the same addresses")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate for DNS over TLS; with -tls-key, enables it (empty to disable)")
+	tlsKey := flag.String("tls-key", "", "PEM private key of the -tls-cert certificate")
+	tls4 := flag.String("tls-4", ":853", "IPv4 DNS over TLS listen address (empty to disable)")
+	tls6 := flag.String("tls-6", "[::]:853", "IPv6 DNS over TLS listen address (empty to disable)")
+	https4 := flag.String("https-4", "", "IPv4 DNS over HTTPS listen address, e.g. :443, with the -tls-cert certificate (empty to disable)")
+	https6 := flag.String("https-6", "", "IPv6 DNS over HTTPS listen address, e.g. [::]:443, with the -tls-cert certificate (empty to disable)")
+	var zoneFiles listFlag
+	flag.Var(&zoneFiles, "zone", "Zone file to load; repeat for more zones (this, -zone-dir, -config or -forward is required)")
+	zoneDir := flag.String("zone-dir", "", "Directory to load every *.zone file of")
+	zoneCheck := flag.Duration("zone-check-interval", 0, "How often to check zone files for changes and reload them, e.g. 10s (0 reloads only on SIGHUP)")
+	localhostZones := flag.Bool("localhost-zones", false, "Serve built-in localhost, 127.in-addr.arpa and ::1 reverse zones")
+	adminAddr := flag.String("admin", "", "Admin HTTP API listen address, e.g. 127.0.0.1:8053 (empty to disable)")
+	queryLogFile := flag.String("query-log", "", "File to log every query to as JSON, with its outcome, size and latency (empty to disable)")
+	dnstapSocket := flag.String("dnstap", "", "Unix socket of a dnstap collector to send every query and response to (empty to disable)")
+	topK := flag.Int("top-k", DefaultTopK, "Query names and client prefixes tracked for the admin API's /stats")
+	allowTransfer := flag.String("allow-transfer", "", "Comma-separated networks that may transfer the -zone and -zone-dir zones with AXFR (empty allows no one)")
+	notify := flag.String("notify", "", "Comma-separated secondaries to send a NOTIFY for the -zone and -zone-dir zones at startup")
+	dnssecKeys := flag.String("dnssec-keys", "", "Directory with the -zone and -zone-dir zones' DNSSEC keys, generated if missing; signs the zones (empty to disable)")
+	forward := flag.String("forward", "", "Comma-separated upstream resolvers to forward queries for names outside our zones to (empty to disable)")
+	var forwardZones listFlag
+	flag.Var(&forwardZones, "forward-zone", "Domain whose queries go to its own upstreams, as <domain>=<addr>[,<addr>...]; repeat for more domains")
+	geoDB := flag.String("geo-db", "", "CSV file of network,region lines to answer clients with their region's $REGION variants (empty to disable)")
+	answerOrder := flag.String("answer-order", AnswerOrderRotate, "Order of A and AAAA records in answers: rotate, random or fixed")
+	udpWorkersFlag := flag.Int("udp-workers", 0, "UDP sockets per listen address sharing it with SO_REUSEPORT, each read by its own goroutine, on Linux (0 for one per CPU)")
+	identityVersion := flag.String("identity-version", "", "Answer to version.bind and version.server CHAOS TXT queries (empty refuses them)")
+	identityID := flag.String("identity-id", "", "Answer to id.server and hostname.bind CHAOS TXT queries (empty refuses them)")
+	dns64Prefix := flag.String("dns64", "", "NAT64 prefix to synthesize AAAA answers from A records with, e.g. "+dns.WellKnownDNS64Prefix+" (empty to disable)")
 	flag.Parse()
 
-	if *zoneFile == "" {
-		fmt.Fprintln(os.Stderr, "Error: Zone file required (-zone)")
-		fmt.Fprintln(os.Stderr, "Usage: dns-server -zone <zonefile> [-4 <addr>] [-6 <addr>]")
-		fmt.Fprintln(os.Stderr, "\nExample:")
-		fmt.Fprintln(os.Stderr, "  dns-server -zone zones/example.com.zone")
-		fmt.Fprintln(os.Stderr, "  dns-server -zone zones/example.com.zone -4 :53 -6 \"\"")
-		os.Exit(1)
+	if *check {
+		if *configFile == "" {
+			fmt.Fprintln(os.Stderr, "Error: -check-config needs -config")
+			os.Exit(2)
+		}
+		if err := checkConfig(*configFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s: configuration OK\n", *configFile)
+		return
 	}
 
-	server := NewServer()
+	var config *Config
+	if *configFile != "" {
+		// Settings come from one place only, so a flag can't quietly
+		// override the file
+		var extra []string
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name != "config" {
+				extra = append(extra, "-"+f.Name)
+			}
+		})
+		if len(extra) > 0 {
+			fmt.Fprintf(os.Stderr, "Error: %s can't be combined with -config; use the config file's settings instead\n", strings.Join(extra, ", "))
+			os.Exit(2)
+		}
 
-	if err := server.LoadZone(*zoneFile); err != nil {
+		var err error
+		if config, err = LoadConfig(*configFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		if *zoneDir != "" {
+			files, err := filepath.Glob(filepath.Join(*zoneDir, "*.zone"))
+			if err == nil && len(files) == 0 {
+				err = fmt.Errorf("no *.zone files in %s", *zoneDir)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: -zone-dir: %v\n", err)
+				os.Exit(1)
+			}
+			zoneFiles = append(zoneFiles, files...)
+		}
+		if len(zoneFiles) == 0 && *forward == "" && len(forwardZones) == 0 {
+			fmt.Fprintln(os.Stderr, "Error: Zone files (-zone or -zone-dir), upstreams (-forward or -forward-zone) or config file (-config) required")
+			fmt.Fprintln(os.Stderr, "Usage: dns-server -zone <zonefile> [-zone <zonefile>...] [-zone-dir <dir>] [-zone-check-interval <duration>] [-4 <addr>] [-6 <addr>] [-tcp=false] [-udp-workers <n>] [-tls-cert <file> -tls-key <file> [-https-4 <addr>]] [-localhost-zones] [-admin <addr>] [-query-log <file>] [-dnstap <socket>] [-dns64 <prefix>] [-answer-order <order>] [-geo-db <file>] [-identity-version <text>] [-identity-id <text>] [-allow-transfer <networks>] [-notify <addrs>] [-dnssec-keys <dir>] [-forward <addrs>] [-forward-zone <domain>=<addrs>...]")
+			fmt.Fprintln(os.Stderr, "       dns-server -config <file> [-check-config]")
+			fmt.Fprintln(os.Stderr, "\nExample:")
+			fmt.Fprintln(os.Stderr, "  dns-server -zone zones/example.com.zone")
+			fmt.Fprintln(os.Stderr, "  dns-server -zone zones/example.com.zone -4 :53 -6 \"\"")
+			fmt.Fprintln(os.Stderr, "  dns-server -zone-dir zones")
+			fmt.Fprintln(os.Stderr, "  dns-server -forward 9.9.9.9,149.112.112.112")
+			fmt.Fprintln(os.Stderr, "  dns-server -zone zones/example.com.zone -forward-zone corp.internal=10.0.0.53")
+			fmt.Fprintln(os.Stderr, "  dns-server -config configs/dns-server.yaml")
+			os.Exit(1)
+		}
+
+		config = DefaultConfig()
+		config.Listen = ListenConfig{IPv4: *addr4, IPv6: *addr6, TCP: *tcp, UDPWorkers: *udpWorkersFlag}
+		if (*tlsCert != "" || *tlsKey != "") && (*tls4 != "" || *tls6 != "") {
+			config.Listen.TLS = &TLSConfig{IPv4: *tls4, IPv6: *tls6, CertFile: *tlsCert, KeyFile: *tlsKey}
+		}
+		if *https4 != "" || *https6 != "" {
+			config.Listen.HTTPS = &TLSConfig{IPv4: *https4, IPv6: *https6, CertFile: *tlsCert, KeyFile: *tlsKey}
+		}
+		for _, file := range zoneFiles {
+			zc := ZoneConfig{File: file}
+			if *allowTransfer != "" {
+				zc.AllowTransfer = strings.Split(*allowTransfer, ",")
+			}
+			if *notify != "" {
+				zc.Notify = strings.Split(*notify, ",")
+			}
+			if *dnssecKeys != "" {
+				zc.DNSSEC = &DNSSECConfig{KeyDir: *dnssecKeys}
+			}
+			config.Zones = append(config.Zones, zc)
+		}
+		config.ZoneCheckInterval = *zoneCheck
+		config.LocalhostZones = *localhostZones
+		config.DNS64 = *dns64Prefix
+		config.AnswerOrder = *answerOrder
+		config.Identity = IdentityConfig{Version: *identityVersion, ID: *identityID}
+		if *geoDB != "" {
+			config.Geo = &GeoConfig{Database: *geoDB}
+		}
+		if *forward != "" {
+			config.Forward.Upstreams = strings.Split(*forward, ",")
+		}
+		for _, fz := range forwardZones {
+			name, upstreams, _ := strings.Cut(fz, "=")
+			zc := ForwardZoneConfig{Name: name}
+			if upstreams != "" {
+				zc.Upstreams = strings.Split(upstreams, ",")
+			}
+			config.Forward.Zones = append(config.Forward.Zones, zc)
+		}
+		config.Admin = AdminConfig{Listen: *adminAddr, TopK: *topK}
+		if *dnstapSocket != "" {
+			config.Dnstap = &DnstapConfig{Socket: *dnstapSocket}
+		}
+		if *queryLogFile != "" {
+			config.Logging.QueryLog = &QueryLogConfig{File: *queryLogFile}
+		}
+		if err := config.Validate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid flags:\n%v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if config.Logging.File != "" {
+		f, err := os.OpenFile(config.Logging.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			log.Fatalf("Failed to open log file: %v", err)
+		}
+		defer f.Close()
+		log.SetOutput(f)
+	}
+
+	server := NewServer()
+	if err := server.Configure(config); err != nil {
 		log.Fatalf("Failed to load zone: %v", err)
 	}
 
+	if config.Admin.Listen != "" {
+		go func() {
+			log.Printf("Admin API listening on %s", config.Admin.Listen)
+			if err := http.ListenAndServe(config.Admin.Listen, server.AdminHandler()); err != nil {
+				log.Printf("Admin API error: %v", err)
+			}
+		}()
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	// Handle shutdown signals
@@ -273,8 +1109,18 @@ func main() {
 		server.Stop()
 	}()
 
+	// SIGHUP loads the zone files again
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			log.Printf("Received SIGHUP, reloading zones...")
+			server.ReloadZones(ctx, true)
+		}
+	}()
+
 	log.Println("DNS Server starting...")
-	if err := server.Start(ctx, *addr4, *addr6); err != nil {
+	if err := server.Start(ctx, config.Listen); err != nil {
 		log.Fatalf("Server error: %v", err)
 	}
 }
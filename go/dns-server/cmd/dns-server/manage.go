@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bellistech/dns-server/dns"
+)
+
+// defaultAdminAllowChanges is who may change zones through the admin API
+// when admin.allow_changes is empty: only this host
+var defaultAdminAllowChanges = []string{"127.0.0.0/8", "::1"}
+
+// defaultRecordTTL is the TTL of a record added without one
+const defaultRecordTTL = 3600
+
+// errNoRecords is a delete that matched nothing
+var errNoRecords = errors.New("no matching records")
+
+// handleRecords adds (POST, a recordJSON body) or deletes (DELETE, by name
+// and type, and data if given) records of a zone. A record posted with the
+// name, type and data of one already there replaces it, so its TTL, comment
+// or weight can be changed.
+func (s *Server) handleRecords(w http.ResponseWriter, r *http.Request, p *primaryZone) {
+	switch r.Method {
+	case http.MethodPost:
+		var req recordJSON
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			http.Error(w, "invalid record: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		rr, err := parseRecordJSON(req, p.name)
+		if err != nil {
+			http.Error(w, "invalid record: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		replaced := false
+		zone, err := s.changeZone(p, func(records []dns.ResourceRecord) ([]dns.ResourceRecord, error) {
+			for i, old := range records {
+				if strings.EqualFold(old.Name, rr.Name) && old.Type == rr.Type && old.RDataString() == rr.RDataString() {
+					rr.HealthCheck = old.HealthCheck
+					records[i] = rr
+					replaced = true
+					return records, nil
+				}
+			}
+			return append(records, rr), nil
+		})
+		if err != nil {
+			s.changeFailed(w, p, err)
+			return
+		}
+		if replaced {
+			log.Printf("Admin: replaced %s in zone %s (serial %d) for %s", describeRecord(rr), p.name, zone.SOA.Serial, r.RemoteAddr)
+		} else {
+			log.Printf("Admin: added %s to zone %s (serial %d) for %s", describeRecord(rr), p.name, zone.SOA.Serial, r.RemoteAddr)
+			w.WriteHeader(http.StatusCreated)
+		}
+		writeJSON(w, map[string]interface{}{"zone": p.name, "serial": zone.SOA.Serial, "record": toRecordJSON(rr)})
+
+	case http.MethodDelete:
+		q := r.URL.Query()
+		name := absoluteName(q.Get("name"), p.name)
+		qtype := dns.StringToType(strings.ToUpper(q.Get("type")))
+		if q.Get("name") == "" || qtype == 0 {
+			http.Error(w, "name and a known type are required", http.StatusBadRequest)
+			return
+		}
+		if qtype == dns.TypeSOA {
+			http.Error(w, "the SOA record can't be deleted", http.StatusBadRequest)
+			return
+		}
+		data := q.Get("data")
+		var deleted []dns.ResourceRecord
+		zone, err := s.changeZone(p, func(records []dns.ResourceRecord) ([]dns.ResourceRecord, error) {
+			kept := records[:0]
+			for _, rr := range records {
+				if strings.EqualFold(rr.Name, name) && rr.Type == qtype && (data == "" || rr.RDataString() == data) {
+					deleted = append(deleted, rr)
+				} else {
+					kept = append(kept, rr)
+				}
+			}
+			if len(deleted) == 0 {
+				return nil, errNoRecords
+			}
+			return kept, nil
+		})
+		if err != nil {
+			s.changeFailed(w, p, err)
+			return
+		}
+		removed := make([]recordJSON, 0, len(deleted))
+		for _, rr := range deleted {
+			log.Printf("Admin: deleted %s from zone %s (serial %d) for %s", describeRecord(rr), p.name, zone.SOA.Serial, r.RemoteAddr)
+			removed = append(removed, toRecordJSON(rr))
+		}
+		writeJSON(w, map[string]interface{}{"zone": p.name, "serial": zone.SOA.Serial, "deleted": removed})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleReload loads a zone's file again straight away, as SIGHUP does for
+// every zone
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request, p *primaryZone) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	if info, err := os.Stat(p.config.File); err == nil {
+		p.modTime = info.ModTime()
+	}
+	if err := s.reloadZone(context.Background(), p); err != nil {
+		log.Printf("Reloading zone %s from %s failed; still serving the loaded zone: %v", p.name, p.config.File, err)
+		http.Error(w, "reload failed: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	s.mu.RLock()
+	zone := s.zones[p.name]
+	s.mu.RUnlock()
+	result := map[string]interface{}{"zone": p.name, "records": len(zone.AllRecords())}
+	if zone.SOA != nil {
+		result["serial"] = zone.SOA.Serial
+	}
+	writeJSON(w, result)
+}
+
+// changeZone applies change to the records of a primary zone (all but its
+// SOA record and regional variants, which are kept), then serves the
+// result with the serial one higher, writes it to the zone's file and
+// notifies the secondaries. The zone being served is never modified:
+// queries see the old version or the new one.
+func (s *Server) changeZone(p *primaryZone, change func([]dns.ResourceRecord) ([]dns.ResourceRecord, error)) (*dns.Zone, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	s.mu.RLock()
+	old := s.zones[p.name]
+	s.mu.RUnlock()
+	if old == nil || old.SOA == nil {
+		return nil, fmt.Errorf("zone %s has no SOA record to give a new serial", p.name)
+	}
+
+	var soa dns.ResourceRecord
+	var records []dns.ResourceRecord
+	for _, rr := range old.AllRecords() {
+		if rr.Type == dns.TypeSOA {
+			soa = rr
+		} else {
+			records = append(records, rr)
+		}
+	}
+	records, err := change(records)
+	if err != nil {
+		return nil, err
+	}
+
+	zone := dns.NewZone(old.Name)
+	serial := *old.SOA
+	serial.Serial++
+	soa.SOAData = &serial
+	zone.AddRecord(soa)
+	for _, rr := range records {
+		zone.AddRecord(rr)
+	}
+	for _, region := range old.Regions() {
+		for _, rr := range old.RegionalRecords(region) {
+			zone.AddRegionalRecord(region, rr)
+		}
+	}
+
+	// Written first, so a change that can't be kept isn't served
+	if err := writeZoneFile(p.config.File, zone); err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(p.config.File); err == nil {
+		p.modTime = info.ModTime()
+	}
+	if _, err := s.installZone(context.Background(), p, zone); err != nil {
+		return nil, err
+	}
+	return zone, nil
+}
+
+// changeFailed reports a zone change that wasn't made
+func (s *Server) changeFailed(w http.ResponseWriter, p *primaryZone, err error) {
+	if errors.Is(err, errNoRecords) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	log.Printf("Admin: changing zone %s failed: %v", p.name, err)
+	http.Error(w, "changing the zone failed: "+err.Error(), http.StatusInternalServerError)
+}
+
+// writeZoneFile replaces a zone file with the zone, through a temporary
+// file so the zone file is never seen half written
+func writeZoneFile(path string, zone *dns.Zone) error {
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := zone.Export(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// parseRecordJSON builds a record of zone from the admin API's
+// representation. Names are relative to the zone unless they end in a dot.
+func parseRecordJSON(req recordJSON, zone string) (dns.ResourceRecord, error) {
+	if req.Name == "" || req.Type == "" || req.Data == "" {
+		return dns.ResourceRecord{}, fmt.Errorf("name, type and data are required")
+	}
+	name := absoluteName(req.Name, zone)
+	if name != zone && !strings.HasSuffix(name, "."+zone) {
+		return dns.ResourceRecord{}, fmt.Errorf("%s is not in zone %s", name, zone)
+	}
+	if strings.EqualFold(req.Type, "SOA") {
+		return dns.ResourceRecord{}, fmt.Errorf("the SOA record can't be added; its serial is kept by the server")
+	}
+	ttl := req.TTL
+	if ttl == 0 {
+		ttl = defaultRecordTTL
+	}
+	rr, err := dns.ParseRecord(fmt.Sprintf("%s. %d IN %s %s", name, ttl, req.Type, req.Data), zone, ttl)
+	if err != nil {
+		return rr, err
+	}
+	rr.Comment = req.Comment
+	if req.Weight != 0 {
+		if rr.Type != dns.TypeA && rr.Type != dns.TypeAAAA {
+			return rr, fmt.Errorf("only A and AAAA records have a weight")
+		}
+		rr.Weight = req.Weight
+	}
+	return rr, nil
+}
+
+// absoluteName returns name, relative to zone unless it ends in a dot or is
+// "@", in lower case without the trailing dot
+func absoluteName(name, zone string) string {
+	switch {
+	case name == "@":
+		name = zone
+	case strings.HasSuffix(name, "."):
+		name = strings.TrimSuffix(name, ".")
+	default:
+		name = name + "." + zone
+	}
+	return strings.ToLower(name)
+}
+
+// describeRecord describes a record on one line for the log
+func describeRecord(rr dns.ResourceRecord) string {
+	s := fmt.Sprintf("%s %d %s %s", rr.Name, rr.TTL, dns.TypeToString(rr.Type), rr.RDataString())
+	if rr.Weight != 0 {
+		s += fmt.Sprintf(" (weight %d)", rr.Weight)
+	}
+	return s
+}
+
+// primaryZone returns the zone loaded from a file named name, or nil
+func (s *Server) primaryZone(name string) *primaryZone {
+	for _, p := range s.primaries {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// mayChange reports whether the client of an admin request may change
+// zones
+func (s *Server) mayChange(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	return s.adminChanges.Allows(net.ParseIP(host))
+}
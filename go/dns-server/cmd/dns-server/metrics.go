@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bellistech/dns-server/dns"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the response latency
+// histogram: answers from memory take microseconds, forwarded ones up to
+// the forwarding timeout
+var latencyBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// queryOutcome is what the query log and the metrics record of an answered
+// query, read back from its responses
+type queryOutcome struct {
+	name    string
+	qtype   uint16
+	rcode   uint8 // Extended response codes included
+	answers int   // Of every message for a zone transfer
+	size    int   // Bytes, of every message
+}
+
+// summarize reads the outcome of a query from its responses. ok is false
+// if there are none (the query was dropped). The FORMERR answer to a query
+// that couldn't be parsed has no question, so no name or type.
+func summarize(responses [][]byte) (o queryOutcome, ok bool) {
+	if len(responses) == 0 {
+		return o, false
+	}
+	msg, err := dns.NewParser(responses[0]).Parse()
+	if err != nil {
+		return o, false
+	}
+	if len(msg.Questions) > 0 {
+		o.name, o.qtype = msg.Questions[0].Name, msg.Questions[0].Type
+	}
+	o.rcode = msg.Rcode()
+	if edns, err := msg.EDNS(); err == nil && edns != nil {
+		o.rcode |= edns.ExtendedRcode << 4
+	}
+	for _, response := range responses {
+		o.size += len(response)
+		if len(response) >= 8 {
+			o.answers += int(binary.BigEndian.Uint16(response[6:8]))
+		}
+	}
+	return o, true
+}
+
+// queryKey counts queries by type and response code
+type queryKey struct {
+	qtype uint16
+	rcode uint8
+}
+
+// transferKey counts zone transfers
+type transferKey struct {
+	zone      string
+	direction string // "out" to a secondary, "in" from a primary
+	result    string // "success" or "failure"
+}
+
+// metrics are the counters and histograms exposed on /metrics, beyond the
+// server's own statistics
+type metrics struct {
+	mu        sync.Mutex
+	queries   map[queryKey]uint64
+	zones     map[string]uint64 // Queries per zone
+	transfers map[transferKey]uint64
+	latency   map[transport]*histogram
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		queries:   make(map[queryKey]uint64),
+		zones:     make(map[string]uint64),
+		transfers: make(map[transferKey]uint64),
+		latency:   make(map[transport]*histogram),
+	}
+}
+
+// recordQuery counts an answered query; zone is empty for names outside our
+// zones
+func (m *metrics) recordQuery(o queryOutcome, zone string, t transport, seconds float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queries[queryKey{o.qtype, o.rcode}]++
+	if zone != "" {
+		m.zones[zone]++
+	}
+	h := m.latency[t]
+	if h == nil {
+		h = newHistogram(latencyBuckets)
+		m.latency[t] = h
+	}
+	h.observe(seconds)
+}
+
+// rcodes returns the number of queries answered with each response code
+func (m *metrics) rcodes() map[string]uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts := make(map[string]uint64)
+	for k, n := range m.queries {
+		counts[dns.RcodeToString(k.rcode)] += n
+	}
+	return counts
+}
+
+// recordTransfer counts a zone transfer
+func (m *metrics) recordTransfer(zone, direction string, ok bool) {
+	result := "success"
+	if !ok {
+		result = "failure"
+	}
+	m.mu.Lock()
+	m.transfers[transferKey{zone, direction, result}]++
+	m.mu.Unlock()
+}
+
+// recordTransfer counts a zone transfer, if metrics are kept
+func (s *Server) recordTransfer(zone, direction string, ok bool) {
+	if s.metrics != nil {
+		s.metrics.recordTransfer(zone, direction, ok)
+	}
+}
+
+// histogram counts observations into cumulative buckets, as Prometheus
+// histograms do
+type histogram struct {
+	bounds []float64
+	counts []uint64 // Per bucket, not cumulative; one more for +Inf
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+// handleMetrics serves the metrics in the Prometheus text format
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Written out in full before any is sent, so a slow client doesn't
+	// hold the locks queries need
+	var buf bytes.Buffer
+	s.writeMetrics(&buf)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// writeMetrics writes every metric, sorted by labels so the output is
+// stable
+func (s *Server) writeMetrics(w io.Writer) {
+	counter := func(name, help string, v *uint64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, atomic.LoadUint64(v))
+	}
+	counter("dns_queries_received_total", "Queries received, answered or not.", &s.queries)
+	counter("dns_answers_total", "Queries answered with records, NODATA or a referral.", &s.answers)
+	counter("dns_nxdomain_total", "Queries answered with NXDOMAIN.", &s.nxdomain)
+	counter("dns_errors_total", "Queries that couldn't be parsed or answered.", &s.errors)
+	counter("dns_dropped_total", "Queries and TCP connections dropped over the limits.", &s.dropped)
+	counter("dns_truncated_total", "UDP responses sent truncated.", &s.truncated)
+	counter("dns_forwarded_total", "Queries answered from upstream resolvers.", &s.forwarded)
+	counter("dns_cache_hits_total", "Forwarded queries answered from the cache.", &s.cacheHits)
+	counter("dns_refused_total", "Queries refused by the query ACLs.", &s.refused)
+
+	m := s.metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP dns_queries_total Queries answered, by type and response code.\n# TYPE dns_queries_total counter\n")
+	queries := make([]queryKey, 0, len(m.queries))
+	for k := range m.queries {
+		queries = append(queries, k)
+	}
+	sort.Slice(queries, func(i, j int) bool {
+		if queries[i].qtype != queries[j].qtype {
+			return queries[i].qtype < queries[j].qtype
+		}
+		return queries[i].rcode < queries[j].rcode
+	})
+	for _, k := range queries {
+		fmt.Fprintf(w, "dns_queries_total{qtype=%q,rcode=%q} %d\n", dns.TypeToString(k.qtype), dns.RcodeToString(k.rcode), m.queries[k])
+	}
+
+	fmt.Fprintf(w, "# HELP dns_zone_queries_total Queries answered for names in each zone.\n# TYPE dns_zone_queries_total counter\n")
+	for _, zone := range sortedKeys(m.zones) {
+		fmt.Fprintf(w, "dns_zone_queries_total{zone=%q} %d\n", zone, m.zones[zone])
+	}
+
+	fmt.Fprintf(w, "# HELP dns_response_duration_seconds Time from reading a query to its response being ready.\n# TYPE dns_response_duration_seconds histogram\n")
+	transports := make([]transport, 0, len(m.latency))
+	for t := range m.latency {
+		transports = append(transports, t)
+	}
+	sort.Slice(transports, func(i, j int) bool { return transports[i] < transports[j] })
+	for _, t := range transports {
+		h := m.latency[t]
+		var cumulative uint64
+		for i, bound := range h.bounds {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "dns_response_duration_seconds_bucket{transport=%q,le=%q} %d\n", t, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "dns_response_duration_seconds_bucket{transport=%q,le=\"+Inf\"} %d\n", t, h.count)
+		fmt.Fprintf(w, "dns_response_duration_seconds_sum{transport=%q} %g\n", t, h.sum)
+		fmt.Fprintf(w, "dns_response_duration_seconds_count{transport=%q} %d\n", t, h.count)
+	}
+
+	fmt.Fprintf(w, "# HELP dns_zone_transfers_total Zone transfers, out to secondaries and in from primaries.\n# TYPE dns_zone_transfers_total counter\n")
+	transfers := make([]transferKey, 0, len(m.transfers))
+	for k := range m.transfers {
+		transfers = append(transfers, k)
+	}
+	sort.Slice(transfers, func(i, j int) bool {
+		a, b := transfers[i], transfers[j]
+		return strings.Compare(a.zone+" "+a.direction+" "+a.result, b.zone+" "+b.direction+" "+b.result) < 0
+	})
+	for _, k := range transfers {
+		fmt.Fprintf(w, "dns_zone_transfers_total{zone=%q,direction=%q,result=%q} %d\n", k.zone, k.direction, k.result, m.transfers[k])
+	}
+
+	if h := s.health; h != nil {
+		fmt.Fprintf(w, "# HELP dns_health_check_up Whether each address with a health check passed its last one.\n# TYPE dns_health_check_up gauge\n")
+		h.mu.RLock()
+		lines := make([]string, 0, len(h.status))
+		for target, up := range h.status {
+			v := 0
+			if up {
+				v = 1
+			}
+			lines = append(lines, fmt.Sprintf("dns_health_check_up{name=%q,address=%q,check=%q} %d\n", target.name, target.addr, target.check.String(), v))
+		}
+		h.mu.RUnlock()
+		sort.Strings(lines)
+		for _, line := range lines {
+			io.WriteString(w, line)
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fmt.Fprintf(w, "# HELP dns_zone_serial SOA serial of each zone served.\n# TYPE dns_zone_serial gauge\n")
+	for _, name := range sortedKeys(s.zones) {
+		if soa := s.zones[name].SOA; soa != nil {
+			fmt.Fprintf(w, "dns_zone_serial{zone=%q} %d\n", name, soa.Serial)
+		}
+	}
+	fmt.Fprintf(w, "# HELP dns_zone_records Records in each zone served.\n# TYPE dns_zone_records gauge\n")
+	for _, name := range sortedKeys(s.zones) {
+		fmt.Fprintf(w, "dns_zone_records{zone=%q} %d\n", name, len(s.zones[name].AllRecords()))
+	}
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/bellistech/dns-server/dns"
+)
+
+const (
+	// notifyAttempts is how many times a NOTIFY is sent to a secondary
+	// that doesn't acknowledge it (RFC 1996 section 3.6)
+	notifyAttempts = 5
+
+	// notifyTimeout is how long the first attempt waits for the
+	// acknowledgement; each retry waits longer
+	notifyTimeout = 2 * time.Second
+)
+
+// notifySecondaries sends a NOTIFY for zone to each of its configured
+// secondaries in the background, so they check for the new serial now
+// instead of at their next refresh
+func (s *Server) notifySecondaries(ctx context.Context, zone *dns.Zone) {
+	s.mu.RLock()
+	targets := s.notify[zone.Name]
+	s.mu.RUnlock()
+
+	if zone.SOA == nil {
+		return
+	}
+	soa := *zone.SOA
+	for _, target := range targets {
+		go s.sendNotify(ctx, target, zone.Name, &soa)
+	}
+}
+
+// sendNotify sends a NOTIFY to one secondary until it is acknowledged
+func (s *Server) sendNotify(ctx context.Context, target, zone string, soa *dns.SOA) {
+	var err error
+	for attempt := 1; attempt <= notifyAttempts; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, notifyTimeout*time.Duration(attempt))
+		err = dns.Notify(attemptCtx, target, zone, soa)
+		cancel()
+		if err == nil {
+			log.Printf("NOTIFY for %s (serial %d) acknowledged by %s", zone, soa.Serial, target)
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+	log.Printf("NOTIFY for %s to %s failed after %d attempts: %v", zone, target, notifyAttempts, err)
+}
+
+// handleNotify answers a NOTIFY (RFC 1996). One from a primary of a
+// secondary zone makes the zone check that primary's serial straight away;
+// any other is REFUSED.
+func (s *Server) handleNotify(query *dns.Message, builder *dns.Builder, ip net.IP) []byte {
+	name := joinLabels(splitLabels(strings.ToLower(query.Questions[0].Name)))
+
+	var zone *secondary
+	s.mu.RLock()
+	for _, z := range s.secondaries {
+		if z.name == name {
+			zone = z
+			break
+		}
+	}
+	s.mu.RUnlock()
+	if zone == nil {
+		s.logQuery("  -> REFUSED (%s is not a secondary zone)", name)
+		return builder.BuildErrorResponse(query, dns.RcodeRefused)
+	}
+	if !zone.notified(ip) {
+		s.logQuery("  -> REFUSED (not from a primary of %s)", name)
+		return builder.BuildErrorResponse(query, dns.RcodeRefused)
+	}
+
+	s.logQuery("  -> accepted, checking the primaries of %s", name)
+	return builder.BuildErrorResponse(query, dns.RcodeNoError)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math/rand"
+	"sync/atomic"
+
+	"github.com/bellistech/dns-server/dns"
+)
+
+// Answer orders of A and AAAA RRsets
+const (
+	AnswerOrderRotate = "rotate" // Each response starts one record further on
+	AnswerOrderRandom = "random" // Shuffled for each response
+	AnswerOrderFixed  = "fixed"  // Canonical order, as the zone holds them
+)
+
+// answerOrder orders the address records of answers, so clients that use
+// the first address spread their load across all of them
+type answerOrder struct {
+	mode string
+	next uint32 // Responses rotated so far
+}
+
+// apply returns records in the order to answer with; the addresses after
+// a CNAME chain are reordered, the chain itself stays first. Addresses with
+// weights ($WEIGHT) are ordered by them rather than rotated or shuffled.
+// The zone's RRsets are shared, so they are copied rather than reordered in
+// place.
+func (o *answerOrder) apply(records []dns.ResourceRecord) []dns.ResourceRecord {
+	chain := 0
+	for chain < len(records) && records[chain].Type == dns.TypeCNAME {
+		chain++
+	}
+	addresses := records[chain:]
+	if o.mode == AnswerOrderFixed || len(addresses) < 2 ||
+		addresses[0].Type != dns.TypeA && addresses[0].Type != dns.TypeAAAA {
+		return records
+	}
+
+	ordered := make([]dns.ResourceRecord, len(records))
+	copy(ordered, records[:chain])
+	if weighted(addresses) {
+		copy(ordered[chain:], addresses)
+		weightedShuffle(ordered[chain:])
+		return ordered
+	}
+	if o.mode == AnswerOrderRandom {
+		shuffled := ordered[chain:]
+		copy(shuffled, addresses)
+		rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		return ordered
+	}
+	start := int(atomic.AddUint32(&o.next, 1) % uint32(len(addresses)))
+	n := copy(ordered[chain:], addresses[start:])
+	copy(ordered[chain+n:], addresses[:start])
+	return ordered
+}
+
+// weighted reports whether any of records has a weight
+func weighted(records []dns.ResourceRecord) bool {
+	for _, rr := range records {
+		if rr.Weight != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// weightOf returns a record's weight; records without one weigh 1
+func weightOf(rr dns.ResourceRecord) int {
+	if rr.Weight == 0 {
+		return 1
+	}
+	return int(rr.Weight)
+}
+
+// weightedShuffle orders records by drawing each next one at random from
+// those left, in proportion to their weights, as SRV targets are picked
+// (RFC 2782). Each record comes first in that share of answers, so clients
+// using the first address send it that share of their traffic.
+func weightedShuffle(records []dns.ResourceRecord) {
+	total := 0
+	for _, rr := range records {
+		total += weightOf(rr)
+	}
+	for i := 0; i < len(records)-1; i++ {
+		n := rand.Intn(total)
+		j := i
+		for n >= weightOf(records[j]) {
+			n -= weightOf(records[j])
+			j++
+		}
+		records[i], records[j] = records[j], records[i]
+		total -= weightOf(records[i])
+	}
+}
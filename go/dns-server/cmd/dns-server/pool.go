@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bellistech/dns-server/dns"
+)
+
+// DefaultQueryWorkers is how many goroutines answer UDP queries. Forwarded
+// queries hold theirs while waiting for an upstream, so there are plenty.
+const DefaultQueryWorkers = 256
+
+// maxPooledQuery is the largest query buffer kept for reuse: most queries
+// are well under it, and keeping the rare large ones would hold their memory
+const maxPooledQuery = 4096
+
+// builders are the response builders of answered queries, kept for the
+// next ones so their buffers aren't allocated again for every query
+var builders = sync.Pool{
+	New: func() any { return dns.NewBuilder() },
+}
+
+// queryBuffers hold UDP queries from when they are read until answered.
+// They are pointers so putting one back doesn't allocate.
+var queryBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 0, 512)
+		return &buf
+	},
+}
+
+// getBuilder returns a builder as NewBuilder creates it. Its responses are
+// valid until it is put back with putBuilder, so that waits until they are
+// written.
+func getBuilder() *dns.Builder {
+	return builders.Get().(*dns.Builder)
+}
+
+func putBuilder(b *dns.Builder) {
+	b.Reset()
+	builders.Put(b)
+}
+
+// udpQuery is a query read from a UDP socket, waiting for a worker
+type udpQuery struct {
+	conn   *net.UDPConn
+	client *net.UDPAddr
+	data   *[]byte // From queryBuffers
+}
+
+// queryWorkers answer the UDP queries read from all sockets. Their number is
+// fixed, and queries arriving while all are busy wait in a queue as long as
+// there are workers; beyond that they are dropped, so a flood can't start
+// goroutines or hold buffers without bound.
+type queryWorkers struct {
+	server *Server
+	queue  chan udpQuery
+}
+
+func newQueryWorkers(s *Server, n int) *queryWorkers {
+	return &queryWorkers{server: s, queue: make(chan udpQuery, n)}
+}
+
+// start starts the workers, which stop when ctx is done
+func (w *queryWorkers) start(ctx context.Context) {
+	for i := 0; i < cap(w.queue); i++ {
+		go w.run(ctx)
+	}
+}
+
+// enqueue queues a query copied from data, or drops it if the queue is full
+func (w *queryWorkers) enqueue(conn *net.UDPConn, client *net.UDPAddr, data []byte) bool {
+	buf := queryBuffers.Get().(*[]byte)
+	*buf = append((*buf)[:0], data...)
+	select {
+	case w.queue <- udpQuery{conn: conn, client: client, data: buf}:
+		return true
+	default:
+		putQueryBuffer(buf)
+		atomic.AddUint64(&w.server.dropped, 1)
+		return false
+	}
+}
+
+func (w *queryWorkers) run(ctx context.Context) {
+	s := w.server
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case q := <-w.queue:
+			builder := getBuilder()
+			for _, response := range s.handleQuery(builder, *q.data, q.client, q.client.IP, transportUDP) {
+				q.conn.WriteToUDP(response, q.client)
+			}
+			putBuilder(builder)
+			putQueryBuffer(q.data)
+			if s.inflight != nil {
+				<-s.inflight
+			}
+		}
+	}
+}
+
+func putQueryBuffer(buf *[]byte) {
+	if cap(*buf) <= maxPooledQuery {
+		queryBuffers.Put(buf)
+	}
+}
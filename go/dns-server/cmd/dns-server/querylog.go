@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bellistech/dns-server/dns"
+)
+
+// Query log formats
+const (
+	QueryLogJSON = "json" // One JSON object per line
+	QueryLogText = "text" // dnstap-style text, one line per query
+)
+
+// DefaultQueryLogBackups is how many rotated query log files are kept when
+// the config doesn't say
+const DefaultQueryLogBackups = 5
+
+// queryLog writes a line per answered query, or per sampled one, to its own
+// file
+type queryLog struct {
+	out    *rotatingFile
+	format string
+	sample uint64 // Log one query in this many
+	n      uint64 // Queries seen, for sampling
+}
+
+// queryLogEntry is one line of the query log
+type queryLogEntry struct {
+	Time      time.Time `json:"time"`
+	Client    string    `json:"client"`
+	Transport string    `json:"transport"`
+	Name      string    `json:"qname"`
+	Type      string    `json:"qtype"`
+	Rcode     string    `json:"rcode"`
+	Answers   int       `json:"answers"`
+	Size      int       `json:"size"`       // Bytes, of every message for a zone transfer
+	Latency   float64   `json:"latency_ms"` // From reading the query to the response being ready
+}
+
+func newQueryLog(config *QueryLogConfig) (*queryLog, error) {
+	backups := config.MaxBackups
+	if backups == 0 {
+		backups = DefaultQueryLogBackups
+	}
+	out, err := openRotatingFile(config.File, int64(config.MaxSize)<<20, backups)
+	if err != nil {
+		return nil, err
+	}
+	l := &queryLog{out: out, format: config.Format, sample: uint64(config.Sample)}
+	if l.format == "" {
+		l.format = QueryLogJSON
+	}
+	if l.sample == 0 {
+		l.sample = 1
+	}
+	return l, nil
+}
+
+// sampled reports whether the next query is one to log
+func (l *queryLog) sampled() bool {
+	return atomic.AddUint64(&l.n, 1)%l.sample == 0
+}
+
+// record logs an answered query
+func (l *queryLog) record(start time.Time, latency time.Duration, ip net.IP, t transport, o queryOutcome) {
+	entry := queryLogEntry{
+		Time:      start.UTC(),
+		Client:    ip.String(),
+		Transport: t.String(),
+		Name:      strings.TrimSuffix(o.name, ".") + ".",
+		Type:      dns.TypeToString(o.qtype),
+		Rcode:     dns.RcodeToString(o.rcode),
+		Answers:   o.answers,
+		Size:      o.size,
+		Latency:   float64(latency.Microseconds()) / 1000,
+	}
+
+	var line []byte
+	if l.format == QueryLogText {
+		line = []byte(entry.text() + "\n")
+	} else {
+		line, _ = json.Marshal(entry)
+		line = append(line, '\n')
+	}
+	l.out.Write(line)
+}
+
+// text formats the entry like dnstap-read does, with the outcome after
+// the question, e.g.
+// 2024-05-01T12:00:00.123Z 192.0.2.7 UDP 61b www.example.com./IN/A NOERROR 1 0.084ms
+func (e queryLogEntry) text() string {
+	return fmt.Sprintf("%s %s %s %db %s/IN/%s %s %d %.3fms",
+		e.Time.Format("2006-01-02T15:04:05.000Z07:00"), e.Client, e.Transport, e.Size,
+		e.Name, e.Type, e.Rcode, e.Answers, e.Latency)
+}
+
+// Close closes the query log's file
+func (l *queryLog) Close() error {
+	return l.out.Close()
+}
+
+// rotatingFile is a file appended to until it reaches maxSize bytes, when
+// it is renamed to path.1 (path.1 to path.2, and so on, keeping backups of
+// them) and a new one started. A maxSize of 0 never rotates.
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	backups int
+	file    *os.File
+	size    int64
+}
+
+func openRotatingFile(path string, maxSize int64, backups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file, r.size = f, info.Size()
+	return nil
+}
+
+// Write appends p, rotating the file first if p would take it over the
+// size limit
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil && r.file == nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups along, dropping the oldest, and starts a new
+// file. If the file can't be renamed it is kept, growing past the limit.
+func (r *rotatingFile) rotate() error {
+	os.Remove(fmt.Sprintf("%s.%d", r.path, r.backups))
+	for i := r.backups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return err
+	}
+	r.file.Close()
+	r.file = nil
+	return r.open()
+}
+
+// Close closes the file; later writes fail
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
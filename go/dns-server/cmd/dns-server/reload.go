@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/bellistech/dns-server/dns"
+)
+
+// primaryZone is a zone loaded from a file, remembered so the file can be
+// loaded again when it changes
+type primaryZone struct {
+	name    string
+	config  ZoneConfig
+	modTime time.Time // Of the file when it was last loaded
+}
+
+func newPrimaryZone(name string, config ZoneConfig) *primaryZone {
+	p := &primaryZone{name: name, config: config}
+	if info, err := os.Stat(config.File); err == nil {
+		p.modTime = info.ModTime()
+	}
+	return p
+}
+
+// ReloadZones loads the zone files again: all of them with force set (on
+// SIGHUP), otherwise only those changed since they were last loaded. A zone
+// is replaced only if its file loads (and signs) without error; until then
+// the old one is served.
+func (s *Server) ReloadZones(ctx context.Context, force bool) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	for _, p := range s.primaries {
+		info, err := os.Stat(p.config.File)
+		if err != nil {
+			log.Printf("Reloading zone %s: %v; still serving the loaded zone", p.name, err)
+			continue
+		}
+		if !force && info.ModTime().Equal(p.modTime) {
+			continue
+		}
+		// Not tried again until the file changes, even if it doesn't load
+		p.modTime = info.ModTime()
+
+		if err := s.reloadZone(ctx, p); err != nil {
+			log.Printf("Reloading zone %s from %s failed; still serving the loaded zone: %v", p.name, p.config.File, err)
+		}
+	}
+}
+
+// reloadZone loads a zone's file and swaps it in, then logs what changed
+func (s *Server) reloadZone(ctx context.Context, p *primaryZone) error {
+	zone, err := dns.LoadZoneFile(p.config.File)
+	if err != nil {
+		return err
+	}
+	if zone.Name != p.name {
+		return fmt.Errorf("the file now holds zone %s; restart to serve it instead", zone.Name)
+	}
+	old, err := s.installZone(ctx, p, zone)
+	if err != nil {
+		return err
+	}
+
+	added, removed := diffZones(old, zone)
+	log.Printf("Reloaded zone %s from %s: %d records added, %d removed, %s", zone.Name, p.config.File, added, removed, serialChange(old, zone))
+	return nil
+}
+
+// installZone serves a new version of a primary zone, with a new signer for
+// a signed zone, and notifies the secondaries of a new serial. It returns
+// the version replaced.
+func (s *Server) installZone(ctx context.Context, p *primaryZone, zone *dns.Zone) (*dns.Zone, error) {
+	var signer *dns.Signer
+	if p.config.DNSSEC != nil {
+		var err error
+		if signer, err = s.signZone(zone, p.config.DNSSEC); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	old := s.zones[zone.Name]
+	s.zones[zone.Name] = zone
+	if signer != nil {
+		s.signers[zone.Name] = signer
+	}
+	s.mu.Unlock()
+
+	if old == nil || old.SOA == nil || zone.SOA != nil && zone.SOA.Serial != old.SOA.Serial {
+		s.notifySecondaries(ctx, zone)
+	}
+	return old, nil
+}
+
+// watchZones reloads zone files that change, checking every interval
+func (s *Server) watchZones(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.ReloadZones(ctx, false)
+		}
+	}
+}
+
+// diffZones counts the records in new but not in old, and in old but not
+// in new. A record whose TTL changed counts as both.
+func diffZones(old, new *dns.Zone) (added, removed int) {
+	count := make(map[string]int)
+	key := func(rr dns.ResourceRecord) string {
+		rr.Comment = ""
+		return rr.String()
+	}
+	if old != nil {
+		for _, rr := range old.AllRecords() {
+			count[key(rr)]--
+		}
+	}
+	for _, rr := range new.AllRecords() {
+		count[key(rr)]++
+	}
+	for _, n := range count {
+		if n > 0 {
+			added += n
+		} else {
+			removed -= n
+		}
+	}
+	return added, removed
+}
+
+// serialChange describes how the zone's serial changed, warning when it
+// didn't go up: secondaries only transfer a zone with a newer serial
+func serialChange(old, new *dns.Zone) string {
+	switch {
+	case new.SOA == nil:
+		return "no SOA record"
+	case old == nil || old.SOA == nil:
+		return fmt.Sprintf("serial %d", new.SOA.Serial)
+	case dns.SerialNewer(new.SOA.Serial, old.SOA.Serial):
+		return fmt.Sprintf("serial %d -> %d", old.SOA.Serial, new.SOA.Serial)
+	case new.SOA.Serial == old.SOA.Serial:
+		return fmt.Sprintf("serial still %d (secondaries won't see the change until it goes up)", new.SOA.Serial)
+	default:
+		return fmt.Sprintf("serial %d -> %d, which isn't newer (secondaries won't see the change)", old.SOA.Serial, new.SOA.Serial)
+	}
+}
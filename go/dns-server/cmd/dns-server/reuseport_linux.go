@@ -0,0 +1,25 @@
+//go:build linux && (386 || amd64 || arm || arm64 || loong64 || ppc64 || ppc64le || riscv64 || s390x)
+
+package main
+
+import "syscall"
+
+// reusePortBalances is whether the kernel spreads the datagrams for an
+// address across the sockets sharing it with SO_REUSEPORT
+const reusePortBalances = true
+
+// soReusePort is SO_REUSEPORT on these architectures, which the syscall
+// package doesn't define
+const soReusePort = 0xf
+
+// reusePort sets SO_REUSEPORT on a socket before it is bound, so more
+// sockets may bind the same address
+func reusePort(network, address string, c syscall.RawConn) error {
+	var err error
+	if controlErr := c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	}); controlErr != nil {
+		return controlErr
+	}
+	return err
+}
@@ -0,0 +1,16 @@
+//go:build !linux || !(386 || amd64 || arm || arm64 || loong64 || ppc64 || ppc64le || riscv64 || s390x)
+
+package main
+
+import "syscall"
+
+// reusePortBalances is whether the kernel spreads the datagrams for an
+// address across the sockets sharing it with SO_REUSEPORT. Elsewhere than
+// Linux one of the sockets gets them all, so there is one per address (as
+// on the Linux architectures whose SO_REUSEPORT isn't listed).
+const reusePortBalances = false
+
+// reusePort leaves a socket as it is: there is only ever one per address
+func reusePort(network, address string, c syscall.RawConn) error {
+	return nil
+}
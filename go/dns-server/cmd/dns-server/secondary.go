@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/bellistech/dns-server/dns"
+)
+
+const (
+	// secondaryInitialRetry is how often a secondary zone without data
+	// (never transferred, or expired) tries its primaries
+	secondaryInitialRetry = time.Minute
+
+	// secondaryMinInterval keeps an SOA with tiny refresh or retry timers
+	// from hammering the primaries
+	secondaryMinInterval = 10 * time.Second
+
+	// transferTimeout bounds an SOA check plus transfer from one primary
+	transferTimeout = 2 * time.Minute
+)
+
+// secondary keeps a secondary zone in step with its primaries, following
+// the timers in the zone's SOA record (RFC 1035 section 4.3.5): every
+// refresh interval the primary's serial is checked and the zone transferred
+// with AXFR if the serial is newer; after a failure the check is retried
+// every retry interval; and once the zone has gone unconfirmed for the
+// expire interval it is no longer served. A NOTIFY from a primary triggers
+// a check straight away.
+type secondary struct {
+	server     *Server
+	name       string
+	primaries  []string
+	primaryIPs []net.IP      // Addresses NOTIFY is accepted from
+	checkNow   chan struct{} // Signalled by a NOTIFY
+	catalog    *catalog      // Provisions the member zones after each transfer (catalog zones only)
+
+	// Only touched by run
+	zone      *dns.Zone // nil until transferred, and again once expired
+	refreshed time.Time // When a primary last confirmed the zone is current
+}
+
+// newSecondary creates a secondary zone; run transfers it
+func newSecondary(server *Server, name string, primaries []string) *secondary {
+	z := &secondary{
+		server:    server,
+		name:      name,
+		primaries: primaries,
+		checkNow:  make(chan struct{}, 1),
+	}
+	for _, primary := range primaries {
+		host, _, err := net.SplitHostPort(primary)
+		if err != nil {
+			host = strings.Trim(primary, "[]")
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			z.primaryIPs = append(z.primaryIPs, ip)
+		}
+	}
+	return z
+}
+
+// run keeps the zone up to date until ctx is done
+func (z *secondary) run(ctx context.Context) {
+	for {
+		timer := time.NewTimer(z.refresh(ctx))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-z.checkNow:
+			timer.Stop()
+		}
+	}
+}
+
+// notified asks run to check the primaries now, if ip is one of them. It
+// never blocks: a check already asked for covers this NOTIFY too.
+func (z *secondary) notified(ip net.IP) bool {
+	for _, primary := range z.primaryIPs {
+		if primary.Equal(ip) {
+			select {
+			case z.checkNow <- struct{}{}:
+			default:
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// refresh brings the zone up to date if a primary can be reached, and
+// returns how long to wait before the next check
+func (z *secondary) refresh(ctx context.Context) time.Duration {
+	err := z.update(ctx)
+	if ctx.Err() != nil {
+		return 0
+	}
+	if err == nil {
+		z.refreshed = time.Now()
+		return soaInterval(z.zone.SOA.Refresh)
+	}
+
+	log.Printf("Secondary zone %s: refresh failed: %v", z.name, err)
+	if z.zone == nil {
+		return secondaryInitialRetry
+	}
+	soa := z.zone.SOA
+	if expire := time.Duration(soa.Expire) * time.Second; time.Since(z.refreshed) >= expire {
+		log.Printf("Secondary zone %s: expired after %s without a refresh, no longer served", z.name, expire)
+		z.server.RemoveZone(z.name)
+		z.zone = nil
+		return secondaryInitialRetry
+	}
+	return soaInterval(soa.Retry)
+}
+
+// update tries the primaries in order until one confirms the zone is
+// current or transfers a newer copy
+func (z *secondary) update(ctx context.Context) error {
+	var errs []error
+	for _, primary := range z.primaries {
+		err := z.updateFrom(ctx, primary)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", primary, err))
+	}
+	return errors.Join(errs...)
+}
+
+// updateFrom checks one primary's serial and transfers the zone from it if
+// the serial is newer than ours, or if we have no copy yet
+func (z *secondary) updateFrom(ctx context.Context, primary string) error {
+	checkCtx, cancel := context.WithTimeout(ctx, transferTimeout)
+	defer cancel()
+
+	if z.zone != nil {
+		msg, err := dns.Exchange(checkCtx, primary, z.name, dns.TypeSOA)
+		if err != nil {
+			return fmt.Errorf("SOA query: %w", err)
+		}
+		serial, err := soaSerial(msg, z.name)
+		if err != nil {
+			return err
+		}
+		if !z.zone.NeedsRefresh(serial) {
+			return nil
+		}
+		log.Printf("Secondary zone %s: serial %d on %s is newer than our %d", z.name, serial, primary, z.zone.SOA.Serial)
+	}
+
+	zone, err := dns.Transfer(checkCtx, primary, z.name)
+	z.server.recordTransfer(z.name, "in", err == nil)
+	if err != nil {
+		return fmt.Errorf("AXFR: %w", err)
+	}
+	z.zone = zone
+	z.server.AddZone(zone)
+	log.Printf("Secondary zone %s: transferred serial %d from %s (%d records)", z.name, zone.SOA.Serial, primary, len(zone.AllRecords()))
+	z.server.notifySecondaries(ctx, zone)
+	if z.catalog != nil {
+		z.catalog.update(ctx, zone)
+	}
+	return nil
+}
+
+// addSecondary adds a secondary zone started after Start, such as a catalog
+// zone's member, so NOTIFYs reach it
+func (s *Server) addSecondary(z *secondary) {
+	s.mu.Lock()
+	s.secondaries = append(s.secondaries, z)
+	s.mu.Unlock()
+}
+
+// removeSecondary forgets a secondary zone added with addSecondary
+func (s *Server) removeSecondary(z *secondary) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, other := range s.secondaries {
+		if other == z {
+			s.secondaries = append(s.secondaries[:i:i], s.secondaries[i+1:]...)
+			return
+		}
+	}
+}
+
+// configured reports whether a zone called name is a primary or secondary
+// zone already
+func (s *Server) configured(name string) bool {
+	for _, p := range s.primaries {
+		if p.name == name {
+			return true
+		}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, z := range s.secondaries {
+		if z.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// soaSerial returns the serial of the zone's SOA record in an authoritative
+// answer
+func soaSerial(msg *dns.Message, zone string) (uint32, error) {
+	if rcode := msg.Rcode(); rcode != dns.RcodeNoError {
+		return 0, fmt.Errorf("SOA query failed with rcode %d", rcode)
+	}
+	if msg.Header.Flags&dns.FlagAA == 0 {
+		return 0, fmt.Errorf("SOA answer is not authoritative")
+	}
+	for _, rr := range msg.Answers {
+		if rr.Type == dns.TypeSOA && rr.SOAData != nil && strings.EqualFold(rr.Name, zone) {
+			return rr.SOAData.Serial, nil
+		}
+	}
+	return 0, fmt.Errorf("no SOA record in the answer")
+}
+
+// soaInterval converts an SOA timer in seconds, with a floor
+func soaInterval(seconds uint32) time.Duration {
+	return max(time.Duration(seconds)*time.Second, secondaryMinInterval)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"container/heap"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultTopK is how many query names and client prefixes are tracked
+const DefaultTopK = 1000
+
+// Client prefix lengths queries are counted by, so one client spreading its
+// queries over many addresses still shows up as one
+const (
+	clientPrefix4 = 24
+	clientPrefix6 = 48
+)
+
+// queryStats counts the most queried names and the busiest client prefixes
+// in constant memory, so hot names and abusive clients can be spotted
+// without logging every query
+type queryStats struct {
+	mu      sync.Mutex
+	names   *topK
+	clients *topK
+}
+
+func newQueryStats(k int) *queryStats {
+	return &queryStats{names: newTopK(k), clients: newTopK(k)}
+}
+
+// record counts one query for name from client
+func (s *queryStats) record(name string, client net.IP) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	prefix := clientPrefix(client)
+
+	s.mu.Lock()
+	s.names.add(name)
+	s.clients.add(prefix)
+	s.mu.Unlock()
+}
+
+// top returns the n most queried names and the n busiest client prefixes
+func (s *queryStats) top(n int) (names, clients []topEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.names.top(n), s.clients.top(n)
+}
+
+// clientPrefix returns the network a client address belongs to, in CIDR
+// notation
+func clientPrefix(ip net.IP) string {
+	bits := clientPrefix6
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, clientPrefix4
+	}
+	network := net.IPNet{IP: ip.Mask(net.CIDRMask(bits, len(ip)*8)), Mask: net.CIDRMask(bits, len(ip)*8)}
+	return network.String()
+}
+
+// topEntry is one counted key. The true count lies between Count-Error and
+// Count
+type topEntry struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+	Error uint64 `json:"error"`
+	index int
+}
+
+// topK is a Space-Saving sketch (Metwally et al., 2005): it keeps counters
+// for at most k keys. A new key takes over the counter of the least counted
+// one, inheriting its count as the possible overcount. Any key queried more
+// than total/k times is guaranteed to be in the sketch
+type topK struct {
+	k       int
+	entries map[string]*topEntry
+	heap    topHeap // Least counted entry first
+}
+
+func newTopK(k int) *topK {
+	if k <= 0 {
+		k = DefaultTopK
+	}
+	return &topK{k: k, entries: make(map[string]*topEntry, k)}
+}
+
+func (t *topK) add(key string) {
+	if e, ok := t.entries[key]; ok {
+		e.Count++
+		heap.Fix(&t.heap, e.index)
+		return
+	}
+
+	if len(t.heap) < t.k {
+		e := &topEntry{Key: key, Count: 1}
+		t.entries[key] = e
+		heap.Push(&t.heap, e)
+		return
+	}
+
+	// Evict the least counted key and take over its counter
+	e := t.heap[0]
+	delete(t.entries, e.Key)
+	e.Key, e.Error = key, e.Count
+	e.Count++
+	t.entries[key] = e
+	heap.Fix(&t.heap, 0)
+}
+
+// top returns copies of the n entries with the highest counts, highest first
+func (t *topK) top(n int) []topEntry {
+	entries := make([]topEntry, 0, len(t.heap))
+	for _, e := range t.heap {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	if n > 0 && len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
+
+// topHeap is a min-heap of entries by count
+type topHeap []*topEntry
+
+func (h topHeap) Len() int           { return len(h) }
+func (h topHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h topHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *topHeap) Push(x interface{}) {
+	e := x.(*topEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *topHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTCPIdleTimeout is how long a TCP connection may sit without a
+// query before it is closed (RFC 7766 section 6.2.3 recommends seconds)
+const DefaultTCPIdleTimeout = 10 * time.Second
+
+// tcpServer serves DNS over TCP (RFC 7766): each message is preceded by its
+// length as two bytes, and a client may send several queries on one
+// connection. Queries on a connection are answered in order; connections
+// are handled concurrently. With a TLS config it serves DNS over TLS (RFC
+// 7858) instead, the same messages inside a TLS session.
+type tcpServer struct {
+	server      *Server
+	idleTimeout time.Duration
+	slots       chan struct{} // One per open connection (nil for no limit)
+	tlsConfig   *tls.Config   // nil for plain TCP
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+func newTCPServer(server *Server, idleTimeout time.Duration, maxConns int, tlsConfig *tls.Config) *tcpServer {
+	t := &tcpServer{
+		server:      server,
+		idleTimeout: idleTimeout,
+		tlsConfig:   tlsConfig,
+		conns:       make(map[net.Conn]struct{}),
+	}
+	if maxConns > 0 {
+		t.slots = make(chan struct{}, maxConns)
+	}
+	return t
+}
+
+// listen starts accepting connections on addr, adding the accept loop to wg
+func (t *tcpServer) listen(ctx context.Context, wg *sync.WaitGroup, network, addr string) error {
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	if t.tlsConfig != nil {
+		ln = tls.NewListener(ln, t.tlsConfig)
+	}
+
+	t.mu.Lock()
+	t.listeners = append(t.listeners, ln)
+	t.mu.Unlock()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		t.serve(ctx, ln)
+	}()
+	return nil
+}
+
+func (t *tcpServer) serve(ctx context.Context, ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("TCP accept error: %v", err)
+			time.Sleep(100 * time.Millisecond) // Out of file descriptors, most likely
+			continue
+		}
+
+		// Too many connections open already: close this one, the client
+		// will try again later
+		if t.slots != nil {
+			select {
+			case t.slots <- struct{}{}:
+			default:
+				atomic.AddUint64(&t.server.dropped, 1)
+				conn.Close()
+				continue
+			}
+		}
+
+		if !t.track(conn) {
+			conn.Close()
+			return
+		}
+		go t.handleConn(conn)
+	}
+}
+
+// track remembers an open connection so close can close it, unless the
+// server is already shutting down
+func (t *tcpServer) track(conn net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false
+	}
+	t.conns[conn] = struct{}{}
+	return true
+}
+
+// handleConn answers queries on one connection until the client closes it,
+// it is idle for too long or a message can't be read or written
+func (t *tcpServer) handleConn(conn net.Conn) {
+	defer func() {
+		t.mu.Lock()
+		delete(t.conns, conn)
+		t.mu.Unlock()
+		conn.Close()
+		if t.slots != nil {
+			<-t.slots
+		}
+	}()
+
+	// The handshake gets as long as a query would, so a client that
+	// connects and sends nothing doesn't hold the connection open
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(t.idleTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			t.server.logQuery("TLS handshake with %s failed: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+
+	ip := conn.RemoteAddr().(*net.TCPAddr).IP
+	var length [2]byte
+	for {
+		conn.SetReadDeadline(time.Now().Add(t.idleTimeout))
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return // Closed by the client, or idle
+		}
+		data := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+
+		// Share the query limit with UDP, but wait for a slot rather
+		// than drop the query: TCP clients don't retry the same way
+		if inflight := t.server.inflight; inflight != nil {
+			inflight <- struct{}{}
+		}
+		transport := transportTCP
+		if t.tlsConfig != nil {
+			transport = transportTLS
+		}
+		builder := getBuilder()
+		responses := t.server.handleQuery(builder, data, conn.RemoteAddr(), ip, transport)
+		if inflight := t.server.inflight; inflight != nil {
+			<-inflight
+		}
+
+		// Several responses for a zone transfer, each with its own length
+		for _, response := range responses {
+			// Length and message in one write, so they go out in one segment
+			packet := make([]byte, 2+len(response))
+			binary.BigEndian.PutUint16(packet, uint16(len(response)))
+			copy(packet[2:], response)
+			conn.SetWriteDeadline(time.Now().Add(t.idleTimeout))
+			if _, err := conn.Write(packet); err != nil {
+				putBuilder(builder)
+				return
+			}
+		}
+		putBuilder(builder)
+	}
+}
+
+// close stops accepting connections and closes the open ones
+func (t *tcpServer) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	for _, ln := range t.listeners {
+		ln.Close()
+	}
+	for conn := range t.conns {
+		conn.Close()
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultTLSIdleTimeout is how long a DNS over TLS connection may sit
+// without a query before it is closed. Longer than for plain TCP, as a
+// client that has paid for a TLS handshake should get to reuse it (RFC
+// 7858 section 3.4).
+const DefaultTLSIdleTimeout = 30 * time.Second
+
+// dotALPN is the ALPN protocol ID of DNS over TLS (RFC 7858 section 3.2)
+const dotALPN = "dot"
+
+// certificate serves a certificate and key from files, loading them again
+// when either changes, so a renewed certificate is picked up without a
+// restart
+type certificate struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // Latest modification time of the two files
+	checked time.Time // When the files were last looked at
+}
+
+// certCheckInterval is how often the certificate files are checked for
+// changes, at most
+const certCheckInterval = time.Minute
+
+// loadCertificate reads a PEM certificate (chain) and its private key
+func loadCertificate(certFile, keyFile string) (*certificate, error) {
+	c := &certificate{certFile: certFile, keyFile: keyFile}
+	modTime, err := c.modified()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.load(modTime); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// modified returns the latest modification time of the files
+func (c *certificate) modified() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (c *certificate) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("TLS certificate %s: %w", c.certFile, err)
+	}
+	c.cert, c.modTime, c.checked = &cert, modTime, time.Now()
+	return nil
+}
+
+// get returns the certificate for a handshake, reloading it if the files
+// have changed. A certificate that fails to load is logged and the old one
+// kept, as the files may be halfway through being replaced.
+func (c *certificate) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checked) < certCheckInterval {
+		return c.cert, nil
+	}
+	c.checked = time.Now()
+	modTime, err := c.modified()
+	if err != nil || !modTime.After(c.modTime) {
+		return c.cert, nil
+	}
+	if err := c.load(modTime); err != nil {
+		log.Printf("Keeping the old TLS certificate: %v", err)
+		return c.cert, nil
+	}
+	log.Printf("Reloaded TLS certificate %s", c.certFile)
+	return c.cert, nil
+}
+
+// newTLSConfig returns the TLS configuration for DNS over TLS or HTTPS
+// listeners, offering the ALPN protocols given. Clients resume sessions with
+// session tickets (on by default, with keys rotated by crypto/tls), saving a
+// full handshake when they reconnect.
+func newTLSConfig(config *TLSConfig, protos ...string) (*tls.Config, error) {
+	cert, err := loadCertificate(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		GetCertificate: cert.get,
+		// TLS 1.2 at least (RFC 8310 section 9, RFC 7525)
+		MinVersion: tls.VersionTLS12,
+		NextProtos: protos,
+	}, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net"
+	"runtime"
+)
+
+// udpWorkers returns how many UDP sockets to open per address for the
+// listen.udp_workers setting: 0 means one per CPU Go may use
+func udpWorkers(configured int) int {
+	if !reusePortBalances {
+		return 1
+	}
+	if configured == 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return configured
+}
+
+// listenUDP opens workers UDP sockets on address. With more than one they
+// share it with SO_REUSEPORT, and the kernel spreads queries across them by
+// client address and port, each socket read by a goroutine of its own
+// rather than all queries by one. Port 0 is the port the first one gets.
+func listenUDP(network, address string, workers int) ([]*net.UDPConn, error) {
+	if workers <= 1 {
+		addr, err := net.ResolveUDPAddr(network, address)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := net.ListenUDP(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return []*net.UDPConn{conn}, nil
+	}
+
+	lc := net.ListenConfig{Control: reusePort}
+	conns := make([]*net.UDPConn, 0, workers)
+	for i := 0; i < workers; i++ {
+		pc, err := lc.ListenPacket(context.Background(), network, address)
+		if err != nil {
+			for _, conn := range conns {
+				conn.Close()
+			}
+			return nil, err
+		}
+		conn := pc.(*net.UDPConn)
+		conns = append(conns, conn)
+		address = conn.LocalAddr().String()
+	}
+	return conns, nil
+}
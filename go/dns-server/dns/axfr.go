@@ -0,0 +1,169 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// TransferMessageSize is the most bytes put in one message of a zone
+// transfer. Messages may be up to 65535 bytes over TCP, but smaller ones
+// let the secondary start on the records sooner.
+const TransferMessageSize = 16384
+
+// BuildTransfer builds the messages of a zone transfer (AXFR, RFC 5936)
+// answering query: the zone's SOA record, every other record and the SOA
+// record again, spread over as many messages as needed to keep each one
+// within maxSize bytes (a single record larger than that gets a message of
+// its own). Only the first message echoes the question (RFC 5936 section
+// 2.2.1). The messages are copies, so the builder can be reused while they
+// are being sent.
+func (b *Builder) BuildTransfer(query *Message, zone *Zone, maxSize int) ([][]byte, error) {
+	b.Truncated = false
+
+	var soa []ResourceRecord
+	var records []ResourceRecord
+	for _, rr := range zone.AllRecords() {
+		if rr.Type == TypeSOA {
+			if strings.EqualFold(rr.Name, zone.Name) {
+				soa = append(soa, rr)
+			}
+			continue
+		}
+		records = append(records, rr)
+	}
+	if len(soa) == 0 {
+		return nil, fmt.Errorf("zone %s has no SOA record", zone.Name)
+	}
+
+	records = append(soa[:1:1], records...)
+	records = append(records, soa[0])
+
+	opt := b.responseOPT(query, RcodeNoError)
+	limit := maxSize
+	if opt != nil {
+		limit -= optRecordSize(opt)
+	}
+
+	var messages [][]byte
+	for len(records) > 0 {
+		header := Header{
+			ID:    query.Header.ID,
+			Flags: FlagQR | FlagAA,
+		}
+		if opt != nil {
+			header.ARCount = 1
+		}
+
+		b.reset()
+		b.writeHeader(&header)
+		if len(messages) == 0 {
+			header.QDCount = uint16(len(query.Questions))
+			for _, q := range query.Questions {
+				b.writeQuestion(&q)
+			}
+		}
+
+		// As many records as fit, but at least one
+		n := 0
+		for n < len(records) {
+			end := len(b.data)
+			b.writeResourceRecord(&records[n])
+			if n > 0 && len(b.data) > limit {
+				b.truncate(end)
+				break
+			}
+			n++
+		}
+		records = records[n:]
+		header.ANCount = uint16(n)
+
+		if opt != nil {
+			b.writeResourceRecord(opt)
+		}
+		b.patchHeader(&header)
+
+		messages = append(messages, append([]byte(nil), b.data...))
+	}
+	return messages, nil
+}
+
+// Transfer copies a zone from server with AXFR over TCP, the way a secondary
+// server does. The port defaults to 53 if server has none.
+func Transfer(ctx context.Context, server, zone string) (*Zone, error) {
+	conn, err := Dial(ctx, "tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.Transfer(ctx, zone)
+}
+
+// Transfer copies a zone with AXFR. The connection must be TCP. The whole
+// transfer must finish within ctx's deadline, or Timeout without one.
+//
+// The records are read until the zone's SOA record comes round again (RFC
+// 5936 section 2.2); records outside the zone are ignored. An error response
+// fails the transfer, e.g. REFUSED when the server doesn't allow us to
+// transfer the zone.
+func (c *Conn) Transfer(ctx context.Context, zone string) (*Zone, error) {
+	if !c.tcp {
+		return nil, fmt.Errorf("zone transfers need TCP")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	zone = strings.TrimSuffix(zone, ".")
+	query := c.builder.BuildQuery(id, zone, TypeAXFR, false)
+
+	var result *Zone
+	err = c.withDeadline(ctx, func() error {
+		if err := c.writeTCP(query); err != nil {
+			return err
+		}
+
+		z := NewZone(zone)
+		for first := true; ; first = false {
+			msg, err := c.readTCP()
+			if err != nil {
+				return err
+			}
+			// Only the first message has to repeat the question
+			if msg.Header.ID != id || msg.Header.Flags&FlagQR == 0 ||
+				(first && !matchesQuery(msg, id, zone, TypeAXFR)) {
+				return fmt.Errorf("response does not match query")
+			}
+			if rcode := msg.Rcode(); rcode != RcodeNoError {
+				return fmt.Errorf("transfer of %s failed with rcode %d", zone, rcode)
+			}
+			if len(msg.Answers) == 0 {
+				return fmt.Errorf("transfer of %s ended without its closing SOA record", zone)
+			}
+
+			for i, rr := range msg.Answers {
+				isSOA := rr.Type == TypeSOA && strings.EqualFold(rr.Name, zone)
+				switch {
+				case first && i == 0:
+					if !isSOA || rr.SOAData == nil {
+						return fmt.Errorf("transfer of %s does not start with its SOA record", zone)
+					}
+					z.AddRecord(rr)
+				case isSOA:
+					if i != len(msg.Answers)-1 {
+						return fmt.Errorf("transfer of %s has records after its closing SOA record", zone)
+					}
+					result = z
+					return nil
+				case z.IsAuthoritative(rr.Name):
+					z.AddRecord(rr)
+				}
+			}
+		}
+	})
+	return result, err
+}
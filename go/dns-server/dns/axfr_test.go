@@ -0,0 +1,263 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+)
+
+// transferZone returns a zone with an SOA, two NS records and n A records
+func transferZone(n int) *Zone {
+	zone := NewZone("example.com")
+	zone.AddRecord(NewSOARecord("example.com", 3600, &SOA{
+		MName: "ns1.example.com", RName: "admin.example.com",
+		Serial: 2024112001, Refresh: 3600, Retry: 600, Expire: 86400, Minimum: 300,
+	}))
+	zone.AddRecord(NewNSRecord("example.com", 3600, "ns1.example.com"))
+	zone.AddRecord(NewNSRecord("example.com", 3600, "ns2.example.com"))
+	for i := 0; i < n; i++ {
+		zone.AddRecord(NewARecord(fmt.Sprintf("host%03d.example.com", i), 300, net.IPv4(192, 0, 2, byte(i))))
+	}
+	return zone
+}
+
+// transferQuery is an AXFR query for example.com
+func transferQuery() *Message {
+	return &Message{
+		Header:    Header{ID: 0x4242, QDCount: 1},
+		Questions: []Question{{Name: "example.com", Type: TypeAXFR, Class: ClassIN}},
+	}
+}
+
+// parseTransfer parses every message of a transfer and returns them with
+// their answers joined in order
+func parseTransfer(t *testing.T, messages [][]byte) ([]*Message, []ResourceRecord) {
+	t.Helper()
+
+	var parsed []*Message
+	var records []ResourceRecord
+	for i, data := range messages {
+		msg, err := NewParser(data).Parse()
+		if err != nil {
+			t.Fatalf("message %d: Parse error: %v", i, err)
+		}
+		parsed = append(parsed, msg)
+		records = append(records, msg.Answers...)
+	}
+	return parsed, records
+}
+
+func TestBuildTransfer(t *testing.T) {
+	zone := transferZone(3)
+
+	messages, err := NewBuilder().BuildTransfer(transferQuery(), zone, TransferMessageSize)
+	if err != nil {
+		t.Fatalf("BuildTransfer error: %v", err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("messages = %d, want 1 for a small zone", len(messages))
+	}
+
+	parsed, records := parseTransfer(t, messages)
+	msg := parsed[0]
+	if msg.Header.ID != 0x4242 || msg.Header.Flags&FlagQR == 0 || msg.Header.Flags&FlagAA == 0 {
+		t.Errorf("header = %+v, want ID 0x4242 with QR and AA", msg.Header)
+	}
+	if len(msg.Questions) != 1 || msg.Questions[0].Type != TypeAXFR {
+		t.Errorf("questions = %+v, want the AXFR question", msg.Questions)
+	}
+
+	// SOA, NS x2, A x3, SOA
+	if len(records) != 7 {
+		t.Fatalf("records = %d, want 7", len(records))
+	}
+	if records[0].Type != TypeSOA || records[len(records)-1].Type != TypeSOA {
+		t.Errorf("first and last record are %s and %s, want SOA", TypeToString(records[0].Type), TypeToString(records[len(records)-1].Type))
+	}
+	for _, rr := range records[1 : len(records)-1] {
+		if rr.Type == TypeSOA {
+			t.Error("SOA record in the middle of the transfer")
+		}
+	}
+}
+
+func TestBuildTransferMultipleMessages(t *testing.T) {
+	zone := transferZone(200)
+	const maxSize = 1024
+
+	messages, err := NewBuilder().BuildTransfer(transferQuery(), zone, maxSize)
+	if err != nil {
+		t.Fatalf("BuildTransfer error: %v", err)
+	}
+	if len(messages) < 2 {
+		t.Fatalf("messages = %d, want several for 200 records in %d bytes", len(messages), maxSize)
+	}
+
+	parsed, records := parseTransfer(t, messages)
+	for i, msg := range parsed {
+		if len(messages[i]) > maxSize {
+			t.Errorf("message %d is %d bytes, over %d", i, len(messages[i]), maxSize)
+		}
+		if msg.Header.ID != 0x4242 {
+			t.Errorf("message %d: ID = %x, want 0x4242", i, msg.Header.ID)
+		}
+		wantQuestions := 0
+		if i == 0 {
+			wantQuestions = 1
+		}
+		if len(msg.Questions) != wantQuestions {
+			t.Errorf("message %d: questions = %d, want %d", i, len(msg.Questions), wantQuestions)
+		}
+		if len(msg.Answers) == 0 {
+			t.Errorf("message %d has no records", i)
+		}
+	}
+
+	// Every record exactly once, between the two SOAs
+	if want := 1 + 2 + 200 + 1; len(records) != want {
+		t.Fatalf("records = %d, want %d", len(records), want)
+	}
+	if records[0].Type != TypeSOA || records[len(records)-1].Type != TypeSOA {
+		t.Error("transfer doesn't start and end with the SOA record")
+	}
+	seen := make(map[string]bool)
+	for _, rr := range records[1 : len(records)-1] {
+		key := rr.Name + " " + rr.RDataString()
+		if seen[key] {
+			t.Errorf("record %s sent twice", key)
+		}
+		seen[key] = true
+	}
+}
+
+func TestBuildTransferEDNS(t *testing.T) {
+	query := transferQuery()
+	query.Additional = []ResourceRecord{NewOPTRecord(&EDNS{UDPSize: 1232})}
+
+	messages, err := NewBuilder().BuildTransfer(query, transferZone(200), 1024)
+	if err != nil {
+		t.Fatalf("BuildTransfer error: %v", err)
+	}
+	for i, data := range messages {
+		if len(data) > 1024 {
+			t.Errorf("message %d is %d bytes, over 1024 with the OPT record", i, len(data))
+		}
+		msg, err := NewParser(data).Parse()
+		if err != nil {
+			t.Fatalf("message %d: Parse error: %v", i, err)
+		}
+		if edns, err := msg.EDNS(); err != nil || edns == nil {
+			t.Errorf("message %d: EDNS = %+v, %v; want an OPT record", i, edns, err)
+		}
+	}
+}
+
+func TestBuildTransferNoSOA(t *testing.T) {
+	zone := NewZone("example.com")
+	zone.AddRecord(NewARecord("www.example.com", 300, net.IPv4(192, 0, 2, 1)))
+
+	if _, err := NewBuilder().BuildTransfer(transferQuery(), zone, TransferMessageSize); err == nil {
+		t.Error("BuildTransfer succeeded for a zone without SOA, want error")
+	}
+}
+
+// serveTransfer answers one AXFR query on listener with the messages respond
+// returns
+func serveTransfer(t *testing.T, listener net.Listener, respond func(query *Message) [][]byte) {
+	t.Helper()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return
+		}
+		data := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+		query, err := NewParser(data).Parse()
+		if err != nil {
+			return
+		}
+		for _, resp := range respond(query) {
+			binary.BigEndian.PutUint16(length[:], uint16(len(resp)))
+			conn.Write(append(length[:], resp...))
+		}
+	}()
+}
+
+func TestTransfer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	primary := transferZone(200)
+	serveTransfer(t, listener, func(query *Message) [][]byte {
+		messages, err := NewBuilder().BuildTransfer(query, primary, 1024)
+		if err != nil {
+			t.Errorf("BuildTransfer error: %v", err)
+		}
+		return messages
+	})
+
+	zone, err := Transfer(context.Background(), listener.Addr().String(), "example.com")
+	if err != nil {
+		t.Fatalf("Transfer error: %v", err)
+	}
+	if zone.SOA == nil || zone.SOA.Serial != 2024112001 {
+		t.Errorf("SOA = %+v, want serial 2024112001", zone.SOA)
+	}
+	if got, want := len(zone.AllRecords()), len(primary.AllRecords()); got != want {
+		t.Errorf("records = %d, want %d", got, want)
+	}
+	if rrs := zone.Lookup("host150.example.com", TypeA); len(rrs) != 1 || !rrs[0].Address.Equal(net.IPv4(192, 0, 2, 150)) {
+		t.Errorf("host150 = %v, want A 192.0.2.150", rrs)
+	}
+	if rrs := zone.Lookup("example.com", TypeNS); len(rrs) != 2 {
+		t.Errorf("NS records = %d, want 2", len(rrs))
+	}
+}
+
+func TestTransferErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		respond func(query *Message) [][]byte
+	}{
+		{"refused", func(query *Message) [][]byte {
+			return [][]byte{NewBuilder().BuildErrorResponse(query, RcodeRefused)}
+		}},
+		{"no opening SOA", func(query *Message) [][]byte {
+			rr := NewARecord("www.example.com", 300, net.IPv4(192, 0, 2, 1))
+			return [][]byte{NewBuilder().BuildResponse(query, []ResourceRecord{rr}, nil)}
+		}},
+		{"no closing SOA", func(query *Message) [][]byte {
+			messages, _ := NewBuilder().BuildTransfer(query, transferZone(200), 1024)
+			return messages[:len(messages)-1]
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+			serveTransfer(t, listener, tt.respond)
+
+			if zone, err := Transfer(context.Background(), listener.Addr().String(), "example.com"); err == nil {
+				t.Errorf("Transfer = %d records, want error", len(zone.AllRecords()))
+			}
+		})
+	}
+}
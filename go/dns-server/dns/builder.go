@@ -7,34 +7,134 @@ import (
 
 // Builder constructs DNS messages
 type Builder struct {
-	data []byte
+	data  []byte
+	names map[string]int // Offsets of the name suffixes written, for compression
+
+	// EDNSUDPSize is the UDP payload size advertised in responses to
+	// queries with EDNS
+	EDNSUDPSize uint16
+
+	// UDP limits responses to what the client can receive over UDP: 512
+	// bytes, or the payload size its EDNS record advertises but no more
+	// than EDNSUDPSize. Over TCP responses have no such limit.
+	UDP bool
+
+	// Truncated reports whether the last response built didn't fit and
+	// was sent with the TC bit instead of its records
+	Truncated bool
+
+	// Recursive builds responses for answers found by recursion, such as
+	// from a forwarder: recursion available (RA) instead of authoritative
+	// (AA)
+	Recursive bool
+
+	// ClientSubnet is echoed in the OPT record of responses, with the
+	// scope of the answer (RFC 7871 section 7.2.1); nil for none
+	ClientSubnet *ClientSubnet
 }
 
 // NewBuilder creates a new DNS message builder
 func NewBuilder() *Builder {
 	return &Builder{
-		data: make([]byte, 0, 512),
+		data:        make([]byte, 0, 512),
+		names:       make(map[string]int),
+		EDNSUDPSize: DefaultEDNSUDPSize,
 	}
 }
 
-// BuildResponse builds a response message for a query
+// Reset readies the builder for another message, with its settings as
+// NewBuilder leaves them but keeping its buffer, so builders can be pooled.
+// The messages it built before are overwritten.
+func (b *Builder) Reset() {
+	b.reset()
+	b.EDNSUDPSize = DefaultEDNSUDPSize
+	b.UDP = false
+	b.Truncated = false
+	b.Recursive = false
+	b.ClientSubnet = nil
+}
+
+// BuildResponse builds a response message for a query, with an OPT record
+// if the query had one.
+//
+// With UDP set the response must fit the client's limit (see MaxSize). The
+// authority section is optional and left out first; if the answers still
+// don't fit, the response has the TC bit set and only the question (and
+// OPT record), so the client retries over TCP (RFC 2181 section 9).
 func (b *Builder) BuildResponse(query *Message, answers []ResourceRecord, authority []ResourceRecord) []byte {
-	b.data = b.data[:0]
+	return b.buildResponse(query, RcodeNoError, FlagAA, answers, authority, nil, 0, false)
+}
+
+// BuildNegativeResponse builds a response without answers: NXDOMAIN, or
+// with RcodeNoError NODATA. Its authority section holds the proof, such as
+// the SOA record and NSEC records, so unlike BuildResponse it is never
+// left out; if it doesn't fit, the response is truncated.
+func (b *Builder) BuildNegativeResponse(query *Message, rcode uint8, authority []ResourceRecord) []byte {
+	return b.buildResponse(query, rcode, FlagAA, nil, authority, nil, 0, true)
+}
+
+// BuildReferral builds a referral to a child zone: not authoritative, with
+// the child's NS records (and DS records or the proof there are none) in
+// the authority section and the glue addresses of its name servers in the
+// additional section. Glue for name servers in the child zone is needed to
+// reach them at all, so if it doesn't fit the response is truncated (RFC
+// 9471); other glue that doesn't fit is left out.
+func (b *Builder) BuildReferral(query *Message, authority, additional []ResourceRecord) []byte {
+	var cut string
+	for _, rr := range authority {
+		if rr.Type == TypeNS {
+			cut = strings.ToLower(rr.Name)
+			break
+		}
+	}
+
+	// The glue in the child zone goes first
+	glue := make([]ResourceRecord, 0, len(additional))
+	var sibling []ResourceRecord
+	for _, rr := range additional {
+		name := strings.ToLower(rr.Name)
+		if cut != "" && (name == cut || strings.HasSuffix(name, "."+cut)) {
+			glue = append(glue, rr)
+		} else {
+			sibling = append(sibling, rr)
+		}
+	}
+	required := len(glue)
+	return b.buildResponse(query, RcodeNoError, 0, nil, authority, append(glue, sibling...), required, true)
+}
+
+// buildResponse builds any response. The first requiredAdditional records
+// of additional must fit, or the response is truncated like one whose
+// authority section doesn't fit with needAuthority set.
+func (b *Builder) buildResponse(query *Message, rcode uint8, aa uint16, answers, authority, additional []ResourceRecord, requiredAdditional int, needAuthority bool) []byte {
+	b.reset()
+	b.Truncated = false
+	opt := b.responseOPT(query, rcode)
+
+	// Room for everything but the OPT record, which always goes in
+	limit := b.MaxSize(query)
+	if opt != nil {
+		limit -= optRecordSize(opt)
+	}
 
 	// Header
 	header := Header{
 		ID:      query.Header.ID,
-		Flags:   FlagQR | FlagAA, // Response + Authoritative
+		Flags:   FlagQR | aa | uint16(rcode&0x0F), // Response + Authoritative, unless a referral
 		QDCount: uint16(len(query.Questions)),
 		ANCount: uint16(len(answers)),
 		NSCount: uint16(len(authority)),
 		ARCount: 0,
 	}
+	if opt != nil {
+		header.ARCount = 1
+	}
 
 	// Set recursion available if requested
 	if query.Header.Flags&FlagRD != 0 {
 		header.Flags |= FlagRD
 	}
+	header.Flags = b.recursionFlags(header.Flags)
 
 	b.writeHeader(&header)
 
@@ -42,32 +142,97 @@ func (b *Builder) BuildResponse(query *Message, answers []ResourceRecord, author
 	for _, q := range query.Questions {
 		b.writeQuestion(&q)
 	}
+	questionEnd := len(b.data)
 
 	// Answers
 	for _, rr := range answers {
 		b.writeResourceRecord(&rr)
 	}
+	answerEnd := len(b.data)
+
+	if len(b.data) > limit {
+		// Not even the answers fit
+		b.truncate(questionEnd)
+		b.Truncated = true
+		header.Flags |= FlagTC
+		header.ANCount, header.NSCount = 0, 0
+	} else {
+		// Authority
+		for _, rr := range authority {
+			b.writeResourceRecord(&rr)
+		}
+		if len(b.data) > limit && needAuthority {
+			b.truncate(questionEnd)
+			b.Truncated = true
+			header.Flags |= FlagTC
+			header.ANCount, header.NSCount = 0, 0
+		} else if len(b.data) > limit {
+			b.truncate(answerEnd)
+			header.NSCount = 0
+		}
+	}
 
-	// Authority
-	for _, rr := range authority {
-		b.writeResourceRecord(&rr)
+	// Additional, as much as fits
+	if !b.Truncated && header.NSCount > 0 {
+		arCount := header.ARCount
+		for i, rr := range additional {
+			end := len(b.data)
+			b.writeResourceRecord(&rr)
+			if len(b.data) > limit && i < requiredAdditional {
+				b.truncate(questionEnd)
+				b.Truncated = true
+				header.Flags |= FlagTC
+				header.ANCount, header.NSCount, header.ARCount = 0, 0, arCount
+				break
+			}
+			if len(b.data) > limit {
+				b.truncate(end)
+				break
+			}
+			header.ARCount++
+		}
+	}
+	if opt != nil {
+		b.writeResourceRecord(opt)
 	}
 
+	// The counts and flags may have changed above
+	b.patchHeader(&header)
+
 	return b.data
 }
 
-// BuildErrorResponse builds an error response
+// MaxSize returns the largest response to query that may be built: over
+// UDP the client's advertised payload size (at least 512 bytes) capped by
+// EDNSUDPSize, over TCP the largest DNS message.
+func (b *Builder) MaxSize(query *Message) int {
+	if !b.UDP {
+		return 65535
+	}
+	return max(min(query.UDPSize(), int(b.EDNSUDPSize)), MinUDPSize)
+}
+
+// BuildErrorResponse builds an error response, or with RcodeNoError a bare
+// acknowledgement such as the answer to a NOTIFY. The query's opcode is
+// echoed. Extended response codes (above 15, like RcodeBadVersion) need the
+// OPT record of a query with EDNS for their upper bits.
 func (b *Builder) BuildErrorResponse(query *Message, rcode uint8) []byte {
-	b.data = b.data[:0]
+	b.reset()
+	b.Truncated = false
+	opt := b.responseOPT(query, rcode)
 
 	header := Header{
 		ID:      query.Header.ID,
-		Flags:   FlagQR | FlagAA | uint16(rcode),
+		Flags:   FlagQR | FlagAA | query.Header.Flags&OpcodeMask | uint16(rcode&0x0F),
 		QDCount: uint16(len(query.Questions)),
 		ANCount: 0,
 		NSCount: 0,
 		ARCount: 0,
 	}
+	header.Flags = b.recursionFlags(header.Flags)
+	if opt != nil {
+		header.ARCount = 1
+	}
 
 	b.writeHeader(&header)
 
@@ -75,9 +240,30 @@ func (b *Builder) BuildErrorResponse(query *Message, rcode uint8) []byte {
 		b.writeQuestion(&q)
 	}
 
+	if opt != nil {
+		b.writeResourceRecord(opt)
+	}
+
 	return b.data
 }
 
+// recursionFlags swaps AA for RA in flags if the builder is Recursive
+func (b *Builder) recursionFlags(flags uint16) uint16 {
+	if b.Recursive {
+		flags = flags&^FlagAA | FlagRA
+	}
+	return flags
+}
+
+// patchHeader rewrites the header at the start of the message
+func (b *Builder) patchHeader(h *Header) {
+	binary.BigEndian.PutUint16(b.data[2:4], h.Flags)
+	binary.BigEndian.PutUint16(b.data[4:6], h.QDCount)
+	binary.BigEndian.PutUint16(b.data[6:8], h.ANCount)
+	binary.BigEndian.PutUint16(b.data[8:10], h.NSCount)
+	binary.BigEndian.PutUint16(b.data[10:12], h.ARCount)
+}
+
 func (b *Builder) writeHeader(h *Header) {
 	b.writeUint16(h.ID)
 	b.writeUint16(h.Flags)
@@ -99,10 +285,29 @@ func (b *Builder) writeResourceRecord(rr *ResourceRecord) {
 	b.writeUint16(rr.Class)
 	b.writeUint32(rr.TTL)
 
-	// Build RDATA based on type
-	rdata := b.buildRData(rr)
-	b.writeUint16(uint16(len(rdata)))
-	b.data = append(b.data, rdata...)
+	// The names in the RDATA of the types defined in RFC 1035 may be
+	// compressed too; those of any later type must not be (RFC 3597
+	// section 4)
+	lengthAt := len(b.data)
+	b.writeUint16(0)
+	switch {
+	case rr.Type == TypeCNAME || rr.Type == TypeNS || rr.Type == TypePTR:
+		b.writeName(rr.Target)
+	case rr.Type == TypeMX:
+		b.writeUint16(rr.Priority)
+		b.writeName(rr.Target)
+	case rr.Type == TypeSOA && rr.SOAData != nil:
+		b.writeName(rr.SOAData.MName)
+		b.writeName(rr.SOAData.RName)
+		b.writeUint32(rr.SOAData.Serial)
+		b.writeUint32(rr.SOAData.Refresh)
+		b.writeUint32(rr.SOAData.Retry)
+		b.writeUint32(rr.SOAData.Expire)
+		b.writeUint32(rr.SOAData.Minimum)
+	default:
+		b.data = append(b.data, b.buildRData(rr)...)
+	}
+	binary.BigEndian.PutUint16(b.data[lengthAt:], uint16(len(b.data)-lengthAt-2))
 }
 
 func (b *Builder) buildRData(rr *ResourceRecord) []byte {
@@ -111,7 +316,7 @@ func (b *Builder) buildRData(rr *ResourceRecord) []byte {
 		return rr.Address.To4()
 	case TypeAAAA:
 		return rr.Address.To16()
-	case TypeCNAME, TypeNS:
+	case TypeCNAME, TypeNS, TypePTR:
 		return b.encodeName(rr.Target)
 	case TypeMX:
 		data := make([]byte, 2)
@@ -124,12 +329,70 @@ func (b *Builder) buildRData(rr *ResourceRecord) []byte {
 		if rr.SOAData != nil {
 			return b.encodeSOA(rr.SOAData)
 		}
+	case TypeSVCB, TypeHTTPS:
+		if rr.SVCB != nil {
+			return b.encodeSVCB(rr.SVCB)
+		}
+	case TypeCAA:
+		if rr.CAA != nil {
+			data := []byte{rr.CAA.Flags, byte(len(rr.CAA.Tag))}
+			data = append(data, rr.CAA.Tag...)
+			return append(data, rr.CAA.Value...)
+		}
+	case TypeTLSA:
+		if rr.TLSA != nil {
+			data := []byte{rr.TLSA.Usage, rr.TLSA.Selector, rr.TLSA.MatchingType}
+			return append(data, rr.TLSA.Certificate...)
+		}
 	}
 	return rr.RData
 }
 
+// writeName writes a name, compressed: the longest suffix of it already in
+// the message is written as a pointer to it (RFC 1035 section 4.1.4).
+// Suffixes match by case too, so every name keeps the case it was given.
 func (b *Builder) writeName(name string) {
-	b.data = append(b.data, b.encodeName(name)...)
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		b.data = append(b.data, 0)
+		return
+	}
+
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		suffix := strings.Join(labels[i:], ".")
+		if offset, ok := b.names[suffix]; ok {
+			b.data = binary.BigEndian.AppendUint16(b.data, 0xC000|uint16(offset))
+			return
+		}
+		// Pointers have 14 bits for the offset
+		if len(b.data) < 0x4000 {
+			b.names[suffix] = len(b.data)
+		}
+		if len(label) > 63 {
+			label = label[:63]
+		}
+		b.data = append(b.data, byte(len(label)))
+		b.data = append(b.data, label...)
+	}
+	b.data = append(b.data, 0)
+}
+
+// reset starts a new message
+func (b *Builder) reset() {
+	b.data = b.data[:0]
+	clear(b.names)
+}
+
+// truncate cuts the message back to n bytes, forgetting the names written
+// after it
+func (b *Builder) truncate(n int) {
+	b.data = b.data[:n]
+	for name, offset := range b.names {
+		if offset >= n {
+			delete(b.names, name)
+		}
+	}
 }
 
 func (b *Builder) encodeName(name string) []byte {
@@ -0,0 +1,60 @@
+package dns
+
+import (
+	"net"
+	"strings"
+)
+
+// builtinTTL is the TTL of records in the built-in zones
+const builtinTTL = 604800
+
+// LocalhostZones returns the zones every server should answer for itself
+// (RFC 6303, RFC 6761): "localhost" with its loopback addresses, and the
+// reverse zones for 127.0.0.0/8 and ::1. Queries for them should never
+// leave the host.
+func LocalhostZones() []*Zone {
+	localhost := newBuiltinZone("localhost")
+	localhost.AddRecord(NewARecord("localhost", builtinTTL, net.IPv4(127, 0, 0, 1)))
+	localhost.AddRecord(NewAAAARecord("localhost", builtinTTL, net.IPv6loopback))
+
+	reverse4 := newBuiltinZone("127.in-addr.arpa")
+	reverse4.AddRecord(NewPTRRecord("1.0.0.127.in-addr.arpa", builtinTTL, "localhost"))
+
+	reverse6 := newBuiltinZone(ReverseName(net.IPv6loopback))
+	reverse6.AddRecord(NewPTRRecord(reverse6.Name, builtinTTL, "localhost"))
+
+	return []*Zone{localhost, reverse4, reverse6}
+}
+
+// newBuiltinZone creates a zone with the SOA and NS records BIND ships in
+// its default localhost zone files
+func newBuiltinZone(name string) *Zone {
+	zone := NewZone(name)
+	zone.AddRecord(NewSOARecord(name, builtinTTL, &SOA{
+		MName:   "localhost",
+		RName:   "nobody.invalid",
+		Serial:  1,
+		Refresh: 604800,
+		Retry:   86400,
+		Expire:  2419200,
+		Minimum: 86400,
+	}))
+	zone.AddRecord(NewNSRecord(name, builtinTTL, "localhost"))
+	return zone
+}
+
+// ReverseName returns the in-addr.arpa or ip6.arpa name of ip
+func ReverseName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return net.IPv4(ip4[3], ip4[2], ip4[1], ip4[0]).String() + ".in-addr.arpa"
+	}
+
+	const hex = "0123456789abcdef"
+	ip6 := ip.To16()
+	labels := make([]string, 0, 2*len(ip6)+1)
+	for i := len(ip6) - 1; i >= 0; i-- {
+		labels = append(labels, string(hex[ip6[i]&0x0f]), string(hex[ip6[i]>>4]))
+	}
+	labels = append(labels, "ip6.arpa")
+	return strings.Join(labels, ".")
+}
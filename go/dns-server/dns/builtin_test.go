@@ -0,0 +1,70 @@
+package dns
+
+import (
+	"net"
+	"testing"
+)
+
+func TestReverseName(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"127.0.0.1", "1.0.0.127.in-addr.arpa"},
+		{"192.0.2.10", "10.2.0.192.in-addr.arpa"},
+		{"::1", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa"},
+		{"2001:db8::ab", "b.a.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa"},
+	}
+
+	for _, tt := range tests {
+		if got := ReverseName(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("ReverseName(%s) = %s, want %s", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestLocalhostZones(t *testing.T) {
+	zones := make(map[string]*Zone)
+	for _, zone := range LocalhostZones() {
+		if zone.SOA == nil {
+			t.Errorf("zone %s has no SOA", zone.Name)
+		}
+		if len(zone.Lookup(zone.Name, TypeNS)) != 1 {
+			t.Errorf("zone %s has no NS record", zone.Name)
+		}
+		zones[zone.Name] = zone
+	}
+
+	localhost := zones["localhost"]
+	if localhost == nil {
+		t.Fatal("no localhost zone")
+	}
+	if a := localhost.Lookup("localhost", TypeA); len(a) != 1 || !a[0].Address.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("localhost A = %v, want 127.0.0.1", a)
+	}
+	if aaaa := localhost.Lookup("localhost", TypeAAAA); len(aaaa) != 1 || !aaaa[0].Address.Equal(net.IPv6loopback) {
+		t.Errorf("localhost AAAA = %v, want ::1", aaaa)
+	}
+
+	for _, ip := range []string{"127.0.0.1", "::1"} {
+		name := ReverseName(net.ParseIP(ip))
+		var ptr []ResourceRecord
+		for _, zone := range zones {
+			if zone.IsAuthoritative(name) {
+				ptr = zone.Lookup(name, TypePTR)
+			}
+		}
+		if len(ptr) != 1 || ptr[0].Target != "localhost" {
+			t.Errorf("%s PTR = %v, want localhost", name, ptr)
+		}
+	}
+
+	// Building the records must not fail
+	for _, zone := range zones {
+		for _, rr := range zone.AllRecords() {
+			if len(NewBuilder().buildRData(&rr)) == 0 {
+				t.Errorf("%s %s: empty RDATA", rr.Name, TypeToString(rr.Type))
+			}
+		}
+	}
+}
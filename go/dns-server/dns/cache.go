@@ -0,0 +1,169 @@
+package dns
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxCacheTTL caps how long a response is cached, whatever its TTLs say
+// (a week, as RFC 8767 suggests for the longest TTL worth trusting)
+const MaxCacheTTL = 7 * 24 * 60 * 60
+
+// Cache holds responses from upstream servers until their TTLs run out. It
+// holds at most a fixed number of responses; when full, the least recently
+// used one makes room. It is safe for concurrent use.
+type Cache struct {
+	size int
+
+	mu      sync.Mutex
+	entries map[cacheKey]*list.Element
+	lru     *list.List // Most recently used first; values are *cacheEntry
+
+	now func() time.Time // time.Now, replaced in tests
+}
+
+type cacheKey struct {
+	name  string // Lowercase, without the trailing dot
+	qtype uint16
+}
+
+type cacheEntry struct {
+	key       cacheKey
+	rcode     uint8
+	answers   []ResourceRecord
+	authority []ResourceRecord
+	stored    time.Time
+	expires   time.Time
+}
+
+// NewCache creates a cache holding up to size responses
+func NewCache(size int) *Cache {
+	return &Cache{
+		size:    size,
+		entries: make(map[cacheKey]*list.Element),
+		lru:     list.New(),
+		now:     time.Now,
+	}
+}
+
+func newCacheKey(name string, qtype uint16) cacheKey {
+	return cacheKey{name: strings.ToLower(strings.TrimSuffix(name, ".")), qtype: qtype}
+}
+
+// Put caches msg, the response to a query for name and qtype. An answer is
+// cached for the lowest TTL among its records. NXDOMAIN and NODATA
+// responses are cached for the negative TTL of the SOA record in their
+// authority section, the lower of its TTL and MINIMUM field (RFC 2308
+// section 5); without one they aren't cached, nor are other errors. Each
+// record's TTL is capped at the time it is cached for, so a later Get
+// never hands out a TTL longer than the entry has left.
+func (c *Cache) Put(name string, qtype uint16, msg *Message) {
+	rcode := msg.Rcode()
+	var ttl uint32
+	switch {
+	case rcode == RcodeNoError && len(msg.Answers) > 0:
+		ttl = MaxCacheTTL
+		for _, records := range [][]ResourceRecord{msg.Answers, msg.Authority} {
+			for _, rr := range records {
+				ttl = min(ttl, rr.TTL)
+			}
+		}
+	case rcode == RcodeNoError || rcode == RcodeNameError:
+		soa := negativeSOA(msg.Authority)
+		if soa == nil {
+			return
+		}
+		ttl = min(soa.TTL, soa.SOAData.Minimum, MaxCacheTTL)
+	default:
+		return
+	}
+	if ttl == 0 || c.size <= 0 {
+		return
+	}
+
+	now := c.now()
+	entry := &cacheEntry{
+		key:       newCacheKey(name, qtype),
+		rcode:     rcode,
+		answers:   capTTLs(msg.Answers, ttl),
+		authority: capTTLs(msg.Authority, ttl),
+		stored:    now,
+		expires:   now.Add(time.Duration(ttl) * time.Second),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[entry.key]; ok {
+		c.lru.Remove(e)
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Get returns the cached response to a query for name and qtype: its
+// response code, answers and authority records, with their TTLs reduced by
+// the time spent in the cache
+func (c *Cache) Get(name string, qtype uint16) (rcode uint8, answers, authority []ResourceRecord, ok bool) {
+	key := newCacheKey(name, qtype)
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return 0, nil, nil, false
+	}
+	entry := e.Value.(*cacheEntry)
+	if !now.Before(entry.expires) {
+		c.lru.Remove(e)
+		delete(c.entries, key)
+		return 0, nil, nil, false
+	}
+	c.lru.MoveToFront(e)
+
+	age := uint32(now.Sub(entry.stored) / time.Second)
+	return entry.rcode, ageTTLs(entry.answers, age), ageTTLs(entry.authority, age), true
+}
+
+// Len returns the number of responses cached, expired ones included until
+// they are looked up or pushed out
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// negativeSOA returns the SOA record of a negative response's authority
+// section, or nil
+func negativeSOA(authority []ResourceRecord) *ResourceRecord {
+	for i := range authority {
+		if authority[i].Type == TypeSOA && authority[i].SOAData != nil {
+			return &authority[i]
+		}
+	}
+	return nil
+}
+
+// capTTLs returns a copy of records with no TTL above ttl
+func capTTLs(records []ResourceRecord, ttl uint32) []ResourceRecord {
+	out := append([]ResourceRecord(nil), records...)
+	for i := range out {
+		out[i].TTL = min(out[i].TTL, ttl)
+	}
+	return out
+}
+
+// ageTTLs returns a copy of records with age seconds taken off their TTLs
+func ageTTLs(records []ResourceRecord, age uint32) []ResourceRecord {
+	out := append([]ResourceRecord(nil), records...)
+	for i := range out {
+		out[i].TTL -= min(out[i].TTL, age)
+	}
+	return out
+}
@@ -0,0 +1,117 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// testCache returns a cache whose clock is moved with the returned func
+func testCache(size int) (*Cache, func(time.Duration)) {
+	c := NewCache(size)
+	now := time.Unix(1_700_000_000, 0)
+	c.now = func() time.Time { return now }
+	return c, func(d time.Duration) { now = now.Add(d) }
+}
+
+func negativeResponse(rcode uint8, soaTTL, minimum uint32) *Message {
+	soa := NewSOARecord("example.com", soaTTL, &SOA{MName: "ns1.example.com", RName: "hostmaster.example.com", Minimum: minimum})
+	return &Message{Header: Header{Flags: FlagQR | uint16(rcode)}, Authority: []ResourceRecord{soa}}
+}
+
+func TestCacheAnswer(t *testing.T) {
+	c, advance := testCache(10)
+	msg := &Message{
+		Header: Header{Flags: FlagQR},
+		Answers: []ResourceRecord{
+			NewARecord("www.example.com", 300, net.ParseIP("192.0.2.1")),
+			NewARecord("www.example.com", 60, net.ParseIP("192.0.2.2")),
+		},
+	}
+	c.Put("WWW.example.com.", TypeA, msg)
+
+	advance(20 * time.Second)
+	rcode, answers, _, ok := c.Get("www.example.com", TypeA)
+	if !ok || rcode != RcodeNoError || len(answers) != 2 {
+		t.Fatalf("Get = %d, %v, %v; want the 2 answers", rcode, answers, ok)
+	}
+	// Capped at the lowest TTL, then aged
+	for _, rr := range answers {
+		if rr.TTL != 40 {
+			t.Errorf("TTL = %d, want 40", rr.TTL)
+		}
+	}
+	if msg.Answers[0].TTL != 300 {
+		t.Error("Put changed the response's records")
+	}
+
+	if _, _, _, ok := c.Get("www.example.com", TypeAAAA); ok {
+		t.Error("Get of another type hit the cache")
+	}
+
+	advance(40 * time.Second)
+	if _, _, _, ok := c.Get("www.example.com", TypeA); ok {
+		t.Error("Get hit an expired entry")
+	}
+	if c.Len() != 0 {
+		t.Errorf("Len = %d after expiry, want 0", c.Len())
+	}
+}
+
+func TestCacheNegative(t *testing.T) {
+	c, advance := testCache(10)
+
+	// The lower of the SOA's TTL and MINIMUM
+	c.Put("nope.example.com", TypeA, negativeResponse(RcodeNameError, 3600, 300))
+	c.Put("www.example.com", TypeMX, negativeResponse(RcodeNoError, 120, 300))
+
+	advance(100 * time.Second)
+	rcode, answers, authority, ok := c.Get("nope.example.com", TypeA)
+	if !ok || rcode != RcodeNameError || len(answers) != 0 || len(authority) != 1 {
+		t.Fatalf("Get NXDOMAIN = %d, %v, %v, %v; want NXDOMAIN with the SOA", rcode, answers, authority, ok)
+	}
+	if authority[0].TTL != 200 {
+		t.Errorf("SOA TTL = %d, want 200", authority[0].TTL)
+	}
+	if rcode, _, _, ok := c.Get("www.example.com", TypeMX); !ok || rcode != RcodeNoError {
+		t.Errorf("Get NODATA = %d, %v; want NOERROR", rcode, ok)
+	}
+
+	advance(30 * time.Second)
+	if _, _, _, ok := c.Get("www.example.com", TypeMX); ok {
+		t.Error("NODATA cached past the SOA's TTL")
+	}
+
+	// No SOA, no caching; nor for other errors
+	c.Put("x.example.com", TypeA, &Message{Header: Header{Flags: FlagQR | uint16(RcodeNameError)}})
+	c.Put("y.example.com", TypeA, negativeResponse(RcodeServerFailure, 3600, 300))
+	for _, name := range []string{"x.example.com", "y.example.com"} {
+		if _, _, _, ok := c.Get(name, TypeA); ok {
+			t.Errorf("%s was cached", name)
+		}
+	}
+}
+
+func TestCacheLRU(t *testing.T) {
+	c, _ := testCache(2)
+	answer := func(name string) *Message {
+		return &Message{Answers: []ResourceRecord{NewARecord(name, 300, net.ParseIP("192.0.2.1"))}}
+	}
+
+	c.Put("a.example.com", TypeA, answer("a.example.com"))
+	c.Put("b.example.com", TypeA, answer("b.example.com"))
+	c.Get("a.example.com", TypeA) // a is now the most recently used
+	c.Put("c.example.com", TypeA, answer("c.example.com"))
+
+	if c.Len() != 2 {
+		t.Errorf("Len = %d, want 2", c.Len())
+	}
+	if _, _, _, ok := c.Get("b.example.com", TypeA); ok {
+		t.Error("least recently used entry was kept")
+	}
+	for _, name := range []string{"a.example.com", "c.example.com"} {
+		if _, _, _, ok := c.Get(name, TypeA); !ok {
+			t.Errorf("%s was evicted", name)
+		}
+	}
+}
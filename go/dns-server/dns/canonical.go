@@ -0,0 +1,30 @@
+package dns
+
+import (
+	"bytes"
+	"strings"
+)
+
+// CanonicalRData returns the canonical wire form of a record's RDATA as
+// defined in RFC 4034 section 6.2: uncompressed, with domain names in RDATA
+// lowercased. Two records with equal canonical RDATA are duplicates within
+// an RRset.
+func CanonicalRData(rr ResourceRecord) []byte {
+	canon := rr
+	canon.Target = strings.ToLower(rr.Target)
+	if rr.SOAData != nil {
+		soa := *rr.SOAData
+		soa.MName = strings.ToLower(soa.MName)
+		soa.RName = strings.ToLower(soa.RName)
+		canon.SOAData = &soa
+	}
+
+	return NewBuilder().buildRData(&canon)
+}
+
+// CompareCanonical orders two records of the same RRset by canonical RDATA,
+// treating it as a left-justified unsigned octet sequence (RFC 4034 section 6.3).
+// It returns -1, 0 or 1.
+func CompareCanonical(a, b ResourceRecord) int {
+	return bytes.Compare(CanonicalRData(a), CanonicalRData(b))
+}
@@ -0,0 +1,251 @@
+package dns
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultClientTimeout is how long an exchange waits for a response when the
+// context has no deadline
+const DefaultClientTimeout = 5 * time.Second
+
+// Rcode returns the response code of a message
+func (m *Message) Rcode() uint8 {
+	return uint8(m.Header.Flags & 0x000F)
+}
+
+// BuildQuery builds a query with a single question
+func (b *Builder) BuildQuery(id uint16, name string, qtype uint16, recursionDesired bool) []byte {
+	b.reset()
+
+	header := Header{
+		ID:      id,
+		QDCount: 1,
+	}
+	if recursionDesired {
+		header.Flags |= FlagRD
+	}
+
+	b.writeHeader(&header)
+	b.writeQuestion(&Question{Name: name, Type: qtype, Class: ClassIN})
+
+	return b.data
+}
+
+// Conn is a client connection to a DNS server. It is safe for concurrent
+// use; exchanges are serialized.
+//
+// Over UDP the socket is connected to the server, so the kernel drops
+// datagrams from any other address; Exchange checks the source as well.
+// Each upstream should get its own Conn rather than sharing one wildcard
+// socket, which would make off-path spoofing much easier.
+type Conn struct {
+	conn    net.Conn
+	tcp     bool
+	builder *Builder
+	mu      sync.Mutex
+
+	// Timeout applies to exchanges whose context has no deadline
+	Timeout time.Duration
+
+	// RecursionDesired sets the RD flag on queries (default true)
+	RecursionDesired bool
+}
+
+// Dial connects to a DNS server. network is "udp" or "tcp" (optionally with
+// a "4" or "6" suffix); the port defaults to 53 if address has none.
+func Dial(ctx context.Context, network, address string) (*Conn, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(strings.Trim(address, "[]"), "53")
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conn{
+		conn:             conn,
+		tcp:              strings.HasPrefix(network, "tcp"),
+		builder:          NewBuilder(),
+		Timeout:          DefaultClientTimeout,
+		RecursionDesired: true,
+	}, nil
+}
+
+// Close closes the connection
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// Exchange sends a query for name and qtype and returns the matching
+// response. Over UDP, datagrams that don't match the query's ID and
+// question are ignored, so a stray or spoofed packet can't be mistaken
+// for the answer.
+func (c *Conn) Exchange(ctx context.Context, name string, qtype uint16) (*Message, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	query := c.builder.BuildQuery(id, name, qtype, c.RecursionDesired)
+
+	var msg *Message
+	err = c.withDeadline(ctx, func() error {
+		msg, err = c.exchange(query, id, name, qtype)
+		return err
+	})
+	return msg, err
+}
+
+// withDeadline runs fn with the connection's deadline set from ctx (or
+// Timeout), and returns ctx's error instead of fn's if ctx was canceled
+func (c *Conn) withDeadline(ctx context.Context, fn func() error) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(c.Timeout)
+	}
+	c.conn.SetDeadline(deadline)
+	defer c.conn.SetDeadline(time.Time{})
+
+	// Unblock reads and writes if the context is canceled
+	stop := context.AfterFunc(ctx, func() {
+		c.conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	err := fn()
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// writeTCP sends a message with its length in front
+func (c *Conn) writeTCP(data []byte) error {
+	packet := make([]byte, 2+len(data))
+	binary.BigEndian.PutUint16(packet, uint16(len(data)))
+	copy(packet[2:], data)
+	if _, err := c.conn.Write(packet); err != nil {
+		return fmt.Errorf("write query: %w", err)
+	}
+	return nil
+}
+
+// readTCP reads and parses one length-prefixed message
+func (c *Conn) readTCP() (*Message, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(c.conn, length[:]); err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	data := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(c.conn, data); err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+
+	msg, err := NewParser(data).Parse()
+	if err != nil {
+		return nil, fmt.Errorf("parse response: %w", err)
+	}
+	return msg, nil
+}
+
+func (c *Conn) exchange(query []byte, id uint16, name string, qtype uint16) (*Message, error) {
+	if c.tcp {
+		if err := c.writeTCP(query); err != nil {
+			return nil, err
+		}
+		msg, err := c.readTCP()
+		if err != nil {
+			return nil, err
+		}
+		if !matchesQuery(msg, id, name, qtype) {
+			return nil, fmt.Errorf("response does not match query")
+		}
+		return msg, nil
+	}
+
+	if _, err := c.conn.Write(query); err != nil {
+		return nil, fmt.Errorf("write query: %w", err)
+	}
+
+	udp := c.conn.(*net.UDPConn)
+	server := udp.RemoteAddr().(*net.UDPAddr).AddrPort()
+
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := udp.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return nil, fmt.Errorf("read response: %w", err)
+		}
+		if !sameAddrPort(from, server) {
+			continue // Not from the server we asked
+		}
+
+		// Copy so the parsed records don't pin the whole buffer
+		msg, err := NewParser(append([]byte(nil), buf[:n]...)).Parse()
+		if err != nil || !matchesQuery(msg, id, name, qtype) {
+			continue // Not our answer; keep waiting until the deadline
+		}
+		return msg, nil
+	}
+}
+
+// Exchange sends a single query to server over UDP, retrying over TCP if
+// the response was truncated
+func Exchange(ctx context.Context, server, name string, qtype uint16) (*Message, error) {
+	conn, err := Dial(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := conn.Exchange(ctx, name, qtype)
+	conn.Close()
+	if err != nil {
+		return nil, err
+	}
+	if msg.Header.Flags&FlagTC == 0 {
+		return msg, nil
+	}
+
+	conn, err = Dial(ctx, "tcp", server)
+	if err != nil {
+		return nil, fmt.Errorf("response truncated, TCP retry: %w", err)
+	}
+	defer conn.Close()
+	return conn.Exchange(ctx, name, qtype)
+}
+
+// matchesQuery reports whether msg is the response to our query
+func matchesQuery(msg *Message, id uint16, name string, qtype uint16) bool {
+	if msg.Header.ID != id || msg.Header.Flags&FlagQR == 0 || len(msg.Questions) != 1 {
+		return false
+	}
+	q := msg.Questions[0]
+	return q.Type == qtype && strings.EqualFold(strings.TrimSuffix(q.Name, "."), strings.TrimSuffix(name, "."))
+}
+
+// sameAddrPort compares two addresses, treating IPv4-mapped IPv6
+// addresses as the IPv4 addresses they contain
+func sameAddrPort(a, b netip.AddrPort) bool {
+	return a.Addr().Unmap() == b.Addr().Unmap() && a.Port() == b.Port()
+}
+
+// randomID returns an unpredictable query ID
+func randomID() (uint16, error) {
+	var b [2]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, fmt.Errorf("generate query ID: %w", err)
+	}
+	return binary.BigEndian.Uint16(b[:]), nil
+}
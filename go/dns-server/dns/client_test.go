@@ -0,0 +1,214 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// serveUDP answers every query on conn using respond. It stops when conn is closed.
+func serveUDP(t *testing.T, conn net.PacketConn, respond func(query *Message) [][]byte) {
+	t.Helper()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			query, err := NewParser(buf[:n]).Parse()
+			if err != nil {
+				continue
+			}
+			for _, resp := range respond(query) {
+				conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+}
+
+func answerA(query *Message, ip string) []byte {
+	rr := NewARecord(query.Questions[0].Name, 300, net.ParseIP(ip))
+	return NewBuilder().BuildResponse(query, []ResourceRecord{rr}, nil)
+}
+
+func TestBuildQuery(t *testing.T) {
+	data := NewBuilder().BuildQuery(0x1234, "example.com", TypeMX, true)
+
+	msg, err := NewParser(data).Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if msg.Header.ID != 0x1234 {
+		t.Errorf("ID = %x, want 0x1234", msg.Header.ID)
+	}
+	if msg.Header.Flags != FlagRD {
+		t.Errorf("Flags = %x, want RD only", msg.Header.Flags)
+	}
+	if len(msg.Questions) != 1 {
+		t.Fatalf("Questions = %d, want 1", len(msg.Questions))
+	}
+	q := msg.Questions[0]
+	if q.Name != "example.com" || q.Type != TypeMX || q.Class != ClassIN {
+		t.Errorf("Question = %+v, want example.com MX IN", q)
+	}
+}
+
+func TestConnExchangeUDP(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	serveUDP(t, server, func(query *Message) [][]byte {
+		// A stray response with the wrong ID must be ignored
+		stray := *query
+		stray.Header.ID++
+		return [][]byte{answerA(&stray, "192.0.2.99"), answerA(query, "192.0.2.1")}
+	})
+
+	conn, err := Dial(context.Background(), "udp", server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	msg, err := conn.Exchange(context.Background(), "www.example.com", TypeA)
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if msg.Rcode() != RcodeNoError {
+		t.Errorf("Rcode = %d, want %d", msg.Rcode(), RcodeNoError)
+	}
+	if len(msg.Answers) != 1 || !msg.Answers[0].Address.Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("Answers = %v, want www.example.com A 192.0.2.1", msg.Answers)
+	}
+}
+
+func TestConnExchangeIgnoresOtherSources(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	spoofer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spoofer.Close()
+
+	go func() {
+		buf := make([]byte, 512)
+		n, addr, err := server.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		query, err := NewParser(buf[:n]).Parse()
+		if err != nil {
+			return
+		}
+		// A perfectly matching response, but from the wrong address
+		spoofer.WriteTo(answerA(query, "192.0.2.66"), addr)
+		time.Sleep(20 * time.Millisecond)
+		server.WriteTo(answerA(query, "192.0.2.1"), addr)
+	}()
+
+	conn, err := Dial(context.Background(), "udp", server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	msg, err := conn.Exchange(context.Background(), "www.example.com", TypeA)
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if len(msg.Answers) != 1 || !msg.Answers[0].Address.Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("Answers = %v, want the server's 192.0.2.1", msg.Answers)
+	}
+}
+
+func TestConnExchangeTimeout(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close() // Never answers
+
+	conn, err := Dial(context.Background(), "udp", server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := conn.Exchange(ctx, "example.com", TypeA); err != context.DeadlineExceeded {
+		t.Errorf("Exchange error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Exchange took %v, want about 100ms", elapsed)
+	}
+}
+
+func TestExchangeTruncatedRetriesTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	server, err := net.ListenPacket("udp", listener.Addr().String())
+	if err != nil {
+		t.Skipf("UDP port in use: %v", err)
+	}
+	defer server.Close()
+
+	serveUDP(t, server, func(query *Message) [][]byte {
+		resp := NewBuilder().BuildResponse(query, nil, nil)
+		binary.BigEndian.PutUint16(resp[2:], binary.BigEndian.Uint16(resp[2:])|FlagTC)
+		return [][]byte{resp}
+	})
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return
+		}
+		data := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+		query, err := NewParser(data).Parse()
+		if err != nil {
+			return
+		}
+		resp := answerA(query, "192.0.2.2")
+		binary.BigEndian.PutUint16(length[:], uint16(len(resp)))
+		conn.Write(append(length[:], resp...))
+	}()
+
+	msg, err := Exchange(context.Background(), listener.Addr().String(), "example.com", TypeA)
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if msg.Header.Flags&FlagTC != 0 {
+		t.Error("got the truncated UDP response, want the TCP one")
+	}
+	if len(msg.Answers) != 1 || !msg.Answers[0].Address.Equal(net.ParseIP("192.0.2.2")) {
+		t.Errorf("Answers = %v, want example.com A 192.0.2.2", msg.Answers)
+	}
+}
@@ -0,0 +1,119 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+)
+
+// WellKnownDNS64Prefix is the NAT64 well-known prefix (RFC 6052)
+const WellKnownDNS64Prefix = "64:ff9b::/96"
+
+// DNS64 synthesizes AAAA records from A records (RFC 6147), so clients on
+// IPv6-only networks can reach IPv4-only hosts through a NAT64 gateway
+// translating the prefix
+type DNS64 struct {
+	prefix     *net.IPNet
+	wellKnown  bool
+	prefixBits int
+}
+
+// NewDNS64 parses a NAT64 prefix such as "64:ff9b::/96". The prefix length
+// must be one RFC 6052 defines: 32, 40, 48, 56, 64 or 96.
+func NewDNS64(prefix string) (*DNS64, error) {
+	ip, network, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS64 prefix: %w", err)
+	}
+	if ip.To4() != nil {
+		return nil, fmt.Errorf("invalid DNS64 prefix %s: not an IPv6 prefix", prefix)
+	}
+
+	bits, _ := network.Mask.Size()
+	switch bits {
+	case 32, 40, 48, 56, 64, 96:
+	default:
+		return nil, fmt.Errorf("invalid DNS64 prefix %s: length must be 32, 40, 48, 56, 64 or 96", prefix)
+	}
+
+	return &DNS64{
+		prefix:     network,
+		wellKnown:  network.String() == WellKnownDNS64Prefix,
+		prefixBits: bits,
+	}, nil
+}
+
+// Prefix returns the NAT64 prefix in CIDR notation
+func (d *DNS64) Prefix() string {
+	return d.prefix.String()
+}
+
+// Synthesize returns the AAAA record for each A record, with the IPv4
+// address embedded in the prefix. Addresses the well-known prefix must not
+// be used for (private, loopback and other non-global ranges, RFC 6052
+// section 3.1) are skipped. The TTL is capped at maxTTL, the negative
+// caching TTL of the zone (RFC 6147 section 5.1.7); 0 leaves it as is.
+func (d *DNS64) Synthesize(records []ResourceRecord, maxTTL uint32) []ResourceRecord {
+	var synthesized []ResourceRecord
+	for _, rr := range records {
+		if rr.Type != TypeA || rr.Address.To4() == nil {
+			continue
+		}
+		if d.wellKnown && !isGlobalIPv4(rr.Address) {
+			continue
+		}
+
+		ttl := rr.TTL
+		if maxTTL > 0 && ttl > maxTTL {
+			ttl = maxTTL
+		}
+		aaaa := NewAAAARecord(rr.Name, ttl, d.Embed(rr.Address))
+		aaaa.Class = rr.Class
+		synthesized = append(synthesized, aaaa)
+	}
+	return synthesized
+}
+
+// Embed returns the IPv6 address representing ip under the prefix (RFC 6052
+// section 2.2). Bits 64-71 (the "u" octet) are always zero, so the IPv4
+// address is split around them for prefixes shorter than /64.
+func (d *DNS64) Embed(ip net.IP) net.IP {
+	ip4 := ip.To4()
+	out := make(net.IP, net.IPv6len)
+	copy(out, d.prefix.IP.To16())
+
+	pos := d.prefixBits / 8
+	for _, b := range ip4 {
+		if pos == 8 {
+			pos++ // Skip the u octet
+		}
+		out[pos] = b
+		pos++
+	}
+	return out
+}
+
+// isGlobalIPv4 reports whether ip may be represented with the well-known
+// prefix: not private, shared, loopback, link-local or otherwise reserved
+func isGlobalIPv4(ip net.IP) bool {
+	for _, cidr := range nonGlobalIPv4 {
+		if cidr.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// nonGlobalIPv4 are the IPv4 ranges that aren't globally reachable
+var nonGlobalIPv4 = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8",
+		"169.254.0.0/16", "172.16.0.0/12", "192.0.0.0/24", "192.0.2.0/24",
+		"192.168.0.0/16", "198.18.0.0/15", "198.51.100.0/24", "203.0.113.0/24",
+		"224.0.0.0/4", "240.0.0.0/4",
+	} {
+		_, network, _ := net.ParseCIDR(cidr)
+		nets = append(nets, network)
+	}
+	return nets
+}()
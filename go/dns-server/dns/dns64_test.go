@@ -0,0 +1,92 @@
+package dns
+
+import (
+	"net"
+	"testing"
+)
+
+func TestNewDNS64(t *testing.T) {
+	for _, prefix := range []string{"64:ff9b::/96", "2001:db8::/32", "2001:db8:100::/40", "2001:db8:122::/48", "2001:db8:122:300::/56", "2001:db8:122:344::/64"} {
+		if _, err := NewDNS64(prefix); err != nil {
+			t.Errorf("NewDNS64(%s): %v", prefix, err)
+		}
+	}
+
+	for _, prefix := range []string{"", "64:ff9b::", "64:ff9b::/80", "10.0.0.0/8", "2001:db8::/128"} {
+		if _, err := NewDNS64(prefix); err == nil {
+			t.Errorf("NewDNS64(%q) succeeded, want error", prefix)
+		}
+	}
+}
+
+// TestDNS64Embed checks the examples of RFC 6052 section 2.4
+func TestDNS64Embed(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"2001:db8::/32", "2001:db8:c000:221::"},
+		{"2001:db8:100::/40", "2001:db8:1c0:2:21::"},
+		{"2001:db8:122::/48", "2001:db8:122:c000:2:2100::"},
+		{"2001:db8:122:300::/56", "2001:db8:122:3c0:0:221::"},
+		{"2001:db8:122:344::/64", "2001:db8:122:344:c0:2:2100:0"},
+		{"2001:db8:122:344::/96", "2001:db8:122:344::192.0.2.33"},
+		{"64:ff9b::/96", "64:ff9b::192.0.2.33"},
+	}
+
+	ip := net.ParseIP("192.0.2.33")
+	for _, tt := range tests {
+		d, err := NewDNS64(tt.prefix)
+		if err != nil {
+			t.Fatalf("NewDNS64(%s): %v", tt.prefix, err)
+		}
+		if got := d.Embed(ip); !got.Equal(net.ParseIP(tt.want)) {
+			t.Errorf("Embed(%s) under %s = %s, want %s", ip, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestDNS64Synthesize(t *testing.T) {
+	d, err := NewDNS64("2001:db8:64::/96")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := []ResourceRecord{
+		NewARecord("www.example.com", 3600, net.ParseIP("192.0.2.1")),
+		NewARecord("www.example.com", 60, net.ParseIP("10.0.0.1")),
+		NewCNAMERecord("www.example.com", 3600, "other.example.com"),
+	}
+
+	got := d.Synthesize(records, 300)
+	if len(got) != 2 {
+		t.Fatalf("Synthesize returned %d records, want 2", len(got))
+	}
+	if got[0].Type != TypeAAAA || got[0].Name != "www.example.com" || got[0].Class != ClassIN {
+		t.Errorf("record = %+v, want an IN AAAA record for www.example.com", got[0])
+	}
+	if !got[0].Address.Equal(net.ParseIP("2001:db8:64::192.0.2.1")) {
+		t.Errorf("address = %s, want 2001:db8:64::192.0.2.1", got[0].Address)
+	}
+	if got[0].TTL != 300 || got[1].TTL != 60 {
+		t.Errorf("TTLs = %d, %d; want 300 (capped), 60", got[0].TTL, got[1].TTL)
+	}
+}
+
+func TestDNS64WellKnownSkipsNonGlobal(t *testing.T) {
+	d, err := NewDNS64(WellKnownDNS64Prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := []ResourceRecord{
+		NewARecord("a.example.com", 3600, net.ParseIP("10.1.2.3")),
+		NewARecord("a.example.com", 3600, net.ParseIP("192.168.1.1")),
+		NewARecord("a.example.com", 3600, net.ParseIP("8.8.8.8")),
+	}
+
+	got := d.Synthesize(records, 0)
+	if len(got) != 1 || !got[0].Address.Equal(net.ParseIP("64:ff9b::808:808")) {
+		t.Errorf("Synthesize = %+v, want only 64:ff9b::808:808", got)
+	}
+}
@@ -0,0 +1,359 @@
+package dns
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DNSSEC constants (RFC 4034, RFC 6605)
+const (
+	// AlgorithmECDSAP256SHA256 is the only signing algorithm supported:
+	// small keys and signatures, and the recommended choice (RFC 8624)
+	AlgorithmECDSAP256SHA256 uint8 = 13
+
+	// DigestSHA256 is the DS digest type used for the parent zone
+	DigestSHA256 uint8 = 2
+
+	DNSKEYFlagZone uint16 = 1 << 8 // The key signs zone data
+	DNSKEYFlagSEP  uint16 = 1      // Secure Entry Point: a key signing key
+
+	dnskeyProtocol = 3 // Always 3 (RFC 4034 section 2.1.2)
+)
+
+// Key is a DNSSEC signing key, ECDSA P-256 with SHA-256. A key signing key
+// (KSK) signs the zone's DNSKEY RRset and is the one the parent's DS record
+// points at; a zone signing key (ZSK) signs everything else.
+type Key struct {
+	Flags   uint16 // DNSKEYFlagZone, plus DNSKEYFlagSEP for a KSK
+	private *ecdsa.PrivateKey
+}
+
+// GenerateKey creates a new key signing key (ksk set) or zone signing key
+func GenerateKey(ksk bool) (*Key, error) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return newKey(private, ksk), nil
+}
+
+// ParseKeyPEM parses a key saved by MarshalPEM. The file doesn't record
+// whether the key is a KSK, so ksk says which it is.
+func ParseKeyPEM(data []byte, ksk bool) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("no PEM private key found")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	private, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok || private.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("not an ECDSA P-256 key")
+	}
+	return newKey(private, ksk), nil
+}
+
+func newKey(private *ecdsa.PrivateKey, ksk bool) *Key {
+	k := &Key{Flags: DNSKEYFlagZone, private: private}
+	if ksk {
+		k.Flags |= DNSKEYFlagSEP
+	}
+	return k
+}
+
+// MarshalPEM returns the private key as a PKCS #8 PEM block
+func (k *Key) MarshalPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.private)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// IsKSK reports whether the key is a key signing key
+func (k *Key) IsKSK() bool {
+	return k.Flags&DNSKEYFlagSEP != 0
+}
+
+// rdata returns the key's DNSKEY RDATA: flags, protocol, algorithm and the
+// public key as the two 32-byte coordinates (RFC 6605 section 4)
+func (k *Key) rdata() []byte {
+	data := binary.BigEndian.AppendUint16(nil, k.Flags)
+	data = append(data, dnskeyProtocol, AlgorithmECDSAP256SHA256)
+	data = append(data, k.private.X.FillBytes(make([]byte, 32))...)
+	data = append(data, k.private.Y.FillBytes(make([]byte, 32))...)
+	return data
+}
+
+// Tag returns the key tag identifying the key in RRSIG and DS records
+// (RFC 4034 appendix B)
+func (k *Key) Tag() uint16 {
+	var sum uint32
+	for i, b := range k.rdata() {
+		if i%2 == 0 {
+			sum += uint32(b) << 8
+		} else {
+			sum += uint32(b)
+		}
+	}
+	sum += sum >> 16
+	return uint16(sum)
+}
+
+// DNSKEY returns the key's DNSKEY record for zone
+func (k *Key) DNSKEY(zone string, ttl uint32) ResourceRecord {
+	rdata := k.rdata()
+	return ResourceRecord{
+		Name:     zone,
+		Type:     TypeDNSKEY,
+		Class:    ClassIN,
+		TTL:      ttl,
+		RDLength: uint16(len(rdata)),
+		RData:    rdata,
+	}
+}
+
+// DS returns the DS record with a SHA-256 digest of the key, which the
+// parent zone publishes to vouch for it (RFC 4509)
+func (k *Key) DS(zone string, ttl uint32) ResourceRecord {
+	digest := sha256.New()
+	digest.Write(canonicalName(zone))
+	digest.Write(k.rdata())
+
+	rdata := binary.BigEndian.AppendUint16(nil, k.Tag())
+	rdata = append(rdata, AlgorithmECDSAP256SHA256, DigestSHA256)
+	rdata = digest.Sum(rdata)
+	return ResourceRecord{
+		Name:     zone,
+		Type:     TypeDS,
+		Class:    ClassIN,
+		TTL:      ttl,
+		RDLength: uint16(len(rdata)),
+		RData:    rdata,
+	}
+}
+
+// Sign returns the RRSIG record for rrset, made by signer's key and valid
+// from inception to expiration. The records must all have the same name,
+// type and class; they are signed in canonical form (RFC 4034 section
+// 3.1.8.1), so their order and the case of their names don't matter.
+func (k *Key) Sign(rrset []ResourceRecord, signer string, inception, expiration time.Time) (ResourceRecord, error) {
+	if len(rrset) == 0 {
+		return ResourceRecord{}, fmt.Errorf("empty RRset")
+	}
+	first := rrset[0]
+
+	header := rrsigHeader(first.Type, labelCount(first.Name), first.TTL,
+		uint32(expiration.Unix()), uint32(inception.Unix()), k.Tag(), signer)
+	hash := sha256.Sum256(append(bytes.Clone(header), canonicalRRset(rrset, first.TTL)...))
+
+	r, s, err := ecdsa.Sign(rand.Reader, k.private, hash[:])
+	if err != nil {
+		return ResourceRecord{}, err
+	}
+	rdata := append(header, r.FillBytes(make([]byte, 32))...)
+	rdata = append(rdata, s.FillBytes(make([]byte, 32))...)
+
+	return ResourceRecord{
+		Name:     first.Name,
+		Type:     TypeRRSIG,
+		Class:    first.Class,
+		TTL:      first.TTL,
+		RDLength: uint16(len(rdata)),
+		RData:    rdata,
+	}, nil
+}
+
+// rrsigHeader returns the RRSIG RDATA up to the signature
+func rrsigHeader(covered uint16, labels uint8, originalTTL, expiration, inception uint32, keyTag uint16, signer string) []byte {
+	data := binary.BigEndian.AppendUint16(nil, covered)
+	data = append(data, AlgorithmECDSAP256SHA256, labels)
+	data = binary.BigEndian.AppendUint32(data, originalTTL)
+	data = binary.BigEndian.AppendUint32(data, expiration)
+	data = binary.BigEndian.AppendUint32(data, inception)
+	data = binary.BigEndian.AppendUint16(data, keyTag)
+	return append(data, canonicalName(signer)...)
+}
+
+// canonicalRRset returns the records of an RRset as they are signed: in
+// canonical order, each with its owner name lowercased, the original TTL
+// and canonical RDATA
+func canonicalRRset(rrset []ResourceRecord, originalTTL uint32) []byte {
+	rdatas := make([][]byte, len(rrset))
+	for i, rr := range rrset {
+		rdatas[i] = CanonicalRData(rr)
+	}
+	sort.Slice(rdatas, func(i, j int) bool {
+		return bytes.Compare(rdatas[i], rdatas[j]) < 0
+	})
+
+	first := rrset[0]
+	owner := canonicalName(first.Name)
+	var data []byte
+	for i, rdata := range rdatas {
+		if i > 0 && bytes.Equal(rdata, rdatas[i-1]) {
+			continue // Duplicates are signed once (RFC 4034 section 6.3)
+		}
+		data = append(data, owner...)
+		data = binary.BigEndian.AppendUint16(data, first.Type)
+		data = binary.BigEndian.AppendUint16(data, first.Class)
+		data = binary.BigEndian.AppendUint32(data, originalTTL)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(rdata)))
+		data = append(data, rdata...)
+	}
+	return data
+}
+
+// canonicalName returns a name in canonical wire form: lowercase and
+// uncompressed
+func canonicalName(name string) []byte {
+	return NewBuilder().encodeName(strings.ToLower(name))
+}
+
+// labelCount returns the RRSIG labels field for a name: its labels, not
+// counting the root or a leading wildcard
+func labelCount(name string) uint8 {
+	labels := strings.Split(strings.TrimSuffix(name, "."), ".")
+	if labels[0] == "" {
+		return 0
+	}
+	if labels[0] == "*" {
+		return uint8(len(labels) - 1)
+	}
+	return uint8(len(labels))
+}
+
+// CompareNames orders two names canonically (RFC 4034 section 6.1): label
+// by label from the right, each compared as lowercase octets, with a name
+// sorting before the names below it. It returns -1, 0 or 1.
+func CompareNames(a, b string) int {
+	la := splitName(strings.ToLower(a))
+	lb := splitName(strings.ToLower(b))
+	for i := 1; i <= len(la) && i <= len(lb); i++ {
+		if cmp := strings.Compare(la[len(la)-i], lb[len(lb)-i]); cmp != 0 {
+			return cmp
+		}
+	}
+	switch {
+	case len(la) < len(lb):
+		return -1
+	case len(la) > len(lb):
+		return 1
+	}
+	return 0
+}
+
+func splitName(name string) []string {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, ".")
+}
+
+// NewNSECRecord creates an NSEC record saying name has records of types,
+// and that no names exist between it and next (RFC 4034 section 4)
+func NewNSECRecord(name string, ttl uint32, next string, types []uint16) ResourceRecord {
+	rdata := append(canonicalName(next), typeBitmap(types)...)
+	return ResourceRecord{
+		Name:     name,
+		Type:     TypeNSEC,
+		Class:    ClassIN,
+		TTL:      ttl,
+		RDLength: uint16(len(rdata)),
+		RData:    rdata,
+	}
+}
+
+// typeBitmap encodes a set of types as NSEC type bit maps: a window block
+// per 256 types in use, each with only as many bitmap bytes as it needs
+// (RFC 4034 section 4.1.2)
+func typeBitmap(types []uint16) []byte {
+	sorted := append([]uint16(nil), types...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var data []byte
+	for i := 0; i < len(sorted); {
+		window := sorted[i] >> 8
+		var bitmap [32]byte
+		length := 0
+		for ; i < len(sorted) && sorted[i]>>8 == window; i++ {
+			low := sorted[i] & 0xff
+			bitmap[low/8] |= 0x80 >> (low % 8)
+			length = int(low/8) + 1
+		}
+		data = append(data, byte(window), byte(length))
+		data = append(data, bitmap[:length]...)
+	}
+	return data
+}
+
+// rrsigTimeFormat is how RRSIG validity times are written in zone files
+const rrsigTimeFormat = "20060102150405"
+
+// rrsigString formats RRSIG RDATA in presentation format
+func rrsigString(rdata []byte) (string, bool) {
+	if len(rdata) < 18 {
+		return "", false
+	}
+	p := NewParser(rdata[18:])
+	signer, err := p.parseName()
+	if err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("%s %d %d %d %s %s %d %s. %s",
+		TypeToString(binary.BigEndian.Uint16(rdata[0:2])), rdata[2], rdata[3],
+		binary.BigEndian.Uint32(rdata[4:8]),
+		time.Unix(int64(binary.BigEndian.Uint32(rdata[8:12])), 0).UTC().Format(rrsigTimeFormat),
+		time.Unix(int64(binary.BigEndian.Uint32(rdata[12:16])), 0).UTC().Format(rrsigTimeFormat),
+		binary.BigEndian.Uint16(rdata[16:18]), signer,
+		base64.StdEncoding.EncodeToString(rdata[18+p.pos:])), true
+}
+
+// nsecString formats NSEC RDATA in presentation format
+func nsecString(rdata []byte) (string, bool) {
+	p := NewParser(rdata)
+	next, err := p.parseName()
+	if err != nil {
+		return "", false
+	}
+	types, ok := bitmapString(rdata[p.pos:])
+	if !ok {
+		return "", false
+	}
+	return next + "." + types, true
+}
+
+// bitmapString formats NSEC or NSEC3 type bit maps as the types they list,
+// each after a space
+func bitmapString(bitmaps []byte) (string, bool) {
+	var text string
+	for len(bitmaps) > 0 {
+		if len(bitmaps) < 2 || len(bitmaps) < 2+int(bitmaps[1]) {
+			return "", false
+		}
+		window, bitmap := uint16(bitmaps[0])<<8, bitmaps[2:2+int(bitmaps[1])]
+		for i, b := range bitmap {
+			for bit := 0; bit < 8; bit++ {
+				if b&(0x80>>bit) != 0 {
+					text += " " + TypeToString(window|uint16(i*8+bit))
+				}
+			}
+		}
+		bitmaps = bitmaps[2+len(bitmap):]
+	}
+	return text, true
+}
@@ -0,0 +1,252 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Dnstap message types (dnstap.proto, Message.Type)
+const (
+	DnstapAuthQuery      uint8 = 1 // Query received by an authoritative server
+	DnstapAuthResponse   uint8 = 2 // Response sent by an authoritative server
+	DnstapClientQuery    uint8 = 5 // Query received by a resolver
+	DnstapClientResponse uint8 = 6 // Response sent by a resolver
+)
+
+// Dnstap socket protocols (dnstap.proto, SocketProtocol)
+const (
+	DnstapUDP uint8 = 1
+	DnstapTCP uint8 = 2
+	DnstapDoT uint8 = 3
+	DnstapDoH uint8 = 4
+)
+
+// DnstapContentType is the Frame Streams content type of dnstap data
+const DnstapContentType = "protobuf:dnstap.Dnstap"
+
+// DnstapMessage is one dnstap message (https://dnstap.info): a query or
+// response as seen by the server, with who sent it and when
+type DnstapMessage struct {
+	Type     uint8 // DnstapAuthQuery, ...
+	Protocol uint8 // DnstapUDP, ...
+
+	QueryAddress    net.IP // The client's
+	QueryPort       uint16
+	ResponseAddress net.IP // The server's, if known
+	ResponsePort    uint16
+
+	QueryTime       time.Time
+	QueryMessage    []byte
+	ResponseTime    time.Time // Responses only
+	ResponseMessage []byte    // Responses only
+}
+
+// Marshal encodes the message in a Dnstap protobuf, the payload of one
+// Frame Streams data frame. identity and version name the server, and are
+// left out if empty.
+func (m *DnstapMessage) Marshal(identity, version string) []byte {
+	var msg []byte
+	msg = protoVarint(msg, 1, uint64(m.Type))
+	ip := m.QueryAddress
+	if ip == nil {
+		ip = m.ResponseAddress
+	}
+	if ip != nil {
+		family := uint64(2) // INET6
+		if ip.To4() != nil {
+			family = 1 // INET
+		}
+		msg = protoVarint(msg, 2, family)
+	}
+	if m.Protocol != 0 {
+		msg = protoVarint(msg, 3, uint64(m.Protocol))
+	}
+	if m.QueryAddress != nil {
+		msg = protoBytes(msg, 4, dnstapAddress(m.QueryAddress))
+		msg = protoVarint(msg, 6, uint64(m.QueryPort))
+	}
+	if m.ResponseAddress != nil {
+		msg = protoBytes(msg, 5, dnstapAddress(m.ResponseAddress))
+		msg = protoVarint(msg, 7, uint64(m.ResponsePort))
+	}
+	if !m.QueryTime.IsZero() {
+		msg = protoVarint(msg, 8, uint64(m.QueryTime.Unix()))
+		msg = protoFixed32(msg, 9, uint32(m.QueryTime.Nanosecond()))
+	}
+	if m.QueryMessage != nil {
+		msg = protoBytes(msg, 10, m.QueryMessage)
+	}
+	if !m.ResponseTime.IsZero() {
+		msg = protoVarint(msg, 12, uint64(m.ResponseTime.Unix()))
+		msg = protoFixed32(msg, 13, uint32(m.ResponseTime.Nanosecond()))
+	}
+	if m.ResponseMessage != nil {
+		msg = protoBytes(msg, 14, m.ResponseMessage)
+	}
+
+	var data []byte
+	if identity != "" {
+		data = protoBytes(data, 1, []byte(identity))
+	}
+	if version != "" {
+		data = protoBytes(data, 2, []byte(version))
+	}
+	data = protoBytes(data, 14, msg)
+	data = protoVarint(data, 15, 1) // Dnstap.Type MESSAGE
+	return data
+}
+
+// dnstapAddress returns an address in 4 bytes for IPv4, 16 for IPv6
+func dnstapAddress(ip net.IP) []byte {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip.To16()
+}
+
+// protoVarint appends a varint field
+func protoVarint(b []byte, field int, v uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3)
+	return binary.AppendUvarint(b, v)
+}
+
+// protoBytes appends a length-delimited field
+func protoBytes(b []byte, field int, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// protoFixed32 appends a fixed32 field
+func protoFixed32(b []byte, field int, v uint32) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|5)
+	return binary.LittleEndian.AppendUint32(b, v)
+}
+
+// Frame Streams control frame types
+const (
+	fstrmControlAccept uint32 = 1
+	fstrmControlStart  uint32 = 2
+	fstrmControlStop   uint32 = 3
+	fstrmControlReady  uint32 = 4
+	fstrmControlFinish uint32 = 5
+
+	fstrmFieldContentType uint32 = 1
+)
+
+// FrameStreamWriter writes data frames to a reader over a bidirectional
+// Frame Streams connection (https://github.com/farsightsec/fstrm), such as
+// a dnstap collector's unix socket
+type FrameStreamWriter struct {
+	conn io.ReadWriter
+}
+
+// NewFrameStreamWriter starts a bidirectional Frame Streams session on
+// conn: it offers contentType with READY, waits for the reader to ACCEPT
+// it, and sends START
+func NewFrameStreamWriter(conn io.ReadWriter, contentType string) (*FrameStreamWriter, error) {
+	w := &FrameStreamWriter{conn: conn}
+	if err := w.writeControl(fstrmControlReady, contentType); err != nil {
+		return nil, err
+	}
+	ctype, types, err := readFstrmControl(conn)
+	if err != nil {
+		return nil, err
+	}
+	if ctype != fstrmControlAccept {
+		return nil, fmt.Errorf("frame streams: got control frame %d, want ACCEPT", ctype)
+	}
+	accepted := len(types) == 0
+	for _, t := range types {
+		accepted = accepted || t == contentType
+	}
+	if !accepted {
+		return nil, fmt.Errorf("frame streams: reader doesn't accept %s", contentType)
+	}
+	if err := w.writeControl(fstrmControlStart, contentType); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// WriteFrame writes one data frame
+func (w *FrameStreamWriter) WriteFrame(data []byte) error {
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	_, err := w.conn.Write(append(frame, data...))
+	return err
+}
+
+// Close ends the session: it sends STOP and waits for the reader's FINISH.
+// The connection is left open.
+func (w *FrameStreamWriter) Close() error {
+	if err := w.writeControl(fstrmControlStop, ""); err != nil {
+		return err
+	}
+	ctype, _, err := readFstrmControl(w.conn)
+	if err != nil {
+		return err
+	}
+	if ctype != fstrmControlFinish {
+		return fmt.Errorf("frame streams: got control frame %d, want FINISH", ctype)
+	}
+	return nil
+}
+
+// writeControl writes a control frame: an escape (a zero length), the
+// frame's length, its type, and the content type field if given
+func (w *FrameStreamWriter) writeControl(ctype uint32, contentType string) error {
+	var payload []byte
+	payload = binary.BigEndian.AppendUint32(payload, ctype)
+	if contentType != "" {
+		payload = binary.BigEndian.AppendUint32(payload, fstrmFieldContentType)
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(contentType)))
+		payload = append(payload, contentType...)
+	}
+	frame := binary.BigEndian.AppendUint32(nil, 0)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(payload)))
+	_, err := w.conn.Write(append(frame, payload...))
+	return err
+}
+
+// fstrmMaxControlSize bounds the control frames read, which are small
+const fstrmMaxControlSize = 512
+
+// readFstrmControl reads a control frame, returning its type and content types
+func readFstrmControl(r io.Reader) (ctype uint32, contentTypes []string, err error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, fmt.Errorf("frame streams: %w", err)
+	}
+	if binary.BigEndian.Uint32(header[:4]) != 0 {
+		return 0, nil, fmt.Errorf("frame streams: got a data frame, want a control frame")
+	}
+	length := binary.BigEndian.Uint32(header[4:])
+	if length < 4 || length > fstrmMaxControlSize {
+		return 0, nil, fmt.Errorf("frame streams: control frame of %d bytes", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, fmt.Errorf("frame streams: %w", err)
+	}
+
+	ctype = binary.BigEndian.Uint32(payload)
+	fields := bytes.NewReader(payload[4:])
+	for fields.Len() > 0 {
+		var field [8]byte
+		if _, err := io.ReadFull(fields, field[:]); err != nil {
+			return 0, nil, fmt.Errorf("frame streams: truncated control field")
+		}
+		value := make([]byte, binary.BigEndian.Uint32(field[4:]))
+		if _, err := io.ReadFull(fields, value); err != nil {
+			return 0, nil, fmt.Errorf("frame streams: truncated control field")
+		}
+		if binary.BigEndian.Uint32(field[:4]) == fstrmFieldContentType {
+			contentTypes = append(contentTypes, string(value))
+		}
+	}
+	return ctype, contentTypes, nil
+}
@@ -0,0 +1,90 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDnstapMarshal(t *testing.T) {
+	m := &DnstapMessage{
+		Type:         DnstapAuthQuery,
+		Protocol:     DnstapUDP,
+		QueryAddress: net.ParseIP("192.0.2.1"),
+		QueryPort:    5353,
+		QueryTime:    time.Unix(1700000000, 5),
+		QueryMessage: []byte{0xab},
+	}
+	want := []byte{
+		0x0a, 0x03, 'n', 's', '1', // identity
+		0x72, 0x1d, // message
+		0x08, 0x01, // type AUTH_QUERY
+		0x10, 0x01, // socket_family INET
+		0x18, 0x01, // socket_protocol UDP
+		0x22, 0x04, 0xc0, 0x00, 0x02, 0x01, // query_address
+		0x30, 0xe9, 0x29, // query_port
+		0x40, 0x80, 0xe2, 0xcf, 0xaa, 0x06, // query_time_sec
+		0x4d, 0x05, 0x00, 0x00, 0x00, // query_time_nsec
+		0x52, 0x01, 0xab, // query_message
+		0x78, 0x01, // type MESSAGE
+	}
+	if got := m.Marshal("ns1", ""); !bytes.Equal(got, want) {
+		t.Errorf("Marshal = % x, want % x", got, want)
+	}
+}
+
+func TestFrameStreamWriter(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	// The reader's side of the handshake, then the frames it got
+	frames := make(chan []byte, 1)
+	errs := make(chan error, 1)
+	go func() {
+		defer server.Close()
+		ctype, types, err := readFstrmControl(server)
+		if err != nil || ctype != fstrmControlReady || len(types) != 1 || types[0] != DnstapContentType {
+			errs <- err
+			return
+		}
+		accept := &FrameStreamWriter{conn: server}
+		if err := accept.writeControl(fstrmControlAccept, DnstapContentType); err != nil {
+			errs <- err
+			return
+		}
+		if ctype, _, err := readFstrmControl(server); err != nil || ctype != fstrmControlStart {
+			errs <- err
+			return
+		}
+		var length [4]byte
+		io.ReadFull(server, length[:])
+		frame := make([]byte, binary.BigEndian.Uint32(length[:]))
+		io.ReadFull(server, frame)
+		frames <- frame
+		if ctype, _, err := readFstrmControl(server); err != nil || ctype != fstrmControlStop {
+			errs <- err
+			return
+		}
+		errs <- accept.writeControl(fstrmControlFinish, "")
+	}()
+
+	w, err := NewFrameStreamWriter(client, DnstapContentType)
+	if err != nil {
+		t.Fatalf("NewFrameStreamWriter: %v", err)
+	}
+	if err := w.WriteFrame([]byte("hello")); err != nil {
+		t.Fatalf("WriteFrame: %v", err)
+	}
+	if got := <-frames; string(got) != "hello" {
+		t.Errorf("frame = %q, want hello", got)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := <-errs; err != nil {
+		t.Errorf("reader: %v", err)
+	}
+}
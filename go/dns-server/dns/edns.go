@@ -0,0 +1,217 @@
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// EDNS0 (RFC 6891) constants
+const (
+	// MinUDPSize is the largest UDP message a client without EDNS accepts
+	MinUDPSize = 512
+
+	// DefaultEDNSUDPSize is the UDP payload size advertised in responses:
+	// 1232 bytes fits the IPv6 minimum MTU without fragmentation (DNS Flag
+	// Day 2020)
+	DefaultEDNSUDPSize = 1232
+
+	// RcodeBadVersion is the extended response code for an EDNS version
+	// the server doesn't implement (BADVERS)
+	RcodeBadVersion uint8 = 16
+)
+
+// EDNSOptionClientSubnet is the code of the EDNS Client Subnet option
+// (RFC 7871)
+const EDNSOptionClientSubnet uint16 = 8
+
+// Address families of a client subnet (IANA address family numbers)
+const (
+	familyIPv4 uint16 = 1
+	familyIPv6 uint16 = 2
+)
+
+// ednsFlagDO is the "DNSSEC OK" bit in the OPT record's flags
+const ednsFlagDO = 1 << 15
+
+// EDNS holds the EDNS0 parameters carried in a message's OPT pseudo-record
+type EDNS struct {
+	UDPSize       uint16 // Largest UDP payload the sender can receive
+	ExtendedRcode uint8  // Upper 8 bits of the 12-bit response code
+	Version       uint8
+	DO            bool // DNSSEC OK
+	Options       []EDNSOption
+}
+
+// EDNSOption is one option of an OPT record
+type EDNSOption struct {
+	Code uint16
+	Data []byte
+}
+
+// EDNS returns the EDNS parameters of the message, or nil if it has no OPT
+// record. A message with more than one OPT record, or a malformed one, is
+// an error (RFC 6891 section 6.1.1), which a server answers with FORMERR.
+func (m *Message) EDNS() (*EDNS, error) {
+	var edns *EDNS
+	for _, rr := range m.Additional {
+		if rr.Type != TypeOPT {
+			continue
+		}
+		if edns != nil {
+			return nil, fmt.Errorf("more than one OPT record")
+		}
+		if rr.Name != "" {
+			return nil, fmt.Errorf("OPT record owner %q is not the root", rr.Name)
+		}
+
+		options, err := parseEDNSOptions(rr.RData)
+		if err != nil {
+			return nil, err
+		}
+		edns = &EDNS{
+			UDPSize:       rr.Class,
+			ExtendedRcode: uint8(rr.TTL >> 24),
+			Version:       uint8(rr.TTL >> 16),
+			DO:            rr.TTL&ednsFlagDO != 0,
+			Options:       options,
+		}
+	}
+	return edns, nil
+}
+
+// ClientSubnet is an EDNS Client Subnet option (RFC 7871): the network of
+// the client a resolver is asking for, so an answer can depend on where
+// the client is rather than the resolver, and in a response how much of
+// that network the answer holds for
+type ClientSubnet struct {
+	Address      net.IP // 4 bytes for IPv4, 16 for IPv6; bits past SourcePrefix are zero
+	SourcePrefix uint8  // Bits of Address the resolver sent
+	ScopePrefix  uint8  // Bits the answer depends on; 0 in queries
+}
+
+// ClientSubnet returns the Client Subnet option, or nil if there is none.
+// A malformed option is an error, which a server answers with FORMERR
+// (RFC 7871 section 7.1.1).
+func (e *EDNS) ClientSubnet() (*ClientSubnet, error) {
+	for _, opt := range e.Options {
+		if opt.Code != EDNSOptionClientSubnet {
+			continue
+		}
+		if len(opt.Data) < 4 {
+			return nil, fmt.Errorf("client subnet option too short")
+		}
+		family := binary.BigEndian.Uint16(opt.Data[0:2])
+		c := &ClientSubnet{SourcePrefix: opt.Data[2], ScopePrefix: opt.Data[3]}
+		switch family {
+		case familyIPv4:
+			c.Address = make(net.IP, net.IPv4len)
+		case familyIPv6:
+			c.Address = make(net.IP, net.IPv6len)
+		default:
+			return nil, fmt.Errorf("client subnet of unknown address family %d", family)
+		}
+		if int(c.SourcePrefix) > 8*len(c.Address) {
+			return nil, fmt.Errorf("client subnet source prefix /%d too long", c.SourcePrefix)
+		}
+		address := opt.Data[4:]
+		if len(address) != (int(c.SourcePrefix)+7)/8 {
+			return nil, fmt.Errorf("client subnet address of %d bytes for a /%d", len(address), c.SourcePrefix)
+		}
+		copy(c.Address, address)
+		bits := 8 * len(c.Address)
+		if !c.Address.Mask(net.CIDRMask(int(c.SourcePrefix), bits)).Equal(c.Address) {
+			return nil, fmt.Errorf("client subnet address has bits set past /%d", c.SourcePrefix)
+		}
+		return c, nil
+	}
+	return nil, nil
+}
+
+// Option encodes the client subnet as an EDNS option, with only as many
+// bytes of the address as the source prefix covers
+func (c *ClientSubnet) Option() EDNSOption {
+	family, address := familyIPv6, c.Address.To16()
+	if ip4 := c.Address.To4(); ip4 != nil {
+		family, address = familyIPv4, ip4
+	}
+	data := binary.BigEndian.AppendUint16(nil, family)
+	data = append(data, c.SourcePrefix, c.ScopePrefix)
+	data = append(data, address[:(int(c.SourcePrefix)+7)/8]...)
+	return EDNSOption{Code: EDNSOptionClientSubnet, Data: data}
+}
+
+// UDPSize returns the largest UDP response the sender of a query accepts:
+// its advertised EDNS payload size, but never less than 512 bytes
+func (m *Message) UDPSize() int {
+	edns, err := m.EDNS()
+	if err != nil || edns == nil || edns.UDPSize < MinUDPSize {
+		return MinUDPSize
+	}
+	return int(edns.UDPSize)
+}
+
+func parseEDNSOptions(data []byte) ([]EDNSOption, error) {
+	var options []EDNSOption
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("EDNS option too short")
+		}
+		code := binary.BigEndian.Uint16(data[0:2])
+		length := int(binary.BigEndian.Uint16(data[2:4]))
+		if 4+length > len(data) {
+			return nil, fmt.Errorf("EDNS option %d extends past the record", code)
+		}
+		options = append(options, EDNSOption{Code: code, Data: data[4 : 4+length]})
+		data = data[4+length:]
+	}
+	return options, nil
+}
+
+// NewOPTRecord creates the OPT pseudo-record carrying e
+func NewOPTRecord(e *EDNS) ResourceRecord {
+	ttl := uint32(e.ExtendedRcode)<<24 | uint32(e.Version)<<16
+	if e.DO {
+		ttl |= ednsFlagDO
+	}
+
+	var rdata []byte
+	for _, opt := range e.Options {
+		rdata = binary.BigEndian.AppendUint16(rdata, opt.Code)
+		rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(opt.Data)))
+		rdata = append(rdata, opt.Data...)
+	}
+
+	return ResourceRecord{
+		Name:     "",
+		Type:     TypeOPT,
+		Class:    e.UDPSize,
+		TTL:      ttl,
+		RDLength: uint16(len(rdata)),
+		RData:    rdata,
+	}
+}
+
+// optRecordSize is the wire size of an OPT record: root name, type, class,
+// TTL, RDLENGTH and the options
+func optRecordSize(opt *ResourceRecord) int {
+	return 1 + 2 + 2 + 4 + 2 + len(opt.RData)
+}
+
+// responseOPT returns the OPT record for a response to query, or nil if
+// the query had none (a client that doesn't speak EDNS must not get one).
+// The response advertises our own payload size and carries the upper bits
+// of rcode; DO is echoed, as RFC 3225 section 3 asks, and of the options
+// only the builder's ClientSubnet.
+func (b *Builder) responseOPT(query *Message, rcode uint8) *ResourceRecord {
+	edns, err := query.EDNS()
+	if err != nil || edns == nil {
+		return nil
+	}
+	response := &EDNS{UDPSize: b.EDNSUDPSize, ExtendedRcode: rcode >> 4, DO: edns.DO}
+	if b.ClientSubnet != nil {
+		response.Options = []EDNSOption{b.ClientSubnet.Option()}
+	}
+	opt := NewOPTRecord(response)
+	return &opt
+}
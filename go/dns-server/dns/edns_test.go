@@ -0,0 +1,345 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+// parseQueryWithAdditional builds an example.com A query carrying the
+// additional records and parses it back
+func parseQueryWithAdditional(t *testing.T, additional ...ResourceRecord) *Message {
+	t.Helper()
+
+	b := NewBuilder()
+	b.BuildQuery(0x1234, "example.com", TypeA, false)
+	for _, rr := range additional {
+		b.writeResourceRecord(&rr)
+	}
+	binary.BigEndian.PutUint16(b.data[10:12], uint16(len(additional)))
+
+	msg, err := NewParser(b.data).Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	return msg
+}
+
+func TestEDNSParse(t *testing.T) {
+	opt := NewOPTRecord(&EDNS{
+		UDPSize: 4096,
+		Version: 0,
+		DO:      true,
+		Options: []EDNSOption{{Code: 10, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}}, // COOKIE
+	})
+	msg := parseQueryWithAdditional(t, opt)
+
+	edns, err := msg.EDNS()
+	if err != nil {
+		t.Fatalf("EDNS error: %v", err)
+	}
+	if edns == nil {
+		t.Fatal("EDNS = nil, want the OPT record's parameters")
+	}
+	if edns.UDPSize != 4096 || edns.Version != 0 || !edns.DO {
+		t.Errorf("EDNS = %+v, want UDP size 4096, version 0, DO", edns)
+	}
+	if len(edns.Options) != 1 || edns.Options[0].Code != 10 || !bytes.Equal(edns.Options[0].Data, []byte{1, 2, 3, 4, 5, 6, 7, 8}) {
+		t.Errorf("Options = %+v, want the cookie option", edns.Options)
+	}
+	if got := msg.UDPSize(); got != 4096 {
+		t.Errorf("UDPSize = %d, want 4096", got)
+	}
+}
+
+func TestEDNSAbsent(t *testing.T) {
+	msg := parseQueryWithAdditional(t)
+
+	edns, err := msg.EDNS()
+	if err != nil || edns != nil {
+		t.Errorf("EDNS = %+v, %v; want nil, nil", edns, err)
+	}
+	if got := msg.UDPSize(); got != MinUDPSize {
+		t.Errorf("UDPSize = %d, want %d", got, MinUDPSize)
+	}
+}
+
+func TestEDNSSmallUDPSize(t *testing.T) {
+	msg := parseQueryWithAdditional(t, NewOPTRecord(&EDNS{UDPSize: 100}))
+	if got := msg.UDPSize(); got != MinUDPSize {
+		t.Errorf("UDPSize = %d, want %d (never below 512)", got, MinUDPSize)
+	}
+}
+
+func TestEDNSMalformed(t *testing.T) {
+	opt := NewOPTRecord(&EDNS{UDPSize: 1232})
+
+	tests := []struct {
+		name       string
+		additional []ResourceRecord
+	}{
+		{"two OPT records", []ResourceRecord{opt, opt}},
+		{"owner not root", []ResourceRecord{{Name: "example.com", Type: TypeOPT, Class: 1232}}},
+		{"truncated option", []ResourceRecord{{Type: TypeOPT, Class: 1232, RData: []byte{0, 10, 0, 8, 1, 2}}}},
+	}
+
+	for _, tt := range tests {
+		msg := parseQueryWithAdditional(t, tt.additional...)
+		if _, err := msg.EDNS(); err == nil {
+			t.Errorf("%s: EDNS succeeded, want error", tt.name)
+		}
+	}
+}
+
+func TestBuildResponseEDNS(t *testing.T) {
+	query := parseQueryWithAdditional(t, NewOPTRecord(&EDNS{UDPSize: 4096, DO: true}))
+	answers := []ResourceRecord{NewARecord("example.com", 300, []byte{192, 0, 2, 1})}
+
+	response, err := NewParser(NewBuilder().BuildResponse(query, answers, nil)).Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	if response.Header.ARCount != 1 {
+		t.Fatalf("ARCount = %d, want 1", response.Header.ARCount)
+	}
+	edns, err := response.EDNS()
+	if err != nil || edns == nil {
+		t.Fatalf("EDNS = %+v, %v; want an OPT record", edns, err)
+	}
+	if edns.UDPSize != DefaultEDNSUDPSize {
+		t.Errorf("advertised UDP size = %d, want %d", edns.UDPSize, DefaultEDNSUDPSize)
+	}
+	if !edns.DO {
+		t.Error("DO clear in response, want it echoed from the query")
+	}
+}
+
+func TestBuildResponseNoEDNS(t *testing.T) {
+	query := parseQueryWithAdditional(t)
+
+	response, err := NewParser(NewBuilder().BuildResponse(query, nil, nil)).Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if response.Header.ARCount != 0 {
+		t.Errorf("ARCount = %d, want 0 for a query without EDNS", response.Header.ARCount)
+	}
+}
+
+func TestBuildErrorResponseBadVersion(t *testing.T) {
+	query := parseQueryWithAdditional(t, NewOPTRecord(&EDNS{UDPSize: 1232, Version: 1}))
+
+	response, err := NewParser(NewBuilder().BuildErrorResponse(query, RcodeBadVersion)).Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	edns, err := response.EDNS()
+	if err != nil || edns == nil {
+		t.Fatalf("EDNS = %+v, %v; want an OPT record", edns, err)
+	}
+	rcode := uint16(edns.ExtendedRcode)<<4 | uint16(response.Rcode())
+	if rcode != uint16(RcodeBadVersion) {
+		t.Errorf("extended rcode = %d, want %d (BADVERS)", rcode, RcodeBadVersion)
+	}
+}
+
+// bigTXTAnswers returns n TXT records of about 230 bytes each, their owner
+// names compressed
+func bigTXTAnswers(n int) []ResourceRecord {
+	answers := make([]ResourceRecord, n)
+	for i := range answers {
+		answers[i] = ResourceRecord{Name: "example.com", Type: TypeTXT, Class: ClassIN, TTL: 300, Text: []string{strings.Repeat("x", 220)}}
+	}
+	return answers
+}
+
+func TestBuildResponseTruncated(t *testing.T) {
+	tests := []struct {
+		name      string
+		edns      *EDNS
+		answers   int
+		truncated bool
+		maxSize   int
+	}{
+		{"no EDNS fits", nil, 2, false, MinUDPSize},
+		{"no EDNS over 512", nil, 3, true, MinUDPSize},
+		{"EDNS 4096 capped at ours", &EDNS{UDPSize: 4096}, 6, true, DefaultEDNSUDPSize},
+		{"EDNS 4096 fits ours", &EDNS{UDPSize: 4096}, 5, false, DefaultEDNSUDPSize},
+		{"EDNS below 512", &EDNS{UDPSize: 100}, 2, false, MinUDPSize},
+	}
+
+	for _, tt := range tests {
+		var additional []ResourceRecord
+		if tt.edns != nil {
+			additional = append(additional, NewOPTRecord(tt.edns))
+		}
+		query := parseQueryWithAdditional(t, additional...)
+
+		b := NewBuilder()
+		b.UDP = true
+		data := b.BuildResponse(query, bigTXTAnswers(tt.answers), nil)
+		if got := b.MaxSize(query); got != tt.maxSize {
+			t.Errorf("%s: MaxSize = %d, want %d", tt.name, got, tt.maxSize)
+		}
+		if len(data) > tt.maxSize {
+			t.Errorf("%s: response is %d bytes, over %d", tt.name, len(data), tt.maxSize)
+		}
+
+		response, err := NewParser(data).Parse()
+		if err != nil {
+			t.Fatalf("%s: Parse error: %v", tt.name, err)
+		}
+		if b.Truncated != tt.truncated || (response.Header.Flags&FlagTC != 0) != tt.truncated {
+			t.Errorf("%s: Truncated = %v, TC = %v; want %v", tt.name, b.Truncated, response.Header.Flags&FlagTC != 0, tt.truncated)
+		}
+		if tt.truncated && (len(response.Answers) != 0 || len(response.Questions) != 1) {
+			t.Errorf("%s: truncated response has %d answers, %d questions; want 0, 1", tt.name, len(response.Answers), len(response.Questions))
+		}
+		if !tt.truncated && len(response.Answers) != tt.answers {
+			t.Errorf("%s: Answers = %d, want %d", tt.name, len(response.Answers), tt.answers)
+		}
+		if edns, _ := response.EDNS(); (edns != nil) != (tt.edns != nil) {
+			t.Errorf("%s: OPT record in response = %v, want %v", tt.name, edns != nil, tt.edns != nil)
+		}
+	}
+}
+
+func TestBuildResponseDropsAuthority(t *testing.T) {
+	query := parseQueryWithAdditional(t)
+	authority := []ResourceRecord{
+		{Name: "example.com", Type: TypeNS, Class: ClassIN, TTL: 300, Target: "ns1.example.com"},
+		{Name: "example.com", Type: TypeNS, Class: ClassIN, TTL: 300, Target: "ns2.example.com"},
+	}
+
+	// Two answers fit in 512 bytes, but not with the authority section
+	b := NewBuilder()
+	b.UDP = true
+	response, err := NewParser(b.BuildResponse(query, bigTXTAnswers(2), authority)).Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if b.Truncated || response.Header.Flags&FlagTC != 0 {
+		t.Error("response truncated, want the authority section left out instead")
+	}
+	if len(response.Answers) != 2 || len(response.Authority) != 0 {
+		t.Errorf("Answers = %d, Authority = %d; want 2, 0", len(response.Answers), len(response.Authority))
+	}
+
+	// Over TCP everything goes in
+	b.UDP = false
+	response, err = NewParser(b.BuildResponse(query, bigTXTAnswers(10), authority)).Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if b.Truncated || len(response.Answers) != 10 || len(response.Authority) != 2 {
+		t.Errorf("TCP: Truncated = %v, Answers = %d, Authority = %d; want false, 10, 2", b.Truncated, len(response.Answers), len(response.Authority))
+	}
+}
+
+func TestBuildNegativeResponse(t *testing.T) {
+	query := parseQueryWithAdditional(t, NewOPTRecord(&EDNS{UDPSize: 4096, DO: true}))
+	authority := []ResourceRecord{NewSOARecord("example.com", 3600, &SOA{
+		MName: "ns1.example.com", RName: "hostmaster.example.com", Serial: 1,
+	})}
+
+	b := NewBuilder()
+	b.UDP = true
+	response, err := NewParser(b.BuildNegativeResponse(query, RcodeNameError, authority)).Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if rcode := response.Rcode(); rcode != RcodeNameError {
+		t.Errorf("Rcode = %d, want NXDOMAIN", rcode)
+	}
+	if len(response.Answers) != 0 || len(response.Authority) != 1 {
+		t.Errorf("Answers = %d, Authority = %d; want 0, 1", len(response.Answers), len(response.Authority))
+	}
+
+	// The authority section is the proof, so it is never dropped: a
+	// response without room for it is truncated instead
+	response, err = NewParser(b.BuildNegativeResponse(query, RcodeNoError, bigTXTAnswers(10))).Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if !b.Truncated || response.Header.Flags&FlagTC == 0 || len(response.Authority) != 0 {
+		t.Errorf("Truncated = %v, TC = %v, Authority = %d; want true, true, 0",
+			b.Truncated, response.Header.Flags&FlagTC != 0, len(response.Authority))
+	}
+}
+
+func TestEDNSClientSubnet(t *testing.T) {
+	tests := []struct {
+		subnet ClientSubnet
+		data   []byte
+	}{
+		{ClientSubnet{Address: net.ParseIP("192.0.2.0").To4(), SourcePrefix: 24}, []byte{0, 1, 24, 0, 192, 0, 2}},
+		{ClientSubnet{Address: net.ParseIP("2001:db8:40::"), SourcePrefix: 42, ScopePrefix: 40}, []byte{0, 2, 42, 40, 0x20, 0x01, 0x0d, 0xb8, 0x00, 0x40}},
+		{ClientSubnet{Address: net.IPv4zero.To4(), SourcePrefix: 0}, []byte{0, 1, 0, 0}},
+	}
+	for _, tt := range tests {
+		opt := tt.subnet.Option()
+		if opt.Code != EDNSOptionClientSubnet || !bytes.Equal(opt.Data, tt.data) {
+			t.Errorf("Option(%+v) = %d %x, want 8 %x", tt.subnet, opt.Code, opt.Data, tt.data)
+		}
+
+		msg := parseQueryWithAdditional(t, NewOPTRecord(&EDNS{UDPSize: 1232, Options: []EDNSOption{opt}}))
+		edns, err := msg.EDNS()
+		if err != nil {
+			t.Fatalf("EDNS error: %v", err)
+		}
+		subnet, err := edns.ClientSubnet()
+		if err != nil {
+			t.Errorf("ClientSubnet(%x) error: %v", tt.data, err)
+			continue
+		}
+		if !subnet.Address.Equal(tt.subnet.Address) || subnet.SourcePrefix != tt.subnet.SourcePrefix || subnet.ScopePrefix != tt.subnet.ScopePrefix {
+			t.Errorf("ClientSubnet(%x) = %+v, want %+v", tt.data, subnet, tt.subnet)
+		}
+	}
+
+	if subnet, err := (&EDNS{}).ClientSubnet(); subnet != nil || err != nil {
+		t.Errorf("ClientSubnet without the option = %+v, %v; want nil, nil", subnet, err)
+	}
+
+	for _, data := range [][]byte{
+		{0, 1, 24},                   // Too short
+		{0, 3, 8, 0, 10},             // Unknown family
+		{0, 1, 33, 0, 1, 2, 3, 4, 5}, // Prefix too long
+		{0, 1, 24, 0, 192, 0},        // Address too short for the prefix
+		{0, 1, 24, 0, 192, 0, 2, 1},  // Address too long for the prefix
+		{0, 1, 23, 0, 192, 0, 3},     // Bits set past the prefix
+	} {
+		edns := &EDNS{Options: []EDNSOption{{Code: EDNSOptionClientSubnet, Data: data}}}
+		if _, err := edns.ClientSubnet(); err == nil {
+			t.Errorf("ClientSubnet(%x) succeeded, want an error", data)
+		}
+	}
+}
+
+func TestBuildResponseClientSubnet(t *testing.T) {
+	subnet := ClientSubnet{Address: net.ParseIP("198.51.100.0").To4(), SourcePrefix: 24}
+	query := parseQueryWithAdditional(t, NewOPTRecord(&EDNS{UDPSize: 4096, Options: []EDNSOption{subnet.Option()}}))
+
+	b := NewBuilder()
+	subnet.ScopePrefix = 16
+	b.ClientSubnet = &subnet
+	response, err := NewParser(b.BuildResponse(query, nil, nil)).Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	edns, err := response.EDNS()
+	if err != nil || edns == nil {
+		t.Fatalf("EDNS = %+v, %v; want an OPT record", edns, err)
+	}
+	echoed, err := edns.ClientSubnet()
+	if err != nil || echoed == nil {
+		t.Fatalf("ClientSubnet = %+v, %v; want the query's, echoed", echoed, err)
+	}
+	if !echoed.Address.Equal(subnet.Address) || echoed.SourcePrefix != 24 || echoed.ScopePrefix != 16 {
+		t.Errorf("echoed %+v, want 198.51.100.0/24 scope /16", echoed)
+	}
+}
@@ -0,0 +1,208 @@
+package dns
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// String formats the record as a zone file line, including its comment.
+func (rr ResourceRecord) String() string {
+	return rr.line(rr.RDataString())
+}
+
+// zoneFileString formats the record as String does, but with the data of
+// types zone files can't give in presentation format in the generic form of
+// RFC 3597, so that ParseRecord reads it back
+func (rr ResourceRecord) zoneFileString() string {
+	if presentationTypes[rr.Type] {
+		return rr.String()
+	}
+	data := NewBuilder().buildRData(&rr)
+	return rr.line(fmt.Sprintf("\\# %d %x", len(data), data))
+}
+
+func (rr ResourceRecord) line(rdata string) string {
+	line := fmt.Sprintf("%s.\t%d\tIN\t%s\t%s", rr.Name, rr.TTL, TypeToString(rr.Type), rdata)
+	if rr.Comment != "" {
+		line += " ; " + rr.Comment
+	}
+	return line
+}
+
+// RDataString formats the record data in zone file presentation format.
+func (rr ResourceRecord) RDataString() string {
+	switch rr.Type {
+	case TypeA, TypeAAAA:
+		return rr.Address.String()
+	case TypeCNAME, TypeNS, TypePTR:
+		return rr.Target + "."
+	case TypeMX:
+		return fmt.Sprintf("%d %s.", rr.Priority, rr.Target)
+	case TypeTXT:
+		quoted := make([]string, len(rr.Text))
+		for i, text := range rr.Text {
+			quoted[i] = quoteTXT(text)
+		}
+		return strings.Join(quoted, " ")
+	case TypeSOA:
+		if soa := rr.SOAData; soa != nil {
+			return fmt.Sprintf("%s. %s. %d %d %d %d %d",
+				soa.MName, soa.RName, soa.Serial, soa.Refresh, soa.Retry, soa.Expire, soa.Minimum)
+		}
+	case TypeTLSA:
+		if tlsa := rr.TLSA; tlsa != nil {
+			return fmt.Sprintf("%d %d %d %X", tlsa.Usage, tlsa.Selector, tlsa.MatchingType, tlsa.Certificate)
+		}
+	case TypeSVCB, TypeHTTPS:
+		if rr.SVCB != nil {
+			return svcbString(rr.SVCB)
+		}
+	case TypeCAA:
+		if caa := rr.CAA; caa != nil {
+			return fmt.Sprintf("%d %s %s", caa.Flags, caa.Tag, quoteTXT(caa.Value))
+		}
+	case TypeDS:
+		if len(rr.RData) > 4 {
+			return fmt.Sprintf("%d %d %d %X", binary.BigEndian.Uint16(rr.RData), rr.RData[2], rr.RData[3], rr.RData[4:])
+		}
+	case TypeDNSKEY:
+		if len(rr.RData) > 4 {
+			return fmt.Sprintf("%d %d %d %s", binary.BigEndian.Uint16(rr.RData), rr.RData[2], rr.RData[3],
+				base64.StdEncoding.EncodeToString(rr.RData[4:]))
+		}
+	case TypeRRSIG:
+		if text, ok := rrsigString(rr.RData); ok {
+			return text
+		}
+	case TypeNSEC:
+		if text, ok := nsecString(rr.RData); ok {
+			return text
+		}
+	case TypeNSEC3:
+		if text, ok := nsec3String(rr.RData); ok {
+			return text
+		}
+	case TypeNSEC3PARAM:
+		if text, ok := nsec3PARAMString(rr.RData); ok {
+			return text
+		}
+	}
+	return fmt.Sprintf("\\# %d %x", len(rr.RData), rr.RData)
+}
+
+// WriteTo writes the zone in BIND zone file format, one record per line
+// with its absolute name, TTL and class: $ORIGIN, the SOA record, the other
+// records sorted by name and type, then the variants of each region after
+// $REGION. Record comments are written back as trailing ";" comments, and
+// health checks and weights as $HEALTHCHECK and $WEIGHT directives before
+// the addresses they apply to. The file loads back into the same zone with
+// LoadZoneFile.
+func (z *Zone) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	fmt.Fprintf(bw, "$ORIGIN %s.\n", z.Name)
+
+	// A $HEALTHCHECK or $WEIGHT directive goes before each address whose
+	// check or weight differs from the one before it
+	var check string
+	var weight uint16
+	write := func(rr ResourceRecord) {
+		if rr.Type == TypeA || rr.Type == TypeAAAA {
+			next := ""
+			if rr.HealthCheck != nil {
+				next = rr.HealthCheck.String()
+			}
+			if next != check {
+				fmt.Fprintln(bw, strings.TrimSpace("$HEALTHCHECK "+next))
+				check = next
+			}
+			if rr.Weight != weight {
+				if rr.Weight == 0 {
+					fmt.Fprintln(bw, "$WEIGHT")
+				} else {
+					fmt.Fprintf(bw, "$WEIGHT %d\n", rr.Weight)
+				}
+				weight = rr.Weight
+			}
+		}
+		fmt.Fprintln(bw, rr.zoneFileString())
+	}
+
+	records := z.AllRecords()
+
+	// SOA goes first, as zone file readers expect
+	for _, rr := range records {
+		if rr.Type == TypeSOA {
+			write(rr)
+		}
+	}
+	for _, rr := range records {
+		if rr.Type != TypeSOA {
+			write(rr)
+		}
+	}
+
+	// Then each region's variants
+	regions := z.Regions()
+	for _, region := range regions {
+		fmt.Fprintf(bw, "$REGION %s\n", region)
+		for _, rr := range z.RegionalRecords(region) {
+			write(rr)
+		}
+	}
+	if len(regions) > 0 {
+		fmt.Fprintln(bw, "$REGION")
+	}
+	if check != "" {
+		fmt.Fprintln(bw, "$HEALTHCHECK")
+	}
+	if weight != 0 {
+		fmt.Fprintln(bw, "$WEIGHT")
+	}
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Export writes the zone as WriteTo does
+func (z *Zone) Export(w io.Writer) error {
+	_, err := z.WriteTo(w)
+	return err
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// quoteTXT quotes a TXT character-string, escaping quotes and backslashes.
+func quoteTXT(text string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c > 0x7e:
+			fmt.Fprintf(&b, "\\%03d", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,195 @@
+package dns
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordString(t *testing.T) {
+	tests := []struct {
+		rr   ResourceRecord
+		want string
+	}{
+		{NewARecord("www.example.com", 300, net.ParseIP("192.0.2.1")), "www.example.com.\t300\tIN\tA\t192.0.2.1"},
+		{NewMXRecord("example.com", 3600, 10, "mail.example.com"), "example.com.\t3600\tIN\tMX\t10 mail.example.com."},
+		{NewTXTRecord("example.com", 60, `say "hi"`), "example.com.\t60\tIN\tTXT\t\"say \\\"hi\\\"\""},
+		{NewCAARecord("example.com", 3600, 0, "issue", "letsencrypt.org"), "example.com.\t3600\tIN\tCAA\t0 issue \"letsencrypt.org\""},
+		{NewTLSARecord("_443._tcp.example.com", 3600, 3, 1, 0, []byte{0x30, 0x82}), "_443._tcp.example.com.\t3600\tIN\tTLSA\t3 1 0 3082"},
+		{NewHTTPSRecord("example.com", 3600, 0, "cdn.example.net"), "example.com.\t3600\tIN\tHTTPS\t0 cdn.example.net."},
+	}
+
+	for _, tt := range tests {
+		if got := tt.rr.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestZoneExportComments(t *testing.T) {
+	zone := NewZone("example.com")
+
+	rr := NewARecord("www.example.com", 3600, net.ParseIP("192.0.2.1"))
+	rr.Comment = "owned by team-x"
+	zone.AddRecord(rr)
+	zone.AddRecord(NewSOARecord("example.com", 3600, &SOA{
+		MName: "ns1.example.com", RName: "hostmaster.example.com", Serial: 1,
+	}))
+
+	var b strings.Builder
+	if err := zone.Export(&b); err != nil {
+		t.Fatalf("Export error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Export wrote %d lines, want 3:\n%s", len(lines), b.String())
+	}
+	if lines[0] != "$ORIGIN example.com." {
+		t.Errorf("line 1 = %q, want $ORIGIN", lines[0])
+	}
+	if !strings.Contains(lines[1], "\tSOA\t") {
+		t.Errorf("line 2 = %q, want SOA first", lines[1])
+	}
+	if !strings.HasSuffix(lines[2], "192.0.2.1 ; owned by team-x") {
+		t.Errorf("line 3 = %q, want trailing comment", lines[2])
+	}
+}
+
+func TestZoneWriteToRoundTrip(t *testing.T) {
+	zone := NewZone("example.com")
+	zone.AddRecord(NewSOARecord("example.com", 3600, &SOA{
+		MName: "ns1.example.com", RName: "hostmaster.example.com", Serial: 2024010101,
+		Refresh: 7200, Retry: 900, Expire: 1209600, Minimum: 300,
+	}))
+	alpn, _ := ParseSvcParam("alpn=h2,h3")
+	unknown := ResourceRecord{Name: "srv.example.com", Type: 33, Class: ClassIN, TTL: 60,
+		RData: []byte{0, 10, 0, 5, 0x01, 0xbb, 3, 'w', 'w', 'w', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0}}
+	dnskey := ResourceRecord{Name: "example.com", Type: TypeDNSKEY, Class: ClassIN, TTL: 3600,
+		RData: []byte{1, 1, 3, 13, 0xde, 0xad, 0xbe, 0xef}}
+	records := []ResourceRecord{
+		NewNSRecord("example.com", 3600, "ns1.example.com"),
+		NewARecord("www.example.com", 300, net.ParseIP("192.0.2.1")),
+		NewAAAARecord("www.example.com", 300, net.ParseIP("2001:db8::1")),
+		NewCNAMERecord("ftp.example.com", 300, "www.example.com"),
+		NewMXRecord("example.com", 3600, 10, "mail.example.com"),
+		NewTXTRecord("example.com", 60, `say "hi"\now`, "two  spaces; and a semicolon", "caf\xc3\xa9"),
+		NewCAARecord("example.com", 3600, 0, "issue", "ca.example; account=1"),
+		NewTLSARecord("_443._tcp.example.com", 3600, 3, 1, 1, bytes.Repeat([]byte{0xab}, 32)),
+		NewHTTPSRecord("example.com", 300, 1, "", alpn),
+		NewPTRRecord("1.2.0.192.in-addr.example.com", 300, "www.example.com"),
+		unknown,
+		dnskey,
+	}
+	records[1].Comment = "web; front end"
+	for _, rr := range records {
+		zone.AddRecord(rr)
+	}
+	zone.AddRegionalRecord("eu", NewARecord("www.example.com", 300, net.ParseIP("198.51.100.1")))
+
+	var b bytes.Buffer
+	n, err := zone.WriteTo(&b)
+	if err != nil {
+		t.Fatalf("WriteTo error: %v", err)
+	}
+	if n != int64(b.Len()) {
+		t.Errorf("WriteTo returned %d, wrote %d bytes", n, b.Len())
+	}
+	// Types without a presentation format here, known or not, are written
+	// in the generic form
+	for _, want := range []string{"\tTYPE33\t\\# 23 000a000501bb", "\tDNSKEY\t\\# 8 0101030ddeadbeef"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("zone file lacks %q:\n%s", want, b.String())
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "example.com.zone")
+	if err := os.WriteFile(path, b.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	again, err := LoadZoneFile(path)
+	if err != nil {
+		t.Fatalf("LoadZoneFile error: %v\n%s", err, b.String())
+	}
+
+	var got bytes.Buffer
+	if _, err := again.WriteTo(&got); err != nil {
+		t.Fatalf("WriteTo error: %v", err)
+	}
+	if got.String() != b.String() {
+		t.Errorf("zone file changed when loaded again:\n%s\nwant:\n%s", got.String(), b.String())
+	}
+	txt := again.Lookup("example.com", TypeTXT)
+	if len(txt) != 1 || fmt.Sprintf("%q", txt[0].Text) != fmt.Sprintf("%q", records[5].Text) {
+		t.Errorf("TXT loaded again = %v, want %q", txt, records[5].Text)
+	}
+	if rr := again.Lookup("srv.example.com", 33); len(rr) != 1 || !bytes.Equal(rr[0].RData, unknown.RData) {
+		t.Errorf("TYPE33 loaded again = %v", rr)
+	}
+}
+
+func TestParseRecordGeneric(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    string
+		wantErr bool
+	}{
+		{`www IN A \# 4 C0000201`, "192.0.2.1", false},
+		{`www IN TYPE1 \# 4 C000 0201`, "192.0.2.1", false},
+		{`srv IN TYPE33 \# 3 abcdef`, `\# 3 abcdef`, false},
+		{`www IN TXT \# 3 026869`, `"hi"`, false},
+		{`www IN A \# 3 C00002`, "", true},
+		{`www IN A \# 4 C00002`, "", true},
+		{`www IN TYPE33 0 0 443 www`, "", true},
+		{`www IN DNSKEY 257 3 13 AAAA`, "", true},
+	}
+	for _, tt := range tests {
+		rr, err := ParseRecord(tt.line, "example.com.", 3600)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseRecord(%q) = %v, want an error", tt.line, rr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseRecord(%q) error: %v", tt.line, err)
+			continue
+		}
+		if got := rr.RDataString(); got != tt.want {
+			t.Errorf("ParseRecord(%q) data = %s, want %s", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseCharacterStrings(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    []string
+		wantErr bool
+	}{
+		{`"v=spf1 -all"`, []string{"v=spf1 -all"}, false},
+		{`"a" "b c"`, []string{"a", "b c"}, false},
+		{`unquoted words`, []string{"unquoted", "words"}, false},
+		{`"say \"hi\" \\ \059 \233"`, []string{"say \"hi\" \\ ; \xe9"}, false},
+		{`""`, []string{""}, false},
+		{`"open`, nil, true},
+		{`"\256"`, nil, true},
+		{``, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseCharacterStrings(tt.s)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseCharacterStrings(%s) = %q, want an error", tt.s, got)
+			}
+			continue
+		}
+		if err != nil || fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.want) {
+			t.Errorf("parseCharacterStrings(%s) = %q, %v, want %q", tt.s, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package dns
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Health check protocols
+const (
+	HealthCheckTCP  = "tcp"  // The port accepts a connection
+	HealthCheckHTTP = "http" // A GET of the path answers with a 2xx or 3xx status
+)
+
+// HealthCheck is how the server checks that the address of an A or AAAA
+// record is up, so it can leave the address out of answers while it isn't.
+// Zone files give it with a $HEALTHCHECK directive, in the form
+// ParseHealthCheck reads and String writes.
+type HealthCheck struct {
+	Protocol string // HealthCheckTCP or HealthCheckHTTP
+	Port     uint16
+	Path     string // HTTP only; "/" if not given
+}
+
+// ParseHealthCheck parses a health check such as "tcp 443" or
+// "http 80 /healthz"
+func ParseHealthCheck(s string) (*HealthCheck, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("health check %q: want a protocol and a port", s)
+	}
+	hc := &HealthCheck{Protocol: strings.ToLower(fields[0])}
+	port, err := strconv.ParseUint(fields[1], 10, 16)
+	if err != nil || port == 0 {
+		return nil, fmt.Errorf("health check %q: invalid port %q", s, fields[1])
+	}
+	hc.Port = uint16(port)
+
+	switch hc.Protocol {
+	case HealthCheckTCP:
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("health check %q: tcp takes only a port", s)
+		}
+	case HealthCheckHTTP:
+		hc.Path = "/"
+		if len(fields) > 3 {
+			return nil, fmt.Errorf("health check %q: http takes a port and a path", s)
+		}
+		if len(fields) == 3 {
+			if !strings.HasPrefix(fields[2], "/") {
+				return nil, fmt.Errorf("health check %q: path %q doesn't start with /", s, fields[2])
+			}
+			hc.Path = fields[2]
+		}
+	default:
+		return nil, fmt.Errorf("health check %q: protocol is not %s or %s", s, HealthCheckTCP, HealthCheckHTTP)
+	}
+	return hc, nil
+}
+
+// String returns the health check as ParseHealthCheck reads it
+func (hc *HealthCheck) String() string {
+	if hc.Protocol == HealthCheckHTTP {
+		return fmt.Sprintf("%s %d %s", hc.Protocol, hc.Port, hc.Path)
+	}
+	return fmt.Sprintf("%s %d", hc.Protocol, hc.Port)
+}
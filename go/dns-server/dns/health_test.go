@@ -0,0 +1,39 @@
+package dns
+
+import "testing"
+
+func TestParseHealthCheck(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string // String() of the check, "" for an error
+	}{
+		{"tcp 443", "tcp 443"},
+		{"TCP  25", "tcp 25"},
+		{"http 80", "http 80 /"},
+		{"http 8080 /healthz", "http 8080 /healthz"},
+		{"tcp", ""},
+		{"tcp 0", ""},
+		{"tcp 65536", ""},
+		{"tcp 443 /x", ""},
+		{"http 80 healthz", ""},
+		{"http 80 /a /b", ""},
+		{"icmp 1", ""},
+	}
+
+	for _, tt := range tests {
+		hc, err := ParseHealthCheck(tt.in)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("ParseHealthCheck(%q) = %v, want an error", tt.in, hc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseHealthCheck(%q) error: %v", tt.in, err)
+			continue
+		}
+		if got := hc.String(); got != tt.want {
+			t.Errorf("ParseHealthCheck(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
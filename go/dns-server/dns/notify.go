@@ -0,0 +1,78 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Opcode returns the opcode of a message
+func (m *Message) Opcode() uint8 {
+	return uint8((m.Header.Flags & OpcodeMask) >> 11)
+}
+
+// BuildNotify builds a NOTIFY message (RFC 1996) telling a secondary that
+// zone has changed. With soa set, the zone's new SOA record goes in the
+// answer section as a hint; secondaries still check the serial themselves.
+func (b *Builder) BuildNotify(id uint16, zone string, soa *SOA) []byte {
+	b.reset()
+
+	header := Header{
+		ID:      id,
+		Flags:   uint16(OpcodeNotify)<<11 | FlagAA,
+		QDCount: 1,
+	}
+	if soa != nil {
+		header.ANCount = 1
+	}
+
+	b.writeHeader(&header)
+	b.writeQuestion(&Question{Name: zone, Type: TypeSOA, Class: ClassIN})
+	if soa != nil {
+		rr := NewSOARecord(zone, 0, soa)
+		b.writeResourceRecord(&rr)
+	}
+
+	return b.data
+}
+
+// Notify sends a NOTIFY for zone to server over UDP and waits for it to be
+// acknowledged. The port defaults to 53 if server has none. NOTIFY is not
+// retransmitted here; callers retry as RFC 1996 section 3.6 describes.
+func Notify(ctx context.Context, server, zone string, soa *SOA) error {
+	conn, err := Dial(ctx, "udp", server)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Notify(ctx, zone, soa)
+}
+
+// Notify sends a NOTIFY for zone and waits for it to be acknowledged
+func (c *Conn) Notify(ctx context.Context, zone string, soa *SOA) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id, err := randomID()
+	if err != nil {
+		return err
+	}
+	zone = strings.TrimSuffix(zone, ".")
+	query := c.builder.BuildNotify(id, zone, soa)
+
+	var msg *Message
+	err = c.withDeadline(ctx, func() error {
+		msg, err = c.exchange(query, id, zone, TypeSOA)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if msg.Opcode() != OpcodeNotify {
+		return fmt.Errorf("NOTIFY answered with opcode %d", msg.Opcode())
+	}
+	if rcode := msg.Rcode(); rcode != RcodeNoError {
+		return fmt.Errorf("NOTIFY failed with rcode %d", rcode)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestBuildNotify(t *testing.T) {
+	soa := &SOA{MName: "ns1.example.com", RName: "admin.example.com", Serial: 2024112002, Refresh: 3600, Retry: 600, Expire: 86400, Minimum: 300}
+	data := NewBuilder().BuildNotify(0x1234, "example.com", soa)
+
+	msg, err := NewParser(data).Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if msg.Opcode() != OpcodeNotify {
+		t.Errorf("Opcode = %d, want %d (NOTIFY)", msg.Opcode(), OpcodeNotify)
+	}
+	if msg.Header.Flags&FlagQR != 0 || msg.Header.Flags&FlagAA == 0 {
+		t.Errorf("Flags = %x, want AA without QR", msg.Header.Flags)
+	}
+	if len(msg.Questions) != 1 || msg.Questions[0].Name != "example.com" || msg.Questions[0].Type != TypeSOA {
+		t.Errorf("Questions = %+v, want example.com SOA", msg.Questions)
+	}
+	if len(msg.Answers) != 1 || msg.Answers[0].SOAData == nil || msg.Answers[0].SOAData.Serial != 2024112002 {
+		t.Errorf("Answers = %+v, want the SOA record with serial 2024112002", msg.Answers)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	tests := []struct {
+		name    string
+		rcode   uint8
+		wantErr bool
+	}{
+		{"acknowledged", RcodeNoError, false},
+		{"refused", RcodeRefused, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer server.Close()
+
+			serveUDP(t, server, func(query *Message) [][]byte {
+				if query.Opcode() != OpcodeNotify {
+					t.Errorf("Opcode = %d, want NOTIFY", query.Opcode())
+				}
+				// The response echoes the opcode
+				return [][]byte{NewBuilder().BuildErrorResponse(query, tt.rcode)}
+			})
+
+			err = Notify(context.Background(), server.LocalAddr().String(), "example.com", nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Notify error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
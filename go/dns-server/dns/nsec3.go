@@ -0,0 +1,243 @@
+package dns
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// NSEC3 constants (RFC 5155)
+const (
+	// NSEC3HashSHA1 is the only NSEC3 hash algorithm defined
+	NSEC3HashSHA1 uint8 = 1
+
+	// MaxNSEC3Iterations bounds the extra hash iterations: each one costs
+	// every validator work on every negative answer, and validators treat
+	// zones using many more as insecure (RFC 9276 section 3.2)
+	MaxNSEC3Iterations = 100
+)
+
+// base32Hex encodes NSEC3 hashes into owner name labels. Its alphabet keeps
+// the hashes' order, so the labels sort the way the hashes do.
+var base32Hex = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// NSEC3Hash returns the NSEC3 hash of name: SHA-1 over the canonical name
+// and salt, then over the previous hash and salt for each extra iteration
+// (RFC 5155 section 5)
+func NSEC3Hash(name string, iterations uint16, salt []byte) []byte {
+	h := sha1.New()
+	h.Write(canonicalName(name))
+	h.Write(salt)
+	digest := h.Sum(nil)
+	for i := 0; i < int(iterations); i++ {
+		h.Reset()
+		h.Write(digest)
+		h.Write(salt)
+		digest = h.Sum(digest[:0])
+	}
+	return digest
+}
+
+// NSEC3Owner returns the owner name of the NSEC3 record for a hash in zone
+func NSEC3Owner(hash []byte, zone string) string {
+	return strings.ToLower(base32Hex.EncodeToString(hash)) + "." + zone
+}
+
+// nsec3Params returns the RDATA NSEC3 and NSEC3PARAM records start with:
+// hash algorithm, flags (no opt-out), iterations and salt
+func nsec3Params(iterations uint16, salt []byte) []byte {
+	data := []byte{NSEC3HashSHA1, 0}
+	data = binary.BigEndian.AppendUint16(data, iterations)
+	data = append(data, byte(len(salt)))
+	return append(data, salt...)
+}
+
+// NewNSEC3Record creates an NSEC3 record saying the name hashing to the
+// owner has records of types, and that no name hashes to anything between
+// the owner and next
+func NewNSEC3Record(owner string, ttl uint32, iterations uint16, salt, next []byte, types []uint16) ResourceRecord {
+	rdata := nsec3Params(iterations, salt)
+	rdata = append(rdata, byte(len(next)))
+	rdata = append(rdata, next...)
+	rdata = append(rdata, typeBitmap(types)...)
+	return ResourceRecord{
+		Name:     owner,
+		Type:     TypeNSEC3,
+		Class:    ClassIN,
+		TTL:      ttl,
+		RDLength: uint16(len(rdata)),
+		RData:    rdata,
+	}
+}
+
+// NewNSEC3PARAMRecord creates the NSEC3PARAM record at a zone's apex, which
+// tells secondaries and tools how its NSEC3 hashes are made
+func NewNSEC3PARAMRecord(zone string, ttl uint32, iterations uint16, salt []byte) ResourceRecord {
+	rdata := nsec3Params(iterations, salt)
+	return ResourceRecord{
+		Name:     zone,
+		Type:     TypeNSEC3PARAM,
+		Class:    ClassIN,
+		TTL:      ttl,
+		RDLength: uint16(len(rdata)),
+		RData:    rdata,
+	}
+}
+
+// nsec3Chain is a zone's NSEC3 records: the hash of every name in the zone,
+// empty non-terminals included, in order
+type nsec3Chain struct {
+	iterations uint16
+	salt       []byte
+	hashes     [][]byte
+	types      map[string][]uint16 // string(hash) -> types for its record
+}
+
+// UseNSEC3 switches the Signer to NSEC3 denial of existence, hashing names
+// with the given extra iterations and salt. The zone then has an
+// NSEC3PARAM record instead of NSEC records.
+func (s *Signer) UseNSEC3(iterations uint16, salt []byte) error {
+	if iterations > MaxNSEC3Iterations {
+		return fmt.Errorf("%d NSEC3 iterations is more than %d", iterations, MaxNSEC3Iterations)
+	}
+	if len(salt) > 255 {
+		return fmt.Errorf("NSEC3 salt is longer than 255 bytes")
+	}
+
+	chain := &nsec3Chain{
+		iterations: iterations,
+		salt:       salt,
+		types:      make(map[string][]uint16),
+	}
+	add := func(name string, types []uint16) error {
+		hash := NSEC3Hash(name, iterations, salt)
+		if _, ok := chain.types[string(hash)]; ok {
+			return fmt.Errorf("NSEC3 hash collision at %s; change the salt", name)
+		}
+		chain.hashes = append(chain.hashes, hash)
+		chain.types[string(hash)] = types
+		return nil
+	}
+
+	for _, name := range s.names {
+		// An RRSIG for each RRset, and no NSEC records in an NSEC3 zone;
+		// at a delegation without DS records, nothing is signed
+		types := s.types[name]
+		if !s.cuts[name] || slices.Contains(types, TypeDS) {
+			types = append([]uint16{TypeRRSIG}, types...)
+		}
+		if name == s.zone.Name {
+			types = append(types, TypeNSEC3PARAM)
+		}
+		if err := add(name, types); err != nil {
+			return err
+		}
+	}
+	for name := range s.empty {
+		if err := add(name, nil); err != nil {
+			return err
+		}
+	}
+	sort.Slice(chain.hashes, func(i, j int) bool {
+		return bytes.Compare(chain.hashes[i], chain.hashes[j]) < 0
+	})
+
+	s.nsec3 = chain
+	return nil
+}
+
+// param returns the zone's NSEC3PARAM record. Its TTL is 0, as it is only
+// read when the zone is loaded or transferred, never cached by resolvers.
+func (c *nsec3Chain) param(zone string) ResourceRecord {
+	return NewNSEC3PARAMRecord(zone, 0, c.iterations, c.salt)
+}
+
+// record returns the i-th NSEC3 record of the chain
+func (c *nsec3Chain) record(i int, zone string, ttl uint32) ResourceRecord {
+	hash := c.hashes[i]
+	next := c.hashes[(i+1)%len(c.hashes)]
+	return NewNSEC3Record(NSEC3Owner(hash, zone), ttl, c.iterations, c.salt, next, c.types[string(hash)])
+}
+
+// find returns the index of the NSEC3 record matching name's hash, or
+// covering it if no name in the zone hashes to it, and whether it matched
+func (c *nsec3Chain) find(name string) (int, bool) {
+	hash := NSEC3Hash(name, c.iterations, c.salt)
+	i := sort.Search(len(c.hashes), func(i int) bool {
+		return bytes.Compare(c.hashes[i], hash) > 0
+	})
+	// A hash before the first is covered by the last record, which wraps
+	i = (i - 1 + len(c.hashes)) % len(c.hashes)
+	return i, bytes.Equal(c.hashes[i], hash)
+}
+
+// nsec3Denial returns the NSEC3 records proving a negative answer (RFC 5155
+// section 7.2): for NODATA the record matching name; for NXDOMAIN the
+// closest encloser proof, a record matching the closest encloser and one
+// covering the next closer name, plus one covering the wildcard at the
+// closest encloser
+func (s *Signer) nsec3Denial(name string, nxdomain bool) []ResourceRecord {
+	c := s.nsec3
+	var indexes []int
+	if !nxdomain {
+		i, _ := c.find(name)
+		indexes = append(indexes, i)
+	} else {
+		encloser, nextCloser := s.closestEncloser(name)
+		for _, n := range []string{encloser, nextCloser, "*." + encloser} {
+			i, _ := c.find(n)
+			indexes = append(indexes, i)
+		}
+	}
+
+	var records []ResourceRecord
+	seen := make(map[int]bool)
+	for _, i := range indexes {
+		if !seen[i] {
+			seen[i] = true
+			records = append(records, c.record(i, s.zone.Name, s.nsecTTL))
+		}
+	}
+	return records
+}
+
+// nsec3String formats NSEC3 RDATA in presentation format
+func nsec3String(rdata []byte) (string, bool) {
+	if len(rdata) < 5 || len(rdata) < 6+int(rdata[4]) {
+		return "", false
+	}
+	salt := rdata[5 : 5+int(rdata[4])]
+	rest := rdata[5+len(salt):]
+	if len(rest) < 1+int(rest[0]) {
+		return "", false
+	}
+	next := rest[1 : 1+int(rest[0])]
+	types, ok := bitmapString(rest[1+len(next):])
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%d %d %d %s %s%s", rdata[0], rdata[1], binary.BigEndian.Uint16(rdata[2:4]),
+		saltString(salt), strings.ToLower(base32Hex.EncodeToString(next)), types), true
+}
+
+// nsec3PARAMString formats NSEC3PARAM RDATA in presentation format
+func nsec3PARAMString(rdata []byte) (string, bool) {
+	if len(rdata) < 5 || len(rdata) != 5+int(rdata[4]) {
+		return "", false
+	}
+	return fmt.Sprintf("%d %d %d %s", rdata[0], rdata[1], binary.BigEndian.Uint16(rdata[2:4]), saltString(rdata[5:])), true
+}
+
+// saltString writes a salt in hex, or "-" for none
+func saltString(salt []byte) string {
+	if len(salt) == 0 {
+		return "-"
+	}
+	return strings.ToUpper(hex.EncodeToString(salt))
+}
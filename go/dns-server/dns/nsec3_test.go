@@ -0,0 +1,168 @@
+package dns
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestNSEC3Hash(t *testing.T) {
+	// The example zone of RFC 5155 appendix A
+	salt := []byte{0xaa, 0xbb, 0xcc, 0xdd}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"example", "0p9mhaveqvm6t7vbl5lop2u3t2rp3tom"},
+		{"a.example", "35mthgpgcu1qg68fab165klnsnk3dpvl"},
+		{"ai.example", "gjeqe526plbf1g8mklp59enfd789njgi"},
+		{"ns1.example", "2t7b4g4vsa5smi47k61mv5bv1a22bojr"},
+		{"ns2.example", "q04jkcevqvmu85r014c7dkba38o0ji5r"},
+		{"w.example", "k8udemvp1j2f7eg6jebps17vp3n8i58h"},
+		{"*.w.example", "r53bq7cc2uvmubfu5ocmm6pers9tk9en"},
+		{"x.w.example", "b4um86eghhds6nea196smvmlo4ors995"},
+		{"y.w.example", "ji6neoaepv8b5o6k4ev33abha8ht9fgc"},
+		{"x.y.w.example", "2vptu5timamqttgl4luu9kg21e0aor3s"},
+		{"xx.example", "t644ebqk9bibcna874givr6joj62mlhv"},
+		{"XX.Example.", "t644ebqk9bibcna874givr6joj62mlhv"},
+	}
+
+	for _, tt := range tests {
+		got := NSEC3Owner(NSEC3Hash(tt.name, 12, salt), "example")
+		if want := tt.want + ".example"; got != want {
+			t.Errorf("NSEC3 owner of %s = %s, want %s", tt.name, got, want)
+		}
+	}
+}
+
+func TestNSEC3Records(t *testing.T) {
+	salt := []byte{0xaa, 0xbb, 0xcc, 0xdd}
+	next := NSEC3Hash("a.example", 12, salt)
+
+	rr := NewNSEC3Record("0p9mhaveqvm6t7vbl5lop2u3t2rp3tom.example", 3600, 12, salt, next,
+		[]uint16{TypeNS, TypeSOA, TypeMX, TypeRRSIG, TypeDNSKEY, TypeNSEC3PARAM})
+	want := "1 0 12 AABBCCDD 35mthgpgcu1qg68fab165klnsnk3dpvl NS SOA MX RRSIG DNSKEY NSEC3PARAM"
+	if got := rr.RDataString(); got != want {
+		t.Errorf("NSEC3 = %q, want %q", got, want)
+	}
+
+	param := NewNSEC3PARAMRecord("example", 0, 12, salt)
+	if got := param.RDataString(); got != "1 0 12 AABBCCDD" {
+		t.Errorf("NSEC3PARAM = %q, want %q", got, "1 0 12 AABBCCDD")
+	}
+	param = NewNSEC3PARAMRecord("example", 0, 0, nil)
+	if got := param.RDataString(); got != "1 0 0 -" {
+		t.Errorf("NSEC3PARAM without salt = %q, want %q", got, "1 0 0 -")
+	}
+}
+
+// nsec3Test describes how an NSEC3 record relates to a name
+func nsec3Test(t *testing.T, rr ResourceRecord, name string) (matches, covers bool) {
+	t.Helper()
+	rdata := rr.RData
+	iterations := uint16(rdata[2])<<8 | uint16(rdata[3])
+	salt := rdata[5 : 5+int(rdata[4])]
+	rest := rdata[5+len(salt):]
+	next := rest[1 : 1+int(rest[0])]
+
+	owner, err := base32Hex.DecodeString(strings.ToUpper(strings.SplitN(rr.Name, ".", 2)[0]))
+	if err != nil {
+		t.Fatalf("NSEC3 owner %s: %v", rr.Name, err)
+	}
+	hash := NSEC3Hash(name, iterations, salt)
+	if bytes.Equal(owner, hash) {
+		return true, false
+	}
+	if bytes.Compare(owner, next) < 0 {
+		return false, bytes.Compare(owner, hash) < 0 && bytes.Compare(hash, next) < 0
+	}
+	// The last record wraps round to the first
+	return false, bytes.Compare(owner, hash) < 0 || bytes.Compare(hash, next) < 0
+}
+
+func TestSignerNSEC3Denial(t *testing.T) {
+	base, zone := newTestSigner(t)
+	// b.example.com becomes an empty non-terminal
+	zone.AddRecord(NewARecord("a.b.example.com", 300, net.ParseIP("192.0.2.3")))
+	signer, err := NewSigner(zone, base.ksk, base.zsk)
+	if err != nil {
+		t.Fatalf("NewSigner error: %v", err)
+	}
+	if err := signer.UseNSEC3(5, []byte{0xab, 0xcd}); err != nil {
+		t.Fatalf("UseNSEC3 error: %v", err)
+	}
+
+	// Every name, the empty non-terminal included, has a record
+	if n := len(signer.nsec3.hashes); n != 5 {
+		t.Errorf("NSEC3 chain has %d records, want 5", n)
+	}
+	if !signer.Exists("B.example.com") || signer.Exists("c.example.com") {
+		t.Error("Exists is wrong for the empty non-terminal or a missing name")
+	}
+
+	// NODATA: the record matching the name
+	denial := signer.Denial("www.example.com", false)
+	if len(denial) != 1 {
+		t.Fatalf("NODATA denial has %d records, want 1", len(denial))
+	}
+	if matches, _ := nsec3Test(t, denial[0], "www.example.com"); !matches {
+		t.Errorf("NODATA denial %s doesn't match www.example.com", denial[0])
+	}
+	if !strings.HasSuffix(denial[0].RDataString(), " A RRSIG") {
+		t.Errorf("NSEC3 of www.example.com = %s, want types A RRSIG", denial[0].RDataString())
+	}
+	denial = signer.Denial("b.example.com", false)
+	if matches, _ := nsec3Test(t, denial[0], "b.example.com"); !matches {
+		t.Errorf("NODATA denial %s doesn't match the empty non-terminal", denial[0])
+	}
+
+	// NXDOMAIN: the closest encloser proof and the wildcard
+	tests := []struct {
+		name, encloser, nextCloser string
+	}{
+		{"nope.example.com", "example.com", "nope.example.com"},
+		{"x.y.www.example.com", "www.example.com", "y.www.example.com"},
+		{"x.b.example.com", "b.example.com", "x.b.example.com"},
+	}
+	for _, tt := range tests {
+		denial := signer.Denial(tt.name, true)
+		var encloser, nextCloser, wildcard bool
+		for _, rr := range denial {
+			if rr.Type != TypeNSEC3 {
+				t.Errorf("%s: denial has a %s record", tt.name, TypeToString(rr.Type))
+			}
+			if m, _ := nsec3Test(t, rr, tt.encloser); m {
+				encloser = true
+			}
+			if _, c := nsec3Test(t, rr, tt.nextCloser); c {
+				nextCloser = true
+			}
+			if _, c := nsec3Test(t, rr, "*."+tt.encloser); c {
+				wildcard = true
+			}
+		}
+		if !encloser || !nextCloser || !wildcard {
+			t.Errorf("%s: denial proves encloser %v, next closer %v, wildcard %v; want all",
+				tt.name, encloser, nextCloser, wildcard)
+		}
+	}
+
+	// NSEC3PARAM instead of NSEC records
+	if got := signer.Lookup("example.com", TypeNSEC3PARAM); len(got) != 1 || got[0].RDataString() != "1 0 5 ABCD" {
+		t.Errorf("Lookup NSEC3PARAM = %v, want 1 0 5 ABCD", got)
+	}
+	if got := signer.Lookup("www.example.com", TypeNSEC); got != nil {
+		t.Errorf("Lookup NSEC = %v, want none with NSEC3", got)
+	}
+}
+
+func TestSignerUseNSEC3Errors(t *testing.T) {
+	signer, _ := newTestSigner(t)
+	if err := signer.UseNSEC3(MaxNSEC3Iterations+1, nil); err == nil {
+		t.Error("UseNSEC3 accepted too many iterations")
+	}
+	if err := signer.UseNSEC3(0, make([]byte, 256)); err == nil {
+		t.Error("UseNSEC3 accepted a 256-byte salt")
+	}
+}
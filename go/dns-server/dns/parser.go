@@ -62,6 +62,16 @@ func (p *Parser) Parse() (*Message, error) {
 	return msg, nil
 }
 
+// ParseHeader parses the header of a message alone, which is enough to
+// answer one that can't be parsed whole
+func ParseHeader(data []byte) (*Header, error) {
+	var h Header
+	if err := NewParser(data).parseHeader(&h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
 func (p *Parser) parseHeader(h *Header) error {
 	if len(p.data) < 12 {
 		return fmt.Errorf("header too short")
@@ -129,7 +139,7 @@ func (p *Parser) parseResourceRecord(rr *ResourceRecord) error {
 		if rr.RDLength == 16 {
 			rr.Address = net.IP(rr.RData)
 		}
-	case TypeCNAME, TypeNS:
+	case TypeCNAME, TypeNS, TypePTR:
 		savedPos := p.pos
 		rr.Target, _ = p.parseName()
 		p.pos = savedPos
@@ -143,6 +153,18 @@ func (p *Parser) parseResourceRecord(rr *ResourceRecord) error {
 		}
 	case TypeTXT:
 		rr.Text = p.parseTXT(rr.RData)
+	case TypeSOA:
+		savedPos := p.pos
+		rr.SOAData = p.parseSOA(savedPos + int(rr.RDLength))
+		p.pos = savedPos
+	case TypeSVCB, TypeHTTPS:
+		savedPos := p.pos
+		rr.SVCB = p.parseSVCB(savedPos + int(rr.RDLength))
+		p.pos = savedPos
+	case TypeCAA:
+		rr.CAA = parseCAA(rr.RData)
+	case TypeTLSA:
+		rr.TLSA = parseTLSA(rr.RData)
 	}
 
 	p.pos += int(rr.RDLength)
@@ -211,6 +233,29 @@ func (p *Parser) parseName() (string, error) {
 	return strings.Join(labels, "."), nil
 }
 
+// parseSOA parses SOA RDATA ending at end, or returns nil if it is malformed
+func (p *Parser) parseSOA(end int) *SOA {
+	mname, err := p.parseName()
+	if err != nil {
+		return nil
+	}
+	rname, err := p.parseName()
+	if err != nil || p.pos+20 != end {
+		return nil
+	}
+
+	nums := p.data[p.pos:end]
+	return &SOA{
+		MName:   mname,
+		RName:   rname,
+		Serial:  binary.BigEndian.Uint32(nums[0:4]),
+		Refresh: binary.BigEndian.Uint32(nums[4:8]),
+		Retry:   binary.BigEndian.Uint32(nums[8:12]),
+		Expire:  binary.BigEndian.Uint32(nums[12:16]),
+		Minimum: binary.BigEndian.Uint32(nums[16:20]),
+	}
+}
+
 func (p *Parser) parseTXT(data []byte) []string {
 	var texts []string
 	pos := 0
@@ -229,3 +274,23 @@ func (p *Parser) parseTXT(data []byte) []string {
 
 	return texts
 }
+
+// parseCAA decodes CAA RDATA: flags, the tag with its length in front, then
+// the value filling the rest. It returns nil if data is too short.
+func parseCAA(data []byte) *CAA {
+	if len(data) < 2 || len(data) < 2+int(data[1]) {
+		return nil
+	}
+	tagEnd := 2 + int(data[1])
+	return &CAA{Flags: data[0], Tag: string(data[2:tagEnd]), Value: string(data[tagEnd:])}
+}
+
+// parseTLSA decodes TLSA RDATA: usage, selector and matching type, then the
+// certificate association data filling the rest. It returns nil if data is
+// too short.
+func parseTLSA(data []byte) *TLSA {
+	if len(data) < 4 {
+		return nil
+	}
+	return &TLSA{Usage: data[0], Selector: data[1], MatchingType: data[2], Certificate: data[3:]}
+}
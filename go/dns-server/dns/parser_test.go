@@ -1,7 +1,10 @@
 package dns
 
 import (
+	"bytes"
+	"fmt"
 	"net"
+	"strings"
 	"testing"
 )
 
@@ -123,6 +126,33 @@ func TestParseTooShort(t *testing.T) {
 	}
 }
 
+func TestParseHeader(t *testing.T) {
+	// A whole header, then a question cut short: enough to answer FORMERR
+	query := NewBuilder().BuildQuery(0x1234, "example.com", TypeA, true)[:15]
+	if _, err := NewParser(query).Parse(); err == nil {
+		t.Fatal("Parse of a truncated question succeeded, want an error")
+	}
+	header, err := ParseHeader(query)
+	if err != nil {
+		t.Fatalf("ParseHeader error: %v", err)
+	}
+	if header.ID != 0x1234 || header.QDCount != 1 || header.Flags&FlagRD == 0 {
+		t.Errorf("header = %+v, want ID 0x1234, 1 question and RD", header)
+	}
+
+	response, err := NewParser(NewBuilder().BuildErrorResponse(&Message{Header: *header}, RcodeFormatError)).Parse()
+	if err != nil {
+		t.Fatalf("Parse of the FORMERR response error: %v", err)
+	}
+	if response.Header.ID != 0x1234 || response.Rcode() != RcodeFormatError || len(response.Questions) != 0 {
+		t.Errorf("response header %+v with %d questions, want FORMERR for ID 0x1234 and no question", response.Header, len(response.Questions))
+	}
+
+	if _, err := ParseHeader(query[:11]); err == nil {
+		t.Error("ParseHeader of 11 bytes succeeded, want an error")
+	}
+}
+
 func TestBuildResponse(t *testing.T) {
 	query := &Message{
 		Header: Header{ID: 0x1234, QDCount: 1, Flags: FlagRD},
@@ -245,6 +275,160 @@ func TestBuildErrorResponse(t *testing.T) {
 	}
 }
 
+func TestBuildReferral(t *testing.T) {
+	query, _ := NewParser(NewBuilder().BuildQuery(1, "www.child.example.com", TypeA, false)).Parse()
+	authority := []ResourceRecord{NewNSRecord("child.example.com", 3600, "ns1.child.example.com")}
+	glue := []ResourceRecord{NewARecord("ns1.child.example.com", 3600, net.ParseIP("192.0.2.10"))}
+
+	msg, err := NewParser(NewBuilder().BuildReferral(query, authority, glue)).Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if msg.Header.Flags&FlagAA != 0 {
+		t.Error("referral has the AA flag set")
+	}
+	if msg.Rcode() != RcodeNoError || len(msg.Answers) != 0 {
+		t.Errorf("referral rcode %d with %d answers, want NOERROR without answers", msg.Rcode(), len(msg.Answers))
+	}
+	if len(msg.Authority) != 1 || len(msg.Additional) != 1 || !msg.Additional[0].Address.Equal(net.ParseIP("192.0.2.10")) {
+		t.Errorf("authority %v, additional %v; want the NS record and its glue", msg.Authority, msg.Additional)
+	}
+}
+
+func TestBuildReferralGlue(t *testing.T) {
+	query, _ := NewParser(NewBuilder().BuildQuery(1, "www.child.example.com", TypeA, false)).Parse()
+	authority := []ResourceRecord{NewNSRecord("child.example.com", 3600, "ns1.child.example.com")}
+	sibling := NewARecord("ns.other.example.com", 3600, net.ParseIP("192.0.2.20"))
+
+	// Glue in the child zone goes before sibling glue
+	glue := []ResourceRecord{sibling, NewARecord("ns1.child.example.com", 3600, net.ParseIP("192.0.2.10"))}
+	msg, err := NewParser(NewBuilder().BuildReferral(query, authority, glue)).Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if len(msg.Additional) != 2 || msg.Additional[0].Name != "ns1.child.example.com" {
+		t.Errorf("additional %v, want the child's glue first", msg.Additional)
+	}
+
+	// Over UDP, sibling glue that doesn't fit is left out...
+	var many []ResourceRecord
+	for i := 0; i < 40; i++ {
+		many = append(many, NewAAAARecord("ns.other.example.com", 3600, net.ParseIP(fmt.Sprintf("2001:db8::%d", i+1))))
+	}
+	b := NewBuilder()
+	b.UDP = true
+	msg, err = NewParser(b.BuildReferral(query, authority, many)).Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if b.Truncated || len(msg.Authority) != 1 || len(msg.Additional) == 0 || len(msg.Additional) == len(many) {
+		t.Errorf("truncated %v with %d NS and %d of %d sibling glue, want some of the glue left out", b.Truncated, len(msg.Authority), len(msg.Additional), len(many))
+	}
+
+	// ...but glue in the child zone that doesn't fit truncates the referral
+	for i := range many {
+		many[i].Name = "ns1.child.example.com"
+	}
+	msg, err = NewParser(b.BuildReferral(query, authority, many)).Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if !b.Truncated || msg.Header.Flags&FlagTC == 0 || len(msg.Authority) != 0 || len(msg.Additional) != 0 {
+		t.Errorf("truncated %v (TC %v) with %d NS and %d glue, want TC and no records", b.Truncated, msg.Header.Flags&FlagTC != 0, len(msg.Authority), len(msg.Additional))
+	}
+}
+
+func TestBuildResponseCompression(t *testing.T) {
+	query, _ := NewParser(NewBuilder().BuildQuery(1, "example.com", TypeMX, false)).Parse()
+	answers := []ResourceRecord{
+		NewMXRecord("example.com", 3600, 10, "mail.example.com"),
+		NewMXRecord("example.com", 3600, 20, "mail2.example.com"),
+	}
+	authority := []ResourceRecord{
+		NewNSRecord("example.com", 3600, "ns1.example.com"),
+		NewNSRecord("example.com", 3600, "ns2.example.net"),
+	}
+	additional := []ResourceRecord{NewARecord("MAIL.example.com", 3600, net.ParseIP("192.0.2.25"))}
+
+	b := NewBuilder()
+	data := b.buildResponse(query, RcodeNoError, FlagAA, answers, authority, additional, 0, false)
+	msg, err := NewParser(data).Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	// Every name after the question's ends in a pointer, to the question's
+	// name at offset 12 or a suffix of an earlier one
+	size := 12 + len(b.encodeName("example.com")) + 4
+	for _, rr := range append(append(append([]ResourceRecord{}, answers...), authority...), additional...) {
+		size += len(b.encodeName(rr.Name)) + 10 + len(b.buildRData(&rr))
+	}
+	if len(data) >= size-5*len(b.encodeName("example.com")) {
+		t.Errorf("response is %d bytes, want it compressed from %d", len(data), size)
+	}
+	if !bytes.Contains(data, []byte{0xC0, 12}) {
+		t.Error("no pointer to the question's name")
+	}
+
+	var got []string
+	for _, section := range [][]ResourceRecord{msg.Answers, msg.Authority, msg.Additional} {
+		for _, rr := range section {
+			got = append(got, rr.Name+" "+rr.RDataString())
+		}
+	}
+	want := []string{
+		"example.com 10 mail.example.com.",
+		"example.com 20 mail2.example.com.",
+		"example.com ns1.example.com.",
+		"example.com ns2.example.net.",
+		"MAIL.example.com 192.0.2.25",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("records %q, want %q", got, want)
+	}
+
+	// Nothing points into the authority section once it's dropped
+	b.UDP = true
+	for i := 0; i < 20; i++ {
+		authority = append(authority, NewNSRecord("example.com", 3600, fmt.Sprintf("ns%d.example%d.org", i, i)))
+	}
+	msg, err = NewParser(b.buildResponse(query, RcodeNoError, FlagAA, answers, authority, additional, 0, false)).Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if len(msg.Authority) != 0 || len(msg.Additional) != 0 {
+		t.Errorf("%d authority and %d additional records, want the authority section dropped", len(msg.Authority), len(msg.Additional))
+	}
+}
+
+func TestBuilderReset(t *testing.T) {
+	query, _ := NewParser(NewBuilder().BuildQuery(1, "example.com", TypeTXT, false)).Parse()
+	var answers []ResourceRecord
+	for i := 0; i < 5; i++ {
+		answers = append(answers, NewTXTRecord("example.com", 3600, strings.Repeat("x", 200)))
+	}
+
+	b := NewBuilder()
+	b.UDP = true
+	b.Recursive = true
+	b.EDNSUDPSize = 4096
+	b.BuildResponse(query, answers, nil)
+	if !b.Truncated {
+		t.Fatal("response over UDP not truncated")
+	}
+
+	// Reset builds what a new builder would, over TCP and authoritative
+	b.Reset()
+	got := b.BuildResponse(query, answers, nil)
+	want := NewBuilder().BuildResponse(query, answers, nil)
+	if !bytes.Equal(got, want) {
+		t.Errorf("response after Reset differs from a new builder's:\n%x\n%x", got, want)
+	}
+	if b.Truncated || b.Recursive || b.UDP || b.EDNSUDPSize != DefaultEDNSUDPSize || b.ClientSubnet != nil {
+		t.Errorf("settings after Reset: %+v", b)
+	}
+}
+
 func TestTypeToString(t *testing.T) {
 	tests := []struct {
 		typ  uint16
@@ -257,6 +441,12 @@ func TestTypeToString(t *testing.T) {
 		{TypeNS, "NS"},
 		{TypeTXT, "TXT"},
 		{TypeSOA, "SOA"},
+		{TypePTR, "PTR"},
+		{TypeTLSA, "TLSA"},
+		{TypeSVCB, "SVCB"},
+		{TypeHTTPS, "HTTPS"},
+		{TypeCAA, "CAA"},
+		{TypeANY, "ANY"},
 		{99, "TYPE99"},
 	}
 
@@ -268,6 +458,25 @@ func TestTypeToString(t *testing.T) {
 	}
 }
 
+func TestRcodeToString(t *testing.T) {
+	tests := []struct {
+		rcode uint8
+		want  string
+	}{
+		{RcodeNoError, "NOERROR"},
+		{RcodeNameError, "NXDOMAIN"},
+		{RcodeRefused, "REFUSED"},
+		{RcodeBadVersion, "BADVERS"},
+		{9, "RCODE9"},
+	}
+
+	for _, tt := range tests {
+		if got := RcodeToString(tt.rcode); got != tt.want {
+			t.Errorf("RcodeToString(%d) = %s, want %s", tt.rcode, got, tt.want)
+		}
+	}
+}
+
 func TestStringToType(t *testing.T) {
 	tests := []struct {
 		s    string
@@ -280,6 +489,12 @@ func TestStringToType(t *testing.T) {
 		{"NS", TypeNS},
 		{"TXT", TypeTXT},
 		{"SOA", TypeSOA},
+		{"PTR", TypePTR},
+		{"DS", TypeDS},
+		{"TLSA", TypeTLSA},
+		{"SVCB", TypeSVCB},
+		{"HTTPS", TypeHTTPS},
+		{"CAA", TypeCAA},
 		{"UNKNOWN", 0},
 	}
 
@@ -290,3 +505,60 @@ func TestStringToType(t *testing.T) {
 		}
 	}
 }
+
+func TestCAARoundTrip(t *testing.T) {
+	query, _ := NewParser(NewBuilder().BuildQuery(1, "example.com", TypeCAA, false)).Parse()
+	answers := []ResourceRecord{
+		NewCAARecord("example.com", 300, 0, "issue", "ca.example.net; account=230123"),
+		NewCAARecord("example.com", 300, CAAFlagCritical, "iodef", "mailto:security@example.com"),
+	}
+
+	msg, err := NewParser(NewBuilder().BuildResponse(query, answers, nil)).Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if len(msg.Answers) != 2 {
+		t.Fatalf("Answers = %d, want 2", len(msg.Answers))
+	}
+	for i, rr := range msg.Answers {
+		if rr.CAA == nil || *rr.CAA != *answers[i].CAA {
+			t.Errorf("answer %d CAA = %+v, want %+v", i, rr.CAA, answers[i].CAA)
+		}
+	}
+	// Flags, tag length, tag, value
+	want := append([]byte{0, 5}, "issueca.example.net; account=230123"...)
+	if !bytes.Equal(msg.Answers[0].RData, want) {
+		t.Errorf("RDATA = %q, want %q", msg.Answers[0].RData, want)
+	}
+
+	if caa := parseCAA([]byte{0, 5, 'i'}); caa != nil {
+		t.Errorf("parseCAA of truncated data = %+v, want nil", caa)
+	}
+}
+
+func TestTLSARoundTrip(t *testing.T) {
+	query, _ := NewParser(NewBuilder().BuildQuery(1, "_443._tcp.www.example.com", TypeTLSA, false)).Parse()
+	digest := bytes.Repeat([]byte{0xab}, 32)
+	answers := []ResourceRecord{NewTLSARecord("_443._tcp.www.example.com", 300, TLSAUsageDANEEE, 1, 1, digest)}
+
+	msg, err := NewParser(NewBuilder().BuildResponse(query, answers, nil)).Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if len(msg.Answers) != 1 {
+		t.Fatalf("Answers = %d, want 1", len(msg.Answers))
+	}
+	tlsa := msg.Answers[0].TLSA
+	if tlsa == nil || tlsa.Usage != 3 || tlsa.Selector != 1 || tlsa.MatchingType != 1 || !bytes.Equal(tlsa.Certificate, digest) {
+		t.Errorf("TLSA = %+v, want 3 1 1 and the digest", tlsa)
+	}
+	// Usage, selector, matching type, data
+	want := append([]byte{3, 1, 1}, digest...)
+	if !bytes.Equal(msg.Answers[0].RData, want) {
+		t.Errorf("RDATA = %x, want %x", msg.Answers[0].RData, want)
+	}
+
+	if tlsa := parseTLSA([]byte{3, 1, 1}); tlsa != nil {
+		t.Errorf("parseTLSA without data = %+v, want nil", tlsa)
+	}
+}
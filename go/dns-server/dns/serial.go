@@ -0,0 +1,58 @@
+package dns
+
+// Serial number arithmetic (RFC 1982) for SOA serials.
+//
+// Serials are 32-bit counters that wrap around, so "newer" cannot be decided
+// with a plain integer comparison: after 4294967295 comes 0. RFC 1982 defines
+// s1 < s2 when s2 is ahead of s1 by less than 2^31. Serials exactly 2^31
+// apart are incomparable.
+
+const serialHalf = 1 << 31
+
+// SerialCompare compares two SOA serials using RFC 1982 arithmetic.
+// It returns -1 if a precedes b, 1 if a follows b, and 0 if they are equal.
+// ok is false when the serials are exactly 2^31 apart and the order is undefined.
+func SerialCompare(a, b uint32) (cmp int, ok bool) {
+	if a == b {
+		return 0, true
+	}
+	diff := b - a // wraps modulo 2^32
+	switch {
+	case diff == serialHalf:
+		return 0, false
+	case diff < serialHalf:
+		return -1, true
+	default:
+		return 1, true
+	}
+}
+
+// SerialNewer reports whether serial a is newer than serial b.
+// Incomparable serials are never considered newer, so a secondary will not
+// transfer a zone on an ambiguous serial.
+func SerialNewer(a, b uint32) bool {
+	cmp, ok := SerialCompare(a, b)
+	return ok && cmp > 0
+}
+
+// SerialAdd adds n to serial s as defined by RFC 1982. The increment must be
+// at most 2^31-1; larger values are clamped.
+func SerialAdd(s, n uint32) uint32 {
+	if n > serialHalf-1 {
+		n = serialHalf - 1
+	}
+	return s + n
+}
+
+// NeedsRefresh reports whether a primary's serial is newer than the zone's,
+// i.e. whether a secondary holding this zone should transfer it.
+// A zone without SOA always needs a refresh.
+func (z *Zone) NeedsRefresh(primarySerial uint32) bool {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	if z.SOA == nil {
+		return true
+	}
+	return SerialNewer(primarySerial, z.SOA.Serial)
+}
@@ -0,0 +1,72 @@
+package dns
+
+import "testing"
+
+func TestSerialCompare(t *testing.T) {
+	tests := []struct {
+		a, b   uint32
+		cmp    int
+		ok     bool
+		reason string
+	}{
+		{1, 1, 0, true, "equal"},
+		{1, 2, -1, true, "simple increment"},
+		{2, 1, 1, true, "simple decrement"},
+		{4294967295, 0, -1, true, "wraparound"},
+		{0, 4294967295, 1, true, "wraparound reversed"},
+		{2024123199, 2025010100, -1, true, "date-based serial"},
+		{4294967000, 100, -1, true, "past the wrap"},
+		{0, 1 << 31, 0, false, "exactly 2^31 apart"},
+		{1 << 31, 0, 0, false, "exactly 2^31 apart reversed"},
+		{0, 1<<31 - 1, -1, true, "just under 2^31 apart"},
+		{0, 1<<31 + 1, 1, true, "just over 2^31 apart"},
+	}
+
+	for _, tt := range tests {
+		cmp, ok := SerialCompare(tt.a, tt.b)
+		if cmp != tt.cmp || ok != tt.ok {
+			t.Errorf("%s: SerialCompare(%d, %d) = %d, %v; want %d, %v",
+				tt.reason, tt.a, tt.b, cmp, ok, tt.cmp, tt.ok)
+		}
+	}
+}
+
+func TestSerialNewer(t *testing.T) {
+	if !SerialNewer(0, 4294967295) {
+		t.Error("SerialNewer(0, 4294967295) = false, want true after wraparound")
+	}
+	if SerialNewer(4294967295, 0) {
+		t.Error("SerialNewer(4294967295, 0) = true, want false")
+	}
+	if SerialNewer(1<<31, 0) {
+		t.Error("SerialNewer on incomparable serials = true, want false")
+	}
+}
+
+func TestSerialAdd(t *testing.T) {
+	if got := SerialAdd(4294967295, 1); got != 0 {
+		t.Errorf("SerialAdd(4294967295, 1) = %d, want 0", got)
+	}
+	if got := SerialAdd(0, 1<<31); got != 1<<31-1 {
+		t.Errorf("SerialAdd(0, 2^31) = %d, want clamped to 2^31-1", got)
+	}
+}
+
+func TestZoneNeedsRefresh(t *testing.T) {
+	zone := NewZone("example.com")
+	if !zone.NeedsRefresh(1) {
+		t.Error("NeedsRefresh without SOA = false, want true")
+	}
+
+	zone.AddRecord(NewSOARecord("example.com", 3600, &SOA{Serial: 4294967290}))
+
+	if !zone.NeedsRefresh(5) {
+		t.Error("NeedsRefresh(5) with serial 4294967290 = false, want true (wrapped)")
+	}
+	if zone.NeedsRefresh(4294967290) {
+		t.Error("NeedsRefresh with equal serial = true, want false")
+	}
+	if zone.NeedsRefresh(4294967000) {
+		t.Error("NeedsRefresh with older serial = true, want false")
+	}
+}
@@ -0,0 +1,298 @@
+package dns
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSignatureValidity is how long the RRSIG records made by a Signer
+// are valid for
+const DefaultSignatureValidity = 14 * 24 * time.Hour
+
+// signatureSkew backdates signatures, so validators whose clocks are a
+// little behind accept them too
+const signatureSkew = time.Hour
+
+// Signer signs a zone's answers as they are sent (online signing). The
+// DNSKEY RRset is signed with the key signing key, every other RRset with
+// the zone signing key. Signatures are cached and made again once less
+// than half their validity is left, so each RRset is signed only
+// occasionally; SignAll makes them all up front.
+//
+// Denial of existence uses NSEC records unless UseNSEC3 switches to NSEC3.
+// The chain proving which names and types don't exist is built from the
+// zone up front, so the zone must not change afterwards.
+type Signer struct {
+	zone     *Zone
+	ksk, zsk *Key
+
+	// Validity is how long new signatures are valid for
+	Validity time.Duration
+
+	dnskey  []ResourceRecord
+	nsecTTL uint32
+	names   []string            // Owner names in canonical order
+	types   map[string][]uint16 // Lowercase owner name -> its record types
+	empty   map[string]bool     // Empty non-terminals: names with none, but with names below
+	cuts    map[string]bool     // Delegations to child zones, whose NS records aren't signed
+	nsec3   *nsec3Chain         // Hashed chain, if NSEC3 is used instead of NSEC
+
+	mu   sync.Mutex
+	sigs map[string]*cachedSig // Keyed by name+type, like zone records
+}
+
+// cachedSig is the RRSIG for an RRset, with the canonical RRset it was
+// made for: an RRset that differs (such as DNS64 answers following a
+// changed A RRset) is signed again
+type cachedSig struct {
+	rrsig   ResourceRecord
+	rrset   []byte
+	refresh time.Time
+}
+
+// NewSigner prepares to sign zone with the given keys. The zone needs an
+// SOA record, whose TTL the DNSKEY records get and whose negative caching
+// TTL the NSEC records get.
+func NewSigner(zone *Zone, ksk, zsk *Key) (*Signer, error) {
+	if !ksk.IsKSK() || zsk.IsKSK() {
+		return nil, fmt.Errorf("zone %s: keys given in the wrong order", zone.Name)
+	}
+	soa := zone.Lookup(zone.Name, TypeSOA)
+	if len(soa) == 0 || soa[0].SOAData == nil {
+		return nil, fmt.Errorf("zone %s has no SOA record to sign", zone.Name)
+	}
+
+	s := &Signer{
+		zone:     zone,
+		ksk:      ksk,
+		zsk:      zsk,
+		Validity: DefaultSignatureValidity,
+		dnskey:   []ResourceRecord{ksk.DNSKEY(zone.Name, soa[0].TTL), zsk.DNSKEY(zone.Name, soa[0].TTL)},
+		nsecTTL:  min(soa[0].TTL, soa[0].SOAData.Minimum), // RFC 9077
+		types:    make(map[string][]uint16),
+		empty:    make(map[string]bool),
+		cuts:     make(map[string]bool),
+		sigs:     make(map[string]*cachedSig),
+	}
+
+	for _, rr := range zone.AllRecords() {
+		if !s.authoritative(rr) {
+			continue
+		}
+		name := strings.ToLower(rr.Name)
+		if rr.Type == TypeNS && name != zone.Name {
+			s.cuts[name] = true
+		}
+		types, ok := s.types[name]
+		if !ok {
+			s.names = append(s.names, name)
+		}
+		if len(types) == 0 || types[len(types)-1] != rr.Type {
+			types = append(types, rr.Type)
+		}
+		s.types[name] = types
+	}
+	s.types[zone.Name] = append(s.types[zone.Name], TypeDNSKEY)
+	sort.Slice(s.names, func(i, j int) bool {
+		return CompareNames(s.names[i], s.names[j]) < 0
+	})
+
+	for _, name := range s.names {
+		labels := splitName(name)
+		for i := 1; i < len(labels); i++ {
+			parent := strings.Join(labels[i:], ".")
+			if !zone.IsAuthoritative(parent) || s.types[parent] != nil {
+				break
+			}
+			s.empty[parent] = true
+		}
+	}
+	return s, nil
+}
+
+// authoritative reports whether rr is the zone's own data: not below a
+// delegation to a child zone, like glue, and at one only the NS and DS
+// records (RFC 4035 section 2.2)
+func (s *Signer) authoritative(rr ResourceRecord) bool {
+	if !s.zone.IsAuthoritative(rr.Name) {
+		return false
+	}
+	cut, _ := s.zone.Delegation(rr.Name)
+	return cut == "" || strings.EqualFold(cut, rr.Name) && (rr.Type == TypeNS || rr.Type == TypeDS)
+}
+
+// Exists reports whether name is in the zone: it has records, or names
+// below it do (an empty non-terminal, which has no records but does exist)
+func (s *Signer) Exists(name string) bool {
+	name = strings.ToLower(name)
+	return s.types[name] != nil || s.empty[name]
+}
+
+// KSK returns the key signing key, whose DS record goes in the parent zone
+func (s *Signer) KSK() *Key {
+	return s.ksk
+}
+
+// Lookup returns the records the Signer adds to the zone: the DNSKEY RRset
+// at the apex, and the NSEC record of every name in the zone
+func (s *Signer) Lookup(name string, qtype uint16) []ResourceRecord {
+	name = strings.ToLower(name)
+	switch {
+	case qtype == TypeDNSKEY && name == s.zone.Name:
+		return s.dnskey
+	case qtype == TypeNSEC3PARAM && name == s.zone.Name && s.nsec3 != nil:
+		return []ResourceRecord{s.nsec3.param(s.zone.Name)}
+	case qtype == TypeNSEC && s.types[name] != nil && s.nsec3 == nil:
+		return []ResourceRecord{s.NSEC(name)}
+	}
+	return nil
+}
+
+// NSEC returns the NSEC record of the last name in the zone at or before
+// name in canonical order: for a name in the zone its own, listing its
+// types, and otherwise the one proving it doesn't exist
+func (s *Signer) NSEC(name string) ResourceRecord {
+	i := sort.Search(len(s.names), func(i int) bool {
+		return CompareNames(s.names[i], name) > 0
+	})
+	// The apex sorts before every name in the zone
+	owner := s.names[max(i-1, 0)]
+	next := s.names[i%len(s.names)]
+	types := append([]uint16{TypeRRSIG, TypeNSEC}, s.types[owner]...)
+	return NewNSECRecord(owner, s.nsecTTL, next, types)
+}
+
+// Denial returns the NSEC records proving a negative answer for name: with
+// nxdomain set that the name doesn't exist and that no wildcard could have
+// matched it (RFC 4035 section 3.1.3.2), and otherwise that the name has
+// no records of the type asked for
+func (s *Signer) Denial(name string, nxdomain bool) []ResourceRecord {
+	if s.nsec3 != nil {
+		return s.nsec3Denial(name, nxdomain)
+	}
+
+	nsec := s.NSEC(name)
+	if !nxdomain {
+		return []ResourceRecord{nsec}
+	}
+
+	encloser, _ := s.closestEncloser(name)
+	wildcard := s.NSEC("*." + encloser)
+	if wildcard.Name == nsec.Name {
+		return []ResourceRecord{nsec}
+	}
+	return []ResourceRecord{nsec, wildcard}
+}
+
+// closestEncloser returns the longest name above name that exists in the
+// zone, and the name one label longer on the way to name: the next closer
+// name, whose absence the NXDOMAIN proof rests on
+func (s *Signer) closestEncloser(name string) (encloser, nextCloser string) {
+	labels := splitName(strings.ToLower(name))
+	for i := 1; i < len(labels); i++ {
+		parent := strings.Join(labels[i:], ".")
+		if s.Exists(parent) {
+			return parent, strings.Join(labels[i-1:], ".")
+		}
+	}
+	return s.zone.Name, strings.ToLower(name)
+}
+
+// Sign returns records followed by an RRSIG record for each of their
+// RRsets. Records of one RRset must be next to each other, as Lookup
+// returns them.
+func (s *Signer) Sign(records []ResourceRecord) ([]ResourceRecord, error) {
+	signed := append([]ResourceRecord(nil), records...)
+	for start := 0; start < len(records); {
+		end := start + 1
+		for end < len(records) && records[end].Type == records[start].Type &&
+			strings.EqualFold(records[end].Name, records[start].Name) {
+			end++
+		}
+		rrsig, err := s.signRRset(records[start:end], time.Now())
+		if err != nil {
+			return nil, err
+		}
+		signed = append(signed, rrsig)
+		start = end
+	}
+	return signed, nil
+}
+
+// SignAll signs every RRset of the zone, with the DNSKEY and NSEC records,
+// so no query has to wait for a signature
+func (s *Signer) SignAll() error {
+	now := time.Now()
+	sign := func(rrset []ResourceRecord) error {
+		_, err := s.signRRset(rrset, now)
+		return err
+	}
+
+	if err := sign(s.dnskey); err != nil {
+		return err
+	}
+	if s.nsec3 != nil {
+		if err := sign([]ResourceRecord{s.nsec3.param(s.zone.Name)}); err != nil {
+			return err
+		}
+		for i := range s.nsec3.hashes {
+			if err := sign([]ResourceRecord{s.nsec3.record(i, s.zone.Name, s.nsecTTL)}); err != nil {
+				return err
+			}
+		}
+	} else {
+		for _, name := range s.names {
+			if err := sign([]ResourceRecord{s.NSEC(name)}); err != nil {
+				return err
+			}
+		}
+	}
+
+	s.zone.mu.RLock()
+	rrsets := make([][]ResourceRecord, 0, len(s.zone.Records))
+	for _, rrset := range s.zone.Records {
+		rrsets = append(rrsets, rrset)
+	}
+	s.zone.mu.RUnlock()
+	for _, rrset := range rrsets {
+		// The NS records at a delegation are the child zone's to sign
+		if len(rrset) > 0 && s.authoritative(rrset[0]) && !(rrset[0].Type == TypeNS && s.cuts[strings.ToLower(rrset[0].Name)]) {
+			if err := sign(rrset); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// signRRset returns the RRSIG for an RRset, from the cache if there is a
+// fresh one
+func (s *Signer) signRRset(rrset []ResourceRecord, now time.Time) (ResourceRecord, error) {
+	key := s.zone.recordKey(rrset[0].Name, rrset[0].Type)
+	canonical := canonicalRRset(rrset, rrset[0].TTL)
+
+	s.mu.Lock()
+	cached := s.sigs[key]
+	s.mu.Unlock()
+	if cached != nil && now.Before(cached.refresh) && bytes.Equal(cached.rrset, canonical) {
+		return cached.rrsig, nil
+	}
+
+	signer := s.zsk
+	if rrset[0].Type == TypeDNSKEY {
+		signer = s.ksk
+	}
+	rrsig, err := signer.Sign(rrset, s.zone.Name, now.Add(-signatureSkew), now.Add(s.Validity))
+	if err != nil {
+		return ResourceRecord{}, fmt.Errorf("signing %s %s: %w", rrset[0].Name, TypeToString(rrset[0].Type), err)
+	}
+
+	s.mu.Lock()
+	s.sigs[key] = &cachedSig{rrsig: rrsig, rrset: canonical, refresh: now.Add(s.Validity / 2)}
+	s.mu.Unlock()
+	return rrsig, nil
+}
@@ -0,0 +1,226 @@
+package dns
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// newTestSigner signs a small zone with fresh keys
+func newTestSigner(t *testing.T) (*Signer, *Zone) {
+	t.Helper()
+	zone := NewZone("example.com")
+	zone.AddRecord(NewSOARecord("example.com", 3600, &SOA{
+		MName: "ns1.example.com", RName: "hostmaster.example.com", Serial: 1, Minimum: 300,
+	}))
+	zone.AddRecord(NewNSRecord("example.com", 3600, "ns1.example.com"))
+	zone.AddRecord(NewARecord("ns1.example.com", 3600, net.ParseIP("192.0.2.53")))
+	zone.AddRecord(NewARecord("www.example.com", 300, net.ParseIP("192.0.2.1")))
+	zone.AddRecord(NewARecord("www.example.com", 300, net.ParseIP("192.0.2.2")))
+	zone.AddRecord(NewMXRecord("example.com", 3600, 10, "mail.example.com"))
+
+	ksk, err := GenerateKey(true)
+	if err != nil {
+		t.Fatalf("GenerateKey error: %v", err)
+	}
+	zsk, err := GenerateKey(false)
+	if err != nil {
+		t.Fatalf("GenerateKey error: %v", err)
+	}
+	signer, err := NewSigner(zone, ksk, zsk)
+	if err != nil {
+		t.Fatalf("NewSigner error: %v", err)
+	}
+	return signer, zone
+}
+
+func TestSignerSign(t *testing.T) {
+	signer, zone := newTestSigner(t)
+
+	www := zone.Lookup("www.example.com", TypeA)
+	signed, err := signer.Sign(www)
+	if err != nil {
+		t.Fatalf("Sign error: %v", err)
+	}
+	if len(signed) != 3 || signed[2].Type != TypeRRSIG {
+		t.Fatalf("Sign returned %d records, want the 2 A records and an RRSIG", len(signed))
+	}
+	if !verifyRRSIG(signer.zsk, www, signed[2]) {
+		t.Error("A RRSIG does not verify with the ZSK")
+	}
+
+	// A fresh signature is reused
+	again, err := signer.Sign(www)
+	if err != nil {
+		t.Fatalf("Sign error: %v", err)
+	}
+	if !bytes.Equal(again[2].RData, signed[2].RData) {
+		t.Error("signature made again, want the cached one")
+	}
+
+	// The DNSKEY RRset is signed by the KSK
+	dnskey := signer.Lookup("EXAMPLE.com", TypeDNSKEY)
+	if len(dnskey) != 2 {
+		t.Fatalf("Lookup DNSKEY returned %d records, want 2", len(dnskey))
+	}
+	signed, err = signer.Sign(dnskey)
+	if err != nil {
+		t.Fatalf("Sign error: %v", err)
+	}
+	if !verifyRRSIG(signer.ksk, dnskey, signed[len(signed)-1]) {
+		t.Error("DNSKEY RRSIG does not verify with the KSK")
+	}
+
+	// One RRSIG per RRset
+	ns := zone.Lookup("example.com", TypeNS)
+	signed, err = signer.Sign(append(append([]ResourceRecord(nil), www...), ns...))
+	if err != nil {
+		t.Fatalf("Sign error: %v", err)
+	}
+	if len(signed) != 5 {
+		t.Errorf("Sign returned %d records, want 3 records and 2 RRSIGs", len(signed))
+	}
+}
+
+func TestSignerNSEC(t *testing.T) {
+	signer, _ := newTestSigner(t)
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		// A name in the zone gets its own NSEC record
+		{"example.com", "example.com NSEC ns1.example.com. NS SOA MX RRSIG NSEC DNSKEY"},
+		{"WWW.example.com", "www.example.com NSEC example.com. A RRSIG NSEC"},
+		// Other names are covered by the NSEC record before them
+		{"mail.example.com", "example.com NSEC ns1.example.com. NS SOA MX RRSIG NSEC DNSKEY"},
+		{"a.ns1.example.com", "ns1.example.com NSEC www.example.com. A RRSIG NSEC"},
+		{"zzz.example.com", "www.example.com NSEC example.com. A RRSIG NSEC"},
+	}
+
+	for _, tt := range tests {
+		nsec := signer.NSEC(tt.name)
+		if got := nsec.Name + " NSEC " + nsec.RDataString(); got != tt.want {
+			t.Errorf("NSEC(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if got := signer.Lookup("www.example.com", TypeNSEC); len(got) != 1 {
+		t.Errorf("Lookup NSEC returned %d records, want 1", len(got))
+	}
+	if got := signer.Lookup("nope.example.com", TypeNSEC); got != nil {
+		t.Errorf("Lookup NSEC of a missing name = %v, want nil", got)
+	}
+}
+
+func TestSignerDenial(t *testing.T) {
+	signer, _ := newTestSigner(t)
+
+	// NODATA: the name's own NSEC record
+	denial := signer.Denial("www.example.com", false)
+	if len(denial) != 1 || denial[0].Name != "www.example.com" {
+		t.Errorf("NODATA denial = %v, want the NSEC of www.example.com", denial)
+	}
+
+	// NXDOMAIN: the NSEC covering the name, and the one covering the
+	// wildcard at its closest encloser (*.example.com sorts before ns1)
+	denial = signer.Denial("zzz.example.com", true)
+	if len(denial) != 2 || denial[0].Name != "www.example.com" || denial[1].Name != "example.com" {
+		t.Errorf("NXDOMAIN denial = %v, want the NSECs of www.example.com and example.com", denial)
+	}
+
+	// One record when it covers both
+	for _, name := range []string{"mail.example.com", "x.www.example.com"} {
+		denial = signer.Denial(name, true)
+		if len(denial) != 1 {
+			t.Errorf("NXDOMAIN denial of %s = %v, want one NSEC", name, denial)
+		}
+	}
+}
+
+func TestNewSignerErrors(t *testing.T) {
+	zone := NewZone("example.com")
+	zone.AddRecord(NewARecord("www.example.com", 300, net.ParseIP("192.0.2.1")))
+
+	ksk, _ := GenerateKey(true)
+	zsk, _ := GenerateKey(false)
+	if _, err := NewSigner(zone, ksk, zsk); err == nil {
+		t.Error("NewSigner succeeded without an SOA record, want error")
+	}
+
+	zone.AddRecord(NewSOARecord("example.com", 3600, &SOA{MName: "ns1.example.com", RName: "hostmaster.example.com"}))
+	if _, err := NewSigner(zone, zsk, ksk); err == nil {
+		t.Error("NewSigner succeeded with the keys swapped, want error")
+	}
+}
+
+func TestSignerSignAll(t *testing.T) {
+	signer, zone := newTestSigner(t)
+
+	if err := signer.SignAll(); err != nil {
+		t.Fatalf("SignAll error: %v", err)
+	}
+	// SOA, NS, MX, 2 A RRsets, DNSKEY and 3 NSEC records
+	if n := len(signer.sigs); n != 9 {
+		t.Errorf("%d RRsets signed, want 9", n)
+	}
+
+	// Queries then use the signatures made up front
+	soa := zone.Lookup("example.com", TypeSOA)
+	before := signer.sigs[zone.recordKey("example.com", TypeSOA)].rrsig
+	signed, err := signer.Sign(soa)
+	if err != nil {
+		t.Fatalf("Sign error: %v", err)
+	}
+	if !bytes.Equal(signed[1].RData, before.RData) {
+		t.Error("SOA signed again, want the signature from SignAll")
+	}
+}
+
+func TestSignerDelegation(t *testing.T) {
+	signer, zone := newTestSigner(t)
+	zone.AddRecord(NewNSRecord("secure.example.com", 3600, "ns.secure.example.com"))
+	zone.AddRecord(NewARecord("ns.secure.example.com", 3600, net.ParseIP("192.0.2.60")))
+	zone.AddRecord(signer.ksk.DS("secure.example.com", 3600))
+	zone.AddRecord(NewNSRecord("insecure.example.com", 3600, "ns.other.net"))
+	signer, err := NewSigner(zone, signer.ksk, signer.zsk)
+	if err != nil {
+		t.Fatalf("NewSigner error: %v", err)
+	}
+
+	// The cuts are in the chain with their NS and DS types; glue isn't
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"secure.example.com", "secure.example.com NSEC www.example.com. NS DS RRSIG NSEC"},
+		{"insecure.example.com", "insecure.example.com NSEC ns1.example.com. NS RRSIG NSEC"},
+	}
+	for _, tt := range tests {
+		nsec := signer.NSEC(tt.name)
+		if got := nsec.Name + " NSEC " + nsec.RDataString(); got != tt.want {
+			t.Errorf("NSEC(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if signer.Exists("ns.secure.example.com") {
+		t.Error("glue below a cut is in the NSEC chain")
+	}
+
+	if err := signer.SignAll(); err != nil {
+		t.Fatalf("SignAll error: %v", err)
+	}
+	for _, rrset := range []struct {
+		name  string
+		qtype uint16
+		want  bool
+	}{
+		{"secure.example.com", TypeDS, true},
+		{"secure.example.com", TypeNS, false},
+		{"ns.secure.example.com", TypeA, false},
+		{"insecure.example.com", TypeNS, false},
+	} {
+		if _, signed := signer.sigs[zone.recordKey(rrset.name, rrset.qtype)]; signed != rrset.want {
+			t.Errorf("%s %s signed = %v, want %v", rrset.name, TypeToString(rrset.qtype), signed, rrset.want)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package dns
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SvcParamKeys (RFC 9460 section 14.3.2)
+const (
+	SvcParamMandatory     uint16 = 0 // Keys a client must understand to use the record
+	SvcParamALPN          uint16 = 1 // Protocols supported, e.g. h2, h3
+	SvcParamNoDefaultALPN uint16 = 2 // The scheme's default protocol isn't supported
+	SvcParamPort          uint16 = 3 // Port to connect to
+	SvcParamIPv4Hint      uint16 = 4 // Addresses of the target, to save a lookup
+	SvcParamECH           uint16 = 5 // Encrypted ClientHello configuration
+	SvcParamIPv6Hint      uint16 = 6
+)
+
+var svcParamKeyNames = map[uint16]string{
+	SvcParamMandatory:     "mandatory",
+	SvcParamALPN:          "alpn",
+	SvcParamNoDefaultALPN: "no-default-alpn",
+	SvcParamPort:          "port",
+	SvcParamIPv4Hint:      "ipv4hint",
+	SvcParamECH:           "ech",
+	SvcParamIPv6Hint:      "ipv6hint",
+}
+
+// SVCB is the data of an SVCB or HTTPS record (RFC 9460), which tells
+// clients where and how to connect to a service. With Priority 0 (alias
+// mode) it only points at Target; otherwise Target serves the service
+// with the given parameters, lower priorities preferred.
+type SVCB struct {
+	Priority uint16
+	Target   string // Without the trailing dot; "" for the root, meaning the owner name
+	Params   []SvcParam
+}
+
+// SvcParam is one service parameter, its value in wire format
+type SvcParam struct {
+	Key   uint16
+	Value []byte
+}
+
+// NewSVCBRecord creates an SVCB record
+func NewSVCBRecord(name string, ttl uint32, priority uint16, target string, params ...SvcParam) ResourceRecord {
+	return newSVCBRecord(TypeSVCB, name, ttl, priority, target, params)
+}
+
+// NewHTTPSRecord creates an HTTPS record, an SVCB record for HTTPS origins
+func NewHTTPSRecord(name string, ttl uint32, priority uint16, target string, params ...SvcParam) ResourceRecord {
+	return newSVCBRecord(TypeHTTPS, name, ttl, priority, target, params)
+}
+
+func newSVCBRecord(rrtype uint16, name string, ttl uint32, priority uint16, target string, params []SvcParam) ResourceRecord {
+	return ResourceRecord{
+		Name:  name,
+		Type:  rrtype,
+		Class: ClassIN,
+		TTL:   ttl,
+		SVCB:  &SVCB{Priority: priority, Target: target, Params: params},
+	}
+}
+
+// encodeSVCB encodes SVCB RDATA: priority, the uncompressed target name,
+// then each parameter's key and length before its value, in key order
+func (b *Builder) encodeSVCB(svcb *SVCB) []byte {
+	data := binary.BigEndian.AppendUint16(nil, svcb.Priority)
+	data = append(data, b.encodeName(svcb.Target)...)
+
+	params := append([]SvcParam(nil), svcb.Params...)
+	sort.SliceStable(params, func(i, j int) bool { return params[i].Key < params[j].Key })
+	for _, p := range params {
+		data = binary.BigEndian.AppendUint16(data, p.Key)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(p.Value)))
+		data = append(data, p.Value...)
+	}
+	return data
+}
+
+// parseSVCB decodes SVCB RDATA ending at end. It returns nil if the RDATA
+// is malformed.
+func (p *Parser) parseSVCB(end int) *SVCB {
+	if p.pos+2 > end {
+		return nil
+	}
+	svcb := &SVCB{Priority: binary.BigEndian.Uint16(p.data[p.pos:])}
+	p.pos += 2
+
+	target, err := p.parseName()
+	if err != nil || p.pos > end {
+		return nil
+	}
+	svcb.Target = target
+
+	for p.pos < end {
+		if p.pos+4 > end {
+			return nil
+		}
+		key := binary.BigEndian.Uint16(p.data[p.pos:])
+		length := int(binary.BigEndian.Uint16(p.data[p.pos+2:]))
+		p.pos += 4
+		if p.pos+length > end {
+			return nil
+		}
+		svcb.Params = append(svcb.Params, SvcParam{Key: key, Value: p.data[p.pos : p.pos+length]})
+		p.pos += length
+	}
+	return svcb
+}
+
+// svcbString formats SVCB data in presentation format, e.g.
+// 1 . alpn=h2,h3 port=8443
+func svcbString(svcb *SVCB) string {
+	parts := []string{strconv.Itoa(int(svcb.Priority)), svcb.Target + "."}
+	for _, p := range svcb.Params {
+		parts = append(parts, p.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+// svcParamKeyString returns the name of a key, or keyNNNNN for keys
+// without one
+func svcParamKeyString(key uint16) string {
+	if name, ok := svcParamKeyNames[key]; ok {
+		return name
+	}
+	return fmt.Sprintf("key%d", key)
+}
+
+// parseSvcParamKey returns the key named s
+func parseSvcParamKey(s string) (uint16, error) {
+	for key, name := range svcParamKeyNames {
+		if name == s {
+			return key, nil
+		}
+	}
+	if n, ok := strings.CutPrefix(s, "key"); ok {
+		if key, err := strconv.ParseUint(n, 10, 16); err == nil {
+			return uint16(key), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown SvcParamKey %q", s)
+}
+
+// String formats the parameter as key=value, or just key for one without
+// a value
+func (p SvcParam) String() string {
+	key := svcParamKeyString(p.Key)
+	if len(p.Value) == 0 {
+		return key
+	}
+
+	var values []string
+	switch p.Key {
+	case SvcParamMandatory:
+		for v := p.Value; len(v) >= 2; v = v[2:] {
+			values = append(values, svcParamKeyString(binary.BigEndian.Uint16(v)))
+		}
+	case SvcParamALPN:
+		for v := p.Value; len(v) > 0 && len(v) > int(v[0]); v = v[1+int(v[0]):] {
+			values = append(values, string(v[1:1+int(v[0])]))
+		}
+	case SvcParamPort:
+		if len(p.Value) == 2 {
+			values = append(values, strconv.Itoa(int(binary.BigEndian.Uint16(p.Value))))
+		}
+	case SvcParamIPv4Hint, SvcParamIPv6Hint:
+		size := net.IPv4len
+		if p.Key == SvcParamIPv6Hint {
+			size = net.IPv6len
+		}
+		for v := p.Value; len(v) >= size; v = v[size:] {
+			values = append(values, net.IP(v[:size]).String())
+		}
+	case SvcParamECH:
+		values = append(values, base64.StdEncoding.EncodeToString(p.Value))
+	}
+	if values == nil {
+		return key + "=" + quoteTXT(string(p.Value))
+	}
+	return key + "=" + strings.Join(values, ",")
+}
+
+// ParseSvcParam parses a parameter in presentation format, key=value or
+// just key, into wire format. Values may be quoted; lists are separated by
+// commas.
+func ParseSvcParam(s string) (SvcParam, error) {
+	name, value, hasValue := strings.Cut(s, "=")
+	key, err := parseSvcParamKey(name)
+	if err != nil {
+		return SvcParam{}, err
+	}
+	value = strings.Trim(value, `"`)
+	param := SvcParam{Key: key}
+
+	if !hasValue || value == "" {
+		if key == SvcParamNoDefaultALPN || !svcParamKnown(key) {
+			return param, nil
+		}
+		return SvcParam{}, fmt.Errorf("%s needs a value", name)
+	}
+
+	switch key {
+	case SvcParamMandatory:
+		for _, n := range strings.Split(value, ",") {
+			k, err := parseSvcParamKey(n)
+			if err != nil {
+				return SvcParam{}, err
+			}
+			param.Value = binary.BigEndian.AppendUint16(param.Value, k)
+		}
+	case SvcParamALPN:
+		for _, id := range strings.Split(value, ",") {
+			if id == "" || len(id) > 255 {
+				return SvcParam{}, fmt.Errorf("invalid alpn ID %q", id)
+			}
+			param.Value = append(param.Value, byte(len(id)))
+			param.Value = append(param.Value, id...)
+		}
+	case SvcParamNoDefaultALPN:
+		return SvcParam{}, fmt.Errorf("no-default-alpn takes no value")
+	case SvcParamPort:
+		port, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return SvcParam{}, fmt.Errorf("invalid port %q", value)
+		}
+		param.Value = binary.BigEndian.AppendUint16(nil, uint16(port))
+	case SvcParamIPv4Hint, SvcParamIPv6Hint:
+		for _, addr := range strings.Split(value, ",") {
+			ip := net.ParseIP(addr)
+			if key == SvcParamIPv4Hint && (ip == nil || ip.To4() == nil) {
+				return SvcParam{}, fmt.Errorf("invalid IPv4 hint %q", addr)
+			}
+			if key == SvcParamIPv6Hint && (ip == nil || ip.To4() != nil) {
+				return SvcParam{}, fmt.Errorf("invalid IPv6 hint %q", addr)
+			}
+			if key == SvcParamIPv4Hint {
+				ip = ip.To4()
+			}
+			param.Value = append(param.Value, ip...)
+		}
+	case SvcParamECH:
+		ech, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return SvcParam{}, fmt.Errorf("ech is not base64: %v", err)
+		}
+		param.Value = ech
+	default:
+		param.Value = []byte(value)
+	}
+	return param, nil
+}
+
+// svcParamKnown reports whether the key is one defined by RFC 9460
+func svcParamKnown(key uint16) bool {
+	_, ok := svcParamKeyNames[key]
+	return ok
+}
+
+// parseSVCBFields parses the RDATA fields of an SVCB or HTTPS record in a
+// zone file: priority, target and parameters. The checks follow RFC 9460
+// section 8: no parameters in alias mode, no key twice, every key listed
+// as mandatory present, and no-default-alpn only with alpn.
+func parseSVCBFields(fields []string, origin string) (*SVCB, error) {
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("SVCB needs priority and target")
+	}
+	priority, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SVCB priority: %v", err)
+	}
+	svcb := &SVCB{Priority: uint16(priority)}
+
+	switch target := fields[1]; {
+	case target == ".":
+		svcb.Target = ""
+	case target == "@":
+		svcb.Target = origin
+	case strings.HasSuffix(target, "."):
+		svcb.Target = strings.TrimSuffix(target, ".")
+	default:
+		svcb.Target = target + "." + origin
+	}
+
+	if svcb.Priority == 0 && len(fields) > 2 {
+		return nil, fmt.Errorf("SVCB in alias mode (priority 0) has no parameters")
+	}
+	seen := make(map[uint16]bool)
+	for _, field := range fields[2:] {
+		param, err := ParseSvcParam(field)
+		if err != nil {
+			return nil, err
+		}
+		if seen[param.Key] {
+			return nil, fmt.Errorf("SvcParamKey %s given twice", svcParamKeyString(param.Key))
+		}
+		seen[param.Key] = true
+		svcb.Params = append(svcb.Params, param)
+	}
+	sort.SliceStable(svcb.Params, func(i, j int) bool { return svcb.Params[i].Key < svcb.Params[j].Key })
+
+	if seen[SvcParamNoDefaultALPN] && !seen[SvcParamALPN] {
+		return nil, fmt.Errorf("no-default-alpn needs alpn")
+	}
+	for _, p := range svcb.Params {
+		if p.Key != SvcParamMandatory {
+			continue
+		}
+		for v := p.Value; len(v) >= 2; v = v[2:] {
+			key := binary.BigEndian.Uint16(v)
+			if key == SvcParamMandatory || !seen[key] {
+				return nil, fmt.Errorf("mandatory key %s is not given", svcParamKeyString(key))
+			}
+		}
+	}
+	return svcb, nil
+}
@@ -0,0 +1,89 @@
+package dns
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSVCBRoundTrip(t *testing.T) {
+	query, _ := NewParser(NewBuilder().BuildQuery(1, "example.com", TypeHTTPS, false)).Parse()
+	alpn, _ := ParseSvcParam("alpn=h2,h3")
+	hint, _ := ParseSvcParam("ipv6hint=2001:db8::1")
+	answers := []ResourceRecord{
+		NewHTTPSRecord("example.com", 300, 1, "", hint, alpn),
+		NewHTTPSRecord("example.com", 300, 0, "cdn.example.net"),
+	}
+
+	msg, err := NewParser(NewBuilder().BuildResponse(query, answers, nil)).Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if len(msg.Answers) != 2 {
+		t.Fatalf("Answers = %d, want 2", len(msg.Answers))
+	}
+	// Parameters come back in key order
+	if got, want := msg.Answers[0].RDataString(), "1 . alpn=h2,h3 ipv6hint=2001:db8::1"; got != want {
+		t.Errorf("answer 0 = %s, want %s", got, want)
+	}
+	if got, want := msg.Answers[1].RDataString(), "0 cdn.example.net."; got != want {
+		t.Errorf("answer 1 = %s, want %s", got, want)
+	}
+
+	// RFC 9460 appendix D.2, "1 foo.example.com. port=53"
+	port, _ := ParseSvcParam("port=53")
+	rr := NewSVCBRecord("example.com", 300, 1, "foo.example.com", port)
+	want := []byte{
+		0x00, 0x01,
+		0x03, 'f', 'o', 'o', 0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0x03, 'c', 'o', 'm', 0x00,
+		0x00, 0x03, 0x00, 0x02, 0x00, 0x35,
+	}
+	if got := NewBuilder().buildRData(&rr); !bytes.Equal(got, want) {
+		t.Errorf("RDATA = % x, want % x", got, want)
+	}
+
+	p := NewParser(want[:len(want)-1])
+	if svcb := p.parseSVCB(len(want) - 1); svcb != nil {
+		t.Errorf("parseSVCB of truncated data = %+v, want nil", svcb)
+	}
+}
+
+func TestParseSVCBFields(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`@ IN HTTPS 0 www`, "0 www.test.com."},
+		{`@ IN HTTPS 1 . alpn=h3,h2 port=8443`, "1 . alpn=h3,h2 port=8443"},
+		{`_dns IN SVCB 1 dns.test.com. port=853 alpn="dot"`, "1 dns.test.com. alpn=dot port=853"},
+		{`@ IN HTTPS 1 . ipv4hint=192.0.2.1,192.0.2.2 ech=AEj+DQBE`, "1 . ipv4hint=192.0.2.1,192.0.2.2 ech=AEj+DQBE"},
+		{`@ IN HTTPS 1 . mandatory=alpn alpn=h2 no-default-alpn`, "1 . mandatory=alpn alpn=h2 no-default-alpn"},
+		{`@ IN HTTPS 2 . key667=hello`, `2 . key667="hello"`},
+	}
+	for _, tt := range tests {
+		rr, _, err := parseZoneLine(tt.line, "test.com", "test.com", 3600)
+		if err != nil {
+			t.Errorf("parseZoneLine(%q) error: %v", tt.line, err)
+			continue
+		}
+		if got := rr.RDataString(); got != tt.want {
+			t.Errorf("parseZoneLine(%q) = %s, want %s", tt.line, got, tt.want)
+		}
+	}
+
+	for _, line := range []string{
+		`@ IN HTTPS 1`,
+		`@ IN HTTPS 0 . alpn=h2`,
+		`@ IN HTTPS 1 . port=70000`,
+		`@ IN HTTPS 1 . port=443 port=8443`,
+		`@ IN HTTPS 1 . ipv4hint=2001:db8::1`,
+		`@ IN HTTPS 1 . ipv6hint=192.0.2.1`,
+		`@ IN HTTPS 1 . no-default-alpn`,
+		`@ IN HTTPS 1 . mandatory=port alpn=h2`,
+		`@ IN HTTPS 1 . ech=!!`,
+		`@ IN HTTPS 1 . color=blue`,
+	} {
+		if _, _, err := parseZoneLine(line, "test.com", "test.com", 3600); err == nil {
+			t.Errorf("parseZoneLine(%q) succeeded, want error", line)
+		}
+	}
+}
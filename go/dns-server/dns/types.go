@@ -1,25 +1,43 @@
-// Package dns implements DNS message parsing and building.
+// Package dns implements DNS message parsing and building, zone files,
+// and a small client for querying DNS servers.
 package dns
 
 import (
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
 )
 
 // DNS record types
 const (
-	TypeA     uint16 = 1
-	TypeNS    uint16 = 2
-	TypeCNAME uint16 = 5
-	TypeSOA   uint16 = 6
-	TypeMX    uint16 = 15
-	TypeTXT   uint16 = 16
-	TypeAAAA  uint16 = 28
+	TypeA          uint16 = 1
+	TypeNS         uint16 = 2
+	TypeCNAME      uint16 = 5
+	TypeSOA        uint16 = 6
+	TypePTR        uint16 = 12
+	TypeMX         uint16 = 15
+	TypeTXT        uint16 = 16
+	TypeAAAA       uint16 = 28
+	TypeOPT        uint16 = 41  // EDNS0 pseudo-record (RFC 6891)
+	TypeDS         uint16 = 43  // DNSSEC delegation signer (RFC 4034)
+	TypeRRSIG      uint16 = 46  // DNSSEC signature
+	TypeNSEC       uint16 = 47  // DNSSEC authenticated denial of existence
+	TypeDNSKEY     uint16 = 48  // DNSSEC public key
+	TypeNSEC3      uint16 = 50  // Hashed authenticated denial of existence (RFC 5155)
+	TypeNSEC3PARAM uint16 = 51  // NSEC3 hash parameters of a zone
+	TypeTLSA       uint16 = 52  // TLS certificate association, for DANE (RFC 6698)
+	TypeSVCB       uint16 = 64  // Service binding (RFC 9460)
+	TypeHTTPS      uint16 = 65  // Service binding for HTTPS origins
+	TypeCAA        uint16 = 257 // Certification authority authorization (RFC 8659)
+	TypeAXFR       uint16 = 252 // Zone transfer (RFC 5936), a query type only
+	TypeANY        uint16 = 255 // Every RRset of a name, a query type only (RFC 8482)
 )
 
 // DNS classes
 const (
 	ClassIN uint16 = 1 // Internet
+	ClassCH uint16 = 3 // Chaos, for server identity queries (RFC 4892)
 )
 
 // DNS response codes
@@ -32,6 +50,15 @@ const (
 	RcodeRefused        uint8 = 5
 )
 
+// DNS opcodes, kept in the flags under OpcodeMask
+const (
+	OpcodeQuery  uint8 = 0
+	OpcodeNotify uint8 = 4 // Zone change notification (RFC 1996)
+)
+
+// OpcodeMask selects the opcode bits of the flags
+const OpcodeMask uint16 = 0x7800
+
 // DNS flags
 const (
 	FlagQR uint16 = 1 << 15 // Query/Response
@@ -69,12 +96,50 @@ type ResourceRecord struct {
 
 	// Parsed data (depending on type)
 	Address  net.IP   // For A, AAAA
-	Target   string   // For CNAME, NS, MX
+	Target   string   // For CNAME, NS, PTR, MX
 	Priority uint16   // For MX
 	Text     []string // For TXT
 	SOAData  *SOA     // For SOA
+	CAA      *CAA     // For CAA
+	SVCB     *SVCB    // For SVCB, HTTPS
+	TLSA     *TLSA    // For TLSA
+
+	// Metadata (not sent on the wire)
+	Comment     string       // Trailing ";" comment from the zone file
+	HealthCheck *HealthCheck // For A, AAAA: from $HEALTHCHECK (nil for none)
+	Weight      uint16       // For A, AAAA: share of answers it comes first in, from $WEIGHT (0 for none)
+}
+
+// CAA is the data of a CAA record, which names a certification authority
+// allowed to issue certificates for the domain (RFC 8659)
+type CAA struct {
+	Flags uint8  // CAAFlagCritical, or 0
+	Tag   string // Property: "issue", "issuewild" or "iodef"
+	Value string // e.g. the CA's domain for issue, "letsencrypt.org"
+}
+
+// CAAFlagCritical is the issuer critical flag: a CA that doesn't understand
+// the property must not issue
+const CAAFlagCritical uint8 = 128
+
+// TLSA is the data of a TLSA record, which says what certificate a TLS
+// server at the owner name, e.g. _443._tcp.www.example.com, must present
+// (RFC 6698): DNS-based authentication of named entities, or DANE
+type TLSA struct {
+	Usage        uint8  // TLSAUsage*
+	Selector     uint8  // 0 for the full certificate, 1 for its public key
+	MatchingType uint8  // 0 for the data itself, 1 for its SHA-256, 2 for its SHA-512
+	Certificate  []byte // Certificate association data
 }
 
+// TLSA certificate usages (RFC 7218)
+const (
+	TLSAUsagePKIXTA uint8 = 0 // A CA certificate, checked as usual
+	TLSAUsagePKIXEE uint8 = 1 // The server's certificate, checked as usual
+	TLSAUsageDANETA uint8 = 2 // A trust anchor, which needn't be a public CA
+	TLSAUsageDANEEE uint8 = 3 // The server's certificate, no other checks
+)
+
 // SOA represents Start of Authority data
 type SOA struct {
 	MName   string // Primary nameserver
@@ -112,12 +177,40 @@ func TypeToString(t uint16) string {
 		return "TXT"
 	case TypeSOA:
 		return "SOA"
+	case TypePTR:
+		return "PTR"
+	case TypeOPT:
+		return "OPT"
+	case TypeDS:
+		return "DS"
+	case TypeRRSIG:
+		return "RRSIG"
+	case TypeNSEC:
+		return "NSEC"
+	case TypeDNSKEY:
+		return "DNSKEY"
+	case TypeNSEC3:
+		return "NSEC3"
+	case TypeNSEC3PARAM:
+		return "NSEC3PARAM"
+	case TypeTLSA:
+		return "TLSA"
+	case TypeSVCB:
+		return "SVCB"
+	case TypeHTTPS:
+		return "HTTPS"
+	case TypeCAA:
+		return "CAA"
+	case TypeAXFR:
+		return "AXFR"
+	case TypeANY:
+		return "ANY"
 	default:
 		return fmt.Sprintf("TYPE%d", t)
 	}
 }
 
-// StringToType converts string to record type
+// StringToType converts string to record type, 0 if it isn't one
 func StringToType(s string) uint16 {
 	switch s {
 	case "A":
@@ -134,8 +227,56 @@ func StringToType(s string) uint16 {
 		return TypeTXT
 	case "SOA":
 		return TypeSOA
+	case "PTR":
+		return TypePTR
+	case "DS":
+		return TypeDS
+	case "TLSA":
+		return TypeTLSA
+	case "SVCB":
+		return TypeSVCB
+	case "HTTPS":
+		return TypeHTTPS
+	case "CAA":
+		return TypeCAA
+	case "DNSKEY":
+		return TypeDNSKEY
+	case "RRSIG":
+		return TypeRRSIG
+	case "NSEC":
+		return TypeNSEC
+	case "NSEC3":
+		return TypeNSEC3
+	case "NSEC3PARAM":
+		return TypeNSEC3PARAM
+	}
+	// TYPEnnn for any other (RFC 3597 section 5), as TypeToString gives it
+	if n, err := strconv.ParseUint(strings.TrimPrefix(s, "TYPE"), 10, 16); err == nil && strings.HasPrefix(s, "TYPE") {
+		return uint16(n)
+	}
+	return 0
+}
+
+// RcodeToString returns the mnemonic for a response code, extended codes
+// included
+func RcodeToString(rcode uint8) string {
+	switch rcode {
+	case RcodeNoError:
+		return "NOERROR"
+	case RcodeFormatError:
+		return "FORMERR"
+	case RcodeServerFailure:
+		return "SERVFAIL"
+	case RcodeNameError:
+		return "NXDOMAIN"
+	case RcodeNotImplemented:
+		return "NOTIMP"
+	case RcodeRefused:
+		return "REFUSED"
+	case RcodeBadVersion:
+		return "BADVERS"
 	default:
-		return 0
+		return fmt.Sprintf("RCODE%d", rcode)
 	}
 }
 
@@ -206,6 +347,17 @@ func NewNSRecord(name string, ttl uint32, target string) ResourceRecord {
 	}
 }
 
+// NewPTRRecord creates a PTR record
+func NewPTRRecord(name string, ttl uint32, target string) ResourceRecord {
+	return ResourceRecord{
+		Name:   name,
+		Type:   TypePTR,
+		Class:  ClassIN,
+		TTL:    ttl,
+		Target: target,
+	}
+}
+
 // NewSOARecord creates an SOA record
 func NewSOARecord(name string, ttl uint32, soa *SOA) ResourceRecord {
 	return ResourceRecord{
@@ -216,3 +368,25 @@ func NewSOARecord(name string, ttl uint32, soa *SOA) ResourceRecord {
 		SOAData: soa,
 	}
 }
+
+// NewCAARecord creates a CAA record
+func NewCAARecord(name string, ttl uint32, flags uint8, tag, value string) ResourceRecord {
+	return ResourceRecord{
+		Name:  name,
+		Type:  TypeCAA,
+		Class: ClassIN,
+		TTL:   ttl,
+		CAA:   &CAA{Flags: flags, Tag: tag, Value: value},
+	}
+}
+
+// NewTLSARecord creates a TLSA record
+func NewTLSARecord(name string, ttl uint32, usage, selector, matchingType uint8, certificate []byte) ResourceRecord {
+	return ResourceRecord{
+		Name:  name,
+		Type:  TypeTLSA,
+		Class: ClassIN,
+		TTL:   ttl,
+		TLSA:  &TLSA{Usage: usage, Selector: selector, MatchingType: matchingType, Certificate: certificate},
+	}
+}
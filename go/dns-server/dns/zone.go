@@ -2,12 +2,17 @@ package dns
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"net"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"unicode"
 )
 
 // Zone represents a DNS zone
@@ -16,6 +21,10 @@ type Zone struct {
 	Records map[string][]ResourceRecord // Keyed by name+type
 	SOA     *SOA
 	mu      sync.RWMutex
+
+	// regions holds the variants of RRsets served to clients in a region
+	// instead: region -> name+type -> RRset
+	regions map[string]map[string][]ResourceRecord
 }
 
 // NewZone creates a new zone
@@ -26,19 +35,70 @@ func NewZone(name string) *Zone {
 	}
 }
 
-// AddRecord adds a record to the zone
+// AddRecord adds a record to the zone.
+// RRsets are kept in canonical order (RFC 4034 section 6.3), and a record
+// whose RDATA duplicates one already in its RRset is dropped.
 func (z *Zone) AddRecord(rr ResourceRecord) {
 	z.mu.Lock()
 	defer z.mu.Unlock()
 
 	key := z.recordKey(rr.Name, rr.Type)
-	z.Records[key] = append(z.Records[key], rr)
+	updated, added := insertRecord(z.Records[key], rr)
+	if !added {
+		return
+	}
+	z.Records[key] = updated
 
 	if rr.Type == TypeSOA && rr.SOAData != nil {
 		z.SOA = rr.SOAData
 	}
 }
 
+// AddRegionalRecord adds a record to the variant of its RRset served to
+// clients in region, in place of the zone's own (see LookupRegion). The
+// variant is kept in canonical order like any RRset.
+func (z *Zone) AddRegionalRecord(region string, rr ResourceRecord) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if z.regions == nil {
+		z.regions = make(map[string]map[string][]ResourceRecord)
+	}
+	variants := z.regions[region]
+	if variants == nil {
+		variants = make(map[string][]ResourceRecord)
+		z.regions[region] = variants
+	}
+	key := z.recordKey(rr.Name, rr.Type)
+	if updated, added := insertRecord(variants[key], rr); added {
+		variants[key] = updated
+	}
+}
+
+// insertRecord returns rrset with rr inserted in canonical order, or added
+// false if its RDATA duplicates a record already there. rrset itself is
+// not modified, as it may have been returned by Lookup.
+func insertRecord(rrset []ResourceRecord, rr ResourceRecord) (updated []ResourceRecord, added bool) {
+	rdata := CanonicalRData(rr)
+	pos := len(rrset)
+	for i, existing := range rrset {
+		cmp := bytes.Compare(rdata, CanonicalRData(existing))
+		if cmp == 0 {
+			return rrset, false
+		}
+		if cmp < 0 {
+			pos = i
+			break
+		}
+	}
+
+	updated = make([]ResourceRecord, 0, len(rrset)+1)
+	updated = append(updated, rrset[:pos]...)
+	updated = append(updated, rr)
+	updated = append(updated, rrset[pos:]...)
+	return updated, true
+}
+
 // Lookup finds records matching name and type
 func (z *Zone) Lookup(name string, qtype uint16) []ResourceRecord {
 	z.mu.RLock()
@@ -63,6 +123,123 @@ func (z *Zone) Lookup(name string, qtype uint16) []ResourceRecord {
 	return nil
 }
 
+// LookupRegion finds records matching name and type for a client in
+// region: the region's variant of the RRset if it has one (or of a CNAME
+// for an address query), and otherwise what Lookup finds
+func (z *Zone) LookupRegion(name string, qtype uint16, region string) []ResourceRecord {
+	if region != "" {
+		z.mu.RLock()
+		variants := z.regions[region]
+		key := strings.ToLower(name)
+		records, ok := variants[z.recordKey(key, qtype)]
+		if !ok && (qtype == TypeA || qtype == TypeAAAA) {
+			records, ok = variants[z.recordKey(key, TypeCNAME)]
+		}
+		z.mu.RUnlock()
+		if ok {
+			return records
+		}
+	}
+	return z.Lookup(name, qtype)
+}
+
+// NegativeSOA returns the zone's SOA record for the authority section of
+// an NXDOMAIN or NODATA answer, with the TTL resolvers may cache the answer
+// for: the lower of the record's own and its MINIMUM (RFC 2308 section 3).
+// It is nil if the zone has no SOA record.
+func (z *Zone) NegativeSOA() []ResourceRecord {
+	soa := append([]ResourceRecord(nil), z.Lookup(z.Name, TypeSOA)...)
+	for i := range soa {
+		if soa[i].SOAData != nil {
+			soa[i].TTL = min(soa[i].TTL, soa[i].SOAData.Minimum)
+		}
+	}
+	return soa
+}
+
+// LookupAny answers an ANY query for name with one of its RRsets, that of
+// the lowest type, rather than all of them (RFC 8482 section 4.1): the
+// answers are much smaller, and anything that needs a type asks for it
+func (z *Zone) LookupAny(name string) []ResourceRecord {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	prefix := strings.ToLower(name) + ":"
+	var lowest []ResourceRecord
+	for key, rrset := range z.Records {
+		if strings.HasPrefix(key, prefix) && len(rrset) > 0 && (lowest == nil || rrset[0].Type < lowest[0].Type) {
+			lowest = rrset
+		}
+	}
+	return lowest
+}
+
+// maxCNAMEChain is the most CNAME records Chase follows in a row
+const maxCNAMEChain = 8
+
+// Chase follows a CNAME answer to an A or AAAA query through the zone,
+// appending the records of each target (as LookupRegion finds them for
+// region), so the client gets the addresses in one answer. It stops at a
+// target outside the zone, below one of its delegations or with no records,
+// and at loops and chains longer than maxCNAMEChain: the client's resolver
+// follows the rest of such a chain itself.
+func (z *Zone) Chase(answer []ResourceRecord, qtype uint16, region string) []ResourceRecord {
+	if len(answer) == 0 || qtype != TypeA && qtype != TypeAAAA {
+		return answer
+	}
+
+	seen := map[string]bool{strings.ToLower(answer[0].Name): true}
+	for len(seen) <= maxCNAMEChain {
+		last := answer[len(answer)-1]
+		if last.Type != TypeCNAME {
+			break
+		}
+		target := strings.ToLower(last.Target)
+		if seen[target] || !z.IsAuthoritative(target) {
+			break
+		}
+		if cut, _ := z.Delegation(target); cut != "" {
+			break
+		}
+		seen[target] = true
+
+		records := z.LookupRegion(target, qtype, region)
+		if len(records) == 0 {
+			break
+		}
+		// The zone's RRsets are shared, so never appended to in place
+		answer = append(answer[:len(answer):len(answer)], records...)
+	}
+	return answer
+}
+
+// Regions returns the regions the zone has RRset variants for, sorted
+func (z *Zone) Regions() []string {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	regions := make([]string, 0, len(z.regions))
+	for region := range z.regions {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	return regions
+}
+
+// RegionalRecords returns the records of a region's variants, sorted by
+// name and type
+func (z *Zone) RegionalRecords(region string) []ResourceRecord {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	var records []ResourceRecord
+	for _, rrset := range z.regions[region] {
+		records = append(records, rrset...)
+	}
+	sortRecords(records)
+	return records
+}
+
 // HasName checks if zone has any records for name
 func (z *Zone) HasName(name string) bool {
 	z.mu.RLock()
@@ -78,6 +255,71 @@ func (z *Zone) HasName(name string) bool {
 	return false
 }
 
+// AllRecords returns every record in the zone, sorted by name and type.
+func (z *Zone) AllRecords() []ResourceRecord {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	var records []ResourceRecord
+	for _, rrset := range z.Records {
+		records = append(records, rrset...)
+	}
+	sortRecords(records)
+	return records
+}
+
+// sortRecords sorts records by name and type. Sort is stable so each RRset
+// keeps its canonical order.
+func sortRecords(records []ResourceRecord) {
+	sort.SliceStable(records, func(i, j int) bool {
+		ni, nj := strings.ToLower(records[i].Name), strings.ToLower(records[j].Name)
+		if ni != nj {
+			return ni < nj
+		}
+		return records[i].Type < records[j].Type
+	})
+}
+
+// Delegation returns the zone cut at or above name: the name closest to
+// the apex, the apex itself not included, with NS records. Everything at
+// and below the cut belongs to the child zone, except the DS records at the
+// cut and glue. cut is "" if name isn't delegated.
+func (z *Zone) Delegation(name string) (cut string, ns []ResourceRecord) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if !z.IsAuthoritative(name) {
+		return "", nil
+	}
+
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	labels := splitName(name)
+	for i := len(labels) - len(splitName(z.Name)) - 1; i >= 0; i-- {
+		cut := strings.Join(labels[i:], ".")
+		if ns := z.Records[z.recordKey(cut, TypeNS)]; len(ns) > 0 {
+			return cut, ns
+		}
+	}
+	return "", nil
+}
+
+// Glue returns the A and AAAA records of the name servers in ns that are
+// in the zone, which resolvers can't look up anywhere else
+func (z *Zone) Glue(ns []ResourceRecord) []ResourceRecord {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	var glue []ResourceRecord
+	for _, rr := range ns {
+		if !z.IsAuthoritative(rr.Target) {
+			continue
+		}
+		glue = append(glue, z.Records[z.recordKey(rr.Target, TypeA)]...)
+		glue = append(glue, z.Records[z.recordKey(rr.Target, TypeAAAA)]...)
+	}
+	return glue
+}
+
 // IsAuthoritative checks if this zone is authoritative for the name
 func (z *Zone) IsAuthoritative(name string) bool {
 	name = strings.ToLower(name)
@@ -102,16 +344,20 @@ func LoadZoneFile(filename string) (*Zone, error) {
 	var origin string
 	var defaultTTL uint32 = 3600
 	var currentName string
+	var region string            // Of the records since a $REGION directive
+	var healthCheck *HealthCheck // Of the addresses since a $HEALTHCHECK directive
+	var weight uint16            // Of the addresses since a $WEIGHT directive
 
 	scanner := bufio.NewScanner(file)
 	lineNum := 0
 
 	for scanner.Scan() {
 		lineNum++
-		line := strings.TrimSpace(scanner.Text())
+		line, comment := splitComment(scanner.Text())
+		line = strings.TrimSpace(line)
 
 		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, ";") {
+		if line == "" {
 			continue
 		}
 
@@ -135,18 +381,58 @@ func LoadZoneFile(filename string) (*Zone, error) {
 			continue
 		}
 
-		// Skip multi-line SOA for now (simplified parser)
-		if strings.Contains(line, "(") {
-			// Read until closing paren
-			for scanner.Scan() {
-				lineNum++
-				if strings.Contains(scanner.Text(), ")") {
-					break
+		// Records after "$REGION eu" are eu's variants, until a "$REGION"
+		// with no region
+		if strings.HasPrefix(line, "$REGION") {
+			region = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(line, "$REGION")))
+			continue
+		}
+
+		// The A and AAAA records after "$HEALTHCHECK tcp 443" are left out
+		// of answers while their address fails the check, until a
+		// "$HEALTHCHECK" with no check
+		if strings.HasPrefix(line, "$HEALTHCHECK") {
+			healthCheck = nil
+			if spec := strings.TrimSpace(strings.TrimPrefix(line, "$HEALTHCHECK")); spec != "" {
+				if healthCheck, err = ParseHealthCheck(spec); err != nil {
+					return nil, fmt.Errorf("line %d: %w", lineNum, err)
+				}
+			}
+			continue
+		}
+
+		// The A and AAAA records after "$WEIGHT 3" come first in answers
+		// three times as often as those of weight 1, until a "$WEIGHT"
+		// with no weight
+		if strings.HasPrefix(line, "$WEIGHT") {
+			weight = 0
+			if w := strings.TrimSpace(strings.TrimPrefix(line, "$WEIGHT")); w != "" {
+				if weight, err = ParseWeight(w); err != nil {
+					return nil, fmt.Errorf("line %d: %w", lineNum, err)
 				}
 			}
 			continue
 		}
 
+		// A record in parentheses goes on to the line that closes them,
+		// e.g. an SOA with its fields on lines of their own. The comment
+		// kept is the first line's.
+		start := lineNum
+		depth := parenDepth(line)
+		for depth > 0 && scanner.Scan() {
+			lineNum++
+			next, _ := splitComment(scanner.Text())
+			line += " " + strings.TrimSpace(next)
+			depth += parenDepth(next)
+		}
+		if depth > 0 {
+			return nil, fmt.Errorf("line %d: unclosed parenthesis", start)
+		}
+		if depth < 0 {
+			return nil, fmt.Errorf("line %d: unopened parenthesis", start)
+		}
+		line = stripParens(line)
+
 		// Parse record
 		rr, name, err := parseZoneLine(line, origin, currentName, defaultTTL)
 		if err != nil {
@@ -157,11 +443,23 @@ func LoadZoneFile(filename string) (*Zone, error) {
 		if name != "" {
 			currentName = name
 		}
+		rr.Comment = comment
+		if rr.Type == TypeA || rr.Type == TypeAAAA {
+			rr.HealthCheck = healthCheck
+			rr.Weight = weight
+		}
 
 		if zone == nil {
 			zone = NewZone(origin)
 		}
 
+		if region != "" {
+			if rr.Type == TypeSOA {
+				return nil, fmt.Errorf("line %d: SOA record in $REGION %s", lineNum, region)
+			}
+			zone.AddRegionalRecord(region, rr)
+			continue
+		}
 		zone.AddRecord(rr)
 	}
 
@@ -172,6 +470,34 @@ func LoadZoneFile(filename string) (*Zone, error) {
 	return zone, nil
 }
 
+// ParseWeight parses the weight of an address record, 1 to 65535
+func ParseWeight(s string) (uint16, error) {
+	w, err := strconv.ParseUint(s, 10, 16)
+	if err != nil || w == 0 {
+		return 0, fmt.Errorf("weight %q is not a number from 1 to 65535", s)
+	}
+	return uint16(w), nil
+}
+
+// ParseRecord parses one record in zone file syntax, e.g.
+// "www 300 IN A 192.0.2.1". Relative names are completed with origin, and
+// the record gets defaultTTL if it doesn't give one.
+func ParseRecord(line, origin string, defaultTTL uint32) (ResourceRecord, error) {
+	line, comment := splitComment(line)
+	if parenDepth(line) != 0 {
+		return ResourceRecord{}, fmt.Errorf("unbalanced parentheses")
+	}
+	rr, _, err := parseZoneLine(stripParens(strings.TrimSpace(line)), strings.TrimSuffix(origin, "."), origin, defaultTTL)
+	if err != nil {
+		return rr, err
+	}
+	if rr.Type == TypeSOA && rr.SOAData == nil {
+		return rr, fmt.Errorf("SOA needs mname, rname, serial, refresh, retry, expire and minimum")
+	}
+	rr.Comment = comment
+	return rr, nil
+}
+
 func parseZoneLine(line, origin, currentName string, defaultTTL uint32) (ResourceRecord, string, error) {
 	fields := strings.Fields(line)
 	if len(fields) < 3 {
@@ -233,6 +559,15 @@ func parseZoneLine(line, origin, currentName string, defaultTTL uint32) (Resourc
 
 	rdata := strings.Join(fields[idx:], " ")
 
+	// Any type's data may be given in the generic form, and those without
+	// a presentation format here only in it
+	if fields[idx] == `\#` {
+		return rr, name, parseGenericRData(&rr, fields[idx+1:])
+	}
+	if !presentationTypes[rr.Type] {
+		return rr, name, fmt.Errorf(`%s data must be given as \# length hex`, TypeToString(rr.Type))
+	}
+
 	switch rr.Type {
 	case TypeA:
 		ip := net.ParseIP(rdata)
@@ -248,7 +583,7 @@ func parseZoneLine(line, origin, currentName string, defaultTTL uint32) (Resourc
 		}
 		rr.Address = ip.To16()
 
-	case TypeCNAME, TypeNS:
+	case TypeCNAME, TypeNS, TypePTR:
 		target := fields[idx]
 		if target == "@" {
 			target = origin
@@ -278,9 +613,88 @@ func parseZoneLine(line, origin, currentName string, defaultTTL uint32) (Resourc
 		rr.Target = target
 
 	case TypeTXT:
-		// Handle quoted strings
-		text := strings.Trim(rdata, "\"")
-		rr.Text = []string{text}
+		texts, err := parseCharacterStrings(afterFields(line, idx))
+		if err != nil {
+			return rr, name, fmt.Errorf("invalid TXT: %v", err)
+		}
+		rr.Text = texts
+
+	case TypeCAA:
+		if idx+2 >= len(fields) {
+			return rr, name, fmt.Errorf("CAA needs flags, tag and value")
+		}
+		flags, err := strconv.ParseUint(fields[idx], 10, 8)
+		if err != nil {
+			return rr, name, fmt.Errorf("invalid CAA flags: %v", err)
+		}
+		tag := fields[idx+1]
+		if !validCAATag(tag) {
+			return rr, name, fmt.Errorf("invalid CAA tag: %s", tag)
+		}
+		// The value may hold spaces, as in "ca.example; account=1"
+		values, err := parseCharacterStrings(afterFields(line, idx+2))
+		if err != nil {
+			return rr, name, fmt.Errorf("invalid CAA value: %v", err)
+		}
+		rr.CAA = &CAA{Flags: uint8(flags), Tag: tag, Value: strings.Join(values, " ")}
+
+	case TypeDS:
+		if idx+3 >= len(fields) {
+			return rr, name, fmt.Errorf("DS needs key tag, algorithm, digest type and digest")
+		}
+		tag, err := strconv.ParseUint(fields[idx], 10, 16)
+		if err != nil {
+			return rr, name, fmt.Errorf("invalid DS key tag: %v", err)
+		}
+		var params [2]uint8
+		for i, what := range []string{"algorithm", "digest type"} {
+			n, err := strconv.ParseUint(fields[idx+1+i], 10, 8)
+			if err != nil {
+				return rr, name, fmt.Errorf("invalid DS %s: %v", what, err)
+			}
+			params[i] = uint8(n)
+		}
+		// The hex digest may be split into several fields
+		digest, err := hex.DecodeString(strings.Join(fields[idx+3:], ""))
+		if err != nil || len(digest) == 0 {
+			return rr, name, fmt.Errorf("invalid DS digest")
+		}
+		if size, ok := dsDigestSizes[params[1]]; ok && len(digest) != size {
+			return rr, name, fmt.Errorf("DS digest is %d bytes, want %d for digest type %d", len(digest), size, params[1])
+		}
+		rr.RData = binary.BigEndian.AppendUint16(nil, uint16(tag))
+		rr.RData = append(rr.RData, params[0], params[1])
+		rr.RData = append(rr.RData, digest...)
+		rr.RDLength = uint16(len(rr.RData))
+
+	case TypeTLSA:
+		if idx+3 >= len(fields) {
+			return rr, name, fmt.Errorf("TLSA needs usage, selector, matching type and data")
+		}
+		var params [3]uint8
+		for i, what := range []string{"usage", "selector", "matching type"} {
+			n, err := strconv.ParseUint(fields[idx+i], 10, 8)
+			if err != nil {
+				return rr, name, fmt.Errorf("invalid TLSA %s: %v", what, err)
+			}
+			params[i] = uint8(n)
+		}
+		// The hex data may be split into several fields
+		data, err := hex.DecodeString(strings.Join(fields[idx+3:], ""))
+		if err != nil {
+			return rr, name, fmt.Errorf("invalid TLSA data: %v", err)
+		}
+		if size, ok := tlsaDigestSizes[params[2]]; ok && len(data) != size {
+			return rr, name, fmt.Errorf("TLSA data is %d bytes, want %d for matching type %d", len(data), size, params[2])
+		}
+		rr.TLSA = &TLSA{Usage: params[0], Selector: params[1], MatchingType: params[2], Certificate: data}
+
+	case TypeSVCB, TypeHTTPS:
+		svcb, err := parseSVCBFields(fields[idx:], origin)
+		if err != nil {
+			return rr, name, err
+		}
+		rr.SVCB = svcb
 
 	case TypeSOA:
 		// Simplified SOA handling
@@ -300,6 +714,218 @@ func parseZoneLine(line, origin, currentName string, defaultTTL uint32) (Resourc
 	return rr, name, nil
 }
 
+// presentationTypes are the types whose data zone files give in their own
+// presentation format; others only in the generic form of RFC 3597
+var presentationTypes = map[uint16]bool{
+	TypeA: true, TypeAAAA: true, TypeCNAME: true, TypeNS: true, TypePTR: true,
+	TypeMX: true, TypeTXT: true, TypeSOA: true, TypeCAA: true, TypeDS: true,
+	TypeTLSA: true, TypeSVCB: true, TypeHTTPS: true,
+}
+
+// parseGenericRData parses record data in the generic form of RFC 3597
+// section 5, the fields after "\#": the data's length, then the data in hex,
+// possibly split into several fields. The record is filled in as if it had
+// come off the wire, so data of a known type gets its fields too.
+func parseGenericRData(rr *ResourceRecord, fields []string) error {
+	if len(fields) == 0 {
+		return fmt.Errorf(`\# needs the data's length`)
+	}
+	length, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return fmt.Errorf(`invalid \# length: %v`, err)
+	}
+	data, err := hex.DecodeString(strings.Join(fields[1:], ""))
+	if err != nil {
+		return fmt.Errorf(`invalid \# data: %v`, err)
+	}
+	if len(data) != int(length) {
+		return fmt.Errorf(`\# data is %d bytes, want %d`, len(data), length)
+	}
+
+	b := NewBuilder()
+	wire := b.encodeName(rr.Name)
+	wire = binary.BigEndian.AppendUint16(wire, rr.Type)
+	wire = binary.BigEndian.AppendUint16(wire, rr.Class)
+	wire = binary.BigEndian.AppendUint32(wire, rr.TTL)
+	wire = binary.BigEndian.AppendUint16(wire, uint16(len(data)))
+	wire = append(wire, data...)
+
+	var parsed ResourceRecord
+	if err := NewParser(wire).parseResourceRecord(&parsed); err != nil {
+		return fmt.Errorf(`invalid \# data: %v`, err)
+	}
+	// Data a type's fields can't hold, such as an A record of 3 bytes
+	if !bytes.Equal(b.buildRData(&parsed), data) {
+		return fmt.Errorf(`invalid \# data for %s`, TypeToString(rr.Type))
+	}
+	parsed.Name = rr.Name
+	*rr = parsed
+	return nil
+}
+
+// afterFields returns what follows the first n whitespace separated fields
+// of line, as it is, for data whose spacing matters
+func afterFields(line string, n int) string {
+	for i := 0; i < n; i++ {
+		line = strings.TrimLeftFunc(line, unicode.IsSpace)
+		if end := strings.IndexFunc(line, unicode.IsSpace); end >= 0 {
+			line = line[end:]
+		} else {
+			line = ""
+		}
+	}
+	return strings.TrimSpace(line)
+}
+
+// parseCharacterStrings parses a list of character-strings (RFC 1035
+// section 5.1): words, or quoted strings that may hold spaces, with "\X"
+// escaping the character X and "\DDD" standing for the byte of decimal
+// value DDD
+func parseCharacterStrings(s string) ([]string, error) {
+	var texts []string
+	for s = strings.TrimLeftFunc(s, unicode.IsSpace); s != ""; s = strings.TrimLeftFunc(s, unicode.IsSpace) {
+		quoted := s[0] == '"'
+		i := 0
+		if quoted {
+			i = 1
+		}
+
+		var text []byte
+		closed := false
+		for ; i < len(s); i++ {
+			c := s[i]
+			if quoted && c == '"' {
+				i++
+				closed = true
+				break
+			}
+			if !quoted && unicode.IsSpace(rune(c)) {
+				break
+			}
+			if c == '\\' {
+				if i+3 < len(s) && isDigits(s[i+1:i+4]) {
+					n, _ := strconv.Atoi(s[i+1 : i+4])
+					if n > 255 {
+						return nil, fmt.Errorf("escape \\%s out of range", s[i+1:i+4])
+					}
+					text = append(text, byte(n))
+					i += 3
+					continue
+				}
+				if i+1 == len(s) {
+					return nil, fmt.Errorf("trailing backslash")
+				}
+				i++
+				c = s[i]
+			}
+			text = append(text, c)
+		}
+		if quoted && !closed {
+			return nil, fmt.Errorf("unterminated quoted string")
+		}
+		texts = append(texts, string(text))
+		s = s[i:]
+	}
+	if len(texts) == 0 {
+		return nil, fmt.Errorf("no strings")
+	}
+	return texts, nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// splitComment separates a zone file line from its trailing ";" comment.
+// Semicolons inside quoted strings (e.g. TXT data) are not comments.
+func splitComment(line string) (string, string) {
+	inQuotes := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++ // Skip the escaped character
+		case '"':
+			inQuotes = !inQuotes
+		case ';':
+			if !inQuotes {
+				return line[:i], strings.TrimSpace(line[i+1:])
+			}
+		}
+	}
+	return line, ""
+}
+
+// parenDepth returns how many more parentheses a line, without its comment,
+// opens than it closes; those in quotes don't count
+func parenDepth(line string) int {
+	depth := 0
+	inQuotes := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '"':
+			inQuotes = !inQuotes
+		case '(':
+			if !inQuotes {
+				depth++
+			}
+		case ')':
+			if !inQuotes {
+				depth--
+			}
+		}
+	}
+	return depth
+}
+
+// stripParens replaces the parentheses of a record that spanned lines with
+// spaces, leaving those in quotes
+func stripParens(line string) string {
+	b := []byte(line)
+	inQuotes := false
+	for i := 0; i < len(b); i++ {
+		switch b[i] {
+		case '\\':
+			i++
+		case '"':
+			inQuotes = !inQuotes
+		case '(', ')':
+			if !inQuotes {
+				b[i] = ' '
+			}
+		}
+	}
+	return string(b)
+}
+
+// dsDigestSizes is the size of DS digests for each digest type: SHA-1,
+// SHA-256 and SHA-384 (RFC 4034, RFC 4509, RFC 6605)
+var dsDigestSizes = map[uint8]int{1: 20, 2: 32, 4: 48}
+
+// tlsaDigestSizes is the size of TLSA data for each matching type that is
+// a digest: SHA-256 and SHA-512
+var tlsaDigestSizes = map[uint8]int{1: 32, 2: 64}
+
+// validCAATag reports whether tag is a valid CAA property tag: 1 to 15
+// letters and digits (RFC 8659 section 4.1)
+func validCAATag(tag string) bool {
+	if len(tag) == 0 || len(tag) > 15 {
+		return false
+	}
+	for _, c := range tag {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
 func normalizeSOAName(name, origin string) string {
 	if name == "@" {
 		return origin
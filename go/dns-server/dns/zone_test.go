@@ -1,7 +1,10 @@
 package dns
 
 import (
+	"fmt"
+	"net"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -51,6 +54,98 @@ func TestZoneLookupCNAME(t *testing.T) {
 	}
 }
 
+func TestZoneChase(t *testing.T) {
+	zone := NewZone("example.com")
+	zone.AddRecord(NewCNAMERecord("www.example.com", 3600, "web.example.com"))
+	zone.AddRecord(NewCNAMERecord("web.example.com", 3600, "origin.example.com"))
+	zone.AddRecord(NewARecord("origin.example.com", 3600, net.ParseIP("192.0.2.1")))
+	zone.AddRecord(NewARecord("origin.example.com", 3600, net.ParseIP("192.0.2.2")))
+	zone.AddRegionalRecord("eu", NewARecord("origin.example.com", 3600, net.ParseIP("198.51.100.1")))
+	zone.AddRecord(NewCNAMERecord("cdn.example.com", 3600, "cdn.example.net"))
+	zone.AddRecord(NewCNAMERecord("loop1.example.com", 3600, "loop2.example.com"))
+	zone.AddRecord(NewCNAMERecord("loop2.example.com", 3600, "loop1.example.com"))
+	zone.AddRecord(NewNSRecord("child.example.com", 3600, "ns.child.example.com"))
+	zone.AddRecord(NewCNAMERecord("away.example.com", 3600, "www.child.example.com"))
+	zone.AddRecord(NewARecord("www.child.example.com", 3600, net.ParseIP("192.0.2.9")))
+	for i := 0; i < 20; i++ {
+		zone.AddRecord(NewCNAMERecord(fmt.Sprintf("long%d.example.com", i), 3600, fmt.Sprintf("long%d.example.com", i+1)))
+	}
+
+	chase := func(name string, qtype uint16, region string) []string {
+		var got []string
+		for _, rr := range zone.Chase(zone.LookupRegion(name, qtype, region), qtype, region) {
+			got = append(got, rr.Name+" "+TypeToString(rr.Type)+" "+rr.RDataString())
+		}
+		return got
+	}
+	tests := []struct {
+		name   string
+		qtype  uint16
+		region string
+		want   int // Records, the last of them the final target's
+		last   string
+	}{
+		{"www.example.com", TypeA, "", 4, "origin.example.com A 192.0.2.2"},
+		{"www.example.com", TypeA, "eu", 3, "origin.example.com A 198.51.100.1"},
+		{"www.example.com", TypeAAAA, "", 2, "web.example.com CNAME origin.example.com."},
+		{"www.example.com", TypeCNAME, "", 1, "www.example.com CNAME web.example.com."},
+		{"cdn.example.com", TypeA, "", 1, "cdn.example.com CNAME cdn.example.net."},
+		{"loop1.example.com", TypeA, "", 2, "loop2.example.com CNAME loop1.example.com."},
+		{"away.example.com", TypeA, "", 1, "away.example.com CNAME www.child.example.com."},
+		{"long0.example.com", TypeA, "", 1 + maxCNAMEChain, "long8.example.com CNAME long9.example.com."},
+	}
+	for _, tt := range tests {
+		got := chase(tt.name, tt.qtype, tt.region)
+		if len(got) != tt.want || got[len(got)-1] != tt.last {
+			t.Errorf("chasing %s %s in %q = %q, want %d records ending with %q", tt.name, TypeToString(tt.qtype), tt.region, got, tt.want, tt.last)
+		}
+	}
+
+}
+
+func TestZoneNegativeSOA(t *testing.T) {
+	zone := NewZone("example.com")
+	if soa := zone.NegativeSOA(); soa != nil {
+		t.Errorf("NegativeSOA() without an SOA record = %v, want nil", soa)
+	}
+
+	record := ResourceRecord{Name: "example.com", Type: TypeSOA, Class: ClassIN, TTL: 3600,
+		SOAData: &SOA{MName: "ns1.example.com", RName: "hostmaster.example.com", Serial: 1, Minimum: 300}}
+	zone.AddRecord(record)
+	if soa := zone.NegativeSOA(); len(soa) != 1 || soa[0].TTL != 300 {
+		t.Errorf("NegativeSOA() = %v, want the SOA record with TTL 300 (MINIMUM)", soa)
+	}
+	if soa := zone.Lookup("example.com", TypeSOA); soa[0].TTL != 3600 {
+		t.Errorf("SOA record's TTL is now %d, want 3600 still", soa[0].TTL)
+	}
+
+	// The record's own TTL, if lower, is the limit
+	record.TTL = 60
+	zone = NewZone("example.com")
+	zone.AddRecord(record)
+	if soa := zone.NegativeSOA(); len(soa) != 1 || soa[0].TTL != 60 {
+		t.Errorf("NegativeSOA() = %v, want the SOA record with TTL 60 (its own)", soa)
+	}
+}
+
+func TestZoneLookupAny(t *testing.T) {
+	zone := NewZone("example.com")
+	zone.AddRecord(NewMXRecord("example.com", 3600, 10, "mail.example.com"))
+	zone.AddRecord(NewNSRecord("example.com", 3600, "ns1.example.com"))
+	zone.AddRecord(NewNSRecord("example.com", 3600, "ns2.example.com"))
+	zone.AddRecord(NewCNAMERecord("www.example.com", 3600, "example.com"))
+
+	if got := zone.LookupAny("Example.com"); len(got) != 2 || got[0].Type != TypeNS {
+		t.Errorf("LookupAny(example.com) = %v, want the NS RRset alone", got)
+	}
+	if got := zone.LookupAny("www.example.com"); len(got) != 1 || got[0].Type != TypeCNAME {
+		t.Errorf("LookupAny(www.example.com) = %v, want the CNAME", got)
+	}
+	if got := zone.LookupAny("nothing.example.com"); got != nil {
+		t.Errorf("LookupAny(nothing.example.com) = %v, want nil", got)
+	}
+}
+
 func TestZoneHasName(t *testing.T) {
 	zone := NewZone("example.com")
 
@@ -195,3 +290,633 @@ func TestIsAuthoritative(t *testing.T) {
 		}
 	}
 }
+
+func TestZoneDeduplicatesRRset(t *testing.T) {
+	zone := NewZone("example.com")
+
+	zone.AddRecord(NewARecord("www.example.com", 3600, []byte{192, 0, 2, 1}))
+	zone.AddRecord(NewARecord("www.example.com", 3600, []byte{192, 0, 2, 1}))
+	zone.AddRecord(NewMXRecord("example.com", 3600, 10, "mail.example.com"))
+	zone.AddRecord(NewMXRecord("example.com", 3600, 10, "MAIL.Example.com"))
+
+	if a := zone.Lookup("www.example.com", TypeA); len(a) != 1 {
+		t.Errorf("A records = %d, want 1", len(a))
+	}
+
+	// Names in RDATA compare case-insensitively
+	if mx := zone.Lookup("example.com", TypeMX); len(mx) != 1 {
+		t.Errorf("MX records = %d, want 1", len(mx))
+	}
+}
+
+func TestZoneCanonicalOrder(t *testing.T) {
+	zone := NewZone("example.com")
+
+	zone.AddRecord(NewARecord("example.com", 3600, []byte{192, 0, 2, 30}))
+	zone.AddRecord(NewARecord("example.com", 3600, []byte{192, 0, 2, 4}))
+	zone.AddRecord(NewARecord("example.com", 3600, []byte{10, 0, 0, 1}))
+
+	records := zone.Lookup("example.com", TypeA)
+	want := []string{"10.0.0.1", "192.0.2.4", "192.0.2.30"}
+	if len(records) != len(want) {
+		t.Fatalf("A records = %d, want %d", len(records), len(want))
+	}
+	for i, rr := range records {
+		if rr.Address.String() != want[i] {
+			t.Errorf("record %d = %s, want %s", i, rr.Address, want[i])
+		}
+	}
+
+	zone.AddRecord(NewMXRecord("example.com", 3600, 20, "b.example.com"))
+	zone.AddRecord(NewMXRecord("example.com", 3600, 10, "z.example.com"))
+	zone.AddRecord(NewMXRecord("example.com", 3600, 20, "a.example.com"))
+
+	mx := zone.Lookup("example.com", TypeMX)
+	wantMX := []string{"z.example.com", "a.example.com", "b.example.com"}
+	for i, rr := range mx {
+		if rr.Target != wantMX[i] {
+			t.Errorf("MX %d = %s, want %s", i, rr.Target, wantMX[i])
+		}
+	}
+}
+
+func TestLoadZoneFileDeduplicates(t *testing.T) {
+	content := `$ORIGIN test.com.
+$TTL 3600
+
+www     IN  A   192.0.2.2
+www     IN  A   192.0.2.1
+www     IN  A   192.0.2.2
+`
+	tmpfile, err := os.CreateTemp("", "zone-*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	zone, err := LoadZoneFile(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("LoadZoneFile error: %v", err)
+	}
+
+	www := zone.Lookup("www.test.com", TypeA)
+	if len(www) != 2 {
+		t.Fatalf("A records for www = %d, want 2", len(www))
+	}
+	if www[0].Address.String() != "192.0.2.1" {
+		t.Errorf("first A record = %s, want 192.0.2.1", www[0].Address)
+	}
+}
+
+func TestSplitComment(t *testing.T) {
+	tests := []struct {
+		line    string
+		content string
+		comment string
+	}{
+		{"www IN A 192.0.2.1", "www IN A 192.0.2.1", ""},
+		{"www IN A 192.0.2.1 ; owned by team-x", "www IN A 192.0.2.1 ", "owned by team-x"},
+		{"; whole line", "", "whole line"},
+		{`@ IN TXT "v=DMARC1; p=reject"`, `@ IN TXT "v=DMARC1; p=reject"`, ""},
+		{`@ IN TXT "a \" ; b" ; note`, `@ IN TXT "a \" ; b" `, "note"},
+	}
+
+	for _, tt := range tests {
+		content, comment := splitComment(tt.line)
+		if content != tt.content || comment != tt.comment {
+			t.Errorf("splitComment(%q) = %q, %q; want %q, %q", tt.line, content, comment, tt.content, tt.comment)
+		}
+	}
+}
+
+func TestLoadZoneFileComments(t *testing.T) {
+	content := `$ORIGIN test.com.
+$TTL 3600 ; one hour
+
+www     IN  A   192.0.2.2   ; owned by team-x
+api     IN  A   192.0.2.3
+_dmarc  IN  TXT "v=DMARC1; p=reject" ; policy
+`
+	tmpfile, err := os.CreateTemp("", "zone-*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	zone, err := LoadZoneFile(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("LoadZoneFile error: %v", err)
+	}
+
+	www := zone.Lookup("www.test.com", TypeA)
+	if len(www) != 1 {
+		t.Fatalf("A records for www = %d, want 1", len(www))
+	}
+	if www[0].Comment != "owned by team-x" {
+		t.Errorf("www comment = %q, want %q", www[0].Comment, "owned by team-x")
+	}
+
+	if api := zone.Lookup("api.test.com", TypeA); len(api) != 1 || api[0].Comment != "" {
+		t.Errorf("api records = %+v, want one record without comment", api)
+	}
+
+	txt := zone.Lookup("_dmarc.test.com", TypeTXT)
+	if len(txt) != 1 {
+		t.Fatalf("TXT records for _dmarc = %d, want 1", len(txt))
+	}
+	if txt[0].Text[0] != "v=DMARC1; p=reject" {
+		t.Errorf("TXT = %q, want %q", txt[0].Text[0], "v=DMARC1; p=reject")
+	}
+	if txt[0].Comment != "policy" {
+		t.Errorf("TXT comment = %q, want %q", txt[0].Comment, "policy")
+	}
+}
+
+func TestLoadZoneFileCAA(t *testing.T) {
+	content := `$ORIGIN test.com.
+$TTL 3600
+
+@       IN  CAA 0 issue "letsencrypt.org"
+@       IN  CAA 0 issuewild ";"
+@       IN  CAA 128 iodef "mailto:security@test.com"
+www     IN  CAA 0 issue "ca.example.net; account=230123"
+`
+	tmpfile, err := os.CreateTemp("", "zone-*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	zone, err := LoadZoneFile(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("LoadZoneFile error: %v", err)
+	}
+
+	if caa := zone.Lookup("test.com", TypeCAA); len(caa) != 3 {
+		t.Errorf("CAA records for test.com = %d, want 3", len(caa))
+	}
+	www := zone.Lookup("www.test.com", TypeCAA)
+	if len(www) != 1 {
+		t.Fatalf("CAA records for www = %d, want 1", len(www))
+	}
+	if got, want := www[0].RDataString(), `0 issue "ca.example.net; account=230123"`; got != want {
+		t.Errorf("CAA = %s, want %s", got, want)
+	}
+
+	for _, line := range []string{
+		`@ IN CAA 0 issue`,
+		`@ IN CAA 256 issue "ca.example.net"`,
+		`@ IN CAA 0 is-sue "ca.example.net"`,
+	} {
+		if _, _, err := parseZoneLine(line, "test.com", "test.com", 3600); err == nil {
+			t.Errorf("parseZoneLine(%q) succeeded, want error", line)
+		}
+	}
+}
+
+func TestLoadZoneFileTLSA(t *testing.T) {
+	content := `$ORIGIN test.com.
+$TTL 3600
+
+_443._tcp.www  IN  TLSA 3 1 1 0C72AC70B745AC19998811B131D662C9AC69DBDBE7CB23E5B514B566 64C5D3D6
+_25._tcp.mail  IN  TLSA 2 0 0 308201
+`
+	tmpfile, err := os.CreateTemp("", "zone-*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	zone, err := LoadZoneFile(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("LoadZoneFile error: %v", err)
+	}
+
+	www := zone.Lookup("_443._tcp.www.test.com", TypeTLSA)
+	if len(www) != 1 {
+		t.Fatalf("TLSA records for www = %d, want 1", len(www))
+	}
+	if got, want := www[0].RDataString(), "3 1 1 0C72AC70B745AC19998811B131D662C9AC69DBDBE7CB23E5B514B56664C5D3D6"; got != want {
+		t.Errorf("TLSA = %s, want %s", got, want)
+	}
+	if mail := zone.Lookup("_25._tcp.mail.test.com", TypeTLSA); len(mail) != 1 {
+		t.Errorf("TLSA records for mail = %d, want 1", len(mail))
+	}
+
+	for _, line := range []string{
+		`_443._tcp IN TLSA 3 1 1`,
+		`_443._tcp IN TLSA 256 1 1 AB`,
+		`_443._tcp IN TLSA 3 1 0 XYZ`,
+		`_443._tcp IN TLSA 3 1 1 ABCD`,
+	} {
+		if _, _, err := parseZoneLine(line, "test.com", "test.com", 3600); err == nil {
+			t.Errorf("parseZoneLine(%q) succeeded, want error", line)
+		}
+	}
+}
+
+func TestZoneDelegation(t *testing.T) {
+	content := `$ORIGIN test.com.
+$TTL 3600
+
+@              IN  NS   ns1.test.com.
+ns1            IN  A    192.0.2.1
+child          IN  NS   ns1.child.test.com.
+child          IN  NS   ns.example.net.
+child          IN  DS   60485 13 2 D4B7D520E7BB5F0F67674A0CCEB1E3E0614B93C4F9E99B8383F6A1E4 469DA50A
+ns1.child      IN  A    192.0.2.10
+ns1.child      IN  AAAA 2001:db8::10
+`
+	tmpfile, err := os.CreateTemp("", "zone-*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	zone, err := LoadZoneFile(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("LoadZoneFile error: %v", err)
+	}
+
+	ds := zone.Lookup("child.test.com", TypeDS)
+	if len(ds) != 1 {
+		t.Fatalf("DS records = %d, want 1", len(ds))
+	}
+	if got, want := ds[0].RDataString(), "60485 13 2 D4B7D520E7BB5F0F67674A0CCEB1E3E0614B93C4F9E99B8383F6A1E4469DA50A"; got != want {
+		t.Errorf("DS = %s, want %s", got, want)
+	}
+
+	for _, tt := range []struct {
+		name string
+		cut  string
+	}{
+		{"test.com", ""},
+		{"ns1.test.com", ""},
+		{"child.test.com", "child.test.com"},
+		{"WWW.Child.test.com.", "child.test.com"},
+		{"a.b.child.test.com", "child.test.com"},
+		{"other.org", ""},
+	} {
+		cut, ns := zone.Delegation(tt.name)
+		if cut != tt.cut {
+			t.Errorf("Delegation(%q) = %q, want %q", tt.name, cut, tt.cut)
+		}
+		if cut != "" && len(ns) != 2 {
+			t.Errorf("Delegation(%q) returned %d NS records, want 2", tt.name, len(ns))
+		}
+	}
+
+	// Only the name server inside the zone needs glue
+	_, ns := zone.Delegation("child.test.com")
+	if glue := zone.Glue(ns); len(glue) != 2 {
+		t.Errorf("Glue = %v, want the A and AAAA records of ns1.child", glue)
+	}
+
+	for _, line := range []string{
+		`child IN DS 60485 13 2`,
+		`child IN DS 70000 13 2 D4B7`,
+		`child IN DS 60485 13 2 D4B7`,
+		`child IN DS 60485 13 2 XYZ`,
+	} {
+		if _, _, err := parseZoneLine(line, "test.com", "test.com", 3600); err == nil {
+			t.Errorf("parseZoneLine(%q) succeeded, want error", line)
+		}
+	}
+}
+
+func TestParseRecord(t *testing.T) {
+	tests := []struct {
+		line string
+		name string
+		ttl  uint32
+		data string
+	}{
+		{"www 300 IN A 192.0.2.1", "www.example.com", 300, "192.0.2.1"},
+		{"@ MX 10 mail", "example.com", 3600, "10 mail.example.com."},
+		{"host.example.net. IN AAAA 2001:db8::1 ; moved", "host.example.net", 3600, "2001:db8::1"},
+		{`info IN TXT ( "a (b" )`, "info.example.com", 3600, `"a (b"`},
+	}
+	for _, tt := range tests {
+		rr, err := ParseRecord(tt.line, "example.com.", 3600)
+		if err != nil {
+			t.Errorf("ParseRecord(%q) error: %v", tt.line, err)
+			continue
+		}
+		if rr.Name != tt.name || rr.TTL != tt.ttl || rr.RDataString() != tt.data {
+			t.Errorf("ParseRecord(%q) = %s %d %s, want %s %d %s", tt.line, rr.Name, rr.TTL, rr.RDataString(), tt.name, tt.ttl, tt.data)
+		}
+	}
+
+	for _, line := range []string{
+		"www IN A 192.0.2.300",
+		"www IN BOGUS data",
+		"www IN A",
+		"@ IN SOA ns1 hostmaster",
+		"@ IN SOA ( ns1 hostmaster 1 2 3 4 5",
+	} {
+		if _, err := ParseRecord(line, "example.com", 3600); err == nil {
+			t.Errorf("ParseRecord(%q) succeeded, want an error", line)
+		}
+	}
+}
+
+func TestLoadZoneFileRegions(t *testing.T) {
+	content := `$ORIGIN test.com.
+$TTL 3600
+@    IN SOA ns1.test.com. hostmaster.test.com. 1 3600 600 86400 300
+www  IN A     192.0.2.1
+www  IN A     192.0.2.2
+api  IN A     192.0.2.3
+$REGION EU
+www  IN A     198.51.100.1
+cdn  IN CNAME eu.cdn.example.net.
+$REGION
+mail IN A     192.0.2.4
+`
+	tmpfile, err := os.CreateTemp("", "zone-*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	zone, err := LoadZoneFile(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("LoadZoneFile error: %v", err)
+	}
+
+	if regions := zone.Regions(); len(regions) != 1 || regions[0] != "eu" {
+		t.Fatalf("Regions() = %v, want [eu]", regions)
+	}
+	if got := zone.LookupRegion("www.test.com", TypeA, "eu"); len(got) != 1 || got[0].RDataString() != "198.51.100.1" {
+		t.Errorf("www in eu = %v, want the eu variant", got)
+	}
+	for _, region := range []string{"", "us"} {
+		if got := zone.LookupRegion("www.test.com", TypeA, region); len(got) != 2 {
+			t.Errorf("www in %q = %v, want the zone's 2 records", region, got)
+		}
+	}
+	if got := zone.LookupRegion("api.test.com", TypeA, "eu"); len(got) != 1 || got[0].RDataString() != "192.0.2.3" {
+		t.Errorf("api in eu = %v, want the zone's record", got)
+	}
+	if got := zone.LookupRegion("cdn.test.com", TypeAAAA, "eu"); len(got) != 1 || got[0].Type != TypeCNAME {
+		t.Errorf("cdn AAAA in eu = %v, want the CNAME variant", got)
+	}
+	if got := zone.Lookup("mail.test.com", TypeA); len(got) != 1 {
+		t.Errorf("mail after $REGION ends = %v, want a record of the zone's own", got)
+	}
+	if zone.HasName("cdn.test.com") {
+		t.Error("HasName(cdn) = true for a name with variants only")
+	}
+
+	// Exported and loaded again, the variants are kept
+	var b strings.Builder
+	if err := zone.Export(&b); err != nil {
+		t.Fatalf("Export error: %v", err)
+	}
+	if err := os.WriteFile(tmpfile.Name(), []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	again, err := LoadZoneFile(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("LoadZoneFile of the export error: %v", err)
+	}
+	if len(again.AllRecords()) != len(zone.AllRecords()) || len(again.RegionalRecords("eu")) != 2 {
+		t.Errorf("export loaded %d records and %d eu variants, want %d and 2:\n%s",
+			len(again.AllRecords()), len(again.RegionalRecords("eu")), len(zone.AllRecords()), b.String())
+	}
+}
+
+func TestLoadZoneFileHealthCheck(t *testing.T) {
+	content := `$ORIGIN test.com.
+$TTL 300
+@    IN SOA ns1.test.com. hostmaster.test.com. 1 3600 600 86400 300
+$HEALTHCHECK http 8080 /healthz
+www  IN A     192.0.2.1
+www  IN AAAA  2001:db8::1
+www  IN TXT   "not checked"
+$HEALTHCHECK tcp 443
+api  IN A     192.0.2.3
+$HEALTHCHECK
+mail IN A     192.0.2.4
+`
+	tmpfile, err := os.CreateTemp("", "zone-*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	zone, err := LoadZoneFile(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("LoadZoneFile error: %v", err)
+	}
+
+	checks := func(zone *Zone) map[string]string {
+		got := make(map[string]string)
+		for _, rr := range zone.AllRecords() {
+			if rr.HealthCheck != nil {
+				got[rr.Name+" "+TypeToString(rr.Type)] = rr.HealthCheck.String()
+			}
+		}
+		return got
+	}
+	want := map[string]string{
+		"www.test.com A":    "http 8080 /healthz",
+		"www.test.com AAAA": "http 8080 /healthz",
+		"api.test.com A":    "tcp 443",
+	}
+	if got := checks(zone); len(got) != len(want) {
+		t.Errorf("health checks = %v, want %v", got, want)
+	} else {
+		for k, v := range want {
+			if got[k] != v {
+				t.Errorf("health check of %s = %q, want %q", k, got[k], v)
+			}
+		}
+	}
+
+	// Exported and loaded again, the checks are kept
+	var b strings.Builder
+	if err := zone.Export(&b); err != nil {
+		t.Fatalf("Export error: %v", err)
+	}
+	if err := os.WriteFile(tmpfile.Name(), []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	again, err := LoadZoneFile(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("LoadZoneFile of the export error: %v", err)
+	}
+	if got := checks(again); len(got) != len(want) || got["api.test.com A"] != "tcp 443" {
+		t.Errorf("export loaded health checks %v, want %v:\n%s", got, want, b.String())
+	}
+
+	// A bad check is an error, not a record served without it
+	if err := os.WriteFile(tmpfile.Name(), []byte("$ORIGIN test.com.\n$HEALTHCHECK udp 53\nwww IN A 192.0.2.1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadZoneFile(tmpfile.Name()); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("LoadZoneFile with a bad $HEALTHCHECK error = %v, want one for line 2", err)
+	}
+}
+
+func TestLoadZoneFileWeights(t *testing.T) {
+	content := `$ORIGIN test.com.
+$TTL 300
+@    IN SOA ns1.test.com. hostmaster.test.com. 1 3600 600 86400 300
+$WEIGHT 90
+www  IN A     192.0.2.1
+www  IN MX    10 mail.test.com.
+$WEIGHT 10
+www  IN A     192.0.2.2
+$WEIGHT
+www  IN A     192.0.2.3
+`
+	tmpfile, err := os.CreateTemp("", "zone-*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	weights := func(zone *Zone) map[string]uint16 {
+		got := make(map[string]uint16)
+		for _, rr := range zone.AllRecords() {
+			if rr.Name == "www.test.com" {
+				got[rr.RDataString()] = rr.Weight
+			}
+		}
+		return got
+	}
+	want := map[string]uint16{"192.0.2.1": 90, "192.0.2.2": 10, "192.0.2.3": 0, "10 mail.test.com.": 0}
+
+	zone, err := LoadZoneFile(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("LoadZoneFile error: %v", err)
+	}
+	for data, w := range want {
+		if got := weights(zone)[data]; got != w {
+			t.Errorf("weight of www %s = %d, want %d", data, got, w)
+		}
+	}
+
+	// Exported and loaded again, the weights are kept
+	var b strings.Builder
+	if err := zone.Export(&b); err != nil {
+		t.Fatalf("Export error: %v", err)
+	}
+	if err := os.WriteFile(tmpfile.Name(), []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	again, err := LoadZoneFile(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("LoadZoneFile of the export error: %v", err)
+	}
+	for data, w := range want {
+		if got := weights(again)[data]; got != w {
+			t.Errorf("exported weight of www %s = %d, want %d:\n%s", data, got, w, b.String())
+		}
+	}
+
+	for _, bad := range []string{"0", "65536", "-1", "heavy"} {
+		if err := os.WriteFile(tmpfile.Name(), []byte("$ORIGIN test.com.\n$WEIGHT "+bad+"\nwww IN A 192.0.2.1\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadZoneFile(tmpfile.Name()); err == nil || !strings.Contains(err.Error(), "line 2") {
+			t.Errorf("LoadZoneFile with $WEIGHT %s error = %v, want one for line 2", bad, err)
+		}
+	}
+}
+
+func TestLoadZoneFileMultiLine(t *testing.T) {
+	content := `$ORIGIN test.com.
+$TTL 3600
+@    IN SOA ns1.test.com. hostmaster.test.com. ( ; the zone's SOA
+             2024010101 ; serial
+             7200       ; refresh
+             (900)      ; retry
+             1209600    ; expire
+             300 )      ; minimum
+@    IN NS  ns1
+info IN TXT ( "a ( in quotes"
+            ) ; dropped
+www  IN A   192.0.2.1
+`
+	tmpfile, err := os.CreateTemp("", "zone-*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	zone, err := LoadZoneFile(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("LoadZoneFile error: %v", err)
+	}
+
+	want := SOA{MName: "ns1.test.com", RName: "hostmaster.test.com", Serial: 2024010101, Refresh: 7200, Retry: 900, Expire: 1209600, Minimum: 300}
+	if zone.SOA == nil || *zone.SOA != want {
+		t.Fatalf("SOA = %+v, want %+v", zone.SOA, want)
+	}
+	soa := zone.Lookup("test.com", TypeSOA)
+	if len(soa) != 1 || soa[0].Comment != "the zone's SOA" {
+		t.Errorf("SOA records = %v, want one with the first line's comment", soa)
+	}
+	txt := zone.Lookup("info.test.com", TypeTXT)
+	if len(txt) != 1 || len(txt[0].Text) != 1 || txt[0].Text[0] != "a ( in quotes" || txt[0].Comment != "" {
+		t.Errorf("TXT records = %v, want the quoted parenthesis kept", txt)
+	}
+	if got := zone.Lookup("www.test.com", TypeA); len(got) != 1 {
+		t.Errorf("www = %v, want the record after the parentheses", got)
+	}
+
+	// Parentheses left open swallow the rest of the file, so they're an error
+	if err := os.WriteFile(tmpfile.Name(), []byte("$ORIGIN test.com.\n@ IN SOA ns1 hostmaster ( 1 2 3 4 5\nwww IN A 192.0.2.1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadZoneFile(tmpfile.Name()); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("LoadZoneFile with an unclosed parenthesis: %v, want an error for line 2", err)
+	}
+}
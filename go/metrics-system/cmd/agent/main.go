@@ -7,31 +7,40 @@ package main
 import (
 	"context"
 	"flag"
+	"io"
+	"log"
 	"os"
-	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/bellistech/metrics-system/internal/agent"
 	"github.com/bellistech/metrics-system/internal/agent/collector"
+	"github.com/bellistech/metrics-system/internal/buildinfo"
 	"github.com/bellistech/metrics-system/internal/config"
 	"github.com/bellistech/metrics-system/internal/logger"
+	"github.com/bellistech/metrics-system/internal/run"
+	"github.com/bellistech/metrics-system/pkg/metrics"
 )
 
-var Version = "dev"
-
 func main() {
 	// Parse command line flags
 	configPath := flag.String("config", "configs/agent.yaml", "Path to configuration file")
 	showVersion := flag.Bool("version", false, "Show version and exit")
 	listCollectors := flag.Bool("list-collectors", false, "List available collectors and exit")
+	noCloudMetadata := flag.Bool("no-cloud-metadata", false, "Don't label metrics with cloud instance metadata")
+	simulate := flag.Int("simulate", 0, "Send fabricated metrics for this many virtual hosts instead of collecting (load testing)")
+	simulateDevices := flag.Int("simulate-devices", agent.DefaultSimulateDevices, "Mount points and network interfaces per simulated host")
+	simulateInterval := flag.Duration("simulate-interval", 0, "How often each simulated host reports (default: collection interval)")
+	simulatePrefix := flag.String("simulate-prefix", "sim-host", "Hostname prefix of the simulated hosts")
 	verbose := flag.Bool("v", false, "Enable verbose (info) logging")
 	debug := flag.Bool("debug", false, "Enable debug logging (most verbose)")
 	logLevel := flag.String("log-level", "", "Set log level: debug, info, warn, error")
 	flag.Parse()
 
 	if *showVersion {
-		logger.Info("metrics-agent version %s", Version)
+		logger.Info("metrics-agent version %s", buildinfo.Get())
 		os.Exit(0)
 	}
 
@@ -61,6 +70,16 @@ func main() {
 
 	logger.Debug("Log level set to: %s", logger.GetLevel())
 
+	// Load-testing mode: no real collection, just fabricated hosts
+	if *simulate > 0 {
+		interval := *simulateInterval
+		if interval <= 0 {
+			interval = cfg.Collection.Interval
+		}
+		runSimulation(cfg.Server.Address, agent.NewSimulator(*simulatePrefix, *simulate, *simulateDevices, 1), interval)
+		return
+	}
+
 	// Get hostname
 	hostname, err := os.Hostname()
 	if err != nil {
@@ -86,6 +105,7 @@ func main() {
 		logger.Fatal("Failed to create client: %v", err)
 	}
 	defer client.Close()
+	client.SetRetries(cfg.Server.Retries)
 	logger.Debug("Connected to server")
 
 	// Create collector registry and register collectors from config
@@ -105,50 +125,167 @@ func main() {
 
 	logger.Info("Registered %d collectors: %v", len(registry.List()), registry.List())
 
-	// Set up signal handling for graceful shutdown
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	// Maintenance windows pause or tag collectors during planned work
+	schedule, err := agent.NewMaintenanceSchedule(cfg.Maintenance)
+	if err != nil {
+		logger.Fatal("Invalid maintenance configuration: %v", err)
+	}
+
+	// Optional agent-side rates for counters (nil when disabled)
+	rates := agent.NewRateCalculator(cfg.Rates)
+	if rates != nil {
+		logger.Info("Computing %s rates for counters matching %v", agent.RateSuffix, cfg.Rates.Prefixes)
+	}
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	// Pick up the counters of the agent we replaced, so rates carry on
+	// without a gap after an upgrade or restart
+	if cfg.Agent.StateFile != "" {
+		n, err := agent.LoadHandoff(cfg.Agent.StateFile, rates, max(agent.HandoffMaxAge, 2*cfg.Collection.Interval))
+		if err != nil {
+			logger.Warn("Handoff: %v", err)
+		} else if n > 0 {
+			logger.Info("Handoff: restored %d counter series from %s", n, cfg.Agent.StateFile)
+		}
+	}
 
-	// Start collection loop
-	ticker := time.NewTicker(cfg.Collection.Interval)
-	defer ticker.Stop()
+	// Optional scrubbing of sensitive labels (nil when disabled)
+	scrubber, err := agent.NewScrubber(cfg.Scrub)
+	if err != nil {
+		logger.Fatal("Invalid scrub configuration: %v", err)
+	}
+	if scrubber != nil {
+		logger.Info("Scrubbing labels with %d rules", len(cfg.Scrub.Rules))
+	}
+
+	// Optional cloud instance labels (nil when disabled)
+	if *noCloudMetadata {
+		cfg.Cloud.Enabled = false
+	}
+	cloud, err := agent.NewCloudMetadata(cfg.Cloud)
+	if err != nil {
+		logger.Fatal("Invalid cloud_metadata configuration: %v", err)
+	}
 
-	// Initial collection
-	collect(ctx, registry, client, cfg.Collection.Collectors)
+	// Optional byte budget for metered links (nil when disabled)
+	bandwidth, err := agent.NewBandwidthLimiter(cfg.Bandwidth)
+	if err != nil {
+		logger.Fatal("Invalid bandwidth configuration: %v", err)
+	}
+	if bandwidth != nil {
+		logger.Info("Bandwidth budget: %d bytes per %s, critical %v, bulk %v", cfg.Bandwidth.MaxBytes, cfg.Bandwidth.Period, cfg.Bandwidth.Critical, cfg.Bandwidth.Bulk)
+	}
 
-	logger.Info("Agent started. Press Ctrl+C to stop.")
+	// Optional disk-full and read-only events between collections (nil when disabled)
+	diskWatcher, err := agent.NewDiskWatcher(cfg.DiskEvents, hostname)
+	if err != nil {
+		logger.Fatal("Invalid disk_events configuration: %v", err)
+	}
+	if diskWatcher != nil {
+		logger.Info("Disk events: watching %v for %.0f%% usage and read-only remounts", diskWatcher.MountPoints(), cfg.DiskEvents.UsagePercent)
+	}
 
-	for {
+	// Everything below runs until SIGINT or SIGTERM. SIGUSR2 restarts the
+	// agent in place with the binary now installed, e.g. after an upgrade.
+	g := run.New("agent")
+	upgrade := make(chan struct{}, 1)
+	g.OnSignal(syscall.SIGUSR2, func() {
 		select {
-		case <-ticker.C:
-			collect(ctx, registry, client, cfg.Collection.Collectors)
-		case sig := <-sigChan:
-			logger.Info("Received signal %v, shutting down...", sig)
-			cancel()
-			return
-		case <-ctx.Done():
-			return
+		case upgrade <- struct{}{}:
+		default:
+		}
+	})
+
+	if diskWatcher != nil {
+		g.Add("disk events", func(ctx context.Context) error {
+			diskWatcher.Run(ctx, func(events []metrics.Metric) {
+				sendEvents(ctx, cloud, scrubber, client, events)
+			})
+			return nil
+		}, nil)
+	}
+
+	g.Add("collection", func(ctx context.Context) error {
+		ticker := time.NewTicker(cfg.Collection.Interval)
+		defer ticker.Stop()
+
+		// Initial collection
+		collect(ctx, registry, schedule, rates, cloud, scrubber, bandwidth, client, cfg.Collection.Collectors)
+
+		logger.Info("Agent started. Press Ctrl+C to stop.")
+
+		for {
+			select {
+			case <-ticker.C:
+				collect(ctx, registry, schedule, rates, cloud, scrubber, bandwidth, client, cfg.Collection.Collectors)
+			case <-upgrade:
+				// Collection runs in this loop, so any send in flight has
+				// finished by the time we get here
+				saveHandoff(cfg.Agent.StateFile, rates)
+				logger.Info("Received %v, restarting with %s...", syscall.SIGUSR2, os.Args[0])
+				if err := agent.Reexec(); err != nil {
+					logger.Error("Upgrade failed, carrying on: %v", err)
+				}
+			case <-ctx.Done():
+				saveHandoff(cfg.Agent.StateFile, rates)
+				return nil
+			}
 		}
+	}, nil)
+
+	if err := g.Run(); err != nil {
+		logger.Fatal("Agent stopped: %v", err)
+	}
+}
+
+// saveHandoff leaves the counter state for the next agent, if configured.
+func saveHandoff(path string, rates *agent.RateCalculator) {
+	if path == "" {
+		return
+	}
+	if err := agent.SaveHandoff(path, rates); err != nil {
+		logger.Warn("Handoff: %v", err)
 	}
 }
 
 // collect performs a single collection cycle.
-func collect(ctx context.Context, registry *collector.Registry, client *agent.Client, collectors []string) {
+func collect(ctx context.Context, registry *collector.Registry, schedule *agent.MaintenanceSchedule, rates *agent.RateCalculator, cloud *agent.CloudMetadata, scrubber *agent.Scrubber, bandwidth *agent.BandwidthLimiter, client *agent.Client, collectors []string) {
 	// Create a timeout context for collection
 	collectCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	logger.Debug("Starting collection cycle...")
 
+	// Apply maintenance windows
+	plan := schedule.Plan(time.Now(), collectors)
+	if len(plan.Paused) > 0 {
+		logger.Info("Maintenance: paused collectors %v", plan.Paused)
+	}
+
 	// Collect metrics
-	metrics, err := registry.CollectFrom(collectCtx, collectors)
+	metrics, err := registry.CollectFrom(collectCtx, plan.Run)
 	if err != nil {
 		logger.Error("Collection error: %v", err)
 	}
 
+	// Collectors inside a "label" window still run, but their metrics are tagged
+	for name, window := range plan.Tagged {
+		tagged, err := registry.CollectFrom(collectCtx, []string{name})
+		if err != nil {
+			logger.Error("Collection error: %v", err)
+		}
+		agent.TagMaintenance(tagged, window)
+		metrics = append(metrics, tagged...)
+	}
+
+	// Derive per-second gauges from counters
+	metrics = append(metrics, rates.Apply(metrics)...)
+
+	// Label everything with the cloud instance we run on
+	cloud.Apply(ctx, metrics)
+
+	// Scrub sensitive labels last, so nothing unscrubbed leaves the host
+	scrubber.Apply(metrics)
+
 	if len(metrics) == 0 {
 		logger.Warn("No metrics collected")
 		return
@@ -163,6 +300,13 @@ func collect(ctx context.Context, registry *collector.Registry, client *agent.Cl
 		}
 	}
 
+	// Stay within the link's budget; bulk metrics may wait for off-peak hours
+	metrics = bandwidth.Apply(time.Now(), metrics)
+	if len(metrics) == 0 {
+		logger.Debug("Nothing to send this cycle (%d bulk metrics queued)", bandwidth.Queued())
+		return
+	}
+
 	// Send metrics to server
 	logger.Debug("Sending metrics to server...")
 	sendCtx, sendCancel := context.WithTimeout(ctx, 10*time.Second)
@@ -174,3 +318,107 @@ func collect(ctx context.Context, registry *collector.Registry, client *agent.Cl
 		logger.Debug("Metrics sent successfully")
 	}
 }
+
+// sendEvents sends disk events right away, labeled and scrubbed like
+// collected metrics. They skip the bandwidth budget: they are few, and the
+// point is that they arrive now.
+func sendEvents(ctx context.Context, cloud *agent.CloudMetadata, scrubber *agent.Scrubber, client *agent.Client, events []metrics.Metric) {
+	cloud.Apply(ctx, events)
+	scrubber.Apply(events)
+
+	sendCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := client.SendMetrics(sendCtx, events); err != nil {
+		logger.Error("Failed to send disk events: %v", err)
+	}
+}
+
+// runSimulation sends fabricated metrics for every simulated host until
+// interrupted. Each host has its own connection and reports on its own
+// schedule, spread evenly over the interval like a fleet of real agents.
+func runSimulation(address string, sim *agent.Simulator, interval time.Duration) {
+	hosts := sim.Hosts()
+	logger.Info("Simulating %d hosts reporting every %s to %s", len(hosts), interval, address)
+
+	// The client logs every batch it sends, which drowns everything else
+	// out with thousands of hosts; the summary below replaces it
+	log.SetOutput(io.Discard)
+
+	clients := make([]*agent.Client, len(hosts))
+	for i, host := range hosts {
+		client, err := agent.NewClient(address, host, host)
+		if err != nil {
+			logger.Fatal("Failed to connect simulated host %s: %v", host, err)
+		}
+		defer client.Close()
+		clients[i] = client
+	}
+
+	var sent, batches, failed atomic.Int64
+	g := run.New("simulation")
+	g.Add("simulated hosts", func(ctx context.Context) error {
+		var wg sync.WaitGroup
+		for i := range hosts {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				// Stagger the start so the hosts don't all report at once
+				select {
+				case <-time.After(interval * time.Duration(i) / time.Duration(len(hosts))):
+				case <-ctx.Done():
+					return
+				}
+
+				ticker := time.NewTicker(interval)
+				defer ticker.Stop()
+				for {
+					batch := sim.Collect(i, time.Now())
+					sendCtx, sendCancel := context.WithTimeout(ctx, 10*time.Second)
+					err := clients[i].SendMetrics(sendCtx, batch)
+					sendCancel()
+					if err != nil {
+						if ctx.Err() != nil {
+							return
+						}
+						failed.Add(1)
+						logger.Debug("Simulated host %s: %v", hosts[i], err)
+					} else {
+						batches.Add(1)
+						sent.Add(int64(len(batch)))
+					}
+
+					select {
+					case <-ticker.C:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		return nil
+	}, nil)
+
+	g.Add("report", func(ctx context.Context) error {
+		report := time.NewTicker(max(interval, 10*time.Second))
+		defer report.Stop()
+		start := time.Now()
+		for {
+			select {
+			case <-report.C:
+				elapsed := time.Since(start).Seconds()
+				logger.Info("Simulation: %d metrics in %d batches (%.0f metrics/s), %d failed sends",
+					sent.Load(), batches.Load(), float64(sent.Load())/elapsed, failed.Load())
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}, nil)
+
+	err := g.Run()
+	logger.Info("Simulation sent %d metrics in %d batches, %d failed sends", sent.Load(), batches.Load(), failed.Load())
+	if err != nil {
+		logger.Error("Simulation stopped: %v", err)
+	}
+}
@@ -8,17 +8,17 @@ import (
 	"context"
 	"flag"
 	"os"
-	"os/signal"
-	"syscall"
+	"strings"
+	"time"
 
+	"github.com/bellistech/metrics-system/internal/buildinfo"
 	"github.com/bellistech/metrics-system/internal/config"
 	"github.com/bellistech/metrics-system/internal/logger"
+	"github.com/bellistech/metrics-system/internal/run"
 	"github.com/bellistech/metrics-system/internal/server"
 	"github.com/bellistech/metrics-system/internal/server/storage"
 )
 
-var Version = "dev"
-
 func main() {
 	// Parse command line flags
 	configPath := flag.String("config", "configs/server.yaml", "Path to configuration file")
@@ -29,7 +29,7 @@ func main() {
 	flag.Parse()
 
 	if *showVersion {
-		logger.Info("metrics-server version %s", Version)
+		logger.Info("metrics-server version %s", buildinfo.Get())
 		os.Exit(0)
 	}
 
@@ -52,46 +52,101 @@ func main() {
 
 	logger.Debug("Log level set to: %s", logger.GetLevel())
 
-	logger.Info("Starting metrics server (version: %s)", Version)
+	logger.Info("Starting metrics server (version: %s)", buildinfo.Get())
 	logger.Info("gRPC port: %d", cfg.GRPC.Port)
 	logger.Debug("Database config: %s@%s:%d/%s", cfg.Database.User, cfg.Database.Host, cfg.Database.Port, cfg.Database.Database)
 
 	// Connect to database
 	logger.Debug("Connecting to database...")
-	store, err := storage.NewPostgresStorage(cfg.Database.ConnectionString())
+	primary, err := storage.NewPostgresStorage(cfg.Database.ConnectionString())
 	if err != nil {
 		logger.Fatal("Failed to connect to database: %v", err)
 	}
-	defer store.Close()
 
 	logger.Info("Connected to database")
 
-	// Create gRPC server
-	grpcServer := server.NewGRPCServer(store)
+	// Optionally dual-write to a warm standby
+	var store storage.Storage = primary
+	var replicated *storage.ReplicatedStorage
+	if cfg.Replication.Enabled {
+		logger.Debug("Connecting to standby database %s:%d/%s...", cfg.Replication.Database.Host, cfg.Replication.Database.Port, cfg.Replication.Database.Database)
+		secondary, err := storage.NewPostgresStorage(cfg.Replication.Database.ConnectionString())
+		if err != nil {
+			logger.Fatal("Failed to connect to standby database: %v", err)
+		}
+		replicated = storage.NewReplicatedStorage(primary, secondary, cfg.Replication.QueueSize, cfg.Replication.RetryInterval)
+		store = replicated
+		logger.Info("Replicating ingestion to standby database %s:%d/%s", cfg.Replication.Database.Host, cfg.Replication.Database.Port, cfg.Replication.Database.Database)
+	}
 
-	// Set up signal handling for graceful shutdown
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	// Encrypt sensitive label values before they reach either database
+	if cfg.Encryption.Enabled {
+		key, err := storage.ReadEncryptionKey(cfg.Encryption.KeyFile)
+		if err != nil {
+			logger.Fatal("Failed to load encryption key: %v", err)
+		}
+		encrypted, err := storage.NewEncryptedStorage(store, key, cfg.Encryption.Labels)
+		if err != nil {
+			logger.Fatal("Failed to set up label encryption: %v", err)
+		}
+		store = encrypted
+		logger.Info("Encrypting values of labels: %s", strings.Join(cfg.Encryption.Labels, ", "))
+	}
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	// Series index backs the series browser API
+	index := server.NewSeriesIndex(cfg.HTTP.SeriesWindow)
 
-	// Start server in a goroutine
-	go func() {
-		if err := grpcServer.Start(cfg.GRPC.Port); err != nil {
-			logger.Fatal("Server failed: %v", err)
+	// Create gRPC server
+	grpcServer := server.NewGRPCServer(store, index)
+	grpcServer.EnableDedup(server.NewBatchDedup(cfg.Dedup.Window, cfg.Dedup.MaxBatches))
+
+	// Everything below runs until SIGINT or SIGTERM, or until one part fails
+	g := run.New("metrics server")
+	g.Add("series pruner", func(ctx context.Context) error {
+		index.RunPruner(ctx, time.Minute)
+		return nil
+	}, nil)
+	g.Add("gRPC server", func(ctx context.Context) error {
+		return grpcServer.Start(cfg.GRPC.Port)
+	}, grpcServer.Shutdown)
+
+	if cfg.HTTP.Enabled {
+		httpServer := server.NewHTTPServer(index)
+		httpServer.EnableQuery(store, cfg.Retention.Raw, cfg.Retention.Hourly, server.QueryLimits{
+			MaxConcurrent: cfg.HTTP.Query.MaxConcurrent,
+			QueueTimeout:  cfg.HTTP.Query.QueueTimeout,
+			MaxRows:       cfg.HTTP.Query.MaxRows,
+			MaxRange:      cfg.HTTP.Query.MaxRange,
+		})
+		if replicated != nil {
+			httpServer.EnableReplicationStatus(replicated)
+		}
+		silences, err := server.NewSilenceStore(cfg.Silences.File, cfg.Silences.Retention)
+		if err != nil {
+			logger.Fatal("Failed to load silences: %v", err)
 		}
-	}()
+		httpServer.EnableSilences(silences, cfg.HTTP.AdminToken)
+		if cfg.HTTP.AdminToken != "" {
+			if hostData, ok := store.(storage.HostData); ok {
+				httpServer.EnableHostExport(hostData, cfg.HTTP.AdminToken)
+			} else {
+				logger.Warn("Storage does not support host export; admin endpoints disabled")
+			}
+		}
+		g.Add("HTTP API", func(ctx context.Context) error {
+			return httpServer.Start(cfg.HTTP.Port)
+		}, httpServer.Shutdown)
+	}
 
 	logger.Info("Server started. Press Ctrl+C to stop.")
+	err = g.Run()
 
-	// Wait for shutdown signal
-	select {
-	case sig := <-sigChan:
-		logger.Info("Received signal %v, shutting down...", sig)
-		cancel()
-	case <-ctx.Done():
+	// Only once nothing can write to it anymore
+	if closeErr := store.Close(); closeErr != nil {
+		logger.Warn("Closing storage: %v", closeErr)
+	}
+	if err != nil {
+		logger.Fatal("Server stopped: %v", err)
 	}
-
 	logger.Info("Server stopped")
 }
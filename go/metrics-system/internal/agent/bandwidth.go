@@ -0,0 +1,154 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bellistech/metrics-system/internal/config"
+	"github.com/bellistech/metrics-system/internal/logger"
+	"github.com/bellistech/metrics-system/pkg/metrics"
+)
+
+// metricOverhead approximates the wire size of a metric's fixed fields
+// (type, value, timestamp) and protobuf framing.
+const metricOverhead = 32
+
+// BandwidthLimiter keeps what the agent sends within a byte budget per
+// period, for hosts on metered or slow links. Critical metrics are always
+// sent first, other metrics are dropped once the budget is spent, and bulk
+// metrics are held back and sent during the off-peak window with whatever
+// budget is left.
+type BandwidthLimiter struct {
+	maxBytes int
+	period   time.Duration
+	critical []string
+	bulk     []string
+	offPeak  *maintenanceWindow // nil: bulk metrics go out whenever budget is left
+	maxQueue int
+
+	periodStart time.Time
+	used        int              // bytes sent this period
+	dropped     int              // metrics dropped this period
+	queue       []metrics.Metric // deferred bulk metrics, oldest first
+}
+
+// NewBandwidthLimiter creates a limiter from the configuration. It returns
+// nil when there is neither a budget nor bulk metrics to defer.
+func NewBandwidthLimiter(cfg config.BandwidthConfig) (*BandwidthLimiter, error) {
+	if cfg.MaxBytes <= 0 && len(cfg.Bulk) == 0 {
+		return nil, nil
+	}
+	if cfg.Period <= 0 {
+		return nil, fmt.Errorf("period must be positive")
+	}
+
+	b := &BandwidthLimiter{
+		maxBytes: cfg.MaxBytes,
+		period:   cfg.Period,
+		critical: cfg.Critical,
+		bulk:     cfg.Bulk,
+		maxQueue: cfg.MaxQueue,
+	}
+	if cfg.OffPeak.Start != "" || cfg.OffPeak.End != "" {
+		b.offPeak = &maintenanceWindow{name: "off-peak"}
+		if err := b.offPeak.parseSchedule(cfg.OffPeak.Days, cfg.OffPeak.Start, cfg.OffPeak.End, cfg.OffPeak.Timezone); err != nil {
+			return nil, fmt.Errorf("off_peak: %w", err)
+		}
+	}
+	return b, nil
+}
+
+// Apply returns the metrics to send this cycle: all critical metrics, the
+// others while the budget lasts, and deferred bulk metrics if it's off-peak.
+// Bulk metrics keep their original timestamps while they wait.
+func (b *BandwidthLimiter) Apply(now time.Time, metricsList []metrics.Metric) []metrics.Metric {
+	if b == nil {
+		return metricsList
+	}
+
+	if start := now.Truncate(b.period); start.After(b.periodStart) {
+		if b.dropped > 0 {
+			logger.Warn("Bandwidth: dropped %d metrics over the %d byte budget or the bulk queue limit in the last %s", b.dropped, b.maxBytes, b.period)
+		}
+		b.periodStart, b.used, b.dropped = start, 0, 0
+	}
+
+	var send, normal []metrics.Metric
+	for _, m := range metricsList {
+		switch {
+		case hasPrefix(m.Name, b.critical):
+			send = append(send, m)
+			b.used += metricSize(m)
+		case hasPrefix(m.Name, b.bulk):
+			b.queue = append(b.queue, m)
+		default:
+			normal = append(normal, m)
+		}
+	}
+
+	for _, m := range normal {
+		if size := metricSize(m); b.fits(size) {
+			send = append(send, m)
+			b.used += size
+		} else {
+			b.dropped++
+		}
+	}
+
+	if over := len(b.queue) - b.maxQueue; b.maxQueue > 0 && over > 0 {
+		b.queue = b.queue[over:]
+		b.dropped += over
+	}
+
+	if b.offPeak == nil || b.offPeak.contains(now) {
+		flushed := 0
+		for _, m := range b.queue {
+			size := metricSize(m)
+			if !b.fits(size) {
+				break
+			}
+			send = append(send, m)
+			b.used += size
+			flushed++
+		}
+		b.queue = b.queue[flushed:]
+		if flushed > 0 {
+			logger.Debug("Bandwidth: sending %d deferred bulk metrics, %d still queued", flushed, len(b.queue))
+		}
+	}
+
+	return send
+}
+
+// fits reports whether size more bytes stay within this period's budget.
+func (b *BandwidthLimiter) fits(size int) bool {
+	return b.maxBytes <= 0 || b.used+size <= b.maxBytes
+}
+
+// Queued returns how many bulk metrics are waiting to be sent.
+func (b *BandwidthLimiter) Queued() int {
+	if b == nil {
+		return 0
+	}
+	return len(b.queue)
+}
+
+// metricSize approximates how many bytes a metric takes on the wire.
+func metricSize(m metrics.Metric) int {
+	size := metricOverhead + len(m.Name) + len(m.Hostname) + len(m.Unit)
+	for k, v := range m.Labels {
+		size += len(k) + len(v) + 4
+	}
+	return size
+}
+
+// hasPrefix reports whether name starts with one of the prefixes.
+func hasPrefix(name string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
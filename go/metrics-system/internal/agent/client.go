@@ -3,6 +3,7 @@ package agent
 
 import (
 	"context"
+	"crypto/rand"
 	"fmt"
 	"log"
 	"time"
@@ -10,16 +11,23 @@ import (
 	metricsv1 "github.com/bellistech/metrics-system/api/metrics/v1"
 	"github.com/bellistech/metrics-system/pkg/metrics"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// retryBackoff is the wait before the first retry of a batch; it doubles
+// with every further retry.
+const retryBackoff = time.Second
+
 // Client represents a gRPC client for sending metrics.
 type Client struct {
 	conn     *grpc.ClientConn
 	client   metricsv1.MetricsServiceClient
 	hostname string
 	agentID  string
+	retries  int
 }
 
 // NewClient creates a new gRPC client.
@@ -46,6 +54,14 @@ func NewClient(address, hostname, agentID string) (*Client, error) {
 	}, nil
 }
 
+// SetRetries sets how many times a batch is resent after a failure that
+// leaves it unknown whether the server stored it (a timeout or a dropped
+// connection). Retries carry the same batch ID, so the server stores the
+// batch at most once.
+func (c *Client) SetRetries(retries int) {
+	c.retries = max(retries, 0)
+}
+
 // Close closes the gRPC connection.
 func (c *Client) Close() error {
 	if c.conn != nil {
@@ -66,14 +82,39 @@ func (c *Client) SendMetrics(ctx context.Context, metricsList []metrics.Metric)
 		pbMetrics = append(pbMetrics, convertToProto(m))
 	}
 
+	batchID, err := newBatchID()
+	if err != nil {
+		return err
+	}
+
 	req := &metricsv1.MetricBatchRequest{
 		Hostname:  c.hostname,
 		AgentId:   c.agentID,
 		Timestamp: timestamppb.Now(),
 		Metrics:   pbMetrics,
+		BatchId:   batchID,
 	}
 
-	resp, err := c.client.SendMetrics(ctx, req)
+	// Each attempt gets an equal share of the time left, so a send that
+	// hangs until its deadline still leaves room to retry
+	var resp *metricsv1.MetricBatchResponse
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if deadline, ok := ctx.Deadline(); ok {
+			attemptCtx, cancel = context.WithTimeout(ctx, time.Until(deadline)/time.Duration(c.retries-attempt+1))
+		}
+		resp, err = c.client.SendMetrics(attemptCtx, req)
+		cancel()
+		if err == nil || attempt >= c.retries || !retryable(err) {
+			break
+		}
+		log.Printf("Sending batch %s failed (%v), retrying", batchID, err)
+		select {
+		case <-time.After(retryBackoff << attempt):
+		case <-ctx.Done():
+			return fmt.Errorf("failed to send metrics: %w", err)
+		}
+	}
 	if err != nil {
 		return fmt.Errorf("failed to send metrics: %w", err)
 	}
@@ -82,10 +123,35 @@ func (c *Client) SendMetrics(ctx context.Context, metricsList []metrics.Metric)
 		return fmt.Errorf("server rejected metrics: %s", resp.Message)
 	}
 
+	if resp.Duplicate {
+		log.Printf("Batch %s of %d metrics was already stored by an earlier attempt", batchID, len(metricsList))
+		return nil
+	}
 	log.Printf("Sent %d metrics to server (received: %d)", len(metricsList), resp.MetricsReceived)
 	return nil
 }
 
+// retryable reports whether a failed send may or may not have reached the
+// server, so it's worth sending again under the same batch ID.
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Aborted:
+		return true
+	}
+	return false
+}
+
+// newBatchID returns a random batch ID in UUID (version 4) form.
+func newBatchID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate batch ID: %w", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
 // HealthCheck checks if the server is healthy.
 func (c *Client) HealthCheck(ctx context.Context) error {
 	req := &metricsv1.HealthCheckRequest{
@@ -0,0 +1,361 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bellistech/metrics-system/internal/config"
+	"github.com/bellistech/metrics-system/internal/logger"
+	"github.com/bellistech/metrics-system/pkg/metrics"
+)
+
+// Labels attached from cloud instance metadata.
+const (
+	LabelCloud            = "cloud"
+	LabelInstanceID       = "instance_id"
+	LabelInstanceType     = "instance_type"
+	LabelRegion           = "region"
+	LabelAvailabilityZone = "availability_zone"
+)
+
+// Defaults for cloud metadata lookups.
+const (
+	DefaultCloudMetadataTimeout = 2 * time.Second
+	DefaultCloudMetadataRefresh = time.Hour
+)
+
+// imdsAddress is the link-local instance metadata service address shared by
+// EC2, GCE and Azure.
+const imdsAddress = "http://169.254.169.254"
+
+// InstanceMetadata describes the cloud instance the agent runs on.
+type InstanceMetadata struct {
+	Cloud            string
+	InstanceID       string
+	InstanceType     string
+	Region           string
+	AvailabilityZone string
+}
+
+// Labels returns the non-empty fields as metric labels.
+func (m InstanceMetadata) Labels() map[string]string {
+	labels := make(map[string]string)
+	for k, v := range map[string]string{
+		LabelCloud:            m.Cloud,
+		LabelInstanceID:       m.InstanceID,
+		LabelInstanceType:     m.InstanceType,
+		LabelRegion:           m.Region,
+		LabelAvailabilityZone: m.AvailabilityZone,
+	} {
+		if v != "" {
+			labels[k] = v
+		}
+	}
+	return labels
+}
+
+// MetadataProvider looks up instance metadata from one cloud. Lookup returns
+// an error when the agent is not running on that cloud.
+type MetadataProvider interface {
+	Lookup(ctx context.Context, client *http.Client) (InstanceMetadata, error)
+}
+
+// MetadataProviderFunc adapts a function to MetadataProvider.
+type MetadataProviderFunc func(ctx context.Context, client *http.Client) (InstanceMetadata, error)
+
+// Lookup calls f.
+func (f MetadataProviderFunc) Lookup(ctx context.Context, client *http.Client) (InstanceMetadata, error) {
+	return f(ctx, client)
+}
+
+var (
+	metadataProvidersMu sync.RWMutex
+	metadataProviders   = make(map[string]MetadataProvider)
+)
+
+// RegisterMetadataProvider registers a cloud metadata provider (e.g. for a
+// private cloud) under the given name, usable in cloud_metadata.providers.
+// Should be called from init().
+func RegisterMetadataProvider(name string, p MetadataProvider) {
+	metadataProvidersMu.Lock()
+	defer metadataProvidersMu.Unlock()
+	metadataProviders[name] = p
+}
+
+// ListMetadataProviders returns the names of all registered providers.
+func ListMetadataProviders() []string {
+	metadataProvidersMu.RLock()
+	defer metadataProvidersMu.RUnlock()
+
+	names := make([]string, 0, len(metadataProviders))
+	for name := range metadataProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterMetadataProvider("ec2", MetadataProviderFunc(lookupEC2))
+	RegisterMetadataProvider("gce", MetadataProviderFunc(lookupGCE))
+	RegisterMetadataProvider("azure", MetadataProviderFunc(lookupAzure))
+}
+
+// CloudMetadata labels every metric with the instance the agent runs on.
+// The lookup result, including "not on any cloud", is cached for the refresh
+// interval so the metadata services are not queried every cycle.
+type CloudMetadata struct {
+	names     []string
+	providers []MetadataProvider
+	client    *http.Client
+	refresh   time.Duration
+
+	mu        sync.Mutex
+	labels    map[string]string // nil until found
+	fetchedAt time.Time
+}
+
+// NewCloudMetadata creates the metadata annotator for the configured
+// providers (all registered ones if none are listed). It returns nil when
+// cloud metadata is disabled.
+func NewCloudMetadata(cfg config.CloudMetadataConfig) (*CloudMetadata, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	names := cfg.Providers
+	if len(names) == 0 {
+		names = ListMetadataProviders()
+	}
+
+	c := &CloudMetadata{
+		names:   names,
+		client:  &http.Client{Timeout: cfg.Timeout},
+		refresh: cfg.Refresh,
+	}
+	if c.client.Timeout <= 0 {
+		c.client.Timeout = DefaultCloudMetadataTimeout
+	}
+	if c.refresh <= 0 {
+		c.refresh = DefaultCloudMetadataRefresh
+	}
+
+	for _, name := range names {
+		metadataProvidersMu.RLock()
+		p := metadataProviders[name]
+		metadataProvidersMu.RUnlock()
+		if p == nil {
+			return nil, fmt.Errorf("unknown cloud metadata provider %q (want one of %v)", name, ListMetadataProviders())
+		}
+		c.providers = append(c.providers, p)
+	}
+	return c, nil
+}
+
+// Apply adds the instance labels to every metric, keeping labels a collector
+// already set.
+func (c *CloudMetadata) Apply(ctx context.Context, metricsList []metrics.Metric) {
+	if c == nil {
+		return
+	}
+
+	labels := c.current(ctx)
+	if len(labels) == 0 {
+		return
+	}
+
+	for i := range metricsList {
+		merged := make(map[string]string, len(metricsList[i].Labels)+len(labels))
+		for k, v := range labels {
+			merged[k] = v
+		}
+		for k, v := range metricsList[i].Labels {
+			merged[k] = v
+		}
+		metricsList[i].Labels = merged
+	}
+}
+
+// current returns the cached labels, looking them up again once the cache
+// has expired.
+func (c *CloudMetadata) current(ctx context.Context) map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.fetchedAt.IsZero() && time.Since(c.fetchedAt) < c.refresh {
+		return c.labels
+	}
+
+	md, name, err := c.lookup(ctx)
+	c.fetchedAt = time.Now()
+	if err != nil {
+		// Keep the last good labels; an instance doesn't change clouds
+		if c.labels == nil {
+			logger.Info("No cloud instance metadata found (%v)", err)
+		} else {
+			logger.Warn("Cloud metadata refresh failed, keeping previous labels: %v", err)
+		}
+		return c.labels
+	}
+
+	if c.labels == nil {
+		logger.Info("Running on %s instance %s", name, md.InstanceID)
+	}
+	c.labels = md.Labels()
+	return c.labels
+}
+
+// lookup asks every provider at once and returns the first one, in
+// configured order, that recognised the instance.
+func (c *CloudMetadata) lookup(ctx context.Context) (InstanceMetadata, string, error) {
+	type result struct {
+		md  InstanceMetadata
+		err error
+	}
+	results := make([]result, len(c.providers))
+
+	var wg sync.WaitGroup
+	for i, p := range c.providers {
+		wg.Add(1)
+		go func(i int, p MetadataProvider) {
+			defer wg.Done()
+			md, err := p.Lookup(ctx, c.client)
+			if err == nil && md.InstanceID == "" {
+				err = fmt.Errorf("no instance ID")
+			}
+			results[i] = result{md, err}
+		}(i, p)
+	}
+	wg.Wait()
+
+	var errs []string
+	for i, r := range results {
+		if r.err == nil {
+			if r.md.Cloud == "" {
+				r.md.Cloud = c.names[i]
+			}
+			return r.md, c.names[i], nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", c.names[i], r.err))
+	}
+	return InstanceMetadata{}, "", errors.New(strings.Join(errs, "; "))
+}
+
+// getMetadata sends a metadata request and decodes the JSON reply into v, or
+// copies it as-is if v is a *string.
+func getMetadata(ctx context.Context, client *http.Client, method, url string, header http.Header, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header = header
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	if s, ok := v.(*string); ok {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		*s = string(body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// lookupEC2 reads the EC2 instance identity document, with an IMDSv2 session
+// token when the instance hands one out.
+func lookupEC2(ctx context.Context, client *http.Client) (InstanceMetadata, error) {
+	header := http.Header{}
+	var token string
+	err := getMetadata(ctx, client, http.MethodPut, imdsAddress+"/latest/api/token",
+		http.Header{"X-Aws-Ec2-Metadata-Token-Ttl-Seconds": {"21600"}}, &token)
+	if err == nil {
+		header.Set("X-Aws-Ec2-Metadata-Token", token)
+	}
+
+	var doc struct {
+		InstanceID       string `json:"instanceId"`
+		InstanceType     string `json:"instanceType"`
+		Region           string `json:"region"`
+		AvailabilityZone string `json:"availabilityZone"`
+	}
+	if err := getMetadata(ctx, client, http.MethodGet, imdsAddress+"/latest/dynamic/instance-identity/document", header, &doc); err != nil {
+		return InstanceMetadata{}, err
+	}
+
+	return InstanceMetadata{
+		Cloud:            "ec2",
+		InstanceID:       doc.InstanceID,
+		InstanceType:     doc.InstanceType,
+		Region:           doc.Region,
+		AvailabilityZone: doc.AvailabilityZone,
+	}, nil
+}
+
+// lookupGCE reads the GCE instance metadata. Machine type and zone come as
+// resource paths ("projects/123/zones/us-central1-a").
+func lookupGCE(ctx context.Context, client *http.Client) (InstanceMetadata, error) {
+	var instance struct {
+		ID          json.Number `json:"id"`
+		MachineType string      `json:"machineType"`
+		Zone        string      `json:"zone"`
+	}
+	if err := getMetadata(ctx, client, http.MethodGet, imdsAddress+"/computeMetadata/v1/instance/?recursive=true",
+		http.Header{"Metadata-Flavor": {"Google"}}, &instance); err != nil {
+		return InstanceMetadata{}, err
+	}
+
+	zone := lastPathElement(instance.Zone)
+	region := zone
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		region = zone[:i]
+	}
+	return InstanceMetadata{
+		Cloud:            "gce",
+		InstanceID:       instance.ID.String(),
+		InstanceType:     lastPathElement(instance.MachineType),
+		Region:           region,
+		AvailabilityZone: zone,
+	}, nil
+}
+
+// lookupAzure reads the Azure instance metadata. Zone is empty for VMs not
+// pinned to an availability zone.
+func lookupAzure(ctx context.Context, client *http.Client) (InstanceMetadata, error) {
+	var compute struct {
+		VMID     string `json:"vmId"`
+		VMSize   string `json:"vmSize"`
+		Location string `json:"location"`
+		Zone     string `json:"zone"`
+	}
+	if err := getMetadata(ctx, client, http.MethodGet, imdsAddress+"/metadata/instance/compute?api-version=2021-02-01",
+		http.Header{"Metadata": {"true"}}, &compute); err != nil {
+		return InstanceMetadata{}, err
+	}
+
+	return InstanceMetadata{
+		Cloud:            "azure",
+		InstanceID:       compute.VMID,
+		InstanceType:     compute.VMSize,
+		Region:           compute.Location,
+		AvailabilityZone: compute.Zone,
+	}, nil
+}
+
+// lastPathElement returns what follows the last "/" of a resource path.
+func lastPathElement(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
+}
@@ -0,0 +1,280 @@
+package collector
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bellistech/metrics-system/pkg/metrics"
+)
+
+// Register btrfs collector factory on package init
+func init() {
+	RegisterFactory("btrfs", func(cfg CollectorConfig) Collector {
+		btrfsPath := "btrfs"
+		if path, ok := cfg.Options["btrfs_path"]; ok {
+			btrfsPath = path
+		}
+		return NewBtrfsCollector(cfg.Hostname, btrfsPath)
+	})
+}
+
+// BtrfsCollector collects btrfs filesystem capacity, missing devices, scrub
+// progress and per-device error counters by parsing the btrfs CLI.
+// Filesystems are discovered from /proc/mounts; each is reported once, under
+// the first mount point it appears at.
+type BtrfsCollector struct {
+	hostname  string
+	btrfsPath string
+}
+
+// btrfsScrub is the state of a filesystem's last or current scrub.
+type btrfsScrub struct {
+	known   bool // false if the filesystem was never scrubbed
+	running bool
+	percent float64
+	errors  float64
+}
+
+// NewBtrfsCollector creates a new btrfs collector.
+func NewBtrfsCollector(hostname, btrfsPath string) *BtrfsCollector {
+	return &BtrfsCollector{
+		hostname:  hostname,
+		btrfsPath: btrfsPath,
+	}
+}
+
+// Name returns the collector name.
+func (c *BtrfsCollector) Name() string {
+	return "btrfs"
+}
+
+// Collect gathers metrics for every mounted btrfs filesystem.
+func (c *BtrfsCollector) Collect(ctx context.Context) ([]metrics.Metric, error) {
+	now := time.Now()
+
+	mountPoints, err := btrfsMountPoints()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []metrics.Metric
+	add := func(name string, metricType metrics.MetricType, value float64, labels map[string]string, unit string) {
+		result = append(result, metrics.Metric{
+			Name:      name,
+			Type:      metricType,
+			Value:     value,
+			Timestamp: now,
+			Hostname:  c.hostname,
+			Labels:    labels,
+			Unit:      unit,
+		})
+	}
+
+	var errs []string
+	for _, mountPoint := range mountPoints {
+		labels := map[string]string{"mountpoint": mountPoint}
+
+		// -b: sizes in bytes
+		usage, err := exec.CommandContext(ctx, c.btrfsPath, "filesystem", "usage", "-b", mountPoint).Output()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: filesystem usage: %v", mountPoint, err))
+			continue
+		}
+		values := parseBtrfsUsage(string(usage))
+		for _, u := range []struct {
+			key, name string
+		}{
+			{"Device size", "btrfs_device_size_bytes"},
+			{"Device allocated", "btrfs_allocated_bytes"},
+			{"Used", "btrfs_used_bytes"},
+			{"Free (estimated)", "btrfs_free_bytes"},
+		} {
+			if v, ok := values[u.key]; ok {
+				add(u.name, metrics.MetricTypeGauge, v, labels, "bytes")
+			}
+		}
+		if size, used := values["Device size"], values["Used"]; size > 0 {
+			add("btrfs_used_percent", metrics.MetricTypeGauge, used/size*100, labels, "percent")
+		}
+
+		if show, err := exec.CommandContext(ctx, c.btrfsPath, "filesystem", "show", mountPoint).Output(); err == nil {
+			missing := 0.0
+			if strings.Contains(string(show), "missing") {
+				missing = 1
+			}
+			add("btrfs_devices_missing", metrics.MetricTypeGauge, missing, labels, "")
+		}
+
+		if stats, err := exec.CommandContext(ctx, c.btrfsPath, "device", "stats", mountPoint).Output(); err == nil {
+			for _, s := range parseBtrfsDeviceStats(string(stats)) {
+				add("btrfs_device_errors_total", metrics.MetricTypeCounter, s.value,
+					map[string]string{"mountpoint": mountPoint, "device": s.device, "type": s.kind}, "")
+			}
+		}
+
+		if status, err := exec.CommandContext(ctx, c.btrfsPath, "scrub", "status", mountPoint).Output(); err == nil {
+			if scrub := parseBtrfsScrubStatus(string(status)); scrub.known {
+				running := 0.0
+				if scrub.running {
+					running = 1
+				}
+				add("btrfs_scrub_in_progress", metrics.MetricTypeGauge, running, labels, "")
+				add("btrfs_scrub_progress_percent", metrics.MetricTypeGauge, scrub.percent, labels, "percent")
+				add("btrfs_scrub_errors", metrics.MetricTypeGauge, scrub.errors, labels, "")
+			}
+		}
+	}
+
+	if len(errs) > 0 && len(result) == 0 {
+		return nil, fmt.Errorf("btrfs: %s", strings.Join(errs, "; "))
+	}
+	return result, nil
+}
+
+// btrfsMountPoints lists one mount point per mounted btrfs filesystem.
+// Subvolumes of the same filesystem share a device, so the device is the key.
+func btrfsMountPoints() ([]string, error) {
+	file, err := os.Open("/proc/mounts")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open /proc/mounts: %w", err)
+	}
+	defer file.Close()
+
+	seen := make(map[string]bool)
+	var mountPoints []string
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[2] != "btrfs" {
+			continue
+		}
+		if seen[fields[0]] {
+			continue
+		}
+		seen[fields[0]] = true
+		mountPoints = append(mountPoints, fields[1])
+	}
+
+	return mountPoints, scanner.Err()
+}
+
+// parseBtrfsUsage reads the "Overall:" section of `btrfs filesystem usage -b`:
+//
+//	Overall:
+//	    Device size:                 107374182400
+//	    Device allocated:             53687091200
+//	    Used:                         42949672960
+//	    Free (estimated):             62277025792      (min: 35433480192)
+func parseBtrfsUsage(output string) map[string]float64 {
+	values := make(map[string]float64)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			break // End of the "Overall:" section
+		}
+		key, rest, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		if v, err := strconv.ParseFloat(fields[0], 64); err == nil {
+			values[strings.TrimSpace(key)] = v
+		}
+	}
+
+	return values
+}
+
+// btrfsDeviceStat is one counter from `btrfs device stats`.
+type btrfsDeviceStat struct {
+	device string
+	kind   string // e.g. "write_io", "corruption"
+	value  float64
+}
+
+// parseBtrfsDeviceStats parses `btrfs device stats` output:
+//
+//	[/dev/sda].write_io_errs    0
+//	[/dev/sda].corruption_errs  2
+func parseBtrfsDeviceStats(output string) []btrfsDeviceStat {
+	var stats []btrfsDeviceStat
+	scanner := bufio.NewScanner(strings.NewReader(output))
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || !strings.HasPrefix(fields[0], "[") {
+			continue
+		}
+		device, counter, ok := strings.Cut(strings.TrimPrefix(fields[0], "["), "].")
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+		stats = append(stats, btrfsDeviceStat{device: device, kind: strings.TrimSuffix(counter, "_errs"), value: value})
+	}
+
+	return stats
+}
+
+// parseBtrfsScrubStatus parses `btrfs scrub status` output:
+//
+//	Status:           running
+//	Bytes scrubbed:   1.00GiB  (10.00%)
+//	Error summary:    csum=3 verify=1
+//	                  Corrected:      2
+//	                  Uncorrectable:  2
+func parseBtrfsScrubStatus(output string) btrfsScrub {
+	var scrub btrfsScrub
+	scanner := bufio.NewScanner(strings.NewReader(output))
+
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch strings.TrimSpace(key) {
+		case "Status":
+			scrub.known = true
+			scrub.running = value == "running"
+			if value == "finished" {
+				scrub.percent = 100
+			}
+		case "Bytes scrubbed":
+			// "1.00GiB  (10.00%)"
+			if open := strings.Index(value, "("); open >= 0 {
+				pct := strings.TrimSuffix(strings.TrimSuffix(value[open+1:], ")"), "%")
+				if v, err := strconv.ParseFloat(pct, 64); err == nil && scrub.running {
+					scrub.percent = v
+				}
+			}
+		case "Error summary":
+			// "no errors found" or "csum=3 verify=1"
+			for _, field := range strings.Fields(value) {
+				if _, count, ok := strings.Cut(field, "="); ok {
+					if v, err := strconv.ParseFloat(count, 64); err == nil {
+						scrub.errors += v
+					}
+				}
+			}
+		}
+	}
+
+	return scrub
+}
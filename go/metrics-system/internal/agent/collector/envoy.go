@@ -0,0 +1,289 @@
+package collector
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bellistech/metrics-system/pkg/metrics"
+)
+
+// Register envoy collector factory on package init
+func init() {
+	RegisterFactory("envoy", func(cfg CollectorConfig) Collector {
+		statsURL := "http://localhost:9901/stats/prometheus"
+		if url, ok := cfg.Options["envoy_stats_url"]; ok {
+			statsURL = url
+		}
+		return NewEnvoyCollector(cfg.Hostname, statsURL)
+	})
+}
+
+// envoyQuantiles are the latency percentiles estimated from Envoy's histograms.
+var envoyQuantiles = []float64{0.5, 0.9, 0.99}
+
+// envoyStat maps an Envoy stat to a metric. label is the Envoy label that
+// identifies the listener or cluster, renamed to labelAs.
+type envoyStat struct {
+	envoyName  string
+	name       string
+	metricType metrics.MetricType
+	label      string
+	labelAs    string
+}
+
+// envoyStats are the downstream (listener side) and upstream (cluster side)
+// stats exported. Response code classes are added as a "code" label.
+var envoyStats = []envoyStat{
+	{"envoy_http_downstream_cx_active", "envoy_downstream_connections_active", metrics.MetricTypeGauge, "envoy_http_conn_manager_prefix", "listener"},
+	{"envoy_http_downstream_rq_total", "envoy_downstream_requests_total", metrics.MetricTypeCounter, "envoy_http_conn_manager_prefix", "listener"},
+	{"envoy_http_downstream_rq_xx", "envoy_downstream_responses_total", metrics.MetricTypeCounter, "envoy_http_conn_manager_prefix", "listener"},
+	{"envoy_cluster_upstream_cx_active", "envoy_cluster_connections_active", metrics.MetricTypeGauge, "envoy_cluster_name", "cluster"},
+	{"envoy_cluster_upstream_rq_total", "envoy_cluster_requests_total", metrics.MetricTypeCounter, "envoy_cluster_name", "cluster"},
+	{"envoy_cluster_upstream_rq_xx", "envoy_cluster_responses_total", metrics.MetricTypeCounter, "envoy_cluster_name", "cluster"},
+	{"envoy_cluster_upstream_cx_connect_fail", "envoy_cluster_connect_failures_total", metrics.MetricTypeCounter, "envoy_cluster_name", "cluster"},
+	{"envoy_cluster_upstream_rq_timeout", "envoy_cluster_request_timeouts_total", metrics.MetricTypeCounter, "envoy_cluster_name", "cluster"},
+	{"envoy_cluster_membership_healthy", "envoy_cluster_healthy_members", metrics.MetricTypeGauge, "envoy_cluster_name", "cluster"},
+	{"envoy_cluster_membership_total", "envoy_cluster_members", metrics.MetricTypeGauge, "envoy_cluster_name", "cluster"},
+}
+
+// envoyHistograms are the request latency histograms (in milliseconds)
+// reported as percentiles.
+var envoyHistograms = []envoyStat{
+	{"envoy_http_downstream_rq_time", "envoy_downstream_request_time_ms", metrics.MetricTypeGauge, "envoy_http_conn_manager_prefix", "listener"},
+	{"envoy_cluster_upstream_rq_time", "envoy_cluster_request_time_ms", metrics.MetricTypeGauge, "envoy_cluster_name", "cluster"},
+}
+
+// EnvoyCollector collects Envoy listener and cluster request counts, error
+// responses and latency percentiles from the admin /stats/prometheus page.
+type EnvoyCollector struct {
+	hostname string
+	statsURL string
+	client   *http.Client
+}
+
+// promSample is one sample line of the Prometheus text format.
+type promSample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+// NewEnvoyCollector creates a new Envoy collector.
+func NewEnvoyCollector(hostname, statsURL string) *EnvoyCollector {
+	return &EnvoyCollector{
+		hostname: hostname,
+		statsURL: statsURL,
+		client: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+	}
+}
+
+// Name returns the collector name.
+func (c *EnvoyCollector) Name() string {
+	return "envoy"
+}
+
+// Collect gathers Envoy metrics from the admin interface.
+func (c *EnvoyCollector) Collect(ctx context.Context) ([]metrics.Metric, error) {
+	now := time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", c.statsURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch stats: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	samples, err := parsePrometheusText(bufio.NewScanner(resp.Body))
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %w", err)
+	}
+
+	var result []metrics.Metric
+	add := func(name string, metricType metrics.MetricType, value float64, labels map[string]string, unit string) {
+		result = append(result, metrics.Metric{
+			Name:      name,
+			Type:      metricType,
+			Value:     value,
+			Timestamp: now,
+			Hostname:  c.hostname,
+			Labels:    labels,
+			Unit:      unit,
+		})
+	}
+
+	byName := make(map[string][]promSample)
+	for _, s := range samples {
+		byName[s.name] = append(byName[s.name], s)
+	}
+
+	for _, stat := range envoyStats {
+		for _, s := range byName[stat.envoyName] {
+			labels := map[string]string{stat.labelAs: s.labels[stat.label]}
+			if class, ok := s.labels["envoy_response_code_class"]; ok {
+				labels["code"] = class + "xx"
+			}
+			add(stat.name, stat.metricType, s.value, labels, "")
+		}
+	}
+
+	for _, hist := range envoyHistograms {
+		// Group the cumulative buckets of each listener/cluster
+		buckets := make(map[string][]promBucket)
+		for _, s := range byName[hist.envoyName+"_bucket"] {
+			le, err := strconv.ParseFloat(s.labels["le"], 64)
+			if err != nil {
+				continue
+			}
+			key := s.labels[hist.label]
+			buckets[key] = append(buckets[key], promBucket{upperBound: le, count: s.value})
+		}
+
+		for key, b := range buckets {
+			for _, q := range envoyQuantiles {
+				v, ok := bucketQuantile(q, b)
+				if !ok {
+					continue // No requests yet
+				}
+				add(hist.name, hist.metricType, v, map[string]string{
+					hist.labelAs: key,
+					"quantile":   strconv.FormatFloat(q, 'f', -1, 64),
+				}, "ms")
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// parsePrometheusText parses Prometheus text exposition format samples,
+// ignoring comments and timestamps:
+//
+//	# TYPE envoy_cluster_upstream_rq_total counter
+//	envoy_cluster_upstream_rq_total{envoy_cluster_name="api"} 1234
+func parsePrometheusText(scanner *bufio.Scanner) ([]promSample, error) {
+	var samples []promSample
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		s := promSample{labels: make(map[string]string)}
+		rest := line
+		if open := strings.IndexAny(line, "{ "); open >= 0 && line[open] == '{' {
+			s.name = line[:open]
+			var ok bool
+			rest, ok = parsePromLabels(line[open+1:], s.labels)
+			if !ok {
+				continue
+			}
+		} else {
+			s.name, rest, _ = strings.Cut(line, " ")
+		}
+
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		s.value = value
+		samples = append(samples, s)
+	}
+
+	return samples, scanner.Err()
+}
+
+// parsePromLabels parses `a="1",b="2"}` into labels, returning what follows
+// the closing brace.
+func parsePromLabels(s string, labels map[string]string) (string, bool) {
+	for {
+		s = strings.TrimLeft(s, " ,")
+		if strings.HasPrefix(s, "}") {
+			return s[1:], true
+		}
+
+		name, rest, ok := strings.Cut(s, "=")
+		if !ok || !strings.HasPrefix(rest, `"`) {
+			return "", false
+		}
+
+		// Label values escape \, " and newlines with a backslash
+		var value strings.Builder
+		i := 1
+		for ; i < len(rest) && rest[i] != '"'; i++ {
+			if rest[i] == '\\' && i+1 < len(rest) {
+				i++
+				if rest[i] == 'n' {
+					value.WriteByte('\n')
+					continue
+				}
+			}
+			value.WriteByte(rest[i])
+		}
+		if i >= len(rest) {
+			return "", false
+		}
+
+		labels[strings.TrimSpace(name)] = value.String()
+		s = rest[i+1:]
+	}
+}
+
+// promBucket is one cumulative histogram bucket.
+type promBucket struct {
+	upperBound float64
+	count      float64
+}
+
+// bucketQuantile estimates the q-quantile from cumulative histogram buckets
+// by linear interpolation within the bucket it falls in, the same way
+// Prometheus' histogram_quantile does. It returns false if there are no
+// observations.
+func bucketQuantile(q float64, buckets []promBucket) (float64, bool) {
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].upperBound < buckets[j].upperBound })
+	if len(buckets) == 0 {
+		return 0, false
+	}
+	total := buckets[len(buckets)-1].count
+	if total == 0 {
+		return 0, false
+	}
+
+	rank := q * total
+	lowerBound, lowerCount := 0.0, 0.0
+	for _, b := range buckets {
+		if b.count >= rank {
+			if math.IsInf(b.upperBound, 1) {
+				return lowerBound, true // Can't interpolate into +Inf
+			}
+			if b.count == lowerCount {
+				return b.upperBound, true
+			}
+			return lowerBound + (b.upperBound-lowerBound)*(rank-lowerCount)/(b.count-lowerCount), true
+		}
+		lowerBound, lowerCount = b.upperBound, b.count
+	}
+	return lowerBound, true
+}
@@ -0,0 +1,239 @@
+package collector
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bellistech/metrics-system/pkg/metrics"
+)
+
+// Register haproxy collector factory on package init
+func init() {
+	RegisterFactory("haproxy", func(cfg CollectorConfig) Collector {
+		statsURL := "http://localhost:8404/stats;csv"
+		if url, ok := cfg.Options["haproxy_stats_url"]; ok {
+			statsURL = url
+		}
+		return NewHAProxyCollector(cfg.Hostname, statsURL, cfg.Options["haproxy_stats_socket"])
+	})
+}
+
+// HAProxyCollector collects HAProxy frontend and backend metrics from the
+// stats CSV, read either from the stats page or the admin socket. The socket
+// is used when set.
+//
+// Example HAProxy config:
+//
+//	global
+//	    stats socket /run/haproxy/admin.sock mode 660 level user
+//	frontend stats
+//	    bind 127.0.0.1:8404
+//	    stats enable
+//	    stats uri /stats
+type HAProxyCollector struct {
+	hostname    string
+	statsURL    string
+	statsSocket string
+	client      *http.Client
+}
+
+// haproxyField maps a stats CSV column to a metric.
+type haproxyField struct {
+	column     string
+	name       string
+	metricType metrics.MetricType
+	scale      float64
+	unit       string
+}
+
+// haproxyFields are the columns exported for every frontend and backend.
+// The *time columns are averages over the last 1024 requests, in milliseconds.
+var haproxyFields = []haproxyField{
+	{"scur", "haproxy_current_sessions", metrics.MetricTypeGauge, 1, ""},
+	{"stot", "haproxy_sessions_total", metrics.MetricTypeCounter, 1, ""},
+	{"rate", "haproxy_session_rate", metrics.MetricTypeGauge, 1, "per_second"},
+	{"qcur", "haproxy_current_queue", metrics.MetricTypeGauge, 1, ""},
+	{"bin", "haproxy_bytes_in_total", metrics.MetricTypeCounter, 1, "bytes"},
+	{"bout", "haproxy_bytes_out_total", metrics.MetricTypeCounter, 1, "bytes"},
+	{"req_tot", "haproxy_http_requests_total", metrics.MetricTypeCounter, 1, ""},
+	{"ereq", "haproxy_request_errors_total", metrics.MetricTypeCounter, 1, ""},
+	{"econ", "haproxy_connection_errors_total", metrics.MetricTypeCounter, 1, ""},
+	{"eresp", "haproxy_response_errors_total", metrics.MetricTypeCounter, 1, ""},
+	{"dreq", "haproxy_requests_denied_total", metrics.MetricTypeCounter, 1, ""},
+	{"qtime", "haproxy_queue_time_avg_seconds", metrics.MetricTypeGauge, 0.001, "seconds"},
+	{"ctime", "haproxy_connect_time_avg_seconds", metrics.MetricTypeGauge, 0.001, "seconds"},
+	{"rtime", "haproxy_response_time_avg_seconds", metrics.MetricTypeGauge, 0.001, "seconds"},
+	{"ttime", "haproxy_total_time_avg_seconds", metrics.MetricTypeGauge, 0.001, "seconds"},
+}
+
+// NewHAProxyCollector creates a new HAProxy collector. statsSocket may be
+// empty to use statsURL instead.
+func NewHAProxyCollector(hostname, statsURL, statsSocket string) *HAProxyCollector {
+	return &HAProxyCollector{
+		hostname:    hostname,
+		statsURL:    statsURL,
+		statsSocket: statsSocket,
+		client: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+	}
+}
+
+// Name returns the collector name.
+func (c *HAProxyCollector) Name() string {
+	return "haproxy"
+}
+
+// Collect gathers HAProxy metrics from the stats CSV.
+func (c *HAProxyCollector) Collect(ctx context.Context) ([]metrics.Metric, error) {
+	now := time.Now()
+
+	var body io.ReadCloser
+	var err error
+	if c.statsSocket != "" {
+		body, err = c.readSocket(ctx)
+	} else {
+		body, err = c.readURL(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	rows, err := parseHAProxyCSV(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []metrics.Metric
+	add := func(name string, metricType metrics.MetricType, value float64, labels map[string]string, unit string) {
+		result = append(result, metrics.Metric{
+			Name:      name,
+			Type:      metricType,
+			Value:     value,
+			Timestamp: now,
+			Hostname:  c.hostname,
+			Labels:    labels,
+			Unit:      unit,
+		})
+	}
+
+	for _, row := range rows {
+		var kind string
+		switch row["svname"] {
+		case "FRONTEND":
+			kind = "frontend"
+		case "BACKEND":
+			kind = "backend"
+		default:
+			continue // Individual servers would multiply cardinality
+		}
+		labels := map[string]string{"proxy": row["pxname"], "type": kind}
+
+		for _, f := range haproxyFields {
+			// Columns a proxy type doesn't support are left empty
+			v, err := strconv.ParseFloat(row[f.column], 64)
+			if err != nil {
+				continue
+			}
+			add(f.name, f.metricType, v*f.scale, labels, f.unit)
+		}
+
+		for _, class := range []string{"1xx", "2xx", "3xx", "4xx", "5xx", "other"} {
+			v, err := strconv.ParseFloat(row["hrsp_"+class], 64)
+			if err != nil {
+				continue
+			}
+			add("haproxy_http_responses_total", metrics.MetricTypeCounter, v,
+				map[string]string{"proxy": row["pxname"], "type": kind, "code": class}, "")
+		}
+
+		up := 0.0
+		if status := row["status"]; status == "UP" || status == "OPEN" {
+			up = 1
+		}
+		add("haproxy_up", metrics.MetricTypeGauge, up, labels, "")
+	}
+
+	return result, nil
+}
+
+// readURL fetches the stats CSV from the stats page.
+func (c *HAProxyCollector) readURL(ctx context.Context) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.statsURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch stats: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// readSocket runs "show stat" on the admin socket.
+func (c *HAProxyCollector) readSocket(ctx context.Context) (io.ReadCloser, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", c.statsSocket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to stats socket: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write([]byte("show stat\n")); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to query stats socket: %w", err)
+	}
+	return conn, nil
+}
+
+// parseHAProxyCSV parses the stats CSV into one column->value map per row.
+// The header line starts with "# ":
+//
+//	# pxname,svname,qcur,qmax,scur,smax,slim,stot,bin,bout,...
+//	http-in,FRONTEND,,,3,10,2000,1234,56789,98765,...
+func parseHAProxyCSV(r io.Reader) ([]map[string]string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1 // Column count varies between HAProxy versions
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stats header: %w", err)
+	}
+	if len(header) == 0 || !strings.HasPrefix(header[0], "#") {
+		return nil, fmt.Errorf("unexpected stats header: %q", strings.Join(header, ","))
+	}
+	header[0] = strings.TrimSpace(strings.TrimPrefix(header[0], "#"))
+
+	var rows []map[string]string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return rows, fmt.Errorf("error reading stats: %w", err)
+		}
+		row := make(map[string]string, len(header))
+		for i, column := range header {
+			if i < len(record) {
+				row[column] = record[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,177 @@
+package collector
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bellistech/metrics-system/pkg/metrics"
+)
+
+// Default in-cluster locations of the kubelet API and service account credentials.
+const (
+	defaultKubeletURL       = "https://localhost:10250/stats/summary"
+	defaultKubeletTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	defaultKubeletCAFile    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// Register kubelet collector factory on package init
+func init() {
+	RegisterFactory("kubelet", func(cfg CollectorConfig) Collector {
+		summaryURL := defaultKubeletURL
+		if url, ok := cfg.Options["kubelet_url"]; ok {
+			summaryURL = url
+		}
+		tokenFile := defaultKubeletTokenFile
+		if path, ok := cfg.Options["token_file"]; ok {
+			tokenFile = path
+		}
+		caFile := defaultKubeletCAFile
+		if path, ok := cfg.Options["ca_file"]; ok {
+			caFile = path
+		}
+		insecure := cfg.Options["insecure_skip_verify"] == "true"
+		return NewKubeletCollector(cfg.Hostname, summaryURL, tokenFile, caFile, insecure)
+	})
+}
+
+// KubeletCollector collects per-pod CPU, memory and ephemeral storage usage
+// from the local kubelet's /stats/summary endpoint.
+//
+// The agent authenticates with its service account token, which needs
+// permission to read the nodes/stats subresource:
+//
+//	rules:
+//	- apiGroups: [""]
+//	  resources: ["nodes/stats"]
+//	  verbs: ["get"]
+type KubeletCollector struct {
+	hostname   string
+	summaryURL string
+	tokenFile  string
+	client     *http.Client
+}
+
+// kubeletSummary is the subset of the kubelet stats summary we use.
+type kubeletSummary struct {
+	Pods []struct {
+		PodRef struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"podRef"`
+		CPU *struct {
+			UsageNanoCores       *uint64 `json:"usageNanoCores"`
+			UsageCoreNanoSeconds *uint64 `json:"usageCoreNanoSeconds"`
+		} `json:"cpu"`
+		Memory *struct {
+			UsageBytes      *uint64 `json:"usageBytes"`
+			WorkingSetBytes *uint64 `json:"workingSetBytes"`
+			RSSBytes        *uint64 `json:"rssBytes"`
+		} `json:"memory"`
+		EphemeralStorage *struct {
+			UsedBytes *uint64 `json:"usedBytes"`
+		} `json:"ephemeral-storage"`
+	} `json:"pods"`
+}
+
+// NewKubeletCollector creates a new kubelet collector. The CA file is used
+// to verify the kubelet's certificate when it exists; kubelets commonly run
+// with self-signed serving certificates, in which case insecure must be set.
+func NewKubeletCollector(hostname, summaryURL, tokenFile, caFile string, insecure bool) *KubeletCollector {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+	if pem, err := os.ReadFile(caFile); err == nil {
+		pool := x509.NewCertPool()
+		if pool.AppendCertsFromPEM(pem) {
+			tlsConfig.RootCAs = pool
+		}
+	}
+
+	return &KubeletCollector{
+		hostname:   hostname,
+		summaryURL: summaryURL,
+		tokenFile:  tokenFile,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}
+}
+
+// Name returns the collector name.
+func (c *KubeletCollector) Name() string {
+	return "kubelet"
+}
+
+// Collect gathers pod metrics from the kubelet stats summary.
+func (c *KubeletCollector) Collect(ctx context.Context) ([]metrics.Metric, error) {
+	now := time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", c.summaryURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Projected service account tokens are rotated, so read it every time
+	if token, err := os.ReadFile(c.tokenFile); err == nil {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch stats summary: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var summary kubeletSummary
+	if err := json.NewDecoder(resp.Body).Decode(&summary); err != nil {
+		return nil, fmt.Errorf("failed to decode stats summary: %w", err)
+	}
+
+	var result []metrics.Metric
+	add := func(name string, metricType metrics.MetricType, value *uint64, scale float64, labels map[string]string, unit string) {
+		if value == nil {
+			return // Not reported yet, e.g. for a pod that just started
+		}
+		result = append(result, metrics.Metric{
+			Name:      name,
+			Type:      metricType,
+			Value:     float64(*value) * scale,
+			Timestamp: now,
+			Hostname:  c.hostname,
+			Labels:    labels,
+			Unit:      unit,
+		})
+	}
+
+	for _, pod := range summary.Pods {
+		labels := map[string]string{
+			"namespace": pod.PodRef.Namespace,
+			"pod":       pod.PodRef.Name,
+		}
+
+		if cpu := pod.CPU; cpu != nil {
+			add("kubelet_pod_cpu_usage_cores", metrics.MetricTypeGauge, cpu.UsageNanoCores, 1e-9, labels, "cores")
+			add("kubelet_pod_cpu_usage_seconds_total", metrics.MetricTypeCounter, cpu.UsageCoreNanoSeconds, 1e-9, labels, "seconds")
+		}
+		if mem := pod.Memory; mem != nil {
+			add("kubelet_pod_memory_usage_bytes", metrics.MetricTypeGauge, mem.UsageBytes, 1, labels, "bytes")
+			add("kubelet_pod_memory_working_set_bytes", metrics.MetricTypeGauge, mem.WorkingSetBytes, 1, labels, "bytes")
+			add("kubelet_pod_memory_rss_bytes", metrics.MetricTypeGauge, mem.RSSBytes, 1, labels, "bytes")
+		}
+		if storage := pod.EphemeralStorage; storage != nil {
+			add("kubelet_pod_ephemeral_storage_used_bytes", metrics.MetricTypeGauge, storage.UsedBytes, 1, labels, "bytes")
+		}
+	}
+
+	return result, nil
+}
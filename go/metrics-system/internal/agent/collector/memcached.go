@@ -0,0 +1,136 @@
+package collector
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bellistech/metrics-system/pkg/metrics"
+)
+
+// Register memcached collector factory on package init
+func init() {
+	RegisterFactory("memcached", func(cfg CollectorConfig) Collector {
+		address := "localhost:11211"
+		if addr, ok := cfg.Options["memcached_address"]; ok {
+			address = addr
+		}
+		return NewMemcachedCollector(cfg.Hostname, address)
+	})
+}
+
+// MemcachedCollector collects memcached metrics from the "stats" command.
+// The address may be host:port or the path of a unix socket.
+type MemcachedCollector struct {
+	hostname string
+	address  string
+}
+
+// memcachedStats maps "STAT" names to metric names. Names ending in _total
+// are counters, the rest gauges.
+var memcachedStats = map[string]string{
+	"uptime":                "memcached_uptime_seconds",
+	"curr_connections":      "memcached_current_connections",
+	"total_connections":     "memcached_connections_total",
+	"rejected_connections":  "memcached_rejected_connections_total",
+	"curr_items":            "memcached_current_items",
+	"total_items":           "memcached_items_total",
+	"bytes":                 "memcached_memory_used_bytes",
+	"limit_maxbytes":        "memcached_memory_limit_bytes",
+	"cmd_get":               "memcached_gets_total",
+	"cmd_set":               "memcached_sets_total",
+	"get_hits":              "memcached_get_hits_total",
+	"get_misses":            "memcached_get_misses_total",
+	"evictions":             "memcached_evictions_total",
+	"bytes_read":            "memcached_read_bytes_total",
+	"bytes_written":         "memcached_written_bytes_total",
+	"threads":               "memcached_threads",
+	"listen_disabled_num":   "memcached_listen_disabled_total",
+	"conn_yields":           "memcached_connection_yields_total",
+	"reclaimed":             "memcached_reclaimed_total",
+	"expired_unfetched":     "memcached_expired_unfetched_total",
+	"evicted_unfetched":     "memcached_evicted_unfetched_total",
+	"total_connections_tls": "memcached_tls_connections_total",
+}
+
+// NewMemcachedCollector creates a new memcached collector.
+func NewMemcachedCollector(hostname, address string) *MemcachedCollector {
+	return &MemcachedCollector{
+		hostname: hostname,
+		address:  address,
+	}
+}
+
+// Name returns the collector name.
+func (c *MemcachedCollector) Name() string {
+	return "memcached"
+}
+
+// Collect gathers memcached metrics.
+func (c *MemcachedCollector) Collect(ctx context.Context) ([]metrics.Metric, error) {
+	now := time.Now()
+
+	network := "tcp"
+	if strings.HasPrefix(c.address, "/") {
+		network = "unix"
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, c.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write([]byte("stats\r\n")); err != nil {
+		return nil, fmt.Errorf("failed to send stats command: %w", err)
+	}
+
+	// Response is "STAT <name> <value>" lines ending with "END"
+	var result []metrics.Metric
+	scanner := bufio.NewScanner(conn)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "END" {
+			return result, nil
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] != "STAT" {
+			continue
+		}
+
+		metricName, ok := memcachedStats[fields[1]]
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			continue
+		}
+
+		metricType := metrics.MetricTypeGauge
+		if strings.HasSuffix(metricName, "_total") {
+			metricType = metrics.MetricTypeCounter
+		}
+
+		result = append(result, metrics.Metric{
+			Name:      metricName,
+			Type:      metricType,
+			Value:     value,
+			Timestamp: now,
+			Hostname:  c.hostname,
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return result, fmt.Errorf("error reading response: %w", err)
+	}
+	return result, fmt.Errorf("stats response ended without END")
+}
@@ -0,0 +1,211 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bellistech/metrics-system/pkg/metrics"
+)
+
+// Register rabbitmq collector factory on package init
+func init() {
+	RegisterFactory("rabbitmq", func(cfg CollectorConfig) Collector {
+		apiURL := "http://localhost:15672"
+		if url, ok := cfg.Options["rabbitmq_url"]; ok {
+			apiURL = url
+		}
+		user := "guest"
+		if u, ok := cfg.Options["rabbitmq_user"]; ok {
+			user = u
+		}
+		password := "guest"
+		if p, ok := cfg.Options["rabbitmq_password"]; ok {
+			password = p
+		}
+		return NewRabbitMQCollector(cfg.Hostname, apiURL, user, password)
+	})
+}
+
+// RabbitMQCollector collects RabbitMQ broker, node and queue metrics from
+// the management plugin's HTTP API. The user needs the "monitoring" tag.
+type RabbitMQCollector struct {
+	hostname string
+	apiURL   string
+	user     string
+	password string
+	client   *http.Client
+}
+
+// rabbitRate is a counter with its rate over the last sampling interval,
+// as reported in message_stats.
+type rabbitRate struct {
+	Rate float64 `json:"rate"`
+}
+
+// rabbitMessageStats is the message_stats object of overview and queues.
+type rabbitMessageStats struct {
+	Publish           *float64   `json:"publish"`
+	PublishDetails    rabbitRate `json:"publish_details"`
+	DeliverGet        *float64   `json:"deliver_get"`
+	DeliverGetDetails rabbitRate `json:"deliver_get_details"`
+}
+
+// rabbitOverview is the subset of /api/overview we use.
+type rabbitOverview struct {
+	ObjectTotals struct {
+		Connections float64 `json:"connections"`
+		Channels    float64 `json:"channels"`
+		Queues      float64 `json:"queues"`
+		Consumers   float64 `json:"consumers"`
+	} `json:"object_totals"`
+	QueueTotals struct {
+		Messages        float64 `json:"messages"`
+		MessagesReady   float64 `json:"messages_ready"`
+		MessagesUnacked float64 `json:"messages_unacknowledged"`
+	} `json:"queue_totals"`
+	MessageStats rabbitMessageStats `json:"message_stats"`
+}
+
+// rabbitNode is the subset of one /api/nodes entry we use.
+type rabbitNode struct {
+	Name        string  `json:"name"`
+	Running     bool    `json:"running"`
+	MemUsed     float64 `json:"mem_used"`
+	MemLimit    float64 `json:"mem_limit"`
+	MemAlarm    bool    `json:"mem_alarm"`
+	DiskFree    float64 `json:"disk_free"`
+	DiskAlarm   bool    `json:"disk_free_alarm"`
+	FDUsed      float64 `json:"fd_used"`
+	SocketsUsed float64 `json:"sockets_used"`
+}
+
+// rabbitQueue is the subset of one /api/queues entry we use.
+type rabbitQueue struct {
+	Name            string             `json:"name"`
+	VHost           string             `json:"vhost"`
+	Messages        float64            `json:"messages"`
+	MessagesReady   float64            `json:"messages_ready"`
+	MessagesUnacked float64            `json:"messages_unacknowledged"`
+	Consumers       float64            `json:"consumers"`
+	MessageStats    rabbitMessageStats `json:"message_stats"`
+}
+
+// NewRabbitMQCollector creates a new RabbitMQ collector.
+func NewRabbitMQCollector(hostname, apiURL, user, password string) *RabbitMQCollector {
+	return &RabbitMQCollector{
+		hostname: hostname,
+		apiURL:   strings.TrimSuffix(apiURL, "/"),
+		user:     user,
+		password: password,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// Name returns the collector name.
+func (c *RabbitMQCollector) Name() string {
+	return "rabbitmq"
+}
+
+// Collect gathers RabbitMQ metrics from the management API.
+func (c *RabbitMQCollector) Collect(ctx context.Context) ([]metrics.Metric, error) {
+	now := time.Now()
+
+	var result []metrics.Metric
+	add := func(name string, metricType metrics.MetricType, value float64, labels map[string]string, unit string) {
+		result = append(result, metrics.Metric{
+			Name:      name,
+			Type:      metricType,
+			Value:     value,
+			Timestamp: now,
+			Hostname:  c.hostname,
+			Labels:    labels,
+			Unit:      unit,
+		})
+	}
+	addMessageStats := func(prefix string, s rabbitMessageStats, labels map[string]string) {
+		if s.Publish != nil {
+			add(prefix+"_published_total", metrics.MetricTypeCounter, *s.Publish, labels, "")
+			add(prefix+"_publish_rate", metrics.MetricTypeGauge, s.PublishDetails.Rate, labels, "per_second")
+		}
+		if s.DeliverGet != nil {
+			add(prefix+"_delivered_total", metrics.MetricTypeCounter, *s.DeliverGet, labels, "")
+			add(prefix+"_deliver_rate", metrics.MetricTypeGauge, s.DeliverGetDetails.Rate, labels, "per_second")
+		}
+	}
+
+	var overview rabbitOverview
+	if err := c.get(ctx, "/api/overview", &overview); err != nil {
+		return nil, err
+	}
+	add("rabbitmq_connections", metrics.MetricTypeGauge, overview.ObjectTotals.Connections, nil, "")
+	add("rabbitmq_channels", metrics.MetricTypeGauge, overview.ObjectTotals.Channels, nil, "")
+	add("rabbitmq_queues", metrics.MetricTypeGauge, overview.ObjectTotals.Queues, nil, "")
+	add("rabbitmq_consumers", metrics.MetricTypeGauge, overview.ObjectTotals.Consumers, nil, "")
+	add("rabbitmq_messages", metrics.MetricTypeGauge, overview.QueueTotals.Messages, nil, "")
+	add("rabbitmq_messages_ready", metrics.MetricTypeGauge, overview.QueueTotals.MessagesReady, nil, "")
+	add("rabbitmq_messages_unacked", metrics.MetricTypeGauge, overview.QueueTotals.MessagesUnacked, nil, "")
+	addMessageStats("rabbitmq", overview.MessageStats, nil)
+
+	// Node and queue details are best effort; the overview is already useful
+	var nodes []rabbitNode
+	if err := c.get(ctx, "/api/nodes", &nodes); err == nil {
+		for _, n := range nodes {
+			labels := map[string]string{"node": n.Name}
+			add("rabbitmq_node_running", metrics.MetricTypeGauge, boolValue(n.Running), labels, "")
+			if !n.Running {
+				continue
+			}
+			add("rabbitmq_node_memory_used_bytes", metrics.MetricTypeGauge, n.MemUsed, labels, "bytes")
+			add("rabbitmq_node_memory_limit_bytes", metrics.MetricTypeGauge, n.MemLimit, labels, "bytes")
+			add("rabbitmq_node_memory_alarm", metrics.MetricTypeGauge, boolValue(n.MemAlarm), labels, "")
+			add("rabbitmq_node_disk_free_bytes", metrics.MetricTypeGauge, n.DiskFree, labels, "bytes")
+			add("rabbitmq_node_disk_alarm", metrics.MetricTypeGauge, boolValue(n.DiskAlarm), labels, "")
+			add("rabbitmq_node_fds_used", metrics.MetricTypeGauge, n.FDUsed, labels, "")
+			add("rabbitmq_node_sockets_used", metrics.MetricTypeGauge, n.SocketsUsed, labels, "")
+		}
+	}
+
+	var queues []rabbitQueue
+	if err := c.get(ctx, "/api/queues", &queues); err == nil {
+		for _, q := range queues {
+			labels := map[string]string{"vhost": q.VHost, "queue": q.Name}
+			add("rabbitmq_queue_messages", metrics.MetricTypeGauge, q.Messages, labels, "")
+			add("rabbitmq_queue_messages_ready", metrics.MetricTypeGauge, q.MessagesReady, labels, "")
+			add("rabbitmq_queue_messages_unacked", metrics.MetricTypeGauge, q.MessagesUnacked, labels, "")
+			add("rabbitmq_queue_consumers", metrics.MetricTypeGauge, q.Consumers, labels, "")
+			addMessageStats("rabbitmq_queue", q.MessageStats, labels)
+		}
+	}
+
+	return result, nil
+}
+
+// get fetches one management API endpoint and decodes its JSON into v.
+func (c *RabbitMQCollector) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.apiURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.SetBasicAuth(c.user, c.password)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: unexpected status code: %d", path, resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,446 @@
+package collector
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bellistech/metrics-system/pkg/metrics"
+)
+
+// Register raid collector factory on package init
+func init() {
+	RegisterFactory("raid", func(cfg CollectorConfig) Collector {
+		tool := "storcli64"
+		if path, ok := cfg.Options["raid_tool"]; ok {
+			tool = path
+		}
+		return NewRAIDCollector(cfg.Hostname, tool)
+	})
+}
+
+// RAIDCollector collects hardware RAID controller state by shelling out to a
+// vendor CLI: storcli or perccli (JSON output), or the older MegaCli (text).
+// It reports virtual-disk and physical-disk states, battery/capacitor
+// health and rebuild progress.
+type RAIDCollector struct {
+	hostname string
+	tool     string
+	megacli  bool // MegaCli has no JSON output and different commands
+}
+
+// raidVirtualDisk is one virtual disk (logical drive).
+type raidVirtualDisk struct {
+	controller string
+	id         string
+	level      string
+	state      string
+	healthy    bool
+}
+
+// raidPhysicalDisk is one physical disk in an enclosure.
+type raidPhysicalDisk struct {
+	controller string
+	enclosure  string
+	slot       string
+	state      string
+	healthy    bool
+	rebuilding bool
+}
+
+// raidBattery is a controller's battery (BBU) or supercapacitor (CacheVault).
+type raidBattery struct {
+	controller string
+	kind       string // "bbu" or "cachevault"
+	state      string
+	healthy    bool
+}
+
+// raidInventory is everything one run of the CLI reported.
+type raidInventory struct {
+	virtualDisks  []raidVirtualDisk
+	physicalDisks []raidPhysicalDisk
+	batteries     []raidBattery
+	rebuild       map[string]float64 // "controller/enclosure/slot" -> percent done
+}
+
+// NewRAIDCollector creates a new RAID collector. tool is the path to
+// storcli, perccli or MegaCli; MegaCli is recognized by its file name.
+func NewRAIDCollector(hostname, tool string) *RAIDCollector {
+	return &RAIDCollector{
+		hostname: hostname,
+		tool:     tool,
+		megacli:  strings.Contains(strings.ToLower(filepath.Base(tool)), "megacli"),
+	}
+}
+
+// Name returns the collector name.
+func (c *RAIDCollector) Name() string {
+	return "raid"
+}
+
+// Collect gathers RAID metrics for every controller.
+func (c *RAIDCollector) Collect(ctx context.Context) ([]metrics.Metric, error) {
+	now := time.Now()
+
+	var inv raidInventory
+	var err error
+	if c.megacli {
+		inv, err = c.collectMegaCli(ctx)
+	} else {
+		inv, err = c.collectStorcli(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var result []metrics.Metric
+	add := func(name string, value float64, labels map[string]string, unit string) {
+		result = append(result, metrics.Metric{
+			Name:      name,
+			Type:      metrics.MetricTypeGauge,
+			Value:     value,
+			Timestamp: now,
+			Hostname:  c.hostname,
+			Labels:    labels,
+			Unit:      unit,
+		})
+	}
+
+	for _, vd := range inv.virtualDisks {
+		add("raid_virtual_disk_healthy", boolValue(vd.healthy), map[string]string{
+			"controller": vd.controller, "virtual_disk": vd.id, "raid_level": vd.level, "state": vd.state,
+		}, "")
+	}
+
+	for _, pd := range inv.physicalDisks {
+		labels := map[string]string{"controller": pd.controller, "enclosure": pd.enclosure, "slot": pd.slot}
+		add("raid_physical_disk_healthy", boolValue(pd.healthy), map[string]string{
+			"controller": pd.controller, "enclosure": pd.enclosure, "slot": pd.slot, "state": pd.state,
+		}, "")
+		add("raid_physical_disk_rebuilding", boolValue(pd.rebuilding), labels, "")
+		if pct, ok := inv.rebuild[pd.controller+"/"+pd.enclosure+"/"+pd.slot]; ok {
+			add("raid_physical_disk_rebuild_percent", pct, labels, "percent")
+		}
+	}
+
+	for _, b := range inv.batteries {
+		add("raid_battery_healthy", boolValue(b.healthy), map[string]string{
+			"controller": b.controller, "type": b.kind, "state": b.state,
+		}, "")
+	}
+
+	return result, nil
+}
+
+// =============================================================================
+// storcli / perccli
+// =============================================================================
+
+// storcliOutput is the envelope of every storcli "J" (JSON) response.
+type storcliOutput struct {
+	Controllers []struct {
+		CommandStatus struct {
+			Controller json.Number `json:"Controller"`
+			Status     string      `json:"Status"`
+		} `json:"Command Status"`
+		ResponseData json.RawMessage `json:"Response Data"`
+	} `json:"Controllers"`
+}
+
+// storcliControllerData is the part of `/cX show all J` we use.
+type storcliControllerData struct {
+	VDList []struct {
+		DGVD  string `json:"DG/VD"`
+		Type  string `json:"TYPE"`
+		State string `json:"State"`
+	} `json:"VD LIST"`
+	PDList []struct {
+		EIDSlt string `json:"EID:Slt"`
+		State  string `json:"State"`
+	} `json:"PD LIST"`
+	BBUInfo []struct {
+		State string `json:"State"`
+	} `json:"BBU_Info"`
+	CachevaultInfo []struct {
+		State string `json:"State"`
+	} `json:"Cachevault_Info"`
+}
+
+// storcliRebuild is one entry of `/call/eall/sall show rebuild J`.
+type storcliRebuild struct {
+	DriveID  string `json:"Drive-ID"` // "/c0/e252/s1"
+	Progress string `json:"Progress%"`
+	Status   string `json:"Status"`
+}
+
+// collectStorcli runs storcli/perccli and parses its JSON output.
+func (c *RAIDCollector) collectStorcli(ctx context.Context) (raidInventory, error) {
+	inv := raidInventory{rebuild: make(map[string]float64)}
+
+	out, err := exec.CommandContext(ctx, c.tool, "/call", "show", "all", "J").Output()
+	if err != nil {
+		return inv, fmt.Errorf("%s show all: %w", c.tool, err)
+	}
+	var all storcliOutput
+	if err := json.Unmarshal(out, &all); err != nil {
+		return inv, fmt.Errorf("%s show all: invalid JSON: %w", c.tool, err)
+	}
+
+	for _, ctrl := range all.Controllers {
+		if ctrl.CommandStatus.Status != "Success" {
+			continue
+		}
+		id := ctrl.CommandStatus.Controller.String()
+
+		var data storcliControllerData
+		if err := json.Unmarshal(ctrl.ResponseData, &data); err != nil {
+			continue
+		}
+
+		for _, vd := range data.VDList {
+			_, vdID, _ := strings.Cut(vd.DGVD, "/")
+			inv.virtualDisks = append(inv.virtualDisks, raidVirtualDisk{
+				controller: id,
+				id:         vdID,
+				level:      strings.ToLower(vd.Type),
+				state:      vd.State,
+				healthy:    vd.State == "Optl",
+			})
+		}
+
+		for _, pd := range data.PDList {
+			enclosure, slot, _ := strings.Cut(pd.EIDSlt, ":")
+			inv.physicalDisks = append(inv.physicalDisks, raidPhysicalDisk{
+				controller: id,
+				enclosure:  strings.TrimSpace(enclosure),
+				slot:       slot,
+				state:      pd.State,
+				healthy:    storcliHealthyDrive(pd.State),
+				rebuilding: pd.State == "Rbld",
+			})
+		}
+
+		for _, b := range data.BBUInfo {
+			inv.batteries = append(inv.batteries, raidBattery{controller: id, kind: "bbu", state: b.State, healthy: b.State == "Optimal"})
+		}
+		for _, cv := range data.CachevaultInfo {
+			inv.batteries = append(inv.batteries, raidBattery{controller: id, kind: "cachevault", state: cv.State, healthy: cv.State == "Optimal"})
+		}
+	}
+
+	// Rebuild progress is a separate command; only worth running mid-rebuild
+	rebuilding := false
+	for _, pd := range inv.physicalDisks {
+		rebuilding = rebuilding || pd.rebuilding
+	}
+	if !rebuilding {
+		return inv, nil
+	}
+
+	out, err = exec.CommandContext(ctx, c.tool, "/call/eall/sall", "show", "rebuild", "J").Output()
+	if err != nil {
+		return inv, nil // Drive states are still useful without progress
+	}
+	var rebuild storcliOutput
+	if err := json.Unmarshal(out, &rebuild); err != nil {
+		return inv, nil
+	}
+	for _, ctrl := range rebuild.Controllers {
+		var drives []storcliRebuild
+		if err := json.Unmarshal(ctrl.ResponseData, &drives); err != nil {
+			continue
+		}
+		for _, d := range drives {
+			pct, err := strconv.ParseFloat(d.Progress, 64)
+			if err != nil {
+				continue // "-" for drives that aren't rebuilding
+			}
+			// "/c0/e252/s1" -> "0/252/1"
+			parts := strings.Split(strings.TrimPrefix(d.DriveID, "/"), "/")
+			if len(parts) != 3 {
+				continue
+			}
+			key := strings.TrimPrefix(parts[0], "c") + "/" + strings.TrimPrefix(parts[1], "e") + "/" + strings.TrimPrefix(parts[2], "s")
+			inv.rebuild[key] = pct
+		}
+	}
+
+	return inv, nil
+}
+
+// storcliHealthyDrive reports whether a storcli drive state is healthy:
+// online, unconfigured good, a hot spare, or passed through as JBOD.
+func storcliHealthyDrive(state string) bool {
+	switch state {
+	case "Onln", "UGood", "GHS", "DHS", "JBOD":
+		return true
+	}
+	return false
+}
+
+// =============================================================================
+// MegaCli
+// =============================================================================
+
+var megacliRebuildRe = regexp.MustCompile(`Completed (\d+)%`)
+
+// collectMegaCli runs MegaCli and parses its text output.
+func (c *RAIDCollector) collectMegaCli(ctx context.Context) (raidInventory, error) {
+	inv := raidInventory{rebuild: make(map[string]float64)}
+
+	out, err := exec.CommandContext(ctx, c.tool, "-LDInfo", "-Lall", "-aALL", "-NoLog").Output()
+	if err != nil {
+		return inv, fmt.Errorf("%s -LDInfo: %w", c.tool, err)
+	}
+	inv.virtualDisks = parseMegaCliLDInfo(string(out))
+
+	out, err = exec.CommandContext(ctx, c.tool, "-PDList", "-aALL", "-NoLog").Output()
+	if err != nil {
+		return inv, fmt.Errorf("%s -PDList: %w", c.tool, err)
+	}
+	inv.physicalDisks = parseMegaCliPDList(string(out))
+
+	// Not every controller has a battery, so failures here are expected
+	if out, err := exec.CommandContext(ctx, c.tool, "-AdpBbuCmd", "-GetBbuStatus", "-aALL", "-NoLog").Output(); err == nil {
+		inv.batteries = parseMegaCliBBU(string(out))
+	}
+
+	for _, pd := range inv.physicalDisks {
+		if !pd.rebuilding {
+			continue
+		}
+		drive := fmt.Sprintf("-PhysDrv[%s:%s]", pd.enclosure, pd.slot)
+		out, err := exec.CommandContext(ctx, c.tool, "-PDRbld", "-ShowProg", drive, "-a"+pd.controller, "-NoLog").Output()
+		if err != nil {
+			continue
+		}
+		// "Rebuild Progress on Device at Enclosure 252, Slot 1 Completed 45% in 12 Minutes."
+		if m := megacliRebuildRe.FindStringSubmatch(string(out)); m != nil {
+			pct, _ := strconv.ParseFloat(m[1], 64)
+			inv.rebuild[pd.controller+"/"+pd.enclosure+"/"+pd.slot] = pct
+		}
+	}
+
+	return inv, nil
+}
+
+// megacliFields calls fn with the adapter number and each "Key : Value"
+// line of MegaCli output, tracking "Adapter #N" / "Adapter N" headings.
+func megacliFields(output string, fn func(adapter, key, value string)) {
+	adapter := "0"
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		// "Adapter #0" (PDList) or "Adapter 0 -- Virtual Drive Information:"
+		if strings.HasPrefix(line, "Adapter ") && (!strings.Contains(line, ":") || strings.Contains(line, "--")) {
+			adapter = strings.TrimPrefix(strings.Fields(line)[1], "#")
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fn(adapter, strings.TrimSpace(key), strings.TrimSpace(value))
+	}
+}
+
+// parseMegaCliLDInfo parses `MegaCli -LDInfo -Lall -aALL`:
+//
+//	Virtual Drive: 0 (Target Id: 0)
+//	RAID Level          : Primary-1, Secondary-0, RAID Level Qualifier-0
+//	State               : Optimal
+func parseMegaCliLDInfo(output string) []raidVirtualDisk {
+	var disks []raidVirtualDisk
+	megacliFields(output, func(adapter, key, value string) {
+		switch key {
+		case "Virtual Drive", "Virtual Disk":
+			id := strings.Fields(value)
+			if len(id) > 0 {
+				disks = append(disks, raidVirtualDisk{controller: adapter, id: id[0]})
+			}
+		case "RAID Level":
+			if n := len(disks); n > 0 {
+				// "Primary-1, Secondary-0, ..." -> "raid1"
+				primary, _, _ := strings.Cut(value, ",")
+				disks[n-1].level = "raid" + strings.TrimPrefix(primary, "Primary-")
+			}
+		case "State":
+			if n := len(disks); n > 0 {
+				disks[n-1].state = value
+				disks[n-1].healthy = value == "Optimal"
+			}
+		}
+	})
+	return disks
+}
+
+// parseMegaCliPDList parses `MegaCli -PDList -aALL`:
+//
+//	Enclosure Device ID: 252
+//	Slot Number: 0
+//	Firmware state: Online, Spun Up
+func parseMegaCliPDList(output string) []raidPhysicalDisk {
+	var disks []raidPhysicalDisk
+	megacliFields(output, func(adapter, key, value string) {
+		switch key {
+		case "Enclosure Device ID":
+			disks = append(disks, raidPhysicalDisk{controller: adapter, enclosure: value})
+		case "Slot Number":
+			if n := len(disks); n > 0 {
+				disks[n-1].slot = value
+			}
+		case "Firmware state":
+			if n := len(disks); n > 0 {
+				state, _, _ := strings.Cut(value, ",")
+				disks[n-1].state = state
+				disks[n-1].rebuilding = state == "Rebuild"
+				switch state {
+				case "Online", "Unconfigured(good)", "Hotspare", "JBOD":
+					disks[n-1].healthy = true
+				}
+			}
+		}
+	})
+	return disks
+}
+
+// parseMegaCliBBU parses `MegaCli -AdpBbuCmd -GetBbuStatus -aALL`:
+//
+//	BBU status for Adapter: 0
+//	BatteryType: CVPM02
+//	Battery State: Optimal
+func parseMegaCliBBU(output string) []raidBattery {
+	var batteries []raidBattery
+	controller, kind := "0", "bbu"
+	megacliFields(output, func(adapter, key, value string) {
+		switch key {
+		case "BBU status for Adapter":
+			controller, kind = value, "bbu"
+		case "BatteryType":
+			if strings.HasPrefix(value, "CVPM") || strings.HasPrefix(value, "SuperCaP") {
+				kind = "cachevault"
+			}
+		case "Battery State":
+			batteries = append(batteries, raidBattery{controller: controller, kind: kind, state: value, healthy: value == "Optimal"})
+		}
+	})
+	return batteries
+}
+
+// boolValue converts a bool to a 1/0 metric value.
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
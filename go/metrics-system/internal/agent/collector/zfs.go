@@ -0,0 +1,214 @@
+package collector
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bellistech/metrics-system/pkg/metrics"
+)
+
+// Register zfs collector factory on package init
+func init() {
+	RegisterFactory("zfs", func(cfg CollectorConfig) Collector {
+		zpoolPath := "zpool"
+		if path, ok := cfg.Options["zpool_path"]; ok {
+			zpoolPath = path
+		}
+		return NewZFSCollector(cfg.Hostname, zpoolPath)
+	})
+}
+
+// ZFSCollector collects ZFS pool health, capacity, scrub/resilver progress
+// and per-vdev error counts by parsing `zpool list` and `zpool status`.
+type ZFSCollector struct {
+	hostname  string
+	zpoolPath string
+}
+
+// zpoolScan is the state of a pool's last or current scrub/resilver.
+type zpoolScan struct {
+	kind       string // "scrub" or "resilver"; empty if the pool was never scanned
+	inProgress bool
+	percent    float64
+}
+
+// zpoolVdevErrors holds the READ/WRITE/CKSUM columns of one `zpool status` config row.
+type zpoolVdevErrors struct {
+	vdev     string
+	read     float64
+	write    float64
+	checksum float64
+}
+
+// NewZFSCollector creates a new ZFS collector.
+func NewZFSCollector(hostname, zpoolPath string) *ZFSCollector {
+	return &ZFSCollector{
+		hostname:  hostname,
+		zpoolPath: zpoolPath,
+	}
+}
+
+// Name returns the collector name.
+func (c *ZFSCollector) Name() string {
+	return "zfs"
+}
+
+// Collect gathers metrics for every imported pool.
+func (c *ZFSCollector) Collect(ctx context.Context) ([]metrics.Metric, error) {
+	now := time.Now()
+
+	// -H: no header, tab separated; -p: exact (parsable) numbers
+	out, err := exec.CommandContext(ctx, c.zpoolPath, "list", "-Hp", "-o", "name,size,alloc,free,frag,cap,health").Output()
+	if err != nil {
+		return nil, fmt.Errorf("zpool list: %w", err)
+	}
+
+	var result []metrics.Metric
+	add := func(name string, metricType metrics.MetricType, value float64, labels map[string]string, unit string) {
+		result = append(result, metrics.Metric{
+			Name:      name,
+			Type:      metricType,
+			Value:     value,
+			Timestamp: now,
+			Hostname:  c.hostname,
+			Labels:    labels,
+			Unit:      unit,
+		})
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			continue
+		}
+		pool, health := fields[0], fields[6]
+		labels := map[string]string{"pool": pool}
+
+		add("zfs_pool_size_bytes", metrics.MetricTypeGauge, parseZpoolNumber(fields[1]), labels, "bytes")
+		add("zfs_pool_allocated_bytes", metrics.MetricTypeGauge, parseZpoolNumber(fields[2]), labels, "bytes")
+		add("zfs_pool_free_bytes", metrics.MetricTypeGauge, parseZpoolNumber(fields[3]), labels, "bytes")
+		add("zfs_pool_capacity_percent", metrics.MetricTypeGauge, parseZpoolNumber(fields[5]), labels, "percent")
+		// Fragmentation is "-" for pools that can't report it
+		if fields[4] != "-" {
+			add("zfs_pool_fragmentation_percent", metrics.MetricTypeGauge, parseZpoolNumber(fields[4]), labels, "percent")
+		}
+
+		healthy := 0.0
+		if health == "ONLINE" {
+			healthy = 1
+		}
+		add("zfs_pool_healthy", metrics.MetricTypeGauge, healthy, map[string]string{"pool": pool, "health": health}, "")
+
+		status, err := exec.CommandContext(ctx, c.zpoolPath, "status", "-p", pool).Output()
+		if err != nil {
+			continue // Capacity and health are still useful on their own
+		}
+		scan, vdevs, dataErrors := parseZpoolStatus(string(status))
+
+		if scan.kind != "" {
+			scanLabels := map[string]string{"pool": pool, "scan": scan.kind}
+			inProgress := 0.0
+			if scan.inProgress {
+				inProgress = 1
+			}
+			add("zfs_pool_scan_in_progress", metrics.MetricTypeGauge, inProgress, scanLabels, "")
+			add("zfs_pool_scan_progress_percent", metrics.MetricTypeGauge, scan.percent, scanLabels, "percent")
+		}
+
+		add("zfs_pool_data_errors", metrics.MetricTypeGauge, dataErrors, labels, "")
+
+		for _, v := range vdevs {
+			vdevLabels := map[string]string{"pool": pool, "vdev": v.vdev}
+			add("zfs_vdev_read_errors_total", metrics.MetricTypeCounter, v.read, vdevLabels, "")
+			add("zfs_vdev_write_errors_total", metrics.MetricTypeCounter, v.write, vdevLabels, "")
+			add("zfs_vdev_checksum_errors_total", metrics.MetricTypeCounter, v.checksum, vdevLabels, "")
+		}
+	}
+
+	return result, nil
+}
+
+// parseZpoolStatus extracts scrub/resilver state, per-vdev error counts and
+// the number of permanent data errors from `zpool status -p <pool>` output:
+//
+//	  scan: scrub in progress since Sun Jul 25 16:07:49 2021
+//	        1.23T scanned at 1.2G/s, 500G issued at 500M/s, 2.00T total
+//	        0B repaired, 25.00% done, 00:30:00 to go
+//	config:
+//
+//	        NAME        STATE     READ WRITE CKSUM
+//	        tank        ONLINE       0     0     0
+//	          mirror-0  ONLINE       0     0     0
+//	            sda     ONLINE       0     0     0
+//
+//	errors: No known data errors
+func parseZpoolStatus(output string) (scan zpoolScan, vdevs []zpoolVdevErrors, dataErrors float64) {
+	inConfig := false
+	scanner := bufio.NewScanner(strings.NewReader(output))
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case strings.HasPrefix(line, "scan:"):
+			desc := strings.TrimSpace(strings.TrimPrefix(line, "scan:"))
+			switch {
+			case strings.HasPrefix(desc, "scrub"):
+				scan.kind = "scrub"
+			case strings.HasPrefix(desc, "resilver"):
+				scan.kind = "resilver"
+			}
+			scan.inProgress = strings.Contains(desc, "in progress")
+			if scan.kind != "" && !scan.inProgress && !strings.Contains(desc, "canceled") {
+				scan.percent = 100 // Finished
+			}
+
+		case scan.inProgress && strings.Contains(line, "% done"):
+			// "0B repaired, 25.00% done, 00:30:00 to go"
+			for _, part := range strings.Split(line, ",") {
+				part = strings.TrimSpace(part)
+				if strings.HasSuffix(part, "% done") {
+					scan.percent, _ = strconv.ParseFloat(strings.TrimSuffix(part, "% done"), 64)
+				}
+			}
+
+		case strings.HasPrefix(line, "NAME") && strings.Contains(line, "CKSUM"):
+			inConfig = true
+
+		case strings.HasPrefix(line, "errors:"):
+			inConfig = false
+			// "errors: No known data errors" or "errors: 3 data errors, use '-v' for a list"
+			fields := strings.Fields(strings.TrimPrefix(line, "errors:"))
+			if len(fields) > 0 {
+				dataErrors, _ = strconv.ParseFloat(fields[0], 64)
+			}
+
+		case inConfig && line != "":
+			fields := strings.Fields(line)
+			if len(fields) < 5 {
+				continue // Section headings like "logs" or "spares" have no counters
+			}
+			read, err1 := strconv.ParseFloat(fields[2], 64)
+			write, err2 := strconv.ParseFloat(fields[3], 64)
+			checksum, err3 := strconv.ParseFloat(fields[4], 64)
+			if err1 != nil || err2 != nil || err3 != nil {
+				continue
+			}
+			vdevs = append(vdevs, zpoolVdevErrors{vdev: fields[0], read: read, write: write, checksum: checksum})
+		}
+	}
+
+	return scan, vdevs, dataErrors
+}
+
+// parseZpoolNumber parses a `zpool list -p` value, ignoring a trailing "%"
+// printed by older releases.
+func parseZpoolNumber(s string) float64 {
+	v, _ := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	return v
+}
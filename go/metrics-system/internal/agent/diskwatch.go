@@ -0,0 +1,215 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bellistech/metrics-system/internal/agent/collector"
+	"github.com/bellistech/metrics-system/internal/config"
+	"github.com/bellistech/metrics-system/internal/logger"
+	"github.com/bellistech/metrics-system/pkg/metrics"
+)
+
+// Disk event metric names. Each is 1 while the condition holds and 0 once it
+// has cleared, labeled with the mount point.
+const (
+	DiskEventFull     = "disk_event_full"
+	DiskEventReadOnly = "disk_event_readonly"
+)
+
+// diskState is what the watcher last saw of a filesystem.
+type diskState struct {
+	full     bool
+	readOnly bool
+}
+
+// DiskWatcher reports a filesystem filling up or turning read-only as soon
+// as it happens, instead of at the next collection. Mount points are checked
+// with statfs whenever inotify sees a write in one of the watched
+// directories, whenever the mount table changes (a remount), and every check
+// interval regardless, since inotify doesn't see writes deeper in the tree.
+// Only changes are reported: crossing the usage threshold or turning
+// read-only, and clearing again.
+type DiskWatcher struct {
+	hostname   string
+	mounts     []string
+	watchPaths []string
+	threshold  float64
+	interval   time.Duration
+
+	state map[string]diskState // mount point -> last seen; only touched by Run
+}
+
+// NewDiskWatcher creates the disk event watcher. It returns nil when disk
+// events are disabled.
+func NewDiskWatcher(cfg config.DiskEventsConfig, hostname string) (*DiskWatcher, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if !diskWatchSupported {
+		return nil, fmt.Errorf("disk events need Linux (inotify and statfs)")
+	}
+	if cfg.UsagePercent <= 0 || cfg.UsagePercent > 100 {
+		return nil, fmt.Errorf("usage_percent must be between 0 and 100")
+	}
+	if cfg.CheckInterval <= 0 {
+		return nil, fmt.Errorf("check_interval must be positive")
+	}
+
+	w := &DiskWatcher{
+		hostname:   hostname,
+		mounts:     cfg.MountPoints,
+		watchPaths: cfg.WatchPaths,
+		threshold:  cfg.UsagePercent,
+		interval:   cfg.CheckInterval,
+		state:      make(map[string]diskState),
+	}
+	if len(w.mounts) == 0 {
+		w.mounts, _ = collector.GetMountPoints()
+	}
+	if len(w.watchPaths) == 0 {
+		w.watchPaths = w.mounts
+	}
+	return w, nil
+}
+
+// MountPoints returns the mount points being watched.
+func (w *DiskWatcher) MountPoints() []string {
+	if w == nil {
+		return nil
+	}
+	return w.mounts
+}
+
+// Run watches the mount points until ctx is done, calling send with the
+// metrics of every change. send is called from Run's goroutine, so a
+// collection cycle in progress doesn't hold events back.
+func (w *DiskWatcher) Run(ctx context.Context, send func([]metrics.Metric)) {
+	if w == nil {
+		return
+	}
+
+	// A filesystem that is already full is reported straight away; one
+	// that is already read-only is assumed to be meant that way
+	w.check(w.mounts, send, true)
+
+	// Mount points to check now; "" checks all of them. The buffer
+	// coalesces bursts of inotify events into one check per mount point.
+	trigger := make(chan string, len(w.mounts)+1)
+	go w.watchWrites(ctx, trigger)
+	go w.watchMountTable(ctx, trigger)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.check(w.mounts, send, false)
+		case mount := <-trigger:
+			if mount == "" {
+				w.check(w.mounts, send, false)
+			} else {
+				w.check([]string{mount}, send, false)
+			}
+		}
+	}
+}
+
+// check statfs's the mount points and sends a metric for every change since
+// the last check.
+func (w *DiskWatcher) check(mounts []string, send func([]metrics.Metric), first bool) {
+	now := time.Now()
+	var events []metrics.Metric
+	for _, mount := range mounts {
+		usedPercent, readOnly, err := statMount(mount)
+		if err != nil {
+			logger.Debug("Disk events: %v", err)
+			continue
+		}
+		full := usedPercent >= w.threshold
+
+		prev, seen := w.state[mount]
+		if !seen {
+			prev = diskState{readOnly: readOnly}
+		}
+		w.state[mount] = diskState{full: full, readOnly: readOnly}
+
+		labels := map[string]string{"mountpoint": mount}
+		if full != prev.full || (first && full) {
+			if full {
+				logger.Warn("Disk events: %s is %.1f%% full (threshold %.0f%%)", mount, usedPercent, w.threshold)
+			} else {
+				logger.Info("Disk events: %s is back below %.0f%% (%.1f%%)", mount, w.threshold, usedPercent)
+			}
+			events = append(events,
+				w.metric(DiskEventFull, full, labels, now),
+				metrics.Metric{
+					Name:      "disk_used_percent",
+					Type:      metrics.MetricTypeGauge,
+					Value:     usedPercent,
+					Timestamp: now,
+					Hostname:  w.hostname,
+					Labels:    labels,
+					Unit:      "percent",
+				})
+		}
+		if readOnly != prev.readOnly {
+			if readOnly {
+				logger.Warn("Disk events: %s turned read-only", mount)
+			} else {
+				logger.Info("Disk events: %s is writable again", mount)
+			}
+			events = append(events, w.metric(DiskEventReadOnly, readOnly, labels, now))
+		}
+	}
+
+	if len(events) > 0 {
+		send(events)
+	}
+}
+
+// metric builds a disk event metric.
+func (w *DiskWatcher) metric(name string, on bool, labels map[string]string, ts time.Time) metrics.Metric {
+	value := 0.0
+	if on {
+		value = 1
+	}
+	return metrics.Metric{
+		Name:      name,
+		Type:      metrics.MetricTypeGauge,
+		Value:     value,
+		Timestamp: ts,
+		Hostname:  w.hostname,
+		Labels:    labels,
+	}
+}
+
+// mountOf returns the watched mount point a path is on: the longest one
+// that contains it.
+func (w *DiskWatcher) mountOf(path string) string {
+	best := ""
+	for _, mount := range w.mounts {
+		if len(mount) <= len(best) {
+			continue
+		}
+		if mount == "/" || path == mount || strings.HasPrefix(path, mount+string(filepath.Separator)) {
+			best = mount
+		}
+	}
+	return best
+}
+
+// triggerCheck asks Run to check a mount point. With the queue full the
+// request is dropped: checks are already waiting, and the next tick catches
+// anything they miss.
+func triggerCheck(trigger chan<- string, mount string) {
+	select {
+	case trigger <- mount:
+	default:
+	}
+}
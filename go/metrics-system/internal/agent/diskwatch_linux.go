@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"github.com/bellistech/metrics-system/internal/logger"
+	"golang.org/x/sys/unix"
+)
+
+const diskWatchSupported = true
+
+// diskWatchMask is the inotify events that may mean a filesystem is filling
+// up: files created, written to or moved in.
+const diskWatchMask = unix.IN_CREATE | unix.IN_MODIFY | unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO
+
+// statMount returns how full a filesystem is, the way the disk collector
+// computes disk_used_percent, and whether it is mounted read-only.
+func statMount(mount string) (usedPercent float64, readOnly bool, err error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(mount, &st); err != nil {
+		return 0, false, fmt.Errorf("statfs %s: %w", mount, err)
+	}
+	if st.Blocks > 0 {
+		usedPercent = float64(st.Blocks-st.Bfree) / float64(st.Blocks) * 100
+	}
+	return usedPercent, st.Flags&unix.ST_RDONLY != 0, nil
+}
+
+// watchWrites asks for a check of a mount point whenever inotify sees a
+// write in one of the watched directories on it.
+func (w *DiskWatcher) watchWrites(ctx context.Context, trigger chan<- string) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		logger.Warn("Disk events: inotify unavailable, checking every %s only: %v", w.interval, err)
+		return
+	}
+	// Non-blocking, so reads go through the runtime poller and Close
+	// interrupts them
+	f := os.NewFile(uintptr(fd), "inotify")
+	defer f.Close()
+
+	mounts := make(map[int32]string) // watch descriptor -> mount point
+	for _, path := range w.watchPaths {
+		path = filepath.Clean(path)
+		mount := w.mountOf(path)
+		if mount == "" {
+			logger.Warn("Disk events: %s is not on a watched mount point", path)
+			continue
+		}
+		wd, err := unix.InotifyAddWatch(fd, path, diskWatchMask)
+		if err != nil {
+			logger.Warn("Disk events: can't watch %s: %v", path, err)
+			continue
+		}
+		mounts[int32(wd)] = mount
+	}
+	if len(mounts) == 0 {
+		return
+	}
+
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Warn("Disk events: inotify read failed, checking every %s only: %v", w.interval, err)
+			}
+			return
+		}
+
+		// One check per mount point for the whole batch of events
+		touched := make(map[string]bool)
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			if mount, ok := mounts[event.Wd]; ok && event.Mask&diskWatchMask != 0 {
+				touched[mount] = true
+			}
+			off += unix.SizeofInotifyEvent + int(event.Len)
+		}
+		for mount := range touched {
+			triggerCheck(trigger, mount)
+		}
+	}
+}
+
+// watchMountTable asks for a check of every mount point whenever the kernel
+// flags /proc/self/mounts with POLLPRI: on mounts and unmounts, and on most
+// (not all) remounts, such as a filesystem turned read-only after I/O
+// errors. The check interval catches the remounts this misses.
+func (w *DiskWatcher) watchMountTable(ctx context.Context, trigger chan<- string) {
+	f, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		logger.Warn("Disk events: can't watch the mount table: %v", err)
+		return
+	}
+	defer f.Close()
+
+	fds := []unix.PollFd{{Fd: int32(f.Fd()), Events: unix.POLLPRI}}
+	for ctx.Err() == nil {
+		// Wake up every second to notice ctx being done
+		n, err := unix.Poll(fds, 1000)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			logger.Warn("Disk events: watching the mount table failed: %v", err)
+			return
+		}
+		if n > 0 && fds[0].Revents&(unix.POLLPRI|unix.POLLERR) != 0 {
+			triggerCheck(trigger, "")
+		}
+	}
+}
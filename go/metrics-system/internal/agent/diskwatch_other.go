@@ -0,0 +1,20 @@
+//go:build !linux
+
+package agent
+
+import (
+	"context"
+	"fmt"
+)
+
+// Disk events need inotify and Linux's statfs flags; NewDiskWatcher refuses
+// to create a watcher elsewhere.
+const diskWatchSupported = false
+
+func statMount(mount string) (float64, bool, error) {
+	return 0, false, fmt.Errorf("disk events are not supported on this platform")
+}
+
+func (w *DiskWatcher) watchWrites(ctx context.Context, trigger chan<- string) {}
+
+func (w *DiskWatcher) watchMountTable(ctx context.Context, trigger chan<- string) {}
@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// HandoffMaxAge is how old a handoff file may be and still be loaded. Older
+// state is from an agent that stopped long ago, not one being upgraded.
+const HandoffMaxAge = 10 * time.Minute
+
+// handoffState is what a stopping agent leaves for the next one, so counter
+// rates carry on across an upgrade instead of restarting with a gap.
+type handoffState struct {
+	SavedAt  time.Time                 `json:"saved_at"`
+	Counters map[string]handoffCounter `json:"counters"`
+}
+
+// handoffCounter is the last sample of one counter series.
+type handoffCounter struct {
+	Value     float64   `json:"value"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// SaveHandoff writes the rate calculator's last counter samples to path,
+// replacing it atomically. It does nothing when rates are disabled.
+func SaveHandoff(path string, rates *RateCalculator) error {
+	if rates == nil {
+		return nil
+	}
+
+	state := handoffState{SavedAt: time.Now(), Counters: make(map[string]handoffCounter, len(rates.previous))}
+	for key, s := range rates.previous {
+		state.Counters[key] = handoffCounter{Value: s.value, Timestamp: s.timestamp}
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write handoff state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write handoff state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write handoff state: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadHandoff restores counter samples saved by SaveHandoff and removes the
+// file, so the state is only ever used once. It returns how many series were
+// restored; a missing file is not an error, and state older than maxAge is
+// discarded.
+func LoadHandoff(path string, rates *RateCalculator, maxAge time.Duration) (int, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read handoff state: %w", err)
+	}
+	os.Remove(path)
+
+	if rates == nil {
+		return 0, nil
+	}
+
+	var state handoffState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return 0, fmt.Errorf("failed to parse handoff state: %w", err)
+	}
+	if age := time.Since(state.SavedAt); age > maxAge {
+		return 0, fmt.Errorf("handoff state is %s old, ignoring it", age.Round(time.Second))
+	}
+
+	for key, c := range state.Counters {
+		rates.previous[key] = counterSample{value: c.Value, timestamp: c.Timestamp}
+	}
+	return len(state.Counters), nil
+}
+
+// Reexec replaces the running agent with the binary now installed at its
+// path, with the same arguments and environment. Every file descriptor is
+// close-on-exec, so nothing else is inherited. It only returns on failure.
+func Reexec() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find agent binary: %w", err)
+	}
+	if err := syscall.Exec(exe, os.Args, os.Environ()); err != nil {
+		return fmt.Errorf("failed to start %s: %w", exe, err)
+	}
+	return nil
+}
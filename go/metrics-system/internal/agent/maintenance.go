@@ -0,0 +1,210 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bellistech/metrics-system/internal/config"
+	"github.com/bellistech/metrics-system/pkg/metrics"
+)
+
+// MaintenanceLabel is the label attached to metrics collected during a
+// maintenance window whose action is "label".
+const MaintenanceLabel = "maintenance"
+
+// Maintenance window actions.
+const (
+	MaintenanceActionPause = "pause"
+	MaintenanceActionLabel = "label"
+)
+
+// maintenanceWindow is a parsed config.MaintenanceWindow.
+type maintenanceWindow struct {
+	name       string
+	days       map[time.Weekday]bool // nil means every day
+	start      time.Duration         // offset from midnight
+	end        time.Duration         // offset from midnight
+	location   *time.Location
+	collectors map[string]bool // nil means all collectors
+	action     string
+}
+
+// MaintenanceSchedule decides which collectors are paused or tagged at a given time.
+type MaintenanceSchedule struct {
+	windows []maintenanceWindow
+}
+
+// CollectionPlan is the outcome of applying the schedule to a list of collectors.
+type CollectionPlan struct {
+	// Run lists collectors to run normally.
+	Run []string
+	// Tagged maps collectors to run to the maintenance window whose name they are labeled with.
+	Tagged map[string]string
+	// Paused lists collectors skipped this cycle.
+	Paused []string
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// NewMaintenanceSchedule parses and validates maintenance window configuration.
+func NewMaintenanceSchedule(cfg config.MaintenanceConfig) (*MaintenanceSchedule, error) {
+	s := &MaintenanceSchedule{}
+
+	for i, wc := range cfg.Windows {
+		name := wc.Name
+		if name == "" {
+			name = fmt.Sprintf("window-%d", i+1)
+		}
+
+		w := maintenanceWindow{name: name, action: strings.ToLower(wc.Action)}
+		if w.action == "" {
+			w.action = MaintenanceActionLabel
+		}
+		if w.action != MaintenanceActionPause && w.action != MaintenanceActionLabel {
+			return nil, fmt.Errorf("maintenance window %s: unknown action %q (want pause or label)", name, wc.Action)
+		}
+
+		if err := w.parseSchedule(wc.Days, wc.Start, wc.End, wc.Timezone); err != nil {
+			return nil, fmt.Errorf("maintenance window %s: %w", name, err)
+		}
+
+		if len(wc.Collectors) > 0 {
+			w.collectors = make(map[string]bool)
+			for _, c := range wc.Collectors {
+				w.collectors[c] = true
+			}
+		}
+
+		s.windows = append(s.windows, w)
+	}
+
+	return s, nil
+}
+
+// parseSchedule sets when a recurring window is open from its configuration.
+func (w *maintenanceWindow) parseSchedule(days []string, start, end, timezone string) error {
+	var err error
+	if w.start, err = parseClock(start); err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+	if w.end, err = parseClock(end); err != nil {
+		return fmt.Errorf("end: %w", err)
+	}
+	if w.start == w.end {
+		return fmt.Errorf("start and end are equal")
+	}
+
+	w.location = time.Local
+	if timezone != "" {
+		if w.location, err = time.LoadLocation(timezone); err != nil {
+			return err
+		}
+	}
+
+	if len(days) > 0 {
+		w.days = make(map[time.Weekday]bool)
+		for _, d := range days {
+			key := strings.ToLower(d)
+			if len(key) > 3 {
+				key = key[:3] // accept "monday" as well as "mon"
+			}
+			day, ok := weekdays[key]
+			if !ok {
+				return fmt.Errorf("unknown day %q", d)
+			}
+			w.days[day] = true
+		}
+	}
+	return nil
+}
+
+// Plan splits collectors into those to run, tag, or pause at the given time.
+// When several windows match a collector, "pause" wins over "label".
+func (s *MaintenanceSchedule) Plan(now time.Time, collectors []string) CollectionPlan {
+	plan := CollectionPlan{Tagged: make(map[string]string)}
+
+	for _, c := range collectors {
+		action, window := s.actionFor(now, c)
+		switch action {
+		case MaintenanceActionPause:
+			plan.Paused = append(plan.Paused, c)
+		case MaintenanceActionLabel:
+			plan.Tagged[c] = window
+		default:
+			plan.Run = append(plan.Run, c)
+		}
+	}
+
+	return plan
+}
+
+// actionFor returns the action and window name applying to a collector, if any.
+func (s *MaintenanceSchedule) actionFor(now time.Time, collector string) (string, string) {
+	action, window := "", ""
+	for _, w := range s.windows {
+		if w.collectors != nil && !w.collectors[collector] {
+			continue
+		}
+		if !w.contains(now) {
+			continue
+		}
+		if w.action == MaintenanceActionPause {
+			return w.action, w.name
+		}
+		if action == "" {
+			action, window = w.action, w.name
+		}
+	}
+	return action, window
+}
+
+// contains reports whether t falls inside the window.
+func (w maintenanceWindow) contains(t time.Time) bool {
+	t = t.In(w.location)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, w.location)
+	offset := t.Sub(midnight)
+
+	if w.start < w.end {
+		return w.onDay(t.Weekday()) && offset >= w.start && offset < w.end
+	}
+
+	// Window wraps midnight: the part after midnight belongs to the previous day's window
+	if offset >= w.start {
+		return w.onDay(t.Weekday())
+	}
+	if offset < w.end {
+		return w.onDay((t.Weekday() + 6) % 7)
+	}
+	return false
+}
+
+// onDay reports whether the window starts on the given weekday.
+func (w maintenanceWindow) onDay(day time.Weekday) bool {
+	return w.days == nil || w.days[day]
+}
+
+// parseClock parses "HH:MM" into an offset from midnight.
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (want HH:MM)", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// TagMaintenance labels metrics with the maintenance window they were collected in.
+// Labels are copied because collectors may share one label map between metrics.
+func TagMaintenance(metricsList []metrics.Metric, window string) {
+	for i := range metricsList {
+		labels := make(map[string]string, len(metricsList[i].Labels)+1)
+		for k, v := range metricsList[i].Labels {
+			labels[k] = v
+		}
+		labels[MaintenanceLabel] = window
+		metricsList[i].Labels = labels
+	}
+}
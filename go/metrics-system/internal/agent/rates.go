@@ -0,0 +1,145 @@
+package agent
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bellistech/metrics-system/internal/config"
+	"github.com/bellistech/metrics-system/pkg/metrics"
+)
+
+// RateSuffix is appended to a counter's name (minus any "_total" suffix)
+// to name its derived per-second gauge.
+const RateSuffix = "_per_sec"
+
+// counterSample is the previous observation of a counter series.
+type counterSample struct {
+	value     float64
+	timestamp time.Time
+}
+
+// RateCalculator derives per-second gauges from counters so storage backends
+// without a rate() function can still graph rates.
+type RateCalculator struct {
+	prefixes []string
+	all      bool
+	previous map[string]counterSample // series key -> last sample
+}
+
+// NewRateCalculator creates a rate calculator for the configured prefixes.
+// It returns nil when rate precomputation is disabled.
+func NewRateCalculator(cfg config.RatesConfig) *RateCalculator {
+	if len(cfg.Prefixes) == 0 {
+		return nil
+	}
+
+	r := &RateCalculator{previous: make(map[string]counterSample)}
+	for _, p := range cfg.Prefixes {
+		if p == "*" {
+			r.all = true
+			continue
+		}
+		r.prefixes = append(r.prefixes, p)
+	}
+	return r
+}
+
+// Apply returns the per-second gauges derived from the counters in metricsList.
+// The first sample of a series yields no rate. A counter that goes backwards is
+// treated as having been reset to zero, so the rate is its new value over the interval.
+// Series missing from a cycle are forgotten, so a collector that was paused
+// starts over rather than reporting a rate across the gap.
+func (r *RateCalculator) Apply(metricsList []metrics.Metric) []metrics.Metric {
+	if r == nil {
+		return nil
+	}
+
+	var derived []metrics.Metric
+	current := make(map[string]counterSample)
+
+	for _, m := range metricsList {
+		if m.Type != metrics.MetricTypeCounter || !r.matches(m.Name) {
+			continue
+		}
+
+		key := rateKey(m)
+		sample := counterSample{value: m.Value, timestamp: m.Timestamp}
+		current[key] = sample
+
+		prev, ok := r.previous[key]
+		if !ok {
+			continue
+		}
+		elapsed := sample.timestamp.Sub(prev.timestamp).Seconds()
+		if elapsed <= 0 {
+			continue
+		}
+
+		delta := sample.value - prev.value
+		if delta < 0 {
+			// Counter reset (process restart, wraparound): count from zero
+			delta = sample.value
+		}
+
+		labels := make(map[string]string, len(m.Labels))
+		for k, v := range m.Labels {
+			labels[k] = v
+		}
+
+		unit := ""
+		if m.Unit != "" {
+			unit = m.Unit + "/s"
+		}
+
+		derived = append(derived, metrics.Metric{
+			Name:      strings.TrimSuffix(m.Name, "_total") + RateSuffix,
+			Type:      metrics.MetricTypeGauge,
+			Value:     delta / elapsed,
+			Timestamp: m.Timestamp,
+			Labels:    labels,
+			Hostname:  m.Hostname,
+			Unit:      unit,
+		})
+	}
+
+	r.previous = current
+	return derived
+}
+
+// matches reports whether a counter name is selected for rate precomputation.
+func (r *RateCalculator) matches(name string) bool {
+	if r.all {
+		return true
+	}
+	for _, p := range r.prefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateKey identifies a counter series. The maintenance label is ignored so
+// entering or leaving a maintenance window does not restart the series.
+func rateKey(m metrics.Metric) string {
+	keys := make([]string, 0, len(m.Labels))
+	for k := range m.Labels {
+		if k != MaintenanceLabel {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(m.Hostname)
+	b.WriteString("|")
+	b.WriteString(m.Name)
+	for _, k := range keys {
+		b.WriteString("|")
+		b.WriteString(k)
+		b.WriteString("=")
+		b.WriteString(m.Labels[k])
+	}
+	return b.String()
+}
@@ -0,0 +1,204 @@
+package agent
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bellistech/metrics-system/internal/config"
+	"github.com/bellistech/metrics-system/pkg/metrics"
+)
+
+// Built-in scrub actions.
+const (
+	ScrubActionHash     = "hash"
+	ScrubActionTruncate = "truncate"
+	ScrubActionDrop     = "drop"
+)
+
+// DefaultScrubTruncateLength is how many characters "truncate" keeps when the
+// rule does not set a length.
+const DefaultScrubTruncateLength = 8
+
+// scrubHashLength is how many hex characters of the HMAC a hashed value keeps.
+const scrubHashLength = 16
+
+// ScrubFunc rewrites one sensitive label value. rule is the configured rule,
+// so custom actions can read their own settings from it.
+type ScrubFunc func(value string, rule config.ScrubRule, salt string) string
+
+var (
+	scrubFuncsMu sync.RWMutex
+	scrubFuncs   = make(map[string]ScrubFunc)
+)
+
+// RegisterScrubFunc registers a custom scrub action (e.g. adding noise for
+// differential privacy) under the given name, usable as a rule's action.
+// Should be called from init().
+func RegisterScrubFunc(name string, fn ScrubFunc) {
+	scrubFuncsMu.Lock()
+	defer scrubFuncsMu.Unlock()
+	scrubFuncs[name] = fn
+}
+
+// ListScrubActions returns the names of all available scrub actions.
+func ListScrubActions() []string {
+	scrubFuncsMu.RLock()
+	defer scrubFuncsMu.RUnlock()
+
+	names := []string{ScrubActionDrop}
+	for name := range scrubFuncs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterScrubFunc(ScrubActionHash, scrubHash)
+	RegisterScrubFunc(ScrubActionTruncate, scrubTruncate)
+}
+
+// scrubRule is a parsed config.ScrubRule.
+type scrubRule struct {
+	rule     config.ScrubRule
+	labels   map[string]bool
+	prefixes []string // metric name prefixes; nil means all metrics
+	fn       ScrubFunc
+	drop     bool
+}
+
+// Scrubber rewrites or removes sensitive labels before metrics leave the host.
+type Scrubber struct {
+	rules []scrubRule
+	salt  string
+}
+
+// NewScrubber parses and validates label scrubbing configuration.
+// It returns nil when no rules are configured.
+func NewScrubber(cfg config.ScrubConfig) (*Scrubber, error) {
+	if len(cfg.Rules) == 0 {
+		return nil, nil
+	}
+
+	s := &Scrubber{salt: cfg.Salt}
+	for i, rc := range cfg.Rules {
+		if len(rc.Labels) == 0 {
+			return nil, fmt.Errorf("scrub rule %d: no labels listed", i+1)
+		}
+
+		r := scrubRule{rule: rc, labels: make(map[string]bool), prefixes: rc.Metrics}
+		for _, l := range rc.Labels {
+			r.labels[l] = true
+		}
+
+		action := strings.ToLower(rc.Action)
+		switch action {
+		case ScrubActionDrop:
+			r.drop = true
+		case "":
+			return nil, fmt.Errorf("scrub rule %d: no action (want one of %v)", i+1, ListScrubActions())
+		default:
+			scrubFuncsMu.RLock()
+			r.fn = scrubFuncs[action]
+			scrubFuncsMu.RUnlock()
+			if r.fn == nil {
+				return nil, fmt.Errorf("scrub rule %d: unknown action %q (want one of %v)", i+1, rc.Action, ListScrubActions())
+			}
+		}
+
+		if action == ScrubActionHash && cfg.Salt == "" {
+			return nil, fmt.Errorf("scrub rule %d: hash needs scrub.salt to be set", i+1)
+		}
+		if rc.Length < 0 {
+			return nil, fmt.Errorf("scrub rule %d: negative length %d", i+1, rc.Length)
+		}
+
+		s.rules = append(s.rules, r)
+	}
+
+	return s, nil
+}
+
+// Apply scrubs the labels of metricsList in place. Rules run in order, so a
+// label dropped by one rule is not seen by later rules.
+// Labels are copied before being changed because collectors may share one
+// label map between metrics.
+func (s *Scrubber) Apply(metricsList []metrics.Metric) {
+	if s == nil {
+		return
+	}
+
+	for i := range metricsList {
+		m := &metricsList[i]
+		copied := false
+
+		for _, r := range s.rules {
+			if !r.matches(m.Name) {
+				continue
+			}
+			for key := range r.labels {
+				value, ok := m.Labels[key]
+				if !ok {
+					continue
+				}
+				if !copied {
+					m.Labels = copyLabels(m.Labels)
+					copied = true
+				}
+				if r.drop {
+					delete(m.Labels, key)
+				} else {
+					m.Labels[key] = r.fn(value, r.rule, s.salt)
+				}
+			}
+		}
+	}
+}
+
+// matches reports whether a rule applies to the named metric.
+func (r scrubRule) matches(name string) bool {
+	if len(r.prefixes) == 0 {
+		return true
+	}
+	for _, p := range r.prefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// scrubHash replaces a value with a salted HMAC-SHA256, so the same value
+// still maps to the same series but cannot be recovered without the salt.
+func scrubHash(value string, rule config.ScrubRule, salt string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))[:scrubHashLength]
+}
+
+// scrubTruncate keeps only the first Length characters of a value.
+func scrubTruncate(value string, rule config.ScrubRule, salt string) string {
+	length := rule.Length
+	if length == 0 {
+		length = DefaultScrubTruncateLength
+	}
+	runes := []rune(value)
+	if len(runes) <= length {
+		return value
+	}
+	return string(runes[:length])
+}
+
+// copyLabels returns a copy of a label map.
+func copyLabels(labels map[string]string) map[string]string {
+	copied := make(map[string]string, len(labels))
+	for k, v := range labels {
+		copied[k] = v
+	}
+	return copied
+}
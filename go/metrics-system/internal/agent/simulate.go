@@ -0,0 +1,171 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/bellistech/metrics-system/pkg/metrics"
+)
+
+// DefaultSimulateDevices is how many mount points and network interfaces
+// each simulated host has unless configured otherwise.
+const DefaultSimulateDevices = 4
+
+// simulateFullDiskShare is the share of simulated hosts with a disk that
+// keeps filling up, so disk space alerts have something to fire on.
+const simulateFullDiskShare = 0.02
+
+// Simulator fabricates metrics for virtual hosts, so the server, its storage
+// and alerting rules can be load-tested without deploying real agents.
+//
+// Metric names, labels and units match the cpu, memory, disk, network and
+// uptime collectors. Each host has its own load level, a daily cycle and
+// noise; counters only go up. Every host reports 6 + 7*devices series.
+type Simulator struct {
+	hosts []*simHost
+}
+
+// simHost is the state of one simulated host between cycles.
+type simHost struct {
+	name    string
+	rng     *rand.Rand
+	boot    time.Time
+	load    float64   // typical CPU usage, percent
+	phase   float64   // offset of the daily cycle, radians
+	memory  float64   // total memory, bytes
+	disks   []simDisk // one per mount point
+	ifaces  []float64 // bytes received per interface (tx is a fraction of it)
+	ifRates []float64 // typical receive rate per interface, bytes/s
+	last    time.Time
+}
+
+// simDisk is one simulated filesystem.
+type simDisk struct {
+	total, used float64 // bytes
+	growth      float64 // bytes per second
+}
+
+// NewSimulator creates count virtual hosts named "<prefix>-0001" and up,
+// each with the given number of mount points and interfaces. Hosts made with
+// the same seed behave the same, so load tests can be repeated.
+func NewSimulator(prefix string, count, devices int, seed int64) *Simulator {
+	if devices <= 0 {
+		devices = DefaultSimulateDevices
+	}
+
+	s := &Simulator{hosts: make([]*simHost, count)}
+	for i := range s.hosts {
+		rng := rand.New(rand.NewSource(seed + int64(i)))
+		h := &simHost{
+			name:    fmt.Sprintf("%s-%04d", prefix, i+1),
+			rng:     rng,
+			boot:    time.Now().Add(-time.Duration(rng.Int63n(int64(90 * 24 * time.Hour)))),
+			load:    5 + rng.ExpFloat64()*15,
+			phase:   rng.Float64() * 2 * math.Pi,
+			memory:  float64(int64(4+rng.Intn(60)) << 30),
+			disks:   make([]simDisk, devices),
+			ifaces:  make([]float64, devices),
+			ifRates: make([]float64, devices),
+		}
+		for d := range h.disks {
+			total := float64(int64(50+rng.Intn(950)) << 30)
+			h.disks[d] = simDisk{total: total, used: total * (0.2 + rng.Float64()*0.5)}
+			if rng.Float64() < simulateFullDiskShare {
+				h.disks[d].growth = total / (24 * 3600) // full within a day
+			}
+		}
+		for n := range h.ifaces {
+			h.ifaces[n] = rng.Float64() * 1e12
+			h.ifRates[n] = rng.ExpFloat64() * 1e6
+		}
+		s.hosts[i] = h
+	}
+	return s
+}
+
+// Hosts returns the names of the simulated hosts.
+func (s *Simulator) Hosts() []string {
+	names := make([]string, len(s.hosts))
+	for i, h := range s.hosts {
+		names[i] = h.name
+	}
+	return names
+}
+
+// Collect returns one cycle of metrics for host i at time now. Calls for the
+// same host must not overlap; different hosts can be collected concurrently.
+func (s *Simulator) Collect(i int, now time.Time) []metrics.Metric {
+	h := s.hosts[i]
+	elapsed := 60.0
+	if !h.last.IsZero() {
+		elapsed = now.Sub(h.last).Seconds()
+	}
+	h.last = now
+
+	// Busier during the host's "day", with noise and the odd spike
+	day := math.Sin(2*math.Pi*float64(now.Unix()%86400)/86400 + h.phase)
+	cpu := h.load*(1+0.5*day) + h.rng.NormFloat64()*3
+	if h.rng.Float64() < 0.01 {
+		cpu += 50
+	}
+	cpu = clamp(cpu, 0.5, 100)
+	iowait := clamp(cpu*0.05+h.rng.Float64(), 0, 100-cpu)
+	memUsed := h.memory * clamp(0.3+cpu/200+h.rng.NormFloat64()*0.02, 0.05, 0.98)
+
+	gauge := func(name string, value float64, unit string, labels map[string]string) metrics.Metric {
+		return metrics.Metric{Name: name, Type: metrics.MetricTypeGauge, Value: value, Timestamp: now, Hostname: h.name, Labels: labels, Unit: unit}
+	}
+	counter := func(name string, value float64, unit string, labels map[string]string) metrics.Metric {
+		m := gauge(name, value, unit, labels)
+		m.Type = metrics.MetricTypeCounter
+		return m
+	}
+
+	result := []metrics.Metric{
+		gauge("cpu_usage_total_percent", cpu, "percent", nil),
+		gauge("cpu_usage_iowait_percent", iowait, "percent", nil),
+		gauge("memory_total_bytes", h.memory, "bytes", nil),
+		gauge("memory_used_bytes", memUsed, "bytes", nil),
+		gauge("memory_used_percent", memUsed/h.memory*100, "percent", nil),
+		gauge("system_uptime_seconds", now.Sub(h.boot).Seconds(), "seconds", nil),
+	}
+
+	for d := range h.disks {
+		disk := &h.disks[d]
+		disk.used = clamp(disk.used+disk.growth*elapsed+h.rng.NormFloat64()*1e6, 0, disk.total)
+		labels := map[string]string{"mountpoint": simMountPoint(d)}
+		result = append(result,
+			gauge("disk_used_bytes", disk.used, "bytes", labels),
+			gauge("disk_total_bytes", disk.total, "bytes", labels),
+			gauge("disk_used_percent", disk.used/disk.total*100, "percent", labels),
+		)
+	}
+
+	for n := range h.ifaces {
+		rate := math.Max(0, h.ifRates[n]*(1+0.5*day)*(1+h.rng.NormFloat64()*0.1))
+		h.ifaces[n] += rate * elapsed
+		labels := map[string]string{"interface": fmt.Sprintf("eth%d", n)}
+		result = append(result,
+			counter("network_rx_bytes_total", math.Floor(h.ifaces[n]), "bytes", labels),
+			counter("network_tx_bytes_total", math.Floor(h.ifaces[n]*0.3), "bytes", labels),
+			counter("network_rx_packets_total", math.Floor(h.ifaces[n]/800), "", labels),
+			counter("network_tx_packets_total", math.Floor(h.ifaces[n]*0.3/400), "", labels),
+		)
+	}
+
+	return result
+}
+
+// simMountPoint names a simulated host's d-th filesystem.
+func simMountPoint(d int) string {
+	if d == 0 {
+		return "/"
+	}
+	return fmt.Sprintf("/data%d", d)
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
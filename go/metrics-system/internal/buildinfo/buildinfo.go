@@ -0,0 +1,61 @@
+// Package buildinfo describes the build a binary came from.
+//
+// The values are injected at build time by the Makefile:
+//
+//	go build -ldflags "-X github.com/bellistech/metrics-system/internal/buildinfo.Version=v1.2.0 \
+//	  -X github.com/bellistech/metrics-system/internal/buildinfo.Commit=abc1234 \
+//	  -X github.com/bellistech/metrics-system/internal/buildinfo.BuildDate=2024-11-20T10:00:00Z"
+package buildinfo
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set with -ldflags -X; plain "go build" leaves the defaults.
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// Info is the build of the running binary.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build of the running binary. Without an injected commit it
+// comes from the VCS stamp "go build" adds, if any.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok && info.Commit == "" {
+		for _, s := range bi.Settings {
+			if s.Key == "vcs.revision" {
+				info.Commit = s.Value
+			}
+		}
+	}
+
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildDate == "" {
+		info.BuildDate = "unknown"
+	}
+	return info
+}
+
+// String formats the build for --version output and logs.
+func (i Info) String() string {
+	return fmt.Sprintf("%s (commit %s, built %s, %s)", i.Version, i.Commit, i.BuildDate, i.GoVersion)
+}
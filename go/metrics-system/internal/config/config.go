@@ -11,10 +11,16 @@ import (
 
 // AgentConfig represents the agent configuration.
 type AgentConfig struct {
-	Server     AgentServerConfig  `yaml:"server"`
-	Collection CollectionConfig   `yaml:"collection"`
-	Agent      AgentInfo          `yaml:"agent"`
-	Logging    LoggingConfig      `yaml:"logging"`
+	Server      AgentServerConfig   `yaml:"server"`
+	Collection  CollectionConfig    `yaml:"collection"`
+	Agent       AgentInfo           `yaml:"agent"`
+	Logging     LoggingConfig       `yaml:"logging"`
+	Maintenance MaintenanceConfig   `yaml:"maintenance"`
+	Rates       RatesConfig         `yaml:"rates"`
+	Scrub       ScrubConfig         `yaml:"scrub"`
+	Cloud       CloudMetadataConfig `yaml:"cloud_metadata"`
+	Bandwidth   BandwidthConfig     `yaml:"bandwidth"`
+	DiskEvents  DiskEventsConfig    `yaml:"disk_events"`
 }
 
 // AgentServerConfig represents server connection settings for the agent.
@@ -22,6 +28,9 @@ type AgentServerConfig struct {
 	Address string        `yaml:"address"`
 	Timeout time.Duration `yaml:"timeout"`
 	TLS     TLSConfig     `yaml:"tls"`
+	// Retries resends a batch (with the same batch ID) after a timeout or
+	// dropped connection; the server's dedup window stores it only once.
+	Retries int `yaml:"retries"`
 }
 
 // CollectionConfig represents metric collection settings.
@@ -35,6 +44,88 @@ type CollectionConfig struct {
 type AgentInfo struct {
 	ID     string            `yaml:"id"`
 	Labels map[string]string `yaml:"labels"`
+	// StateFile is where a stopping agent leaves its counter state for the
+	// next one (upgrades, restarts). Empty disables the handoff.
+	StateFile string `yaml:"state_file"`
+}
+
+// MaintenanceConfig represents planned maintenance windows.
+type MaintenanceConfig struct {
+	Windows []MaintenanceWindow `yaml:"windows"`
+}
+
+// MaintenanceWindow represents a recurring time-of-day window during which
+// selected collectors are paused or their metrics are tagged.
+type MaintenanceWindow struct {
+	Name       string   `yaml:"name"`
+	Days       []string `yaml:"days"`       // e.g. ["sat", "sun"]; empty means every day
+	Start      string   `yaml:"start"`      // "HH:MM"
+	End        string   `yaml:"end"`        // "HH:MM", may be earlier than start to wrap midnight
+	Timezone   string   `yaml:"timezone"`   // IANA name; empty means local time
+	Collectors []string `yaml:"collectors"` // empty means all collectors
+	Action     string   `yaml:"action"`     // "pause" or "label" (default)
+}
+
+// RatesConfig represents agent-side rate-of-change precomputation for counters.
+type RatesConfig struct {
+	// Prefixes selects counters by metric name prefix; "*" selects all counters.
+	// Empty disables rate precomputation.
+	Prefixes []string `yaml:"prefixes"`
+}
+
+// CloudMetadataConfig represents labels taken from the cloud instance
+// metadata service (EC2, GCE, Azure).
+type CloudMetadataConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	Providers []string      `yaml:"providers"` // providers to try; empty means all
+	Timeout   time.Duration `yaml:"timeout"`   // per metadata request
+	Refresh   time.Duration `yaml:"refresh"`   // how long a lookup is cached
+}
+
+// BandwidthConfig caps what the agent sends, for hosts on metered or slow links.
+type BandwidthConfig struct {
+	MaxBytes int           `yaml:"max_bytes"` // bytes sent per period; 0 = unlimited
+	Period   time.Duration `yaml:"period"`    // budget period
+	Critical []string      `yaml:"critical"`  // metric name prefixes always sent, even over budget
+	Bulk     []string      `yaml:"bulk"`      // metric name prefixes held back for the off-peak window
+	OffPeak  OffPeakConfig `yaml:"off_peak"`  // when bulk metrics go out; empty means whenever budget is left
+	MaxQueue int           `yaml:"max_queue"` // bulk metrics held back at most (oldest dropped)
+}
+
+// DiskEventsConfig represents the disk event watcher, which reports a
+// filesystem filling up or turning read-only as it happens rather than at the
+// next collection.
+type DiskEventsConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	MountPoints   []string      `yaml:"mount_points"`   // empty means the real filesystems in /proc/mounts
+	WatchPaths    []string      `yaml:"watch_paths"`    // directories watched with inotify; empty means the mount points
+	UsagePercent  float64       `yaml:"usage_percent"`  // a filesystem at or above this usage is full
+	CheckInterval time.Duration `yaml:"check_interval"` // every mount point is checked at least this often
+}
+
+// OffPeakConfig represents the recurring window in which deferred bulk
+// metrics are sent.
+type OffPeakConfig struct {
+	Days     []string `yaml:"days"`     // e.g. ["sat", "sun"]; empty means every day
+	Start    string   `yaml:"start"`    // "HH:MM"
+	End      string   `yaml:"end"`      // "HH:MM", may be earlier than start to wrap midnight
+	Timezone string   `yaml:"timezone"` // IANA name; empty means local time
+}
+
+// ScrubConfig represents label scrubbing applied before metrics leave the host.
+type ScrubConfig struct {
+	// Salt keys the "hash" action. Keep it secret: anyone who knows it can
+	// check guesses against hashed values.
+	Salt  string      `yaml:"salt"`
+	Rules []ScrubRule `yaml:"rules"`
+}
+
+// ScrubRule represents one scrubbing rule for sensitive label keys.
+type ScrubRule struct {
+	Labels  []string `yaml:"labels"`  // label keys to scrub, e.g. ["user", "cmdline"]
+	Metrics []string `yaml:"metrics"` // metric name prefixes; empty means all metrics
+	Action  string   `yaml:"action"`  // "hash", "truncate", "drop" or a registered custom action
+	Length  int      `yaml:"length"`  // characters kept by "truncate" (default 8)
 }
 
 // LoggingConfig represents logging configuration.
@@ -53,9 +144,15 @@ type TLSConfig struct {
 
 // ServerConfig represents the server configuration.
 type ServerConfig struct {
-	GRPC     GRPCConfig     `yaml:"grpc"`
-	Database DatabaseConfig `yaml:"database"`
-	Logging  LoggingConfig  `yaml:"logging"`
+	GRPC        GRPCConfig        `yaml:"grpc"`
+	HTTP        HTTPConfig        `yaml:"http"`
+	Database    DatabaseConfig    `yaml:"database"`
+	Replication ReplicationConfig `yaml:"replication"`
+	Encryption  EncryptionConfig  `yaml:"encryption"`
+	Silences    SilencesConfig    `yaml:"silences"`
+	Dedup       DedupConfig       `yaml:"dedup"`
+	Retention   RetentionConfig   `yaml:"retention"`
+	Logging     LoggingConfig     `yaml:"logging"`
 }
 
 // GRPCConfig represents gRPC server settings.
@@ -65,6 +162,27 @@ type GRPCConfig struct {
 	MaxRecv int       `yaml:"max_recv_msg_size"`
 }
 
+// HTTPConfig represents the HTTP API settings.
+type HTTPConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	Port         int           `yaml:"port"`
+	SeriesWindow time.Duration `yaml:"series_window"`
+	// AdminToken enables the admin endpoints (host export/deletion) for
+	// requests carrying "Authorization: Bearer <token>". Empty disables them.
+	AdminToken string `yaml:"admin_token"`
+	// Query limits the cost of /api/v1/query requests.
+	Query QueryConfig `yaml:"query"`
+}
+
+// QueryConfig limits HTTP queries so a runaway dashboard can't starve
+// ingestion of database connections.
+type QueryConfig struct {
+	MaxConcurrent int           `yaml:"max_concurrent"` // queries running at once
+	QueueTimeout  time.Duration `yaml:"queue_timeout"`  // wait for a free slot before answering 503
+	MaxRows       int           `yaml:"max_rows"`       // rows one query may return
+	MaxRange      time.Duration `yaml:"max_range"`      // longest end - start; 0 = unlimited
+}
+
 // DatabaseConfig represents PostgreSQL configuration.
 type DatabaseConfig struct {
 	Host            string        `yaml:"host"`
@@ -78,6 +196,42 @@ type DatabaseConfig struct {
 	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
 }
 
+// ReplicationConfig represents dual-write of ingested metrics to a standby database.
+type ReplicationConfig struct {
+	Enabled       bool           `yaml:"enabled"`
+	Database      DatabaseConfig `yaml:"database"`
+	QueueSize     int            `yaml:"queue_size"`     // batches buffered for the standby
+	RetryInterval time.Duration  `yaml:"retry_interval"` // wait after a failed write
+}
+
+// EncryptionConfig selects label values to encrypt before they are stored.
+type EncryptionConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	KeyFile string   `yaml:"key_file"` // base64-encoded 32-byte key
+	Labels  []string `yaml:"labels"`   // label keys whose values are encrypted
+}
+
+// SilencesConfig controls where alert silences are kept.
+type SilencesConfig struct {
+	File      string        `yaml:"file"`      // JSON file silences are saved to; "" = memory only
+	Retention time.Duration `yaml:"retention"` // how long expired silences are kept
+}
+
+// DedupConfig controls how long the server remembers batch IDs, so batches
+// agents retry after an ambiguous failure aren't stored twice.
+type DedupConfig struct {
+	Window     time.Duration `yaml:"window"`      // how long a batch ID is remembered; 0 disables
+	MaxBatches int           `yaml:"max_batches"` // batch IDs remembered at most (oldest forgotten)
+}
+
+// RetentionConfig describes how long the database keeps data at each
+// resolution. It must match the policies in scripts/init-db.sql; the query
+// API uses it to flag results whose range reaches past the retained data.
+type RetentionConfig struct {
+	Raw    time.Duration `yaml:"raw"`    // raw samples; 0 = kept forever
+	Hourly time.Duration `yaml:"hourly"` // metrics_hourly aggregates; 0 = kept forever
+}
+
 // LoadAgentConfig loads agent configuration from a YAML file.
 func LoadAgentConfig(path string) (*AgentConfig, error) {
 	data, err := os.ReadFile(path)
@@ -90,16 +244,33 @@ func LoadAgentConfig(path string) (*AgentConfig, error) {
 		Server: AgentServerConfig{
 			Address: "localhost:9090",
 			Timeout: 30 * time.Second,
+			Retries: 2,
 		},
 		Collection: CollectionConfig{
 			Interval:   60 * time.Second,
 			Collectors: []string{"cpu", "memory", "disk", "network", "uptime"},
 			BatchSize:  100,
 		},
+		Agent: AgentInfo{
+			StateFile: "/var/lib/metrics-agent/state.json",
+		},
 		Logging: LoggingConfig{
 			Level:  "info",
 			Format: "text",
 		},
+		Cloud: CloudMetadataConfig{
+			Enabled: true,
+			Timeout: 2 * time.Second,
+			Refresh: time.Hour,
+		},
+		Bandwidth: BandwidthConfig{
+			Period:   time.Hour,
+			MaxQueue: 10000,
+		},
+		DiskEvents: DiskEventsConfig{
+			UsagePercent:  95,
+			CheckInterval: 5 * time.Second,
+		},
 	}
 
 	if err := yaml.Unmarshal(data, config); err != nil {
@@ -122,6 +293,16 @@ func LoadServerConfig(path string) (*ServerConfig, error) {
 			Port:    9090,
 			MaxRecv: 16 * 1024 * 1024, // 16MB
 		},
+		HTTP: HTTPConfig{
+			Enabled:      true,
+			Port:         8080,
+			SeriesWindow: time.Hour,
+			Query: QueryConfig{
+				MaxConcurrent: 4,
+				QueueTimeout:  5 * time.Second,
+				MaxRows:       10000,
+			},
+		},
 		Database: DatabaseConfig{
 			Host:            "localhost",
 			Port:            5432,
@@ -133,6 +314,24 @@ func LoadServerConfig(path string) (*ServerConfig, error) {
 			MaxIdleConns:    5,
 			ConnMaxLifetime: 5 * time.Minute,
 		},
+		Replication: ReplicationConfig{
+			Database: DatabaseConfig{
+				Port:    5432,
+				SSLMode: "disable",
+			},
+			QueueSize:     1000,
+			RetryInterval: 5 * time.Second,
+		},
+		Silences: SilencesConfig{
+			Retention: 5 * 24 * time.Hour,
+		},
+		Dedup: DedupConfig{
+			Window:     10 * time.Minute,
+			MaxBatches: 100000,
+		},
+		Retention: RetentionConfig{
+			Raw: 90 * 24 * time.Hour,
+		},
 		Logging: LoggingConfig{
 			Level:  "info",
 			Format: "text",
@@ -0,0 +1,146 @@
+// Package run manages the lifecycle of the long-running binaries: their
+// services are started together and stopped together, on SIGINT or SIGTERM
+// or as soon as one of them fails, with a bound on how long stopping may take.
+package run
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/bellistech/metrics-system/internal/logger"
+)
+
+// DefaultShutdownTimeout is how long services get to stop.
+const DefaultShutdownTimeout = 10 * time.Second
+
+// service is one part of a program run by a Group.
+type service struct {
+	name  string
+	start func(ctx context.Context) error
+	stop  func(ctx context.Context) error
+}
+
+// Group runs a program's services until it is told to stop.
+//
+// Each service's start function runs in its own goroutine and should block
+// until its context is cancelled. Shutdown begins on SIGINT or SIGTERM, or
+// when any start function returns; the context is then cancelled, the stop
+// functions are called in reverse order of Add, and Run waits for every
+// start function to return. All of that must finish within the shutdown
+// timeout.
+type Group struct {
+	name     string
+	timeout  time.Duration
+	services []service
+	handlers map[os.Signal]func()
+}
+
+// New creates a group for the program called name, which appears in the
+// shutdown log messages.
+func New(name string) *Group {
+	return &Group{
+		name:     name,
+		timeout:  DefaultShutdownTimeout,
+		handlers: make(map[os.Signal]func()),
+	}
+}
+
+// SetShutdownTimeout changes how long services get to stop.
+func (g *Group) SetShutdownTimeout(d time.Duration) {
+	g.timeout = d
+}
+
+// Add adds a service. start runs until ctx is cancelled; stop, which may be
+// nil, is called after that to release what start can't on its own, such
+// as a listener blocking in Serve.
+func (g *Group) Add(name string, start func(ctx context.Context) error, stop func(ctx context.Context) error) {
+	g.services = append(g.services, service{name: name, start: start, stop: stop})
+}
+
+// OnSignal calls fn whenever sig arrives, instead of shutting down. fn runs
+// in Run's goroutine, so it should hand long work off to a service.
+func (g *Group) OnSignal(sig os.Signal, fn func()) {
+	g.handlers[sig] = fn
+}
+
+// Run starts every service and returns once all of them have stopped. The
+// error joins what every start and stop function returned, each prefixed
+// with its service's name, plus a timeout error if stopping took too long.
+func (g *Group) Run() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigs := []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	for sig := range g.handlers {
+		sigs = append(sigs, sig)
+	}
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, sigs...)
+	defer signal.Stop(sigChan)
+
+	type result struct {
+		name string
+		err  error
+	}
+	results := make(chan result, len(g.services))
+	for _, svc := range g.services {
+		go func(svc service) {
+			results <- result{svc.name, svc.start(ctx)}
+		}(svc)
+	}
+
+	var errs []error
+	running := len(g.services)
+	for running > 0 && ctx.Err() == nil {
+		select {
+		case sig := <-sigChan:
+			if fn, ok := g.handlers[sig]; ok {
+				fn()
+				continue
+			}
+			logger.Info("Received signal %v, shutting down %s...", sig, g.name)
+			cancel()
+		case r := <-results:
+			running--
+			if r.err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", r.name, r.err))
+				logger.Error("%s failed, shutting down %s: %v", r.name, g.name, r.err)
+			} else {
+				logger.Info("%s stopped, shutting down %s...", r.name, g.name)
+			}
+			cancel()
+		}
+	}
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), g.timeout)
+	defer shutdownCancel()
+
+	for i := len(g.services) - 1; i >= 0; i-- {
+		svc := g.services[i]
+		if svc.stop == nil {
+			continue
+		}
+		if err := svc.stop(shutdownCtx); err != nil {
+			errs = append(errs, fmt.Errorf("stopping %s: %w", svc.name, err))
+		}
+	}
+
+	for running > 0 {
+		select {
+		case r := <-results:
+			running--
+			if r.err != nil && !errors.Is(r.err, context.Canceled) {
+				errs = append(errs, fmt.Errorf("%s: %w", r.name, r.err))
+			}
+		case <-shutdownCtx.Done():
+			errs = append(errs, fmt.Errorf("%d service(s) still running after the %s shutdown timeout", running, g.timeout))
+			return errors.Join(errs...)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// batchState is what the dedup window knows about a batch ID.
+type batchState int
+
+const (
+	batchNew        batchState = iota // never seen: store it
+	batchInProgress                   // being stored by an earlier attempt right now
+	batchStored                       // already stored: acknowledge without storing again
+)
+
+// BatchDedup remembers the IDs of recently stored batches, so a batch an
+// agent retries after an ambiguous failure (a timeout or dropped connection
+// after the server had already stored it) isn't stored twice. IDs are kept
+// for a bounded window and up to a bounded number of batches.
+type BatchDedup struct {
+	mu         sync.Mutex
+	window     time.Duration
+	maxBatches int
+	batches    map[string]*dedupEntry
+	order      []*dedupEntry // oldest first, for pruning
+}
+
+// dedupEntry is one batch ID in the window.
+type dedupEntry struct {
+	key   string
+	seen  time.Time
+	state batchState
+}
+
+// NewBatchDedup creates a dedup window. It returns nil (deduplication
+// disabled) when window is not positive.
+func NewBatchDedup(window time.Duration, maxBatches int) *BatchDedup {
+	if window <= 0 {
+		return nil
+	}
+	return &BatchDedup{
+		window:     window,
+		maxBatches: maxBatches,
+		batches:    make(map[string]*dedupEntry),
+	}
+}
+
+// Begin checks a batch ID before its batch is stored. A new ID is marked
+// in progress, and must be followed by Done once the store succeeded or
+// failed. Batches without an ID (older agents) are always new.
+func (d *BatchDedup) Begin(agentID, batchID string, now time.Time) batchState {
+	if d == nil || batchID == "" {
+		return batchNew
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.prune(now)
+
+	key := agentID + "/" + batchID
+	if e, ok := d.batches[key]; ok {
+		return e.state
+	}
+
+	e := &dedupEntry{key: key, seen: now, state: batchInProgress}
+	d.batches[key] = e
+	d.order = append(d.order, e)
+	return batchNew
+}
+
+// Done records the outcome of storing a batch Begin marked in progress.
+// Stored batches are remembered for the window; failed ones are forgotten
+// so the agent's retry is stored.
+func (d *BatchDedup) Done(agentID, batchID string, stored bool, now time.Time) {
+	if d == nil || batchID == "" {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := agentID + "/" + batchID
+	e, ok := d.batches[key]
+	if !ok {
+		return
+	}
+	if stored {
+		e.state, e.seen = batchStored, now
+	} else {
+		delete(d.batches, key)
+	}
+}
+
+// prune forgets batch IDs older than the window, and the oldest ones beyond
+// maxBatches. Callers hold d.mu.
+func (d *BatchDedup) prune(now time.Time) {
+	drop := 0
+	for _, e := range d.order {
+		over := d.maxBatches > 0 && len(d.order)-drop >= d.maxBatches
+		if !over && now.Sub(e.seen) < d.window {
+			break
+		}
+		if d.batches[e.key] == e {
+			delete(d.batches, e.key)
+		}
+		drop++
+	}
+	d.order = d.order[drop:]
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bellistech/metrics-system/internal/server/storage"
+	"github.com/bellistech/metrics-system/pkg/metrics"
+)
+
+// HostExport is a spooled export of everything stored for one host, ready to
+// be written out as a gzip-compressed tar archive containing:
+//
+//	<hostname>/manifest.json   what was exported and when
+//	<hostname>/series.json     one entry per series (name + labels) with sample counts
+//	<hostname>/metrics.jsonl   every stored sample, one JSON object per line, oldest first
+//
+// Samples are spooled to a temporary file so a database error is reported
+// before anything is sent to the client. Call Close to remove the file.
+type HostExport struct {
+	Manifest HostExportManifest
+
+	series []exportedSeries
+	spool  *os.File
+	size   int64
+}
+
+// HostExportManifest describes the contents of a host export.
+type HostExportManifest struct {
+	Hostname   string    `json:"hostname"`
+	ExportedAt time.Time `json:"exported_at"`
+	Samples    int64     `json:"samples"`
+	Series     int       `json:"series"`
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+}
+
+// exportedSeries is the metadata exported for one series.
+type exportedSeries struct {
+	Name      string            `json:"name"`
+	Labels    map[string]string `json:"labels"`
+	Type      string            `json:"type"`
+	Unit      string            `json:"unit"`
+	Samples   int64             `json:"samples"`
+	FirstSeen time.Time         `json:"first_seen"`
+	LastSeen  time.Time         `json:"last_seen"`
+}
+
+// exportedSample is one line of metrics.jsonl.
+type exportedSample struct {
+	Time   time.Time         `json:"time"`
+	Name   string            `json:"name"`
+	Value  float64           `json:"value"`
+	Type   string            `json:"type"`
+	Labels map[string]string `json:"labels"`
+	Unit   string            `json:"unit"`
+}
+
+// NewHostExport reads everything stored for hostname into a spooled export.
+func NewHostExport(ctx context.Context, store storage.HostData, hostname string) (*HostExport, error) {
+	spool, err := os.CreateTemp("", "metrics-export-*.jsonl")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create export spool: %w", err)
+	}
+	e := &HostExport{
+		Manifest: HostExportManifest{Hostname: hostname, ExportedAt: time.Now().UTC()},
+		spool:    spool,
+	}
+
+	series := make(map[string]*exportedSeries)
+	out := bufio.NewWriter(spool)
+	enc := json.NewEncoder(out)
+
+	err = store.ExportHost(ctx, hostname, func(m metrics.Metric) error {
+		key := seriesKey(m.Name, m.Labels)
+		s, ok := series[key]
+		if !ok {
+			s = &exportedSeries{Name: m.Name, Labels: m.Labels, FirstSeen: m.Timestamp}
+			series[key] = s
+		}
+		s.Type = m.Type.String()
+		s.Unit = m.Unit
+		s.Samples++
+		s.LastSeen = m.Timestamp
+
+		if e.Manifest.Samples == 0 {
+			e.Manifest.FirstSeen = m.Timestamp
+		}
+		e.Manifest.Samples++
+		e.Manifest.LastSeen = m.Timestamp
+
+		return enc.Encode(exportedSample{
+			Time:   m.Timestamp,
+			Name:   m.Name,
+			Value:  m.Value,
+			Type:   m.Type.String(),
+			Labels: m.Labels,
+			Unit:   m.Unit,
+		})
+	})
+	if err == nil {
+		err = out.Flush()
+	}
+	if err != nil {
+		e.Close()
+		return nil, fmt.Errorf("failed to export host %s: %w", hostname, err)
+	}
+
+	if e.size, err = spool.Seek(0, io.SeekCurrent); err != nil {
+		e.Close()
+		return nil, fmt.Errorf("failed to size export spool: %w", err)
+	}
+
+	e.series = make([]exportedSeries, 0, len(series))
+	for _, s := range series {
+		e.series = append(e.series, *s)
+	}
+	sort.Slice(e.series, func(i, j int) bool {
+		if e.series[i].Name != e.series[j].Name {
+			return e.series[i].Name < e.series[j].Name
+		}
+		return seriesKey("", e.series[i].Labels) < seriesKey("", e.series[j].Labels)
+	})
+	e.Manifest.Series = len(e.series)
+
+	return e, nil
+}
+
+// Filename returns the suggested file name for the archive.
+func (e *HostExport) Filename() string {
+	return fmt.Sprintf("%s-%s.tar.gz", archiveDir(e.Manifest.Hostname), e.Manifest.ExportedAt.Format("20060102T150405Z"))
+}
+
+// WriteTo writes the export as a gzip-compressed tar archive.
+func (e *HostExport) WriteTo(w io.Writer) (int64, error) {
+	counter := &countingWriter{w: w}
+	gz := gzip.NewWriter(counter)
+	tw := tar.NewWriter(gz)
+	dir := archiveDir(e.Manifest.Hostname) + "/"
+
+	manifest, err := json.MarshalIndent(e.Manifest, "", "  ")
+	if err != nil {
+		return counter.n, err
+	}
+	if err := writeTarFile(tw, dir+"manifest.json", e.Manifest.ExportedAt, int64(len(manifest)), bytes.NewReader(manifest)); err != nil {
+		return counter.n, err
+	}
+
+	series, err := json.MarshalIndent(e.series, "", "  ")
+	if err != nil {
+		return counter.n, err
+	}
+	if err := writeTarFile(tw, dir+"series.json", e.Manifest.ExportedAt, int64(len(series)), bytes.NewReader(series)); err != nil {
+		return counter.n, err
+	}
+
+	if _, err := e.spool.Seek(0, io.SeekStart); err != nil {
+		return counter.n, err
+	}
+	if err := writeTarFile(tw, dir+"metrics.jsonl", e.Manifest.ExportedAt, e.size, e.spool); err != nil {
+		return counter.n, err
+	}
+
+	if err := tw.Close(); err != nil {
+		return counter.n, err
+	}
+	if err := gz.Close(); err != nil {
+		return counter.n, err
+	}
+	return counter.n, nil
+}
+
+// Close removes the spooled samples.
+func (e *HostExport) Close() error {
+	name := e.spool.Name()
+	e.spool.Close()
+	return os.Remove(name)
+}
+
+// archiveDir makes a hostname safe to use as a directory and file name
+// inside the archive, so extracting it can never write outside that directory.
+func archiveDir(hostname string) string {
+	safe := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, hostname)
+	if safe == "" || strings.Trim(safe, ".") == "" {
+		return "_" + safe
+	}
+	return safe
+}
+
+// writeTarFile adds one regular file to a tar archive.
+func writeTarFile(tw *tar.Writer, name string, modTime time.Time, size int64, r io.Reader) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    size,
+		ModTime: modTime,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.CopyN(tw, r, size)
+	return err
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -5,45 +5,78 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"time"
 
 	metricsv1 "github.com/bellistech/metrics-system/api/metrics/v1"
+	"github.com/bellistech/metrics-system/internal/buildinfo"
 	"github.com/bellistech/metrics-system/internal/logger"
 	"github.com/bellistech/metrics-system/internal/server/storage"
 	"github.com/bellistech/metrics-system/pkg/metrics"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-// Version is the server version.
-var Version = "1.0.0"
-
 // GRPCServer implements the MetricsService gRPC server.
 type GRPCServer struct {
 	metricsv1.UnimplementedMetricsServiceServer
 	storage storage.Storage
+	index   *SeriesIndex
+	dedup   *BatchDedup
+	server  *grpc.Server
 }
 
 // NewGRPCServer creates a new gRPC server.
-func NewGRPCServer(store storage.Storage) *GRPCServer {
-	return &GRPCServer{
+// The series index is optional; when set it is updated with every stored batch.
+func NewGRPCServer(store storage.Storage, index *SeriesIndex) *GRPCServer {
+	s := &GRPCServer{
 		storage: store,
+		index:   index,
+		server: grpc.NewServer(
+			grpc.MaxRecvMsgSize(16 * 1024 * 1024), // 16MB max message size
+		),
 	}
+	metricsv1.RegisterMetricsServiceServer(s.server, s)
+	return s
+}
+
+// EnableDedup makes the server acknowledge batches it has already stored
+// (by batch ID) without storing them again. A nil dedup disables it.
+func (s *GRPCServer) EnableDedup(dedup *BatchDedup) {
+	s.dedup = dedup
 }
 
-// Start starts the gRPC server on the specified port.
+// Start starts the gRPC server on the specified port. It returns nil once
+// Shutdown has stopped the server.
 func (s *GRPCServer) Start(port int) error {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return fmt.Errorf("failed to listen on port %d: %w", port, err)
 	}
 
-	grpcServer := grpc.NewServer(
-		grpc.MaxRecvMsgSize(16*1024*1024), // 16MB max message size
-	)
-	metricsv1.RegisterMetricsServiceServer(grpcServer, s)
-
 	logger.Info("Starting gRPC server on port %d", port)
-	return grpcServer.Serve(listener)
+	if err := s.server.Serve(listener); err != nil && err != grpc.ErrServerStopped {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops the gRPC server, letting batches in flight finish until ctx
+// is done and then closing their connections.
+func (s *GRPCServer) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.server.Stop()
+		return ctx.Err()
+	}
 }
 
 // SendMetrics handles incoming metric batches.
@@ -56,7 +89,23 @@ func (s *GRPCServer) SendMetrics(ctx context.Context, req *metricsv1.MetricBatch
 		}, nil
 	}
 
-	logger.Debug("Received %d metrics from %s (agent: %s)", len(req.Metrics), req.Hostname, req.AgentId)
+	logger.Debug("Received %d metrics from %s (agent: %s, batch: %s)", len(req.Metrics), req.Hostname, req.AgentId, req.BatchId)
+
+	// A retry of a batch we already have? Acknowledge it, but don't store
+	// its samples twice.
+	switch s.dedup.Begin(req.AgentId, req.BatchId, time.Now()) {
+	case batchStored:
+		logger.Info("Ignoring duplicate batch %s from %s (%d metrics already stored)", req.BatchId, req.Hostname, len(req.Metrics))
+		return &metricsv1.MetricBatchResponse{
+			Success:         true,
+			Message:         "Duplicate batch already stored",
+			MetricsReceived: int32(len(req.Metrics)),
+			ServerTimestamp: timestamppb.Now(),
+			Duplicate:       true,
+		}, nil
+	case batchInProgress:
+		return nil, status.Errorf(codes.Aborted, "batch %s is still being stored, retry later", req.BatchId)
+	}
 
 	// Convert and store metrics
 	converted := make([]metrics.Metric, 0, len(req.Metrics))
@@ -66,6 +115,7 @@ func (s *GRPCServer) SendMetrics(ctx context.Context, req *metricsv1.MetricBatch
 
 	// Store metrics
 	err := s.storage.Store(ctx, converted)
+	s.dedup.Done(req.AgentId, req.BatchId, err == nil, time.Now())
 	if err != nil {
 		logger.Error("Error storing metrics: %v", err)
 		return &metricsv1.MetricBatchResponse{
@@ -77,6 +127,10 @@ func (s *GRPCServer) SendMetrics(ctx context.Context, req *metricsv1.MetricBatch
 		}, nil
 	}
 
+	if s.index != nil {
+		s.index.Observe(converted)
+	}
+
 	logger.Debug("Stored %d metrics from %s", len(req.Metrics), req.Hostname)
 
 	return &metricsv1.MetricBatchResponse{
@@ -120,10 +174,13 @@ func (s *GRPCServer) HealthCheck(ctx context.Context, req *metricsv1.HealthCheck
 
 	logger.Debug("Health check from agent %s: healthy=%v", req.AgentId, healthy)
 
+	build := buildinfo.Get()
 	return &metricsv1.HealthCheckResponse{
 		Healthy:   healthy,
-		Version:   Version,
+		Version:   build.Version,
 		Timestamp: timestamppb.Now(),
+		Commit:    build.Commit,
+		BuildDate: build.BuildDate,
 	}, nil
 }
 
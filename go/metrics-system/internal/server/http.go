@@ -0,0 +1,289 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bellistech/metrics-system/internal/buildinfo"
+	"github.com/bellistech/metrics-system/internal/logger"
+	"github.com/bellistech/metrics-system/internal/server/storage"
+)
+
+// HTTPServer serves the JSON API used by UIs and tooling.
+type HTTPServer struct {
+	index       *SeriesIndex
+	replication *storage.ReplicatedStorage
+	hostData    storage.HostData
+	adminToken  string
+	mux         *http.ServeMux
+	server      *http.Server
+
+	// Set by EnableQuery
+	store           storage.Storage
+	rawRetention    time.Duration
+	hourlyRetention time.Duration
+	queryLimits     QueryLimits
+	queryGate       *queryGate
+
+	// Set by EnableSilences
+	silences *SilenceStore
+}
+
+// metricSeries groups the label sets reported for one metric name.
+type metricSeries struct {
+	Name      string              `json:"name"`
+	LabelSets []map[string]string `json:"label_sets"`
+	LastSeen  time.Time           `json:"last_seen"`
+}
+
+// NewHTTPServer creates a new HTTP API server.
+func NewHTTPServer(index *SeriesIndex) *HTTPServer {
+	s := &HTTPServer{
+		index: index,
+		mux:   http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("/api/v1/hosts", s.handleHosts)
+	s.mux.HandleFunc("/api/v1/hosts/", s.handleHostSeries)
+	s.mux.HandleFunc("/api/v1/version", s.handleVersion)
+
+	return s
+}
+
+// EnableReplicationStatus exposes the state of dual-write replication.
+func (s *HTTPServer) EnableReplicationStatus(replication *storage.ReplicatedStorage) {
+	s.replication = replication
+	s.mux.HandleFunc("/api/v1/replication", s.handleReplication)
+}
+
+// EnableHostExport exposes the admin host export/deletion endpoint, guarded by
+// a bearer token.
+func (s *HTTPServer) EnableHostExport(hostData storage.HostData, adminToken string) {
+	s.hostData = hostData
+	s.adminToken = adminToken
+	s.mux.HandleFunc("/api/v1/admin/hosts/", s.handleHostExport)
+}
+
+// Start starts the HTTP server on the specified port.
+func (s *HTTPServer) Start(port int) error {
+	s.server = &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           s.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	logger.Info("Starting HTTP API on port %d", port)
+	if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("http server: %w", err)
+	}
+	return nil
+}
+
+// Shutdown gracefully stops the HTTP server.
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	if s.server == nil {
+		return nil
+	}
+	return s.server.Shutdown(ctx)
+}
+
+// handleHosts lists hosts that reported metrics recently.
+//
+//	GET /api/v1/hosts?window=15m
+func (s *HTTPServer) handleHosts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	window, err := parseWindow(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hosts := s.index.Hosts(window)
+	if hosts == nil {
+		hosts = []string{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"hosts": hosts,
+	})
+}
+
+// handleHostSeries lists the metric names and label sets a host reported recently.
+//
+//	GET /api/v1/hosts/{hostname}/series?window=15m
+func (s *HTTPServer) handleHostSeries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/hosts/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] != "series" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	hostname := parts[0]
+
+	window, err := parseWindow(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Series come back sorted by name, so grouping is a single pass
+	grouped := []metricSeries{}
+	for _, info := range s.index.Series(hostname, window) {
+		if n := len(grouped); n == 0 || grouped[n-1].Name != info.Name {
+			grouped = append(grouped, metricSeries{Name: info.Name, LabelSets: []map[string]string{}})
+		}
+		last := &grouped[len(grouped)-1]
+		last.LabelSets = append(last.LabelSets, info.Labels)
+		if info.LastSeen.After(last.LastSeen) {
+			last.LastSeen = info.LastSeen
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"hostname": hostname,
+		"window":   s.index.clampWindow(window).String(),
+		"metrics":  grouped,
+	})
+}
+
+// handleVersion reports the build of the running server.
+//
+//	GET /api/v1/version
+func (s *HTTPServer) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, buildinfo.Get())
+}
+
+// handleReplication reports the standby replication queue and lag.
+//
+//	GET /api/v1/replication
+func (s *HTTPServer) handleReplication(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.replication.Stats())
+}
+
+// handleHostExport exports everything stored for a host as a .tar.gz archive,
+// and with delete=true deletes it once the archive has been sent.
+//
+//	GET  /api/v1/admin/hosts/{hostname}/export
+//	POST /api/v1/admin/hosts/{hostname}/export?delete=true
+//
+// The number of deleted samples is reported in the X-Deleted-Samples trailer,
+// since the archive has already been sent by the time the delete runs.
+func (s *HTTPServer) handleHostExport(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/hosts/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] != "export" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	hostname := parts[0]
+
+	deleteAfter := r.URL.Query().Get("delete") == "true"
+	switch {
+	case r.Method == http.MethodGet && !deleteAfter:
+	case r.Method == http.MethodPost:
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "use GET to export, or POST with delete=true to export and delete")
+		return
+	}
+
+	export, err := NewHostExport(r.Context(), s.hostData, hostname)
+	if err != nil {
+		logger.Error("Host export failed: %v", err)
+		writeError(w, http.StatusInternalServerError, "export failed")
+		return
+	}
+	defer export.Close()
+
+	if export.Manifest.Samples == 0 {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no data stored for host %q", hostname))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", export.Filename()))
+	if deleteAfter {
+		w.Header().Set("Trailer", "X-Deleted-Samples")
+	}
+	w.WriteHeader(http.StatusOK)
+
+	written, err := export.WriteTo(w)
+	if err != nil {
+		logger.Error("Host export of %s interrupted after %d bytes, nothing deleted: %v", hostname, written, err)
+		return
+	}
+	logger.Info("Exported %d samples in %d series for host %s (%d bytes)", export.Manifest.Samples, export.Manifest.Series, hostname, written)
+
+	if !deleteAfter {
+		return
+	}
+
+	deleted, err := s.hostData.DeleteHost(r.Context(), hostname)
+	if err != nil {
+		logger.Error("Failed to delete data for host %s after export: %v", hostname, err)
+		w.Header().Set("X-Deleted-Samples", "error")
+		return
+	}
+	s.index.Forget(hostname)
+	w.Header().Set("X-Deleted-Samples", fmt.Sprintf("%d", deleted))
+	logger.Info("Deleted %d samples for host %s", deleted, hostname)
+}
+
+// authorizeAdmin checks the request's bearer token against the admin token.
+func (s *HTTPServer) authorizeAdmin(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && s.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) == 1
+}
+
+// parseWindow reads the optional "window" query parameter (e.g. "15m").
+func parseWindow(r *http.Request) (time.Duration, error) {
+	raw := r.URL.Query().Get("window")
+	if raw == "" {
+		return 0, nil
+	}
+	window, err := time.ParseDuration(raw)
+	if err != nil || window <= 0 {
+		return 0, fmt.Errorf("invalid window %q", raw)
+	}
+	return window, nil
+}
+
+// writeJSON writes a JSON response body with the given status code.
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logger.Warn("Failed to encode HTTP response: %v", err)
+	}
+}
+
+// writeError writes a JSON error response.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bellistech/metrics-system/internal/logger"
+	"github.com/bellistech/metrics-system/internal/server/storage"
+)
+
+// Query resolutions reported in responses.
+const (
+	resolutionRaw    = "raw"
+	resolutionHourly = "1h"
+)
+
+// defaultQueryRange is how far back a query without "start" reaches.
+const defaultQueryRange = time.Hour
+
+// queryResponse is the result of GET /api/v1/query.
+//
+// Partial is set when part of the requested range is older than the data
+// kept at the reported resolution; DataStart is then the earliest time data
+// can exist, so UIs can mark the rest of the graph as missing rather than
+// empty.
+type queryResponse struct {
+	Name       string        `json:"name"`
+	Start      time.Time     `json:"start"`
+	End        time.Time     `json:"end"`
+	Resolution string        `json:"resolution"`
+	Partial    bool          `json:"partial"`
+	DataStart  *time.Time    `json:"data_start,omitempty"`
+	Warnings   []string      `json:"warnings,omitempty"`
+	Samples    []querySample `json:"samples"`
+}
+
+// querySample is one raw sample, or one bucket of a downsampled series
+// (Value is then the bucket average).
+type querySample struct {
+	Time     time.Time         `json:"time"`
+	Value    float64           `json:"value"`
+	Min      *float64          `json:"min,omitempty"`
+	Max      *float64          `json:"max,omitempty"`
+	Hostname string            `json:"hostname"`
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+// queryPlan is the tier a query reads from and what it will be missing.
+type queryPlan struct {
+	hourly    bool
+	dataStart time.Time // Zero if the tier covers the whole range
+	warning   string
+}
+
+// EnableQuery exposes metric queries. rawRetention and hourlyRetention are
+// how long the database keeps raw samples and hourly aggregates (zero means
+// forever); they must match the policies in scripts/init-db.sql. Limits left
+// at zero take their DefaultQueryLimits value.
+func (s *HTTPServer) EnableQuery(store storage.Storage, rawRetention, hourlyRetention time.Duration, limits QueryLimits) {
+	s.store = store
+	s.rawRetention = rawRetention
+	s.hourlyRetention = hourlyRetention
+	s.queryLimits = limits.withDefaults()
+	s.queryGate = newQueryGate(s.queryLimits.MaxConcurrent, s.queryLimits.QueueTimeout)
+	s.mux.HandleFunc("/api/v1/query", s.handleQuery)
+}
+
+// handleQuery returns the samples of one metric, from raw samples while they
+// are retained and from hourly aggregates beyond that.
+//
+//	GET /api/v1/query?name=cpu_usage_percent&host=web-01&start=2024-11-01T00:00:00Z&end=...&label=cpu:total
+func (s *HTTPServer) handleQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	params := r.URL.Query()
+	name := params.Get("name")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	hostname := params.Get("host")
+
+	now := time.Now()
+	end, err := parseTime(params.Get("end"), now)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	start, err := parseTime(params.Get("start"), end.Add(-defaultQueryRange))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !start.Before(end) {
+		writeError(w, http.StatusBadRequest, "start must be before end")
+		return
+	}
+	if max := s.queryLimits.MaxRange; max > 0 && end.Sub(start) > max {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("range of %s is longer than the maximum of %s; narrow start and end", end.Sub(start), max))
+		return
+	}
+
+	labels := make(map[string]string)
+	for _, l := range params["label"] {
+		k, v, ok := strings.Cut(l, ":")
+		if !ok || k == "" {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid label %q, want key:value", l))
+			return
+		}
+		labels[k] = v
+	}
+
+	if !s.queryGate.acquire(r.Context()) {
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusServiceUnavailable, fmt.Sprintf("too many queries running (limit %d); try again shortly", s.queryLimits.MaxConcurrent))
+		return
+	}
+	defer s.queryGate.release()
+
+	// Ask for one row more than allowed, to tell "exactly at the cap" from "over it"
+	maxRows := s.queryLimits.MaxRows
+	tooManyRows := fmt.Sprintf("query matched more than %d rows; narrow the range or filter by host or label", maxRows)
+
+	resp := queryResponse{Name: name, Start: start, End: end, Samples: []querySample{}}
+	plan := s.planQuery(start, now, len(labels) > 0)
+
+	if plan.hourly {
+		aggregates, err := s.store.(storage.Downsampled).QueryHourly(r.Context(), name, hostname, start, end, maxRows+1)
+		if err == nil && len(aggregates) > maxRows {
+			writeError(w, http.StatusUnprocessableEntity, tooManyRows)
+			return
+		}
+		if err == nil {
+			resp.Resolution = resolutionHourly
+			for _, a := range aggregates {
+				lo, hi := a.Min, a.Max
+				resp.Samples = append(resp.Samples, querySample{Time: a.Time, Value: a.Avg, Min: &lo, Max: &hi, Hostname: a.Hostname})
+			}
+			setPartial(&resp, plan)
+			writeJSON(w, http.StatusOK, resp)
+			return
+		}
+
+		// No TimescaleDB, for example: fall back to whatever raw data is left
+		logger.Warn("Hourly query for %s failed, using raw samples: %v", name, err)
+		plan = s.rawPlan(now, "hourly aggregates are unavailable")
+	}
+
+	results, err := s.store.Query(r.Context(), name, hostname, start, end, labels, maxRows+1)
+	if err != nil {
+		logger.Error("Query for %s failed: %v", name, err)
+		writeError(w, http.StatusInternalServerError, "query failed")
+		return
+	}
+	if len(results) > maxRows {
+		writeError(w, http.StatusUnprocessableEntity, tooManyRows)
+		return
+	}
+	resp.Resolution = resolutionRaw
+	for _, m := range results {
+		resp.Samples = append(resp.Samples, querySample{Time: m.Timestamp, Value: m.Value, Hostname: m.Hostname, Labels: m.Labels})
+	}
+	sort.Slice(resp.Samples, func(i, j int) bool {
+		return resp.Samples[i].Time.Before(resp.Samples[j].Time)
+	})
+	setPartial(&resp, plan)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// planQuery picks the tier for a query starting at start: raw samples while
+// they cover the whole range, otherwise the hourly aggregates if the storage
+// keeps them and no label filter is needed (aggregates have no labels).
+func (s *HTTPServer) planQuery(start, now time.Time, labels bool) queryPlan {
+	if s.rawRetention <= 0 || !start.Before(now.Add(-s.rawRetention)) {
+		return queryPlan{}
+	}
+
+	if _, ok := s.store.(storage.Downsampled); !ok {
+		return s.rawPlan(now, "the storage keeps no hourly aggregates")
+	}
+	if labels {
+		return s.rawPlan(now, "hourly aggregates have no labels to filter on")
+	}
+
+	plan := queryPlan{hourly: true}
+	if s.hourlyRetention > 0 && start.Before(now.Add(-s.hourlyRetention)) {
+		plan.dataStart = now.Add(-s.hourlyRetention)
+		plan.warning = fmt.Sprintf("hourly aggregates are kept for %s", s.hourlyRetention)
+	}
+	return plan
+}
+
+// rawPlan reads raw samples although they don't cover the whole range.
+func (s *HTTPServer) rawPlan(now time.Time, reason string) queryPlan {
+	return queryPlan{
+		dataStart: now.Add(-s.rawRetention),
+		warning:   fmt.Sprintf("raw samples are kept for %s and %s", s.rawRetention, reason),
+	}
+}
+
+// setPartial copies what a plan is missing into the response.
+func setPartial(resp *queryResponse, plan queryPlan) {
+	if plan.dataStart.IsZero() || !resp.Start.Before(plan.dataStart) {
+		return
+	}
+	dataStart := plan.dataStart
+	resp.Partial = true
+	resp.DataStart = &dataStart
+	resp.Warnings = append(resp.Warnings, fmt.Sprintf("no data before %s: %s", dataStart.Format(time.RFC3339), plan.warning))
+}
+
+// parseTime parses an RFC 3339 timestamp, or returns def if raw is empty.
+func parseTime(raw string, def time.Time) (time.Time, error) {
+	if raw == "" {
+		return def, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, want RFC 3339", raw)
+	}
+	return t, nil
+}
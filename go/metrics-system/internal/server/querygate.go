@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// QueryLimits bounds what HTTP queries may cost, so a runaway dashboard can't
+// take every database connection away from ingestion.
+type QueryLimits struct {
+	MaxConcurrent int           // Queries allowed to run at once
+	QueueTimeout  time.Duration // How long a query waits for a free slot
+	MaxRows       int           // Rows (samples or buckets) one query may return
+	MaxRange      time.Duration // Longest end - start; 0 means unlimited
+}
+
+// DefaultQueryLimits are used for limits left at zero.
+var DefaultQueryLimits = QueryLimits{
+	MaxConcurrent: 4,
+	QueueTimeout:  5 * time.Second,
+	MaxRows:       10000,
+}
+
+// withDefaults fills in limits left at zero.
+func (l QueryLimits) withDefaults() QueryLimits {
+	if l.MaxConcurrent <= 0 {
+		l.MaxConcurrent = DefaultQueryLimits.MaxConcurrent
+	}
+	if l.QueueTimeout <= 0 {
+		l.QueueTimeout = DefaultQueryLimits.QueueTimeout
+	}
+	if l.MaxRows <= 0 {
+		l.MaxRows = DefaultQueryLimits.MaxRows
+	}
+	return l
+}
+
+// queryGate limits how many queries run at once. Queries beyond the limit
+// wait in line for up to the queue timeout.
+type queryGate struct {
+	slots   chan struct{}
+	timeout time.Duration
+}
+
+// newQueryGate creates a gate admitting max queries at once.
+func newQueryGate(max int, timeout time.Duration) *queryGate {
+	return &queryGate{
+		slots:   make(chan struct{}, max),
+		timeout: timeout,
+	}
+}
+
+// acquire waits for a free slot. It returns false if none became free within
+// the queue timeout or the request was cancelled; otherwise the caller must
+// call release when its query is done.
+func (g *queryGate) acquire(ctx context.Context) bool {
+	timer := time.NewTimer(g.timeout)
+	defer timer.Stop()
+
+	select {
+	case g.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// release frees the slot taken by acquire.
+func (g *queryGate) release() {
+	<-g.slots
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bellistech/metrics-system/pkg/metrics"
+)
+
+// SeriesInfo describes one series (metric name + label set) reported by a host.
+type SeriesInfo struct {
+	Name     string            `json:"name"`
+	Labels   map[string]string `json:"labels"`
+	LastSeen time.Time         `json:"last_seen"`
+}
+
+// SeriesIndex is an in-memory index of the series each host has reported recently.
+// It is updated at ingest time so the series browser API can answer
+// "what does this host report?" without running DISTINCT queries against the database.
+type SeriesIndex struct {
+	mu     sync.RWMutex
+	window time.Duration
+	hosts  map[string]map[string]*SeriesInfo // hostname -> series key -> info
+}
+
+// NewSeriesIndex creates a series index that remembers series for the given window.
+func NewSeriesIndex(window time.Duration) *SeriesIndex {
+	if window <= 0 {
+		window = time.Hour
+	}
+	return &SeriesIndex{
+		window: window,
+		hosts:  make(map[string]map[string]*SeriesInfo),
+	}
+}
+
+// Window returns how long series are remembered after they were last seen.
+func (idx *SeriesIndex) Window() time.Duration {
+	return idx.window
+}
+
+// Observe records a batch of ingested metrics.
+func (idx *SeriesIndex) Observe(metricsList []metrics.Metric) {
+	now := time.Now()
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for _, m := range metricsList {
+		series, ok := idx.hosts[m.Hostname]
+		if !ok {
+			series = make(map[string]*SeriesInfo)
+			idx.hosts[m.Hostname] = series
+		}
+
+		key := seriesKey(m.Name, m.Labels)
+		if info, ok := series[key]; ok {
+			info.LastSeen = now
+			continue
+		}
+
+		labels := make(map[string]string, len(m.Labels))
+		for k, v := range m.Labels {
+			labels[k] = v
+		}
+		series[key] = &SeriesInfo{Name: m.Name, Labels: labels, LastSeen: now}
+	}
+}
+
+// Hosts returns the hostnames that reported anything within the window, sorted.
+func (idx *SeriesIndex) Hosts(window time.Duration) []string {
+	cutoff := time.Now().Add(-idx.clampWindow(window))
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var hosts []string
+	for host, series := range idx.hosts {
+		for _, info := range series {
+			if info.LastSeen.After(cutoff) {
+				hosts = append(hosts, host)
+				break
+			}
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// Series returns the series a host reported within the window,
+// sorted by metric name and then by label set.
+func (idx *SeriesIndex) Series(hostname string, window time.Duration) []SeriesInfo {
+	cutoff := time.Now().Add(-idx.clampWindow(window))
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var result []SeriesInfo
+	for _, info := range idx.hosts[hostname] {
+		if info.LastSeen.After(cutoff) {
+			result = append(result, *info)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		return seriesKey("", result[i].Labels) < seriesKey("", result[j].Labels)
+	})
+	return result
+}
+
+// Prune drops series that have not been seen within the index window.
+func (idx *SeriesIndex) Prune() int {
+	cutoff := time.Now().Add(-idx.window)
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	removed := 0
+	for host, series := range idx.hosts {
+		for key, info := range series {
+			if info.LastSeen.Before(cutoff) {
+				delete(series, key)
+				removed++
+			}
+		}
+		if len(series) == 0 {
+			delete(idx.hosts, host)
+		}
+	}
+	return removed
+}
+
+// Forget drops every series of a host, e.g. after its data was deleted.
+func (idx *SeriesIndex) Forget(hostname string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	delete(idx.hosts, hostname)
+}
+
+// RunPruner prunes the index periodically until the context is cancelled.
+func (idx *SeriesIndex) RunPruner(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			idx.Prune()
+		}
+	}
+}
+
+// clampWindow limits a requested window to what the index actually remembers.
+func (idx *SeriesIndex) clampWindow(window time.Duration) time.Duration {
+	if window <= 0 || window > idx.window {
+		return idx.window
+	}
+	return window
+}
+
+// seriesKey builds a stable identity for a metric name and label set.
+func seriesKey(name string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(name)
+	for _, k := range keys {
+		b.WriteString("|")
+		b.WriteString(k)
+		b.WriteString("=")
+		b.WriteString(labels[k])
+	}
+	return b.String()
+}
@@ -0,0 +1,452 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bellistech/metrics-system/internal/logger"
+)
+
+// Silence states, derived from the time window.
+const (
+	SilenceStatePending = "pending" // starts in the future
+	SilenceStateActive  = "active"
+	SilenceStateExpired = "expired"
+)
+
+// DefaultSilenceRetention is how long expired silences are kept for the
+// record before they are dropped.
+const DefaultSilenceRetention = 5 * 24 * time.Hour
+
+// maxSilenceBody bounds the size of a silence submitted through the API.
+const maxSilenceBody = 64 << 10
+
+// ErrSilenceNotFound is returned for an unknown silence ID.
+var ErrSilenceNotFound = errors.New("silence not found")
+
+// SilenceMatcher matches one alert label, by exact value or by a regular
+// expression that must match the whole value.
+type SilenceMatcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"is_regex,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// Silence suppresses notifications for alerts matching all of its matchers
+// between StartsAt and EndsAt. Alerts keep being evaluated and recorded while
+// silenced; only notifying is skipped, so a maintenance window doesn't page
+// anyone but its history is still complete.
+type Silence struct {
+	ID        string           `json:"id"`
+	Matchers  []SilenceMatcher `json:"matchers"`
+	StartsAt  time.Time        `json:"starts_at"`
+	EndsAt    time.Time        `json:"ends_at"`
+	CreatedBy string           `json:"created_by"`
+	Comment   string           `json:"comment"`
+	CreatedAt time.Time        `json:"created_at"`
+	UpdatedAt time.Time        `json:"updated_at"`
+}
+
+// State reports whether the silence is pending, active or expired at now.
+func (s *Silence) State(now time.Time) string {
+	switch {
+	case now.Before(s.StartsAt):
+		return SilenceStatePending
+	case now.Before(s.EndsAt):
+		return SilenceStateActive
+	default:
+		return SilenceStateExpired
+	}
+}
+
+// Matches reports whether every matcher matches the given alert labels. A
+// label the alert doesn't have matches as the empty string.
+func (s *Silence) Matches(labels map[string]string) bool {
+	for _, m := range s.Matchers {
+		value := labels[m.Name]
+		if m.re != nil {
+			if !m.re.MatchString(value) {
+				return false
+			}
+		} else if value != m.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// validate checks a silence submitted through the API and compiles its
+// regular expressions.
+func (s *Silence) validate() error {
+	if len(s.Matchers) == 0 {
+		return fmt.Errorf("at least one matcher is required")
+	}
+	if s.CreatedBy == "" {
+		return fmt.Errorf("created_by is required")
+	}
+	if s.Comment == "" {
+		return fmt.Errorf("comment is required")
+	}
+	if !s.EndsAt.After(s.StartsAt) {
+		return fmt.Errorf("ends_at must be after starts_at")
+	}
+
+	matchesEmpty := true
+	for i := range s.Matchers {
+		m := &s.Matchers[i]
+		if m.Name == "" {
+			return fmt.Errorf("matcher %d has no label name", i+1)
+		}
+		m.re = nil
+		if m.IsRegex {
+			re, err := regexp.Compile("^(?:" + m.Value + ")$")
+			if err != nil {
+				return fmt.Errorf("matcher %s: invalid regex: %w", m.Name, err)
+			}
+			m.re = re
+		}
+		if m.re != nil && !m.re.MatchString("") || m.re == nil && m.Value != "" {
+			matchesEmpty = false
+		}
+	}
+	// A silence that matches every alert is almost certainly a mistake
+	if matchesEmpty {
+		return fmt.Errorf("matchers would silence every alert")
+	}
+	return nil
+}
+
+// SilenceStore holds silences and persists them to a JSON file, so planned
+// maintenance survives a server restart.
+type SilenceStore struct {
+	mu        sync.RWMutex
+	path      string // "" keeps silences in memory only
+	retention time.Duration
+	silences  map[string]*Silence
+}
+
+// NewSilenceStore loads the silences saved at path, if any. Expired silences
+// are kept for retention before they are dropped.
+func NewSilenceStore(path string, retention time.Duration) (*SilenceStore, error) {
+	if retention <= 0 {
+		retention = DefaultSilenceRetention
+	}
+	st := &SilenceStore{
+		path:      path,
+		retention: retention,
+		silences:  make(map[string]*Silence),
+	}
+	if path == "" {
+		return st, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read silences: %w", err)
+	}
+	var saved []*Silence
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("failed to parse silences: %w", err)
+	}
+	for _, s := range saved {
+		if err := s.validate(); err != nil {
+			return nil, fmt.Errorf("saved silence %s: %w", s.ID, err)
+		}
+		st.silences[s.ID] = s
+	}
+	return st, nil
+}
+
+// Create validates and stores a new silence, assigning its ID. A zero
+// StartsAt means now.
+func (st *SilenceStore) Create(s Silence) (Silence, error) {
+	now := time.Now()
+	if s.StartsAt.IsZero() {
+		s.StartsAt = now
+	}
+	if !s.EndsAt.After(now) {
+		return Silence{}, fmt.Errorf("ends_at must be in the future")
+	}
+	if err := s.validate(); err != nil {
+		return Silence{}, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return Silence{}, fmt.Errorf("failed to generate silence ID: %w", err)
+	}
+	s.ID = hex.EncodeToString(id)
+	s.CreatedAt, s.UpdatedAt = now, now
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.silences[s.ID] = &s
+	if err := st.save(now); err != nil {
+		delete(st.silences, s.ID)
+		return Silence{}, err
+	}
+	return s, nil
+}
+
+// Expire ends a silence now. Pending silences are expired before they start,
+// and expiring an already expired silence is a no-op.
+func (st *SilenceStore) Expire(id string) (Silence, error) {
+	now := time.Now()
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s, ok := st.silences[id]
+	if !ok {
+		return Silence{}, ErrSilenceNotFound
+	}
+	if s.State(now) == SilenceStateExpired {
+		return *s, nil
+	}
+
+	old := *s
+	if s.StartsAt.After(now) {
+		s.StartsAt = now
+	}
+	s.EndsAt, s.UpdatedAt = now, now
+	if err := st.save(now); err != nil {
+		*s = old
+		return Silence{}, err
+	}
+	return *s, nil
+}
+
+// Get returns one silence.
+func (st *SilenceStore) Get(id string) (Silence, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	s, ok := st.silences[id]
+	if !ok {
+		return Silence{}, ErrSilenceNotFound
+	}
+	return *s, nil
+}
+
+// List returns the silences in the given state ("" for all), most recently
+// started first.
+func (st *SilenceStore) List(state string, now time.Time) []Silence {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	list := []Silence{}
+	for _, s := range st.silences {
+		if state == "" || s.State(now) == state {
+			list = append(list, *s)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].StartsAt.Equal(list[j].StartsAt) {
+			return list[i].StartsAt.After(list[j].StartsAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// Silenced returns the active silences matching an alert's labels. Alert
+// evaluation records the alert either way and only notifies when none match.
+func (st *SilenceStore) Silenced(labels map[string]string, now time.Time) []Silence {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	var matched []Silence
+	for _, s := range st.silences {
+		if s.State(now) == SilenceStateActive && s.Matches(labels) {
+			matched = append(matched, *s)
+		}
+	}
+	return matched
+}
+
+// save drops silences expired for longer than the retention and writes the
+// rest to the file, replacing it atomically. The caller holds the write lock.
+func (st *SilenceStore) save(now time.Time) error {
+	for id, s := range st.silences {
+		if now.Sub(s.EndsAt) > st.retention {
+			delete(st.silences, id)
+		}
+	}
+	if st.path == "" {
+		return nil
+	}
+
+	list := make([]*Silence, 0, len(st.silences))
+	for _, s := range st.silences {
+		list = append(list, s)
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(st.path), filepath.Base(st.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to save silences: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save silences: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save silences: %w", err)
+	}
+	return os.Rename(tmp.Name(), st.path)
+}
+
+// EnableSilences exposes the silences API. Listing is open like the other
+// read endpoints; creating and expiring silences require the admin token.
+func (s *HTTPServer) EnableSilences(silences *SilenceStore, adminToken string) {
+	s.silences = silences
+	s.adminToken = adminToken
+	s.mux.HandleFunc("/api/v1/silences", s.handleSilences)
+	s.mux.HandleFunc("/api/v1/silences/", s.handleSilence)
+}
+
+// handleSilences lists or creates silences. With label parameters, only the
+// active silences matching an alert with those labels are listed.
+//
+//	GET  /api/v1/silences?state=active&label=hostname:web-01
+//	POST /api/v1/silences {"matchers":[{"name":"hostname","value":"web-.*","is_regex":true}],
+//	                       "starts_at":"...","ends_at":"...","created_by":"alice","comment":"..."}
+func (s *HTTPServer) handleSilences(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		s.createSilence(w, r)
+		return
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	params := r.URL.Query()
+	state := params.Get("state")
+	switch state {
+	case "", SilenceStatePending, SilenceStateActive, SilenceStateExpired:
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid state %q, want pending, active or expired", state))
+		return
+	}
+
+	now := time.Now()
+	list := s.silences.List(state, now)
+	if len(params["label"]) > 0 {
+		labels := make(map[string]string)
+		for _, l := range params["label"] {
+			k, v, ok := strings.Cut(l, ":")
+			if !ok || k == "" {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid label %q, want key:value", l))
+				return
+			}
+			labels[k] = v
+		}
+		list = []Silence{}
+		for _, silence := range s.silences.Silenced(labels, now) {
+			if state == "" || state == SilenceStateActive {
+				list = append(list, silence)
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"silences": withSilenceState(list, now),
+	})
+}
+
+// createSilence stores the silence in the request body.
+func (s *HTTPServer) createSilence(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var silence Silence
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSilenceBody)).Decode(&silence); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid silence: %v", err))
+		return
+	}
+	created, err := s.silences.Create(silence)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.Info("Silence %s created by %s until %s: %s", created.ID, created.CreatedBy, created.EndsAt.Format(time.RFC3339), created.Comment)
+	writeJSON(w, http.StatusCreated, withSilenceState([]Silence{created}, time.Now())[0])
+}
+
+// handleSilence shows or expires one silence.
+//
+//	GET    /api/v1/silences/{id}
+//	DELETE /api/v1/silences/{id}
+func (s *HTTPServer) handleSilence(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/silences/")
+	if id == "" || strings.Contains(id, "/") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	var silence Silence
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		silence, err = s.silences.Get(id)
+	case http.MethodDelete:
+		if !s.authorizeAdmin(r) {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		silence, err = s.silences.Expire(id)
+		if err == nil {
+			logger.Info("Silence %s expired", id)
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	switch {
+	case errors.Is(err, ErrSilenceNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		logger.Error("Silence %s: %v", id, err)
+		writeError(w, http.StatusInternalServerError, "failed to update silence")
+	default:
+		writeJSON(w, http.StatusOK, withSilenceState([]Silence{silence}, time.Now())[0])
+	}
+}
+
+// silenceResponse is a silence with its state at the time of the request.
+type silenceResponse struct {
+	Silence
+	State string `json:"state"`
+}
+
+func withSilenceState(list []Silence, now time.Time) []silenceResponse {
+	resp := make([]silenceResponse, len(list))
+	for i := range list {
+		resp[i] = silenceResponse{Silence: list[i], State: list[i].State(now)}
+	}
+	return resp
+}
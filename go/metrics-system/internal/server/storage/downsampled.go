@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Aggregate is one bucket of a downsampled series.
+type Aggregate struct {
+	Time     time.Time
+	Name     string
+	Hostname string
+	Avg      float64
+	Min      float64
+	Max      float64
+	Count    int64
+}
+
+// Downsampled is implemented by storages that keep hourly aggregates next to
+// the raw samples (the metrics_hourly continuous aggregate created by
+// scripts/init-db.sql). Aggregates have no labels.
+type Downsampled interface {
+	// QueryHourly returns the hourly buckets of a metric between start and
+	// end, oldest first and at most limit of them. An empty hostname matches
+	// every host.
+	QueryHourly(ctx context.Context, name, hostname string, start, end time.Time, limit int) ([]Aggregate, error)
+}
+
+// QueryHourly reads hourly buckets from the metrics_hourly continuous aggregate.
+func (s *PostgresStorage) QueryHourly(ctx context.Context, name, hostname string, start, end time.Time, limit int) ([]Aggregate, error) {
+	b := newSelect(`SELECT bucket, name, hostname, avg_value, min_value, max_value, sample_count FROM metrics_hourly`).
+		Where("name = %s", name).
+		Where("bucket >= %s", start).
+		Where("bucket <= %s", end)
+	if hostname != "" {
+		b.Where("hostname = %s", hostname)
+	}
+	query, args := b.Build("bucket ASC", limit)
+
+	rows, err := s.queries.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query hourly metrics: %w", err)
+	}
+	defer rows.Close()
+
+	var result []Aggregate
+	for rows.Next() {
+		var a Aggregate
+		if err := rows.Scan(&a.Time, &a.Name, &a.Hostname, &a.Avg, &a.Min, &a.Max, &a.Count); err != nil {
+			return nil, fmt.Errorf("failed to read hourly metric: %w", err)
+		}
+		result = append(result, a)
+	}
+
+	return result, rows.Err()
+}
+
+// QueryHourly reads hourly buckets from the primary.
+func (r *ReplicatedStorage) QueryHourly(ctx context.Context, name, hostname string, start, end time.Time, limit int) ([]Aggregate, error) {
+	primary, ok := r.primary.(Downsampled)
+	if !ok {
+		return nil, fmt.Errorf("primary storage does not keep hourly aggregates")
+	}
+	return primary.QueryHourly(ctx, name, hostname, start, end, limit)
+}
@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bellistech/metrics-system/internal/logger"
+	"github.com/bellistech/metrics-system/pkg/metrics"
+)
+
+// encryptedPrefix marks a label value encrypted by EncryptedStorage. The
+// version leaves room for a different scheme or key rotation later.
+const encryptedPrefix = "enc:v1:"
+
+// EncryptedStorage encrypts the values of selected labels with AES-256-GCM
+// before they reach the wrapped storage, and decrypts them again on the way
+// out, so the database never sees them in the clear.
+//
+// The nonce is derived from the label key and value, so the same value always
+// encrypts to the same ciphertext. That keeps label filters working (the
+// filter value is encrypted the same way) at the cost of revealing which
+// stored values are equal. Values stored before encryption was enabled are
+// returned as they are, but filters on them no longer match.
+type EncryptedStorage struct {
+	inner    Storage
+	aead     cipher.AEAD
+	nonceKey []byte
+	labels   map[string]bool
+}
+
+// NewEncryptedStorage wraps inner so the values of the given label keys are
+// encrypted with a key derived from the 32-byte master key.
+func NewEncryptedStorage(inner Storage, key []byte, labels []string) (*EncryptedStorage, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+	}
+
+	// Separate keys for encryption and nonce derivation, so neither is
+	// used for two purposes
+	block, err := aes.NewCipher(deriveKey(key, "label-encryption"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	e := &EncryptedStorage{
+		inner:    inner,
+		aead:     aead,
+		nonceKey: deriveKey(key, "label-nonce"),
+		labels:   make(map[string]bool, len(labels)),
+	}
+	for _, label := range labels {
+		e.labels[label] = true
+	}
+	return e, nil
+}
+
+// ReadEncryptionKey reads a base64-encoded 32-byte key, as generated by
+// `openssl rand -base64 32`, from a file.
+func ReadEncryptionKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption key: %w", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key is not valid base64: %w", err)
+	}
+	return key, nil
+}
+
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// Store encrypts the protected labels of every metric and stores the batch.
+// The caller's metrics are left untouched.
+func (e *EncryptedStorage) Store(ctx context.Context, metricsList []metrics.Metric) error {
+	encrypted := make([]metrics.Metric, len(metricsList))
+	for i, m := range metricsList {
+		m.Labels = e.encryptLabels(m.Labels)
+		encrypted[i] = m
+	}
+	return e.inner.Store(ctx, encrypted)
+}
+
+// Query encrypts filters on protected labels, queries the wrapped storage and
+// decrypts the results.
+func (e *EncryptedStorage) Query(ctx context.Context, name, hostname string, start, end time.Time, labels map[string]string, limit int) ([]metrics.Metric, error) {
+	result, err := e.inner.Query(ctx, name, hostname, start, end, e.encryptLabels(labels), limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range result {
+		result[i].Labels = e.decryptLabels(result[i].Labels)
+	}
+	return result, nil
+}
+
+// Ping checks the wrapped storage.
+func (e *EncryptedStorage) Ping(ctx context.Context) error {
+	return e.inner.Ping(ctx)
+}
+
+// Close closes the wrapped storage.
+func (e *EncryptedStorage) Close() error {
+	return e.inner.Close()
+}
+
+// QueryHourly reads hourly buckets from the wrapped storage. Aggregates have
+// no labels, so there is nothing to decrypt.
+func (e *EncryptedStorage) QueryHourly(ctx context.Context, name, hostname string, start, end time.Time, limit int) ([]Aggregate, error) {
+	inner, ok := e.inner.(Downsampled)
+	if !ok {
+		return nil, fmt.Errorf("storage does not keep hourly aggregates")
+	}
+	return inner.QueryHourly(ctx, name, hostname, start, end, limit)
+}
+
+// ExportHost exports a host's metrics with their labels decrypted.
+func (e *EncryptedStorage) ExportHost(ctx context.Context, hostname string, fn func(metrics.Metric) error) error {
+	inner, ok := e.inner.(HostData)
+	if !ok {
+		return fmt.Errorf("storage does not support host export")
+	}
+	return inner.ExportHost(ctx, hostname, func(m metrics.Metric) error {
+		m.Labels = e.decryptLabels(m.Labels)
+		return fn(m)
+	})
+}
+
+// DeleteHost deletes a host's metrics from the wrapped storage.
+func (e *EncryptedStorage) DeleteHost(ctx context.Context, hostname string) (int64, error) {
+	inner, ok := e.inner.(HostData)
+	if !ok {
+		return 0, fmt.Errorf("storage does not support host deletion")
+	}
+	return inner.DeleteHost(ctx, hostname)
+}
+
+// encryptLabels returns a copy of labels with the protected values encrypted.
+func (e *EncryptedStorage) encryptLabels(labels map[string]string) map[string]string {
+	if !e.protects(labels) {
+		return labels
+	}
+	out := make(map[string]string, len(labels))
+	for k, v := range labels {
+		if e.labels[k] {
+			v = e.encrypt(k, v)
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// decryptLabels decrypts the protected values of labels in place.
+func (e *EncryptedStorage) decryptLabels(labels map[string]string) map[string]string {
+	for k, v := range labels {
+		if !e.labels[k] || !strings.HasPrefix(v, encryptedPrefix) {
+			continue
+		}
+		plain, err := e.decrypt(k, v)
+		if err != nil {
+			logger.Warn("Failed to decrypt label %q, returning it encrypted: %v", k, err)
+			continue
+		}
+		labels[k] = plain
+	}
+	return labels
+}
+
+func (e *EncryptedStorage) protects(labels map[string]string) bool {
+	for k := range labels {
+		if e.labels[k] {
+			return true
+		}
+	}
+	return false
+}
+
+// encrypt seals value with a nonce derived from the label key and value. The
+// key is also authenticated, so a ciphertext can't be moved to another label.
+func (e *EncryptedStorage) encrypt(key, value string) string {
+	mac := hmac.New(sha256.New, e.nonceKey)
+	mac.Write([]byte(key))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	nonce := mac.Sum(nil)[:e.aead.NonceSize()]
+
+	sealed := e.aead.Seal(nonce, nonce, []byte(value), []byte(key))
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed)
+}
+
+func (e *EncryptedStorage) decrypt(key, value string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil {
+		return "", fmt.Errorf("invalid encoding: %w", err)
+	}
+	if len(sealed) < e.aead.NonceSize() {
+		return "", fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:e.aead.NonceSize()], sealed[e.aead.NonceSize():]
+	plain, err := e.aead.Open(nil, nonce, ciphertext, []byte(key))
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
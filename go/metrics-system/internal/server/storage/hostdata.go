@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/bellistech/metrics-system/pkg/metrics"
+)
+
+// HostData is implemented by storages that can export and delete everything
+// stored for a single host, e.g. when a customer-dedicated host is decommissioned.
+type HostData interface {
+	// ExportHost calls fn for every metric stored for hostname, oldest first.
+	// Export stops at the first error returned by fn.
+	ExportHost(ctx context.Context, hostname string, fn func(metrics.Metric) error) error
+	// DeleteHost deletes every metric stored for hostname and returns how many were removed.
+	DeleteHost(ctx context.Context, hostname string) (int64, error)
+}
+
+// ExportHost streams every metric stored for hostname, oldest first.
+func (s *PostgresStorage) ExportHost(ctx context.Context, hostname string, fn func(metrics.Metric) error) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT time, name, value, metric_type, hostname, labels, unit
+		FROM metrics
+		WHERE hostname = $1
+		ORDER BY time ASC
+	`, hostname)
+	if err != nil {
+		return fmt.Errorf("failed to query host metrics: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m metrics.Metric
+		var metricType string
+		var labelsJSON sql.NullString
+		var unit sql.NullString
+
+		if err := rows.Scan(&m.Timestamp, &m.Name, &m.Value, &metricType, &m.Hostname, &labelsJSON, &unit); err != nil {
+			return fmt.Errorf("failed to read host metric: %w", err)
+		}
+
+		m.Type = parseMetricType(metricType)
+		m.Labels = parseLabels(labelsJSON.String)
+		m.Unit = unit.String
+
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// DeleteHost deletes every metric stored for hostname.
+func (s *PostgresStorage) DeleteHost(ctx context.Context, hostname string) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM metrics WHERE hostname = $1`, hostname)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete host metrics: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// ExportHost exports a host's metrics from the primary.
+func (r *ReplicatedStorage) ExportHost(ctx context.Context, hostname string, fn func(metrics.Metric) error) error {
+	primary, ok := r.primary.(HostData)
+	if !ok {
+		return fmt.Errorf("primary storage does not support host export")
+	}
+	return primary.ExportHost(ctx, hostname, fn)
+}
+
+// DeleteHost deletes a host's metrics from both the primary and the secondary.
+//
+// The host's metrics are first removed from batches still queued for the
+// secondary, so replication cannot bring them back after the delete.
+// The returned count is the number of metrics removed from the primary.
+func (r *ReplicatedStorage) DeleteHost(ctx context.Context, hostname string) (int64, error) {
+	primary, ok := r.primary.(HostData)
+	if !ok {
+		return 0, fmt.Errorf("primary storage does not support host deletion")
+	}
+	secondary, ok := r.secondary.(HostData)
+	if !ok {
+		return 0, fmt.Errorf("secondary storage does not support host deletion")
+	}
+
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	r.mu.Lock()
+	for i := range r.queue {
+		kept := make([]metrics.Metric, 0, len(r.queue[i].metrics))
+		for _, m := range r.queue[i].metrics {
+			if m.Hostname != hostname {
+				kept = append(kept, m)
+			}
+		}
+		r.queue[i].metrics = kept
+	}
+	r.mu.Unlock()
+
+	deleted, err := primary.DeleteHost(ctx, hostname)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := secondary.DeleteHost(ctx, hostname); err != nil {
+		return deleted, fmt.Errorf("deleted from primary but not from secondary: %w", err)
+	}
+	return deleted, nil
+}
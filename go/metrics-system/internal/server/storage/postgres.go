@@ -4,6 +4,7 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"strings"
@@ -17,8 +18,9 @@ import (
 type Storage interface {
 	// Store stores a batch of metrics.
 	Store(ctx context.Context, metrics []metrics.Metric) error
-	// Query retrieves metrics matching the given criteria.
-	Query(ctx context.Context, name string, start, end time.Time, labels map[string]string) ([]metrics.Metric, error)
+	// Query retrieves metrics matching the given criteria, newest first and
+	// at most limit of them. An empty hostname matches every host.
+	Query(ctx context.Context, name, hostname string, start, end time.Time, labels map[string]string, limit int) ([]metrics.Metric, error)
 	// Ping checks if the storage is available.
 	Ping(ctx context.Context) error
 	// Close closes the storage connection.
@@ -27,7 +29,8 @@ type Storage interface {
 
 // PostgresStorage implements Storage using PostgreSQL/TimescaleDB.
 type PostgresStorage struct {
-	db *sql.DB
+	db      *sql.DB
+	queries *stmtCache // Prepared statements for Query and QueryHourly
 }
 
 // NewPostgresStorage creates a new PostgreSQL storage.
@@ -51,7 +54,7 @@ func NewPostgresStorage(connStr string) (*PostgresStorage, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	return &PostgresStorage{db: db}, nil
+	return &PostgresStorage{db: db, queries: newStmtCache(db)}, nil
 }
 
 // Store stores a batch of metrics.
@@ -102,26 +105,27 @@ func (s *PostgresStorage) Store(ctx context.Context, metricsList []metrics.Metri
 	return nil
 }
 
-// Query retrieves metrics matching the given criteria.
-func (s *PostgresStorage) Query(ctx context.Context, name string, start, end time.Time, labels map[string]string) ([]metrics.Metric, error) {
-	query := `
-		SELECT time, name, value, metric_type, hostname, labels, unit
-		FROM metrics
-		WHERE name = $1 AND time >= $2 AND time <= $3
-	`
-	args := []interface{}{name, start, end}
-
-	// Add label filters
+// Query retrieves metrics matching the given criteria. Label filters are
+// matched with JSONB containment, as one parameter whatever the labels, so
+// the GIN index on labels applies and every label filter shares a statement.
+func (s *PostgresStorage) Query(ctx context.Context, name, hostname string, start, end time.Time, labels map[string]string, limit int) ([]metrics.Metric, error) {
+	b := newSelect(`SELECT time, name, value, metric_type, hostname, labels, unit FROM metrics`).
+		Where("name = %s", name).
+		Where("time >= %s", start).
+		Where("time <= %s", end)
+	if hostname != "" {
+		b.Where("hostname = %s", hostname)
+	}
 	if len(labels) > 0 {
-		for k, v := range labels {
-			query += fmt.Sprintf(" AND labels->>'%s' = $%d", k, len(args)+1)
-			args = append(args, v)
+		filter, err := json.Marshal(labels)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode label filter: %w", err)
 		}
+		b.Where("labels @> %s::jsonb", string(filter))
 	}
+	query, args := b.Build("time DESC", limit)
 
-	query += " ORDER BY time DESC LIMIT 10000"
-
-	rows, err := s.db.QueryContext(ctx, query, args...)
+	rows, err := s.queries.Query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query metrics: %w", err)
 	}
@@ -156,6 +160,7 @@ func (s *PostgresStorage) Ping(ctx context.Context) error {
 
 // Close closes the database connection.
 func (s *PostgresStorage) Close() error {
+	s.queries.Close()
 	return s.db.Close()
 }
 
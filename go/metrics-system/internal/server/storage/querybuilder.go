@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// selectBuilder builds a SELECT whose values are all passed as parameters.
+// Conditions are fixed strings with a %s where the placeholder goes, so the
+// SQL text depends only on which filters a query uses (its shape), never on
+// their values: label keys and values can't inject SQL, and queries of the
+// same shape share one prepared statement.
+type selectBuilder struct {
+	selectFrom string
+	where      []string
+	args       []interface{}
+}
+
+// newSelect starts a query from the SELECT ... FROM part.
+func newSelect(selectFrom string) *selectBuilder {
+	return &selectBuilder{selectFrom: selectFrom}
+}
+
+// param adds a value and returns its placeholder.
+func (b *selectBuilder) param(value interface{}) string {
+	b.args = append(b.args, value)
+	return fmt.Sprintf("$%d", len(b.args))
+}
+
+// Where adds a condition, with value in place of its %s.
+func (b *selectBuilder) Where(cond string, value interface{}) *selectBuilder {
+	b.where = append(b.where, fmt.Sprintf(cond, b.param(value)))
+	return b
+}
+
+// Build returns the SQL text and its arguments, ending with the given ORDER
+// BY clause and a LIMIT of limit rows.
+func (b *selectBuilder) Build(orderBy string, limit int) (string, []interface{}) {
+	var sb strings.Builder
+	sb.WriteString(b.selectFrom)
+	for i, cond := range b.where {
+		if i == 0 {
+			sb.WriteString(" WHERE ")
+		} else {
+			sb.WriteString(" AND ")
+		}
+		sb.WriteString(cond)
+	}
+	sb.WriteString(" ORDER BY ")
+	sb.WriteString(orderBy)
+	sb.WriteString(" LIMIT ")
+	sb.WriteString(b.param(limit))
+	return sb.String(), b.args
+}
+
+// stmtCache keeps a prepared statement per SQL text. Built queries only vary
+// by shape, so the cache stays as small as the number of filter combinations
+// and needs no eviction.
+type stmtCache struct {
+	db    *sql.DB
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
+}
+
+func newStmtCache(db *sql.DB) *stmtCache {
+	return &stmtCache{db: db, stmts: make(map[string]*sql.Stmt)}
+}
+
+// Query runs query with a cached prepared statement, preparing it on first use.
+func (c *stmtCache) Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	stmt, err := c.prepare(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.QueryContext(ctx, args...)
+}
+
+func (c *stmtCache) prepare(ctx context.Context, query string) (*sql.Stmt, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if stmt, ok := c.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := c.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare query: %w", err)
+	}
+	c.stmts[query] = stmt
+	return stmt, nil
+}
+
+// Close closes every cached statement.
+func (c *stmtCache) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for query, stmt := range c.stmts {
+		stmt.Close()
+		delete(c.stmts, query)
+	}
+}
@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bellistech/metrics-system/internal/logger"
+	"github.com/bellistech/metrics-system/pkg/metrics"
+)
+
+// ReplicatedStorage writes every batch to a primary storage synchronously and
+// replicates it to a secondary (standby) storage asynchronously.
+//
+// Writes to the secondary go through a bounded in-memory queue and are retried
+// in order until they succeed, so a standby that is briefly unavailable catches
+// up without slowing down ingestion. When the queue is full the oldest batch
+// is dropped. Queries and health checks only use the primary.
+type ReplicatedStorage struct {
+	primary       Storage
+	secondary     Storage
+	capacity      int
+	retryInterval time.Duration
+
+	mu      sync.Mutex
+	queue   []replicationBatch
+	nextSeq uint64
+	stats   ReplicationStats
+	notify  chan struct{}
+
+	// writeMu is held while writing to the secondary, so a host deletion
+	// can't race with a batch already on its way there.
+	writeMu sync.Mutex
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// replicationBatch is a batch waiting to be written to the secondary.
+type replicationBatch struct {
+	seq      uint64
+	metrics  []metrics.Metric
+	enqueued time.Time
+}
+
+// ReplicationStats reports the state of replication to the secondary.
+type ReplicationStats struct {
+	QueueDepth        int       `json:"queue_depth"`
+	QueueCapacity     int       `json:"queue_capacity"`
+	LagSeconds        float64   `json:"lag_seconds"` // age of the oldest unreplicated batch
+	BatchesReplicated uint64    `json:"batches_replicated"`
+	MetricsReplicated uint64    `json:"metrics_replicated"`
+	BatchesDropped    uint64    `json:"batches_dropped"`
+	WriteErrors       uint64    `json:"write_errors"`
+	LastError         string    `json:"last_error,omitempty"`
+	LastErrorTime     time.Time `json:"last_error_time"`
+	LastReplicated    time.Time `json:"last_replicated"`
+}
+
+// NewReplicatedStorage creates a dual-writing storage and starts replicating
+// to the secondary in the background.
+func NewReplicatedStorage(primary, secondary Storage, queueSize int, retryInterval time.Duration) *ReplicatedStorage {
+	if queueSize <= 0 {
+		queueSize = 1000
+	}
+	if retryInterval <= 0 {
+		retryInterval = 5 * time.Second
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &ReplicatedStorage{
+		primary:       primary,
+		secondary:     secondary,
+		capacity:      queueSize,
+		retryInterval: retryInterval,
+		notify:        make(chan struct{}, 1),
+		cancel:        cancel,
+		done:          make(chan struct{}),
+	}
+
+	go r.run(ctx)
+	return r
+}
+
+// Store stores a batch in the primary and queues it for the secondary.
+func (r *ReplicatedStorage) Store(ctx context.Context, metricsList []metrics.Metric) error {
+	if err := r.primary.Store(ctx, metricsList); err != nil {
+		return err
+	}
+	if len(metricsList) == 0 {
+		return nil
+	}
+
+	r.mu.Lock()
+	if len(r.queue) >= r.capacity {
+		r.queue = r.queue[1:]
+		r.stats.BatchesDropped++
+		logger.Warn("Replication queue full (%d batches), dropping oldest batch", r.capacity)
+	}
+	r.nextSeq++
+	r.queue = append(r.queue, replicationBatch{seq: r.nextSeq, metrics: metricsList, enqueued: time.Now()})
+	r.mu.Unlock()
+
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Query retrieves metrics from the primary.
+func (r *ReplicatedStorage) Query(ctx context.Context, name, hostname string, start, end time.Time, labels map[string]string, limit int) ([]metrics.Metric, error) {
+	return r.primary.Query(ctx, name, hostname, start, end, labels, limit)
+}
+
+// Ping checks the primary. The secondary's health is reported by Stats.
+func (r *ReplicatedStorage) Ping(ctx context.Context) error {
+	return r.primary.Ping(ctx)
+}
+
+// Close stops replication and closes both storages.
+// Batches still queued for the secondary are discarded.
+func (r *ReplicatedStorage) Close() error {
+	r.cancel()
+	<-r.done
+
+	r.mu.Lock()
+	if n := len(r.queue); n > 0 {
+		logger.Warn("Discarding %d unreplicated batches on shutdown", n)
+	}
+	r.mu.Unlock()
+
+	if err := r.secondary.Close(); err != nil {
+		logger.Warn("Failed to close secondary storage: %v", err)
+	}
+	return r.primary.Close()
+}
+
+// Stats returns a snapshot of the replication state.
+func (r *ReplicatedStorage) Stats() ReplicationStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := r.stats
+	stats.QueueDepth = len(r.queue)
+	stats.QueueCapacity = r.capacity
+	if len(r.queue) > 0 {
+		stats.LagSeconds = time.Since(r.queue[0].enqueued).Seconds()
+	}
+	return stats
+}
+
+// run replicates queued batches in order until the context is cancelled.
+func (r *ReplicatedStorage) run(ctx context.Context) {
+	defer close(r.done)
+
+	for {
+		r.mu.Lock()
+		var batch replicationBatch
+		pending := len(r.queue) > 0
+		if pending {
+			batch = r.queue[0]
+		}
+		r.mu.Unlock()
+
+		if !pending {
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.notify:
+				continue
+			}
+		}
+
+		r.writeMu.Lock()
+		writeCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		err := r.secondary.Store(writeCtx, batch.metrics)
+		cancel()
+		r.writeMu.Unlock()
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			r.mu.Lock()
+			r.stats.WriteErrors++
+			r.stats.LastError = err.Error()
+			r.stats.LastErrorTime = time.Now()
+			r.mu.Unlock()
+			logger.Warn("Replication to secondary failed, retrying in %s: %v", r.retryInterval, err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(r.retryInterval):
+			}
+			continue
+		}
+
+		r.mu.Lock()
+		// The batch may have been dropped while we were writing it
+		if len(r.queue) > 0 && r.queue[0].seq == batch.seq {
+			r.queue = r.queue[1:]
+		}
+		r.stats.BatchesReplicated++
+		r.stats.MetricsReplicated += uint64(len(batch.metrics))
+		r.stats.LastReplicated = time.Now()
+		r.mu.Unlock()
+	}
+}
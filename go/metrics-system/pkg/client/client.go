@@ -0,0 +1,36 @@
+// Package client lets other programs send metrics to a metrics server, with
+// the same gRPC client the agent uses.
+package client
+
+import (
+	"context"
+
+	"github.com/bellistech/metrics-system/internal/agent"
+	"github.com/bellistech/metrics-system/pkg/metrics"
+)
+
+// Client sends metrics to a metrics server.
+type Client struct {
+	agent *agent.Client
+}
+
+// New connects to the metrics server at address (host:port of its gRPC
+// listener). hostname is reported as the source of every batch and sourceID
+// identifies the sender the way an agent ID does.
+func New(address, hostname, sourceID string) (*Client, error) {
+	c, err := agent.NewClient(address, hostname, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{agent: c}, nil
+}
+
+// Send sends one batch of metrics.
+func (c *Client) Send(ctx context.Context, batch []metrics.Metric) error {
+	return c.agent.SendMetrics(ctx, batch)
+}
+
+// Close closes the connection to the server.
+func (c *Client) Close() error {
+	return c.agent.Close()
+}
@@ -0,0 +1,65 @@
+// =============================================================================
+// ADAPTIVE TIMEOUT - Not waiting longer than we need to
+// =============================================================================
+//
+// Some routers never answer our probes. For each of those we wait the full
+// timeout (-w, 3 seconds by default) before printing "*". A trace with a
+// handful of silent hops can spend most of its time just... waiting.
+//
+// But once we've seen a few replies, we know roughly how far away the
+// destination is. If the slowest reply so far took 40 milliseconds, a reply
+// that hasn't arrived after 120 milliseconds almost certainly never will.
+//
+// So we keep track of the slowest round trip seen for each destination, and
+// give up on a probe once it has waited AdaptiveFactor times that long.
+// Modern traceroutes do the same (the "-w MAX,HERE,NEAR" option of Linux
+// traceroute):
+//
+//   Slowest reply so far:   40ms
+//   Wait for other probes:  3 x 40ms = 120ms (but at least 500ms)
+//
+// The wait never goes below MinAdaptiveTimeout (a single fast hop shouldn't
+// make us impatient with the rest) and never above -w. Before the first
+// reply arrives we wait the full -w, since we have nothing to go on.
+//
+// Probes already waiting when a slow reply arrives keep waiting longer too:
+// the timeout is checked again every adaptiveRecheck while we wait.
+//
+// Use -adaptive=false to always wait the full -w.
+//
+// =============================================================================
+
+package main
+
+import "time"
+
+const (
+	// AdaptiveFactor is how many times the slowest reply so far we wait.
+	AdaptiveFactor = 3
+
+	// MinAdaptiveTimeout is the shortest the adaptive timeout ever gets.
+	MinAdaptiveTimeout = 500 * time.Millisecond
+
+	// adaptiveRecheck is how often a waiting probe looks at the timeout
+	// again, in case it changed since the probe was sent.
+	adaptiveRecheck = 50 * time.Millisecond
+)
+
+// timeout returns how long a probe should wait for its reply right now.
+func (p *Prober) timeout() time.Duration {
+	slowest := time.Duration(p.slowest.Load())
+	if !p.cfg.Adaptive || slowest == 0 {
+		return p.cfg.Timeout
+	}
+	return min(max(AdaptiveFactor*slowest, MinAdaptiveTimeout), p.cfg.Timeout)
+}
+
+// noteRTT remembers rtt if it's the slowest reply seen so far.
+func (p *Prober) noteRTT(rtt time.Duration) {
+	for {
+		slowest := p.slowest.Load()
+		if int64(rtt) <= slowest || p.slowest.CompareAndSwap(slowest, int64(rtt)) {
+			return
+		}
+	}
+}
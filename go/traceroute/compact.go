@@ -0,0 +1,97 @@
+// =============================================================================
+// COMPACT OUTPUT - Shorter router names and labeled private hops
+// =============================================================================
+//
+// Inside a big company network, a trace can look like this:
+//
+//    3   1.21ms  ...  10.20.0.1      core-sw1.bldg4.campus.corp.example.com
+//    4   2.05ms  ...  10.90.0.1      fw-edge2.dc1.datacenter.corp.example.com
+//
+// Every name ends the same way, and on a narrow terminal that shared ending
+// pushes the part that's actually different off the screen.
+//
+// With -trim-suffix we cut the ending off every router name we show:
+//
+//   sudo go run . -trim-suffix corp.example.com,example.net 10.90.0.1
+//
+//    3   1.21ms  ...  10.20.0.1      core-sw1.bldg4.campus
+//    4   2.05ms  ...  10.90.0.1      fw-edge2.dc1.datacenter
+//
+// Give several endings separated by commas (or repeat the option); the
+// longest one that matches is cut. Only whole labels are cut ("example.com"
+// shortens "r1.example.com", not "r1.myexample.com"), and a name that IS
+// the ending is left alone. -json and -save keep the full names, because
+// programs reading them shouldn't have to guess what was cut.
+//
+// With -label-private, hops with addresses that only mean something inside
+// one network are pointed out:
+//
+//   - 10.0.0.0/8, 172.16.0.0/12, 192.168.0.0/16: private (RFC 1918), for
+//     home and company networks
+//   - 100.64.0.0/10: carrier-grade NAT (RFC 6598), used INSIDE an ISP that
+//     shares one public address between many customers
+//   - fc00::/7: unique local IPv6 addresses (RFC 4193), the IPv6 private ones
+//
+// Where the private hops end is usually where your network (or your ISP's
+// NAT) ends and the Internet begins.
+//
+// =============================================================================
+
+package main
+
+import (
+	"net"
+	"strings"
+)
+
+// labelPrivate is set by -label-private.
+var labelPrivate bool
+
+// cgnatNet is the shared address space of carrier-grade NAT (RFC 6598).
+var cgnatNet = &net.IPNet{IP: net.IP{100, 64, 0, 0}, Mask: net.CIDRMask(10, 32)}
+
+// parseSuffixes splits a -trim-suffix value into domain endings, lower-case
+// and without leading or trailing dots.
+func parseSuffixes(value string) []string {
+	var suffixes []string
+	for _, s := range strings.Split(value, ",") {
+		s = strings.ToLower(strings.Trim(strings.TrimSpace(s), "."))
+		if s != "" {
+			suffixes = append(suffixes, s)
+		}
+	}
+	return suffixes
+}
+
+// trimHostname cuts the longest matching ending off a router name. Endings
+// only match whole labels, and a name is never cut down to nothing.
+func trimHostname(name string, suffixes []string) string {
+	lower := strings.ToLower(name)
+	best := 0
+	for _, s := range suffixes {
+		if len(s) > best && len(lower) > len(s)+1 && strings.HasSuffix(lower, "."+s) {
+			best = len(s)
+		}
+	}
+	if best == 0 {
+		return name
+	}
+	return name[:len(name)-best-1]
+}
+
+// privateLabel says what kind of private address ip is, or returns "" for
+// a public one.
+func privateLabel(ip string) string {
+	addr := net.ParseIP(ip)
+	switch {
+	case addr == nil:
+		return ""
+	case cgnatNet.Contains(addr):
+		return "🏢 carrier NAT (RFC 6598)"
+	case addr.IsPrivate() && addr.To4() != nil:
+		return "🔒 private (RFC 1918)"
+	case addr.IsPrivate():
+		return "🔒 private (IPv6 ULA)"
+	}
+	return ""
+}
@@ -0,0 +1,97 @@
+// =============================================================================
+// CSV EXPORT - Keeping a history of traces
+// =============================================================================
+//
+// Running traceroute every few minutes (from cron, say) is a great way to
+// catch a route that changes, or a hop that only gets slow at night. But
+// you need the results somewhere you can look at them later.
+//
+// With -csv FILE we ADD one row per hop to FILE every time we run, so the
+// file grows into a history you can open in a spreadsheet or load into a
+// database:
+//
+//   timestamp,target,ttl,responder,rtt1,rtt2,rtt3,loss
+//   2024-11-20T10:00:00Z,google.com,1,192.168.1.1,1.203,0.981,1.114,0
+//   2024-11-20T10:00:00Z,google.com,2,,,,,100
+//
+// - timestamp: when the run started (the same for every row of one run)
+// - responder: the router that answered ("" if nobody did)
+// - rtt1..N:   round-trip time of each probe in milliseconds ("" if lost)
+// - loss:      percentage of the hop's probes that got no reply
+//
+// The header is only written when the file is new (or empty), so the same
+// file can be appended to forever.
+//
+// =============================================================================
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// openCSV opens (or creates) the CSV file we append to.
+func openCSV(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+}
+
+// saveCSV appends the traces to the CSV file, and only warns if that fails -
+// the trace itself was still printed.
+func saveCSV(f *os.File, started time.Time, numProbes int, traces []multiResult) {
+	if err := appendCSV(f, started, numProbes, traces); err != nil {
+		fmt.Printf("⚠️  Could not write to %s: %v\n", f.Name(), err)
+	}
+}
+
+// appendCSV adds one row per hop of every trace to the file. numProbes
+// decides how many rtt columns there are.
+func appendCSV(f *os.File, started time.Time, numProbes int, traces []multiResult) error {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		header := []string{"timestamp", "target", "ttl", "responder"}
+		for i := 1; i <= numProbes; i++ {
+			header = append(header, fmt.Sprintf("rtt%d", i))
+		}
+		w.Write(append(header, "loss"))
+	}
+
+	timestamp := started.UTC().Format(time.RFC3339)
+	for _, trace := range traces {
+		for _, hop := range trace.Trace {
+			row := []string{timestamp, trace.Destination, strconv.Itoa(hop.TTL), ""}
+			rtts := make([]string, numProbes)
+			for i, p := range hop.Probes {
+				if p.Responder == "" || i >= numProbes {
+					continue
+				}
+				row[3] = p.Responder
+				rtts[i] = strconv.FormatFloat(float64(p.RTT.Microseconds())/1000, 'f', 3, 64)
+			}
+			row = append(row, rtts...)
+			loss := math.Round(hop.Loss()*10) / 10
+			w.Write(append(row, strconv.FormatFloat(loss, 'f', -1, 64)))
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+
+	// One write for the whole run, so runs that overlap (two cron jobs
+	// appending to the same file) don't mix their rows
+	_, err = f.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,283 @@
+// =============================================================================
+// COMPARE - What changed since last time?
+// =============================================================================
+//
+// "The network is slow since this morning's change" - but is the path any
+// different? To find out you need the trace from BEFORE the change, and a
+// careful eye to spot what moved. Let the computer do the spotting!
+//
+// First save a trace as JSON (see json.go), then compare a new one with it:
+//
+//   sudo go run . -json example.com > before.json
+//   ...make the change...
+//   sudo go run . -compare before.json example.com
+//
+// Or compare two saved traces, without tracing anything:
+//
+//   go run . -compare before.json after.json
+//
+// We line the two paths up like the "diff" tool lines up two versions of a
+// file: routers found in both stay side by side even if a hop was added or
+// removed in between (which shifts every hop after it by one). Each line
+// starts with what happened to that hop:
+//
+//   =  same router as before
+//   !  same router, but its RTT or loss changed a lot
+//   ~  a different router answered at this point of the path
+//   +  a hop that wasn't there before
+//   -  a hop that has disappeared
+//
+// =============================================================================
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// diffRTTChange is how much slower or faster (as a fraction of the
+	// old RTT) a router must get to be flagged with "!"...
+	diffRTTChange = 0.5
+
+	// ...as long as that's more than diffMinRTTChange milliseconds, so
+	// 0.2ms becoming 0.4ms on the local network doesn't count.
+	diffMinRTTChange = 5.0
+
+	// diffLossChange is how many percentage points the loss must change by
+	// to be flagged with "!".
+	diffLossChange = 20.0
+)
+
+// loadTrace reads a trace saved with -json. Files from several destinations
+// (or -dual) hold a list; we pick the one for destination, or the first one
+// if destination is "".
+func loadTrace(path, destination string) (jsonTrace, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return jsonTrace{}, err
+	}
+
+	var traces []jsonTrace
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		err = json.Unmarshal(data, &traces)
+	} else {
+		var trace jsonTrace
+		err = json.Unmarshal(data, &trace)
+		traces = append(traces, trace)
+	}
+	if err != nil {
+		return jsonTrace{}, fmt.Errorf("not a trace saved with -json: %w", err)
+	}
+	if len(traces) == 0 {
+		return jsonTrace{}, fmt.Errorf("no traces in the file")
+	}
+
+	if destination == "" || len(traces) == 1 {
+		return traces[0], nil
+	}
+	for _, t := range traces {
+		if t.Destination == destination || t.Address == destination {
+			return t, nil
+		}
+	}
+	return jsonTrace{}, fmt.Errorf("none of its %d traces is for %s", len(traces), destination)
+}
+
+// compareSaved compares two saved traces and prints the differences.
+func compareSaved(beforePath, afterPath string) {
+	after, err := loadTrace(afterPath, "")
+	if err != nil {
+		fmt.Printf("❌ ERROR: Could not read %s: %v\n", afterPath, err)
+		os.Exit(1)
+	}
+	before, err := loadTrace(beforePath, after.Destination)
+	if err != nil {
+		fmt.Printf("❌ ERROR: Could not read %s: %v\n", beforePath, err)
+		os.Exit(1)
+	}
+	printDiff(before, after, beforePath, afterPath)
+}
+
+// isSavedTrace reports whether a destination is really a saved trace to
+// compare with, rather than something to trace.
+func isSavedTrace(destination string) bool {
+	if !strings.HasSuffix(destination, ".json") {
+		return false
+	}
+	_, err := os.Stat(destination)
+	return err == nil
+}
+
+// diffLine is one line of the comparison: a hop from before, after, or both.
+type diffLine struct {
+	mark          string
+	before, after *jsonHop
+}
+
+// diffTraces lines up the hops of two traces. It's the classic "longest
+// common subsequence" algorithm: find the longest list of responders the
+// two paths have in the same order, and everything else was added or
+// removed. A removed hop right where one was added becomes a change.
+func diffTraces(before, after []jsonHop) []diffLine {
+	same := func(i, j int) bool { return before[i].Responder == after[j].Responder }
+
+	// common[i][j] = how many hops before[i:] and after[j:] have in common
+	common := make([][]int, len(before)+1)
+	for i := range common {
+		common[i] = make([]int, len(after)+1)
+	}
+	for i := len(before) - 1; i >= 0; i-- {
+		for j := len(after) - 1; j >= 0; j-- {
+			if same(i, j) {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	var lines []diffLine
+	var removed, added []*jsonHop
+	flush := func() {
+		// Pair up hops removed and added at the same spot as changes
+		for len(removed) > 0 && len(added) > 0 {
+			lines = append(lines, diffLine{mark: "~", before: removed[0], after: added[0]})
+			removed, added = removed[1:], added[1:]
+		}
+		for _, h := range removed {
+			lines = append(lines, diffLine{mark: "-", before: h})
+		}
+		for _, h := range added {
+			lines = append(lines, diffLine{mark: "+", after: h})
+		}
+		removed, added = nil, nil
+	}
+
+	i, j := 0, 0
+	for i < len(before) || j < len(after) {
+		switch {
+		case i < len(before) && j < len(after) && same(i, j):
+			flush()
+			mark := "="
+			if hopChanged(before[i], after[j]) {
+				mark = "!"
+			}
+			lines = append(lines, diffLine{mark: mark, before: &before[i], after: &after[j]})
+			i++
+			j++
+		case j == len(after) || (i < len(before) && common[i+1][j] >= common[i][j+1]):
+			removed = append(removed, &before[i])
+			i++
+		default:
+			added = append(added, &after[j])
+			j++
+		}
+	}
+	flush()
+	return lines
+}
+
+// hopChanged reports whether the same router got a lot slower or faster,
+// or started (or stopped) losing probes.
+func hopChanged(before, after jsonHop) bool {
+	if math.Abs(after.LossPercent-before.LossPercent) >= diffLossChange {
+		return true
+	}
+	if before.Stats == nil || after.Stats == nil {
+		return false
+	}
+	change := math.Abs(after.Stats.Avg - before.Stats.Avg)
+	return change > diffMinRTTChange && change > before.Stats.Avg*diffRTTChange
+}
+
+// printDiff prints the two traces lined up, and counts what changed.
+func printDiff(before, after jsonTrace, beforeName, afterName string) {
+	lines := diffTraces(before.Hops, after.Hops)
+
+	// Work out every cell first, so each side is only as wide as it needs
+	cells := make([][2]dualCell, len(lines))
+	widths := [2]int{len(beforeName), len(afterName)}
+	counts := make(map[string]int)
+	for n, line := range lines {
+		for side, hop := range []*jsonHop{line.before, line.after} {
+			cells[n][side] = newDiffCell(hop)
+			widths[side] = max(widths[side], len(cells[n][side].host))
+		}
+		counts[line.mark]++
+	}
+
+	fmt.Printf("🔍 Comparing %s with %s\n", afterName, beforeName)
+	fmt.Println()
+	fmt.Printf("     Hop  %-*s  %-7s %-6s  Hop  %s\n", widths[0], beforeName, "RTT", "Loss", afterName)
+	fmt.Printf("     ───  %-*s  %-7s %-6s  ───  %s\n", widths[0], "──────", "───", "────", "─────")
+	for n, line := range lines {
+		b, a := cells[n][0], cells[n][1]
+		text := fmt.Sprintf(" %s   %3s  %-*s  %-7s %-6s  %3s  %-*s  %-7s %s",
+			line.mark, diffTTL(line.before), widths[0], b.host, b.rtt, b.loss,
+			diffTTL(line.after), widths[1], a.host, a.rtt, a.loss)
+		fmt.Println(strings.TrimRight(text, " "))
+	}
+
+	fmt.Println()
+	fmt.Printf("   Before: %s\n", diffStatus(before))
+	fmt.Printf("   After:  %s\n", diffStatus(after))
+	fmt.Println()
+	if counts["~"]+counts["+"]+counts["-"]+counts["!"] == 0 {
+		fmt.Println("✅ Same path as before")
+		return
+	}
+	fmt.Printf("⚠️  %d changed, %d appeared, %d disappeared, %d slower/faster or lossier\n",
+		counts["~"], counts["+"], counts["-"], counts["!"])
+}
+
+// newDiffCell describes one side of a line: who answered, the average RTT
+// and the loss. A hop missing on this side is left blank.
+func newDiffCell(hop *jsonHop) dualCell {
+	switch {
+	case hop == nil:
+		return dualCell{}
+	case hop.Responder == "":
+		return dualCell{host: "*", rtt: "-", loss: "100%"}
+	}
+
+	host := hop.Responder
+	if hop.Hostname != "" {
+		host = hop.Hostname
+	}
+	if len(host) > dualHostWidth {
+		host = host[:dualHostWidth-3] + "..."
+	}
+	cell := dualCell{host: host, loss: fmt.Sprintf("%.0f%%", hop.LossPercent)}
+	if hop.Stats != nil {
+		cell.rtt = formatRTT(time.Duration(hop.Stats.Avg * float64(time.Millisecond)))
+	}
+	return cell
+}
+
+func diffTTL(hop *jsonHop) string {
+	if hop == nil {
+		return ""
+	}
+	return fmt.Sprint(hop.TTL)
+}
+
+// diffStatus sums up how one of the traces went.
+func diffStatus(t jsonTrace) string {
+	switch {
+	case t.Error != "":
+		return "❌ " + t.Error
+	case t.Reached && len(t.Hops) > 0:
+		return fmt.Sprintf("✅ reached %s in %d hops", t.Address, t.Hops[len(t.Hops)-1].TTL)
+	case t.Loop != nil:
+		return fmt.Sprintf("🔁 loop at hops %d-%d", t.Loop.FirstTTL, t.Loop.LastTTL)
+	case t.GaveUp != nil:
+		return fmt.Sprintf("🔇 gave up after silent hops %d-%d", t.GaveUp.FirstTTL, t.GaveUp.LastTTL)
+	}
+	return fmt.Sprintf("⚠️  %s not reached", t.Address)
+}
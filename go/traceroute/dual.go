@@ -0,0 +1,202 @@
+// =============================================================================
+// DUAL STACK - Tracing the IPv4 and IPv6 paths side by side
+// =============================================================================
+//
+// Many places on the internet have two addresses: an old-style IPv4 one
+// (an "A" record in DNS) and a new IPv6 one (an "AAAA" record). Your
+// computer picks one of them for you, and you never notice which.
+//
+// But the two families are routed SEPARATELY. The IPv6 packets might go
+// through a different network, a tunnel, or round the world the long way,
+// while IPv4 goes straight there. When "the website is slow for some
+// people", this is a classic reason!
+//
+// With -dual we look up both addresses, trace both at the same time (one
+// socket for each family), and print the two paths next to each other:
+//
+//   Hop   IPv4                RTT     Loss   IPv6           RTT     Loss
+//     1   router.home         0.52ms  0%     router.home    0.61ms  0%
+//     2   isp-gw.example.net  8ms     0%     *              -       100%
+//
+// Each side shows who answered at that hop, their average round-trip time
+// and how many probes got no answer. If the two sides look very different
+// - different networks, or one much slower - you've found an asymmetry.
+//
+// If the name only has one kind of address, we just trace that one.
+//
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// dualHostWidth is the most room a router's name gets on each side - as
+// much as a full IPv6 address needs.
+const dualHostWidth = 39
+
+// lookupDual finds the first IPv4 and the first IPv6 address of a name.
+// Either can be nil if the name doesn't have one.
+func lookupDual(destination string) (v4, v6 *net.IPAddr, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DNSTimeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, destination)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range addrs {
+		switch {
+		case addrs[i].IP.To4() != nil && v4 == nil:
+			v4 = &addrs[i]
+		case addrs[i].IP.To4() == nil && v6 == nil:
+			v6 = &addrs[i]
+		}
+	}
+	return v4, v6, nil
+}
+
+// traceDual looks up both addresses of destination, traces them and prints
+// them side by side. It returns false, having traced nothing, if the name
+// only has one kind of address.
+func traceDual(destination string, sockOpts socketOptions, cfg ProberConfig, opts traceOptions) ([]multiResult, bool) {
+	fmt.Printf("📡 Looking up the IPv4 and IPv6 addresses of '%s'...\n", destination)
+	v4, v6, err := lookupDual(destination)
+	if err != nil {
+		fmt.Printf("❌ ERROR: Could not find IP addresses for '%s': %v\n", destination, err)
+		os.Exit(1)
+	}
+	if v4 == nil || v6 == nil {
+		family := "IPv4"
+		if v4 == nil {
+			family = "IPv6"
+		}
+		fmt.Printf("ℹ️  %s only has an %s address, so there is only one path to trace\n", destination, family)
+		fmt.Println()
+		return nil, false
+	}
+	fmt.Printf("✅ Found %s and %s\n", v4.IP, v6.IP)
+	fmt.Println()
+
+	results := runDual(destination, v4, v6, sockOpts, cfg, opts)
+
+	fmt.Printf("🌐 IPv4 and IPv6 routes to %s, maximum %d hops, %d probes per hop\n", destination, opts.MaxHops, opts.NumProbes)
+	fmt.Println()
+	printDual(results, opts.Names)
+	return results, true
+}
+
+// runDual traces both addresses at the same time, each over a socket of
+// its own family, and returns the IPv4 result first.
+func runDual(destination string, v4, v6 *net.IPAddr, sockOpts socketOptions, cfg ProberConfig, opts traceOptions) []multiResult {
+	addrs := []*net.IPAddr{v4, v6}
+	results := make([]multiResult, len(addrs))
+
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		sock := mustOpenSocket(destination, sockOpts.forFamily(addr))
+		prober := NewProber(sock, addr, cfg)
+
+		wg.Add(1)
+		go func(i int, addr *net.IPAddr) {
+			defer wg.Done()
+			defer sock.Close()
+			results[i] = traceAddr(prober, destination, addr, opts, func(hop hopResult) {})
+		}(i, addr)
+	}
+	wg.Wait()
+	return results
+}
+
+// printDual prints the IPv4 and IPv6 traces next to each other, one hop per
+// line, and a line about each at the end.
+func printDual(results []multiResult, names *nameCache) {
+	// Work out every cell first, so each side is only as wide as it needs
+	rows := 0
+	for _, r := range results {
+		rows = max(rows, len(r.Trace))
+	}
+	ttls := make([]int, rows)
+	cells := make([][]dualCell, len(results))
+	widths := make([]int, len(results))
+	for side, r := range results {
+		widths[side] = len("IPv4")
+		cells[side] = make([]dualCell, rows)
+		for i, hop := range r.Trace {
+			ttls[i] = hop.TTL
+			cells[side][i] = newDualCell(hop, names)
+			widths[side] = max(widths[side], len(cells[side][i].host))
+		}
+	}
+
+	titles := "Hop   "
+	lines := "───   "
+	for side, r := range results {
+		family := "IPv4"
+		if r.Addr.IP.To4() == nil {
+			family = "IPv6"
+		}
+		titles += fmt.Sprintf("%-*s  %-7s %-6s ", widths[side], family, "RTT", "Loss")
+		lines += fmt.Sprintf("%-*s  %-7s %-6s ", widths[side], "────", "───", "────")
+	}
+	fmt.Println(strings.TrimRight(titles, " "))
+	fmt.Println(strings.TrimRight(lines, " "))
+
+	// Both traces start at the same TTL, but one may stop sooner and leave
+	// its side blank
+	for i := 0; i < rows; i++ {
+		line := fmt.Sprintf("%3d   ", ttls[i])
+		for side := range results {
+			c := cells[side][i]
+			line += fmt.Sprintf("%-*s  %-7s %-6s ", widths[side], c.host, c.rtt, c.loss)
+		}
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+
+	fmt.Println()
+	for _, r := range results {
+		fmt.Printf("   %s: %s\n", r.Addr.IP, dualStatus(r))
+	}
+}
+
+// dualCell is one side of a hop: who answered, the average RTT and the loss.
+type dualCell struct {
+	host, rtt, loss string
+}
+
+func newDualCell(hop hopResult, names *nameCache) dualCell {
+	stats := hop.Stats()
+	if stats.Received == 0 {
+		return dualCell{host: "*", rtt: "-", loss: "100%"}
+	}
+
+	host := stats.Responder
+	if name := names.Wait(host); name != "" && name != NoHostname {
+		host = name
+	}
+	if len(host) > dualHostWidth {
+		host = host[:dualHostWidth-3] + "..."
+	}
+	return dualCell{host: host, rtt: formatRTT(stats.Avg()), loss: fmt.Sprintf("%.0f%%", stats.Loss())}
+}
+
+// dualStatus sums up how one of the traces went.
+func dualStatus(r multiResult) string {
+	switch {
+	case r.Reached:
+		return fmt.Sprintf("✅ reached in %d hops, %s", r.Hops, formatRTT(r.RTT))
+	case r.Loop != nil:
+		return fmt.Sprintf("🔁 loop at hops %d-%d", r.Loop.FirstTTL, r.Loop.LastTTL)
+	case r.Silent != nil:
+		return fmt.Sprintf("🔇 gave up after silent hops %d-%d", r.Silent.FirstTTL, r.Silent.LastTTL)
+	case r.Hops > 0:
+		return fmt.Sprintf("⚠️  not reached, last answer from hop %d", r.Hops)
+	}
+	return "⚠️  not reached, no hop answered"
+}
@@ -0,0 +1,53 @@
+// =============================================================================
+// DEFAULT GATEWAY - Spotting your own router
+// =============================================================================
+//
+// Almost every trace starts at the same place: the "default gateway", the
+// router your computer hands every packet that isn't for its own network
+// (your home router, or the office's). When hop 1 is slow or lossy, the
+// problem is right next to you - so it helps to know that hop 1 IS your
+// gateway and not some router further away:
+//
+//    1   0.52ms     0.48ms     0.47ms     0%     192.168.1.1        router.lan  🏠 your default gateway
+//
+// Each operating system keeps its routing table in a different place, so
+// reading it lives in gateway_linux.go, gateway_bsd.go and
+// gateway_other.go. We only look for the default route(s): if a more
+// specific route sends this trace through another router, hop 1 isn't
+// labeled.
+//
+// Over IPv6 the gateway is often known by its link-local address (fe80::...)
+// while it answers traceroute from a global one, so it can go unlabeled.
+//
+// =============================================================================
+
+package main
+
+import (
+	"net"
+	"sync"
+)
+
+// defaultGateways returns the default gateways from the routing table,
+// reading it only the first time.
+var defaultGateways = sync.OnceValue(readDefaultGateways)
+
+// isDefaultGateway reports whether hop is the first hop and was answered
+// by one of our default gateways.
+func isDefaultGateway(hop hopResult) bool {
+	if hop.TTL != 1 {
+		return false
+	}
+	for _, p := range hop.Probes {
+		if p.Responder == "" {
+			continue
+		}
+		ip := net.ParseIP(p.Responder)
+		for _, gw := range defaultGateways() {
+			if gw.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
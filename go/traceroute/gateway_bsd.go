@@ -0,0 +1,50 @@
+//go:build darwin || freebsd
+
+package main
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/net/route"
+)
+
+// readDefaultGateways reads the default gateways from the kernel's routing
+// table: routes through a router whose destination is 0.0.0.0 or ::.
+func readDefaultGateways() []net.IP {
+	rib, err := route.FetchRIB(syscall.AF_UNSPEC, route.RIBTypeRoute, 0)
+	if err != nil {
+		return nil
+	}
+	messages, err := route.ParseRIB(route.RIBTypeRoute, rib)
+	if err != nil {
+		return nil
+	}
+
+	var gateways []net.IP
+	for _, m := range messages {
+		r, ok := m.(*route.RouteMessage)
+		if !ok || r.Flags&syscall.RTF_GATEWAY == 0 || len(r.Addrs) <= syscall.RTAX_GATEWAY {
+			continue
+		}
+		switch dst := r.Addrs[syscall.RTAX_DST].(type) {
+		case *route.Inet4Addr:
+			if dst.IP != [4]byte{} {
+				continue
+			}
+		case *route.Inet6Addr:
+			if dst.IP != [16]byte{} {
+				continue
+			}
+		default:
+			continue
+		}
+		switch gw := r.Addrs[syscall.RTAX_GATEWAY].(type) {
+		case *route.Inet4Addr:
+			gateways = append(gateways, net.IP(gw.IP[:]))
+		case *route.Inet6Addr:
+			gateways = append(gateways, net.IP(gw.IP[:]))
+		}
+	}
+	return gateways
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// rtfGateway marks a route that goes through a router.
+const rtfGateway = 0x2
+
+// readDefaultGateways reads the default gateways from the routing tables
+// Linux shows in /proc, with addresses written as hex digits. The default
+// routes are the ones whose destination and prefix length are zero.
+func readDefaultGateways() []net.IP {
+	var gateways []net.IP
+
+	// Iface Destination Gateway Flags RefCnt Use Metric Mask ...
+	// Addresses are 32-bit numbers in the machine's byte order.
+	eachRoute("/proc/net/route", func(fields []string) {
+		if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+			return
+		}
+		flags, err := strconv.ParseUint(fields[3], 16, 32)
+		if err != nil || flags&rtfGateway == 0 {
+			return
+		}
+		gw, err := strconv.ParseUint(fields[2], 16, 32)
+		if err != nil {
+			return
+		}
+		ip := make(net.IP, 4)
+		binary.NativeEndian.PutUint32(ip, uint32(gw))
+		gateways = append(gateways, ip)
+	})
+
+	// Destination PrefixLen Source SourcePrefixLen NextHop Metric ...
+	eachRoute("/proc/net/ipv6_route", func(fields []string) {
+		if len(fields) < 10 || fields[1] != "00" || strings.Trim(fields[0], "0") != "" {
+			return
+		}
+		gw, err := hex.DecodeString(fields[4])
+		if err != nil || len(gw) != net.IPv6len || net.IP(gw).IsUnspecified() {
+			return
+		}
+		gateways = append(gateways, net.IP(gw))
+	})
+
+	return gateways
+}
+
+// eachRoute calls fn with the fields of every line of a /proc routing
+// table.
+func eachRoute(path string, fn func(fields []string)) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fn(strings.Fields(scanner.Text()))
+	}
+}
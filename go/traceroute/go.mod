@@ -3,7 +3,19 @@ module github.com/bellistech/traceroute
 go 1.21
 
 require (
+	github.com/bellistech/metrics-system v0.0.0
 	golang.org/x/net v0.19.0
 )
 
-require golang.org/x/sys v0.15.0 // indirect
+require (
+	github.com/golang/protobuf v1.5.3 // indirect
+	golang.org/x/sys v0.15.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20231106174013-bbf56f31fb17 // indirect
+	google.golang.org/grpc v1.59.0 // indirect
+	google.golang.org/protobuf v1.31.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+// Only needed when building with -tags metrics (see metrics.go)
+replace github.com/bellistech/metrics-system => ../metrics-system
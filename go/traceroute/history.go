@@ -0,0 +1,353 @@
+// =============================================================================
+// HISTORY - Watching a path change over time
+// =============================================================================
+//
+// One trace is a snapshot: it tells you how packets travel right now. But
+// the question is often "when did this start?" or "does it only happen in
+// the evening?" - and for that you need snapshots taken all day long.
+//
+// With -every we trace again and again on a schedule, until you press
+// Ctrl+C, and -save adds each trace to a history file:
+//
+//   sudo go run . -every 5m -save history.jsonl google.com
+//
+// The history file is "JSON Lines": one line per trace, each line the same
+// JSON that -json prints (see json.go) plus the time the trace ran. New
+// traces are ADDED to the end, so the file can grow for as long as you like,
+// and -save works for single runs from cron too.
+//
+// Later, -history reads the file back and shows how things evolved, without
+// tracing anything:
+//
+//   go run . -history history.jsonl [destination]
+//
+//   📜 google.com: 4 traces, from 2024-11-20 10:00 to 2024-11-20 10:15
+//
+//      When                 Hops  RTT        Loss  Path
+//      2024-11-20 10:00:00  9     12.31ms    0%    192.168.1.1 → 10.0.0.1 → ...
+//      2024-11-20 10:05:00  9     12.05ms    0%    same path
+//      2024-11-20 10:10:00  10    48.77ms    0%    ~ 192.168.1.1 → 10.0.0.9 → ...
+//      2024-11-20 10:15:00  10    47.90ms    0%    same path
+//
+// followed by each router of the latest trace, with its RTT the first and
+// the last time it was seen, and the best and worst RTT in between.
+//
+// =============================================================================
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// historyEntry is one line of a history file: a trace and when it ran.
+type historyEntry struct {
+	Time time.Time `json:"time"`
+	jsonTrace
+}
+
+// openHistory opens (or creates) the history file we append to.
+func openHistory(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+}
+
+// saveHistory appends the traces to the history file, and only warns if
+// that fails - the trace itself was still printed.
+func saveHistory(f *os.File, started time.Time, traces []jsonTrace) {
+	if err := appendHistory(f, started, traces); err != nil {
+		fmt.Printf("⚠️  Could not write to %s: %v\n", f.Name(), err)
+	}
+}
+
+// appendHistory adds one line per trace to the file.
+func appendHistory(f *os.File, started time.Time, traces []jsonTrace) error {
+	var buf bytes.Buffer
+	for _, trace := range traces {
+		line, err := json.Marshal(historyEntry{Time: started.UTC().Truncate(time.Second), jsonTrace: trace})
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	// One write for the whole run, like appendCSV, so runs that overlap
+	// don't mix their lines
+	_, err := f.Write(buf.Bytes())
+	return err
+}
+
+// runEvery traces the destinations every interval until the user presses
+// Ctrl+C. Each round is printed like several destinations are (see
+// multi.go), then handed to save. Rounds that take longer than the
+// interval are followed by the next one straight away.
+func runEvery(engine *probeEngine, destinations []string, opts traceOptions, parallel int, every time.Duration, save func(time.Time, []multiResult)) {
+	// Catch Ctrl+C so we stop between rounds (or drop the one running)
+	// instead of dying halfway through writing the history
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupted)
+
+	for rounds := 1; ; rounds++ {
+		started := time.Now()
+		fmt.Printf("⏰ Round %d at %s\n", rounds, started.Format("2006-01-02 15:04:05"))
+		fmt.Println()
+
+		done := make(chan []multiResult, 1)
+		go func() {
+			results, _ := runMulti(engine, destinations, opts, parallel)
+			done <- results
+		}()
+
+		select {
+		case results := <-done:
+			save(started, results)
+		case <-interrupted:
+			fmt.Println()
+			fmt.Printf("👋 Stopped after %d %s (the unfinished one wasn't saved)\n", rounds-1, plural(rounds-1, "round", "rounds"))
+			return
+		}
+
+		next := started.Add(every)
+		fmt.Println()
+		fmt.Printf("💤 Next round at %s (Ctrl+C to stop)\n", next.Format("15:04:05"))
+		fmt.Println()
+
+		select {
+		case <-time.After(time.Until(next)):
+		case <-interrupted:
+			fmt.Printf("👋 Stopped after %d %s\n", rounds, plural(rounds, "round", "rounds"))
+			return
+		}
+	}
+}
+
+// loadHistory reads every trace in a history file.
+func loadHistory(path string) ([]historyEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []historyEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20) // A long trace with hostnames is a long line
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var entry historyEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, fmt.Errorf("line %d is not a trace saved with -save: %w", n, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// printHistory prints how the path to each destination in the history file
+// changed, or only to the destinations given.
+func printHistory(path string, destinations []string) {
+	entries, err := loadHistory(path)
+	if err != nil {
+		fmt.Printf("❌ ERROR: Could not read %s: %v\n", path, err)
+		os.Exit(1)
+	}
+
+	// Group the traces by destination, in the order each first appears
+	var order []string
+	byDestination := make(map[string][]historyEntry)
+	for _, e := range entries {
+		if !historyWanted(e, destinations) {
+			continue
+		}
+		if _, seen := byDestination[e.Destination]; !seen {
+			order = append(order, e.Destination)
+		}
+		byDestination[e.Destination] = append(byDestination[e.Destination], e)
+	}
+
+	if len(order) == 0 {
+		if len(destinations) > 0 {
+			fmt.Printf("📜 No traces to %s in %s\n", strings.Join(destinations, ", "), path)
+		} else {
+			fmt.Printf("📜 No traces in %s\n", path)
+		}
+		return
+	}
+
+	for i, destination := range order {
+		if i > 0 {
+			fmt.Println()
+		}
+		printDestinationHistory(destination, byDestination[destination])
+	}
+}
+
+// historyWanted reports whether a trace is to one of the destinations (by
+// name or address), or whether no destinations were given.
+func historyWanted(e historyEntry, destinations []string) bool {
+	if len(destinations) == 0 {
+		return true
+	}
+	for _, d := range destinations {
+		if e.Destination == d || e.Address == d {
+			return true
+		}
+	}
+	return false
+}
+
+// printDestinationHistory prints one line per trace to a destination, then
+// how each router of the latest trace did over time.
+func printDestinationHistory(destination string, entries []historyEntry) {
+	first, last := entries[0], entries[len(entries)-1]
+	fmt.Printf("📜 %s: %d %s, from %s to %s\n", destination, len(entries), plural(len(entries), "trace", "traces"),
+		first.Time.Local().Format("2006-01-02 15:04"), last.Time.Local().Format("2006-01-02 15:04"))
+	fmt.Println()
+	fmt.Println("   When                 Hops  RTT        Loss  Path")
+	fmt.Println("   ────                 ────  ───        ────  ────")
+
+	for i, e := range entries {
+		hops, rtt, loss := "-", "-", "-"
+		if e.Reached && len(e.Hops) > 0 {
+			end := e.Hops[len(e.Hops)-1]
+			hops = fmt.Sprint(end.TTL)
+			loss = fmt.Sprintf("%.0f%%", end.LossPercent)
+			if end.Stats != nil {
+				rtt = formatRTT(historyRTT(end.Stats.Avg))
+			}
+		}
+
+		var path string
+		switch {
+		case e.Error != "":
+			path = "❌ " + e.Error
+		case i == 0:
+			path = formatHistoryPath(e.Hops)
+		case pathChanged(entries[i-1].Hops, e.Hops):
+			path = "~ " + formatHistoryPath(e.Hops)
+		default:
+			path = "same path"
+		}
+		if !e.Reached && e.Error == "" {
+			path += "  (" + diffStatus(e.jsonTrace) + ")"
+		}
+
+		fmt.Printf("   %-20s %-5s %-10s %-5s %s\n", e.Time.Local().Format("2006-01-02 15:04:05"), hops, rtt, loss, path)
+	}
+
+	hops := trimSilent(last.Hops)
+	if len(hops) == 0 {
+		return
+	}
+	fmt.Println()
+	fmt.Println("   Hop  Router                                   Seen      First      Last       Best       Worst")
+	fmt.Println("   ───  ──────                                   ────      ─────      ────       ────       ─────")
+	for _, hop := range hops {
+		if hop.Responder == "" {
+			fmt.Printf("   %3d  %s\n", hop.TTL, "*")
+			continue
+		}
+
+		// Every trace where this router answered at this hop
+		seen := 0
+		var firstAvg, lastAvg float64
+		best, worst := math.Inf(1), math.Inf(-1)
+		for _, e := range entries {
+			for _, h := range e.Hops {
+				if h.TTL != hop.TTL || h.Responder != hop.Responder || h.Stats == nil {
+					continue
+				}
+				if seen == 0 {
+					firstAvg = h.Stats.Avg
+				}
+				seen++
+				lastAvg = h.Stats.Avg
+				best = math.Min(best, h.Stats.Min)
+				worst = math.Max(worst, h.Stats.Max)
+			}
+		}
+
+		host := hop.Responder
+		if hop.Hostname != "" {
+			host = hop.Hostname
+		}
+		if len(host) > dualHostWidth {
+			host = host[:dualHostWidth-3] + "..."
+		}
+		if seen == 0 {
+			fmt.Printf("   %3d  %-*s  %s\n", hop.TTL, dualHostWidth, host, "-")
+			continue
+		}
+		fmt.Printf("   %3d  %-*s  %-9s %-10s %-10s %-10s %s\n", hop.TTL, dualHostWidth, host,
+			fmt.Sprintf("%d/%d", seen, len(entries)),
+			formatRTT(historyRTT(firstAvg)), formatRTT(historyRTT(lastAvg)),
+			formatRTT(historyRTT(best)), formatRTT(historyRTT(worst)))
+	}
+}
+
+// pathChanged reports whether a different set of routers answered, the way
+// -compare lines them up (see diff.go). Routers that only got slower don't
+// count: the RTT column shows that.
+func pathChanged(before, after []jsonHop) bool {
+	for _, line := range diffTraces(before, after) {
+		if line.mark == "~" || line.mark == "+" || line.mark == "-" {
+			return true
+		}
+	}
+	return false
+}
+
+// formatHistoryPath lists the routers of a trace, with "*" for a silent
+// hop and "*×N" for N of them in a row. Silent hops at the end of a trace
+// that didn't get through are left out.
+func formatHistoryPath(hops []jsonHop) string {
+	hops = trimSilent(hops)
+	if len(hops) == 0 {
+		return "no replies"
+	}
+
+	var routers []string
+	for i := 0; i < len(hops); i++ {
+		if hops[i].Responder != "" {
+			routers = append(routers, hops[i].Responder)
+			continue
+		}
+		n := 1
+		for i+n < len(hops) && hops[i+n].Responder == "" {
+			n++
+		}
+		if n == 1 {
+			routers = append(routers, "*")
+		} else {
+			routers = append(routers, fmt.Sprintf("*×%d", n))
+		}
+		i += n - 1
+	}
+	return strings.Join(routers, " → ")
+}
+
+// trimSilent drops the silent hops at the end of a trace.
+func trimSilent(hops []jsonHop) []jsonHop {
+	for len(hops) > 0 && hops[len(hops)-1].Responder == "" {
+		hops = hops[:len(hops)-1]
+	}
+	return hops
+}
+
+// historyRTT converts milliseconds from a saved trace back to a duration.
+func historyRTT(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
+}
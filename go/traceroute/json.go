@@ -0,0 +1,187 @@
+// =============================================================================
+// JSON OUTPUT - Results for other programs to read
+// =============================================================================
+//
+// The table we print is great for people, but awkward for programs: a
+// script that wants "the loss at hop 5" would have to pick the table apart.
+//
+// With -json we write the results as JSON instead, which almost every
+// programming language can read. Everything else we print (the banner,
+// the progress, the table itself) goes to stderr, so stdout holds nothing
+// but the JSON:
+//
+//   sudo go run . -json google.com > trace.json
+//
+// Lost probes show up as null in the list of round-trip times, and each
+// hop says how many probes were sent and received, and the loss percentage.
+//
+// =============================================================================
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"net"
+	"time"
+)
+
+// jsonTrace is one traced destination.
+type jsonTrace struct {
+	Destination string      `json:"destination"`
+	Address     string      `json:"address,omitempty"`
+	Error       string      `json:"error,omitempty"` // Why the trace couldn't start
+	Reached     bool        `json:"reached"`
+	Loop        *jsonLoop   `json:"loop,omitempty"`    // Why the trace stopped early
+	GaveUp      *jsonSilent `json:"gave_up,omitempty"` // Why the trace gave up early (-max-unknown)
+	Hops        []jsonHop   `json:"hops"`
+	Strays      []jsonStray `json:"stray_replies,omitempty"` // Duplicate and late replies (see stamp.go)
+}
+
+// jsonStray is a reply that didn't count towards the hops.
+type jsonStray struct {
+	TTL       int     `json:"ttl"`
+	Responder string  `json:"responder"`
+	Kind      string  `json:"kind"` // "duplicate" or "late"
+	RTT       float64 `json:"rtt_ms"`
+}
+
+// newJSONStrays converts stray replies for JSON output.
+func newJSONStrays(strays []strayReply) []jsonStray {
+	var out []jsonStray
+	for _, s := range strays {
+		out = append(out, jsonStray{TTL: s.TTL, Responder: s.Responder, Kind: s.Kind, RTT: jsonMS(s.RTT)})
+	}
+	return out
+}
+
+// jsonLoop is a routing loop the trace ran into (see loop.go).
+type jsonLoop struct {
+	Responder string `json:"responder"`
+	FirstTTL  int    `json:"first_ttl"`
+	LastTTL   int    `json:"last_ttl"`
+}
+
+// jsonSilent is the silent hops a trace gave up after (see silent.go).
+type jsonSilent struct {
+	FirstTTL int `json:"first_ttl"`
+	LastTTL  int `json:"last_ttl"`
+}
+
+// jsonHop is one TTL of a trace.
+type jsonHop struct {
+	TTL         int               `json:"ttl"`
+	Responder   string            `json:"responder,omitempty"` // "" if nobody answered
+	Hostname    string            `json:"hostname,omitempty"`
+	Gateway     bool              `json:"default_gateway,omitempty"` // Our own router (see gateway.go)
+	Sent        int               `json:"sent"`
+	Received    int               `json:"received"`
+	LossPercent float64           `json:"loss_percent"`
+	RTTs        []*float64        `json:"rtts_ms"`             // One per probe; null if lost
+	Stats       *jsonRTTStats     `json:"rtt_stats,omitempty"` // Only if some probes got a reply
+	MPLS        [][]jsonMPLSLabel `json:"mpls,omitempty"`      // Each different label stack seen
+}
+
+// jsonRTTStats sums up the round-trip times of a hop. StdDev (the jitter)
+// is 0 with fewer than two replies.
+type jsonRTTStats struct {
+	Min    float64 `json:"min_ms"`
+	Avg    float64 `json:"avg_ms"`
+	Max    float64 `json:"max_ms"`
+	StdDev float64 `json:"stddev_ms"`
+}
+
+// jsonMPLSLabel is one MPLS label a router reported (see mpls.go).
+type jsonMPLSLabel struct {
+	Label int  `json:"label"`
+	TC    int  `json:"tc"`
+	S     bool `json:"s"`
+	TTL   int  `json:"ttl"`
+}
+
+// newJSONTrace converts a finished trace. names may be nil (-n).
+func newJSONTrace(destination string, addr *net.IPAddr, reached bool, loop *routingLoop, silent *silentRun, hops []hopResult, names *nameCache) jsonTrace {
+	trace := jsonTrace{
+		Destination: destination,
+		Reached:     reached,
+		Hops:        []jsonHop{},
+	}
+	if addr != nil {
+		trace.Address = addr.IP.String()
+	}
+	if loop != nil {
+		trace.Loop = &jsonLoop{Responder: loop.Responder, FirstTTL: loop.FirstTTL, LastTTL: loop.LastTTL}
+	}
+	if silent != nil {
+		trace.GaveUp = &jsonSilent{FirstTTL: silent.FirstTTL, LastTTL: silent.LastTTL}
+	}
+
+	for _, hop := range hops {
+		h := jsonHop{
+			TTL:         hop.TTL,
+			Sent:        hop.Sent(),
+			Received:    hop.Received(),
+			LossPercent: math.Round(hop.Loss()*10) / 10,
+			RTTs:        make([]*float64, 0, len(hop.Probes)),
+			Gateway:     isDefaultGateway(hop),
+		}
+		for _, labels := range hopMPLS(hop) {
+			stack := make([]jsonMPLSLabel, len(labels))
+			for i, l := range labels {
+				stack[i] = jsonMPLSLabel{Label: l.Label, TC: l.TC, S: l.S, TTL: l.TTL}
+			}
+			h.MPLS = append(h.MPLS, stack)
+		}
+		for _, p := range hop.Probes {
+			if p.Responder == "" {
+				h.RTTs = append(h.RTTs, nil)
+				continue
+			}
+			h.Responder = p.Responder
+			ms := jsonMS(p.RTT)
+			h.RTTs = append(h.RTTs, &ms)
+		}
+		if stats := hop.Stats(); stats.Received > 0 {
+			h.Stats = &jsonRTTStats{
+				Min:    jsonMS(stats.Best),
+				Avg:    jsonMS(stats.Avg()),
+				Max:    jsonMS(stats.Worst),
+				StdDev: jsonMS(stats.StdDev()),
+			}
+		}
+		if h.Responder != "" {
+			if name := names.WaitFull(h.Responder); name != NoHostname {
+				h.Hostname = name
+			}
+		}
+		trace.Hops = append(trace.Hops, h)
+	}
+
+	return trace
+}
+
+// newJSONTraces converts the traces of a multi-destination (or -dual) run,
+// with the DNS error of any destination that couldn't be looked up.
+func newJSONTraces(results []multiResult, names *nameCache) []jsonTrace {
+	traces := make([]jsonTrace, len(results))
+	for i, r := range results {
+		traces[i] = newJSONTrace(r.Destination, r.Addr, r.Reached, r.Loop, r.Silent, r.Trace, names)
+		if r.Err != nil {
+			traces[i].Error = r.Err.Error()
+		}
+	}
+	return traces
+}
+
+// jsonMS converts a duration to milliseconds, to the microsecond.
+func jsonMS(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// writeJSON writes v as indented JSON.
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
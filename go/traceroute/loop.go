@@ -0,0 +1,91 @@
+// =============================================================================
+// ROUTING LOOPS - When the packet goes round in circles
+// =============================================================================
+//
+// Normally each hop is a different router, one step closer to the
+// destination. But if two routers disagree about the way (router A says
+// "go via B", router B says "go via A"), a packet bounces between them until
+// its TTL runs out. That's a routing loop, and everything sent that way is
+// lost.
+//
+// In a trace it looks like the same router answering hop after hop:
+//
+//   12   20ms       21ms       20ms       0%     198.51.100.7       ...
+//   13   22ms       22ms       21ms       0%     198.51.100.7       ...
+//   14   21ms       23ms       22ms       0%     198.51.100.7       ...
+//   🔁 Routing loop between hop 12 and 14 (198.51.100.7)
+//
+// (Why the same router and not A, B, A, B? Often both routers only answer
+// with one address, or the one whose reply comes back first hides the other.)
+//
+// Once we've seen LoopHops hops in a row answered by the same router, no
+// higher TTL will get any further, so we stop there instead of sending
+// probes all the way to -m.
+//
+// Two hops in a row from one router can happen without a loop (some
+// firewalls answer for the hop behind them), which is why we wait for a
+// third.
+//
+// =============================================================================
+
+package main
+
+import "fmt"
+
+// LoopHops is how many hops in a row one router has to answer before we
+// call it a routing loop.
+const LoopHops = 3
+
+// routingLoop is a router that answered LoopHops (or more) hops in a row.
+type routingLoop struct {
+	Responder string
+	FirstTTL  int
+	LastTTL   int
+}
+
+// formatLoop builds the line printed under the last hop of a loop.
+func formatLoop(loop *routingLoop) string {
+	return fmt.Sprintf("🔁 Routing loop between hop %d and %d (%s)", loop.FirstTTL, loop.LastTTL, loop.Responder)
+}
+
+// loopDetector watches hops go by, in TTL order, for a routing loop.
+type loopDetector struct {
+	responder string // Who answered the hops of the current run
+	firstTTL  int    // Where the current run started
+}
+
+// add looks at the next hop and returns the loop once the same router has
+// answered LoopHops hops in a row.
+func (d *loopDetector) add(hop hopResult) *routingLoop {
+	responder := hopResponder(hop)
+	if responder == "" || responder != d.responder {
+		d.responder = responder
+		d.firstTTL = hop.TTL
+		return nil
+	}
+
+	if hop.TTL-d.firstTTL+1 < LoopHops {
+		return nil
+	}
+	return &routingLoop{Responder: responder, FirstTTL: d.firstTTL, LastTTL: hop.TTL}
+}
+
+// hopResponder returns the router that answered a hop, or "" if nobody did,
+// the destination did, or different probes were answered by different
+// routers (load balancing - not a loop).
+func hopResponder(hop hopResult) string {
+	responder := ""
+	for _, p := range hop.Probes {
+		if p.Reached {
+			return ""
+		}
+		if p.Responder == "" {
+			continue
+		}
+		if responder != "" && p.Responder != responder {
+			return ""
+		}
+		responder = p.Responder
+	}
+	return responder
+}
@@ -22,9 +22,21 @@
 // of post offices!
 //
 // USAGE:
-//   sudo go run main.go google.com
-//   sudo go run main.go 8.8.8.8
-//   sudo go run main.go amazon.com
+//   sudo go run . google.com
+//   sudo go run . 8.8.8.8
+//   sudo go run . -max-inflight 1 amazon.com   # one probe at a time
+//   sudo go run . -ttl-interval 0.5 1.1.1.1    # go easy on rate-limiting routers
+//   sudo go run . -max-unknown 5 10.0.0.1      # give up after 5 silent hops
+//   sudo go run . -continuous 1.1.1.1          # keep probing, like mtr
+//   sudo go run . -tui -continuous 1.1.1.1     # full-screen live table
+//   sudo go run . 1.1.1.1 8.8.8.8 9.9.9.9      # many destinations at once
+//   sudo go run . -f targets.txt               # destinations from a file
+//   sudo go run . -dual google.com             # IPv4 and IPv6 side by side
+//   sudo go run . -compare old.json google.com # what changed since old.json
+//   sudo go run . -every 5m -save h.jsonl 1.1.1.1 # trace every 5 minutes
+//   go run . -history h.jsonl                  # how the path changed over time
+//   sudo go run . -label-private -trim-suffix corp.example.com 10.0.0.1
+//                                              # shorter names, private hops marked
 //
 // WHY SUDO?
 //   We need "raw sockets" to send custom ICMP packets. Raw sockets are
@@ -32,27 +44,29 @@
 //   system requires administrator/root privileges to use them.
 //   This is a security feature, not a bug!
 //
+//   Without sudo we fall back to the unprivileged ICMP "ping" socket that
+//   Linux and macOS offer to normal users, when the system allows it.
+//
 // =============================================================================
 
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"strings"
+	"sync/atomic"
 	"time"
-
-	// These are from the "extended" Go networking library
-	// They provide lower-level network access than the standard library
-	"golang.org/x/net/icmp"
-	"golang.org/x/net/ipv4"
 )
 
 // =============================================================================
 // CONSTANTS
 // =============================================================================
-// These are fixed values we use throughout the program.
-// We define them here so they're easy to find and change if needed.
+// These are the values we use when the user doesn't pick their own with
+// flags (-m, -w, -q, -s, ...). We define them here so they're easy to find.
 
 const (
 	// ProtocolICMP is the magic number that identifies ICMP packets.
@@ -63,7 +77,11 @@ const (
 	// Fun fact: TCP is protocol 6, UDP is protocol 17!
 	ProtocolICMP = 1
 
-	// MaxHops is how many routers we'll try to discover before giving up.
+	// ProtocolICMPv6 is the same for IPv6, which has its own version of
+	// ICMP with different message numbers (RFC 4443).
+	ProtocolICMPv6 = 58
+
+	// DefaultMaxHops is how many routers we'll try to discover before giving up.
 	// Most destinations on the internet are within 15-20 hops.
 	// 30 is a safe maximum that almost always works.
 	//
@@ -71,9 +89,10 @@ const (
 	// - The destination is REALLY far away (rare)
 	// - There's a routing loop (packets going in circles)
 	// - The destination is unreachable
-	MaxHops = 30
+	// Change it with -m.
+	DefaultMaxHops = 30
 
-	// Timeout is how long we wait for each router to respond.
+	// DefaultTimeout is how long we wait for each router to respond.
 	// 3 seconds might seem long, but some routers are:
 	// - Very far away (like on another continent)
 	// - Very busy (handling millions of packets)
@@ -81,22 +100,38 @@ const (
 	//
 	// Most responses come back in under 100 milliseconds.
 	// We use 3 seconds to be generous and not miss slow routers.
-	Timeout = 3 * time.Second
+	// Change it with -w (in seconds).
+	DefaultTimeout = 3 * time.Second
 
-	// NumProbes is how many packets we send at each TTL level.
+	// DefaultNumProbes is how many packets we send at each TTL level.
 	// Sending multiple probes helps because:
 	// - Some packets might get lost (the internet isn't 100% reliable!)
 	// - Different packets might take different paths (load balancing)
 	// - We can calculate average and variance in response times
 	//
-	// The standard traceroute sends 3 probes per hop.
-	NumProbes = 3
+	// The standard traceroute sends 3 probes per hop. Change it with -q.
+	DefaultNumProbes = 3
 
-	// PacketSize is how many bytes of data we put in each packet.
+	// DefaultPacketSize is how many bytes of data we put in each packet.
 	// 56 bytes is traditional (same as the standard "ping" command).
 	// Larger packets might get fragmented (split up), which we don't want.
 	// Smaller packets work fine too, but 56 is conventional.
-	PacketSize = 56
+	// Change it with -s.
+	DefaultPacketSize = 56
+
+	// DefaultMaxInflight is how many probes may wait for a reply at once.
+	// Probing many TTLs in parallel makes traces MUCH faster, because silent
+	// routers no longer cost us a full Timeout each, one after another.
+	// 16 matches what modern traceroute implementations use by default.
+	// Use -max-inflight 1 for the old one-probe-at-a-time behavior.
+	DefaultMaxInflight = 16
+
+	// MaxTTL is the largest TTL that fits in the IP header (it's one byte).
+	MaxTTL = 255
+
+	// MaxPacketSize is the most data that fits in one IPv4 packet:
+	// 65535 bytes, minus 20 for the IP header and 8 for the ICMP header.
+	MaxPacketSize = 65535 - 20 - 8
 )
 
 // =============================================================================
@@ -109,25 +144,321 @@ func main() {
 	// -------------------------------------------------------------------------
 	// STEP 1: Parse command line arguments
 	// -------------------------------------------------------------------------
-	// When you type "sudo go run main.go google.com", the operating system
-	// passes all those words to our program as "arguments".
-	//
-	// os.Args is a slice (like an array) containing:
-	//   os.Args[0] = "main.go" (or the compiled program name)
-	//   os.Args[1] = "google.com" (the first real argument)
-	//   os.Args[2] = ... (we don't expect any more)
+	// When you type "sudo ./traceroute -max-inflight 4 google.com", the
+	// operating system passes all those words to our program as "arguments".
 	//
-	// len(os.Args) tells us how many arguments we got.
-	// We expect exactly 2: the program name and the destination.
+	// The "flag" package picks out the options that start with "-" for us.
+	// Whatever is left over (flag.Args()) is the destination - or several
+	// destinations, which we then trace all at the same time (see multi.go).
+
+	maxHops := flag.Int("m", DefaultMaxHops, "maximum number of hops (max TTL) to probe")
+	maxUnknown := flag.Int("max-unknown", 0, "stop after this many hops in a row with no reply (0 = go on to -m, see silent.go)")
+	firstTTL := flag.Int("first-ttl", 1, "TTL to start probing at")
+	waitSeconds := flag.Float64("w", DefaultTimeout.Seconds(), "seconds to wait for each reply")
+	numProbes := flag.Int("q", DefaultNumProbes, "number of probes per hop")
+	packetSize := flag.Int("s", DefaultPacketSize, "bytes of data in each probe packet")
+	maxInflight := flag.Int("max-inflight", DefaultMaxInflight, "maximum number of probes in flight at once")
+	paris := flag.Bool("paris", true, "keep the flow identifier constant so all probes follow one path (Paris traceroute)")
+	flowID := flag.Int("flow", 0, "flow identifier to use in Paris mode (0-65534); change it to see other paths")
+	continuous := flag.Bool("continuous", false, "keep probing all hops and show live statistics (like mtr)")
+	tuiMode := flag.Bool("tui", false, "show a live full-screen table that updates as each probe returns (see tui.go)")
+	targetsFile := flag.String("f", "", "read destinations from a file, one per line (- for stdin)")
+	parallel := flag.Int("parallel", DefaultParallel, "how many destinations to trace at the same time")
+	iface := flag.String("i", "", "network interface to send probes out of")
+	source := flag.String("S", "", "source IPv4 or IPv6 address to send probes from")
+	numeric := flag.Bool("n", false, "show IP addresses only, without looking up router names")
+	paths := flag.Bool("paths", false, "find every load-balanced path by probing many flows at each hop")
+	numFlows := flag.Int("flows", DefaultFlows, "how many flows -paths tries at each hop")
+	sendWait := flag.Float64("z", 0, "seconds to wait between sending any two probes")
+	flag.Float64Var(sendWait, "send-interval", 0, "same as -z")
+	ttlWait := flag.Float64("ttl-interval", 0, "seconds to wait between two probes with the same TTL (see pacing.go)")
+	adaptive := flag.Bool("adaptive", true, "wait less for replies once we know how slow they are (see adaptive.go)")
+	csvPath := flag.String("csv", "", "append one row per hop to this CSV file (see csv.go)")
+	metricsServer := flag.String("metrics-server", "", "send each trace to this metrics-system server, as host:port (see metrics.go)")
+	jsonOutput := flag.Bool("json", false, "write the results as JSON to stdout (everything else goes to stderr)")
+	dual := flag.Bool("dual", false, "trace the IPv4 and IPv6 addresses side by side (see dual.go)")
+	compareFile := flag.String("compare", "", "compare the trace with one saved by -json (see diff.go)")
+	every := flag.Duration("every", 0, "trace again on this interval (like 5m) until Ctrl+C (see history.go)")
+	savePath := flag.String("save", "", "append each trace as a line of JSON to this history file (see history.go)")
+	historyPath := flag.String("history", "", "show how the paths in a history file changed over time, without tracing")
+	var trimSuffixes []string
+	flag.Func("trim-suffix", "cut these domain endings (comma-separated) off router names (see compact.go)", func(value string) error {
+		trimSuffixes = append(trimSuffixes, parseSuffixes(value)...)
+		return nil
+	})
+	flag.BoolVar(&labelPrivate, "label-private", false, "point out hops with private (RFC 1918, ULA) or carrier NAT addresses")
+	flag.Usage = printUsage
+	flag.Parse()
+
+	destinations := flag.Args()
+	if *targetsFile != "" {
+		fromFile, err := readTargets(*targetsFile)
+		if err != nil {
+			fmt.Printf("❌ ERROR: Could not read destinations from %s: %v\n", *targetsFile, err)
+			os.Exit(1)
+		}
+		destinations = append(destinations, fromFile...)
+	}
 
-	if len(os.Args) != 2 {
+	// Reading a history file back doesn't trace anything, and the
+	// destinations (if any) only pick which traces to show (see history.go)
+	if *historyPath != "" {
+		printHistory(*historyPath, destinations)
+		return
+	}
+
+	if len(destinations) == 0 {
 		// They didn't give us a destination! Show them how to use the program.
 		printUsage()
 		os.Exit(1) // Exit code 1 means "something went wrong"
 	}
 
+	// Check the options make sense before we start sending anything
+	sockOpts := socketOptions{Interface: *iface}
+	if *source != "" {
+		sockOpts.Source = net.ParseIP(*source)
+		if v4 := sockOpts.Source.To4(); v4 != nil {
+			sockOpts.Source = v4
+		}
+	}
+	var problem string
+	switch {
+	case *maxHops < 1 || *maxHops > MaxTTL:
+		problem = fmt.Sprintf("-m must be between 1 and %d", MaxTTL)
+	case *firstTTL < 1 || *firstTTL > *maxHops:
+		problem = fmt.Sprintf("-first-ttl must be between 1 and -m (%d)", *maxHops)
+	case *maxUnknown < 0:
+		problem = "-max-unknown can't be negative"
+	case *maxUnknown > 0 && (*continuous || *paths):
+		problem = "-max-unknown can't be used with -continuous or -paths"
+	case *waitSeconds <= 0:
+		problem = "-w must be more than 0 seconds"
+	case *numProbes < 1 || *numProbes > 10:
+		problem = "-q must be between 1 and 10"
+	case *packetSize < 0 || *packetSize > MaxPacketSize:
+		problem = fmt.Sprintf("-s must be between 0 and %d", MaxPacketSize)
+	case *paris && *packetSize < 2:
+		problem = "Paris mode needs at least 2 bytes of data (-s 2), or use -paris=false"
+	case *sendWait < 0:
+		problem = "-z can't be negative"
+	case *ttlWait < 0:
+		problem = "-ttl-interval can't be negative"
+	case *maxInflight < 1:
+		problem = "-max-inflight must be at least 1"
+	case *flowID < 0 || *flowID > 0xfffe:
+		problem = "-flow must be between 0 and 65534"
+	case *parallel < 1:
+		problem = "-parallel must be at least 1"
+	case *continuous && len(destinations) > 1:
+		problem = "-continuous works with one destination at a time"
+	case *continuous && *jsonOutput:
+		problem = "-json can't be used with -continuous"
+	case *continuous && *csvPath != "":
+		problem = "-csv can't be used with -continuous"
+	case *continuous && *metricsServer != "":
+		problem = "-metrics-server can't be used with -continuous"
+	case *savePath != "" && (*continuous || *paths || *tuiMode):
+		problem = "-save can't be used with -continuous, -paths or -tui"
+	case *every < 0 || (*every > 0 && *every < time.Second):
+		problem = "-every must be at least 1s"
+	case *every > 0 && (*continuous || *tuiMode || *paths || *dual || *compareFile != "" || *jsonOutput):
+		problem = "-every can't be used with -continuous, -tui, -paths, -dual, -compare or -json (use -save)"
+	case *paths && (*continuous || *jsonOutput || *csvPath != "" || *metricsServer != "" || len(destinations) > 1):
+		problem = "-paths works with one destination, without -continuous, -json, -csv or -metrics-server"
+	case *paths && *packetSize < 2:
+		problem = "-paths needs at least 2 bytes of data (-s 2)"
+	case *numFlows < 1 || *flowID+*numFlows-1 > 0xfffe:
+		problem = fmt.Sprintf("-flows must be between 1 and %d (with -flow %d)", 0xfffe-*flowID+1, *flowID)
+	case *dual && (*continuous || *paths || len(destinations) > 1):
+		problem = "-dual works with one destination, without -continuous or -paths"
+	case *dual && *source != "":
+		problem = "-S can't be used with -dual, which sends from an address of each family"
+	case *source != "" && sockOpts.Source == nil:
+		problem = fmt.Sprintf("-S must be an IP address, not %q", *source)
+	case (len(destinations) > 1 || *every > 0) && sockOpts.Source.To4() == nil && sockOpts.Source != nil:
+		problem = "-S must be an IPv4 address when tracing several destinations or with -every"
+	case *compareFile != "" && (*continuous || *paths || *dual || len(destinations) > 1):
+		problem = "-compare works with one destination, without -continuous, -paths or -dual"
+	case len(trimSuffixes) > 0 && *numeric:
+		problem = "-trim-suffix can't be used with -n, which shows no names"
+	case *tuiMode && (*jsonOutput || *csvPath != "" || *metricsServer != "" || *paths || *dual || *compareFile != "" || len(destinations) > 1):
+		problem = "-tui works with one destination, without -json, -csv, -metrics-server, -paths, -dual or -compare"
+	case *iface != "" && !interfaceExists(*iface):
+		problem = fmt.Sprintf("-i: no network interface called %q", *iface)
+	}
+	if problem != "" {
+		fmt.Printf("❌ ERROR: %s\n\n", problem)
+		printUsage()
+		os.Exit(1)
+	}
+
+	// Comparing with a saved trace? Load it now, so a typo in the file name
+	// doesn't waste a whole trace (see diff.go). If the destination is a
+	// saved trace too, there's nothing to trace at all.
+	var before jsonTrace
+	if *compareFile != "" {
+		if isSavedTrace(destinations[0]) {
+			compareSaved(*compareFile, destinations[0])
+			return
+		}
+		var err error
+		if before, err = loadTrace(*compareFile, destinations[0]); err != nil {
+			fmt.Printf("❌ ERROR: Could not read %s: %v\n", *compareFile, err)
+			os.Exit(1)
+		}
+	}
+
+	opts := traceOptions{
+		FirstTTL:    *firstTTL,
+		MaxHops:     *maxHops,
+		NumProbes:   *numProbes,
+		MaxInflight: *maxInflight,
+		MaxUnknown:  *maxUnknown,
+	}
+	if !*numeric {
+		opts.Names = newNameCache(DNSTimeout, trimSuffixes)
+	}
+
+	// With -json, stdout is only for the JSON (see json.go). Everything we
+	// would normally print goes to stderr instead.
+	var jsonOut io.Writer
+	if *jsonOutput {
+		jsonOut = os.Stdout
+		os.Stdout = os.Stderr
+	}
+
+	// Open the CSV file now, so a bad path fails before we trace anything
+	var csvFile *os.File
+	if *csvPath != "" {
+		f, err := openCSV(*csvPath)
+		if err != nil {
+			fmt.Printf("❌ ERROR: Could not open %s: %v\n", *csvPath, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		csvFile = f
+	}
+
+	// And the history file
+	var historyFile *os.File
+	if *savePath != "" {
+		f, err := openHistory(*savePath)
+		if err != nil {
+			fmt.Printf("❌ ERROR: Could not open %s: %v\n", *savePath, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		historyFile = f
+	}
+
+	// Same for the metrics server: better to find out it's unreachable now
+	var sink *metricsSink
+	if *metricsServer != "" {
+		m, err := openMetrics(*metricsServer)
+		if err != nil {
+			fmt.Printf("❌ ERROR: Could not connect to metrics server %s: %v\n", *metricsServer, err)
+			os.Exit(1)
+		}
+		defer m.Close()
+		sink = m
+	}
+	started := time.Now()
+
+	proberConfig := ProberConfig{
+		Timeout:    time.Duration(*waitSeconds * float64(time.Second)),
+		PacketSize: *packetSize,
+		Paris:      *paris,
+		FlowID:     *flowID,
+		Adaptive:   *adaptive,
+
+		SendInterval: time.Duration(*sendWait * float64(time.Second)),
+		TTLInterval:  time.Duration(*ttlWait * float64(time.Second)),
+	}
+
+	fmt.Println("╔════════════════════════════════════════════════════════════════╗")
+	fmt.Println("║                    🔍 TRACEROUTE                               ║")
+	fmt.Println("║         Discover the path your packets take!                  ║")
+	fmt.Println("╚════════════════════════════════════════════════════════════════╝")
+	fmt.Println()
+
+	// On a schedule? Trace over and over until Ctrl+C, saving each round
+	// as it finishes (see history.go). Rounds are traced like several
+	// destinations are, so one destination works the same way.
+	if *every > 0 {
+		sock := mustOpenSocket(strings.Join(destinations, " "), sockOpts)
+		defer sock.Close()
+		fmt.Printf("⏰ Tracing %s every %s, until you press Ctrl+C\n", strings.Join(destinations, ", "), *every)
+		fmt.Printf("   Maximum %d hops, %d probes per hop, %d byte packets, %d probes in flight per destination\n",
+			opts.MaxHops, opts.NumProbes, *packetSize, opts.MaxInflight)
+		if historyFile != nil {
+			fmt.Printf("   Saving every trace to %s\n", historyFile.Name())
+		}
+		fmt.Println()
+
+		runEvery(newProbeEngine(sock, proberConfig), destinations, opts, *parallel, *every, func(started time.Time, results []multiResult) {
+			if historyFile != nil {
+				saveHistory(historyFile, started, newJSONTraces(results, opts.Names))
+			}
+			if csvFile != nil {
+				saveCSV(csvFile, started, opts.NumProbes, results)
+			}
+			if sink != nil {
+				sink.send(started, results)
+			}
+		})
+		return
+	}
+
+	// Several destinations? Trace them all at once (see multi.go)
+	if len(destinations) > 1 {
+		sock := mustOpenSocket(strings.Join(destinations, " "), sockOpts)
+		fmt.Printf("🚀 Tracing %d destinations, %d at a time\n", len(destinations), *parallel)
+		fmt.Printf("   Maximum %d hops, %d probes per hop, %d byte packets, %d probes in flight per destination\n",
+			opts.MaxHops, opts.NumProbes, *packetSize, opts.MaxInflight)
+		fmt.Println()
+
+		results, ok := runMulti(newProbeEngine(sock, proberConfig), destinations, opts, *parallel)
+		sock.Close()
+		if jsonOut != nil {
+			writeJSON(jsonOut, newJSONTraces(results, opts.Names))
+		}
+		if historyFile != nil {
+			saveHistory(historyFile, started, newJSONTraces(results, opts.Names))
+		}
+		if csvFile != nil {
+			saveCSV(csvFile, started, opts.NumProbes, results)
+		}
+		if sink != nil {
+			sink.send(started, results)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Grab the destination they want to trace
-	destination := os.Args[1]
+	destination := destinations[0]
+
+	// Both IPv4 and IPv6? Trace them side by side (see dual.go)
+	if *dual {
+		if results, ok := traceDual(destination, sockOpts, proberConfig, opts); ok {
+			if jsonOut != nil {
+				writeJSON(jsonOut, newJSONTraces(results, opts.Names))
+			}
+			if historyFile != nil {
+				saveHistory(historyFile, started, newJSONTraces(results, opts.Names))
+			}
+			if csvFile != nil {
+				saveCSV(csvFile, started, opts.NumProbes, results)
+			}
+			if sink != nil {
+				sink.send(started, results)
+			}
+			return
+		}
+		// Only one kind of address - carry on with a normal trace
+	}
 
 	// -------------------------------------------------------------------------
 	// STEP 2: Resolve the destination to an IP address
@@ -136,20 +467,22 @@ func main() {
 	// This is called "DNS resolution" - looking up a name in the internet's
 	// phone book (the Domain Name System).
 	//
-	// "ip4" means we want an IPv4 address (like 142.250.80.46).
-	// IPv6 addresses look different (like 2607:f8b0:4004:800::200e)
-	// and require different handling, so we stick with IPv4 for simplicity.
-
-	fmt.Println("╔════════════════════════════════════════════════════════════════╗")
-	fmt.Println("║                    🔍 TRACEROUTE                               ║")
-	fmt.Println("║         Discover the path your packets take!                  ║")
-	fmt.Println("╚════════════════════════════════════════════════════════════════╝")
-	fmt.Println()
+	// Names can have an IPv4 address (like 142.250.80.46), an IPv6 one
+	// (like 2607:f8b0:4004:800::200e), or both. "ip" means either will do:
+	// we get IPv4 when there is one, and IPv6 otherwise. Sending from a
+	// given address (-S) means using that address's family.
+	network := "ip"
+	switch {
+	case sockOpts.Source.To4() != nil:
+		network = "ip4"
+	case sockOpts.Source != nil:
+		network = "ip6"
+	}
 
 	fmt.Printf("📡 Looking up '%s' in DNS...\n", destination)
 
 	// net.ResolveIPAddr does the DNS lookup for us
-	destAddr, err := net.ResolveIPAddr("ip4", destination)
+	destAddr, err := net.ResolveIPAddr(network, destination)
 	if err != nil {
 		// The lookup failed! Let's give a helpful error message.
 		fmt.Println()
@@ -174,72 +507,155 @@ func main() {
 	// setting custom fields like TTL.
 	//
 	// "ip4:icmp" means: "I want to send/receive IPv4 ICMP packets"
+	// ("ip6:ipv6-icmp" for IPv6, which has its own flavour of ICMP)
 	// "0.0.0.0" means: "Listen on all network interfaces on this machine"
 	//
 	// This is where we need root/sudo privileges! The operating system
 	// checks if we have permission to create raw sockets, and only
 	// allows it for administrators.
+	//
+	// No sudo? We then try an unprivileged ICMP "ping" socket instead,
+	// which many systems allow for normal users (see socket.go).
 
-	fmt.Println("🔌 Creating network socket...")
-
-	conn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
-	if err != nil {
-		fmt.Println()
-		fmt.Println("❌ ERROR: Could not create network socket")
-		fmt.Printf("   Technical details: %v\n", err)
-		fmt.Println()
-		fmt.Println("🔧 This usually means you need administrator privileges!")
-		fmt.Println("   Try running with: sudo go run main.go " + destination)
-		fmt.Println()
-		fmt.Println("   On Linux/Mac: sudo is required for raw ICMP sockets")
-		fmt.Println("   On Windows: Run as Administrator")
-		os.Exit(1)
-	}
+	sock := mustOpenSocket(destination, sockOpts.forFamily(destAddr))
 
 	// "defer" schedules this to run when the function exits.
 	// It's like saying "remind me to close this when we're done!"
 	// This ensures we clean up properly even if an error occurs.
-	defer conn.Close()
+	defer sock.Close()
 
-	fmt.Println("✅ Socket created successfully!")
-	fmt.Println()
+	prober := NewProber(sock, destAddr, proberConfig)
+
+	// The full-screen display does its own probing, with or without
+	// -continuous (see tui.go).
+	if *tuiMode {
+		runTUI(prober, destination, destAddr, opts, *continuous)
+		return
+	}
+
+	// In continuous mode we hand over to the live display and never return
+	// to the one-shot trace below (see mtr.go).
+	if *continuous {
+		runContinuous(prober, destination, destAddr, opts)
+		return
+	}
+
+	// In multipath mode we map every load-balanced path instead of
+	// following one (see multipath.go).
+	if *paths {
+		fmt.Printf("🔀 Finding every path to %s (%s), trying flows %d-%d at each hop\n",
+			destination, destAddr.IP, *flowID, *flowID+*numFlows-1)
+		fmt.Println()
+		fmt.Println(pathsHeader())
+
+		if runPaths(prober, opts, *flowID, *numFlows) {
+			fmt.Println("🎉 Destination reached!")
+		} else {
+			fmt.Println("⚠️  Destination not reached")
+		}
+		return
+	}
 
 	// -------------------------------------------------------------------------
 	// STEP 4: Print the header and start tracing!
 	// -------------------------------------------------------------------------
 
 	fmt.Printf("🚀 Tracing route to %s (%s)\n", destination, destAddr.IP)
-	fmt.Printf("   Maximum %d hops, %d probes per hop, %d byte packets\n",
-		MaxHops, NumProbes, PacketSize)
+	fmt.Printf("   Maximum %d hops, %d probes per hop, %d byte packets, %d probes in flight\n",
+		opts.MaxHops, opts.NumProbes, *packetSize, opts.MaxInflight)
+	if opts.FirstTTL > 1 {
+		fmt.Printf("   Starting at TTL %d\n", opts.FirstTTL)
+	}
+	if *paris {
+		fmt.Printf("   Paris mode: all probes use flow %d\n", *flowID)
+	}
 	fmt.Println()
 
 	// Print column headers
-	// We'll show: hop number, three RTT values (for 3 probes), IP address, hostname
-	fmt.Println("Hop   Probe 1    Probe 2    Probe 3    IP Address         Hostname")
-	fmt.Println("───   ───────    ───────    ───────    ──────────         ────────")
+	// We'll show: hop number, one RTT value per probe, IP address, hostname
+	fmt.Println(hopHeader(opts))
 
 	// -------------------------------------------------------------------------
 	// STEP 5: The main traceroute loop!
 	// -------------------------------------------------------------------------
 	// This is the heart of the program!
 	//
-	// We start with TTL=1 (packet expires at first router)
-	// and keep incrementing until we reach the destination
-	// or hit our maximum hop count.
-
-	for ttl := 1; ttl <= MaxHops; ttl++ {
-		// Send probes and collect results for this TTL
-		reachedDestination := traceHop(conn, destAddr, ttl)
-
-		// Did we make it?
-		if reachedDestination {
+	// We probe TTL=1 (packet expires at first router), TTL=2, TTL=3...
+	// (or start further along with -first-ttl)
+	// many of them at the same time, and print each hop in order as soon
+	// as its probes are done - until we reach the destination or hit our
+	// maximum hop count.
+
+	var hops []hopResult // Kept for -json, -csv and -metrics-server
+	lastHop := 0         // The last hop that answered
+	reached, loop, silent := traceRoute(prober, opts, func(hop hopResult) {
+		printHopResults(hop, opts.Names)
+		hops = append(hops, hop)
+		if hop.Received() > 0 {
+			lastHop = hop.TTL
+		}
+	})
+	if loop != nil {
+		fmt.Println(formatLoop(loop))
+	}
+	if silent != nil {
+		fmt.Println(formatSilent(silent))
+	}
+	printStrays(prober.Strays(), opts.Names)
+	if jsonOut != nil || *compareFile != "" || historyFile != nil {
+		trace := newJSONTrace(destination, destAddr, reached, loop, silent, hops, opts.Names)
+		trace.Strays = newJSONStrays(prober.Strays())
+		if jsonOut != nil {
+			writeJSON(jsonOut, trace)
+		}
+		if historyFile != nil {
+			saveHistory(historyFile, started, []jsonTrace{trace})
+		}
+		if *compareFile != "" {
 			fmt.Println()
-			fmt.Println("════════════════════════════════════════════════════════════════")
-			fmt.Println("🎉 SUCCESS! Destination reached!")
-			fmt.Println("════════════════════════════════════════════════════════════════")
-			return // We're done!
+			printDiff(before, trace, *compareFile, "now")
 		}
 	}
+	result := multiResult{Destination: destination, Addr: destAddr, Reached: reached, Loop: loop, Silent: silent, Hops: lastHop, Trace: hops}
+	if csvFile != nil {
+		saveCSV(csvFile, started, opts.NumProbes, []multiResult{result})
+	}
+	if sink != nil {
+		sink.send(started, []multiResult{result})
+	}
+
+	if reached {
+		fmt.Println()
+		fmt.Println("════════════════════════════════════════════════════════════════")
+		fmt.Println("🎉 SUCCESS! Destination reached!")
+		fmt.Println("════════════════════════════════════════════════════════════════")
+		return // We're done!
+	}
+
+	if loop != nil {
+		fmt.Println()
+		fmt.Println("════════════════════════════════════════════════════════════════")
+		fmt.Printf("🔁 Stopped at hop %d: packets are going round in circles\n", loop.LastTTL)
+		fmt.Println()
+		fmt.Printf("%s keeps answering, so the routers around it disagree about\n", loop.Responder)
+		fmt.Println("the way to the destination. Nothing sent this way gets through -")
+		fmt.Println("the network's operator needs to fix their routing.")
+		fmt.Println("════════════════════════════════════════════════════════════════")
+		return
+	}
+
+	if silent != nil {
+		fmt.Println()
+		fmt.Println("════════════════════════════════════════════════════════════════")
+		fmt.Printf("🔇 Gave up after %d silent hops in a row (-max-unknown)\n", *maxUnknown)
+		fmt.Println()
+		fmt.Println("This could mean:")
+		fmt.Printf("  • A firewall drops everything past hop %d\n", silent.FirstTTL-1)
+		fmt.Println("  • The destination is blocking ICMP packets")
+		fmt.Println("  • There are more silent routers in a row than -max-unknown allows")
+		fmt.Println("════════════════════════════════════════════════════════════════")
+		return
+	}
 
 	// If we get here, we hit MaxHops without reaching the destination
 	fmt.Println()
@@ -248,319 +664,266 @@ func main() {
 	fmt.Println()
 	fmt.Println("This could mean:")
 	fmt.Println("  • The destination is blocking ICMP packets")
-	fmt.Println("  • The destination is very far away (>30 hops)")
+	fmt.Printf("  • The destination is very far away (>%d hops)\n", opts.MaxHops)
 	fmt.Println("  • There's a routing problem on the internet")
 	fmt.Println("════════════════════════════════════════════════════════════════")
 }
 
 // =============================================================================
-// TRACE HOP FUNCTION
+// TRACE ROUTE FUNCTION
 // =============================================================================
-// This function handles one "hop" (one TTL value) of the trace.
-// It sends multiple probes and prints the results.
+// This function runs the whole trace. Instead of probing one TTL at a time,
+// it keeps up to maxInflight probes "in flight" at once - across many TTLs -
+// and hands each hop to onHop as soon as it (and every hop before it) is done.
+//
+// Why wait for earlier hops? Replies for hop 5 might arrive before replies
+// for hop 2 (hop 2 could be a slow or silent router). We hold hop 5 back
+// until hop 2 is finished so the output still reads top to bottom.
 //
 // Parameters:
-//   - conn: Our ICMP socket for sending/receiving
-//   - dest: The final destination we're trying to reach
-//   - ttl: How many hops this packet should survive
+//   - prober: Our probe engine (socket + reply matching)
+//   - opts: Which TTLs to probe, how many probes per hop, and how many
+//     probes may wait for replies at once
+//   - onHop: Called once per hop, in TTL order
 //
 // Returns:
 //   - true if we reached the final destination
-//   - false if we got a "time exceeded" from an intermediate router
+//   - the routing loop that made us stop early, if any (see loop.go)
+//   - the silent hops that made us give up, if any (see silent.go)
+
+// traceOptions controls which hops we probe and how.
+type traceOptions struct {
+	FirstTTL    int        // The first TTL to probe (usually 1)
+	MaxHops     int        // The last TTL to probe
+	NumProbes   int        // Probes per hop
+	MaxInflight int        // Most probes waiting for a reply at once
+	MaxUnknown  int        // Give up after this many silent hops in a row (0 = never)
+	Names       *nameCache // Router names (nil with -n, see names.go)
+}
 
-func traceHop(conn *icmp.PacketConn, dest *net.IPAddr, ttl int) bool {
-	// We'll collect results from all probes
-	// Each probe might hit a different router (load balancing!)
-	// or return at a different time (network variance)
-	var rtts [NumProbes]string       // Round-trip times as formatted strings
-	var respondingIP string          // IP address of whoever responded
-	var reachedDestination bool      // Did any probe reach the final destination?
+func traceRoute(prober *Prober, opts traceOptions, onHop func(hopResult)) (bool, *routingLoop, *silentRun) {
+	// Every probe result lands here. The buffer is big enough for ALL probes,
+	// so a probe goroutine never gets stuck if we stop listening early.
+	results := make(chan probeResult, (opts.MaxHops-opts.FirstTTL+1)*opts.NumProbes)
+
+	// lastTTL is the highest TTL worth probing. It starts at MaxHops and
+	// shrinks once we learn how far away the destination really is.
+	var lastTTL atomic.Int32
+	lastTTL.Store(int32(opts.MaxHops))
 
 	// -------------------------------------------------------------------------
-	// Send multiple probes at this TTL
+	// The dispatcher: launches probes in TTL order, but never more than
+	// maxInflight at a time. The "sem" channel works like a parking lot with
+	// maxInflight spaces - a probe needs a space to run, and frees it when done.
 	// -------------------------------------------------------------------------
-	for probe := 0; probe < NumProbes; probe++ {
-		// Send one probe and get the result
-		hopIP, rtt, reached, err := sendProbe(conn, dest, ttl, probe)
-
-		if err != nil {
-			// Something went wrong with this probe
-			rtts[probe] = "error"
-		} else if hopIP == "" {
-			// Timeout - no response received
-			rtts[probe] = "*"
-		} else {
-			// Got a response!
-			rtts[probe] = formatRTT(rtt)
-			respondingIP = hopIP
-
-			if reached {
-				reachedDestination = true
+	go func() {
+		sem := make(chan struct{}, opts.MaxInflight)
+		for ttl := opts.FirstTTL; ttl <= opts.MaxHops; ttl++ {
+			for probe := 0; probe < opts.NumProbes; probe++ {
+				sem <- struct{}{} // Wait for a free space
+				if ttl > int(lastTTL.Load()) {
+					return // Already past the destination, stop launching
+				}
+				go func(ttl int) {
+					defer func() { <-sem }()
+					results <- prober.Probe(ttl)
+				}(ttl)
 			}
 		}
-	}
-
-	// -------------------------------------------------------------------------
-	// Print the results for this hop
-	// -------------------------------------------------------------------------
-	printHopResults(ttl, rtts, respondingIP)
+	}()
 
-	return reachedDestination
-}
-
-// =============================================================================
-// SEND PROBE FUNCTION
-// =============================================================================
-// This function sends a single ICMP Echo Request and waits for a response.
-// This is where the real network magic happens!
-//
-// WHAT'S AN ICMP ECHO REQUEST?
-// It's like shouting "Hello, is anyone there?" into the network.
-// The destination (or an intermediate router) will shout back
-// "Yes, I heard you!" (Echo Reply) or "Your message died here!" (Time Exceeded).
-//
-// Parameters:
-//   - conn: Our ICMP socket
-//   - dest: Where we're trying to reach
-//   - ttl: Time To Live (how many routers can touch this packet)
-//   - seq: Sequence number (helps match responses to requests)
-//
-// Returns:
-//   - hopIP: IP address of whoever responded ("" if timeout)
-//   - rtt: How long the round trip took
-//   - reached: true if this was the final destination
-//   - err: Any error that occurred
-
-func sendProbe(conn *icmp.PacketConn, dest *net.IPAddr, ttl, seq int) (string, time.Duration, bool, error) {
 	// -------------------------------------------------------------------------
-	// STEP A: Set the TTL on our socket
+	// The collector: gathers results and releases hops in order.
 	// -------------------------------------------------------------------------
-	// TTL is set at the IP layer (Internet Protocol, the "envelope" around our ICMP packet).
-	// Each router that handles our packet will decrement TTL by 1.
-	// When TTL hits 0, the router can't forward it, and must tell us where it died.
-	//
-	// TTL=1: Dies at first router
-	// TTL=2: Dies at second router
-	// TTL=20: Can pass through 19 routers before dying at the 20th
-	//
-	// IPv4PacketConn() gives us access to IPv4-specific settings
-	// SetTTL() actually sets the Time To Live field
-
-	if err := conn.IPv4PacketConn().SetTTL(ttl); err != nil {
-		return "", 0, false, fmt.Errorf("couldn't set TTL to %d: %w", ttl, err)
-	}
+	hops := make(map[int][]probeResult)
+	reached := false
+	next := opts.FirstTTL // The next hop to hand to onHop
+	var detector loopDetector
+	var loop *routingLoop
+	silence := silentDetector{max: opts.MaxUnknown}
+	var silent *silentRun
+
+	for next <= int(lastTTL.Load()) {
+		r := <-results
+		hops[r.TTL] = append(hops[r.TTL], r)
+
+		// Start finding the router's name now, so it's (probably) ready
+		// by the time its hop is printed
+		if r.Responder != "" {
+			opts.Names.Start(r.Responder)
+		}
 
-	// -------------------------------------------------------------------------
-	// STEP B: Build our ICMP Echo Request packet
-	// -------------------------------------------------------------------------
-	// ICMP packets have a specific structure (defined in RFC 792):
-	//
-	//  0                   1                   2                   3
-	//  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
-	// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-	// |     Type      |     Code      |          Checksum             |
-	// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-	// |           Identifier          |        Sequence Number        |
-	// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-	// |                         Data (optional)                       |
-	// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-	//
-	// Type: 8 = Echo Request (we're asking "are you there?")
-	// Code: 0 (no sub-type for Echo Request)
-	// Checksum: Error detection (calculated automatically)
-	// Identifier: Helps us recognize OUR packets vs. other programs' packets
-	// Sequence: Helps us match responses to requests
-	// Data: Extra payload (we use zeros, could put a timestamp, etc.)
-
-	message := &icmp.Message{
-		Type: ipv4.ICMPTypeEcho, // Type 8 = Echo Request
-		Code: 0,                 // Always 0 for Echo Request
-		Body: &icmp.Echo{
-			// Identifier: We use our process ID so we can identify our own packets.
-			// Multiple programs might be sending ICMP at the same time!
-			// The & 0xffff part keeps only the bottom 16 bits (ID is 16-bit).
-			ID: os.Getpid() & 0xffff,
-
-			// Sequence number: Combines TTL and probe number for uniqueness.
-			// This helps us match incoming responses to outgoing requests.
-			Seq: ttl*100 + seq,
-
-			// Data: We send 56 bytes of zeros (traditional ping size).
-			// Some traceroutes put timestamps here to measure RTT more precisely.
-			Data: make([]byte, PacketSize),
-		},
-	}
-
-	// Convert our structured message into raw bytes
-	// Marshal() also calculates the checksum for us!
-	messageBytes, err := message.Marshal(nil)
-	if err != nil {
-		return "", 0, false, fmt.Errorf("couldn't build ICMP packet: %w", err)
-	}
+		// The destination answered! Nothing beyond this TTL matters.
+		if r.Reached && r.TTL <= int(lastTTL.Load()) {
+			lastTTL.Store(int32(r.TTL))
+			reached = true
+		}
 
-	// -------------------------------------------------------------------------
-	// STEP C: Send the packet!
-	// -------------------------------------------------------------------------
-	// We address it to the final destination, even though we know it won't
-	// get there (because TTL is too low). The intermediate router that kills
-	// the packet will tell us where it died.
+		// Release every hop that is complete and next in line
+		for next <= int(lastTTL.Load()) && len(hops[next]) == opts.NumProbes {
+			hop := hopResult{TTL: next, Probes: hops[next]}
+			onHop(hop)
+			delete(hops, next)
+			next++
 
-	startTime := time.Now() // Record when we sent it (for RTT calculation)
+			// Going round in circles? Higher TTLs won't get any further.
+			if loop = detector.add(hop); loop != nil {
+				lastTTL.Store(int32(hop.TTL))
+			}
 
-	_, err = conn.WriteTo(messageBytes, dest)
-	if err != nil {
-		return "", 0, false, fmt.Errorf("couldn't send packet: %w", err)
+			// Nobody answering for a while? Give up (-max-unknown).
+			if silent = silence.add(hop); silent != nil {
+				lastTTL.Store(int32(hop.TTL))
+			}
+		}
 	}
 
-	// -------------------------------------------------------------------------
-	// STEP D: Wait for a response
-	// -------------------------------------------------------------------------
-	// Now we wait. Three things can happen:
-	//
-	// 1. TIMEOUT: No response arrives (router dropped it silently)
-	//    Result: We'll return ("", 0, false, nil)
-	//
-	// 2. TIME EXCEEDED: A router's TTL counter hit 0
-	//    Result: We'll return (router's IP, rtt, false, nil)
-	//
-	// 3. ECHO REPLY: We reached the destination!
-	//    Result: We'll return (destination IP, rtt, true, nil)
-
-	// Create a buffer to receive the response
-	// 1500 bytes is the maximum Ethernet frame size, plenty of room
-	reply := make([]byte, 1500)
-
-	// Set a deadline: if nothing arrives by this time, stop waiting
-	// This prevents us from waiting forever for a router that won't respond
-	conn.SetReadDeadline(time.Now().Add(Timeout))
+	return reached, loop, silent
+}
 
-	// ReadFrom blocks (waits) until:
-	// - A packet arrives
-	// - The deadline passes (timeout error)
-	// n = how many bytes we received
-	// peer = who sent the response (their IP address)
-	n, peer, err := conn.ReadFrom(reply)
+// =============================================================================
+// OPEN SOCKET
+// =============================================================================
+// Opens our ICMP socket (see STEP 3 in main), or explains what went wrong
+// and exits. destinations is only used to show the command to retry with.
 
-	// Calculate round-trip time now (even if there was an error)
-	rtt := time.Since(startTime)
+func mustOpenSocket(destinations string, opts socketOptions) *probeSocket {
+	fmt.Println("🔌 Creating network socket...")
 
-	// -------------------------------------------------------------------------
-	// STEP E: Handle timeout
-	// -------------------------------------------------------------------------
+	sock, err := openSocket(opts)
 	if err != nil {
-		// Check if this is a timeout (as opposed to some other error)
-		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-			// Timeout is normal! Some routers don't respond to ICMP.
-			// Return empty results (the caller will print "*")
-			return "", 0, false, nil
+		fmt.Println()
+		fmt.Println("❌ ERROR: Could not create network socket")
+		fmt.Printf("   Technical details: %v\n", err)
+		fmt.Println()
+		if opts.Source != nil {
+			fmt.Printf("🔧 Check that %s is an address of this machine (-S)\n", opts.Source)
+			fmt.Println()
 		}
-		// Some other error occurred
-		return "", 0, false, fmt.Errorf("error receiving: %w", err)
+		fmt.Println("🔧 This usually means you need administrator privileges!")
+		fmt.Println("   Try running with: sudo go run . " + destinations)
+		fmt.Println()
+		fmt.Println("   On Linux/Mac: sudo is required for raw ICMP sockets")
+		fmt.Println("   On Linux without sudo: check that your group is inside")
+		fmt.Println("   the range in /proc/sys/net/ipv4/ping_group_range")
+		fmt.Println("   On Windows: Run as Administrator")
+		os.Exit(1)
 	}
 
-	// -------------------------------------------------------------------------
-	// STEP F: Parse the response
-	// -------------------------------------------------------------------------
-	// We got data! But what kind? We need to parse the ICMP message
-	// to understand what the remote host is telling us.
-
-	parsedMessage, err := icmp.ParseMessage(ProtocolICMP, reply[:n])
-	if err != nil {
-		return "", 0, false, fmt.Errorf("couldn't parse response: %w", err)
+	if sock.datagram {
+		fmt.Println("✅ Socket created successfully! (unprivileged ICMP datagram socket)")
+	} else {
+		fmt.Println("✅ Socket created successfully!")
+	}
+	if opts.Source != nil {
+		fmt.Printf("   Sending from %s\n", opts.Source)
+	}
+	if opts.Interface != "" {
+		fmt.Printf("   Sending out of interface %s\n", opts.Interface)
 	}
+	fmt.Println()
+	return sock
+}
 
-	// Get the IP address of who sent the response
-	// This could be an intermediate router or the final destination
-	responderIP := peer.String()
+// interfaceExists reports whether this machine has a network interface
+// with the given name (like "eth0" or "en0").
+func interfaceExists(name string) bool {
+	_, err := net.InterfaceByName(name)
+	return err == nil
+}
 
-	// -------------------------------------------------------------------------
-	// STEP G: Interpret the response type
-	// -------------------------------------------------------------------------
-	switch parsedMessage.Type {
-
-	case ipv4.ICMPTypeEchoReply:
-		// TYPE 0: Echo Reply
-		// This means our packet made it all the way to the destination!
-		// The destination is responding to our "hello" with "yes I'm here!"
-		// We're done tracing!
-		return responderIP, rtt, true, nil
-
-	case ipv4.ICMPTypeTimeExceeded:
-		// TYPE 11: Time Exceeded
-		// This means TTL hit 0 at this router.
-		// The router is telling us "your packet died at my location"
-		// This is EXACTLY what we want for traceroute!
-		return responderIP, rtt, false, nil
-
-	case ipv4.ICMPTypeDestinationUnreachable:
-		// TYPE 3: Destination Unreachable
-		// This means something blocked our packet (firewall, no route, etc.)
-		// We'll treat this as reaching a hop, but not the destination
-		return responderIP, rtt, false, nil
-
-	default:
-		// Some other ICMP type we weren't expecting
-		// Still useful info, so we'll return it
-		return responderIP, rtt, false, nil
+// =============================================================================
+// PRINT HOP HEADER
+// =============================================================================
+// Builds the column titles, with one "Probe N" column per probe, and a
+// Hostname column unless we're showing numbers only (-n).
+
+func hopHeader(opts traceOptions) string {
+	titles := "Hop   "
+	lines := "───   "
+	for i := 1; i <= opts.NumProbes; i++ {
+		titles += fmt.Sprintf("%-10s ", fmt.Sprintf("Probe %d", i))
+		lines += fmt.Sprintf("%-10s ", "───────")
+	}
+	titles += "Loss%  "
+	lines += "─────  "
+	if opts.Names == nil {
+		return titles + "IP Address\n" + lines + "──────────"
 	}
+	return titles + "IP Address         Hostname\n" + lines + "──────────         ────────"
 }
 
 // =============================================================================
 // PRINT HOP RESULTS
 // =============================================================================
 // Pretty-prints the results for one TTL level (one row in our output).
-// Also does reverse DNS lookup to show the hostname.
+// Also shows the router's hostname (from reverse DNS, see names.go).
+
+func printHopResults(hop hopResult, names *nameCache) {
+	fmt.Println(formatHopResults(hop, names))
+}
 
-func printHopResults(ttl int, rtts [NumProbes]string, responderIP string) {
+// formatHopResults builds the output line for one hop, without printing it.
+func formatHopResults(hop hopResult, names *nameCache) string {
 	// Start building the output line
-	// %2d formats the number with padding (so "1" becomes " 1")
-	line := fmt.Sprintf("%3d   ", ttl)
+	// %3d formats the number with padding (so "1" becomes "  1")
+	line := fmt.Sprintf("%3d   ", hop.TTL)
 
 	// Add each RTT value with consistent spacing
-	for _, rtt := range rtts {
+	responderIP := ""
+	for _, probe := range hop.Probes {
+		rtt := "*" // Timeout - no response received
+		if probe.Err != nil {
+			rtt = "error"
+		} else if probe.Responder != "" {
+			rtt = formatRTT(probe.RTT)
+			responderIP = probe.Responder
+		}
 		line += fmt.Sprintf("%-10s ", rtt)
 	}
 
+	// Add the share of probes that got no answer
+	line += fmt.Sprintf("%-6s ", fmt.Sprintf("%.0f%%", hop.Loss()))
+
 	// Add IP address (or stars if no response)
 	if responderIP == "" {
 		line += fmt.Sprintf("%-18s ", "*")
 		line += "(no response)"
+	} else if names == nil {
+		line += responderIP // Numbers only (-n)
 	} else {
 		line += fmt.Sprintf("%-18s ", responderIP)
 
-		// Try to look up the hostname for this IP
-		// This is "reverse DNS" - going from IP to name
-		hostname := lookupHostname(responderIP)
-		line += hostname
+		// Add the router's name
+		// This is "reverse DNS" - going from IP to name (see names.go)
+		line += names.Wait(responderIP)
 	}
 
-	fmt.Println(line)
-}
-
-// =============================================================================
-// LOOKUP HOSTNAME
-// =============================================================================
-// Does reverse DNS lookup to find the hostname for an IP address.
-// Not all IPs have hostnames, so this might return "(no hostname)".
-
-func lookupHostname(ip string) string {
-	// net.LookupAddr does reverse DNS lookup
-	// It returns a slice of names (usually just one)
-	names, err := net.LookupAddr(ip)
-
-	if err != nil || len(names) == 0 {
-		return "(no hostname)"
+	// Point out our own router (see gateway.go)
+	if isDefaultGateway(hop) {
+		line += "  🏠 your default gateway"
+	} else if labelPrivate && responderIP != "" {
+		// Or any other router with an address that isn't public (see compact.go)
+		if label := privateLabel(responderIP); label != "" {
+			line += "  " + label
+		}
 	}
 
-	// Get the first (usually only) name
-	hostname := names[0]
+	// With more probes than usual there are enough RTTs to say how much
+	// they jump around (the "jitter"), so sum them up like ping does
+	if stats := hop.Stats(); len(hop.Probes) > DefaultNumProbes && stats.Received >= 2 {
+		line += fmt.Sprintf("\n      rtt min/avg/max/stddev = %s/%s/%s/%s",
+			formatRTT(stats.Best), formatRTT(stats.Avg()), formatRTT(stats.Worst), formatRTT(stats.StdDev()))
+	}
 
-	// Remove trailing dot if present (DNS names often end with ".")
-	if len(hostname) > 0 && hostname[len(hostname)-1] == '.' {
-		hostname = hostname[:len(hostname)-1]
+	// Add any MPLS labels the router reported, one per line (see mpls.go)
+	for _, stack := range hopMPLS(hop) {
+		for _, label := range formatMPLS(stack) {
+			line += "\n      " + label
+		}
 	}
 
-	return hostname
+	return line
 }
 
 // =============================================================================
@@ -596,23 +959,70 @@ func printUsage() {
 	fmt.Println("╚════════════════════════════════════════════════════════════════╝")
 	fmt.Println()
 	fmt.Println("USAGE:")
-	fmt.Println("   sudo go run main.go <destination>")
+	fmt.Println("   sudo go run . [options] <destination> [destination...]")
+	fmt.Println("   sudo go run . [options] -f targets.txt")
+	fmt.Println("   go run . -history FILE [destination...]")
+	fmt.Println()
+	fmt.Println("OPTIONS:")
+	fmt.Printf("   -m N              Maximum number of hops to probe (default %d)\n", DefaultMaxHops)
+	fmt.Println("   -first-ttl N      TTL to start probing at (default 1)")
+	fmt.Println("   -max-unknown N    Give up after N hops in a row with no reply (default: go on to -m)")
+	fmt.Printf("   -w SECONDS        Time to wait for each reply (default %g)\n", DefaultTimeout.Seconds())
+	fmt.Println("   -adaptive=false   Always wait the full -w, even once replies show it's too long")
+	fmt.Printf("   -q N              Probes per hop (default %d)\n", DefaultNumProbes)
+	fmt.Printf("   -s BYTES          Bytes of data in each probe (default %d)\n", DefaultPacketSize)
+	fmt.Printf("   -max-inflight N   Probes allowed in flight at once (default %d)\n", DefaultMaxInflight)
+	fmt.Println("   -z SECONDS        Wait at least this long between any two probes (also -send-interval)")
+	fmt.Println("   -ttl-interval SECONDS")
+	fmt.Println("                     Wait at least this long between probes to the same hop")
+	fmt.Println("   -continuous       Keep probing every hop with live loss/RTT statistics (like mtr)")
+	fmt.Println("   -tui              Full-screen table that updates as probes return; scroll with the arrow keys")
+	fmt.Println("   -paris=false      Let each probe take its own path (classic traceroute)")
+	fmt.Println("   -flow N           Flow to use in Paris mode; different flows may take different paths")
+	fmt.Println("   -f FILE           Read destinations from FILE, one per line (- for stdin)")
+	fmt.Printf("   -parallel N       Destinations traced at the same time (default %d)\n", DefaultParallel)
+	fmt.Println("   -i INTERFACE      Send probes out of this network interface (like eth0)")
+	fmt.Println("   -S ADDRESS        Send probes from this source address (IPv4 or IPv6)")
+	fmt.Println("   -n                Show IP addresses only; don't look up router names")
+	fmt.Println("   -trim-suffix DOMAINS")
+	fmt.Println("                     Cut these endings (comma-separated) off router names, like corp.example.com")
+	fmt.Println("   -label-private    Point out hops with private (RFC 1918, IPv6 ULA) or carrier NAT addresses")
+	fmt.Println("   -csv FILE         Append one row per hop to FILE, for a history of scheduled runs")
+	fmt.Println("   -metrics-server HOST:PORT")
+	fmt.Println("                     Send per-hop RTT and loss to a metrics-system server (build with -tags metrics)")
+	fmt.Println("   -json             Write the results as JSON (the usual output goes to stderr)")
+	fmt.Println("   -dual             Trace the IPv4 and IPv6 addresses at once and show them side by side")
+	fmt.Println("   -compare FILE     Show what changed since a trace saved with -json (FILE)")
+	fmt.Println("   -every DURATION   Trace again every DURATION (like 30s or 5m) until Ctrl+C")
+	fmt.Println("   -save FILE        Append each trace to a history FILE, one line of JSON per trace")
+	fmt.Println("   -history FILE     Show how the paths and RTTs saved in FILE changed over time")
+	fmt.Println("   -paths            Find every load-balanced path, not just one")
+	fmt.Printf("   -flows N          Flows -paths tries at each hop (default %d)\n", DefaultFlows)
 	fmt.Println()
 	fmt.Println("EXAMPLES:")
-	fmt.Println("   sudo go run main.go google.com      # Trace to Google")
-	fmt.Println("   sudo go run main.go 8.8.8.8         # Trace to Google DNS")
-	fmt.Println("   sudo go run main.go amazon.com      # Trace to Amazon")
-	fmt.Println("   sudo go run main.go cloudflare.com  # Trace to Cloudflare")
+	fmt.Println("   sudo go run . google.com      # Trace to Google")
+	fmt.Println("   sudo go run . 8.8.8.8         # Trace to Google DNS")
+	fmt.Println("   sudo go run . amazon.com      # Trace to Amazon")
+	fmt.Println("   sudo go run . cloudflare.com  # Trace to Cloudflare")
+	fmt.Println("   sudo go run . 1.1.1.1 8.8.8.8 # Trace both at once, with a summary")
+	fmt.Println("   sudo go run . -dual google.com # Compare the IPv4 and IPv6 paths")
+	fmt.Println("   sudo go run . -compare before.json google.com # What changed?")
+	fmt.Println("   sudo go run . -tui -continuous 1.1.1.1 # Live table with RTT sparklines")
+	fmt.Println("   sudo go run . -every 5m -save history.jsonl 1.1.1.1 # Keep a history")
+	fmt.Println("   sudo go run . -label-private -trim-suffix corp.example.com 10.90.0.1 # Inside a company")
 	fmt.Println()
 	fmt.Println("WHY SUDO?")
 	fmt.Println("   Traceroute needs to send special ICMP packets with custom")
 	fmt.Println("   TTL values. This requires 'raw socket' access, which needs")
 	fmt.Println("   administrator/root privileges for security reasons.")
+	fmt.Println("   Without sudo, an unprivileged ICMP 'ping' socket is used")
+	fmt.Println("   instead where the system allows it (Linux, macOS).")
 	fmt.Println()
 	fmt.Println("WHAT YOU'LL SEE:")
 	fmt.Println("   Each line shows one 'hop' (router) between you and the destination:")
 	fmt.Println("   • Hop number (1 = first router, 2 = second, etc.)")
 	fmt.Println("   • Response times from 3 probes (in milliseconds)")
+	fmt.Println("   • Loss%: how many probes got no answer (use -q 10 for a fairer number)")
 	fmt.Println("   • IP address of the router")
 	fmt.Println("   • Hostname of the router (if available)")
 	fmt.Println()
@@ -0,0 +1,134 @@
+//go:build metrics
+
+// =============================================================================
+// METRICS - Sending traces to the metrics-system server
+// =============================================================================
+//
+// One trace tells you how the path looks right now. Run traceroute every
+// few minutes (from cron, say) and send every result to the metrics-system
+// server (../metrics-system), and you can draw graphs of each hop's delay
+// and loss over time, and see exactly when a path got worse.
+//
+// With -metrics-server HOST:PORT every finished trace is sent to the
+// server's gRPC port, using the same client the metrics agent uses:
+//
+//   traceroute_hop_rtt_ms         average round-trip time of the hop
+//   traceroute_hop_loss_percent   share of the hop's probes that got no reply
+//   traceroute_reached            1 if the destination answered, 0 if not
+//   traceroute_hops               hops to the destination (or the last hop
+//                                 that answered)
+//
+// Every metric has a "target" label (the destination as you typed it), and
+// the hop metrics also have "ttl" and "responder" (the router that answered,
+// left out if nobody did). They're reported under this machine's hostname,
+// so traces from different places don't get mixed up.
+//
+// This needs the metrics-system code, so it is only built in with
+// "go build -tags metrics" (see metrics_off.go for the normal build).
+//
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bellistech/metrics-system/pkg/client"
+	"github.com/bellistech/metrics-system/pkg/metrics"
+)
+
+// metricsTimeout is how long sending one run's metrics may take.
+const metricsTimeout = 10 * time.Second
+
+// metricsSink sends finished traces to a metrics server.
+type metricsSink struct {
+	address  string
+	hostname string
+	client   *client.Client
+}
+
+// openMetrics connects to the metrics server at address.
+func openMetrics(address string) (*metricsSink, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	c, err := client.New(address, hostname, "traceroute-"+hostname)
+	if err != nil {
+		return nil, err
+	}
+	return &metricsSink{address: address, hostname: hostname, client: c}, nil
+}
+
+// send sends the traces, and only warns if that fails - the trace itself
+// was still printed.
+func (m *metricsSink) send(started time.Time, traces []multiResult) {
+	var batch []metrics.Metric
+	for _, trace := range traces {
+		if trace.Err == nil {
+			batch = append(batch, m.traceMetrics(started, trace)...)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), metricsTimeout)
+	defer cancel()
+	if err := m.client.Send(ctx, batch); err != nil {
+		fmt.Printf("⚠️  Could not send metrics to %s: %v\n", m.address, err)
+	}
+}
+
+// Close closes the connection to the server.
+func (m *metricsSink) Close() error {
+	return m.client.Close()
+}
+
+// traceMetrics turns one trace into metrics, all stamped with the time the
+// run started.
+func (m *metricsSink) traceMetrics(started time.Time, trace multiResult) []metrics.Metric {
+	gauge := func(name string, value float64, unit string) metrics.Metric {
+		metric := metrics.NewMetric(name, value, metrics.MetricTypeGauge, m.hostname).
+			WithLabel("target", trace.Destination).
+			WithUnit(unit)
+		metric.Timestamp = started
+		return metric
+	}
+
+	var batch []metrics.Metric
+	for _, hop := range trace.Trace {
+		var total time.Duration
+		responder := ""
+		for _, p := range hop.Probes {
+			if p.Responder != "" {
+				total += p.RTT
+				responder = p.Responder
+			}
+		}
+
+		loss := gauge("traceroute_hop_loss_percent", hop.Loss(), "percent").
+			WithLabel("ttl", strconv.Itoa(hop.TTL))
+		if responder == "" {
+			batch = append(batch, loss)
+			continue
+		}
+
+		avg := float64(total.Microseconds()) / 1000 / float64(hop.Received())
+		rtt := gauge("traceroute_hop_rtt_ms", avg, "milliseconds").
+			WithLabel("ttl", strconv.Itoa(hop.TTL)).
+			WithLabel("responder", responder)
+		batch = append(batch, rtt, loss.WithLabel("responder", responder))
+	}
+
+	reached := 0.0
+	if trace.Reached {
+		reached = 1
+	}
+	return append(batch,
+		gauge("traceroute_reached", reached, ""),
+		gauge("traceroute_hops", float64(trace.Hops), "hops"),
+	)
+}
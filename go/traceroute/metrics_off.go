@@ -0,0 +1,21 @@
+//go:build !metrics
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// metricsSink stands in for the real one in metrics.go, which needs the
+// metrics-system code and is only built with "go build -tags metrics".
+type metricsSink struct{}
+
+// openMetrics explains how to get -metrics-server support.
+func openMetrics(address string) (*metricsSink, error) {
+	return nil, errors.New("this traceroute was built without metrics support; rebuild it with \"go build -tags metrics\"")
+}
+
+func (m *metricsSink) send(started time.Time, traces []multiResult) {}
+
+func (m *metricsSink) Close() error { return nil }
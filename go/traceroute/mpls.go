@@ -0,0 +1,89 @@
+// =============================================================================
+// MPLS LABELS - Seeing inside the carrier's tunnels
+// =============================================================================
+//
+// Big networks often don't route each packet by its IP address. Instead the
+// first router sticks a small number - a "label" - on the front of the
+// packet, and the routers after it just look at the label ("label 24001?
+// out of port 3, and swap it for 16005"). This is MPLS (Multi-Protocol
+// Label Switching), and the path a label leads along is an "LSP" (Label
+// Switched Path).
+//
+// When our probe's TTL runs out inside one of those tunnels, many routers
+// attach the labels the packet was carrying to their Time Exceeded message
+// (RFC 4950, using the ICMP extensions of RFC 4884). We print them under
+// the hop:
+//
+//    5   12ms       11ms       12ms       0%     203.0.113.5        core1.example.net
+//        MPLS Label 24001 TC 0 S 1 TTL 1
+//
+//   - Label: which tunnel (or service) the packet was in
+//   - TC:    traffic class, the packet's priority (formerly "EXP")
+//   - S:     1 on the last label of the stack ("bottom of stack")
+//   - TTL:   the label's own TTL, which counts down like the IP one
+//
+// A packet can carry several labels at once (a tunnel inside a tunnel), so
+// there may be more than one line per stack.
+//
+// The unprivileged datagram socket (used without sudo) only tells us who
+// sent an error, not what was in it, so labels need a raw socket.
+//
+// =============================================================================
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/icmp"
+)
+
+// mplsLabels returns the MPLS label stack attached to an ICMP error, if
+// there is one.
+func mplsLabels(message *icmp.Message) []icmp.MPLSLabel {
+	var extensions []icmp.Extension
+	switch body := message.Body.(type) {
+	case *icmp.TimeExceeded:
+		extensions = body.Extensions
+	case *icmp.DstUnreach:
+		extensions = body.Extensions
+	}
+
+	var labels []icmp.MPLSLabel
+	for _, ext := range extensions {
+		if stack, ok := ext.(*icmp.MPLSLabelStack); ok {
+			labels = append(labels, stack.Labels...)
+		}
+	}
+	return labels
+}
+
+// formatMPLS returns one line per label, like Linux traceroute -e.
+func formatMPLS(labels []icmp.MPLSLabel) []string {
+	lines := make([]string, len(labels))
+	for i, l := range labels {
+		s := 0
+		if l.S {
+			s = 1
+		}
+		lines[i] = fmt.Sprintf("MPLS Label %d TC %d S %d TTL %d", l.Label, l.TC, s, l.TTL)
+	}
+	return lines
+}
+
+// hopMPLS returns every different label stack seen at a hop. Probes that
+// went through the same tunnel only count once.
+func hopMPLS(hop hopResult) [][]icmp.MPLSLabel {
+	var stacks [][]icmp.MPLSLabel
+	seen := make(map[string]bool)
+	for _, p := range hop.Probes {
+		key := strings.Join(formatMPLS(p.MPLS), "\n")
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		stacks = append(stacks, p.MPLS)
+	}
+	return stacks
+}
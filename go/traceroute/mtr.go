@@ -0,0 +1,253 @@
+// =============================================================================
+// CONTINUOUS MODE - Like "mtr": keep probing and watch the path live
+// =============================================================================
+//
+// A normal traceroute is a snapshot: 3 probes per hop, then we're done.
+// But networks change from second to second! A router that answered fine
+// once might be dropping 20% of packets under load.
+//
+// In continuous mode we probe every hop over and over (one probe per hop
+// per round) and keep running statistics for each one:
+//
+//   Loss%  - What fraction of probes got no answer
+//   Snt    - How many probes we've sent to this hop
+//   Last   - The most recent round-trip time
+//   Best   - The fastest round-trip time we've seen
+//   Avg    - The average round-trip time
+//   Wrst   - The slowest round-trip time we've seen
+//   StDev  - How much the times jump around (the "jitter")
+//
+// The screen is redrawn after every round. Press Ctrl+C to stop and
+// print a final report.
+//
+// =============================================================================
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ContinuousInterval is the shortest time between the starts of two rounds.
+// If a round takes longer (waiting on silent hops), the next starts right away.
+const ContinuousInterval = 1 * time.Second
+
+// hopStats keeps running statistics for one hop.
+type hopStats struct {
+	Sent      int
+	Received  int
+	Responder string // Whoever answered most recently
+	Last      time.Duration
+	Best      time.Duration
+	Worst     time.Duration
+
+	// Running mean and sum of squared differences (Welford's method), in
+	// milliseconds. This lets us compute the standard deviation without
+	// remembering every single RTT we've ever seen.
+	mean float64
+	m2   float64
+}
+
+// add records the result of one probe.
+func (s *hopStats) add(r probeResult) {
+	s.Sent++
+	if r.Err != nil || r.Responder == "" {
+		return // Lost (or never sent) - only counts against Loss%
+	}
+
+	s.Received++
+	s.Responder = r.Responder
+	s.Last = r.RTT
+	if s.Received == 1 || r.RTT < s.Best {
+		s.Best = r.RTT
+	}
+	if r.RTT > s.Worst {
+		s.Worst = r.RTT
+	}
+
+	ms := float64(r.RTT) / float64(time.Millisecond)
+	delta := ms - s.mean
+	s.mean += delta / float64(s.Received)
+	s.m2 += delta * (ms - s.mean)
+}
+
+// Loss returns the percentage of probes that got no reply.
+func (s *hopStats) Loss() float64 {
+	if s.Sent == 0 {
+		return 0
+	}
+	return 100 * float64(s.Sent-s.Received) / float64(s.Sent)
+}
+
+// Avg returns the average round-trip time.
+func (s *hopStats) Avg() time.Duration {
+	return time.Duration(s.mean * float64(time.Millisecond))
+}
+
+// StdDev returns the standard deviation of the round-trip time.
+func (s *hopStats) StdDev() time.Duration {
+	if s.Received < 2 {
+		return 0
+	}
+	return time.Duration(math.Sqrt(s.m2/float64(s.Received-1)) * float64(time.Millisecond))
+}
+
+// =============================================================================
+// THE CONTINUOUS LOOP
+// =============================================================================
+
+// runContinuous probes all hops in rounds until the user presses Ctrl+C.
+func runContinuous(prober *Prober, destination string, destAddr *net.IPAddr, opts traceOptions) {
+	// Ctrl+C sends SIGINT. Instead of dying on the spot, we catch it so we
+	// can print the final statistics.
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupted)
+
+	stats := make([]hopStats, opts.MaxHops+1) // stats[ttl]; below FirstTTL unused
+	names := opts.Names                       // Router names (nil with -n)
+
+	// Until the destination answers we don't know how many hops there are,
+	// so we probe all the way to MaxHops. Once it answers, we stop there.
+	lastTTL := opts.MaxHops
+	rounds := 0
+
+	for {
+		started := time.Now()
+
+		done := make(chan []probeResult, 1)
+		go func(lastTTL int) {
+			done <- probeRound(prober, opts.FirstTTL, lastTTL, opts.MaxInflight)
+		}(lastTTL)
+
+		select {
+		case results := <-done:
+			for _, r := range results {
+				stats[r.TTL].add(r)
+				if r.Reached && r.TTL < lastTTL {
+					lastTTL = r.TTL
+				}
+			}
+			rounds++
+
+		case <-interrupted:
+			printContinuous(destination, destAddr, stats, opts.FirstTTL, lastTTL, rounds, names, false)
+			return
+		}
+
+		printContinuous(destination, destAddr, stats, opts.FirstTTL, lastTTL, rounds, names, true)
+
+		// Wait until it's time for the next round (or the user gives up)
+		select {
+		case <-time.After(time.Until(started.Add(ContinuousInterval))):
+		case <-interrupted:
+			printContinuous(destination, destAddr, stats, opts.FirstTTL, lastTTL, rounds, names, false)
+			return
+		}
+	}
+}
+
+// probeRound sends one probe to every TTL from firstTTL to lastTTL, at most
+// maxInflight at a time, and returns all the results.
+func probeRound(prober *Prober, firstTTL, lastTTL, maxInflight int) []probeResult {
+	results := make([]probeResult, lastTTL-firstTTL+1)
+	probeEach(prober, firstTTL, lastTTL, maxInflight, func(r probeResult) {
+		results[r.TTL-firstTTL] = r
+	})
+	return results
+}
+
+// probeEach is probeRound for those who can't wait for the whole round:
+// onResult gets each probe's result as soon as it's done. It's called from
+// many goroutines at once, and probeEach returns when all probes are done.
+func probeEach(prober *Prober, firstTTL, lastTTL, maxInflight int, onResult func(probeResult)) {
+	sem := make(chan struct{}, maxInflight)
+	var wg sync.WaitGroup
+
+	for ttl := firstTTL; ttl <= lastTTL; ttl++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(ttl int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			onResult(prober.Probe(ttl))
+		}(ttl)
+	}
+
+	wg.Wait()
+}
+
+// =============================================================================
+// THE LIVE DISPLAY
+// =============================================================================
+
+// printContinuous draws the statistics table. With live=true it first
+// clears the terminal so the table updates "in place", and shows IP
+// addresses for routers whose names haven't arrived yet rather than waiting.
+func printContinuous(destination string, destAddr *net.IPAddr, stats []hopStats, firstTTL, lastTTL, rounds int, names *nameCache, live bool) {
+	if live {
+		// ANSI escape codes: "\033[H" moves the cursor to the top-left
+		// corner, "\033[2J" clears the screen.
+		fmt.Print("\033[H\033[2J")
+	} else {
+		fmt.Println()
+	}
+
+	fmt.Printf("🔁 Continuous trace to %s (%s) - %d rounds", destination, destAddr.IP, rounds)
+	if live {
+		fmt.Print(" - press Ctrl+C to stop")
+	}
+	fmt.Println()
+	fmt.Println()
+	fmt.Println("Hop  Host                                     Loss%   Snt   Last    Best    Avg     Wrst    StDev")
+	fmt.Println("───  ────                                     ─────   ───   ────    ────    ───     ────    ─────")
+
+	// Don't print a long tail of silent hops past the last one that answered
+	last := firstTTL
+	for ttl := firstTTL; ttl <= lastTTL; ttl++ {
+		if stats[ttl].Received > 0 {
+			last = ttl
+		}
+	}
+
+	for ttl := firstTTL; ttl <= last; ttl++ {
+		s := &stats[ttl]
+
+		host := "???"
+		if s.Responder != "" {
+			var name string
+			if live {
+				name, _ = names.Peek(s.Responder)
+			} else {
+				name = names.Wait(s.Responder)
+			}
+			host = s.Responder
+			if name != "" && name != NoHostname {
+				host = name
+			}
+		}
+		if len(host) > 40 {
+			host = host[:37] + "..."
+		}
+
+		fmt.Printf("%3d  %-40s %5.1f%% %5d  %-7s %-7s %-7s %-7s %-7s\n",
+			ttl, host, s.Loss(), s.Sent,
+			statRTT(s, s.Last), statRTT(s, s.Best), statRTT(s, s.Avg()),
+			statRTT(s, s.Worst), statRTT(s, s.StdDev()))
+	}
+}
+
+// statRTT formats an RTT column, or "-" if the hop never answered.
+func statRTT(s *hopStats, d time.Duration) string {
+	if s.Received == 0 {
+		return "-"
+	}
+	return formatRTT(d)
+}
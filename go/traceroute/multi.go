@@ -0,0 +1,216 @@
+// =============================================================================
+// MANY DESTINATIONS - Trace several places at the same time
+// =============================================================================
+//
+// Sometimes you want to know how you reach a whole list of places: every
+// data center, every DNS server, every customer site. Running traceroute
+// once per destination, one after another, takes forever.
+//
+// Instead we trace them all at the same time, sharing ONE socket. That works
+// because every probe gets its own Sequence number (see probe.go), so each
+// reply still finds its way back to the right trace.
+//
+// Printing is the tricky part: if every trace printed its hops as they
+// arrived, the screen would be a jumble of lines from different traces.
+// So each trace collects its hops quietly, and prints its whole table in
+// one go when it's done. At the very end we print a short summary with one
+// line per destination.
+//
+// =============================================================================
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultParallel is how many destinations we trace at the same time.
+const DefaultParallel = 8
+
+// multiResult is how one trace in a multi-destination run went.
+type multiResult struct {
+	Destination string
+	Addr        *net.IPAddr // nil if the DNS lookup failed
+	Err         error       // DNS error
+	Reached     bool
+	Loop        *routingLoop  // Why the trace stopped early, if it did
+	Silent      *silentRun    // Why the trace gave up early, if it did (-max-unknown)
+	Hops        int           // TTL of the destination (or the last hop that answered)
+	RTT         time.Duration // Fastest reply from the destination
+	Trace       []hopResult   // Every hop, for -json
+}
+
+// readTargets reads destinations from a file (or "-" for stdin): one per
+// line, ignoring blank lines and "#" comments.
+func readTargets(path string) ([]string, error) {
+	file := os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		file = f
+	}
+
+	var targets []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if fields := strings.Fields(line); len(fields) > 0 {
+			targets = append(targets, fields[0])
+		}
+	}
+	return targets, scanner.Err()
+}
+
+// runMulti traces every destination, up to parallel at a time, printing each
+// trace when it finishes and a summary at the end. It returns the results in
+// the order given, and false if any destination could not be looked up.
+func runMulti(engine *probeEngine, destinations []string, opts traceOptions, parallel int) ([]multiResult, bool) {
+	results := make([]multiResult, len(destinations))
+
+	var printMu sync.Mutex // One trace prints at a time
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, parallel)
+
+	for i, destination := range destinations {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, destination string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			var out strings.Builder
+			results[i] = traceOne(engine, destination, opts, &out)
+
+			printMu.Lock()
+			fmt.Print(out.String())
+			printMu.Unlock()
+		}(i, destination)
+	}
+	wg.Wait()
+
+	printSummary(results)
+
+	for _, r := range results {
+		if r.Err != nil {
+			return results, false
+		}
+	}
+	return results, true
+}
+
+// traceOne traces a single destination, writing its table to out.
+func traceOne(engine *probeEngine, destination string, opts traceOptions, out *strings.Builder) multiResult {
+	result := multiResult{Destination: destination}
+
+	addr, err := net.ResolveIPAddr("ip4", destination)
+	if err != nil {
+		result.Err = err
+		fmt.Fprintf(out, "❌ %s: could not find IP address: %v\n\n", destination, err)
+		return result
+	}
+
+	fmt.Fprintf(out, "🚀 Route to %s (%s)\n", destination, addr.IP)
+	fmt.Fprintln(out, hopHeader(opts))
+
+	result = traceAddr(engine.ForDestination(addr), destination, addr, opts, func(hop hopResult) {
+		fmt.Fprintln(out, formatHopResults(hop, opts.Names))
+	})
+
+	if result.Loop != nil {
+		fmt.Fprintln(out, formatLoop(result.Loop))
+	}
+	if result.Silent != nil {
+		fmt.Fprintln(out, formatSilent(result.Silent))
+	}
+	if result.Reached {
+		fmt.Fprintln(out, "🎉 Destination reached!")
+	} else {
+		fmt.Fprintln(out, "⚠️  Destination not reached")
+	}
+	fmt.Fprintln(out)
+	return result
+}
+
+// traceAddr traces an address that has already been looked up, calling
+// onHop for each hop as it's done, and sums the trace up.
+func traceAddr(prober *Prober, destination string, addr *net.IPAddr, opts traceOptions, onHop func(hopResult)) multiResult {
+	result := multiResult{Destination: destination, Addr: addr}
+
+	result.Reached, result.Loop, result.Silent = traceRoute(prober, opts, func(hop hopResult) {
+		onHop(hop)
+		result.Trace = append(result.Trace, hop)
+
+		for _, probe := range hop.Probes {
+			if probe.Responder != "" {
+				result.Hops = hop.TTL
+			}
+			if probe.Reached && (result.RTT == 0 || probe.RTT < result.RTT) {
+				result.RTT = probe.RTT
+			}
+		}
+	})
+	return result
+}
+
+// printSummary prints one line per destination, in the order they were given.
+func printSummary(results []multiResult) {
+	reached := 0
+	fmt.Println("════════════════════════════════════════════════════════════════")
+	fmt.Println("📋 SUMMARY")
+	fmt.Println()
+	fmt.Println("Destination                    IP Address         Hops  RTT        Status")
+	fmt.Println("───────────                    ──────────         ────  ───        ──────")
+
+	for _, r := range results {
+		name := r.Destination
+		if len(name) > 30 {
+			name = name[:27] + "..."
+		}
+
+		ip, hops, rtt, status := "-", "-", "-", ""
+		switch {
+		case r.Err != nil:
+			status = "❌ DNS lookup failed"
+		case r.Reached:
+			reached++
+			ip = r.Addr.IP.String()
+			hops = fmt.Sprintf("%d", r.Hops)
+			rtt = formatRTT(r.RTT)
+			status = "✅ reached"
+		case r.Loop != nil:
+			ip = r.Addr.IP.String()
+			hops = fmt.Sprintf("%d", r.Hops)
+			status = fmt.Sprintf("🔁 loop at hops %d-%d", r.Loop.FirstTTL, r.Loop.LastTTL)
+		case r.Silent != nil:
+			ip = r.Addr.IP.String()
+			if r.Hops > 0 {
+				hops = fmt.Sprintf("%d", r.Hops) // Last hop that answered
+			}
+			status = fmt.Sprintf("🔇 gave up at hop %d", r.Silent.LastTTL)
+		default:
+			ip = r.Addr.IP.String()
+			if r.Hops > 0 {
+				hops = fmt.Sprintf("%d", r.Hops) // Last hop that answered
+			}
+			status = "⚠️  not reached"
+		}
+
+		fmt.Printf("%-30s %-18s %-5s %-10s %s\n", name, ip, hops, rtt, status)
+	}
+
+	fmt.Println()
+	fmt.Printf("%d of %d destinations reached\n", reached, len(results))
+	fmt.Println("════════════════════════════════════════════════════════════════")
+}
@@ -0,0 +1,192 @@
+// =============================================================================
+// MULTIPATH MODE - Finding ALL the paths, not just one
+// =============================================================================
+//
+// Paris traceroute (see paris.go) keeps every probe on ONE path, so the
+// route we print is real. But which path? The load balancers pick one per
+// flow, and there may be many others we never see.
+//
+// With -paths we go looking for them on purpose. At every TTL we send
+// probes on lots of different flows (-flows of them). Flows the load
+// balancers send the same way find the same router; flows they split up
+// find different routers. Collect every router seen at every TTL, and
+// remember which router each flow came from one TTL earlier, and we have
+// a map of the whole network between us and the destination:
+//
+//   TTL 1:  A                       A
+//   TTL 2:  B, C          --->     / \
+//   TTL 3:  D (from B, C)         B   C
+//                                  \ /
+//                                   D
+//
+// This picture is called a "DAG" (directed acyclic graph): routers are
+// the boxes, and arrows only ever point one hop further away.
+//
+// The more flows we try, the more likely we are to see every path - but
+// the more probes we send. 16 flows finds all branches of a two- or
+// four-way split with very high probability.
+//
+// =============================================================================
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultFlows is how many different flows -paths tries at each TTL.
+const DefaultFlows = 16
+
+// pathNode is one router (or "*" for silence) seen at a TTL.
+type pathNode struct {
+	Addr  string
+	Flows int             // How many flows went through it
+	From  map[string]bool // Routers one TTL earlier that lead here
+}
+
+// runPaths probes every TTL on many flows and prints the routers seen at
+// each TTL with the routers that lead to them. It returns true if the
+// destination was reached on any flow.
+func runPaths(prober *Prober, opts traceOptions, firstFlow, numFlows int) bool {
+	// Every flow starts out active; a flow is done once it reaches the
+	// destination, since there is nothing beyond that to discover.
+	active := make([]int, numFlows)
+	for i := range active {
+		active[i] = firstFlow + i
+	}
+
+	prev := make(map[int]string) // Flow -> router at the previous TTL
+	widest, widestTTL := 0, 0    // Most routers seen at one TTL
+	reached := false
+
+	for ttl := opts.FirstTTL; ttl <= opts.MaxHops && len(active) > 0; ttl++ {
+		results := probeFlows(prober, ttl, active, opts.MaxInflight)
+
+		nodes := make(map[string]*pathNode)
+		var stillActive []int
+		for _, flow := range active {
+			r := results[flow]
+			addr := r.Responder
+			if addr == "" {
+				addr = "*"
+			}
+
+			node, ok := nodes[addr]
+			if !ok {
+				node = &pathNode{Addr: addr, From: make(map[string]bool)}
+				nodes[addr] = node
+			}
+			node.Flows++
+			if from, ok := prev[flow]; ok {
+				node.From[from] = true
+			}
+
+			prev[flow] = addr
+
+			if r.Reached {
+				reached = true
+			} else {
+				stillActive = append(stillActive, flow)
+			}
+		}
+		active = stillActive
+
+		// Silence isn't a router - a lost probe doesn't mean another path
+		width := len(nodes)
+		if nodes["*"] != nil {
+			width--
+		}
+		if width > widest {
+			widest, widestTTL = width, ttl
+		}
+
+		printPathHop(ttl, nodes, opts.Names)
+	}
+
+	fmt.Println()
+	if widest > 1 {
+		fmt.Printf("🔀 Traffic splits up to %d ways (at hop %d)\n", widest, widestTTL)
+	} else {
+		fmt.Printf("➡️  All %d flows took the same path\n", numFlows)
+	}
+
+	return reached
+}
+
+// probeFlows sends one probe per flow at ttl, at most maxInflight at a
+// time, and returns the results by flow.
+func probeFlows(prober *Prober, ttl int, flows []int, maxInflight int) map[int]probeResult {
+	results := make(map[int]probeResult, len(flows))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxInflight)
+
+	for _, flow := range flows {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(flow int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			r := prober.ProbeFlow(ttl, flow)
+
+			mu.Lock()
+			results[flow] = r
+			mu.Unlock()
+		}(flow)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// printPathHop prints every router seen at one TTL, busiest first, with
+// how many flows went through it and where they came from.
+func printPathHop(ttl int, nodes map[string]*pathNode, names *nameCache) {
+	sorted := make([]*pathNode, 0, len(nodes))
+	for _, n := range nodes {
+		sorted = append(sorted, n)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if (sorted[i].Addr == "*") != (sorted[j].Addr == "*") {
+			return sorted[j].Addr == "*" // Silence goes last
+		}
+		if sorted[i].Flows != sorted[j].Flows {
+			return sorted[i].Flows > sorted[j].Flows
+		}
+		return sorted[i].Addr < sorted[j].Addr
+	})
+
+	for i, n := range sorted {
+		label := "   "
+		if i == 0 {
+			label = fmt.Sprintf("%3d", ttl)
+		}
+
+		host := n.Addr
+		if n.Addr != "*" {
+			if name := names.Wait(n.Addr); name != "" && name != NoHostname {
+				host = fmt.Sprintf("%s (%s)", n.Addr, name)
+			}
+		}
+
+		line := fmt.Sprintf("%s   %-50s %-9s", label, host, fmt.Sprintf("%d flows", n.Flows))
+		if len(n.From) > 0 {
+			from := make([]string, 0, len(n.From))
+			for addr := range n.From {
+				from = append(from, addr)
+			}
+			sort.Strings(from)
+			line += "  ← " + strings.Join(from, ", ")
+		}
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+}
+
+// pathsHeader returns the column titles for multipath mode.
+func pathsHeader() string {
+	return fmt.Sprintf("Hop   %-50s %-9s  %s\n", "Router", "Flows", "Reached from") +
+		fmt.Sprintf("───   %-50s %-9s  %s", "──────", "─────", "────────────")
+}
@@ -0,0 +1,143 @@
+// =============================================================================
+// ROUTER NAMES - Reverse DNS without the waiting
+// =============================================================================
+//
+// Routers are much easier to recognize by name than by number:
+// "ae-1.r20.londen12.uk.bb.gin.ntt.net" tells you the network (NTT), the
+// city (London) and more - "129.250.3.45" tells you nothing.
+//
+// Finding a name for an IP address is called "reverse DNS". The catch: it
+// can be SLOW. Some DNS servers take seconds to answer, or never answer at
+// all. If we looked names up one at a time, just before printing each
+// row, one slow server would freeze the whole table.
+//
+// So instead:
+//   1. As soon as a router answers a probe, we start looking up its name
+//      in the background - while the other probes are still out.
+//   2. By the time its row is printed, the name is usually already there.
+//   3. Every lookup has a time limit, and each IP is only looked up once
+//      (the answer is kept in a small cache).
+//
+// With -n we skip names completely and just show numbers. That's faster,
+// and doesn't send any DNS queries at all.
+//
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNSTimeout is the longest we wait for a router's name.
+const DNSTimeout = 2 * time.Second
+
+// NoHostname is shown for routers without a name (or whose DNS server
+// didn't answer in time).
+const NoHostname = "(no hostname)"
+
+// nameCache looks up router names in the background and remembers them.
+// A nil *nameCache means "numbers only" (-n): it never looks anything up.
+type nameCache struct {
+	mu      sync.Mutex
+	lookups map[string]*nameLookup // IP -> lookup (finished or not)
+	timeout time.Duration
+	trim    []string // Endings cut off the names we show (-trim-suffix, see compact.go)
+}
+
+// nameLookup is one reverse DNS lookup. done is closed when name is set.
+type nameLookup struct {
+	done chan struct{}
+	name string
+}
+
+// newNameCache creates an empty cache whose lookups give up after timeout,
+// and which shows names without the endings in trim.
+func newNameCache(timeout time.Duration, trim []string) *nameCache {
+	return &nameCache{
+		lookups: make(map[string]*nameLookup),
+		timeout: timeout,
+		trim:    trim,
+	}
+}
+
+// Start begins looking up ip's name in the background, unless we already
+// have (or are getting) it. It never waits.
+func (c *nameCache) Start(ip string) *nameLookup {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if l, ok := c.lookups[ip]; ok {
+		return l
+	}
+
+	l := &nameLookup{done: make(chan struct{})}
+	c.lookups[ip] = l
+
+	go func() {
+		defer close(l.done)
+
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		defer cancel()
+
+		// LookupAddr returns a list of names (usually just one)
+		names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+		if err != nil || len(names) == 0 {
+			l.name = NoHostname
+			return
+		}
+		// Remove the trailing dot (DNS names often end with ".")
+		l.name = strings.TrimSuffix(names[0], ".")
+	}()
+
+	return l
+}
+
+// Wait returns ip's name, waiting for the lookup if it's still running.
+// Thanks to the lookup's time limit this never takes longer than the
+// timeout. It returns "" in numbers-only mode.
+func (c *nameCache) Wait(ip string) string {
+	return c.shorten(c.WaitFull(ip))
+}
+
+// WaitFull is Wait without cutting off the -trim-suffix endings, for output
+// that programs read (-json and -save).
+func (c *nameCache) WaitFull(ip string) string {
+	l := c.Start(ip)
+	if l == nil {
+		return ""
+	}
+	<-l.done
+	return l.name
+}
+
+// Peek returns ip's name if we already know it, and starts looking it up
+// if we don't. ok is false while the lookup is still running.
+func (c *nameCache) Peek(ip string) (name string, ok bool) {
+	l := c.Start(ip)
+	if l == nil {
+		return "", true
+	}
+	select {
+	case <-l.done:
+		return c.shorten(l.name), true
+	default:
+		return "", false
+	}
+}
+
+// shorten cuts the -trim-suffix endings off a name we are about to show.
+func (c *nameCache) shorten(name string) string {
+	if c == nil || name == NoHostname {
+		return name
+	}
+	return trimHostname(name, c.trim)
+}
@@ -0,0 +1,107 @@
+// =============================================================================
+// PACING - Not sending probes faster than routers are willing to answer
+// =============================================================================
+//
+// Answering our probes is extra work for a router: it has to build a brand
+// new ICMP "Time Exceeded" packet. Many routers protect themselves by only
+// doing that a few times per second ("ICMP rate limiting"). Send them ten
+// probes at once and they answer the first couple and ignore the rest.
+//
+// That's bad for us, because an ignored probe looks exactly like a lost one:
+// we print "*" (or a scary Loss%) for a router that is perfectly fine. With
+// up to -max-inflight probes in flight, fast paths are where this bites.
+//
+// So we can leave gaps between probes, in two ways:
+//
+//   -z SECONDS            at least this long between ANY two probes, like
+//                         the -z option of classic traceroute. Simple, but
+//                         it slows everything down.
+//
+//   -ttl-interval SECONDS at least this long between two probes with the
+//                         SAME TTL. Probes with the same TTL all land on the
+//                         same router, so this spaces out the work for each
+//                         router while other TTLs still go out in parallel.
+//
+// Both can be used together. When tracing several destinations at once the
+// gaps are shared by all of them: probes with the same TTL to different
+// destinations often hit the same nearby routers anyway.
+//
+// The timeout for a probe only starts once it is actually sent, so waiting
+// for our turn never counts as "no reply".
+//
+// =============================================================================
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// pacer hands out send times so probes are spread out as configured.
+type pacer struct {
+	interval    time.Duration // Least time between any two probes (-z)
+	ttlInterval time.Duration // Least time between probes with one TTL (-ttl-interval)
+
+	mu      sync.Mutex
+	booked  []time.Time       // Send times handed out that may still matter, in order
+	nextTTL map[int]time.Time // Earliest time the next probe with this TTL may go out
+}
+
+func newPacer(interval, ttlInterval time.Duration) *pacer {
+	return &pacer{
+		interval:    interval,
+		ttlInterval: ttlInterval,
+		nextTTL:     make(map[int]time.Time),
+	}
+}
+
+// wait blocks until a probe with the given TTL may be sent. It books the
+// slot before sleeping, so probes waiting at the same time each get their
+// own turn instead of all waking up together.
+func (p *pacer) wait(ttl int) {
+	if p.interval <= 0 && p.ttlInterval <= 0 {
+		return
+	}
+
+	p.mu.Lock()
+	now := time.Now()
+	at := now
+	if next := p.nextTTL[ttl]; next.After(at) {
+		at = next
+	}
+	if p.interval > 0 {
+		at = p.book(now, at)
+	}
+	p.nextTTL[ttl] = at.Add(p.ttlInterval)
+	p.mu.Unlock()
+
+	time.Sleep(time.Until(at))
+}
+
+// book finds the first time from "earliest" on that is at least -z away
+// from every other booked probe, and books it. A probe held back by its TTL
+// doesn't hold up the others: they can still use the gaps before it.
+func (p *pacer) book(now, earliest time.Time) time.Time {
+	// Forget probes long enough ago that they can't get in anyone's way
+	for len(p.booked) > 0 && !p.booked[0].Add(p.interval).After(now) {
+		p.booked = p.booked[1:]
+	}
+
+	at := earliest
+	i := 0
+	for ; i < len(p.booked); i++ {
+		b := p.booked[i]
+		if !b.Add(p.interval).After(at) {
+			continue // Far enough before us
+		}
+		if !at.Add(p.interval).After(b) {
+			break // We fit in the gap before this one
+		}
+		at = b.Add(p.interval)
+	}
+	p.booked = append(p.booked, time.Time{})
+	copy(p.booked[i+1:], p.booked[i:])
+	p.booked[i] = at
+	return at
+}
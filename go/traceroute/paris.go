@@ -0,0 +1,91 @@
+// =============================================================================
+// PARIS TRACEROUTE - Keeping every probe on the same path
+// =============================================================================
+//
+// Big networks often have several equal paths to the same place, and
+// "load balancers" spread traffic across them. To keep each conversation
+// (a "flow") on one path, they pick the path by hashing fields that stay
+// the same for a flow: addresses, protocol, and - for ICMP - the first
+// bytes of the ICMP header, which include the CHECKSUM.
+//
+// Classic traceroute changes the Sequence number in every probe, which
+// changes the checksum, which makes every probe a different "flow". So
+// hop 5 might be measured on path A and hop 6 on path B, and the route we
+// print is a mix of paths that doesn't really exist!
+//
+// Paris traceroute (named after the team that described the problem) fixes
+// this with a neat trick: we still change the Sequence number (so we can
+// match replies to probes), but we ALSO change two bytes of the payload to
+// cancel it out, so the checksum - and therefore the flow - never changes.
+//
+// Want to see a different path? Pick a different flow ID (-flow), and every
+// probe gets a different (but still constant) checksum.
+//
+// =============================================================================
+
+package main
+
+import (
+	"encoding/binary"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// parisPayload fills in the first two bytes of data so the ICMP Echo Request
+// with the given ID and Seq gets the same checksum as one with Seq=flowID
+// and an all-zero payload. Returns false if the payload is too small.
+//
+// The ICMP checksum is a "ones' complement" sum of the packet as 16-bit
+// words. Adding X to one word and subtracting X from another leaves the
+// sum - and the checksum - unchanged. That's the whole trick!
+//
+// The ICMPv6 checksum also covers the source and destination addresses,
+// but those are the same for every probe, so the trick still works.
+func parisPayload(data []byte, echoType icmp.Type, id, seq, flowID int) bool {
+	if len(data) < 2 {
+		return false
+	}
+	typeCode := echoTypeCode(echoType)
+
+	// What the sum SHOULD be: the header of a probe with Seq=flowID
+	target := onesSum(typeCode, uint32(id), uint32(flowID))
+
+	// What the sum is right now, with the compensation word set to zero
+	binary.BigEndian.PutUint16(data[0:2], 0)
+	current := onesSum(typeCode, uint32(id), uint32(seq))
+	for i := 0; i+1 < len(data); i += 2 {
+		current = onesSum(uint32(current), uint32(binary.BigEndian.Uint16(data[i:i+2])))
+	}
+	if len(data)%2 == 1 {
+		current = onesSum(uint32(current), uint32(data[len(data)-1])<<8)
+	}
+
+	// The compensation word is target - current (in ones' complement,
+	// subtracting is adding the bitwise NOT)
+	binary.BigEndian.PutUint16(data[0:2], onesSum(uint32(target), uint32(^current)))
+	return true
+}
+
+// echoTypeCode is the first 16-bit word of an ICMP Echo Request: Type 8
+// (128 for ICMPv6), Code 0.
+func echoTypeCode(echoType icmp.Type) uint32 {
+	if echoType == ipv6.ICMPTypeEchoRequest {
+		return uint32(ipv6.ICMPTypeEchoRequest) << 8
+	}
+	return uint32(ipv4.ICMPTypeEcho) << 8
+}
+
+// onesSum adds 16-bit values with "end-around carry" - any overflow out of
+// the top bit is added back in at the bottom, as the checksum requires.
+func onesSum(values ...uint32) uint16 {
+	var sum uint32
+	for _, v := range values {
+		sum += v & 0xffff
+	}
+	for sum > 0xffff {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return uint16(sum)
+}
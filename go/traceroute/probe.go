@@ -0,0 +1,535 @@
+// =============================================================================
+// PROBE ENGINE - Sending probes and matching replies back to them
+// =============================================================================
+//
+// The original version of this program sent one probe, waited for one reply,
+// then sent the next probe. Simple, but SLOW: every silent router costs us a
+// full timeout, one after another.
+//
+// Now many probes can be "in flight" at the same time. That creates a new
+// problem: when a reply arrives, which probe is it answering?
+//
+// The answer is in the packet itself! Every probe carries an Identifier
+// (our process ID) and a unique Sequence number. Replies carry them too:
+//
+//   - Echo Reply: the destination copies ID and Seq straight back to us.
+//   - Time Exceeded / Destination Unreachable: the router includes the
+//     first bytes of OUR original packet inside its error message, and
+//     those bytes contain our ID and Seq.
+//
+// A single "receiver" goroutine reads every packet from the socket, digs out
+// the ID/Seq, and hands the reply to whichever probe is waiting for it.
+// This is called "demultiplexing" - one stream in, many listeners out.
+//
+// A matching ID and Seq isn't quite enough: another program pinging from
+// this machine can use the same ID, and we trace many destinations over
+// one socket. So we also check the reply is about the right destination -
+// an Echo Reply must come FROM it, and an error must quote a packet that
+// was going TO it. Anything else is dropped, and the probe keeps waiting.
+//
+// Each probe's data also carries a random probe ID and its send time (see
+// stamp.go), so a reply for an older probe that reused the Seq is spotted,
+// and replies that come too late or twice are reported instead of ignored.
+//
+// =============================================================================
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// probeResult is what we learned from one probe.
+type probeResult struct {
+	TTL       int              // The TTL the probe was sent with
+	Responder string           // IP address of whoever answered ("" on timeout)
+	RTT       time.Duration    // Round-trip time
+	Reached   bool             // true if the destination itself answered
+	MPLS      []icmp.MPLSLabel // Labels the router reported, see mpls.go
+	Err       error            // Any error sending the probe
+}
+
+// hopResult collects the probes sent at one TTL (one row of output).
+type hopResult struct {
+	TTL    int
+	Probes []probeResult
+}
+
+// Sent returns how many probes were sent at this TTL.
+func (h hopResult) Sent() int {
+	return len(h.Probes)
+}
+
+// Received returns how many of those probes got a reply.
+func (h hopResult) Received() int {
+	received := 0
+	for _, p := range h.Probes {
+		if p.Responder != "" {
+			received++
+		}
+	}
+	return received
+}
+
+// Loss returns the percentage of probes that got no reply.
+func (h hopResult) Loss() float64 {
+	if h.Sent() == 0 {
+		return 0
+	}
+	return 100 * float64(h.Sent()-h.Received()) / float64(h.Sent())
+}
+
+// Stats returns the hop's round-trip time statistics (best, average, worst
+// and standard deviation - the same ones mtr.go keeps).
+func (h hopResult) Stats() hopStats {
+	var s hopStats
+	for _, p := range h.Probes {
+		s.add(p)
+	}
+	return s
+}
+
+// probeReply is what the receiver goroutine hands to a waiting probe.
+type probeReply struct {
+	peer     string
+	reached  bool
+	received time.Time
+	mpls     []icmp.MPLSLabel
+	stamp    *probeStamp // nil if the reply didn't carry our stamp, see stamp.go
+}
+
+// ProberConfig holds the settings that shape each probe.
+type ProberConfig struct {
+	Timeout    time.Duration // How long to wait for each reply
+	PacketSize int           // Bytes of data in each probe
+	Paris      bool          // Keep the flow (checksum) constant, see paris.go
+	FlowID     int           // Which flow to use when Paris is on
+	Adaptive   bool          // Shorten Timeout once replies arrive, see adaptive.go
+
+	SendInterval time.Duration // Least time between any two probes, see pacing.go
+	TTLInterval  time.Duration // Least time between probes with the same TTL
+}
+
+// Prober sends probes to one destination.
+//
+// Several Probers can share one socket (see ForDestination) - that's how we
+// trace many destinations at once. Sequence numbers are handed out by the
+// shared probeEngine, so a reply can never be matched to the wrong trace.
+type Prober struct {
+	*probeEngine
+	dest    net.Addr
+	slowest atomic.Int64 // Slowest round trip so far, see adaptive.go
+	strays  strayLog     // Duplicate and late replies, see stamp.go
+}
+
+// probeEngine owns the ICMP socket and keeps track of probes in flight.
+type probeEngine struct {
+	sock *probeSocket
+	cfg  ProberConfig
+
+	// TTL is a property of the SOCKET, not of each packet we write.
+	// "Set TTL, then send" must happen as one step, or two goroutines
+	// could interleave and send a packet with the wrong TTL.
+	sendMu sync.Mutex
+
+	// pace spaces probes out for routers that rate-limit their answers
+	pace *pacer
+
+	mu      sync.Mutex
+	seq     int                   // Last sequence number handed out
+	pending map[int]*pendingProbe // Probes waiting for a reply, by Seq
+	recent  map[int]*pendingProbe // Finished probes, by Seq, for spotting stray replies
+}
+
+// pendingProbe is a probe waiting for its reply.
+type pendingProbe struct {
+	replies  chan probeReply
+	dest     string    // Where the probe was sent
+	id       probeID   // Stamped into the probe, see stamp.go
+	ttl      int       // For reporting stray replies
+	owner    *Prober   // Who to report stray replies to
+	sent     time.Time // Zero until the probe is sent
+	answered bool      // Set once it's finished with a reply
+}
+
+// NewProber wraps an ICMP socket and starts the receiver goroutine.
+// The receiver stops when the socket is closed.
+func NewProber(sock *probeSocket, dest *net.IPAddr, cfg ProberConfig) *Prober {
+	return newProbeEngine(sock, cfg).ForDestination(dest)
+}
+
+// newProbeEngine starts the receiver goroutine for a socket.
+func newProbeEngine(sock *probeSocket, cfg ProberConfig) *probeEngine {
+	e := &probeEngine{
+		sock:    sock,
+		cfg:     cfg,
+		pace:    newPacer(cfg.SendInterval, cfg.TTLInterval),
+		pending: make(map[int]*pendingProbe),
+		recent:  make(map[int]*pendingProbe),
+	}
+	go e.receive()
+	return e
+}
+
+// ForDestination returns a Prober for dest that shares this socket.
+func (e *probeEngine) ForDestination(dest *net.IPAddr) *Prober {
+	return &Prober{probeEngine: e, dest: e.sock.destination(dest)}
+}
+
+// =============================================================================
+// SENDING
+// =============================================================================
+
+// Probe sends one ICMP Echo Request with the given TTL and waits for the
+// matching reply (or the timeout). It is safe to call from many goroutines.
+func (p *Prober) Probe(ttl int) probeResult {
+	return p.probe(ttl, p.cfg.Paris, p.cfg.FlowID)
+}
+
+// ProbeFlow is like Probe, but always uses Paris mode with the given flow,
+// whatever the config says. Multipath mode uses it to try many flows.
+func (p *Prober) ProbeFlow(ttl, flowID int) probeResult {
+	return p.probe(ttl, true, flowID)
+}
+
+func (p *Prober) probe(ttl int, paris bool, flowID int) probeResult {
+	result := probeResult{TTL: ttl}
+
+	// Reserve a sequence number and a mailbox for the reply BEFORE sending,
+	// so a very fast reply can't arrive before anyone is listening for it.
+	id := newProbeID()
+	seq, probe := p.register(p, ttl, id)
+	answered := false
+	defer func() { p.unregister(seq, answered) }()
+
+	data := make([]byte, p.cfg.PacketSize)
+	echoType := icmp.Type(ipv4.ICMPTypeEcho)
+	if p.sock.isIPv6() {
+		echoType = ipv6.ICMPTypeEchoRequest
+	}
+
+	// -------------------------------------------------------------------------
+	// Build our ICMP Echo Request packet (see RFC 792 for the layout):
+	//
+	//   Type=8 | Code=0 | Checksum | Identifier | Sequence | Data...
+	//
+	// The Identifier says "this is OURS" and the Sequence says "this is
+	// probe number N" - together they let us match replies to probes.
+	// ICMPv6 is the same, except that Echo Request is Type 128 and the
+	// operating system fills in the checksum for us.
+	// -------------------------------------------------------------------------
+	message := &icmp.Message{
+		Type: echoType, // Type 8 (or 128) = Echo Request
+		Code: 0,        // Always 0 for Echo Request
+		Body: &icmp.Echo{
+			ID:   p.sock.id,
+			Seq:  seq,
+			Data: data,
+		},
+	}
+
+	// -------------------------------------------------------------------------
+	// Wait for our turn (see pacing.go), then set the TTL and send -
+	// atomically, as explained on sendMu above.
+	// -------------------------------------------------------------------------
+	p.pace.wait(ttl)
+	p.sendMu.Lock()
+	if err := p.sock.setTTL(ttl); err != nil {
+		p.sendMu.Unlock()
+		result.Err = fmt.Errorf("couldn't set TTL to %d: %w", ttl, err)
+		return result
+	}
+
+	// Stamp the send time as late as possible (see stamp.go). In Paris
+	// mode, the payload then cancels out the changing Seq and stamp so the
+	// checksum (and the path load balancers pick) stays the same.
+	startTime := time.Now()
+	writeStamp(data, id, startTime)
+	if paris {
+		parisPayload(data, echoType, p.sock.id, seq, flowID)
+	}
+
+	// Marshal() converts the message to bytes and calculates the checksum
+	messageBytes, err := message.Marshal(nil)
+	if err != nil {
+		p.sendMu.Unlock()
+		result.Err = fmt.Errorf("couldn't build ICMP packet: %w", err)
+		return result
+	}
+	_, err = p.sock.writeTo(messageBytes, p.dest)
+	p.sendMu.Unlock()
+	p.markSent(probe, startTime)
+
+	if err != nil {
+		result.Err = fmt.Errorf("couldn't send packet: %w", err)
+		return result
+	}
+
+	// -------------------------------------------------------------------------
+	// Wait for the receiver goroutine to deliver our reply, or give up.
+	// -------------------------------------------------------------------------
+	// The adaptive timeout can change while we wait, so look at it again
+	// every so often instead of setting one timer up front.
+	for {
+		remaining := time.Until(startTime.Add(p.timeout()))
+		if remaining <= 0 {
+			// Timeout is normal! Some routers don't respond to ICMP.
+			// Leave Responder empty and the caller will print "*".
+			return result
+		}
+		if p.cfg.Adaptive {
+			remaining = min(remaining, adaptiveRecheck)
+		}
+
+		timer := time.NewTimer(remaining)
+		select {
+		case reply := <-probe.replies:
+			timer.Stop()
+			answered = true
+			result.Responder = reply.peer
+			result.RTT = reply.received.Sub(startTime)
+			if reply.stamp != nil {
+				result.RTT = reply.stamp.rtt(reply.received)
+			}
+			result.Reached = reply.reached
+			result.MPLS = reply.mpls
+			p.noteRTT(result.RTT)
+			return result
+		case <-timer.C:
+		}
+	}
+}
+
+// register hands out the next sequence number and creates its reply mailbox
+// for a probe from owner.
+func (p *probeEngine) register(owner *Prober, ttl int, id probeID) (int, *pendingProbe) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Sequence numbers are 16 bits, so wrap around - by the time we wrap,
+	// the probe that used the old number has long since finished.
+	p.seq = (p.seq + 1) & 0xffff
+	probe := &pendingProbe{
+		replies: make(chan probeReply, 1), // Buffered: the receiver never blocks
+		dest:    addrIP(owner.dest),
+		id:      id,
+		ttl:     ttl,
+		owner:   owner,
+	}
+	p.pending[p.seq] = probe
+	delete(p.recent, p.seq)
+	return p.seq, probe
+}
+
+// markSent records when a probe was sent.
+func (p *probeEngine) markSent(probe *pendingProbe, sent time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	probe.sent = sent
+}
+
+// unregister stops waiting for a probe once it has its answer (or gave up).
+// It is remembered until its Seq comes round again, so later replies to it
+// can be reported as strays.
+func (p *probeEngine) unregister(seq int, answered bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if probe, ok := p.pending[seq]; ok {
+		probe.answered = answered
+		p.recent[seq] = probe
+		delete(p.pending, seq)
+	}
+}
+
+// =============================================================================
+// RECEIVING
+// =============================================================================
+
+// receive reads every packet arriving on the socket and delivers replies to
+// the probes waiting for them. Packets that aren't for us are ignored.
+func (p *probeEngine) receive() {
+	// 1500 bytes is the maximum Ethernet frame size, plenty of room - unless
+	// we're sending big probes (-s), whose Echo Replies come back just as big
+	buffer := make([]byte, max(1500, ipv6.HeaderLen+8+p.cfg.PacketSize))
+
+	for {
+		reply, ok, err := p.sock.readReply(buffer)
+		if err != nil {
+			// The socket was closed (we're shutting down) - time to stop.
+			return
+		}
+
+		if !ok || reply.id != p.sock.id {
+			continue // Not one of our probes (maybe someone else is pinging)
+		}
+
+		p.mu.Lock()
+		probe, waiting := p.pending[reply.seq]
+		if !waiting {
+			probe = p.recent[reply.seq]
+		}
+		var sent time.Time
+		if probe != nil {
+			sent = probe.sent
+		}
+		p.mu.Unlock()
+
+		if probe == nil || (reply.target != "" && reply.target != probe.dest) {
+			continue // Right ID and Seq, wrong destination - not ours
+		}
+		if reply.stamp != nil && reply.stamp.id != probe.id {
+			continue // For an older probe that used the same Seq, see stamp.go
+		}
+
+		if waiting {
+			select {
+			case probe.replies <- probeReply{peer: reply.peer, reached: reply.reached, received: reply.received, mpls: reply.mpls, stamp: reply.stamp}:
+				continue
+			default:
+				// Already answered - keep the first reply
+			}
+		}
+
+		// A duplicate, or a reply that came too late to count
+		if sent.IsZero() || reply.received.Sub(sent) > strayMaxAge {
+			continue
+		}
+		stray := strayReply{TTL: probe.ttl, Responder: reply.peer, Kind: StrayDuplicate, RTT: reply.received.Sub(sent)}
+		if reply.stamp != nil {
+			stray.RTT = reply.stamp.rtt(reply.received)
+		}
+		if !waiting && !probe.answered {
+			stray.Kind = StrayLate
+		}
+		probe.owner.strays.add(stray)
+	}
+}
+
+// matchReply figures out which probe an ICMP message is answering.
+//
+// Returns the ID and Seq of the original probe, whether the reply came from
+// the final destination, and ok=false if the message isn't a reply at all.
+func matchReply(message *icmp.Message) (id, seq int, reached, ok bool) {
+	switch body := message.Body.(type) {
+
+	case *icmp.Echo:
+		// TYPE 0: Echo Reply - the destination answered our "hello"!
+		// (Our own outgoing Echo Requests can show up here too on some
+		// systems, so check the type.)
+		if message.Type != ipv4.ICMPTypeEchoReply && message.Type != ipv6.ICMPTypeEchoReply {
+			return 0, 0, false, false
+		}
+		return body.ID, body.Seq, true, true
+
+	case *icmp.TimeExceeded:
+		// TYPE 11 (ICMPv6: 3): Time Exceeded - a router's TTL counter hit 0.
+		// This is EXACTLY what we want for traceroute!
+		id, seq, ok := parseQuotedEcho(body.Data)
+		return id, seq, false, ok
+
+	case *icmp.DstUnreach:
+		// TYPE 3 (ICMPv6: 1): Destination Unreachable - something blocked our packet.
+		// We treat this as reaching a hop, but not the destination.
+		id, seq, ok := parseQuotedEcho(body.Data)
+		return id, seq, false, ok
+	}
+
+	return 0, 0, false, false
+}
+
+// replyTarget returns the address the answered probe was sent to: the sender
+// of an Echo Reply, or the destination in the IP header an ICMP error quotes.
+// It returns "" if the message doesn't say.
+func replyTarget(message *icmp.Message, peer string) string {
+	var data []byte
+	switch body := message.Body.(type) {
+	case *icmp.Echo:
+		return peer
+	case *icmp.TimeExceeded:
+		data = body.Data
+	case *icmp.DstUnreach:
+		data = body.Data
+	}
+
+	// Bytes 16-19 of the quoted IPv4 header are its destination address,
+	// bytes 24-39 of an IPv6 header
+	switch {
+	case len(data) >= ipv6.HeaderLen && data[0]>>4 == 6:
+		return net.IP(data[24:40]).String()
+	case len(data) >= ipv4.HeaderLen && data[0]>>4 == 4:
+		return net.IP(data[16:20]).String()
+	}
+	return ""
+}
+
+// parseQuotedEcho digs our original Echo Request out of an ICMP error.
+//
+// ICMP error messages quote the IP header of the packet that caused the
+// error, followed by (at least) the first 8 bytes of its payload:
+//
+//	+----------------------+--------------------------------------+
+//	| Original IP header   | Type | Code | Checksum | ID  | Seq   |
+//	| (20+ bytes)          |   our original ICMP Echo header      |
+//	+----------------------+--------------------------------------+
+//
+// An IPv6 header is always 40 bytes, with no length field to read.
+func parseQuotedEcho(data []byte) (id, seq int, ok bool) {
+	if len(data) > 0 && data[0]>>4 == 6 {
+		return parseQuotedEcho6(data)
+	}
+	if len(data) < ipv4.HeaderLen {
+		return 0, 0, false
+	}
+
+	// The low 4 bits of the first byte are the header length in 32-bit words
+	headerLen := int(data[0]&0x0f) * 4
+	if headerLen < ipv4.HeaderLen || len(data) < headerLen+8 {
+		return 0, 0, false
+	}
+
+	// Byte 9 of the IP header is the protocol - it must be ICMP
+	if data[9] != ProtocolICMP {
+		return 0, 0, false
+	}
+
+	quoted := data[headerLen:]
+	if quoted[0] != byte(ipv4.ICMPTypeEcho) {
+		return 0, 0, false
+	}
+
+	id = int(binary.BigEndian.Uint16(quoted[4:6]))
+	seq = int(binary.BigEndian.Uint16(quoted[6:8]))
+	return id, seq, true
+}
+
+// parseQuotedEcho6 is parseQuotedEcho for an ICMPv6 error quoting an IPv6
+// packet. Our probes never carry extension headers, so ICMPv6 must follow
+// the IPv6 header directly.
+func parseQuotedEcho6(data []byte) (id, seq int, ok bool) {
+	if len(data) < ipv6.HeaderLen+8 {
+		return 0, 0, false
+	}
+
+	// Byte 6 of the IPv6 header is the "next header" - it must be ICMPv6
+	if data[6] != ProtocolICMPv6 {
+		return 0, 0, false
+	}
+
+	quoted := data[ipv6.HeaderLen:]
+	if quoted[0] != byte(ipv6.ICMPTypeEchoRequest) {
+		return 0, 0, false
+	}
+
+	id = int(binary.BigEndian.Uint16(quoted[4:6]))
+	seq = int(binary.BigEndian.Uint16(quoted[6:8]))
+	return id, seq, true
+}
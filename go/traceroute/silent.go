@@ -0,0 +1,70 @@
+// =============================================================================
+// SILENT HOPS - Knowing when to give up
+// =============================================================================
+//
+// Some routers never answer traceroute - that's normal, and one or two
+// silent hops in the middle of a trace don't mean much:
+//
+//    5   12ms       11ms       12ms       0%     198.51.100.1       ...
+//    6   *          *          *          100%   *                  (no response)
+//    7   14ms       13ms       15ms       0%     198.51.100.9       ...
+//
+// But when a firewall drops everything past a certain point (or the
+// destination doesn't answer at all), EVERY hop after it is silent, and a
+// plain trace keeps going all the way to -m - 30 hops of stars, each one
+// costing a full timeout.
+//
+// With -max-unknown N we stop once N hops IN A ROW got no reply at all:
+//
+//    9   *          *          *          100%   *                  (no response)
+//   10   *          *          *          100%   *                  (no response)
+//   11   *          *          *          100%   *                  (no response)
+//   🔇 No replies from hop 9 to 11, giving up
+//
+// Pick N bigger than the longest stretch of silent routers you expect in
+// the middle of the path, or the trace will stop short of a destination
+// that would have answered.
+//
+// =============================================================================
+
+package main
+
+import "fmt"
+
+// silentRun is a stretch of hops in a row that nobody answered.
+type silentRun struct {
+	FirstTTL int
+	LastTTL  int
+}
+
+// formatSilent builds the line printed under the last hop of a silent run.
+func formatSilent(run *silentRun) string {
+	return fmt.Sprintf("🔇 No replies from hop %d to %d, giving up", run.FirstTTL, run.LastTTL)
+}
+
+// silentDetector watches hops go by, in TTL order, for too many silent
+// hops in a row.
+type silentDetector struct {
+	max      int // Give up after this many (0 = never)
+	firstTTL int // Where the current run started (0 = not in a run)
+}
+
+// add looks at the next hop and returns the silent run once it is max hops
+// long.
+func (d *silentDetector) add(hop hopResult) *silentRun {
+	if d.max <= 0 {
+		return nil
+	}
+	if hop.Received() > 0 {
+		d.firstTTL = 0
+		return nil
+	}
+
+	if d.firstTTL == 0 {
+		d.firstTTL = hop.TTL
+	}
+	if hop.TTL-d.firstTTL+1 < d.max {
+		return nil
+	}
+	return &silentRun{FirstTTL: d.firstTTL, LastTTL: hop.TTL}
+}
@@ -0,0 +1,218 @@
+// =============================================================================
+// SOCKETS - Raw when we're allowed, unprivileged "ping" sockets when we're not
+// =============================================================================
+//
+// The classic way to send hand-made ICMP packets is a RAW socket, and only
+// root (sudo) may open one of those.
+//
+// Many systems ALSO offer an unprivileged ICMP "datagram" socket - the same
+// kind the "ping" command uses so normal users can ping without sudo:
+//
+//   - Linux: allowed for users in the group range set by the sysctl
+//     net.ipv4.ping_group_range (many distributions allow everyone)
+//   - macOS: always allowed
+//
+// These sockets behave a little differently, so we keep track of which kind
+// we got:
+//
+//   - On Linux, the kernel replaces our ICMP Identifier with the socket's
+//     "port" number, and router errors (Time Exceeded!) are NOT delivered as
+//     normal packets. Instead they go to a special "error queue" that we
+//     have to read separately (see socket_linux.go).
+//   - On macOS, they work just like the raw socket for our purposes.
+//
+// =============================================================================
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// probeSocket is the socket we send probes on, plus what we know about it.
+type probeSocket struct {
+	conn     net.PacketConn
+	ipv4     *ipv4.PacketConn // Used to set the TTL (IPv4 sockets)
+	ipv6     *ipv6.PacketConn // Used to set the hop limit (IPv6 sockets)
+	datagram bool             // true for an unprivileged ICMP datagram socket
+	id       int              // The ICMP Identifier our probes will carry
+
+	// errQueue is set on Linux datagram sockets, where router errors must
+	// be read from the socket's error queue instead of as normal packets.
+	// raw gives us the file descriptor to do that with.
+	errQueue bool
+	raw      syscall.RawConn
+}
+
+// replyPacket is one reply read from the socket, already matched to the
+// ID and Seq of the probe it answers.
+type replyPacket struct {
+	id       int
+	seq      int
+	target   string // Where the probe was sent ("" if unknown), see probe.go
+	reached  bool
+	peer     string
+	received time.Time
+	mpls     []icmp.MPLSLabel // Only on raw sockets, see mpls.go
+	stamp    *probeStamp      // nil if the reply didn't carry our stamp, see stamp.go
+}
+
+// socketOptions says where our probes should leave from. On a machine with
+// several network cards ("multi-homed"), the routing table normally picks
+// one for us - these let the user pick instead.
+type socketOptions struct {
+	Source    net.IP // Source address to send from (nil = let the system pick)
+	Interface string // Network interface to send out of ("" = let routing pick)
+	IPv6      bool   // Send ICMPv6 probes instead of ICMP
+}
+
+// forFamily returns the options for tracing addr, switching to IPv6 when
+// addr is an IPv6 address.
+func (o socketOptions) forFamily(addr *net.IPAddr) socketOptions {
+	o.IPv6 = addr.IP.To4() == nil
+	return o
+}
+
+// listenAddress is the local address to bind to: the source address, or
+// "0.0.0.0" ("::" for IPv6) meaning "any address on this machine".
+func (o socketOptions) listenAddress() string {
+	switch {
+	case o.Source != nil:
+		return o.Source.String()
+	case o.IPv6:
+		return "::"
+	}
+	return "0.0.0.0"
+}
+
+// openSocket opens a raw ICMP socket, or falls back to an unprivileged
+// ICMP datagram socket if we aren't allowed to open a raw one.
+func openSocket(opts socketOptions) (*probeSocket, error) {
+	sock, rawErr := openRawSocket(opts)
+	if rawErr == nil {
+		return sock, nil
+	}
+
+	sock, err := openDatagramSocket(opts)
+	if err != nil {
+		return nil, fmt.Errorf("raw socket: %v; datagram socket: %v", rawErr, err)
+	}
+	return sock, nil
+}
+
+// interfaceAddress returns the first IPv4 (or IPv6) address of a network
+// interface.
+func interfaceAddress(name string, v6 bool) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && (ipnet.IP.To4() == nil) == v6 {
+			return ipnet.IP, nil
+		}
+	}
+	if v6 {
+		return nil, fmt.Errorf("interface %s has no IPv6 address", name)
+	}
+	return nil, fmt.Errorf("interface %s has no IPv4 address", name)
+}
+
+// isIPv6 reports whether the socket sends ICMPv6 probes.
+func (s *probeSocket) isIPv6() bool {
+	return s.ipv6 != nil
+}
+
+// protocol is the IP protocol number of the socket's ICMP flavour.
+func (s *probeSocket) protocol() int {
+	if s.isIPv6() {
+		return ProtocolICMPv6
+	}
+	return ProtocolICMP
+}
+
+// setTTL sets the TTL of the next probes. IPv6 calls it the "hop limit",
+// but it works exactly the same way.
+func (s *probeSocket) setTTL(ttl int) error {
+	if s.isIPv6() {
+		return s.ipv6.SetHopLimit(ttl)
+	}
+	return s.ipv4.SetTTL(ttl)
+}
+
+// Close closes the socket.
+func (s *probeSocket) Close() error {
+	return s.conn.Close()
+}
+
+// destination converts the address we're tracing to the form WriteTo
+// expects: datagram sockets want a *net.UDPAddr, raw sockets a *net.IPAddr.
+func (s *probeSocket) destination(dest *net.IPAddr) net.Addr {
+	if s.datagram {
+		return &net.UDPAddr{IP: dest.IP, Zone: dest.Zone}
+	}
+	return dest
+}
+
+// writeTo sends a probe.
+//
+// On Linux datagram sockets every ICMP error is ALSO reported once as a
+// "pending socket error", and the next send picks it up and fails - even
+// though the error belonged to an earlier probe. We already get those
+// errors from the error queue, so we just send again.
+func (s *probeSocket) writeTo(b []byte, dst net.Addr) (int, error) {
+	n, err := s.conn.WriteTo(b, dst)
+	if err != nil && s.errQueue && isPendingICMPError(err) {
+		n, err = s.conn.WriteTo(b, dst)
+	}
+	return n, err
+}
+
+// readReply reads packets until one of them looks like a reply to a probe.
+// ok is false for packets that aren't replies; err is only set once the
+// socket is closed.
+func (s *probeSocket) readReply(buffer []byte) (reply replyPacket, ok bool, err error) {
+	if s.errQueue {
+		return s.readErrQueueReply(buffer)
+	}
+
+	n, peer, err := s.conn.ReadFrom(buffer)
+	reply.received = time.Now()
+	if err != nil {
+		return reply, false, err
+	}
+
+	message, err := icmp.ParseMessage(s.protocol(), buffer[:n])
+	if err != nil {
+		return reply, false, nil // Garbage - ignore it
+	}
+
+	reply.id, reply.seq, reply.reached, ok = matchReply(message)
+	reply.peer = addrIP(peer)
+	reply.target = replyTarget(message, reply.peer)
+	reply.mpls = mplsLabels(message)
+	reply.stamp = replyStamp(message)
+	return reply, ok, nil
+}
+
+// addrIP returns just the IP address part of a network address.
+func addrIP(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.IPAddr:
+		return a.IP.String()
+	case *net.UDPAddr:
+		return a.IP.String()
+	}
+	return addr.String()
+}
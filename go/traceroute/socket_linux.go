@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Values from <linux/errqueue.h> and <linux/in6.h>
+const (
+	soEEOriginICMP  = 2  // SO_EE_ORIGIN_ICMP: the error came from an ICMP message
+	soEEOriginICMP6 = 3  // SO_EE_ORIGIN_ICMP6: ... from an ICMPv6 message
+	sizeofExtErr    = 16 // sizeof(struct sock_extended_err)
+	ipv6RecvErr     = 25 // IPV6_RECVERR, missing from package syscall
+)
+
+// openRawSocket opens a raw ICMP socket. On Linux the socket can be tied to
+// one network interface with SO_BINDTODEVICE, so every probe leaves through
+// it no matter what the routing table says.
+func openRawSocket(opts socketOptions) (*probeSocket, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			if opts.Interface == "" {
+				return nil
+			}
+			var err error
+			if cerr := c.Control(func(fd uintptr) {
+				err = bindToDevice(int(fd), opts.Interface)
+			}); cerr != nil {
+				return cerr
+			}
+			return err
+		},
+	}
+
+	network := "ip4:icmp"
+	if opts.IPv6 {
+		network = "ip6:ipv6-icmp"
+	}
+	conn, err := lc.ListenPacket(context.Background(), network, opts.listenAddress())
+	if err != nil {
+		return nil, err
+	}
+	sock := &probeSocket{conn: conn, id: os.Getpid() & 0xffff}
+	if opts.IPv6 {
+		sock.ipv6 = ipv6.NewPacketConn(conn)
+	} else {
+		sock.ipv4 = ipv4.NewPacketConn(conn)
+	}
+	return sock, nil
+}
+
+// bindToDevice ties a socket to one network interface (SO_BINDTODEVICE).
+func bindToDevice(fd int, iface string) error {
+	if err := syscall.SetsockoptString(fd, syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface); err != nil {
+		return os.NewSyscallError("setsockopt SO_BINDTODEVICE", err)
+	}
+	return nil
+}
+
+// openDatagramSocket opens an unprivileged ICMP datagram socket with
+// IP_RECVERR (IPV6_RECVERR) turned on, so router errors land in the
+// socket's error queue.
+//
+// We build the socket by hand (instead of icmp.ListenPacket) because we
+// need the file descriptor for reading the error queue later.
+func openDatagramSocket(opts socketOptions) (*probeSocket, error) {
+	family, proto, level, recvErr := syscall.AF_INET, syscall.IPPROTO_ICMP, syscall.IPPROTO_IP, syscall.IP_RECVERR
+	var local syscall.Sockaddr = &syscall.SockaddrInet4{}
+	if opts.IPv6 {
+		family, proto, level, recvErr = syscall.AF_INET6, syscall.IPPROTO_ICMPV6, syscall.IPPROTO_IPV6, ipv6RecvErr
+		local = &syscall.SockaddrInet6{}
+	}
+
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := syscall.SetsockoptInt(fd, level, recvErr, 1); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	if opts.Interface != "" {
+		if err := bindToDevice(fd, opts.Interface); err != nil {
+			syscall.Close(fd)
+			return nil, err
+		}
+	}
+	if opts.Source != nil {
+		switch sa := local.(type) {
+		case *syscall.SockaddrInet4:
+			copy(sa.Addr[:], opts.Source.To4())
+		case *syscall.SockaddrInet6:
+			copy(sa.Addr[:], opts.Source.To16())
+		}
+	}
+	if err := syscall.Bind(fd, local); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+
+	f := os.NewFile(uintptr(fd), "icmp datagram socket")
+	conn, err := net.FilePacketConn(f)
+	f.Close() // FilePacketConn made its own copy of the descriptor
+	if err != nil {
+		return nil, err
+	}
+
+	udp, ok := conn.(*net.UDPConn)
+	if !ok {
+		conn.Close()
+		return nil, errors.New("unexpected socket type")
+	}
+	raw, err := udp.SyscallConn()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// The kernel uses the socket's "port" as the ICMP Identifier
+	sock := &probeSocket{
+		conn:     conn,
+		datagram: true,
+		id:       udp.LocalAddr().(*net.UDPAddr).Port,
+		errQueue: true,
+		raw:      raw,
+	}
+	if opts.IPv6 {
+		sock.ipv6 = ipv6.NewPacketConn(conn)
+	} else {
+		sock.ipv4 = ipv4.NewPacketConn(conn)
+	}
+	return sock, nil
+}
+
+// readErrQueueReply reads the next reply from a Linux ICMP datagram socket.
+//
+// Echo Replies from the destination arrive as normal packets. Time Exceeded
+// and Destination Unreachable from routers arrive on the error queue: the
+// data is OUR original Echo Request (so we can read its Seq), and a control
+// message tells us the ICMP type and who sent it.
+func (s *probeSocket) readErrQueueReply(buffer []byte) (reply replyPacket, ok bool, err error) {
+	oob := make([]byte, 512)
+
+	readErr := s.raw.Read(func(fd uintptr) bool {
+		// 1. Router errors, from the error queue. The address is where our
+		//    original probe was going.
+		n, oobn, _, to, err := syscall.Recvmsg(int(fd), buffer, oob, syscall.MSG_ERRQUEUE|syscall.MSG_DONTWAIT)
+		if err == nil {
+			reply.received = time.Now()
+			reply, ok = parseErrQueue(buffer[:n], oob[:oobn], reply)
+			reply.target = sockaddrIP(to)
+			return true
+		}
+
+		// 2. Echo Replies, as normal packets
+		n, from, err := syscall.Recvfrom(int(fd), buffer, syscall.MSG_DONTWAIT)
+		if err == syscall.EAGAIN {
+			return false // Nothing yet - wait until the socket is readable
+		}
+		reply.received = time.Now()
+		if err != nil {
+			// The kernel also reports each ICMP error once as a socket
+			// error. We already got it from the error queue, so ignore it.
+			return true
+		}
+
+		message, err := icmp.ParseMessage(s.protocol(), buffer[:n])
+		if err != nil {
+			return true
+		}
+		reply.id, reply.seq, reply.reached, ok = matchReply(message)
+		reply.peer = sockaddrIP(from)
+		reply.target = replyTarget(message, reply.peer)
+		reply.stamp = replyStamp(message)
+		return true
+	})
+
+	return reply, ok, readErr
+}
+
+// sockaddrIP returns the IP address of a socket address ("" if it has none).
+func sockaddrIP(sa syscall.Sockaddr) string {
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return net.IP(sa.Addr[:]).String()
+	case *syscall.SockaddrInet6:
+		return net.IP(sa.Addr[:]).String()
+	}
+	return ""
+}
+
+// isPendingICMPError reports whether a send failed only because of an ICMP
+// error the kernel had stored for an earlier probe.
+func isPendingICMPError(err error) bool {
+	return errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPROTO)
+}
+
+// parseErrQueue decodes one error queue entry into a reply.
+func parseErrQueue(data, oob []byte, reply replyPacket) (replyPacket, bool) {
+	// The data is our original ICMP Echo Request header
+	if len(data) < 8 || (data[0] != byte(ipv4.ICMPTypeEcho) && data[0] != byte(ipv6.ICMPTypeEchoRequest)) {
+		return reply, false
+	}
+	reply.id = int(binary.BigEndian.Uint16(data[4:6]))
+	reply.seq = int(binary.BigEndian.Uint16(data[6:8]))
+	reply.stamp = readStamp(data[8:])
+
+	messages, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return reply, false
+	}
+
+	for _, m := range messages {
+		ext := m.Data
+		switch {
+		case m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == syscall.IP_RECVERR:
+			// struct sock_extended_err, followed by the sender's sockaddr_in:
+			//   errno(4) origin(1) type(1) code(1) pad(1) info(4) data(4) | family(2) port(2) addr(4)
+			if len(ext) < sizeofExtErr+8 || ext[4] != soEEOriginICMP {
+				return reply, false
+			}
+			switch ext[5] {
+			case byte(ipv4.ICMPTypeTimeExceeded), byte(ipv4.ICMPTypeDestinationUnreachable):
+			default:
+				return reply, false
+			}
+			reply.peer = net.IP(ext[sizeofExtErr+4 : sizeofExtErr+8]).String()
+			return reply, true
+
+		case m.Header.Level == syscall.IPPROTO_IPV6 && m.Header.Type == ipv6RecvErr:
+			// The same, followed by a sockaddr_in6:
+			//   ... | family(2) port(2) flowinfo(4) addr(16) scope(4)
+			if len(ext) < sizeofExtErr+24 || ext[4] != soEEOriginICMP6 {
+				return reply, false
+			}
+			switch ext[5] {
+			case byte(ipv6.ICMPTypeTimeExceeded), byte(ipv6.ICMPTypeDestinationUnreachable):
+			default:
+				return reply, false
+			}
+			reply.peer = net.IP(ext[sizeofExtErr+8 : sizeofExtErr+24]).String()
+			return reply, true
+		}
+	}
+
+	return reply, false
+}
@@ -0,0 +1,76 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/net/icmp"
+)
+
+// openRawSocket opens a raw ICMP socket.
+func openRawSocket(opts socketOptions) (*probeSocket, error) {
+	network := "ip4:icmp"
+	if opts.IPv6 {
+		network = "ip6:ipv6-icmp"
+	}
+	return listenICMP(network, opts, false)
+}
+
+// openDatagramSocket opens an unprivileged ICMP datagram socket.
+// Outside Linux the kernel keeps our Identifier and delivers router errors
+// as normal packets, so nothing special is needed.
+func openDatagramSocket(opts socketOptions) (*probeSocket, error) {
+	network := "udp4"
+	if opts.IPv6 {
+		network = "udp6"
+	}
+	return listenICMP(network, opts, true)
+}
+
+// listenICMP opens either kind of socket with icmp.ListenPacket.
+func listenICMP(network string, opts socketOptions, datagram bool) (*probeSocket, error) {
+	address, err := otherListenAddress(opts)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := icmp.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	sock := &probeSocket{
+		conn:     conn,
+		datagram: datagram,
+		id:       os.Getpid() & 0xffff,
+	}
+	if opts.IPv6 {
+		sock.ipv6 = conn.IPv6PacketConn()
+	} else {
+		sock.ipv4 = conn.IPv4PacketConn()
+	}
+	return sock, nil
+}
+
+// otherListenAddress picks the address to bind to. Without SO_BINDTODEVICE
+// we choose an interface by sending from one of its addresses instead.
+func otherListenAddress(opts socketOptions) (string, error) {
+	if opts.Interface != "" && opts.Source == nil {
+		ip, err := interfaceAddress(opts.Interface, opts.IPv6)
+		if err != nil {
+			return "", err
+		}
+		opts.Source = ip
+	}
+	return opts.listenAddress(), nil
+}
+
+// readErrQueueReply is only used on Linux.
+func (s *probeSocket) readErrQueueReply(buffer []byte) (replyPacket, bool, error) {
+	return replyPacket{}, false, errors.New("socket error queue not supported")
+}
+
+// isPendingICMPError is only needed on Linux.
+func isPendingICMPError(err error) bool {
+	return false
+}
@@ -0,0 +1,228 @@
+// =============================================================================
+// STAMPS - Writing the send time and a name on every probe
+// =============================================================================
+//
+// Matching a reply to its probe by Sequence number (see probe.go) works
+// well, with two blind spots:
+//
+//   - A reply that shows up AFTER its probe gave up ("late") is simply
+//     thrown away, and we never learn that the router did answer - just
+//     slowly. That's worth knowing: it means -w is too short!
+//   - Some networks DUPLICATE packets, so one probe gets two replies. We
+//     used the first one and silently ignored the rest.
+//
+// And Sequence numbers are only 16 bits, so in a long -continuous run they
+// get reused: a very late reply to probe 3 could be mistaken for a reply to
+// the NEW probe 65539, which also has Seq 3.
+//
+// So we write two things into the data of every probe:
+//
+//   +--------+-------+-----------------------+------------------+
+//   | Paris  | "TRc1"| Probe ID (16 random   | Send time (8     |
+//   | word   |       | bytes, like a UUID)   | bytes)           |
+//   +--------+-------+-----------------------+------------------+
+//   0        2       6                       22                 30
+//
+// The destination copies the data back in its Echo Reply, and most routers
+// quote it in their Time Exceeded messages (the rules ask for at least the
+// first 8 bytes of our ICMP header, and most send much more). So a reply
+// now says exactly WHICH probe it's for and WHEN that probe left:
+//
+//   - The round-trip time comes straight from the stamped send time.
+//   - A reply with the right Seq but the wrong probe ID belongs to an
+//     older probe - it's never counted for the new one.
+//   - Replies for probes that already got their answer are "duplicate",
+//     replies for probes that already gave up are "late". Both are listed
+//     after the trace instead of vanishing.
+//
+// The send time counts from when the program started, on the computer's
+// "monotonic" clock - the one that only ever ticks forward, even if
+// someone changes the time of day in the middle of our trace.
+//
+// Probes smaller than 30 bytes (-s) have no room for a stamp. Then, and for
+// routers that quote too little, we fall back to remembering the send time
+// and matching by Seq alone.
+//
+// =============================================================================
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// stampMagic marks a payload as carrying one of our stamps.
+	stampMagic = "TRc1"
+
+	// stampOffset leaves the first two bytes of data to Paris mode.
+	stampOffset = 2
+
+	// StampSize is the smallest probe (-s) with room for a stamp.
+	StampSize = stampOffset + len(stampMagic) + 16 + 8
+
+	// strayMaxAge is how long after sending we still report stray replies
+	// to a probe. Anything slower is more likely a confused network than a
+	// reply worth mentioning.
+	strayMaxAge = time.Minute
+)
+
+// Stray reply kinds.
+const (
+	StrayDuplicate = "duplicate" // A second reply to an answered probe
+	StrayLate      = "late"      // A reply to a probe that had given up
+)
+
+// clockStart is what stamped send times count from.
+var clockStart = time.Now()
+
+// probeID tells one probe apart from every other, even ones that reused
+// its Sequence number.
+type probeID [16]byte
+
+// newProbeID returns a random probe ID, laid out like a version 4 UUID.
+func newProbeID() probeID {
+	var id probeID
+	rand.Read(id[:])
+	id[6] = id[6]&0x0f | 0x40 // Version 4
+	id[8] = id[8]&0x3f | 0x80 // Variant 10
+	return id
+}
+
+// probeStamp is what a reply told us about the probe it answers.
+type probeStamp struct {
+	id   probeID
+	sent time.Duration // Since clockStart
+}
+
+// rtt is the round-trip time of a reply received at received.
+func (s probeStamp) rtt(received time.Time) time.Duration {
+	return received.Sub(clockStart) - s.sent
+}
+
+// writeStamp stamps a probe's data with its ID and send time. It returns
+// false (and leaves data alone) if the probe is too small.
+func writeStamp(data []byte, id probeID, sent time.Time) bool {
+	if len(data) < StampSize {
+		return false
+	}
+	b := data[stampOffset:]
+	copy(b, stampMagic)
+	copy(b[len(stampMagic):], id[:])
+	binary.BigEndian.PutUint64(b[len(stampMagic)+16:], uint64(sent.Sub(clockStart)))
+	return true
+}
+
+// readStamp reads the stamp from the data of one of our probes, if it has
+// one (and the reply quoted enough of it).
+func readStamp(data []byte) *probeStamp {
+	if len(data) < StampSize || string(data[stampOffset:stampOffset+len(stampMagic)]) != stampMagic {
+		return nil
+	}
+	b := data[stampOffset+len(stampMagic):]
+	s := &probeStamp{sent: time.Duration(binary.BigEndian.Uint64(b[16:24]))}
+	copy(s.id[:], b[:16])
+	return s
+}
+
+// replyStamp finds the stamp in a reply: in the echoed data of an Echo
+// Reply, or after the IP and ICMP headers an ICMP error quotes.
+func replyStamp(message *icmp.Message) *probeStamp {
+	var quoted []byte
+	switch body := message.Body.(type) {
+	case *icmp.Echo:
+		return readStamp(body.Data)
+	case *icmp.TimeExceeded:
+		quoted = body.Data
+	case *icmp.DstUnreach:
+		quoted = body.Data
+	}
+
+	headerLen := 0
+	switch {
+	case len(quoted) >= ipv6.HeaderLen && quoted[0]>>4 == 6:
+		headerLen = ipv6.HeaderLen
+	case len(quoted) >= ipv4.HeaderLen && quoted[0]>>4 == 4:
+		headerLen = int(quoted[0]&0x0f) * 4
+	default:
+		return nil
+	}
+	if len(quoted) < headerLen+8 {
+		return nil
+	}
+	return readStamp(quoted[headerLen+8:])
+}
+
+// =============================================================================
+// STRAY REPLIES
+// =============================================================================
+
+// strayReply is a reply that arrived but didn't count: a duplicate, or one
+// that came too late.
+type strayReply struct {
+	TTL       int
+	Responder string
+	Kind      string        // StrayDuplicate or StrayLate
+	RTT       time.Duration // From the stamp, or the remembered send time
+}
+
+// strayLog collects a Prober's stray replies as they arrive.
+type strayLog struct {
+	mu      sync.Mutex
+	replies []strayReply
+}
+
+func (l *strayLog) add(r strayReply) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.replies = append(l.replies, r)
+}
+
+// Strays returns the stray replies seen so far, by TTL.
+func (p *Prober) Strays() []strayReply {
+	p.strays.mu.Lock()
+	defer p.strays.mu.Unlock()
+
+	strays := append([]strayReply(nil), p.strays.replies...)
+	sort.SliceStable(strays, func(i, j int) bool { return strays[i].TTL < strays[j].TTL })
+	return strays
+}
+
+// printStrays lists the stray replies under the trace.
+func printStrays(strays []strayReply, names *nameCache) {
+	if len(strays) == 0 {
+		return
+	}
+	fmt.Println()
+	fmt.Printf("👻 %d stray %s, not counted above:\n", len(strays), plural(len(strays), "reply", "replies"))
+	for _, s := range strays {
+		host := s.Responder
+		if name := names.Wait(s.Responder); name != "" && name != NoHostname {
+			host = fmt.Sprintf("%s (%s)", name, s.Responder)
+		}
+		fmt.Printf("   %3d  %-9s %-8s %s\n", s.TTL, s.Kind, formatRTT(s.RTT), host)
+	}
+	for _, s := range strays {
+		if s.Kind == StrayLate {
+			fmt.Println("   Late replies mean those routers answer slower than -w allows.")
+			break
+		}
+	}
+}
+
+// plural picks the singular or plural form of a word for n.
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
@@ -0,0 +1,9 @@
+package main
+
+import "syscall"
+
+// The ioctl requests that read and change the terminal's settings.
+const (
+	ioctlGetTermios = syscall.TIOCGETA
+	ioctlSetTermios = syscall.TIOCSETA
+)
@@ -0,0 +1,24 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// errNoTerminal is why -tui doesn't work here: we only know how to drive
+// Linux and macOS terminals.
+var errNoTerminal = errors.New("-tui only works on Linux and macOS terminals")
+
+type terminalState struct{}
+
+func makeRaw(f *os.File) (*terminalState, error) {
+	return nil, errNoTerminal
+}
+
+func restoreTerminal(f *os.File, state *terminalState) {}
+
+func terminalSize(f *os.File) (cols, rows int, err error) {
+	return 0, 0, errNoTerminal
+}
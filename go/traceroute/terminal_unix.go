@@ -0,0 +1,56 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// terminalState is how the terminal was set up before we changed it.
+type terminalState struct {
+	termios syscall.Termios
+}
+
+// makeRaw switches the terminal to "raw" mode: every key press reaches us
+// straight away, instead of a whole line once Enter is pressed, and isn't
+// echoed to the screen. Ctrl+C still stops the program.
+func makeRaw(f *os.File) (*terminalState, error) {
+	var old terminalState
+	if err := termiosIoctl(f, ioctlGetTermios, &old.termios); err != nil {
+		return nil, err
+	}
+
+	raw := old.termios
+	raw.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.IEXTEN
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := termiosIoctl(f, ioctlSetTermios, &raw); err != nil {
+		return nil, err
+	}
+	return &old, nil
+}
+
+// restoreTerminal puts the terminal back the way makeRaw found it.
+func restoreTerminal(f *os.File, state *terminalState) {
+	termiosIoctl(f, ioctlSetTermios, &state.termios)
+}
+
+// terminalSize returns the number of columns and rows of the terminal.
+func terminalSize(f *os.File) (cols, rows int, err error) {
+	var size struct{ Row, Col, X, Y uint16 }
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&size)))
+	if errno != 0 {
+		return 0, 0, errno
+	}
+	return int(size.Col), int(size.Row), nil
+}
+
+func termiosIoctl(f *os.File, request uintptr, t *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), request, uintptr(unsafe.Pointer(t)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
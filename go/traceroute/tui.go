@@ -0,0 +1,410 @@
+// =============================================================================
+// TUI - A live, full-screen table of the trace
+// =============================================================================
+//
+// The normal output prints each hop once it's completely done, and
+// continuous mode (-continuous) redraws the whole table once per round.
+// With -tui we take over the terminal like "top" or "mtr" do, and update
+// the table the moment any probe comes back:
+//
+//   🔍 10.202.0.2 (10.202.0.2) - round 12 - q to quit
+//
+//   Hop  Host         Loss%   Snt   Last    Best    Avg     Wrst    RTT history
+//   ───  ────         ─────   ───   ────    ────    ───     ────    ───────────
+//     1  router.home   0.0%    12  0.52ms  0.40ms  0.61ms  1ms     ▂▁▂▁▁▃▁▂▁▁█▁
+//     2  isp-gw.net   16.7%    12  9ms     7ms     8ms     12ms    ▅▄×▅▄▆▄×▄▅▄▄
+//
+// The "RTT history" (only with -continuous) is a sparkline: one little bar
+// per probe, newest on the right, taller for slower replies. "×" is a
+// probe that got no answer. A glance shows whether a hop is always slow,
+// or slow now and then.
+//
+// Long paths don't fit on a small screen, so the table scrolls:
+//
+//   ↑ ↓ (or k j)      one hop up or down
+//   PgUp PgDn         a screen at a time
+//   Home End (g G)    top or bottom
+//   q (or Ctrl+C)     quit
+//
+// Without -continuous we send -q probes to each hop and stop, leaving the
+// table on screen until you quit. Either way, the final table is printed
+// again after quitting, so it stays in your terminal's scrollback.
+//
+// HOW DO YOU TAKE OVER A TERMINAL?
+//   Terminals understand "escape codes": special text starting with the
+//   ESC character that moves the cursor, clears lines and so on. We switch
+//   to the terminal's "alternate screen" (like a fresh sheet of paper that
+//   disappears again when we're done) and redraw the table from the top
+//   ten times a second. And we put the keyboard in "raw" mode, so each key
+//   press reaches us straight away (see terminal_unix.go).
+//
+// =============================================================================
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// tuiRefresh is how often the screen is redrawn.
+	tuiRefresh = 100 * time.Millisecond
+
+	// tuiHistory is how many RTTs each hop remembers for its sparkline.
+	tuiHistory = 500
+
+	// tuiLost stands for a lost probe in a hop's history.
+	tuiLost = time.Duration(-1)
+
+	// tuiFixedWidth is how wide the table is without the host and the
+	// sparkline.
+	tuiFixedWidth = 52
+)
+
+// sparkBlocks are the bars of a sparkline, from fast to slow.
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// tuiHop is everything we show about one hop.
+type tuiHop struct {
+	stats   hopStats
+	history []time.Duration // Newest last; tuiLost for lost probes
+}
+
+// tuiState is the table, shared by the probes (which fill it in) and the
+// screen (which shows it).
+type tuiState struct {
+	destination string
+	addr        *net.IPAddr
+	opts        traceOptions
+	continuous  bool
+
+	mu      sync.Mutex
+	hops    []tuiHop // hops[ttl]; below FirstTTL unused
+	lastTTL int      // Highest TTL worth probing, see mtr.go
+	rounds  int
+	done    bool
+	scroll  int // First hop shown, counting from the top of the table
+	visible int // How many hops fit on the screen last time we drew it
+}
+
+// runTUI shows the trace in a live full-screen table until the user quits.
+func runTUI(prober *Prober, destination string, destAddr *net.IPAddr, opts traceOptions, continuous bool) {
+	saved, err := makeRaw(os.Stdin)
+	if err != nil {
+		fmt.Printf("❌ ERROR: -tui needs an interactive terminal: %v\n", err)
+		os.Exit(1)
+	}
+
+	t := &tuiState{
+		destination: destination,
+		addr:        destAddr,
+		opts:        opts,
+		continuous:  continuous,
+		hops:        make([]tuiHop, opts.MaxHops+1),
+		lastTTL:     opts.MaxHops,
+	}
+
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupted)
+
+	keys := make(chan string, 16)
+	go readKeys(os.Stdin, keys)
+
+	stop := make(chan struct{})
+	go t.probe(prober, stop)
+
+	// "\033[?1049h" switches to the alternate screen, "\033[?25l" hides
+	// the cursor
+	fmt.Print("\033[?1049h\033[?25l")
+
+	ticker := time.NewTicker(tuiRefresh)
+	defer ticker.Stop()
+
+loop:
+	for {
+		t.draw()
+		select {
+		case <-ticker.C:
+		case key := <-keys:
+			if key == "quit" {
+				break loop
+			}
+			t.key(key)
+		case <-interrupted:
+			break loop
+		}
+	}
+	close(stop)
+
+	// Put the cursor, the screen and the keyboard back as they were
+	fmt.Print("\033[?25h\033[?1049l")
+	restoreTerminal(os.Stdin, saved)
+
+	t.mu.Lock()
+	stats := make([]hopStats, len(t.hops))
+	for ttl := range t.hops {
+		stats[ttl] = t.hops[ttl].stats
+	}
+	lastTTL, rounds := t.lastTTL, t.rounds
+	t.mu.Unlock()
+	printContinuous(destination, destAddr, stats, opts.FirstTTL, lastTTL, rounds, opts.Names, false)
+}
+
+// probe sends rounds of probes - forever with -continuous, -q rounds
+// without - and adds each result to the table as soon as it arrives.
+func (t *tuiState) probe(prober *Prober, stop <-chan struct{}) {
+	for round := 0; t.continuous || round < t.opts.NumProbes; round++ {
+		started := time.Now()
+
+		t.mu.Lock()
+		lastTTL := t.lastTTL
+		t.mu.Unlock()
+
+		probeEach(prober, t.opts.FirstTTL, lastTTL, t.opts.MaxInflight, t.add)
+
+		t.mu.Lock()
+		t.rounds++
+		t.mu.Unlock()
+
+		// Continuous mode goes at a calm one round per second, like mtr.go
+		var wait <-chan time.Time
+		if t.continuous {
+			wait = time.After(time.Until(started.Add(ContinuousInterval)))
+		} else {
+			wait = time.After(0)
+		}
+		select {
+		case <-wait:
+		case <-stop:
+			return
+		}
+	}
+
+	t.mu.Lock()
+	t.done = true
+	t.mu.Unlock()
+}
+
+// add records one probe's result.
+func (t *tuiState) add(r probeResult) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	hop := &t.hops[r.TTL]
+	hop.stats.add(r)
+
+	rtt := tuiLost
+	if r.Err == nil && r.Responder != "" {
+		rtt = r.RTT
+	}
+	hop.history = append(hop.history, rtt)
+	if len(hop.history) > tuiHistory {
+		hop.history = hop.history[len(hop.history)-tuiHistory:]
+	}
+
+	if r.Reached && r.TTL < t.lastTTL {
+		t.lastTTL = r.TTL
+	}
+}
+
+// key scrolls the table. draw keeps the scroll position within the table.
+func (t *tuiState) key(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	page := max(t.visible-1, 1)
+	switch key {
+	case "up":
+		t.scroll--
+	case "down":
+		t.scroll++
+	case "pgup":
+		t.scroll -= page
+	case "pgdn":
+		t.scroll += page
+	case "home":
+		t.scroll = 0
+	case "end":
+		t.scroll = len(t.hops) // draw brings it back to the last page
+	}
+}
+
+// draw redraws the whole screen.
+func (t *tuiState) draw() {
+	cols, rows, err := terminalSize(os.Stdout)
+	if err != nil || cols == 0 || rows == 0 {
+		cols, rows = 80, 24
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Like printContinuous, don't show a long tail of silent hops
+	last := t.opts.FirstTTL
+	for ttl := t.opts.FirstTTL; ttl <= t.lastTTL; ttl++ {
+		if t.hops[ttl].stats.Received > 0 {
+			last = ttl
+		}
+	}
+	total := last - t.opts.FirstTTL + 1
+
+	// 4 lines of title and column names above the hops, 2 below
+	t.visible = max(rows-6, 1)
+	t.scroll = max(min(t.scroll, total-t.visible), 0)
+
+	// The host column gives up some room so the sparkline gets at least
+	// 20 bars
+	hostWidth := max(min(40, cols-tuiFixedWidth), 15)
+	sparkWidth := 0
+	if t.continuous {
+		sparkWidth = cols - tuiFixedWidth - hostWidth - 2
+		if sparkWidth < 20 {
+			hostWidth = max(hostWidth-(20-sparkWidth), 15)
+			sparkWidth = max(cols-tuiFixedWidth-hostWidth-2, 0)
+		}
+	}
+
+	var screen strings.Builder
+	line := func(format string, args ...interface{}) {
+		text := fmt.Sprintf(format, args...)
+		if utf8.RuneCountInString(text) > cols {
+			text = string([]rune(text)[:cols])
+		}
+		// "\033[K" clears whatever was left on the line from last time
+		screen.WriteString(text + "\033[K\n")
+	}
+
+	// "\033[H" moves the cursor to the top-left corner
+	screen.WriteString("\033[H")
+
+	status := "q to quit"
+	if t.done {
+		status = "done - q to quit"
+	}
+	rounds := fmt.Sprintf("round %d", t.rounds)
+	if !t.continuous {
+		rounds = fmt.Sprintf("round %d of %d", min(t.rounds+1, t.opts.NumProbes), t.opts.NumProbes)
+		if t.done {
+			rounds = fmt.Sprintf("%d probes per hop", t.opts.NumProbes)
+		}
+	}
+	line("🔍 %s (%s) - %s - %s", t.destination, t.addr.IP, rounds, status)
+	line("")
+
+	sparkTitle, sparkLine := "", ""
+	if sparkWidth > 0 {
+		sparkTitle, sparkLine = "RTT history", "───────────"
+	}
+	line("Hop  %-*s Loss%%   Snt   Last    Best    Avg     Wrst    %s", hostWidth, "Host", sparkTitle)
+	line("───  %-*s ─────   ───   ────    ────    ───     ────    %s", hostWidth, "────", sparkLine)
+
+	shown := 0
+	for ttl := t.opts.FirstTTL + t.scroll; ttl <= last && shown < t.visible; ttl++ {
+		hop := &t.hops[ttl]
+		s := &hop.stats
+		line("%3d  %-*s %5.1f%% %5d  %-7s %-7s %-7s %-7s %s",
+			ttl, hostWidth, tuiHost(s, t.opts.Names, hostWidth), s.Loss(), s.Sent,
+			statRTT(s, s.Last), statRTT(s, s.Best), statRTT(s, s.Avg()), statRTT(s, s.Worst),
+			sparkline(hop.history, sparkWidth))
+		shown++
+	}
+	for ; shown < t.visible; shown++ {
+		line("")
+	}
+
+	line("")
+	screen.WriteString(fmt.Sprintf("↑↓ PgUp PgDn Home End to scroll - hops %d-%d of %d\033[K",
+		t.opts.FirstTTL+t.scroll, t.opts.FirstTTL+t.scroll+min(t.visible, total)-1, total))
+
+	// "\033[J" clears everything below, in case the terminal got smaller
+	screen.WriteString("\033[J")
+	fmt.Print(screen.String())
+}
+
+// tuiHost names whoever answered at a hop, without waiting for DNS.
+func tuiHost(s *hopStats, names *nameCache, width int) string {
+	if s.Responder == "" {
+		return "???"
+	}
+	host := s.Responder
+	if name, _ := names.Peek(s.Responder); name != "" && name != NoHostname {
+		host = name
+	}
+	if len(host) > width {
+		host = host[:width-3] + "..."
+	}
+	return host
+}
+
+// sparkline draws the last width RTTs of a history as bars, scaled so the
+// slowest one shown is a full bar.
+func sparkline(history []time.Duration, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	if len(history) > width {
+		history = history[len(history)-width:]
+	}
+
+	var slowest time.Duration
+	for _, rtt := range history {
+		slowest = max(slowest, rtt)
+	}
+
+	var b strings.Builder
+	for _, rtt := range history {
+		switch {
+		case rtt == tuiLost:
+			b.WriteRune('×')
+		case slowest == 0:
+			b.WriteRune(sparkBlocks[0])
+		default:
+			level := int(int64(rtt) * int64(len(sparkBlocks)-1) / int64(slowest))
+			b.WriteRune(sparkBlocks[level])
+		}
+	}
+	return b.String()
+}
+
+// readKeys turns key presses into the names key understands. The arrow
+// and paging keys send escape codes, like "\033[A" for ↑.
+func readKeys(f *os.File, keys chan<- string) {
+	buf := make([]byte, 16)
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			return
+		}
+		in := string(buf[:n])
+
+		var key string
+		switch {
+		case in == "q" || in == "Q":
+			key = "quit"
+		case in == "\033[A" || in == "k":
+			key = "up"
+		case in == "\033[B" || in == "j":
+			key = "down"
+		case in == "\033[5~" || in == "b":
+			key = "pgup"
+		case in == "\033[6~" || in == " ":
+			key = "pgdn"
+		case in == "\033[H" || in == "\033[1~" || in == "g":
+			key = "home"
+		case in == "\033[F" || in == "\033[4~" || in == "G":
+			key = "end"
+		default:
+			continue
+		}
+		keys <- key
+	}
+}